@@ -7,28 +7,103 @@
 //	rdma-cdi generate --pci 0000:86:00.0
 //	rdma-cdi discover --all
 //	rdma-cdi doctor --pci 0000:86:00.0
+//	rdma-cdi collect --output bundle.tar.gz
 //	rdma-cdi cleanup --prefix rdma
+//	rdma-cdi restore --from latest
+//	rdma-cdi migrate --dry-run
+//	rdma-cdi guard --all-dirs
+//	rdma-cdi udev install --dry-run
+//	rdma-cdi systemd install --dry-run
+//	rdma-cdi bind --pci 0000:86:00.1 --driver vfio-pci
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/sys/unix"
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
 
+	"github.com/Nativu5/rdma-cdi/pkg/bench"
+	"github.com/Nativu5/rdma-cdi/pkg/bind"
+	"github.com/Nativu5/rdma-cdi/pkg/bundle"
 	"github.com/Nativu5/rdma-cdi/pkg/cdi"
+	"github.com/Nativu5/rdma-cdi/pkg/deploy"
 	"github.com/Nativu5/rdma-cdi/pkg/discover"
 	"github.com/Nativu5/rdma-cdi/pkg/doctor"
+	"github.com/Nativu5/rdma-cdi/pkg/export"
+	"github.com/Nativu5/rdma-cdi/pkg/kubeapi"
+	"github.com/Nativu5/rdma-cdi/pkg/kubeevents"
+	"github.com/Nativu5/rdma-cdi/pkg/list"
+	"github.com/Nativu5/rdma-cdi/pkg/logging"
+	"github.com/Nativu5/rdma-cdi/pkg/mapping"
+	"github.com/Nativu5/rdma-cdi/pkg/pciids"
+	"github.com/Nativu5/rdma-cdi/pkg/plugin"
+	"github.com/Nativu5/rdma-cdi/pkg/preflight"
 	"github.com/Nativu5/rdma-cdi/pkg/rdma"
+	"github.com/Nativu5/rdma-cdi/pkg/rdmacdi"
+	"github.com/Nativu5/rdma-cdi/pkg/selector"
+	"github.com/Nativu5/rdma-cdi/pkg/singleton"
+	"github.com/Nativu5/rdma-cdi/pkg/stats"
+	"github.com/Nativu5/rdma-cdi/pkg/systemd"
 	"github.com/Nativu5/rdma-cdi/pkg/types"
+	"github.com/Nativu5/rdma-cdi/pkg/udev"
 	"github.com/Nativu5/rdma-cdi/pkg/utils"
+
+	// Compiled-in vendor plugins, enabled with --plugins
+	_ "github.com/Nativu5/rdma-cdi/pkg/plugin/nvidiagds"
+)
+
+// Exit codes shared by all subcommands, so automation can tell a node
+// without RDMA hardware from a bad invocation or a crash. See exitCodeFor.
+const (
+	exitOK             = 0
+	exitRuntimeError   = 1 // anything not covered below, or a *batchError where every item failed
+	exitNoDevices      = 2 // rdma.ErrNoRdmaDevices: the host has no RDMA hardware
+	exitUsage          = 3 // *usageError, rdma.ErrDeviceNotFound, cdi.ErrUnresolvedDevice
+	exitUnhealthy      = 4 // errChecksFailed, rdma.ErrMissingRequiredDevice
+	exitPartialFailure = 5 // *batchError, errPartialFailure: some items failed, others succeeded
 )
 
-// Exit codes following CLI conventions.
+// envRequireDevices sets the --require-devices policy when the flag is not
+// given, so it can be set once per host (e.g. in a systemd unit or DaemonSet).
+const envRequireDevices = "RDMA_CDI_REQUIRE_DEVICES"
+
+// envExpectedNetnsMode sets the doctor --expected-netns-mode when the flag
+// is not given, like envRequireDevices.
+const envExpectedNetnsMode = "RDMA_CDI_EXPECTED_NETNS_MODE"
+
+// envNoColor disables colored output when set to any non-empty value,
+// following https://no-color.org.
+const envNoColor = "NO_COLOR"
+
+// envHostRoot sets --host-root when the flag is not given, e.g. in a
+// DaemonSet that mounts the host filesystem at /host.
+const envHostRoot = "RDMA_CDI_HOST_ROOT"
+
+// hostRootAuto makes --host-root use autoHostRoot when the host's sysfs
+// is mounted there.
 const (
-	exitOK           = 0
-	exitRuntimeError = 1
+	hostRootAuto = "auto"
+	autoHostRoot = "/host"
 )
 
 // Build-time variables injected via ldflags.
@@ -39,15 +114,28 @@ var (
 )
 
 func main() {
-	if err := rootCmd().Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(exitRuntimeError)
+	root := rootCmd()
+	if cmd, err := execute(root); err != nil {
+		errorFormat, _ := root.PersistentFlags().GetString("error-format")
+		printError(os.Stderr, errorFormat, cmd, err)
+		os.Exit(exitCodeFor(err))
 	}
 }
 
 // rootCmd builds the top-level cobra command tree.
 func rootCmd() *cobra.Command {
-	var logLevel string
+	var (
+		logLevel  string
+		quiet     bool
+		noColor   bool
+		backend   string
+		required  []string
+		stateFile string
+		errFormat string
+		hostRoot  string
+		sysfsRoot string
+		devRoot   string
+	)
 
 	root := &cobra.Command{
 		Use:   "rdma-cdi",
@@ -59,20 +147,116 @@ func rootCmd() *cobra.Command {
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			lvl, err := log.ParseLevel(logLevel)
 			if err != nil {
-				return fmt.Errorf("invalid log level %q: %w", logLevel, err)
+				return usageErrorf("invalid log level %q: %w", logLevel, err)
+			}
+			if quiet {
+				lvl = log.WarnLevel
 			}
 			log.SetLevel(lvl)
+			if noColor || os.Getenv(envNoColor) != "" {
+				log.SetFormatter(&log.TextFormatter{DisableColors: true})
+			}
+			if err := addLogSinks(cmd); err != nil {
+				return err
+			}
+
+			if _, err := rdma.ParseBackend(backend); err != nil {
+				return &usageError{err}
+			}
+			if timeout, _ := cmd.Flags().GetDuration("device-timeout"); timeout <= 0 {
+				return usageErrorf("--device-timeout must be positive, got %s", timeout)
+			}
+			lockTimeout, _ := cmd.Flags().GetDuration("lock-timeout")
+			if lockTimeout < 0 {
+				return usageErrorf("--lock-timeout must not be negative, got %s", lockTimeout)
+			}
+			cdi.LockTimeout = lockTimeout
+			if f, _ := cmd.Flags().GetString("device-id-format"); f != "" {
+				if _, err := types.ParseDeviceIDFormat(f); err != nil {
+					return usageErrorf("invalid --device-id-format: %w", err)
+				}
+			}
+			if names, _ := cmd.Flags().GetStringSlice("plugins"); len(names) > 0 {
+				if _, err := plugin.Lookup(names...); err != nil {
+					return usageErrorf("invalid --plugins: %w", err)
+				}
+			}
+			if errFormat != errorFormatText && errFormat != errorFormatJSON {
+				return usageErrorf("unsupported error format %q: use %s or %s", errFormat, errorFormatText, errorFormatJSON)
+			}
+			policy, _ := requiredDevicesPolicy(cmd)
+			for _, t := range policy {
+				if err := rdma.ValidateDeviceType(t); err != nil {
+					return usageErrorf("invalid --require-devices: %w", err)
+				}
+			}
+			// cobra checks these only after the pre-run hooks, without
+			// going through the flag error func
+			if err := cmd.ValidateRequiredFlags(); err != nil {
+				return &usageError{err}
+			}
+			if err := cmd.ValidateFlagGroups(); err != nil {
+				return &usageError{err}
+			}
 			return nil
 		},
 	}
 
 	root.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Log level (trace, debug, info, warn, error, fatal, panic)")
+	root.PersistentFlags().BoolVar(&quiet, "quiet", false, "Only log warnings and errors, leaving stdout to the command's results")
+	root.PersistentFlags().BoolVar(&noColor, "no-color", false, "Disable colored output (env "+envNoColor+")")
+	root.PersistentFlags().String("log-file", "", "Also append logs to this file, e.g. /var/log/rdma-cdi.log (for udev rules and init containers)")
+	root.PersistentFlags().Int("log-max-size", logging.DefaultMaxSize>>20, "Rotate --log-file once it would grow past this many MiB (0: never)")
+	root.PersistentFlags().Int("log-max-backups", logging.DefaultMaxBackups, "Rotated --log-file copies to keep (<file>.1 is the newest)")
+	root.PersistentFlags().Bool("log-journald", false, "Also send logs to the systemd journal, with their fields as journal fields")
+	root.MarkFlagsMutuallyExclusive("quiet", "log-level")
+	root.PersistentFlags().StringVar(&backend, "backend", string(rdma.BackendSysfs), "Discovery backend (sysfs|netlink)")
+	root.PersistentFlags().StringSliceVar(&required, "require-devices", nil,
+		"RDMA device types a device must expose (uverbs, umad, issm, rdma_cm; default: per-driver profile; env "+envRequireDevices+")")
+	root.PersistentFlags().StringVar(&stateFile, "state-file", cdi.DefaultStateFile, "File recording the spec files written by generate")
+	root.PersistentFlags().StringVar(&errFormat, "error-format", errorFormatText,
+		"How a failure is reported on stderr (text|json); json prints an object with code, message, device and hint")
+	root.PersistentFlags().StringVar(&hostRoot, "host-root", "",
+		"Where the host filesystem is mounted when running in a container (e.g. /host, or \"auto\" to use /host if present); "+
+			"sysfs and /dev are read under it (env "+envHostRoot+")")
+	root.PersistentFlags().StringVar(&sysfsRoot, "sysfs-root", rdma.DefaultSysfsRoot, "Where the host sysfs is mounted (overrides --host-root)")
+	root.PersistentFlags().StringVar(&devRoot, "dev-root", rdma.DefaultDevRoot, "Where the host /dev is mounted (overrides --host-root)")
+	root.PersistentFlags().Duration("device-timeout", rdma.DefaultDeviceTimeout,
+		"How long discovery of all devices waits for each one before reporting it as failed")
+	root.PersistentFlags().Duration("lock-timeout", cdi.DefaultLockTimeout,
+		"How long a spec or state file write waits for another rdma-cdi process writing it (0: fail at once)")
+	root.PersistentFlags().Bool("no-lag-collapse", false,
+		"Report the physical functions of a RoCE LAG separately instead of as one device for the bond")
+	root.PersistentFlags().StringSlice("plugins", nil,
+		"Vendor plugins adding discovery attributes and spec edits (available: "+strings.Join(plugin.Names(), ", ")+")")
+	root.PersistentFlags().String("device-id-format", string(types.DeviceIDAuto),
+		"How tables and doctor results name devices (auto = \"mlx5_0 (0000:17:00.0)\", pci, ibdev, ifname)")
+
+	root.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return &usageError{err}
+	})
 
 	root.AddCommand(
 		newGenerateCmd(),
+		newPlanCmd(),
 		newDiscoverCmd(),
 		newDoctorCmd(),
+		newStatsCmd(),
+		newBenchCmd(),
+		newCollectCmd(),
 		newCleanupCmd(),
+		newRestoreCmd(),
+		newListCmd(),
+		newExplainCmd(),
+		newMigrateCmd(),
+		newGuardCmd(),
+		newUdevCmd(),
+		newSystemdCmd(),
+		newDeployCmd(),
+		newExportCmd(),
+		newMappingCmd(),
+		newBindCmd(),
+		newNetnsCmd(),
 		newVersionCmd(),
 	)
 
@@ -84,301 +268,3311 @@ func rootCmd() *cobra.Command {
 // ──────────────────────────────────────────────
 
 func newGenerateCmd() *cobra.Command {
+	return generateCommand(false)
+}
+
+// generateCommand builds generate or, with plan, the read-only plan command
+// sharing its spec flags.
+func generateCommand(plan bool) *cobra.Command {
 	var (
-		all       bool
-		pci       string
-		ifname    string
-		prefix    string
-		name      string
-		outputDir string
-		format    string
+		all          bool
+		selection    deviceSelection
+		prefix       string
+		name         string
+		outputDir    string
+		format       string
+		devNums      bool
+		nodeIdentity bool
+		perPort      bool
+		perms        string
+		ctrDir       string
+		devTypes     []string
+		include      []string
+		jobs         int
+		summary      string
+		failFast     bool
+		groupBy      string
+		aliases      bool
+		sysfs        bool
+		annotate     bool
+		rdmaCM       string
+		fileMode     string
+		fileOwner    string
+		timestamp    bool
+		force        bool
+		specDirs     []string
+		dryRun       bool
+		planOutput   string
 	)
 
 	cmd := &cobra.Command{
 		Use:   "generate",
 		Short: "Generate CDI spec files for RDMA devices",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			discoverer := rdma.NewDiscoverer()
+			if plan {
+				if planOutput != "table" && planOutput != "json" {
+					return usageErrorf("unsupported output format %q: use table or json", planOutput)
+				}
+				dryRun = true
+			}
+			if summary != "" && summary != "json" {
+				return usageErrorf("unsupported summary format %q: use json", summary)
+			}
+			if rdmaCM != rdmaCMPerDevice && rdmaCM != rdmaCMShared {
+				return usageErrorf("unsupported rdma_cm mode %q: use %s or %s", rdmaCM, rdmaCMPerDevice, rdmaCMShared)
+			}
+			if groupBy != "" {
+				if _, ok := groupKeys[groupBy]; !ok {
+					return usageErrorf("unsupported group key %q: use link-type, numa or driver", groupBy)
+				}
+				if selection.single() != "" {
+					return usageErrorf("--group-by requires --all, --selector or several devices")
+				}
+			}
+			if err := rdma.ValidatePermissions(perms); err != nil {
+				return &usageError{err}
+			}
+			devSpecOpts := []rdma.SpecOption{rdma.WithPermissions(perms)}
+			if ctrDir != "" {
+				devSpecOpts = append(devSpecOpts, rdma.WithContainerDir(ctrDir))
+			}
+			if len(devTypes) > 0 {
+				for _, t := range devTypes {
+					if err := rdma.ValidateDeviceType(t); err != nil {
+						return &usageError{err}
+					}
+				}
+				devSpecOpts = append(devSpecOpts, rdma.WithDeviceTypes(devTypes...))
+			}
+			for _, t := range include {
+				if err := rdma.ValidateOptionalDeviceType(t); err != nil {
+					return &usageError{err}
+				}
+			}
+			discoverer := newDiscoverer(cmd, rdma.WithSpecOptions(devSpecOpts...), rdma.WithIncludeDevices(include...))
 
-			switch {
-			case all:
-				// Batch mode: generate a spec for every discovered device
-				devices, err := discoverer.DiscoverAll()
+			var specOpts []cdi.Option
+			if devNums {
+				specOpts = append(specOpts, cdi.WithDeviceNumbers())
+			}
+			if nodeIdentity {
+				specOpts = append(specOpts, cdi.WithNodeIdentity())
+			}
+			if perPort {
+				specOpts = append(specOpts, cdi.WithPerPort())
+			}
+			if aliases {
+				specOpts = append(specOpts, cdi.WithAliases())
+			}
+			if sysfs {
+				specOpts = append(specOpts, cdi.WithSysfsMounts())
+			}
+			if annotate {
+				specOpts = append(specOpts, cdi.WithDeviceAnnotations())
+			}
+			if timestamp {
+				specOpts = append(specOpts, cdi.WithTimestamp())
+			}
+			if plugins := enabledPlugins(cmd); len(plugins) > 0 {
+				specOpts = append(specOpts, cdi.WithPlugins(plugins...))
+			}
+			if fileMode != "" {
+				mode, err := utils.ParseFileMode(fileMode)
 				if err != nil {
-					return fmt.Errorf("device discovery failed: %w", err)
+					return &usageError{err}
 				}
-				if len(devices) == 0 {
-					fmt.Fprintln(cmd.OutOrStdout(), "No RDMA devices found.")
-					return nil
+				specOpts = append(specOpts, cdi.WithFileMode(mode))
+			}
+			if fileOwner != "" {
+				uid, gid, err := utils.ParseOwner(fileOwner)
+				if err != nil {
+					return &usageError{err}
 				}
+				specOpts = append(specOpts, cdi.WithOwner(uid, gid))
+			}
 
-				var errCount int
-				for _, dev := range devices {
-					autoName := deriveDefaultName(dev.PciAddress, "")
-					if err := cdi.CreateCDISpec(prefix, autoName, []types.RdmaDevice{*dev}, outputDir, format); err != nil {
-						log.Errorf("failed to generate spec for %s: %v", dev.PciAddress, err)
-						errCount++
-						continue
-					}
-					fmt.Fprintf(cmd.OutOrStdout(), "CDI spec written to %s/%s\n",
-						outputDir, cdi.SpecFileName(prefix, autoName, format))
+			if !dryRun && !plan {
+				// Previews show the directory asked for, not a fallback
+				outputDir = rootlessOutputDir(cmd, outputDir)
+			}
+			w := specWriter{prefix: prefix, outputDir: outputDir, format: format, opts: specOpts, dryRun: dryRun,
+				searchDirs: specSearchDirs(specDirs, outputDir)}
+
+			single := selection.single()
+			if plan {
+				single = "" // plan covers the whole selection, like --all
+			}
+			if name != "" && single == "" {
+				return usageErrorf("--name requires a single --pci or --ifname")
+			}
+			if !dryRun {
+				sysfsRoot, _ := hostRoots(cmd)
+				if err := preflight.Check(
+					preflight.Writable(outputDir, "to write CDI specs"),
+					preflight.Readable(sysfsRoot, "to discover RDMA devices"),
+				); err != nil {
+					return err
 				}
-				if errCount > 0 {
-					return fmt.Errorf("%d device(s) failed to generate", errCount)
+			}
+
+			switch {
+			case single == "":
+				// Batch mode: generate a spec for every selected device
+				devices, err := selection.discover(cmd, discoverer)
+				if plan && errors.Is(err, rdma.ErrNoRdmaDevices) {
+					// Without devices, plan still reports the specs to delete
+					devices, err = nil, nil
 				}
-				return nil
+				if err != nil {
+					return err
+				}
+				// Devices whose discovery failed are reported, not generated,
+				// so they cannot fail the rdma_cm spec or a group with them
+				devices, failed := splitFailedDevices(devices)
+
+				var cm *generateTarget
+				if rdmaCM == rdmaCMShared {
+					cm = splitRdmaCM(devices)
+				}
+				targets := perDeviceTargets(devices)
+				if groupBy != "" {
+					targets = groupTargets(devices, groupKeys[groupBy])
+					w.opts = append(w.opts, cdi.WithAllDevice())
+				} else {
+					keepSpecNames(cmd, w, targets)
+				}
+				if cm != nil {
+					targets = append([]generateTarget{*cm}, targets...)
+				}
+				if err := checkTargetNames(prefix, format, targets); err != nil {
+					return err
+				}
+				if err := checkForeignKinds(w.searchDirs, prefix, targets, force); err != nil {
+					return err
+				}
+				if err := checkDeviceCollisions(w, targets, force); err != nil {
+					return err
+				}
+				p := newProgress(cmd, "generate")
+				results := generateAll(targets, jobs, failFast, func(t generateTarget) generateResult {
+					r := w.write(t)
+					p.step(len(targets), r.label())
+					return r
+				})
+				p.finish()
+				results = append(results, failed...)
+				if plan {
+					return reportPlan(cmd, planOutput, prefix, outputDir, results)
+				}
+				if !dryRun {
+					recordGenerated(cmd, w, results)
+				}
+				if err := reportGenerate(cmd.OutOrStdout(), summary, results); err != nil {
+					return err
+				}
+				return newBatchError(results)
 
 			default:
 				// Single-device mode
+				if len(selection.pci) == 1 {
+					normalized, err := utils.NormalizePCIAddress(selection.pci[0])
+					if err != nil {
+						return &usageError{err}
+					}
+					selection.pci[0] = normalized
+				}
 				if name == "" {
-					name = deriveDefaultName(pci, ifname)
+					name = deriveDefaultName(strings.Join(selection.pci, ""), strings.Join(selection.ifname, ""))
 				}
-
-				var dev *types.RdmaDevice
-				var err error
-				if pci != "" {
-					dev, err = discoverer.DiscoverByPCI(pci)
-				} else {
-					dev, err = discoverer.DiscoverByIfName(ifname)
+				if err := utils.ValidateCDIName(name); err != nil {
+					return &usageError{err}
 				}
+
+				devices, err := selection.discover(cmd, discoverer)
 				if err != nil {
-					return fmt.Errorf("device discovery failed: %w", err)
+					return err
 				}
 
-				if err := cdi.CreateCDISpec(prefix, name, []types.RdmaDevice{*dev}, outputDir, format); err != nil {
-					return fmt.Errorf("CDI spec generation failed: %w", err)
+				targets := []generateTarget{{name: name, devices: devices}}
+				if !cmd.Flags().Changed("name") {
+					keepSpecNames(cmd, w, targets)
+				}
+				if rdmaCM == rdmaCMShared {
+					if cm := splitRdmaCM(targets[0].devices); cm != nil {
+						targets = append([]generateTarget{*cm}, targets...)
+					}
+				}
+				if err := checkTargetNames(prefix, format, targets); err != nil {
+					return err
+				}
+				if err := checkForeignKinds(w.searchDirs, prefix, targets, force); err != nil {
+					return err
+				}
+				if err := checkDeviceCollisions(w, targets, force); err != nil {
+					return err
+				}
+				results := generateAll(targets, 1, true, w.write)
+				if !dryRun {
+					recordGenerated(cmd, w, results)
 				}
 
-				fmt.Fprintf(cmd.OutOrStdout(), "CDI spec written to %s/%s\n",
-					outputDir, cdi.SpecFileName(prefix, name, format))
+				// The returned error already describes a failure; only the
+				// JSON summary repeats it
+				reported := results
+				if summary == "" {
+					reported = nil
+					for _, r := range results {
+						if r.Success {
+							reported = append(reported, r)
+						}
+					}
+				}
+				if err := reportGenerate(cmd.OutOrStdout(), summary, reported); err != nil {
+					return err
+				}
+				for _, r := range results {
+					if r.err != nil {
+						return withDevice(r.label(), fmt.Errorf("CDI spec generation failed: %w", r.err))
+					}
+				}
 				return nil
 			}
 		},
 	}
 
-	cmd.Flags().BoolVar(&all, "all", false, "Generate specs for all discovered RDMA devices")
-	cmd.Flags().StringVar(&pci, "pci", "", "PCI BDF address (e.g. 0000:86:00.0)")
-	cmd.Flags().StringVar(&ifname, "ifname", "", "Network interface name (e.g. ib0)")
+	if !plan {
+		cmd.Flags().BoolVar(&all, "all", false, "Generate specs for all discovered RDMA devices")
+	}
+	selection.addFlags(cmd)
 	cmd.Flags().StringVar(&prefix, "prefix", cdi.DefaultPrefix, "CDI resource prefix")
-	cmd.Flags().StringVar(&name, "name", "", "CDI resource name (auto-derived if omitted; incompatible with --all)")
+	if !plan {
+		cmd.Flags().StringVar(&name, "name", "", "CDI resource name (auto-derived if omitted; only with a single --pci or --ifname)")
+	}
 	cmd.Flags().StringVar(&outputDir, "output-dir", cdi.DefaultOutputDir, "Output directory for CDI spec files")
 	cmd.Flags().StringVar(&format, "format", "yaml", "Output format (json|yaml)")
+	cmd.Flags().BoolVar(&timestamp, "timestamp", false, "Record the generation time in each spec (off by default so regenerated specs diff cleanly)")
+	cmd.Flags().StringVar(&fileMode, "file-mode", "", "Permission bits for spec files, e.g. 0644 or 0600 (output directory gets matching search bits)")
+	cmd.Flags().StringVar(&fileOwner, "file-owner", "", "Owner of spec files and the output directory, as user[:group]")
+	cmd.Flags().BoolVar(&devNums, "device-numbers", false, "Pin device node type, major:minor and owner in the spec")
+	cmd.Flags().BoolVar(&nodeIdentity, "preserve-node-identity", false, "Like --device-numbers, but fail unless every device node's type, major:minor and owner are known")
+	cmd.Flags().BoolVar(&perPort, "per-port", false, "Give each port of a multi-port device its own CDI device (<pci>-p<port>) instead of one for the function")
+	cmd.Flags().BoolVar(&aliases, "aliases", false, "Also name each device by its interface and RDMA device (e.g. rdma/net=ib0, rdma/net=mlx5_0)")
+	cmd.Flags().BoolVar(&sysfs, "sysfs-mounts", false, "Bind-mount the device's /sys/class/infiniband entry and /sys/class/infiniband_verbs read-only")
+	cmd.Flags().BoolVar(&annotate, "device-annotations", false, "Annotate each device with its link type, NUMA node, rate, vendor and node GUID (rdma.cdi.x-k8s.io/...) for schedulers")
+	cmd.Flags().StringVar(&rdmaCM, "rdma-cm", rdmaCMPerDevice, "Where to declare the node-global rdma_cm device: per-device (in every spec) or shared (once, in a <prefix>/cm spec)")
+	cmd.Flags().StringVar(&perms, "permissions", rdma.DefaultPermissions, "Cgroup permissions for each device node (combination of r, w, m)")
+	cmd.Flags().StringVar(&ctrDir, "container-dir", "", "Directory to place device nodes under inside the container (default: same as host)")
+	cmd.Flags().StringSliceVar(&devTypes, "device-types", nil, "Only expose these device types (uverbs, umad, issm, ucm, rdma_cm; default: all)")
+	cmd.Flags().StringSliceVar(&include, "include-devices", rdma.OptionalDeviceTypes, "Optional device types to expose (issm, ucm); empty for neither")
+	cmd.Flags().IntVar(&jobs, "jobs", runtime.NumCPU(), "Number of specs to generate concurrently with --all")
+	cmd.Flags().StringVar(&groupBy, "group-by", "", "With --all, write one spec per group instead of per device (link-type|numa|driver)")
+	cmd.Flags().BoolVar(&force, "force", false, "Write specs even if another spec already defines the same CDI kind or device")
+	cmd.Flags().StringSliceVar(&specDirs, "spec-dirs", cdi.StandardSpecDirs(), "CDI spec directories runtimes read, lowest priority first, checked for kinds and devices the specs would collide with")
+
+	if plan {
+		cmd.Use = "plan"
+		cmd.Short = "Show what generate --all and cleanup --orphans would change, without changing anything"
+		cmd.Long = `Discover the devices, render their specs with the given generate flags and
+compare them with the spec directory, then list each spec file a refresh
+(generate --all followed by cleanup --orphans) would create, update or delete,
+with its content or diff. Nothing is written, not even the state file, so plan
+is safe to run before touching /etc/cdi, e.g. on an air-gapped image. Exits
+non-zero when a device's spec could not be rendered.`
+		cmd.Flags().StringVar(&planOutput, "output", "table", "Output format (table|json)")
+		return cmd
+	}
+
+	cmd.Flags().StringVar(&summary, "summary", "", "Print a machine-readable summary instead of progress lines (json)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print each spec, or its diff against the existing file, instead of writing it")
+	cmd.Flags().BoolVar(&failFast, "fail-fast", false, "With --all, stop starting new devices after the first failure (default: continue on error)")
 
-	// --all, --pci, --ifname are mutually exclusive; at least one required
+	// --all excludes naming devices; a selection is required, --selector
+	// alone filtering every device
 	cmd.MarkFlagsMutuallyExclusive("all", "pci")
 	cmd.MarkFlagsMutuallyExclusive("all", "ifname")
-	cmd.MarkFlagsMutuallyExclusive("pci", "ifname")
-	cmd.MarkFlagsOneRequired("all", "pci", "ifname")
+	cmd.MarkFlagsOneRequired("all", "pci", "ifname", "selector")
 	// --name is only meaningful for single-device mode
 	cmd.MarkFlagsMutuallyExclusive("all", "name")
 
 	return cmd
 }
 
-// ──────────────────────────────────────────────
-//  discover
-// ──────────────────────────────────────────────
-
-func newDiscoverCmd() *cobra.Command {
-	var (
-		all    bool
-		pci    string
-		ifname string
-		output string
-	)
-
-	cmd := &cobra.Command{
-		Use:   "discover",
-		Short: "Discover RDMA devices and their character device mappings",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// If a target is specified, --all is implicitly false
-			if pci != "" || ifname != "" {
-				if all {
-					log.Warn("--all ignored because --pci or --ifname was specified")
-				}
-				all = false
-			}
-
-			discoverer := rdma.NewDiscoverer()
-			var devices []*types.RdmaDevice
+// rdma_cm handling modes for generate --rdma-cm.
+const (
+	rdmaCMPerDevice = "per-device"
+	rdmaCMShared    = "shared"
+)
 
-			switch {
-			case pci != "":
-				dev, err := discoverer.DiscoverByPCI(pci)
-				if err != nil {
-					return fmt.Errorf("discovery failed: %w", err)
-				}
-				devices = []*types.RdmaDevice{dev}
-			case ifname != "":
-				dev, err := discoverer.DiscoverByIfName(ifname)
-				if err != nil {
-					return fmt.Errorf("discovery failed: %w", err)
-				}
-				devices = []*types.RdmaDevice{dev}
-			default: // --all
-				var err error
-				devices, err = discoverer.DiscoverAll()
-				if err != nil {
-					return fmt.Errorf("discovery failed: %w", err)
-				}
+// splitRdmaCM removes the rdma_cm node from every device and returns a
+// target for the dedicated rdma_cm spec, or nil if no device had one.
+func splitRdmaCM(devices []*types.RdmaDevice) *generateTarget {
+	var cmSpec *types.DeviceSpec
+	for _, dev := range devices {
+		kept := make([]types.DeviceSpec, 0, len(dev.DeviceSpecs))
+		for _, spec := range dev.DeviceSpecs {
+			if rdma.DeviceType(spec.HostPath) != rdma.DeviceTypeRdmaCM {
+				kept = append(kept, spec)
+				continue
 			}
-
-			switch output {
-			case "json":
-				return discover.PrintJSON(cmd.OutOrStdout(), devices)
-			default:
-				discover.PrintTable(cmd.OutOrStdout(), devices)
+			if cmSpec == nil {
+				cmSpec = &spec
 			}
-			return nil
-		},
+		}
+		dev.DeviceSpecs = kept
 	}
+	if cmSpec == nil {
+		return nil
+	}
+	return &generateTarget{
+		name: cdi.RdmaCMResourceName,
+		devices: []*types.RdmaDevice{{
+			PciAddress:  cdi.RdmaCMDeviceName,
+			DeviceSpecs: []types.DeviceSpec{*cmSpec},
+		}},
+	}
+}
 
-	cmd.Flags().BoolVar(&all, "all", true, "Discover all RDMA devices on the host")
-	cmd.Flags().StringVar(&pci, "pci", "", "PCI BDF address")
-	cmd.Flags().StringVar(&ifname, "ifname", "", "Network interface name")
-	cmd.Flags().StringVar(&output, "output", "table", "Output format (table|json)")
+// specWriter writes one CDI spec per device with shared settings. With
+// dryRun it only renders them, see preview.
+type specWriter struct {
+	prefix    string
+	outputDir string
+	format    string
+	opts      []cdi.Option
+	dryRun    bool
+	// searchDirs are the spec directories runtimes read, lowest priority
+	// first, including outputDir.
+	searchDirs []string
+}
 
-	cmd.MarkFlagsMutuallyExclusive("pci", "ifname")
+// generateTarget is one spec to write: a single device, or with --group-by
+// every device sharing a group key.
+type generateTarget struct {
+	name    string
+	devices []*types.RdmaDevice
+}
 
-	return cmd
+// perDeviceTargets returns one target per device, named after its PCI address.
+func perDeviceTargets(devices []*types.RdmaDevice) []generateTarget {
+	targets := make([]generateTarget, 0, len(devices))
+	for _, dev := range devices {
+		targets = append(targets, generateTarget{
+			name:    deriveDefaultName(dev.PciAddress, ""),
+			devices: []*types.RdmaDevice{dev},
+		})
+	}
+	return targets
 }
 
-// ──────────────────────────────────────────────
-//  doctor
-// ──────────────────────────────────────────────
+// groupKeys maps --group-by values to the device attribute they group on.
+var groupKeys = map[string]func(*types.RdmaDevice) string{
+	"link-type": func(dev *types.RdmaDevice) string { return orUnknown(dev.LinkType) },
+	"driver":    func(dev *types.RdmaDevice) string { return orUnknown(dev.Driver) },
+	"numa": func(dev *types.RdmaDevice) string {
+		if dev.NumaNode < 0 {
+			return "numa-unknown"
+		}
+		return fmt.Sprintf("numa%d", dev.NumaNode)
+	},
+}
 
-func newDoctorCmd() *cobra.Command {
-	var (
-		all      bool
-		pci      string
-		ifname   string
-		strict   bool
-		showPass bool
-		output   string
-	)
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
 
-	cmd := &cobra.Command{
-		Use:   "doctor",
-		Short: "Run environment diagnostics for RDMA device readiness",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			if pci != "" || ifname != "" {
-				if all {
-					log.Warn("--all ignored because --pci or --ifname was specified")
-				}
-				all = false
-			}
+// groupTargets returns one target per distinct key, in order of first
+// appearance, so e.g. all InfiniBand HCAs land in "rdma/infiniband".
+func groupTargets(devices []*types.RdmaDevice, key func(*types.RdmaDevice) string) []generateTarget {
+	var targets []generateTarget
+	index := make(map[string]int)
+	for _, dev := range devices {
+		name := utils.SanitizeName(key(dev))
+		i, ok := index[name]
+		if !ok {
+			i = len(targets)
+			index[name] = i
+			targets = append(targets, generateTarget{name: name})
+		}
+		targets[i].devices = append(targets[i].devices, dev)
+	}
+	return targets
+}
 
-			discoverer := rdma.NewDiscoverer()
-			var devices []*types.RdmaDevice
+// checkTargetNames fails before anything is written if a target name is
+// not a valid CDI resource name, two targets would write the same spec
+// file, or a target would declare the same device name twice (e.g. two
+// netdevs on one PCI function), since one would silently replace the other.
+func checkTargetNames(prefix, format string, targets []generateTarget) error {
+	var problems []string
+	files := make(map[string]generateTarget)
+	for _, t := range targets {
+		if err := utils.ValidateCDIName(t.name); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", t.describe(), err))
+			continue
+		}
+		file := cdi.SpecFileName(prefix, t.name, format)
+		if prev, ok := files[file]; ok {
+			problems = append(problems, fmt.Sprintf("%s and %s would both be written to %s", prev.describe(), t.describe(), file))
+			continue
+		}
+		files[file] = t
 
-			switch {
-			case pci != "":
-				dev, err := discoverer.DiscoverByPCI(pci)
-				if err != nil {
-					return fmt.Errorf("device discovery failed: %w", err)
-				}
-				devices = []*types.RdmaDevice{dev}
-			case ifname != "":
-				dev, err := discoverer.DiscoverByIfName(ifname)
-				if err != nil {
-					return fmt.Errorf("device discovery failed: %w", err)
-				}
-				devices = []*types.RdmaDevice{dev}
-			default: // --all
-				var err error
-				devices, err = discoverer.DiscoverAll()
-				if err != nil {
-					return fmt.Errorf("device discovery failed: %w", err)
-				}
+		names := make(map[string]*types.RdmaDevice)
+		for _, dev := range t.devices {
+			if prev, ok := names[dev.PciAddress]; ok {
+				problems = append(problems, fmt.Sprintf("device name %q is declared twice in %s (interfaces %s and %s)",
+					dev.PciAddress, file, orUnknown(prev.IfName), orUnknown(dev.IfName)))
+				continue
 			}
+			names[dev.PciAddress] = dev
+		}
+	}
+	if len(problems) > 0 {
+		return usageErrorf("unusable CDI names, no specs written:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
 
-			// Run diagnostics on each device and merge
-			var reports []*doctor.Report
-			for _, dev := range devices {
-				reports = append(reports, doctor.DiagnoseDevice(dev))
-			}
-			merged := doctor.MergeReports(reports...)
+// checkForeignKinds refuses to write a kind that a spec from another tool
+// already defines anywhere in dirs; with force it only warns.
+func checkForeignKinds(dirs []string, prefix string, targets []generateTarget, force bool) error {
+	kinds := make([]string, 0, len(targets))
+	for _, t := range targets {
+		kinds = append(kinds, prefix+"/"+t.name)
+	}
+	conflicts, err := cdi.FindForeignKinds(dirs, kinds)
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for _, kind := range kinds {
+		for _, f := range conflicts[kind] {
+			problems = append(problems, fmt.Sprintf("kind %s is already defined by %s", kind, f))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	if force {
+		for _, p := range problems {
+			log.Warnf("%s; writing anyway (--force)", p)
+		}
+		return nil
+	}
+	return fmt.Errorf("spec(s) from another tool use the same CDI kind, no specs written (pass --force to write anyway):\n  %s",
+		strings.Join(problems, "\n  "))
+}
 
-			// Output
-			switch output {
-			case "json":
-				if err := doctor.PrintJSON(cmd.OutOrStdout(), merged, showPass); err != nil {
-					return err
+// specSearchDirs returns specDirs, the directories runtimes read specs
+// from, plus outputDir if it is not one of them.
+func specSearchDirs(specDirs []string, outputDir string) []string {
+	dirs := make([]string, 0, len(specDirs)+1)
+	for _, dir := range specDirs {
+		dirs = append(dirs, filepath.Clean(dir))
+	}
+	if !slices.Contains(dirs, filepath.Clean(outputDir)) {
+		dirs = append(dirs, filepath.Clean(outputDir))
+	}
+	return dirs
+}
+
+// checkDeviceCollisions refuses, unless force, to write a spec defining a
+// device that another spec in the search path of w also defines: runtimes
+// take a device from the highest-priority directory only, and reject one
+// defined twice in the same directory. Shadowing a spec in a
+// lower-priority directory is intended, e.g. when moving specs from
+// /etc/cdi to /var/run/cdi, and only logged.
+func checkDeviceCollisions(w specWriter, targets []generateTarget, force bool) error {
+	outPrio := slices.Index(w.searchDirs, filepath.Clean(w.outputDir))
+	var problems []string
+	for _, t := range targets {
+		path := filepath.Join(w.outputDir, cdi.SpecFileName(w.prefix, t.name, w.format))
+		names := w.deviceNames(t)
+		defs, err := cdi.FindDefinitions(w.searchDirs, names)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			for _, d := range defs[name] {
+				switch {
+				case d.Path == path:
+				case d.Priority > outPrio:
+					problems = append(problems, fmt.Sprintf("%s in %s would be shadowed by %s", name, path, d.Path))
+				case d.Priority == outPrio:
+					problems = append(problems, fmt.Sprintf("%s is already defined by %s", name, d.Path))
+				default:
+					log.Warnf("%s in %s will shadow %s", name, path, d.Path)
 				}
-			default:
-				doctor.PrintTable(cmd.OutOrStdout(), merged, showPass)
 			}
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	if force {
+		for _, p := range problems {
+			log.Warnf("%s; writing anyway (--force)", p)
+		}
+		return nil
+	}
+	return fmt.Errorf("other specs in the CDI search path define the same devices, no specs written (pass --force to write anyway):\n  %s",
+		strings.Join(problems, "\n  "))
+}
 
-			// Exit code strategy
-			if merged.HasFail {
-				os.Exit(exitRuntimeError)
-			}
-			if strict && merged.HasWarn {
-				os.Exit(exitRuntimeError)
-			}
-			return nil
-		},
+// addLogSinks copies the logs to the --log-file and --log-journald
+// sinks of cmd, on top of stderr.
+func addLogSinks(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+	path, _ := flags.GetString("log-file")
+	maxSize, _ := flags.GetInt("log-max-size")
+	maxBackups, _ := flags.GetInt("log-max-backups")
+	if maxSize < 0 || maxBackups < 0 {
+		return usageErrorf("--log-max-size and --log-max-backups must not be negative")
+	}
+	if path != "" {
+		f, err := logging.OpenRotatingFile(path, int64(maxSize)<<20, maxBackups)
+		if err != nil {
+			return err
+		}
+		log.AddHook(logging.NewFileHook(f))
 	}
+	if journald, _ := flags.GetBool("log-journald"); journald {
+		hook, err := logging.NewJournalHook("rdma-cdi", map[string]string{"rdma_cdi_command": cmd.CommandPath()})
+		if err != nil {
+			return err
+		}
+		log.AddHook(hook)
+	}
+	return nil
+}
 
-	cmd.Flags().BoolVar(&all, "all", true, "Check all RDMA devices")
-	cmd.Flags().StringVar(&pci, "pci", "", "PCI BDF address")
-	cmd.Flags().StringVar(&ifname, "ifname", "", "Network interface name")
-	cmd.Flags().BoolVar(&strict, "strict", false, "Exit non-zero on warnings")
-	cmd.Flags().BoolVar(&showPass, "show-pass", false, "Show passed checks in output")
-	cmd.Flags().StringVar(&output, "output", "table", "Output format (table|json)")
+// rootlessOutputDir returns dir, the --output-dir of cmd, unless it was
+// left at the default and the process, not being root, cannot write there;
+// then specs go to cdi.RootlessOutputDir instead, so generate works without
+// root. Root gets the preflight error instead, as no runtime looks there.
+func rootlessOutputDir(cmd *cobra.Command, dir string) string {
+	if cmd.Flags().Changed("output-dir") || os.Geteuid() == 0 || preflight.Check(preflight.Writable(dir, "")) == nil {
+		return dir
+	}
+	rootless, err := cdi.RootlessOutputDir()
+	if err != nil {
+		log.Debugf("%v", err)
+		return dir
+	}
+	log.Infof("%s is not writable, using %s", dir, rootless)
+	return rootless
+}
 
-	cmd.MarkFlagsMutuallyExclusive("pci", "ifname")
+// withRootlessDirs adds cdi.RootlessOutputDir to dirs when it exists,
+// --output-dir of cmd was not given and the process is not root, so the
+// specs generate wrote there without root are found too.
+func withRootlessDirs(cmd *cobra.Command, dirs []string) []string {
+	if cmd.Flags().Changed("output-dir") || os.Geteuid() == 0 {
+		return dirs
+	}
+	rootless, err := cdi.RootlessOutputDir()
+	if err != nil || slices.Contains(dirs, rootless) {
+		return dirs
+	}
+	if _, err := os.Stat(rootless); err != nil {
+		return dirs
+	}
+	return append(dirs, rootless)
+}
 
-	return cmd
+// specDirsOf returns the directories holding the spec files in paths, each
+// once, in first-seen order.
+func specDirsOf(paths []string) []string {
+	var dirs []string
+	for _, p := range paths {
+		if dir := filepath.Dir(p); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
 }
 
-// ──────────────────────────────────────────────
-//  cleanup
-// ──────────────────────────────────────────────
+// keepSpecNames renames each single-device target to the spec the state
+// file records for the same hardware (PCI address and node GUID) in the
+// output directory, so a spec named after an interface is updated after
+// udev renames it, with its aliases and annotations, instead of being
+// duplicated under a new name.
+func keepSpecNames(cmd *cobra.Command, w specWriter, targets []generateTarget) {
+	statePath, _ := cmd.Flags().GetString("state-file")
+	state, err := cdi.LoadState(statePath)
+	if err != nil {
+		log.Debugf("not looking up existing specs: %v", err)
+		return
+	}
+	for i, t := range targets {
+		if len(t.devices) != 1 {
+			continue
+		}
+		if _, ok := state.Lookup(filepath.Join(w.outputDir, cdi.SpecFileName(w.prefix, t.name, w.format))); ok {
+			continue // already generated under this name
+		}
+		rec, ok := state.SpecFor(w.outputDir, cdi.IdentityOf(t.devices[0]))
+		if !ok {
+			continue
+		}
+		prefix, name, found := strings.Cut(rec.Kind, "/")
+		if !found || prefix != w.prefix || name == t.name ||
+			filepath.Base(rec.Path) != cdi.SpecFileName(w.prefix, name, w.format) {
+			continue
+		}
+		log.Infof("%s is covered by %s already; updating it rather than writing %s/%s",
+			t.devices[0].PciAddress, rec.Kind, w.prefix, t.name)
+		targets[i].name = name
+	}
+}
 
-func newCleanupCmd() *cobra.Command {
-	var (
-		prefix    string
-		name      string
-		outputDir string
-		dryRun    bool
-		force     bool
-	)
+// splitFailedDevices separates the devices whose discovery failed, returned
+// as failed results, from the others.
+func splitFailedDevices(devices []*types.RdmaDevice) ([]*types.RdmaDevice, []generateResult) {
+	var ok []*types.RdmaDevice
+	var failed []generateResult
+	for _, dev := range devices {
+		if dev.Error == "" {
+			ok = append(ok, dev)
+			continue
+		}
+		err := fmt.Errorf("discovery failed: %s", dev.Error)
+		failed = append(failed, generateResult{
+			PciAddress: dev.PciAddress,
+			Name:       deriveDefaultName(dev.PciAddress, ""),
+			Error:      err.Error(),
+			err:        err,
+		})
+	}
+	return ok, failed
+}
 
-	cmd := &cobra.Command{
-		Use:   "cleanup",
-		Short: "Remove CDI spec files created by this tool",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			_ = force
+// describe identifies a target in collision messages.
+func (t generateTarget) describe() string {
+	if len(t.devices) == 1 && t.devices[0].IfName != "" {
+		return fmt.Sprintf("%s (%s)", t.devices[0].PciAddress, t.devices[0].IfName)
+	}
+	if len(t.devices) == 1 {
+		return t.devices[0].PciAddress
+	}
+	return fmt.Sprintf("group %q", t.name)
+}
 
-			removed, err := cdi.CleanupSpecs(outputDir, prefix, name, dryRun)
+// generateResult is the outcome of generating one target's spec. Device
+// metadata is filled in for single-device targets; groups list their
+// members in Devices instead.
+type generateResult struct {
+	PciAddress string   `json:"pci_address,omitempty"`
+	IfName     string   `json:"ifname,omitempty"`
+	Vendor     string   `json:"vendor,omitempty"`
+	DeviceID   string   `json:"device_id,omitempty"`
+	Driver     string   `json:"driver,omitempty"`
+	Devices    []string `json:"devices,omitempty"`
+	Name       string   `json:"name"`
+	SpecPath   string   `json:"spec_path,omitempty"`
+	Success    bool     `json:"success"`
+	Skipped    bool     `json:"skipped,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	// With --dry-run, Content is the spec a new file would hold and Diff
+	// the change to an existing one (empty when it is up to date). A new
+	// file taking over devices from a spec in a lower-priority directory
+	// of the search path names it in Shadows, and is diffed against it.
+	DryRun  bool   `json:"dry_run,omitempty"`
+	Content string `json:"content,omitempty"`
+	Diff    string `json:"diff,omitempty"`
+	Shadows string `json:"shadows,omitempty"`
+
+	err    error
+	exists bool
+	ids    []cdi.DeviceIdentity
+}
+
+// newResult returns a result describing t, without an outcome yet.
+func (t generateTarget) newResult() generateResult {
+	r := generateResult{Name: t.name}
+	for _, dev := range t.devices {
+		r.ids = append(r.ids, cdi.IdentityOf(dev))
+	}
+	if len(t.devices) == 1 {
+		dev := t.devices[0]
+		r.PciAddress = dev.PciAddress
+		r.IfName = dev.IfName
+		r.Vendor = dev.Vendor
+		r.DeviceID = dev.DeviceID
+		r.Driver = dev.Driver
+		return r
+	}
+	for _, dev := range t.devices {
+		r.Devices = append(r.Devices, dev.PciAddress)
+	}
+	return r
+}
+
+// label identifies the result in log lines and errors.
+func (r generateResult) label() string {
+	if r.PciAddress != "" {
+		return r.PciAddress
+	}
+	return r.Name
+}
+
+// write generates the spec for t.
+func (w specWriter) write(t generateTarget) generateResult {
+	if w.dryRun {
+		return w.preview(t)
+	}
+	r := t.newResult()
+	spec, err := w.build(t)
+	if err == nil {
+		r.SpecPath, err = w.store("").Write(spec)
+	}
+	if err != nil {
+		r.err = err
+		r.Error = err.Error()
+		return r
+	}
+	r.Success = true
+	return r
+}
+
+// store returns the store of the output directory, recording specs in
+// the state file at statePath.
+func (w specWriter) store(statePath string) *cdi.DirStore {
+	return &cdi.DirStore{Dir: w.outputDir, Format: w.format, StateFile: statePath, Options: w.opts}
+}
+
+// build renders the spec for t.
+func (w specWriter) build(t generateTarget) (*cdiSpecs.Spec, error) {
+	devices := make([]types.RdmaDevice, 0, len(t.devices))
+	for _, dev := range t.devices {
+		devices = append(devices, *dev)
+	}
+	return cdi.BuildSpec(w.prefix, t.name, devices, w.opts...)
+}
+
+// deviceNames returns the qualified names of the devices the spec for t
+// defines, or nil if it cannot be rendered; writing it reports why.
+func (w specWriter) deviceNames(t generateTarget) []string {
+	spec, err := w.build(t)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(spec.Devices))
+	for _, dev := range spec.Devices {
+		names = append(names, spec.Kind+"="+dev.Name)
+	}
+	return names
+}
+
+// shadowedSpec returns the spec in the highest-priority directory below
+// the output directory that defines a device of t, which runtimes use
+// until the spec for t is written, or "" if there is none.
+func (w specWriter) shadowedSpec(t generateTarget) string {
+	outPrio := slices.Index(w.searchDirs, filepath.Clean(w.outputDir))
+	names := w.deviceNames(t)
+	defs, err := cdi.FindDefinitions(w.searchDirs, names)
+	if err != nil {
+		log.Debugf("not looking for shadowed specs: %v", err)
+		return ""
+	}
+	shadowed, prio := "", -1
+	for _, name := range names {
+		for _, d := range defs[name] {
+			if d.Priority < outPrio && d.Priority > prio {
+				shadowed, prio = d.Path, d.Priority
+			}
+		}
+	}
+	return shadowed
+}
+
+// preview renders the spec for t without writing it, as the file content
+// or, if the file exists, as a diff against it. A new file is diffed
+// against the spec it shadows, if any.
+func (w specWriter) preview(t generateTarget) generateResult {
+	r := t.newResult()
+	r.DryRun = true
+	spec, err := w.build(t)
+	var data []byte
+	if err == nil {
+		data, err = cdi.MarshalSpec(spec, w.format)
+	}
+	r.SpecPath = filepath.Join(w.outputDir, cdi.SpecFileName(w.prefix, t.name, w.format))
+	var current []byte
+	if err == nil {
+		current, err = os.ReadFile(r.SpecPath)
+		r.exists = err == nil
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+	}
+	if err != nil {
+		r.err = err
+		r.Error = err.Error()
+		return r
+	}
+
+	if !r.exists {
+		r.Shadows = w.shadowedSpec(t)
+	}
+	switch {
+	case r.exists:
+		r.Diff = utils.UnifiedDiff(r.SpecPath, r.SpecPath+" (generated)", string(current), string(data))
+	case r.Shadows != "":
+		if current, err = os.ReadFile(r.Shadows); err != nil {
+			r.err = err
+			r.Error = err.Error()
+			return r
+		}
+		r.Diff = utils.UnifiedDiff(r.Shadows, r.SpecPath+" (generated)", string(current), string(data))
+	default:
+		r.Content = string(data)
+	}
+	r.Success = true
+	return r
+}
+
+// generateAll runs gen for every target with at most jobs running at once.
+// Results keep the order of targets. With failFast, targets not yet started
+// when a failure is seen are marked as skipped; jobs already running finish.
+func generateAll(targets []generateTarget, jobs int, failFast bool, gen func(generateTarget) generateResult) []generateResult {
+	if jobs < 1 {
+		jobs = 1
+	}
+	results := make([]generateResult, len(targets))
+	sem := make(chan struct{}, jobs)
+	var (
+		wg     sync.WaitGroup
+		failed atomic.Bool
+	)
+	for i, t := range targets {
+		sem <- struct{}{}
+		if failFast && failed.Load() {
+			<-sem
+			results[i] = t.newResult()
+			results[i].Skipped = true
+			results[i].Error = "skipped after an earlier failure (--fail-fast)"
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = gen(t)
+			if !results[i].Success {
+				failed.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// recordGenerated adds the specs w wrote successfully to the state file.
+// Failing to update it only costs cleanup precision, so it is not fatal.
+func recordGenerated(cmd *cobra.Command, w specWriter, results []generateResult) {
+	path, _ := cmd.Flags().GetString("state-file")
+	var specs []cdi.StoredSpec
+	for _, r := range results {
+		if !r.Success {
+			continue
+		}
+		devices := r.Devices
+		if r.PciAddress != "" {
+			devices = []string{r.PciAddress}
+		}
+		specs = append(specs, cdi.StoredSpec{Path: r.SpecPath, Kind: w.prefix + "/" + r.Name, Devices: devices, Identities: r.ids})
+	}
+	w.store(path).Record(specs...)
+}
+
+// countFailed returns the number of results that were attempted and failed.
+func countFailed(results []generateResult) int {
+	var n int
+	for _, r := range results {
+		if !r.Success && !r.Skipped {
+			n++
+		}
+	}
+	return n
+}
+
+// countSkipped returns the number of results skipped by --fail-fast.
+func countSkipped(results []generateResult) int {
+	var n int
+	for _, r := range results {
+		if r.Skipped {
+			n++
+		}
+	}
+	return n
+}
+
+// batchError aggregates the per-device failures of generate --all.
+type batchError struct {
+	failures  []generateResult
+	skipped   int
+	succeeded int
+}
+
+// newBatchError returns a *batchError for the failed results, or nil if
+// every device succeeded.
+func newBatchError(results []generateResult) error {
+	be := &batchError{skipped: countSkipped(results)}
+	for _, r := range results {
+		switch {
+		case r.Success:
+			be.succeeded++
+		case !r.Skipped:
+			be.failures = append(be.failures, r)
+		}
+	}
+	if len(be.failures) == 0 {
+		return nil
+	}
+	return be
+}
+
+func (e *batchError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d device(s) failed to generate", len(e.failures))
+	if e.skipped > 0 {
+		fmt.Fprintf(&b, ", %d skipped", e.skipped)
+	}
+	for _, r := range e.failures {
+		fmt.Fprintf(&b, "\n  %s: %s", r.label(), r.Error)
+	}
+	return b.String()
+}
+
+// Unwrap exposes the individual causes to errors.Is and errors.As.
+func (e *batchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.failures))
+	for _, r := range e.failures {
+		errs = append(errs, r.err)
+	}
+	return errs
+}
+
+// reportGenerate prints progress lines, or with format "json" a summary
+// document covering every device.
+func reportGenerate(w io.Writer, format string, results []generateResult) error {
+	if format == "json" {
+		failed, skipped := countFailed(results), countSkipped(results)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Total     int              `json:"total"`
+			Succeeded int              `json:"succeeded"`
+			Failed    int              `json:"failed"`
+			Skipped   int              `json:"skipped"`
+			Results   []generateResult `json:"results"`
+		}{len(results), len(results) - failed - skipped, failed, skipped, results})
+	}
+
+	for _, r := range results {
+		if r.Skipped {
+			log.Warnf("skipped %s: %s", r.label(), r.Error)
+			continue
+		}
+		if !r.Success {
+			log.WithField("device", r.label()).Errorf("failed to generate spec for %s: %v", r.label(), r.err)
+			continue
+		}
+		switch {
+		case !r.DryRun:
+			fmt.Fprintf(w, "CDI spec written to %s\n", r.SpecPath)
+		case r.Shadows != "":
+			fmt.Fprintf(w, "Would write CDI spec to %s, shadowing %s:\n%s", r.SpecPath, r.Shadows, r.Diff)
+		case !r.exists:
+			fmt.Fprintf(w, "Would write CDI spec to %s:\n%s", r.SpecPath, r.Content)
+		case r.Diff == "":
+			fmt.Fprintf(w, "CDI spec %s is up to date\n", r.SpecPath)
+		default:
+			fmt.Fprintf(w, "Would update CDI spec %s:\n%s", r.SpecPath, r.Diff)
+		}
+	}
+	return nil
+}
+
+// ──────────────────────────────────────────────
+//  plan
+// ──────────────────────────────────────────────
+
+func newPlanCmd() *cobra.Command {
+	return generateCommand(true)
+}
+
+// Plan actions.
+const (
+	planCreate = "create"
+	planUpdate = "update"
+	planDelete = "delete"
+	// planKeep is an orphaned spec cleanup would keep, as it was modified
+	// since it was generated.
+	planKeep = "keep"
+)
+
+// planChange is one spec file a refresh would touch.
+type planChange struct {
+	Action   string   `json:"action"`
+	SpecPath string   `json:"spec_path"`
+	Kind     string   `json:"kind,omitempty"`
+	Devices  []string `json:"devices,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+	Content  string   `json:"content,omitempty"`
+	Diff     string   `json:"diff,omitempty"`
+}
+
+// planReport is the JSON form of plan's output.
+type planReport struct {
+	Create    int              `json:"create"`
+	Update    int              `json:"update"`
+	Delete    int              `json:"delete"`
+	Unchanged int              `json:"unchanged"`
+	Failed    int              `json:"failed"`
+	Changes   []planChange     `json:"changes"`
+	Failures  []generateResult `json:"failures,omitempty"`
+}
+
+// reportPlan turns the dry-run results of generate into what a refresh
+// would do to outputDir, adding the orphaned specs cleanup --orphans would
+// delete, and prints it. It fails like generate when a spec could not be
+// rendered.
+func reportPlan(cmd *cobra.Command, output, prefix, outputDir string, results []generateResult) error {
+	report := planReport{Changes: []planChange{}}
+	for _, r := range results {
+		if !r.Success {
+			report.Failed++
+			report.Failures = append(report.Failures, r)
+			continue
+		}
+		c := planChange{SpecPath: r.SpecPath, Kind: prefix + "/" + r.Name, Devices: r.Devices}
+		if r.PciAddress != "" {
+			c.Devices = []string{r.PciAddress}
+		}
+		switch {
+		case !r.exists:
+			c.Action, c.Content, c.Diff = planCreate, r.Content, r.Diff
+			if r.Shadows != "" {
+				c.Reason = "shadows " + r.Shadows
+			}
+			report.Create++
+		case r.Diff != "":
+			c.Action, c.Diff = planUpdate, r.Diff
+			report.Update++
+		default:
+			report.Unchanged++
+			continue
+		}
+		report.Changes = append(report.Changes, c)
+	}
+
+	orphans, err := findOrphans(newDiscoverer(cmd), []string{outputDir}, prefix, "")
+	if err != nil {
+		return err
+	}
+	statePath, _ := cmd.Flags().GetString("state-file")
+	state, err := cdi.LoadState(statePath)
+	if err != nil {
+		return err
+	}
+	for _, f := range orphans {
+		c := planChange{Action: planDelete, SpecPath: f, Reason: "its devices are gone"}
+		if modified, err := state.Modified(f); modified || err != nil {
+			c.Action, c.Reason = planKeep, "its devices are gone, but it was modified since it was generated"
+		} else {
+			report.Delete++
+		}
+		report.Changes = append(report.Changes, c)
+	}
+
+	w := cmd.OutOrStdout()
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+		return newBatchError(results)
+	}
+
+	markers := map[string]string{planCreate: "+", planUpdate: "~", planDelete: "-", planKeep: "!"}
+	for _, c := range report.Changes {
+		fmt.Fprintf(w, "%s %s %s", markers[c.Action], c.Action, c.SpecPath)
+		if len(c.Devices) > 0 {
+			fmt.Fprintf(w, " (%s)", strings.Join(c.Devices, ", "))
+		}
+		if c.Reason != "" {
+			fmt.Fprintf(w, ": %s", c.Reason)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprint(w, c.Content, c.Diff)
+	}
+	for _, r := range report.Failures {
+		log.Errorf("cannot plan the spec for %s: %s", r.label(), r.Error)
+	}
+	if report.Create+report.Update+report.Delete == 0 && report.Failed == 0 {
+		fmt.Fprintf(w, "No changes: the CDI specs in %s match the hardware (%d spec(s) up to date).\n", outputDir, report.Unchanged)
+	} else {
+		fmt.Fprintf(w, "Plan: %d to create, %d to update, %d to delete, %d unchanged", report.Create, report.Update, report.Delete, report.Unchanged)
+		if report.Failed > 0 {
+			fmt.Fprintf(w, ", %d failed", report.Failed)
+		}
+		fmt.Fprintln(w, ".")
+	}
+	return newBatchError(results)
+}
+
+// ──────────────────────────────────────────────
+//  discover
+// ──────────────────────────────────────────────
+
+func newDiscoverCmd() *cobra.Command {
+	var (
+		all        bool
+		selection  deviceSelection
+		output     string
+		fromBundle string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "discover",
+		Short: "Discover RDMA devices and their character device mappings",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var snap *bundle.Snapshot
+			if fromBundle != "" {
+				var err error
+				if snap, err = useBundle(cmd, fromBundle); err != nil {
+					return err
+				}
+				defer snap.Close()
+			}
+
+			// If a target is specified, --all is implicitly false
+			if selection.named() && all && cmd.Flags().Changed("all") {
+				log.Warn("--all ignored because --pci or --ifname was specified")
+			}
+
+			opts := bundleOptions(snap)
+			if output == "wide" {
+				// Only shown in the wide table, and costly on busy hosts
+				opts = append(opts, rdma.WithUsage())
+			}
+			devices, err := selection.discover(cmd, newDiscoverer(cmd, opts...))
+			if err != nil {
+				return err
+			}
+
+			switch output {
+			case "json":
+				return discover.PrintJSON(cmd.OutOrStdout(), devices)
+			case "wide":
+				discover.PrintWideTable(cmd.OutOrStdout(), devices, deviceIDFormat(cmd))
+			default:
+				discover.PrintTable(cmd.OutOrStdout(), devices, deviceIDFormat(cmd))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&all, "all", true, "Discover all RDMA devices on the host")
+	selection.addFlags(cmd)
+	cmd.Flags().StringVar(&output, "output", "table", "Output format (table|wide|json); wide adds NUMA node, IP addresses, attached kernel ULPs and open verbs contexts")
+	cmd.Flags().StringVar(&fromBundle, "from-bundle", "", "Discover from a support bundle written by collect instead of the host")
+
+	return cmd
+}
+
+// ──────────────────────────────────────────────
+//  stats
+// ──────────────────────────────────────────────
+
+func newStatsCmd() *cobra.Command {
+	var (
+		pci         string
+		output      string
+		allCounters bool
+		watch       bool
+		interval    time.Duration
+		textfileDir string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show the port and hardware counters of RDMA devices",
+		Long: `Read the counters and hw_counters of every port of the RDMA devices from
+sysfs (class/infiniband/<dev>/ports/<port>). The table shows traffic, error,
+retransmission and CNP counters; --all-counters shows every counter. The
+port_*_data counters count 4-octet words. With --watch the counters are read
+every --interval until interrupted, with their per-second rates.
+
+--output prometheus renders the counters and device inventory gauges in the
+Prometheus text format; with --textfile-dir they are written to rdma-cdi.prom
+there on each run, for the node_exporter textfile collector (e.g. from cron).
+With --watch too, only one instance may write to a textfile directory.`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "table" && output != "json" && output != "prometheus" {
+				return usageErrorf("unsupported output format %q: use table, json or prometheus", output)
+			}
+			if textfileDir != "" && output != "prometheus" {
+				return usageErrorf("--textfile-dir requires --output prometheus")
+			}
+			if watch && interval <= 0 {
+				return usageErrorf("--interval must be positive, got %s", interval)
+			}
+
+			discoverer := newDiscoverer(cmd)
+			discover := func() ([]*types.RdmaDevice, error) {
+				if pci != "" {
+					dev, err := discoverer.DiscoverByPCI(pci)
+					if err != nil {
+						return nil, withDevice(pci, fmt.Errorf("discovery failed: %w", err))
+					}
+					return []*types.RdmaDevice{dev}, nil
+				}
+				devices, err := discoverer.DiscoverAll()
+				if err != nil {
+					return nil, fmt.Errorf("discovery failed: %w", err)
+				}
+				return devices, nil
+			}
+			devices, err := discover()
+			if err != nil {
+				return err
+			}
+
+			show := func(ports []stats.PortStats) error {
+				switch output {
+				case "json":
+					return stats.PrintJSON(cmd.OutOrStdout(), ports)
+				case "prometheus":
+					if textfileDir == "" {
+						return stats.PrintPrometheus(cmd.OutOrStdout(), devices, ports, time.Now())
+					}
+					return writeTextfile(textfileDir, stats.TextfileName, func(w io.Writer) error {
+						return stats.PrintPrometheus(w, devices, ports, time.Now())
+					})
+				}
+				stats.PrintTable(cmd.OutOrStdout(), ports, allCounters, deviceIDFormat(cmd))
+				return nil
+			}
+
+			ports, err := stats.Read(discoverer.SysfsRoot(), devices)
+			if err != nil {
+				return err
+			}
+			if !watch {
+				return show(ports)
+			}
+			if textfileDir != "" {
+				lock, err := singleton.Acquire(singleton.LockDir(), textfileDir)
+				if err != nil {
+					return err
+				}
+				defer lock.Release()
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			// SIGHUP, e.g. from systemctl reload, rediscovers the devices
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			defer signal.Stop(hup)
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			watchdog, stopping := notifyReady()
+			defer stopping()
+			last := time.Now()
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-watchdog:
+					notify(systemd.Watchdog)
+				case <-hup:
+					log.Info("Reloading: rediscovering devices")
+					found, err := discover()
+					if err != nil {
+						log.Warnf("Keeping the devices found before: %v", err)
+						continue
+					}
+					devices = found
+				case now := <-ticker.C:
+					cur, err := stats.Read(discoverer.SysfsRoot(), devices)
+					if err != nil {
+						return err
+					}
+					stats.SetRates(ports, cur, now.Sub(last))
+					ports, last = cur, now
+					if output == "table" {
+						fmt.Fprintf(cmd.OutOrStdout(), "\n%s\n", now.Format(time.RFC3339))
+					}
+					if err := show(ports); err != nil {
+						return err
+					}
+				}
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&pci, "pci", "", "PCI BDF address (default: all RDMA devices)")
+	cmd.Flags().StringVar(&output, "output", "table", "Output format (table|json|prometheus)")
+	cmd.Flags().StringVar(&textfileDir, "textfile-dir", "", "Write --output prometheus to "+stats.TextfileName+" in this node_exporter textfile directory")
+	cmd.Flags().BoolVar(&allCounters, "all-counters", false, "Show every counter in the table, not just the key ones")
+	cmd.Flags().BoolVar(&watch, "watch", false, "Print the counters and their rates every --interval until interrupted")
+	cmd.Flags().DurationVar(&interval, "interval", time.Second, "Sampling interval for --watch")
+
+	return cmd
+}
+
+// writeTextfile writes what render renders to name in a node_exporter
+// textfile collector directory, atomically, so the collector never reads
+// a partial file.
+func writeTextfile(dir, name string, render func(io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := render(&buf); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, name)
+	if err := utils.WriteFileAtomic(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("cannot write %s: %w", path, err)
+	}
+	log.Debugf("Wrote %s", path)
+	return nil
+}
+
+// ──────────────────────────────────────────────
+//  bench
+// ──────────────────────────────────────────────
+
+func newBenchCmd() *cobra.Command {
+	var (
+		pci        string
+		ifname     string
+		peer       string
+		server     bool
+		ibPort     int
+		tcpPort    int
+		gidIndex   int
+		duration   time.Duration
+		thresholds bench.Thresholds
+		output     string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Run an RDMA bandwidth and latency self-test on a device",
+		Long: `Check that RC traffic flows on a device by running an RDMA write bandwidth
+and latency test, in loopback by default. With --peer the test runs against
+"rdma-cdi bench --server" on another host. The results are reported as PASS or
+FAIL against --min-bw-gbps and --max-lat-us, exiting 4 on failure, for burn-in
+pipelines. The tests are driven through the perftest tools ib_write_bw and
+ib_write_lat, which must be installed.`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "table" && output != "json" {
+				return usageErrorf("unsupported output format %q: use table or json", output)
+			}
+			if server && peer != "" {
+				return usageErrorf("--server and --peer are mutually exclusive")
+			}
+
+			var dev *types.RdmaDevice
+			var err error
+			discoverer := newDiscoverer(cmd)
+			switch {
+			case pci != "":
+				dev, err = discoverer.DiscoverByPCI(pci)
+			case ifname != "":
+				dev, err = discoverer.DiscoverByIfName(ifname)
+			default:
+				return usageErrorf("one of --pci or --ifname is required")
+			}
+			if err != nil {
+				return withDevice(pci+ifname, fmt.Errorf("discovery failed: %w", err))
+			}
+			if dev.IbDev == "" {
+				return withDevice(dev.PciAddress, fmt.Errorf("%s has no RDMA device to test", dev.PciAddress))
+			}
+
+			opts := []bench.Option{bench.WithIbPort(ibPort), bench.WithTCPPort(tcpPort), bench.WithDuration(duration)}
+			if gidIndex >= 0 {
+				opts = append(opts, bench.WithGIDIndex(gidIndex))
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			if server {
+				log.Infof("Serving the bench tests on %s port %d", dev.IbDev, tcpPort)
+				if err := bench.Serve(ctx, dev.IbDev, opts...); err != nil {
+					return withDevice(dev.PciAddress, err)
+				}
+				return nil
+			}
+			res, err := bench.Run(ctx, dev.IbDev, peer, opts...)
+			if err != nil {
+				return withDevice(dev.PciAddress, err)
+			}
+
+			report := doctor.NewReport(bench.Evaluate(res, thresholds, dev.PciAddress)...)
+			report.Label([]*types.RdmaDevice{dev}, deviceIDFormat(cmd))
+			switch output {
+			case "json":
+				if err := doctor.PrintJSON(cmd.OutOrStdout(), report, true); err != nil {
+					return err
+				}
+			default:
+				doctor.PrintTable(cmd.OutOrStdout(), report, true)
+			}
+			if report.HasFail {
+				return withDevice(dev.PciAddress, fmt.Errorf("%w: see the report above", errChecksFailed))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&pci, "pci", "", "PCI BDF address of the device to test")
+	cmd.Flags().StringVar(&ifname, "ifname", "", "Network interface name of the device to test")
+	cmd.Flags().StringVar(&peer, "peer", "", "Test against rdma-cdi bench --server on this host[:port] instead of loopback")
+	cmd.Flags().BoolVar(&server, "server", false, "Serve one test run for a --peer client on another host")
+	cmd.Flags().IntVar(&ibPort, "ib-port", 1, "RDMA port of the device")
+	cmd.Flags().IntVar(&tcpPort, "port", bench.DefaultPort, "TCP port the test connection is set up on")
+	cmd.Flags().IntVar(&gidIndex, "gid-index", -1, "GID index to use, e.g. for RoCE (default: chosen by perftest)")
+	cmd.Flags().DurationVar(&duration, "duration", bench.DefaultDuration, "Duration of each test")
+	cmd.Flags().Float64Var(&thresholds.MinBandwidthGbps, "min-bw-gbps", 0, "Fail when the bandwidth is below this many Gb/s")
+	cmd.Flags().Float64Var(&thresholds.MaxLatencyUsec, "max-lat-us", 0, "Fail when the latency is above this many microseconds")
+	cmd.Flags().StringVar(&output, "output", "table", "Output format (table|json)")
+
+	cmd.MarkFlagsMutuallyExclusive("pci", "ifname")
+
+	return cmd
+}
+
+// ──────────────────────────────────────────────
+//  doctor
+// ──────────────────────────────────────────────
+
+func newDoctorCmd() *cobra.Command {
+	var (
+		all        bool
+		selection  deviceSelection
+		strict     bool
+		showPass   bool
+		output     string
+		minKernel  string
+		fromBundle string
+		fromFile   string
+		baseline   string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Run environment diagnostics for RDMA device readiness",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := expectedNetnsMode(cmd); err != nil {
+				return err
+			}
+			var base *doctor.Report
+			if baseline != "" {
+				var err error
+				if base, err = doctor.LoadBaseline(baseline); err != nil {
+					return &usageError{err}
+				}
+			}
+
+			var snap *bundle.Snapshot
+			if fromBundle != "" {
+				var err error
+				if snap, err = useBundle(cmd, fromBundle); err != nil {
+					return err
+				}
+				defer snap.Close()
+			}
+
+			if selection.named() && all && cmd.Flags().Changed("all") {
+				log.Warn("--all ignored because --pci or --ifname was specified")
+			}
+
+			var discoverer types.RdmaDeviceDiscoverer
+			if fromFile != "" {
+				inv, err := readInventory(cmd, fromFile)
+				if err != nil {
+					return err
+				}
+				discoverer = inv
+			} else {
+				discoverer = newDiscoverer(cmd, bundleOptions(snap)...)
+			}
+			devices, err := selection.discover(cmd, discoverer)
+			if err != nil {
+				return err
+			}
+
+			var merged *doctor.Report
+			if fromFile != "" {
+				merged = diagnoseInventory(cmd, devices)
+			} else {
+				// Spec drift can only be judged against the full device list
+				merged = diagnose(cmd, devices, !selection.filtered(), minKernel, snap)
+			}
+			if base != nil {
+				return compareBaseline(cmd, merged, base, output, showPass)
+			}
+
+			// Output
+			switch output {
+			case "json":
+				if err := doctor.PrintJSON(cmd.OutOrStdout(), merged, showPass); err != nil {
+					return err
+				}
+			default:
+				doctor.PrintTable(cmd.OutOrStdout(), merged, showPass)
+			}
+
+			var failed error
+			switch {
+			case merged.HasFail:
+				failed = fmt.Errorf("%w: see the report above", errChecksFailed)
+			case strict && merged.HasWarn:
+				failed = fmt.Errorf("%w: warnings reported with --strict", errChecksFailed)
+			default:
+				return nil
+			}
+			if len(devices) == 1 {
+				return withDevice(devices[0].PciAddress, failed)
+			}
+			return failed
+		},
+	}
+
+	cmd.Flags().BoolVar(&all, "all", true, "Check all RDMA devices")
+	selection.addFlags(cmd)
+	cmd.Flags().BoolVar(&strict, "strict", false, "Exit non-zero on warnings")
+	cmd.Flags().StringVar(&minKernel, "min-kernel", doctor.DefaultMinKernel, "Oldest acceptable kernel release")
+	cmd.Flags().BoolVar(&showPass, "show-pass", false, "Show passed checks in output")
+	cmd.Flags().StringVar(&output, "output", "table", "Output format (table|json)")
+	cmd.Flags().StringVar(&fromBundle, "from-bundle", "", "Diagnose a support bundle written by collect instead of the host (static checks only)")
+	cmd.Flags().StringVar(&fromFile, "from-file", "",
+		"Diagnose the devices of an inventory written by discover --output json on another host, or - for stdin; "+
+			"checks that need the live host are reported as SKIPPED")
+	cmd.Flags().StringVar(&baseline, "baseline", "",
+		"Compare with a report saved from doctor --output json, failing only on new or worse warnings and failures")
+	cmd.Flags().String("expected-netns-mode", "",
+		"RDMA netns mode the host is meant to be in (shared|exclusive); by default exclusive, or as the generated specs imply ($"+envExpectedNetnsMode+")")
+
+	cmd.MarkFlagsMutuallyExclusive("baseline", "strict")
+	cmd.MarkFlagsMutuallyExclusive("from-bundle", "from-file")
+	cmd.AddCommand(newDoctorListChecksCmd())
+
+	return cmd
+}
+
+func newDoctorListChecksCmd() *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "list-checks",
+		Short: "List the checks doctor and bench report, with their stable IDs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch output {
+			case "json":
+				return doctor.PrintChecksJSON(cmd.OutOrStdout(), doctor.Checks())
+			case "table":
+				doctor.PrintChecks(cmd.OutOrStdout(), doctor.Checks())
+				return nil
+			default:
+				return usageErrorf("unsupported output format %q: use table or json", output)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&output, "output", "table", "Output format (table|json)")
+
+	return cmd
+}
+
+// compareBaseline prints report with its changes from base, and fails
+// only when a check regressed, so the warnings and failures the baseline
+// already had are accepted.
+func compareBaseline(cmd *cobra.Command, report, base *doctor.Report, output string, showPass bool) error {
+	c := doctor.Compare(base, report)
+	switch output {
+	case "json":
+		if err := doctor.PrintComparisonJSON(cmd.OutOrStdout(), report, c, showPass); err != nil {
+			return err
+		}
+	default:
+		doctor.PrintTable(cmd.OutOrStdout(), report, showPass)
+		fmt.Fprintln(cmd.OutOrStdout())
+		doctor.PrintComparison(cmd.OutOrStdout(), c)
+	}
+	if len(c.Regressions) > 0 {
+		return fmt.Errorf("%w: %d check(s) regressed from the baseline", errChecksFailed, len(c.Regressions))
+	}
+	return nil
+}
+
+// diagnose runs the host-wide checks, then the diagnostics of each device,
+// and merges the reports. all means devices is the full device list, so
+// spec drift and spec integrity can be judged too. With a support bundle
+// snapshot, only the static checks run, against what the bundle captured.
+func diagnose(cmd *cobra.Command, devices []*types.RdmaDevice, all bool, minKernel string, snap *bundle.Snapshot) *doctor.Report {
+	sysfsRoot, devRoot := hostRoots(cmd)
+	hostOpts := []doctor.HostOption{doctor.WithMinKernel(minKernel), doctor.WithHostSysfsRoot(sysfsRoot)}
+	specDirs := cdi.StandardSpecDirs()
+	if snap != nil {
+		hostOpts = append(hostOpts, doctor.WithKernelRelease(snap.Manifest.Kernel), doctor.WithKernelConfig(snap.KernelConfigPaths()...))
+		specDirs = snap.SpecDirs(specDirs)
+	}
+	reports := []*doctor.Report{doctor.DiagnoseHost(hostOpts...)}
+	netnsMode, err := expectedNetnsMode(cmd)
+	if err != nil {
+		log.Warnf("ignoring the expected RDMA netns mode: %v", err)
+	}
+
+	p := newProgress(cmd, "doctor")
+	for _, dev := range devices {
+		diagOpts := []doctor.Option{doctor.WithSysfsRoot(sysfsRoot), doctor.WithDevRoot(devRoot)}
+		if required, ok := requiredDevicesPolicy(cmd); ok {
+			diagOpts = append(diagOpts, doctor.WithRequiredDevices(required...))
+		}
+		if netnsMode != "" {
+			diagOpts = append(diagOpts, doctor.WithExpectedNetnsMode(netnsMode))
+		}
+		if snap != nil {
+			diagOpts = append(diagOpts, doctor.WithOffline())
+		}
+		reports = append(reports, doctor.DiagnoseDevice(dev, diagOpts...))
+		if all {
+			p.step(len(devices), dev.PciAddress)
+		}
+	}
+	p.finish()
+	if snap == nil {
+		// A bundle carries neither addresses nor sysctls
+		reports = append(reports, doctor.DiagnoseMultiRail(devices))
+	}
+	if all {
+		reports = append(reports, doctor.DiagnoseSpecs(specDirs, devices))
+		reports = append(reports, doctor.DiagnoseNetnsSpecs(specDirs, devices, netnsMode))
+		// A bundle carries no state file; the checksum annotations still apply
+		var state *cdi.State
+		if snap == nil {
+			statePath, _ := cmd.Flags().GetString("state-file")
+			if s, err := cdi.LoadState(statePath); err == nil {
+				state = s
+			} else {
+				log.Warnf("checking specs without the state file: %v", err)
+			}
+		}
+		reports = append(reports, doctor.DiagnoseSpecIntegrity(specDirs, state))
+		// Injecting stats the host device nodes the specs name, which a
+		// bundle or a container without the host /dev lacks
+		inject := snap == nil && devRoot == rdma.DefaultDevRoot
+		reports = append(reports, diagnoseCacheLoad(specDirs, devices, inject, snap == nil))
+	}
+	merged := doctor.MergeReports(reports...)
+	merged.Label(devices, deviceIDFormat(cmd))
+	return merged
+}
+
+// diagnoseInventory runs the device checks of doctor on devices read from
+// an inventory file, skipping those that need the live host. The host,
+// multi-rail and spec checks are not run: an inventory records none of
+// what they look at.
+func diagnoseInventory(cmd *cobra.Command, devices []*types.RdmaDevice) *doctor.Report {
+	netnsMode, err := expectedNetnsMode(cmd)
+	if err != nil {
+		log.Warnf("ignoring the expected RDMA netns mode: %v", err)
+	}
+	var reports []*doctor.Report
+	for _, dev := range devices {
+		diagOpts := []doctor.Option{doctor.WithStatic()}
+		if required, ok := requiredDevicesPolicy(cmd); ok {
+			diagOpts = append(diagOpts, doctor.WithRequiredDevices(required...))
+		}
+		if netnsMode != "" {
+			diagOpts = append(diagOpts, doctor.WithExpectedNetnsMode(netnsMode))
+		}
+		reports = append(reports, doctor.DiagnoseDevice(dev, diagOpts...))
+	}
+	merged := doctor.MergeReports(reports...)
+	merged.Label(devices, deviceIDFormat(cmd))
+	return merged
+}
+
+// diagnoseCacheLoad runs doctor.DiagnoseCacheLoad on the specs in
+// specDirs or, when none was generated yet and fresh is set, on the specs
+// generate --all would write for devices, rendered into a temporary
+// directory.
+func diagnoseCacheLoad(specDirs []string, devices []*types.RdmaDevice, inject, fresh bool) *doctor.Report {
+	if managed, err := cdi.FindManagedSpecs(specDirs); err != nil || len(managed) > 0 || !fresh {
+		return doctor.DiagnoseCacheLoad(specDirs, inject)
+	}
+	dir, err := os.MkdirTemp("", "rdma-cdi-doctor-")
+	if err != nil {
+		log.Debugf("not checking fresh specs: %v", err)
+		return &doctor.Report{}
+	}
+	defer os.RemoveAll(dir)
+	healthy, _ := splitFailedDevices(devices)
+	for _, t := range perDeviceTargets(healthy) {
+		if err := cdi.CreateCDISpec(cdi.DefaultPrefix, t.name, []types.RdmaDevice{*t.devices[0]}, dir, "yaml"); err != nil {
+			log.Debugf("not checking the spec of %s: %v", t.name, err)
+		}
+	}
+	return doctor.DiagnoseCacheLoad([]string{dir}, inject)
+}
+
+// ──────────────────────────────────────────────
+//  collect
+// ──────────────────────────────────────────────
+
+func newCollectCmd() *cobra.Command {
+	var (
+		output    string
+		minKernel string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "collect",
+		Short: "Collect a support bundle for bug reports",
+		Long: `Write a gzipped tar with a sanitized snapshot of the sysfs and /dev entries
+of the RDMA devices, the loaded kernel modules, the installed CDI specs, and
+the discover and doctor output. MAC addresses and GUIDs are redacted, and IP
+addresses left out.`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			devices, err := newDiscoverer(cmd).DiscoverAll()
+			if err != nil && !errors.Is(err, rdma.ErrNoRdmaDevices) {
+				return fmt.Errorf("device discovery failed: %w", err)
+			}
+			if len(devices) == 0 {
+				log.Warn("No RDMA devices found; the bundle holds host information only")
+			}
+
+			// IP addresses identify the host as much as MAC addresses do,
+			// and node GUIDs are redacted from the snapshot
+			for _, dev := range devices {
+				dev.Addresses = nil
+				dev.NodeGUID = ""
+			}
+			var discovered, diagnosed bytes.Buffer
+			if err := discover.PrintJSON(&discovered, devices); err != nil {
+				return err
+			}
+			if err := doctor.PrintJSON(&diagnosed, diagnose(cmd, devices, true, minKernel, nil), true); err != nil {
+				return err
+			}
+
+			sysfsRoot, devRoot := hostRoots(cmd)
+			opts := []bundle.Option{
+				bundle.WithSysfsRoot(sysfsRoot), bundle.WithDevRoot(devRoot), bundle.WithVersion(version),
+				bundle.WithFile("discover.json", discovered.Bytes()),
+				bundle.WithFile("doctor.json", diagnosed.Bytes()),
+			}
+			if output == "-" {
+				return bundle.Collect(cmd.OutOrStdout(), devices, opts...)
+			}
+
+			f, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			err = bundle.Collect(f, devices, opts...)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(output)
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Wrote support bundle: %s\n", output)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&output, "output", "rdma-cdi-bundle.tar.gz", "Bundle file to write, or - for stdout")
+	cmd.Flags().StringVar(&minKernel, "min-kernel", doctor.DefaultMinKernel, "Oldest acceptable kernel release, for the doctor report")
+
+	return cmd
+}
+
+// ──────────────────────────────────────────────
+//  cleanup
+// ──────────────────────────────────────────────
+
+func newCleanupCmd() *cobra.Command {
+	var (
+		prefix     string
+		name       string
+		outputDirs []string
+		allDirs    bool
+		dryRun     bool
+		force      bool
+		orphans    bool
+		backupDir  string
+		noBackup   bool
+		filter     cdi.SpecSelector
+		selectors  []string
+		output     string
+		modified   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "Remove CDI spec files created by this tool",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "table" && output != "json" {
+				return usageErrorf("unsupported output format %q: use table or json", output)
+			}
+			match, err := parseSelector(selectors)
+			if err != nil {
+				return err
+			}
+
+			dirs := outputDirs
+			if allDirs {
+				dirs = cdi.StandardSpecDirs()
+			}
+			dirs = withRootlessDirs(cmd, dirs)
+
+			// Resolve the full list before touching anything, so it can be
+			// previewed and confirmed as a whole.
+			var candidates []string
+			if orphans {
+				candidates, err = findOrphans(newDiscoverer(cmd), dirs, prefix, name)
+			} else {
+				candidates, err = listSpecs(dirs, prefix, name)
+			}
+			if err != nil {
+				return err
+			}
+			if candidates, err = filter.Filter(candidates, prefix); err != nil {
+				return err
+			}
+			if !match.IsZero() {
+				if candidates, err = selectSpecs(newDiscoverer(cmd), candidates, match); err != nil {
+					return err
+				}
+			}
+
+			// Specs edited since generate wrote them are no longer ours alone
+			statePath, _ := cmd.Flags().GetString("state-file")
+			state, err := cdi.LoadState(statePath)
+			if err != nil {
+				return err
+			}
+			var kept []string
+			if !modified {
+				candidates, kept = dropModified(state, candidates)
+			}
+
+			summary := &cdi.CleanupSummary{Matched: candidates, DryRun: dryRun, Modified: kept}
+			switch {
+			case len(candidates) == 0:
+			case dryRun:
+				summary.Skipped = candidates
+			default:
+				proceed := force
+				if !force {
+					if output == "json" {
+						return usageErrorf("--output json cannot prompt for confirmation: pass --force or --dry-run")
+					}
+					if proceed, err = confirmRemoval(cmd, candidates); err != nil {
+						return err
+					}
+				}
+				if !proceed {
+					summary.Skipped = candidates
+					summary.Aborted = true
+					break
+				}
+
+				var reqs []preflight.Requirement
+				for _, dir := range specDirsOf(candidates) {
+					reqs = append(reqs, preflight.Writable(dir, "to remove CDI specs"))
+				}
+				if !noBackup {
+					reqs = append(reqs, preflight.Writable(backupDir, "to back up removed specs"))
+				}
+				if err := preflight.Check(reqs...); err != nil {
+					return err
+				}
+				if !noBackup {
+					id, err := cdi.BackupSpecs(backupDir, candidates)
+					if err != nil {
+						return fmt.Errorf("backup failed, nothing removed (use --no-backup to skip): %w", err)
+					}
+					summary.BackupID = id
+				}
+				// Remove one at a time so a single failure doesn't hide the rest
+				for _, f := range candidates {
+					store := &cdi.DirStore{Dir: filepath.Dir(f), StateFile: statePath}
+					if err := store.Remove(f); err != nil {
+						log.Errorf("%v", err)
+						summary.Errors = append(summary.Errors, err.Error())
+						summary.Skipped = append(summary.Skipped, f)
+						continue
+					}
+					summary.Removed = append(summary.Removed, f)
+				}
+			}
+
+			if output == "json" {
+				if err := cdi.PrintCleanupJSON(cmd.OutOrStdout(), summary); err != nil {
+					return err
+				}
+			} else {
+				printCleanupSummary(cmd.OutOrStdout(), summary)
+			}
+			if len(summary.Errors) > 0 {
+				return fmt.Errorf("%w: %d spec file(s) could not be removed", errPartialFailure, len(summary.Errors))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&prefix, "prefix", cdi.DefaultPrefix, "CDI resource prefix to match")
+	cmd.Flags().StringVar(&name, "name", "", "CDI resource name to match (all if omitted)")
+	cmd.Flags().StringSliceVar(&outputDirs, "output-dir", []string{cdi.DefaultOutputDir}, "CDI spec directories (repeatable)")
+	cmd.Flags().BoolVar(&allDirs, "all-dirs", false, "Clean every standard CDI spec directory (/etc/cdi, /var/run/cdi)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Preview files that would be removed")
+	cmd.Flags().BoolVar(&force, "force", false, "Skip the confirmation prompt (required when stdout is not a terminal)")
+	cmd.Flags().BoolVar(&orphans, "orphans", false, "Only remove specs whose devices no longer exist on this host")
+	cmd.Flags().StringVar(&backupDir, "backup-dir", cdi.DefaultBackupDir, "Directory for backups of removed spec files")
+	cmd.Flags().BoolVar(&noBackup, "no-backup", false, "Remove spec files without backing them up")
+
+	cmd.Flags().StringVar(&filter.NameGlob, "name-glob", "", "Only remove specs whose resource name matches this glob (e.g. 'pci-0000-3b-*')")
+	cmd.Flags().DurationVar(&filter.OlderThan, "older-than", 0, "Only remove specs last modified longer ago than this (e.g. 720h)")
+	addSelectorFlag(cmd, &selectors, "Only remove specs whose devices all match")
+	cmd.Flags().StringVar(&output, "output", "table", "Output format (table|json)")
+	cmd.Flags().BoolVar(&modified, "include-modified", false, "Also remove specs changed by hand since generate wrote them")
+
+	cmd.MarkFlagsMutuallyExclusive("output-dir", "all-dirs")
+	cmd.MarkFlagsMutuallyExclusive("name", "name-glob")
+
+	return cmd
+}
+
+// dropModified splits candidates into those still matching their state
+// record (or without one) and those modified since they were generated.
+func dropModified(state *cdi.State, candidates []string) (keep, modified []string) {
+	for _, f := range candidates {
+		changed, err := state.Modified(f)
+		if err != nil {
+			log.Warnf("cannot verify %s against the state file: %v", f, err)
+		}
+		if changed || err != nil {
+			log.Warnf("keeping %s: modified since it was generated (use --include-modified to remove it)", f)
+			modified = append(modified, f)
+			continue
+		}
+		keep = append(keep, f)
+	}
+	return keep, modified
+}
+
+// printCleanupSummary renders a cleanup summary as human-readable lines.
+func printCleanupSummary(w io.Writer, s *cdi.CleanupSummary) {
+	for _, f := range s.Modified {
+		fmt.Fprintf(w, "Kept (modified since generated): %s\n", f)
+	}
+	switch {
+	case len(s.Matched) == 0:
+		fmt.Fprintln(w, "No matching spec files found.")
+		return
+	case s.DryRun:
+		for _, f := range s.Skipped {
+			fmt.Fprintf(w, "Would remove: %s\n", f)
+		}
+		return
+	case s.Aborted:
+		fmt.Fprintln(w, "Aborted.")
+		return
+	}
+	if s.BackupID != "" {
+		fmt.Fprintf(w, "Backup saved as %s (undo with: rdma-cdi restore --from %s)\n", s.BackupID, s.BackupID)
+	}
+	for _, f := range s.Removed {
+		fmt.Fprintf(w, "Removed: %s\n", f)
+	}
+}
+
+// ──────────────────────────────────────────────
+//  list
+// ──────────────────────────────────────────────
+
+func newListCmd() *cobra.Command {
+	var (
+		managed    bool
+		outputDirs []string
+		allDirs    bool
+		output     string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List installed CDI spec files and whether their host devices exist",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "table" && output != "json" {
+				return usageErrorf("unsupported output format %q: use table or json", output)
+			}
+
+			if managed {
+				statePath, _ := cmd.Flags().GetString("state-file")
+				state, err := cdi.LoadState(statePath)
+				if err != nil {
+					return err
+				}
+				specs := list.Managed(state)
+				if output == "json" {
+					return list.PrintManagedJSON(cmd.OutOrStdout(), specs)
+				}
+				if len(specs) == 0 {
+					fmt.Fprintln(cmd.OutOrStdout(), "No managed spec files recorded.")
+					return nil
+				}
+				list.PrintManagedTable(cmd.OutOrStdout(), specs)
+				return nil
+			}
+
+			dirs := outputDirs
+			if allDirs || len(dirs) == 0 {
+				dirs = cdi.StandardSpecDirs()
+			}
+			dirs = withRootlessDirs(cmd, dirs)
+			_, devRoot := hostRoots(cmd)
+			specs, err := list.Installed(dirs, devRoot)
+			if err != nil {
+				return err
+			}
+			if output == "json" {
+				return list.PrintInstalledJSON(cmd.OutOrStdout(), specs)
+			}
+			if len(specs) == 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "No CDI spec files found in %s.\n", strings.Join(dirs, ", "))
+				return nil
+			}
+			list.PrintInstalledTable(cmd.OutOrStdout(), specs)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&managed, "managed", false, "List the spec files recorded in the state file, with their checksum status")
+	cmd.Flags().StringSliceVar(&outputDirs, "output-dir", nil, "CDI spec directories to scan (repeatable; default: all standard directories)")
+	cmd.Flags().BoolVar(&allDirs, "all-dirs", false, "Scan every standard CDI spec directory (/etc/cdi, /var/run/cdi)")
+	cmd.Flags().StringVar(&output, "output", "table", "Output format (table|json)")
+
+	cmd.MarkFlagsMutuallyExclusive("output-dir", "all-dirs")
+	cmd.MarkFlagsMutuallyExclusive("managed", "output-dir")
+	cmd.MarkFlagsMutuallyExclusive("managed", "all-dirs")
+
+	return cmd
+}
+
+// ──────────────────────────────────────────────
+//  explain
+// ──────────────────────────────────────────────
+
+func newExplainCmd() *cobra.Command {
+	var (
+		outputDirs []string
+		output     string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "explain <vendor/class=device>",
+		Short: "Show what a runtime would inject for a CDI device name",
+		Long: "Locate the spec defining a fully qualified CDI device name (e.g. rdma/net=0000:17:00.0)\n" +
+			"and print the device nodes, mounts, env and hooks a runtime would inject for it.",
+		Args: usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "table" && output != "json" {
+				return usageErrorf("unsupported output format %q: use table or json", output)
+			}
+
+			dirs := outputDirs
+			if len(dirs) == 0 {
+				dirs = cdi.StandardSpecDirs()
+			}
+			dirs = withRootlessDirs(cmd, dirs)
+			r, err := cdi.Resolve(dirs, args[0])
+			if err != nil {
+				return err
+			}
+			if output == "json" {
+				return cdi.PrintResolutionJSON(cmd.OutOrStdout(), r)
+			}
+			_, devRoot := hostRoots(cmd)
+			cdi.PrintResolution(cmd.OutOrStdout(), r, devRoot)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&outputDirs, "output-dir", nil, "CDI spec directories to search, lowest priority first (default: all standard directories)")
+	cmd.Flags().StringVar(&output, "output", "table", "Output format (table|json)")
+
+	return cmd
+}
+
+// listSpecs collects this tool's spec files from each directory.
+func listSpecs(dirs []string, prefix, name string) ([]string, error) {
+	var all []string
+	for _, dir := range dirs {
+		files, err := cdi.ListSpecs(dir, prefix, name)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, files...)
+	}
+	return all, nil
+}
+
+// findOrphans returns the specs, across all dirs, whose devices are missing
+// from the current discovery results.
+func findOrphans(discoverer types.RdmaDeviceDiscoverer, dirs []string, prefix, name string) ([]string, error) {
+	devices, err := discoverer.DiscoverAll()
+	if err != nil && !errors.Is(err, rdma.ErrNoRdmaDevices) {
+		return nil, fmt.Errorf("device discovery failed: %w", err)
+	}
+	present := make(map[string]bool, len(devices))
+	for _, dev := range devices {
+		present[dev.PciAddress] = true
+	}
+
+	var orphans []string
+	for _, dir := range dirs {
+		files, err := cdi.FindOrphanSpecs(dir, prefix, name, func(pci string) bool { return present[pci] })
+		if err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, files...)
+	}
+	return orphans, nil
+}
+
+// selectSpecs returns the specs among candidates whose devices all match
+// sel. Devices that are gone only match on their PCI address.
+func selectSpecs(discoverer types.RdmaDeviceDiscoverer, candidates []string, sel selector.Selector) ([]string, error) {
+	devices, err := discoverer.DiscoverAll()
+	if err != nil && !errors.Is(err, rdma.ErrNoRdmaDevices) {
+		return nil, fmt.Errorf("device discovery failed: %w", err)
+	}
+	return cdi.SelectSpecs(candidates, sel.PCIMatcher(devices)), nil
+}
+
+// stdoutIsTerminal decides whether cleanup may prompt; swapped out in tests.
+var stdoutIsTerminal = utils.IsTerminal
+
+// confirmRemoval lists files and asks the user to confirm their removal.
+// Without a terminal there is nobody to ask, so it refuses outright rather
+// than letting a script mass-delete specs by accident.
+func confirmRemoval(cmd *cobra.Command, files []string) (bool, error) {
+	out := cmd.OutOrStdout()
+	if !stdoutIsTerminal(out) {
+		return false, fmt.Errorf("refusing to remove %d spec file(s) without confirmation: pass --force or --dry-run", len(files))
+	}
+
+	fmt.Fprintf(out, "The following %d spec file(s) will be removed:\n", len(files))
+	for _, f := range files {
+		fmt.Fprintf(out, "  %s\n", f)
+	}
+	fmt.Fprint(out, "Proceed? [y/N]: ")
+
+	answer, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("cannot read confirmation: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// ──────────────────────────────────────────────
+//  restore
+// ──────────────────────────────────────────────
+
+func newRestoreCmd() *cobra.Command {
+	var (
+		from      string
+		backupDir string
+		outputDir string
+		force     bool
+		listOnly  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore CDI spec files removed by cleanup",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if listOnly {
+				ids, err := cdi.ListBackups(backupDir)
+				if err != nil {
+					return err
+				}
+				if len(ids) == 0 {
+					fmt.Fprintln(cmd.OutOrStdout(), "No backups found.")
+				}
+				for _, id := range ids {
+					fmt.Fprintln(cmd.OutOrStdout(), id)
+				}
+				return nil
+			}
+			if from == "" {
+				return usageErrorf("--from is required (a backup ID from --list, or \"latest\")")
+			}
+
+			restored, err := cdi.RestoreBackup(backupDir, from, outputDir, force)
+			for _, f := range restored {
+				fmt.Fprintf(cmd.OutOrStdout(), "Restored: %s\n", f)
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&from, "from", "", "Backup ID to restore, or \"latest\"")
+	cmd.Flags().StringVar(&backupDir, "backup-dir", cdi.DefaultBackupDir, "Directory holding cleanup backups")
+	cmd.Flags().StringVar(&outputDir, "output-dir", "", "Restore into this directory instead of the original location")
+	cmd.Flags().BoolVar(&force, "force", false, "Overwrite spec files that already exist")
+	cmd.Flags().BoolVar(&listOnly, "list", false, "List available backups")
+
+	cmd.MarkFlagsMutuallyExclusive("from", "list")
+
+	return cmd
+}
+
+// ──────────────────────────────────────────────
+//  migrate
+// ──────────────────────────────────────────────
+
+func newMigrateCmd() *cobra.Command {
+	var (
+		outputDirs []string
+		allDirs    bool
+		dryRun     bool
+		backupDir  string
+		noBackup   bool
+		output     string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Upgrade spec files written by earlier releases to the current conventions",
+		Long: "Rewrite this tool's spec files in place with the current CDI version, resource naming\n" +
+			"rules and canonical layout, renaming files whose name no longer matches. Changed files\n" +
+			"are backed up first (undo with rdma-cdi restore).",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "table" && output != "json" {
+				return usageErrorf("unsupported output format %q: use table or json", output)
+			}
+
+			dirs := outputDirs
+			if allDirs {
+				dirs = cdi.StandardSpecDirs()
+			}
+			dirs = withRootlessDirs(cmd, dirs)
+			files, err := cdi.FindManagedSpecs(dirs)
+			if err != nil {
+				return err
+			}
+			plans := cdi.PlanMigrations(files)
+
+			var pending []string
+			for _, m := range plans {
+				if m.Error == "" && len(m.Changes) > 0 {
+					pending = append(pending, m.Path)
+				}
+			}
+			backupID := ""
+			if !dryRun && len(pending) > 0 {
+				if !noBackup {
+					if backupID, err = cdi.BackupSpecs(backupDir, pending); err != nil {
+						return fmt.Errorf("backup failed, nothing migrated (use --no-backup to skip): %w", err)
+					}
+				}
+				for i, m := range plans {
+					if m.Error != "" || len(m.Changes) == 0 {
+						continue
+					}
+					if err := cdi.ApplyMigration(m); err != nil {
+						log.Errorf("%v", err)
+						plans[i].Error = err.Error()
+					}
+				}
+				statePath, _ := cmd.Flags().GetString("state-file")
+				migrateState(statePath, plans)
+			}
+
+			if output == "json" {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(plans); err != nil {
+					return err
+				}
+			} else {
+				printMigrations(cmd.OutOrStdout(), plans, dryRun, backupID)
+			}
+
+			failed := 0
+			for _, m := range plans {
+				if m.Error != "" {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%w: %d spec file(s) could not be migrated", errPartialFailure, failed)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&outputDirs, "output-dir", []string{cdi.DefaultOutputDir}, "CDI spec directories (repeatable)")
+	cmd.Flags().BoolVar(&allDirs, "all-dirs", false, "Migrate every standard CDI spec directory (/etc/cdi, /var/run/cdi)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would change without writing anything")
+	cmd.Flags().StringVar(&backupDir, "backup-dir", cdi.DefaultBackupDir, "Directory for backups of the original spec files")
+	cmd.Flags().BoolVar(&noBackup, "no-backup", false, "Migrate spec files without backing them up")
+	cmd.Flags().StringVar(&output, "output", "table", "Output format (table|json)")
+
+	cmd.MarkFlagsMutuallyExclusive("output-dir", "all-dirs")
+
+	return cmd
+}
+
+// migrateState moves the state record of each migrated spec to its new
+// path and checksum. Failures only warn, as the specs are already written.
+func migrateState(path string, plans []cdi.Migration) {
+	err := cdi.UpdateState(path, func(state *cdi.State) error {
+		for _, m := range plans {
+			if m.Error != "" || len(m.Changes) == 0 {
+				continue
+			}
+			rec, ok := state.Lookup(m.Path)
+			if !ok {
+				continue
+			}
+			state.Forget(m.Path)
+			if err := state.Record(m.NewPath, m.Kind, rec.Devices, rec.Identities...); err != nil {
+				log.Warnf("not recording %s: %v", m.NewPath, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warnf("cannot update state file: %v", err)
+	}
+}
+
+// printMigrations renders migration results as human-readable lines.
+func printMigrations(w io.Writer, plans []cdi.Migration, dryRun bool, backupID string) {
+	if len(plans) == 0 {
+		fmt.Fprintln(w, "No spec files created by this tool found.")
+		return
+	}
+	if backupID != "" {
+		fmt.Fprintf(w, "Backup saved as %s (undo with: rdma-cdi restore --from %s)\n", backupID, backupID)
+	}
+	verb := "Migrated"
+	if dryRun {
+		verb = "Would migrate"
+	}
+	for _, m := range plans {
+		switch {
+		case m.Error != "":
+			fmt.Fprintf(w, "Failed: %s: %s\n", m.Path, m.Error)
+		case len(m.Changes) == 0:
+			fmt.Fprintf(w, "Up to date: %s\n", m.Path)
+		default:
+			fmt.Fprintf(w, "%s: %s (%s)\n", verb, m.Path, strings.Join(m.Changes, "; "))
+		}
+	}
+}
+
+// ──────────────────────────────────────────────
+//  guard
+// ──────────────────────────────────────────────
+
+func newGuardCmd() *cobra.Command {
+	var (
+		outputDirs     []string
+		allDirs        bool
+		k8sEvents      bool
+		nodeName       string
+		nodeCondition  string
+		doctorInterval time.Duration
+		textfileDir    string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "guard",
+		Short: "Restore generated spec files when something else modifies or removes them",
+		Long: `Watch the CDI spec directories until interrupted and write back any spec
+file recorded in the state file that is modified or removed by something other
+than rdma-cdi, such as a configuration management run wiping /etc/cdi. Specs
+rewritten by generate, migrate or cleanup are accepted as they are recorded.
+Only one guard runs per directory: a second one, e.g. a debug run next to the
+systemd unit, exits with an error naming the PID of the first.
+
+In a pod, --k8s-events reports specs that cannot be restored as Kubernetes
+Events on the node, seen in kubectl describe node. With --doctor-interval,
+doctor runs periodically and its status changes are reported too, and
+--node-condition maintains a node condition, e.g. RDMAHealthy, false while a
+check fails. The pod's service account must be allowed to create events and
+patch nodes/status.
+
+With --textfile-dir, the rdma_cdi_guard_restores_total counter, by result
+ok or failed, is written to rdma-cdi-guard.prom there for the node_exporter
+textfile collector, on start, after each restore and on shutdown.`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !k8sEvents && (nodeCondition != "" || doctorInterval != 0) {
+				return usageErrorf("--node-condition and --doctor-interval need --k8s-events")
+			}
+			if doctorInterval < 0 {
+				return usageErrorf("--doctor-interval must not be negative, got %s", doctorInterval)
+			}
+			var rec *kubeevents.Recorder
+			if k8sEvents {
+				if nodeName == "" {
+					return usageErrorf("--k8s-events needs --node-name or the NODE_NAME environment variable")
+				}
+				api, err := kubeapi.InCluster()
+				if err != nil {
+					return fmt.Errorf("cannot report Kubernetes events: %w", err)
+				}
+				rec = kubeevents.NewRecorder(&kubeevents.Client{API: api, Node: nodeName}, nodeCondition)
+			}
+
+			dirs := outputDirs
+			if allDirs {
+				dirs = cdi.StandardSpecDirs()
+			}
+			dirs = withRootlessDirs(cmd, dirs)
+			statePath, _ := cmd.Flags().GetString("state-file")
+
+			lock, err := singleton.Acquire(singleton.LockDir(), dirs...)
+			if err != nil {
+				return err
+			}
+			defer lock.Release()
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			// SIGHUP, e.g. from systemctl reload, re-reads the state file
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			defer signal.Stop(hup)
+			resync := make(chan struct{}, 1)
+			events, err := cdi.GuardSpecs(ctx, dirs, statePath, cdi.GuardResync(resync))
+			if err != nil {
+				return err
+			}
+			log.Infof("Guarding CDI specs in %s", strings.Join(dirs, ", "))
+
+			var restored, failed uint64
+			writeMetrics := func() {
+				if textfileDir == "" {
+					return
+				}
+				err := writeTextfile(textfileDir, stats.GuardTextfileName, func(w io.Writer) error {
+					return stats.PrintGuardPrometheus(w, restored, failed, time.Now())
+				})
+				if err != nil {
+					log.Warnf("Cannot export guard metrics: %v", err)
+				}
+			}
+			writeMetrics()
+			watchdog, stopping := notifyReady()
+			defer stopping()
+
+			var ticks <-chan time.Time
+			if rec != nil && doctorInterval > 0 {
+				rec.ObserveReport(ctx, guardDoctor(cmd))
+				ticker := time.NewTicker(doctorInterval)
+				defer ticker.Stop()
+				ticks = ticker.C
+			}
+
+		loop:
+			for {
+				select {
+				case ev, ok := <-events:
+					if !ok {
+						break loop
+					}
+					if ev.Err != nil {
+						failed++
+						writeMetrics()
+						log.WithField("spec", ev.Path).Errorf("Cannot restore %s: %v", ev.Path, ev.Err)
+						if rec != nil {
+							rec.ReconcileFailed(ctx, ev.Path, ev.Err)
+						}
+						continue
+					}
+					restored++
+					writeMetrics()
+					log.WithField("spec", ev.Path).Warnf("Restored %s (modified or removed outside rdma-cdi)", ev.Path)
+				case <-ticks:
+					rec.ObserveReport(ctx, guardDoctor(cmd))
+				case <-hup:
+					log.Info("Reloading: re-reading the state file")
+					select {
+					case resync <- struct{}{}:
+					default: // a resync is already pending
+					}
+				case <-watchdog:
+					notify(systemd.Watchdog)
+				}
+			}
+			writeMetrics()
+			log.Infof("Guard stopped: %d spec file(s) restored, %d could not be", restored, failed)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&outputDirs, "output-dir", []string{cdi.DefaultOutputDir}, "CDI spec directories (repeatable)")
+	cmd.Flags().BoolVar(&allDirs, "all-dirs", false, "Guard every standard CDI spec directory (/etc/cdi, /var/run/cdi)")
+	cmd.Flags().BoolVar(&k8sEvents, "k8s-events", false, "Report restore failures and doctor status changes as Kubernetes Events on the node")
+	cmd.Flags().StringVar(&nodeName, "node-name", os.Getenv("NODE_NAME"), "Kubernetes node the events are about, $NODE_NAME by default")
+	cmd.Flags().StringVar(&nodeCondition, "node-condition", "", "Also maintain this node condition with --k8s-events, e.g. "+kubeevents.DefaultCondition)
+	cmd.Flags().DurationVar(&doctorInterval, "doctor-interval", 0, "Run doctor this often with --k8s-events and report its status changes (0 disables)")
+	cmd.Flags().StringVar(&textfileDir, "textfile-dir", "", "Write the restore counters to "+stats.GuardTextfileName+" in this node_exporter textfile directory")
+
+	cmd.MarkFlagsMutuallyExclusive("output-dir", "all-dirs")
+
+	return cmd
+}
+
+// notifyReady tells systemd that the daemon is up when it runs as a
+// Type=notify service. It returns a channel ticking whenever the watchdog
+// is due, nil without one, and a function to call on shutdown.
+func notifyReady() (watchdog <-chan time.Time, stopping func()) {
+	notify(systemd.Ready)
+	var ticker *time.Ticker
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		ticker = time.NewTicker(interval)
+		watchdog = ticker.C
+	}
+	return watchdog, func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+		notify(systemd.Stopping)
+	}
+}
+
+// notify sends state to systemd, logging failures only at debug level:
+// the daemon works the same without a service manager listening.
+func notify(state string) {
+	if err := systemd.Notify(state); err != nil {
+		log.Debugf("Cannot notify systemd of %s: %v", state, err)
+	}
+}
+
+// guardDoctor runs doctor on every device for guard --doctor-interval;
+// a discovery failure is reported as a failing check.
+func guardDoctor(cmd *cobra.Command) *doctor.Report {
+	devices, err := newDiscoverer(cmd).DiscoverAll()
+	if err != nil {
+		return doctor.NewReport(doctor.CheckResult{Check: "discovery", Severity: doctor.Fail, Message: fmt.Sprintf("device discovery failed: %v", err)})
+	}
+	return diagnose(cmd, devices, true, doctor.DefaultMinKernel, nil)
+}
+
+// ──────────────────────────────────────────────
+//  udev
+// ──────────────────────────────────────────────
+
+func newUdevCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "udev",
+		Short: "Manage the udev rule that regenerates specs on RDMA hot-plug",
+	}
+	cmd.AddCommand(newUdevInstallCmd())
+	return cmd
+}
+
+func newUdevInstallCmd() *cobra.Command {
+	var (
+		rulesFile    string
+		binary       string
+		generateArgs []string
+		dryRun       bool
+		force        bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "install",
+		Short: "Install a udev rule that runs generate and cleanup when RDMA devices come and go",
+		Args:  usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			binary, err := selfBinary(binary)
+			if err != nil {
+				return err
+			}
+			rules, err := udev.Rules(binary, generateArgs)
+			if err != nil {
+				return &usageError{err}
+			}
+
+			if dryRun {
+				fmt.Fprint(cmd.OutOrStdout(), rules)
+				return nil
+			}
+			if err := udev.Install(rulesFile, rules, force); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Installed: %s\n", rulesFile)
+			fmt.Fprintln(cmd.OutOrStdout(), "Run 'udevadm control --reload' to activate it.")
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&rulesFile, "rules-file", udev.DefaultRulesFile, "Path of the udev rule file to write")
+	cmd.Flags().StringVar(&binary, "binary", "", "Absolute path of rdma-cdi the rule runs (default: this binary)")
+	cmd.Flags().StringSliceVar(&generateArgs, "generate-args", nil, "Extra generate flags for the rule, e.g. --aliases,--format=json")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the rule instead of installing it")
+	cmd.Flags().BoolVar(&force, "force", false, "Replace a rule file not written by rdma-cdi")
+
+	return cmd
+}
+
+// ──────────────────────────────────────────────
+//  systemd
+// ──────────────────────────────────────────────
+
+func newSystemdCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "systemd",
+		Short: "Manage the systemd units that generate and guard specs",
+	}
+	cmd.AddCommand(newSystemdInstallCmd())
+	return cmd
+}
+
+func newSystemdInstallCmd() *cobra.Command {
+	var (
+		unitFile      string
+		guardUnitFile string
+		binary        string
+		generateArgs  []string
+		guardArgs     []string
+		noGuard       bool
+		dryRun        bool
+		force         bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "install",
+		Short: "Install systemd units that run generate --all at boot and guard afterwards, with the current global flags",
+		Long: `Install a oneshot systemd unit that runs "generate --all" at boot, ordered
+before the container runtimes, and a Type=notify unit that runs "guard" after
+it, reporting readiness and petting the systemd watchdog; systemctl reload
+makes the guard re-read the state file. --no-guard installs only the first.
+Global flags given to this command, such as --host-root or --require-devices,
+are baked into the units' command lines.`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			binary, err := selfBinary(binary)
+			if err != nil {
+				return err
+			}
+			global := changedFlagArgs(cmd.InheritedFlags())
+			unit, err := systemd.Unit(binary, append(append(slices.Clone(global), "generate", "--all"), generateArgs...))
+			if err != nil {
+				return &usageError{err}
+			}
+			units := []struct{ path, content string }{{unitFile, unit}}
+			if !noGuard {
+				guard, err := systemd.GuardUnit(binary, append(append(slices.Clone(global), "guard"), guardArgs...))
+				if err != nil {
+					return &usageError{err}
+				}
+				units = append(units, struct{ path, content string }{guardUnitFile, guard})
+			}
+
+			if dryRun {
+				for i, u := range units {
+					if i > 0 {
+						fmt.Fprintln(cmd.OutOrStdout())
+					}
+					fmt.Fprintf(cmd.OutOrStdout(), "# %s\n%s", u.path, u.content)
+				}
+				return nil
+			}
+			names := make([]string, 0, len(units))
+			for _, u := range units {
+				if err := systemd.Install(u.path, u.content, force); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Installed: %s\n", u.path)
+				names = append(names, filepath.Base(u.path))
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Run 'systemctl daemon-reload && systemctl enable %s' to activate them.\n", strings.Join(names, " "))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&unitFile, "unit-file", systemd.DefaultUnitFile, "Path of the generate unit file to write")
+	cmd.Flags().StringVar(&guardUnitFile, "guard-unit-file", systemd.DefaultGuardUnitFile, "Path of the guard unit file to write")
+	cmd.Flags().StringVar(&binary, "binary", "", "Absolute path of rdma-cdi the units run (default: this binary)")
+	cmd.Flags().StringSliceVar(&generateArgs, "generate-args", nil, "Extra generate flags for the unit, e.g. --aliases,--format=json")
+	cmd.Flags().StringSliceVar(&guardArgs, "guard-args", nil, "Extra guard flags for the guard unit, e.g. --all-dirs")
+	cmd.Flags().BoolVar(&noGuard, "no-guard", false, "Install only the generate unit")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the units instead of installing them")
+	cmd.Flags().BoolVar(&force, "force", false, "Replace unit files not written by rdma-cdi")
+
+	return cmd
+}
+
+// ──────────────────────────────────────────────
+//  deploy
+// ──────────────────────────────────────────────
+
+func newDeployCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "deploy",
+		Short: "Render what runs rdma-cdi fleet-wide on Kubernetes",
+	}
+	cmd.AddCommand(newDeployManifestCmd())
+	return cmd
+}
+
+// hostPathFlags name global flags holding host paths, which the manifest
+// replaces with where the pod mounts them.
+var hostPathFlags = []string{"host-root", "sysfs-root", "dev-root", "state-file"}
+
+func newDeployManifestCmd() *cobra.Command {
+	var (
+		o            deploy.Options
+		mode         string
+		generateArgs []string
+		nodeSelector map[string]string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "manifest",
+		Short: "Print a DaemonSet running rdma-cdi on every node with the current global flags",
+		Long: `Print a Kubernetes DaemonSet, ready for kubectl apply, that runs rdma-cdi on
+every node with the host mounts, privileges and tolerations it needs.
+
+  watch          generate --all in an init container, then guard the specs
+  dra            a DRA driver image embedding rdma-cdi (--plugin-name is the
+                 driver name)
+  device-plugin  a device plugin image embedding rdma-cdi (--plugin-name is
+                 the resource name)
+
+Global flags given to this command, such as --require-devices or --plugins,
+are baked into the containers' arguments; --host-root is where the pod mounts
+the host's /. No image is published with rdma-cdi, so --image is required.
+With --k8s-events, the manifest also holds a ServiceAccount and the RBAC
+rules to create events and patch nodes/status, and in watch mode guard
+reports restore failures and doctor status changes to the node, maintaining
+its RDMAHealthy condition.`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := deploy.ParseMode(mode)
+			if err != nil {
+				return &usageError{err}
+			}
+			o.Mode = m
+			o.GenerateArgs = generateArgs
+			o.NodeSelector = nodeSelector
+			o.HostRoot, _ = cmd.Flags().GetString("host-root")
+			if o.HostRoot == "" || o.HostRoot == hostRootAuto {
+				o.HostRoot = deploy.DefaultHostRoot
+			}
+			o.StateFile, _ = cmd.Flags().GetString("state-file")
+			o.LockDir = singleton.DefaultLockDir
+			o.SpecDirs = cdi.StandardSpecDirs()
+
+			o.GlobalArgs = slices.DeleteFunc(changedFlagArgs(cmd.InheritedFlags()), func(arg string) bool {
+				name, _, _ := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+				return slices.Contains(hostPathFlags, name)
+			})
+
+			manifest, err := deploy.Manifest(o)
+			if err != nil {
+				return &usageError{err}
+			}
+			_, err = cmd.OutOrStdout().Write(manifest)
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&mode, "mode", string(deploy.ModeWatch), "What the DaemonSet runs (watch|dra|device-plugin)")
+	cmd.Flags().StringVar(&o.Image, "image", "", "Container image with rdma-cdi, or the DRA driver or device plugin embedding it")
+	cmd.Flags().StringVar(&o.Name, "name", deploy.DefaultName, "Name of the DaemonSet")
+	cmd.Flags().StringVar(&o.Namespace, "namespace", deploy.DefaultNamespace, "Namespace of the DaemonSet")
+	cmd.Flags().StringVar(&o.PluginName, "plugin-name", "", "DRA driver or device plugin resource name (dra and device-plugin modes)")
+	cmd.Flags().StringSliceVar(&generateArgs, "generate-args", nil, "Extra generate flags in watch mode, e.g. --aliases,--format=json")
+	cmd.Flags().StringToStringVar(&nodeSelector, "node-selector", nil, "Only run on nodes with these labels, e.g. feature.node.kubernetes.io/rdma.available=true")
+	cmd.Flags().BoolVar(&o.Events, "k8s-events", false, "Render a service account allowed to report node events and conditions; in watch mode, guard reports them")
+
+	_ = cmd.MarkFlagRequired("image")
+
+	return cmd
+}
+
+// ──────────────────────────────────────────────
+//  export
+// ──────────────────────────────────────────────
+
+func newExportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Convert discovered devices into the configuration of other Kubernetes RDMA components",
+	}
+	cmd.AddCommand(newExportSharedDevPluginCmd(), newExportSriovPolicyCmd())
+	return cmd
+}
+
+func newExportSharedDevPluginCmd() *cobra.Command {
+	var (
+		groupBy    string
+		rdmaHcaMax int
+		prefix     string
+		format     string
+		name       string
+		namespace  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "shared-dev-plugin",
+		Short: "Print a k8s-rdma-shared-dev-plugin config for the RDMA PFs of this host",
+		Long: `Print the ConfigMap consumed by k8s-rdma-shared-dev-plugin, with one shared
+resource per PF (selected by interface name), per adapter model (vendor and
+device ID) or per link type, for clusters running the plugin alongside CDI.
+VFs are left out; the SR-IOV device plugin hands those out.`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "configmap" && format != "json" {
+				return usageErrorf("unsupported format %q: use configmap or json", format)
+			}
+			if !export.ValidGroup(groupBy) {
+				return usageErrorf("unsupported group key %q: use %s, %s or %s", groupBy, export.GroupInterface, export.GroupModel, export.GroupLinkType)
+			}
+			if rdmaHcaMax < 1 {
+				return usageErrorf("--rdma-hca-max must be at least 1, got %d", rdmaHcaMax)
+			}
+
+			devices, err := newDiscoverer(cmd).DiscoverAll()
+			if err != nil {
+				return fmt.Errorf("discovery failed: %w", err)
+			}
+			cfg, err := export.SharedDevPlugin(devices, groupBy, rdmaHcaMax)
+			if err != nil {
+				return &usageError{err}
+			}
+			for i := range cfg.ConfigList {
+				cfg.ConfigList[i].ResourcePrefix = prefix
+			}
+
+			data, err := json.MarshalIndent(cfg, "", "  ")
+			if err != nil {
+				return err
+			}
+			if format == "json" {
+				fmt.Fprintln(cmd.OutOrStdout(), string(data))
+				return nil
+			}
+			manifest, err := export.ConfigMap(name, namespace, export.SharedDevPluginConfigKey, append(data, '\n'))
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(manifest)
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&groupBy, "group-by", export.GroupInterface, "One resource per interface, model or link-type")
+	cmd.Flags().IntVar(&rdmaHcaMax, "rdma-hca-max", export.DefaultRdmaHcaMax, "How many pods may share each resource")
+	cmd.Flags().StringVar(&prefix, "resource-prefix", "", "Resource prefix (default: the plugin's, rdma)")
+	cmd.Flags().StringVar(&format, "format", "configmap", "Output format (configmap|json); json is the bare config.json")
+	cmd.Flags().StringVar(&name, "name", export.SharedDevPluginConfigMap, "ConfigMap name")
+	cmd.Flags().StringVar(&namespace, "namespace", export.SharedDevPluginNamespace, "ConfigMap namespace")
+
+	return cmd
+}
+
+func newExportSriovPolicyCmd() *cobra.Command {
+	var (
+		numVFs    int
+		namespace string
+		node      string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "sriov-policy",
+		Short: "Print SriovNetworkNodePolicy skeletons for the RDMA PFs of this host",
+		Long: `Print one sriov-network-operator SriovNetworkNodePolicy per SR-IOV capable
+RDMA PF (pfNames, vendor and device ID, numVfs, deviceType netdevice,
+isRdma true), pinned to this node, as a starting point for moving VF
+configuration to the operator. numVfs is the number of VFs currently
+enabled; PFs without VFs are skipped unless --num-vfs is set. Review the
+resource names and priorities before applying.`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if numVFs < 0 {
+				return usageErrorf("--num-vfs must not be negative, got %d", numVFs)
+			}
+
+			discoverer := newDiscoverer(cmd)
+			devices, err := discoverer.DiscoverAll()
+			if err != nil {
+				return fmt.Errorf("discovery failed: %w", err)
+			}
+			var pfs []export.SriovPF
+			for _, dev := range devices {
+				if dev.IsVF || dev.IfName == "" {
+					continue
+				}
+				current, total, err := discoverer.GetSriovVFs(dev.PciAddress)
+				if err != nil {
+					log.Debugf("Skipping %s: %v", dev.PciAddress, err)
+					continue
+				}
+				n := current
+				if numVFs > 0 {
+					n = min(numVFs, total)
+				}
+				if n == 0 {
+					log.Infof("Skipping %s (%s): no VFs enabled (set --num-vfs)", dev.PciAddress, dev.IfName)
+					continue
+				}
+				pfs = append(pfs, export.SriovPF{Device: dev, NumVFs: n})
+			}
+			if len(pfs) == 0 {
+				return fmt.Errorf("%w: no SR-IOV RDMA PF to write a policy for", rdma.ErrNoRdmaDevices)
+			}
+
+			out, err := export.MarshalYAMLDocuments(export.SriovPolicies(pfs, namespace, node))
 			if err != nil {
 				return err
 			}
-			if len(removed) == 0 {
-				fmt.Fprintln(cmd.OutOrStdout(), "No matching spec files found.")
-			} else {
-				action := "Removed"
-				if dryRun {
-					action = "Would remove"
+			_, err = cmd.OutOrStdout().Write(out)
+			return err
+		},
+	}
+
+	hostname, _ := os.Hostname()
+	cmd.Flags().IntVar(&numVFs, "num-vfs", 0, "VFs per PF, capped at sriov_totalvfs (default: the number currently enabled)")
+	cmd.Flags().StringVar(&namespace, "namespace", export.SriovOperatorNamespace, "Namespace of the policies")
+	cmd.Flags().StringVar(&node, "node", hostname, "Node the policies select by kubernetes.io/hostname (empty: all nodes)")
+
+	return cmd
+}
+
+// ──────────────────────────────────────────────
+//  mapping
+// ──────────────────────────────────────────────
+
+func newMappingCmd() *cobra.Command {
+	var (
+		sharedDevConfigs []string
+		sriovDPConfigs   []string
+		nads             []string
+		outputDirs       []string
+		output           string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "mapping",
+		Short: "Map RDMA devices to their CDI devices, device plugin resources and networks",
+		Long: `Relate each RDMA device of this host to the CDI devices naming it, the
+extended resources of k8s-rdma-shared-dev-plugin and sriov-network-device-plugin
+selecting it, and the NetworkAttachmentDefinitions requesting those resources
+through the k8s.v1.cni.cncf.io/resourceName annotation. Device plugin configs
+are read as config.json or as the ConfigMap manifest holding it; --nad takes
+manifest files or directories, e.g. the output of
+'kubectl get network-attachment-definitions -A -o yaml'.
+
+A device with a resource but no CDI device, a resource no network requests
+(when --nad is given) and a network whose resource no device provides are
+reported as problems, and make the command exit with status 4.`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "table" && output != "json" {
+				return usageErrorf("unsupported output %q: use table or json", output)
+			}
+
+			var resources []mapping.Resource
+			for _, path := range sharedDevConfigs {
+				res, err := mapping.LoadSharedDevConfig(path)
+				if err != nil {
+					return err
 				}
-				for _, f := range removed {
-					fmt.Fprintf(cmd.OutOrStdout(), "%s: %s\n", action, f)
+				resources = append(resources, res...)
+			}
+			for _, path := range sriovDPConfigs {
+				res, err := mapping.LoadSriovDPConfig(path)
+				if err != nil {
+					return err
 				}
+				resources = append(resources, res...)
+			}
+			networks, err := mapping.LoadNetworks(nads)
+			if err != nil {
+				return err
+			}
+
+			devices, err := newDiscoverer(cmd).DiscoverAll()
+			if err != nil {
+				return fmt.Errorf("discovery failed: %w", err)
+			}
+			dirs := outputDirs
+			if len(dirs) == 0 {
+				dirs = cdi.StandardSpecDirs()
+			}
+			dirs = withRootlessDirs(cmd, dirs)
+			report, err := mapping.Build(devices, dirs, resources, networks)
+			if err != nil {
+				return err
+			}
+
+			if output == "json" {
+				err = mapping.PrintJSON(cmd.OutOrStdout(), report)
+			} else {
+				mapping.PrintTable(cmd.OutOrStdout(), report)
+			}
+			if err != nil {
+				return err
+			}
+			if report.HasProblems {
+				return fmt.Errorf("%w: see the report above", errChecksFailed)
 			}
 			return nil
 		},
 	}
 
-	cmd.Flags().StringVar(&prefix, "prefix", cdi.DefaultPrefix, "CDI resource prefix to match")
-	cmd.Flags().StringVar(&name, "name", "", "CDI resource name to match (all if omitted)")
-	cmd.Flags().StringVar(&outputDir, "output-dir", cdi.DefaultOutputDir, "CDI spec directory")
-	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Preview files that would be removed")
-	cmd.Flags().BoolVar(&force, "force", false, "Skip confirmation prompts")
+	cmd.Flags().StringSliceVar(&sharedDevConfigs, "shared-dev-config", nil, "k8s-rdma-shared-dev-plugin config.json or ConfigMap manifest (repeatable)")
+	cmd.Flags().StringSliceVar(&sriovDPConfigs, "sriov-dp-config", nil, "sriov-network-device-plugin config.json or ConfigMap manifest (repeatable)")
+	cmd.Flags().StringSliceVar(&nads, "nad", nil, "NetworkAttachmentDefinition manifest file or directory (repeatable)")
+	cmd.Flags().StringSliceVar(&outputDirs, "output-dir", nil, "CDI spec directories to scan (repeatable; default: all standard directories)")
+	cmd.Flags().StringVar(&output, "output", "table", "Output format (table|json)")
+
+	return cmd
+}
+
+// ──────────────────────────────────────────────
+//  bind
+// ──────────────────────────────────────────────
+
+// bindPollInterval is how often bind checks whether the RDMA device of a
+// rebound function has appeared.
+const bindPollInterval = 200 * time.Millisecond
+
+func newBindCmd() *cobra.Command {
+	var (
+		pci        string
+		driver     string
+		force      bool
+		dryRun     bool
+		noGenerate bool
+		prefix     string
+		outputDir  string
+		format     string
+		procRoot   string
+		timeout    time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "bind",
+		Short: "Rebind a PCI function between its kernel driver and vfio-pci, then update its CDI spec",
+		Long: `Move a PCI function to another driver through sysfs, e.g. a VF to vfio-pci
+before a DPDK workload takes it, and back to mlx5_core afterwards.
+
+The function is left alone while it is in use: an interface of it is up, it
+has SR-IOV VFs enabled, or a process holds one of its RDMA device nodes or
+its VFIO group. --force skips these checks. vfio-pci also needs the function
+to be in an IOMMU group.
+
+Once bound, the per-device spec generate writes for the function (named after
+its PCI address) is brought in step: written when the function has an RDMA
+device on the new driver, removed when it has none, e.g. under vfio-pci.`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			normalized, err := utils.NormalizePCIAddress(pci)
+			if err != nil {
+				return &usageError{err}
+			}
+			pci = normalized
+			if format != "yaml" && format != "json" {
+				return usageErrorf("unsupported format %q: use yaml or json", format)
+			}
+
+			sysfsRoot, _ := hostRoots(cmd)
+			if !dryRun {
+				reqs := []preflight.Requirement{
+					preflight.Writable(filepath.Join(sysfsRoot, "bus/pci/devices", pci, "driver_override"), "to rebind the function"),
+				}
+				if !noGenerate {
+					reqs = append(reqs, preflight.Writable(outputDir, "to regenerate its CDI spec"))
+				}
+				if err := preflight.Check(reqs...); err != nil {
+					return err
+				}
+			}
+			discoverer := newDiscoverer(cmd)
+
+			opts := []bind.Option{bind.WithSysfsRoot(sysfsRoot), bind.WithProcRoot(procRoot)}
+			if force {
+				opts = append(opts, bind.WithForce())
+			}
+			if dryRun {
+				opts = append(opts, bind.WithDryRun())
+			}
+			// The RDMA nodes of the function; rdma_cm is shared by every device
+			if dev, err := discoverer.DiscoverByPCI(pci); err == nil {
+				for _, spec := range dev.DeviceSpecs {
+					if filepath.Base(spec.HostPath) != cdi.RdmaCMDeviceName {
+						opts = append(opts, bind.WithDeviceNodes(spec.HostPath))
+					}
+				}
+			}
+
+			res, err := bind.Bind(pci, driver, opts...)
+			if err != nil {
+				return withDevice(pci, err)
+			}
+			out := cmd.OutOrStdout()
+			from := res.From
+			if from == "" {
+				from = "no driver"
+			}
+			switch {
+			case res.DryRun && res.From != res.To:
+				fmt.Fprintf(out, "Would bind %s to %s (now %s)\n", pci, driver, from)
+				return nil
+			case !res.Changed:
+				fmt.Fprintf(out, "%s is already bound to %s\n", pci, driver)
+			default:
+				fmt.Fprintf(out, "Bound %s to %s (was %s)\n", pci, driver, from)
+			}
+			if noGenerate || dryRun {
+				return nil
+			}
+
+			name := deriveDefaultName(pci, "")
+			var dev *types.RdmaDevice
+			if driver != bind.VfioPCI {
+				dev = waitForRdmaDevice(discoverer, pci, timeout)
+			}
+			if dev == nil {
+				return removeDeviceSpec(cmd, filepath.Join(outputDir, cdi.SpecFileName(prefix, name, format)))
+			}
+			w := specWriter{prefix: prefix, outputDir: outputDir, format: format}
+			r := w.write(generateTarget{name: name, devices: []*types.RdmaDevice{dev}})
+			if r.err != nil {
+				return withDevice(pci, fmt.Errorf("CDI spec generation failed: %w", r.err))
+			}
+			recordGenerated(cmd, w, []generateResult{r})
+			return reportGenerate(out, "", []generateResult{r})
+		},
+	}
+
+	cmd.Flags().StringVar(&pci, "pci", "", "PCI BDF address of the function to rebind")
+	cmd.Flags().StringVar(&driver, "driver", "", "Driver to bind it to, e.g. vfio-pci or mlx5_core")
+	cmd.Flags().BoolVar(&force, "force", false, "Rebind even if the function is in use")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Run the checks and print what would be done")
+	cmd.Flags().BoolVar(&noGenerate, "no-generate", false, "Leave the function's CDI spec alone")
+	cmd.Flags().StringVar(&prefix, "prefix", cdi.DefaultPrefix, "CDI resource prefix of the spec")
+	cmd.Flags().StringVar(&outputDir, "output-dir", cdi.DefaultOutputDir, "Directory of the spec")
+	cmd.Flags().StringVar(&format, "format", "yaml", "Spec format (json|yaml)")
+	cmd.Flags().StringVar(&procRoot, "proc-root", bind.DefaultProcRoot, "Where the host /proc is mounted, to find processes using the function")
+	cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Second, "How long to wait for the RDMA device after binding a kernel driver")
+	_ = cmd.MarkFlagRequired("pci")
+	_ = cmd.MarkFlagRequired("driver")
+
+	return cmd
+}
+
+// waitForRdmaDevice polls for the RDMA device of pci, which the driver
+// registers shortly after binding, and returns nil if none shows up
+// within timeout.
+func waitForRdmaDevice(discoverer types.RdmaDeviceDiscoverer, pci string, timeout time.Duration) *types.RdmaDevice {
+	deadline := time.Now().Add(timeout)
+	for {
+		dev, err := discoverer.DiscoverByPCI(pci)
+		if err == nil {
+			return dev
+		}
+		if time.Now().After(deadline) {
+			log.Warnf("no RDMA device on %s: %v", pci, err)
+			return nil
+		}
+		time.Sleep(bindPollInterval)
+	}
+}
+
+// removeDeviceSpec removes a spec that no longer describes an RDMA device,
+// unless it was modified since generate wrote it.
+func removeDeviceSpec(cmd *cobra.Command, path string) error {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	statePath, _ := cmd.Flags().GetString("state-file")
+	state, err := cdi.LoadState(statePath)
+	if err != nil {
+		return err
+	}
+	if keep, _ := dropModified(state, []string{path}); len(keep) == 0 {
+		return nil
+	}
+	store := &cdi.DirStore{Dir: filepath.Dir(path), StateFile: statePath}
+	if err := store.Remove(path); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Removed CDI spec %s\n", path)
+	return nil
+}
 
+// ──────────────────────────────────────────────
+//  netns
+// ──────────────────────────────────────────────
+
+func newNetnsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "netns",
+		Short: "Manage the RDMA subsystem netns mode",
+	}
+	cmd.AddCommand(newNetnsSetCmd())
 	return cmd
 }
 
+func newNetnsSetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "set exclusive|shared",
+		Short: "Set the RDMA subsystem netns mode (requires root)",
+		Long: "Switch the RDMA subsystem netns mode via RDMA netlink, like 'rdma system set netns'.\n" +
+			"In exclusive mode each RDMA device belongs to one network namespace, so containers only\n" +
+			"see the devices moved into theirs. The kernel refuses the change while network namespaces\n" +
+			"other than the initial one exist, so run it at boot before containers start. The mode is\n" +
+			"not persistent; set the ib_core netns_mode module parameter to keep it across reboots.",
+		Args:      usageArgs(cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs)),
+		ValidArgs: []string{rdma.NetnsModeExclusive, rdma.NetnsModeShared},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := preflight.Check(preflight.Capability(unix.CAP_NET_ADMIN, "to change the RDMA netns mode")); err != nil {
+				return err
+			}
+			if err := rdma.SetNetnsMode(args[0]); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "RDMA netns mode: %s\n", args[0])
+			return nil
+		},
+	}
+}
+
 // ──────────────────────────────────────────────
 //  version
 // ──────────────────────────────────────────────
@@ -397,6 +3591,522 @@ func newVersionCmd() *cobra.Command {
 //  helpers
 // ──────────────────────────────────────────────
 
+// useBundle opens the support bundle at path and points the discovery
+// flags of cmd at its snapshot. Callers must Close the snapshot.
+func useBundle(cmd *cobra.Command, path string) (*bundle.Snapshot, error) {
+	snap, err := bundle.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range map[string]string{
+		"sysfs-root": snap.SysfsRoot,
+		"dev-root":   snap.DevRoot,
+		"backend":    string(rdma.BackendSysfs),
+	} {
+		if err := cmd.Flags().Set(name, value); err != nil {
+			snap.Close()
+			return nil, err
+		}
+	}
+	log.Infof("Using support bundle %s (rdma-cdi %s, collected %s)", path, snap.Manifest.Version, snap.Manifest.Created.Format(time.RFC3339))
+	return snap, nil
+}
+
+// readInventory reads the device inventory at path, or stdin for "-".
+func readInventory(cmd *cobra.Command, path string) (*rdma.Inventory, error) {
+	r := cmd.InOrStdin()
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, &usageError{err}
+		}
+		defer f.Close()
+		r = f
+	}
+	inv, err := rdma.ReadInventory(r)
+	if err != nil {
+		return nil, &usageError{fmt.Errorf("%s: %w", path, err)}
+	}
+	return inv, nil
+}
+
+// bundleOptions returns the discovery options for snap, if any.
+func bundleOptions(snap *bundle.Snapshot) []rdma.Option {
+	if snap == nil {
+		return nil
+	}
+	return []rdma.Option{rdma.WithOffline(), rdma.WithNetnsMode(snap.Manifest.NetnsMode)}
+}
+
+// selfBinary returns binary, or when empty the resolved path of the
+// running executable, for commands that install rules invoking rdma-cdi.
+func selfBinary(binary string) (string, error) {
+	if binary != "" {
+		return binary, nil
+	}
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot locate the rdma-cdi binary (use --binary): %w", err)
+	}
+	return exe, nil
+}
+
+// changedFlagArgs renders the flags set on the command line as arguments
+// that reproduce them, e.g. "--host-root=/host".
+func changedFlagArgs(flags *pflag.FlagSet) []string {
+	var args []string
+	flags.VisitAll(func(f *pflag.Flag) {
+		if !f.Changed {
+			return
+		}
+		value := f.Value.String()
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			value = strings.Join(sv.GetSlice(), ",")
+		}
+		if f.Value.Type() == "bool" && value == "true" {
+			args = append(args, "--"+f.Name)
+			return
+		}
+		args = append(args, "--"+f.Name+"="+value)
+	})
+	return args
+}
+
+// deviceSelection holds the device selection flags of generate, doctor and
+// discover: repeatable --pci and --ifname naming devices, and --selector
+// filtering them (or, without names, every device).
+type deviceSelection struct {
+	pci      []string
+	ifname   []string
+	selector []string
+}
+
+func (s *deviceSelection) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&s.pci, "pci", nil, "PCI BDF address (repeatable)")
+	cmd.Flags().StringSliceVar(&s.ifname, "ifname", nil, "Network interface name (repeatable)")
+	addSelectorFlag(cmd, &s.selector, "Only devices matching")
+}
+
+// addSelectorFlag adds the --selector flag, for the selector.Parse syntax
+// shared by every subcommand that selects devices.
+func addSelectorFlag(cmd *cobra.Command, p *[]string, what string) {
+	cmd.Flags().StringSliceVar(p, "selector", nil, what+" key=value or key!=value terms, values being globs (keys: "+
+		strings.Join(selector.Keys, ", ")+"; e.g. vendor=15b3,numa=1,linktype!=infiniband)")
+}
+
+// parseSelector parses --selector expressions, failing with a usage error.
+func parseSelector(exprs []string) (selector.Selector, error) {
+	sel, err := selector.Parse(exprs...)
+	if err != nil {
+		return sel, &usageError{err}
+	}
+	return sel, nil
+}
+
+// named reports whether devices were named with --pci or --ifname.
+func (s *deviceSelection) named() bool {
+	return len(s.pci) > 0 || len(s.ifname) > 0
+}
+
+// filtered reports whether anything narrows the selection to less than
+// every device.
+func (s *deviceSelection) filtered() bool {
+	return s.named() || len(s.selector) > 0
+}
+
+// single returns the one device named, if exactly one was, for error
+// reporting.
+func (s *deviceSelection) single() string {
+	switch {
+	case len(s.pci) == 1 && len(s.ifname) == 0:
+		return s.pci[0]
+	case len(s.pci) == 0 && len(s.ifname) == 1:
+		return s.ifname[0]
+	}
+	return ""
+}
+
+// parse returns the selection as an rdmacdi.Selector.
+func (s *deviceSelection) parse() (rdmacdi.Selector, error) {
+	match, err := parseSelector(s.selector)
+	if err != nil {
+		return rdmacdi.Selector{}, err
+	}
+	return rdmacdi.Selector{PciAddresses: s.pci, IfNames: s.ifname, Match: match}, nil
+}
+
+// discover returns the selected devices.
+func (s *deviceSelection) discover(cmd *cobra.Command, discoverer types.RdmaDeviceDiscoverer) ([]*types.RdmaDevice, error) {
+	sel, err := s.parse()
+	if err != nil {
+		return nil, err
+	}
+	devices, err := rdmacdi.Select(cmd.Context(), discoverer, sel)
+	if err != nil {
+		return nil, withDevice(s.single(), fmt.Errorf("device discovery failed: %w", err))
+	}
+	return devices, nil
+}
+
+// deviceIDFormat returns how --device-id-format names devices, validated
+// in PersistentPreRunE.
+func deviceIDFormat(cmd *cobra.Command) types.DeviceIDFormat {
+	s, _ := cmd.Flags().GetString("device-id-format")
+	f, err := types.ParseDeviceIDFormat(s)
+	if err != nil {
+		return types.DeviceIDAuto
+	}
+	return f
+}
+
+// enabledPlugins returns the plugins named by --plugins, which
+// PersistentPreRunE has checked are registered.
+func enabledPlugins(cmd *cobra.Command) []plugin.Plugin {
+	names, _ := cmd.Flags().GetStringSlice("plugins")
+	plugins, _ := plugin.Lookup(names...)
+	return plugins
+}
+
+// newDiscoverer builds a discoverer honoring the global discovery flags.
+// Flags are validated in the root PersistentPreRunE.
+func newDiscoverer(cmd *cobra.Command, opts ...rdma.Option) *rdma.Discoverer {
+	backend, _ := cmd.Flags().GetString("backend")
+	b, _ := rdma.ParseBackend(backend)
+	sysfsRoot, devRoot := hostRoots(cmd)
+	base := []rdma.Option{
+		rdma.WithBackend(b), rdma.WithSysfsRoot(sysfsRoot), rdma.WithDevRoot(devRoot),
+		rdma.WithProgress(newProgress(cmd, "discover").report),
+	}
+	if timeout, _ := cmd.Flags().GetDuration("device-timeout"); timeout > 0 {
+		base = append(base, rdma.WithDeviceTimeout(timeout))
+	}
+	if noCollapse, _ := cmd.Flags().GetBool("no-lag-collapse"); noCollapse {
+		base = append(base, rdma.WithoutLAGCollapse())
+	}
+	if plugins := enabledPlugins(cmd); len(plugins) > 0 {
+		base = append(base, rdma.WithPlugins(plugins...))
+	}
+	if required, ok := requiredDevicesPolicy(cmd); ok {
+		base = append(base, rdma.WithRequiredDevices(required...))
+	}
+	if sysfsRoot != rdma.DefaultSysfsRoot {
+		// Prefer the host's pci.ids over the container image's
+		hostRoot := filepath.Dir(sysfsRoot)
+		var paths []string
+		for _, p := range pciids.DefaultPaths {
+			paths = append(paths, filepath.Join(hostRoot, p))
+		}
+		base = append(base, rdma.WithPCIIDs(pciids.Load(append(paths, pciids.DefaultPaths...)...)))
+	}
+	return rdma.NewDiscoverer(append(base, opts...)...)
+}
+
+// progressInterval is how often progress is logged when stderr is not a
+// terminal.
+const progressInterval = 5 * time.Second
+
+// progress reports how far a command working through many devices has
+// got: as a status line rewritten in place when stderr is a terminal, and
+// otherwise as an info log line every progressInterval, so that hosts with
+// dozens of VFs do not look hung. It is safe for concurrent use.
+type progress struct {
+	mu    sync.Mutex
+	w     io.Writer
+	tty   bool
+	what  string
+	done  int
+	last  time.Time
+	shown bool
+}
+
+// newProgress returns a progress reporter for the given operation, writing
+// to cmd's stderr. --quiet turns the status line off.
+func newProgress(cmd *cobra.Command, what string) *progress {
+	w := cmd.ErrOrStderr()
+	quiet, _ := cmd.Flags().GetBool("quiet")
+	return &progress{w: w, tty: !quiet && utils.IsTerminal(w), what: what, last: time.Now()}
+}
+
+// report records that done of total devices are complete, the last being
+// item. It matches rdma.ProgressFunc.
+func (p *progress) report(done, total int, item string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.render(done, total, item)
+}
+
+// step records one more of total devices, item, as complete.
+func (p *progress) step(total int, item string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.render(p.done, total, item)
+}
+
+// finish clears the status line, if one is shown.
+func (p *progress) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+}
+
+func (p *progress) render(done, total int, item string) {
+	if p.tty {
+		fmt.Fprintf(p.w, "\r\033[K%s: %d/%d %s", p.what, done, total, item)
+		p.shown = true
+		if done == total {
+			p.clear()
+		}
+		return
+	}
+	if now := time.Now(); now.Sub(p.last) >= progressInterval {
+		p.last = now
+		log.Infof("%s: %d/%d devices done, last %s", p.what, done, total, item)
+	}
+}
+
+func (p *progress) clear() {
+	if p.shown {
+		fmt.Fprint(p.w, "\r\033[K")
+		p.shown = false
+	}
+}
+
+// hostRoots returns where the host sysfs and /dev are to be read. An
+// explicit --sysfs-root or --dev-root wins; otherwise both sit under
+// --host-root (or $RDMA_CDI_HOST_ROOT), with "auto" choosing /host when it
+// holds the host's sysfs.
+func hostRoots(cmd *cobra.Command) (sysfsRoot, devRoot string) {
+	sysfsRoot, _ = cmd.Flags().GetString("sysfs-root")
+	devRoot, _ = cmd.Flags().GetString("dev-root")
+
+	prefix, _ := cmd.Flags().GetString("host-root")
+	if f := cmd.Flags().Lookup("host-root"); f == nil || !f.Changed {
+		prefix = os.Getenv(envHostRoot)
+	}
+	if prefix == hostRootAuto {
+		prefix = ""
+		if _, err := os.Stat(filepath.Join(autoHostRoot, "sys", "bus", "pci")); err == nil {
+			prefix = autoHostRoot
+		}
+	}
+	if prefix == "" {
+		return sysfsRoot, devRoot
+	}
+	if f := cmd.Flags().Lookup("sysfs-root"); f == nil || !f.Changed {
+		sysfsRoot = filepath.Join(prefix, rdma.DefaultSysfsRoot)
+	}
+	if f := cmd.Flags().Lookup("dev-root"); f == nil || !f.Changed {
+		devRoot = filepath.Join(prefix, rdma.DefaultDevRoot)
+	}
+	return sysfsRoot, devRoot
+}
+
+// requiredDevicesPolicy returns the user's required device types from
+// --require-devices or the environment. ok is false when neither is set and
+// the per-driver vendor profile applies.
+func requiredDevicesPolicy(cmd *cobra.Command) (required []string, ok bool) {
+	if f := cmd.Flags().Lookup("require-devices"); f != nil && f.Changed {
+		required, _ = cmd.Flags().GetStringSlice("require-devices")
+		return required, true
+	}
+	v, ok := os.LookupEnv(envRequireDevices)
+	if !ok {
+		return nil, false
+	}
+	for _, t := range strings.Split(v, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			required = append(required, t)
+		}
+	}
+	return required, true
+}
+
+// expectedNetnsMode returns the RDMA netns mode the host is meant to be in,
+// from --expected-netns-mode or the environment, or "" when neither is set.
+func expectedNetnsMode(cmd *cobra.Command) (string, error) {
+	mode, ok := os.Getenv(envExpectedNetnsMode), false
+	if f := cmd.Flags().Lookup("expected-netns-mode"); f != nil && f.Changed {
+		mode, ok = f.Value.String(), true
+	}
+	switch mode {
+	case "", rdma.NetnsModeShared, rdma.NetnsModeExclusive:
+		return mode, nil
+	}
+	if ok {
+		return "", usageErrorf("invalid --expected-netns-mode %q: want shared or exclusive", mode)
+	}
+	return "", usageErrorf("invalid $%s %q: want shared or exclusive", envExpectedNetnsMode, mode)
+}
+
+// errChecksFailed is returned by doctor when a check failed, or a warning
+// was reported under --strict.
+var errChecksFailed = errors.New("diagnostics failed")
+
+// errPartialFailure is returned when a command acting on several spec
+// files got through some of them but not all.
+var errPartialFailure = errors.New("partial failure")
+
+// usageError marks an invalid invocation: unknown flags or subcommands,
+// conflicting or malformed flag values, or arguments that fail validation.
+type usageError struct {
+	err error
+}
+
+// usageErrorf formats an error as with fmt.Errorf and marks it as a usage error.
+func usageErrorf(format string, args ...any) error {
+	return &usageError{fmt.Errorf(format, args...)}
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *usageError) Unwrap() error {
+	return e.err
+}
+
+// usageArgs marks the errors of a positional argument validator as usage
+// errors.
+func usageArgs(validate cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := validate(cmd, args); err != nil {
+			return &usageError{err}
+		}
+		return nil
+	}
+}
+
+// execute runs the command tree and returns the command that ran. Errors
+// cobra raises before a subcommand is found, such as an unknown
+// subcommand, are marked as usage errors.
+func execute(root *cobra.Command) (*cobra.Command, error) {
+	cmd, err := root.ExecuteC()
+	if err != nil && cmd == root && !errors.As(err, new(*usageError)) {
+		err = &usageError{err}
+	}
+	return cmd, err
+}
+
+// Values of --error-format.
+const (
+	errorFormatText = "text"
+	errorFormatJSON = "json"
+)
+
+// deviceError attributes an error to the device (PCI address or interface
+// name) it concerns, for --error-format json.
+type deviceError struct {
+	device string
+	err    error
+}
+
+// withDevice attributes err to device; it returns err unchanged if device
+// is empty.
+func withDevice(device string, err error) error {
+	if device == "" {
+		return err
+	}
+	return &deviceError{device: device, err: err}
+}
+
+func (e *deviceError) Error() string {
+	return e.err.Error()
+}
+
+func (e *deviceError) Unwrap() error {
+	return e.err
+}
+
+// errorReport is the structured form of a failure printed by
+// --error-format json.
+type errorReport struct {
+	// Code is the process exit code.
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	// Device is the PCI address or interface name the failure concerns,
+	// when it concerns a single one.
+	Device string `json:"device,omitempty"`
+	// Hint suggests what to do about the failure.
+	Hint string `json:"hint,omitempty"`
+}
+
+// newErrorReport describes err, returned by cmd, as an errorReport.
+func newErrorReport(cmd *cobra.Command, err error) errorReport {
+	r := errorReport{Code: exitCodeFor(err), Message: err.Error()}
+
+	var de *deviceError
+	var be *batchError
+	switch {
+	case errors.As(err, &de):
+		r.Device = de.device
+	case errors.As(err, &be) && len(be.failures) == 1:
+		r.Device = be.failures[0].label()
+	}
+
+	switch {
+	case errors.As(err, &be):
+		r.Hint = "rerun with --summary json for per-device results"
+	case errors.Is(err, errPartialFailure):
+		r.Hint = "rerun with --output json for per-file results"
+	case errors.Is(err, errChecksFailed):
+		r.Hint = "fix the checks reported as failed (or warned, with --strict)"
+	case errors.Is(err, rdma.ErrMissingRequiredDevice):
+		r.Hint = "load the missing kernel modules, or relax --require-devices"
+	case errors.Is(err, rdma.ErrNoRdmaDevices):
+		r.Hint = "check that RDMA drivers are loaded; run rdma-cdi doctor"
+	case errors.Is(err, rdma.ErrDeviceNotFound):
+		r.Hint = "run rdma-cdi discover to list available devices"
+	case errors.Is(err, cdi.ErrUnresolvedDevice):
+		r.Hint = "run rdma-cdi list to see installed CDI devices"
+	case errors.As(err, new(*usageError)) && cmd != nil:
+		r.Hint = fmt.Sprintf("see %s --help", cmd.CommandPath())
+	}
+	return r
+}
+
+// printError reports err on w in the given --error-format. An unknown
+// format falls back to text.
+func printError(w io.Writer, format string, cmd *cobra.Command, err error) {
+	if format == errorFormatJSON {
+		if data, jerr := json.Marshal(newErrorReport(cmd, err)); jerr == nil {
+			fmt.Fprintln(w, string(data))
+			return
+		}
+	}
+	fmt.Fprintln(w, err)
+}
+
+// exitCodeFor maps an error returned by a subcommand to a process exit code.
+func exitCodeFor(err error) int {
+	var be *batchError
+	switch {
+	case err == nil:
+		return exitOK
+	// batchError wraps the per-device errors, so it must come first; with
+	// nothing generated, the failure is not partial
+	case errors.As(err, &be):
+		if be.succeeded == 0 {
+			return exitRuntimeError
+		}
+		return exitPartialFailure
+	case errors.Is(err, errPartialFailure):
+		return exitPartialFailure
+	case errors.As(err, new(*usageError)),
+		errors.Is(err, rdma.ErrDeviceNotFound), errors.Is(err, cdi.ErrUnresolvedDevice):
+		return exitUsage
+	case errors.Is(err, errChecksFailed), errors.Is(err, rdma.ErrMissingRequiredDevice):
+		return exitUnhealthy
+	case errors.Is(err, rdma.ErrNoRdmaDevices):
+		return exitNoDevices
+	default:
+		return exitRuntimeError
+	}
+}
+
 // deriveDefaultName builds a default resource name from the locator flags.
 func deriveDefaultName(pci, ifname string) string {
 	if ifname != "" {
@@ -2,8 +2,31 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/Nativu5/rdma-cdi/pkg/bind"
+	"github.com/Nativu5/rdma-cdi/pkg/cdi"
+	"github.com/Nativu5/rdma-cdi/pkg/doctor"
+	"github.com/Nativu5/rdma-cdi/pkg/rdma"
+	"github.com/Nativu5/rdma-cdi/pkg/rdma/rdmatest"
+	"github.com/Nativu5/rdma-cdi/pkg/stats"
+	"github.com/Nativu5/rdma-cdi/pkg/types"
 )
 
 // ──────────────────────────────────────────────
@@ -36,6 +59,130 @@ func TestDeriveDefaultName(t *testing.T) {
 	}
 }
 
+// ──────────────────────────────────────────────
+//  exitCodeFor
+// ──────────────────────────────────────────────
+
+func TestExitCodeFor(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, exitOK},
+		{"generic", errors.New("boom"), exitRuntimeError},
+		{"no_devices", fmt.Errorf("discovery failed: %w", rdma.ErrNoRdmaDevices), exitNoDevices},
+		{"not_found", fmt.Errorf("discovery failed: %w", rdma.ErrDeviceNotFound), exitUsage},
+		{"unresolved", fmt.Errorf("explain: %w", cdi.ErrUnresolvedDevice), exitUsage},
+		{"incomplete", fmt.Errorf("discovery failed: %w", rdma.ErrMissingRequiredDevice), exitUnhealthy},
+		{"batch", &batchError{failures: []generateResult{{PciAddress: "0000:17:00.0", err: rdma.ErrDeviceNotFound}}, succeeded: 1}, exitPartialFailure},
+		{"batch_all_failed", &batchError{failures: []generateResult{{PciAddress: "0000:17:00.0", err: rdma.ErrDeviceNotFound}}}, exitRuntimeError},
+		{"partial", fmt.Errorf("%w: 2 spec file(s) could not be removed", errPartialFailure), exitPartialFailure},
+		{"usage", usageErrorf("unsupported output format %q", "xml"), exitUsage},
+		{"checks_failed", fmt.Errorf("%w: see the report above", errChecksFailed), exitUnhealthy},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := exitCodeFor(tc.err); got != tc.want {
+				t.Errorf("exitCodeFor(%v) = %d, want %d", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExecute_UsageErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"unknown_command", []string{"bogus"}},
+		{"unknown_flag", []string{"discover", "--bogus"}},
+		{"bad_flag_value", []string{"generate", "--jobs", "x"}},
+		{"exclusive_flags", []string{"generate", "--all", "--ifname", "ib0"}},
+		{"missing_flag", []string{"generate"}},
+		{"bad_output", []string{"list", "--output", "xml"}},
+		{"bad_log_level", []string{"--log-level", "loud", "version"}},
+		{"extra_args", []string{"explain"}},
+		{"bad_netns_mode", []string{"netns", "set", "private"}},
+		{"bad_stats_interval", []string{"stats", "--watch", "--interval", "0s"}},
+		{"textfile_without_prometheus", []string{"stats", "--textfile-dir", "/tmp"}},
+		{"bench_without_device", []string{"bench"}},
+		{"bad_export_group", []string{"export", "shared-dev-plugin", "--group-by", "numa", "--sysfs-root", "/nonexistent"}},
+		{"bad_selector", []string{"discover", "--selector", "color=blue", "--sysfs-root", "/nonexistent"}},
+		{"bad_cleanup_selector", []string{"cleanup", "--selector", "numa", "--dry-run"}},
+		{"name_with_two_devices", []string{"generate", "--pci", "0000:17:00.0", "--pci", "0000:41:00.0", "--name", "x"}},
+		{"bad_mapping_output", []string{"mapping", "--output", "yaml", "--sysfs-root", "/nonexistent"}},
+		{"bind_without_driver", []string{"bind", "--pci", "0000:17:00.2"}},
+		{"bind_bad_pci", []string{"bind", "--pci", "17:00", "--driver", "vfio-pci"}},
+		{"bad_device_id_format", []string{"--device-id-format", "guid", "version"}},
+		{"negative_lock_timeout", []string{"--lock-timeout", "-1s", "version"}},
+		{"bad_expected_netns_mode", []string{"doctor", "--expected-netns-mode", "private"}},
+		{"doctor_two_sources", []string{"doctor", "--from-file", "devices.json", "--from-bundle", "bundle.tar.gz"}},
+		{"doctor_missing_inventory", []string{"doctor", "--from-file", "/nonexistent/devices.json"}},
+		{"guard_condition_without_events", []string{"guard", "--node-condition", "RDMAHealthy"}},
+		{"guard_events_without_node", []string{"guard", "--k8s-events", "--node-name", ""}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			root := rootCmd()
+			root.SetArgs(tc.args)
+			root.SetOut(io.Discard)
+			root.SetErr(io.Discard)
+			_, err := execute(root)
+			if got := exitCodeFor(err); got != exitUsage {
+				t.Errorf("exit code = %d, want %d (err %v)", got, exitUsage, err)
+			}
+		})
+	}
+}
+
+func TestPrintError(t *testing.T) {
+	notFound := withDevice("0000:41:00.0", fmt.Errorf("discovery failed: %w", rdma.ErrDeviceNotFound))
+	tests := []struct {
+		name string
+		err  error
+		want errorReport
+	}{
+		{"device", notFound, errorReport{Code: exitUsage, Message: notFound.Error(), Device: "0000:41:00.0", Hint: "run rdma-cdi discover to list available devices"}},
+		{"usage", usageErrorf("--group-by requires --all"), errorReport{Code: exitUsage, Message: "--group-by requires --all", Hint: "see rdma-cdi generate --help"}},
+		{"batch_single", &batchError{failures: []generateResult{{PciAddress: "0000:17:00.0", Error: "boom"}}, succeeded: 1},
+			errorReport{Code: exitPartialFailure, Message: "1 device(s) failed to generate\n  0000:17:00.0: boom", Device: "0000:17:00.0", Hint: "rerun with --summary json for per-device results"}},
+		{"generic", errors.New("boom"), errorReport{Code: exitRuntimeError, Message: "boom"}},
+	}
+
+	cmd, _, _ := rootCmd().Find([]string{"generate"})
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			printError(&buf, errorFormatJSON, cmd, tc.err)
+			var got errorReport
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+			}
+			if got != tc.want {
+				t.Errorf("report = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	printError(&buf, errorFormatText, cmd, notFound)
+	if buf.String() != notFound.Error()+"\n" {
+		t.Errorf("text output = %q", buf.String())
+	}
+}
+
+func TestExecute_ErrorFormatInvalid(t *testing.T) {
+	root := rootCmd()
+	root.SetArgs([]string{"--error-format", "xml", "version"})
+	root.SetOut(io.Discard)
+	if _, err := execute(root); exitCodeFor(err) != exitUsage {
+		t.Errorf("expected a usage error, got %v", err)
+	}
+}
+
 // ──────────────────────────────────────────────
 //  rootCmd structure
 // ──────────────────────────────────────────────
@@ -47,7 +194,21 @@ func TestRootCmd_HasAllSubcommands(t *testing.T) {
 		"generate": false,
 		"discover": false,
 		"doctor":   false,
+		"stats":    false,
+		"bench":    false,
+		"collect":  false,
 		"cleanup":  false,
+		"restore":  false,
+		"list":     false,
+		"explain":  false,
+		"migrate":  false,
+		"guard":    false,
+		"udev":     false,
+		"systemd":  false,
+		"export":   false,
+		"mapping":  false,
+		"bind":     false,
+		"netns":    false,
 		"version":  false,
 	}
 
@@ -71,7 +232,7 @@ func TestRootCmd_HasAllSubcommands(t *testing.T) {
 func TestGenerateCmd_Flags(t *testing.T) {
 	cmd := newGenerateCmd()
 
-	requiredFlags := []string{"all", "pci", "ifname", "prefix", "name", "output-dir", "format"}
+	requiredFlags := []string{"all", "pci", "ifname", "prefix", "name", "output-dir", "format", "file-mode", "file-owner", "timestamp", "force", "device-numbers", "preserve-node-identity", "per-port", "aliases", "sysfs-mounts", "device-annotations", "rdma-cm", "permissions", "container-dir", "device-types", "include-devices", "jobs", "summary", "fail-fast", "group-by", "dry-run"}
 	for _, flag := range requiredFlags {
 		if cmd.Flags().Lookup(flag) == nil {
 			t.Errorf("generate command missing flag: --%s", flag)
@@ -91,8 +252,16 @@ func TestGenerateCmd_DefaultValues(t *testing.T) {
 		{"output-dir", "/etc/cdi"},
 		{"format", "yaml"},
 		{"name", ""},
-		{"pci", ""},
-		{"ifname", ""},
+		{"pci", "[]"},
+		{"ifname", "[]"},
+		{"device-numbers", "false"},
+		{"preserve-node-identity", "false"},
+		{"per-port", "false"},
+		{"permissions", "rw"},
+		{"container-dir", ""},
+		{"device-types", "[]"},
+		{"summary", ""},
+		{"rdma-cm", "per-device"},
 	}
 
 	for _, tc := range tests {
@@ -137,7 +306,7 @@ func TestDiscoverCmd_Flags(t *testing.T) {
 func TestDoctorCmd_Flags(t *testing.T) {
 	cmd := newDoctorCmd()
 
-	flags := []string{"all", "pci", "ifname", "strict", "show-pass", "output"}
+	flags := []string{"all", "pci", "ifname", "strict", "show-pass", "output", "min-kernel"}
 	for _, flag := range flags {
 		if cmd.Flags().Lookup(flag) == nil {
 			t.Errorf("doctor command missing flag: --%s", flag)
@@ -164,7 +333,7 @@ func TestDoctorCmd_Flags(t *testing.T) {
 func TestCleanupCmd_Flags(t *testing.T) {
 	cmd := newCleanupCmd()
 
-	flags := []string{"prefix", "name", "output-dir", "dry-run", "force"}
+	flags := []string{"prefix", "name", "output-dir", "all-dirs", "dry-run", "force", "orphans", "backup-dir", "no-backup", "name-glob", "older-than", "output"}
 	for _, flag := range flags {
 		if cmd.Flags().Lookup(flag) == nil {
 			t.Errorf("cleanup command missing flag: --%s", flag)
@@ -178,119 +347,2077 @@ func TestCleanupCmd_Flags(t *testing.T) {
 	}
 }
 
-// ──────────────────────────────────────────────
-//  XOR validation (simulate via rootCmd)
-// ──────────────────────────────────────────────
+func TestFindOrphans(t *testing.T) {
+	dir := t.TempDir()
+	for pci, name := range map[string]string{"0000:17:00.0": "pf", "0000:17:00.2": "vf"} {
+		dev := types.RdmaDevice{
+			PciAddress:  pci,
+			DeviceSpecs: []types.DeviceSpec{{HostPath: "/dev/infiniband/uverbs0", ContainerPath: "/dev/infiniband/uverbs0", Permissions: "rw"}},
+		}
+		if err := cdi.CreateCDISpec("rdma", name, []types.RdmaDevice{dev}, dir, "yaml"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fake := rdmatest.NewFakeDiscoverer(&types.RdmaDevice{PciAddress: "0000:17:00.0"})
+	orphans, err := findOrphans(fake, []string{dir}, "rdma", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 || !strings.HasSuffix(orphans[0], "rdma-cdi_rdma_vf.yaml") {
+		t.Errorf("expected only the VF spec as orphan, got %v", orphans)
+	}
+
+	// No devices at all: every spec is an orphan
+	fake = &rdmatest.FakeDiscoverer{Err: rdma.ErrNoRdmaDevices}
+	orphans, err = findOrphans(fake, []string{dir}, "rdma", "")
+	if err != nil || len(orphans) != 2 {
+		t.Errorf("expected both specs as orphans with no devices, got %v, %v", orphans, err)
+	}
+}
+
+// seedSpecs writes two empty rdma-cdi spec files and returns the directory.
+func seedSpecs(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, f := range []string{"rdma-cdi_rdma_a.yaml", "rdma-cdi_rdma_b.json"} {
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func runCleanup(t *testing.T, tty bool, stdin string, args ...string) (string, error) {
+	t.Helper()
+	orig := stdoutIsTerminal
+	stdoutIsTerminal = func(io.Writer) bool { return tty }
+	t.Cleanup(func() { stdoutIsTerminal = orig })
 
-func TestGenerateCmd_NeitherPciNorIfname(t *testing.T) {
-	// Running generate without --pci, --ifname, or --all should produce an error.
 	root := rootCmd()
-	var stderr bytes.Buffer
-	root.SetErr(&stderr)
-	root.SetOut(&bytes.Buffer{})
-	root.SetArgs([]string{"generate"})
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetErr(&bytes.Buffer{})
+	root.SetIn(strings.NewReader(stdin))
+	if !slices.Contains(args, "--state-file") {
+		// Keep tests away from the host state file
+		args = append(args, "--state-file", filepath.Join(t.TempDir(), "state.json"))
+	}
+	root.SetArgs(append([]string{"cleanup"}, args...))
+	err := root.Execute()
+	return out.String(), err
+}
 
-	cmd := newGenerateCmd()
-	if cmd.Use != "generate" {
-		t.Errorf("expected Use=generate, got %q", cmd.Use)
+func TestCleanupCmd_SkipsModifiedSpecs(t *testing.T) {
+	dir := seedSpecs(t)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	a, b := filepath.Join(dir, "rdma-cdi_rdma_a.yaml"), filepath.Join(dir, "rdma-cdi_rdma_b.json")
+
+	state := &cdi.State{}
+	for _, f := range []string{a, b} {
+		if err := state.Record(f, "rdma/x", []string{"0000:17:00.0"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := state.Save(statePath); err != nil {
+		t.Fatal(err)
+	}
+	// Hand-edit one of them after generation
+	if err := os.WriteFile(b, []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runCleanup(t, false, "", "--output-dir", dir, "--force", "--no-backup", "--state-file", statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(a); !os.IsNotExist(err) {
+		t.Error("unmodified spec should have been removed")
+	}
+	if _, err := os.Stat(b); err != nil {
+		t.Error("modified spec should have been kept")
+	}
+	if !strings.Contains(out, "Kept (modified since generated): "+b) {
+		t.Errorf("expected modified file in output, got:\n%s", out)
+	}
+
+	// The removed file's record is dropped, the kept one stays
+	state, err = cdi.LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := state.Lookup(a); ok {
+		t.Error("record of removed spec should be forgotten")
+	}
+	if _, ok := state.Lookup(b); !ok {
+		t.Error("record of kept spec should remain")
+	}
+
+	if _, err := runCleanup(t, false, "", "--output-dir", dir, "--force", "--no-backup", "--include-modified", "--state-file", statePath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(b); !os.IsNotExist(err) {
+		t.Error("--include-modified should remove the modified spec")
 	}
 }
 
-func TestGenerateCmd_AllAndPciConflict(t *testing.T) {
+func TestListCmd_Installed(t *testing.T) {
+	dir := seedSpecs(t)
 	root := rootCmd()
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"list", "--output-dir", dir, "--output", "json"})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	var specs []struct {
+		Path  string `json:"path"`
+		Tool  string `json:"tool"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &specs); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, out.String())
+	}
+	// seedSpecs writes empty files, which parse as empty specs
+	if len(specs) != 2 || specs[0].Tool != cdi.FilePrefix {
+		t.Errorf("unexpected listing: %+v", specs)
+	}
+
+	root = rootCmd()
 	root.SetOut(&bytes.Buffer{})
 	root.SetErr(&bytes.Buffer{})
-	root.SetArgs([]string{"generate", "--all", "--pci", "0000:17:00.0"})
-	err := root.Execute()
-	if err == nil {
-		t.Error("expected error when --all and --pci are both set")
+	root.SetArgs([]string{"list", "--managed", "--output-dir", dir})
+	if err := root.Execute(); err == nil {
+		t.Error("--managed and --output-dir should be mutually exclusive")
 	}
 }
 
-func TestGenerateCmd_AllAndNameConflict(t *testing.T) {
+func TestListCmd_Managed(t *testing.T) {
+	dir := seedSpecs(t)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	state := &cdi.State{}
+	for _, f := range []string{"rdma-cdi_rdma_a.yaml", "rdma-cdi_rdma_b.json", "rdma-cdi_rdma_c.yaml"} {
+		p := filepath.Join(dir, f)
+		if err := os.WriteFile(p, []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := state.Record(p, "rdma/"+f, []string{"0000:17:00.0"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := state.Save(statePath); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "rdma-cdi_rdma_b.json"), []byte("edited"), 0644)
+	os.Remove(filepath.Join(dir, "rdma-cdi_rdma_c.yaml"))
+
+	root := rootCmd()
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"list", "--managed", "--output", "json", "--state-file", statePath})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	var specs []struct {
+		Path   string `json:"path"`
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &specs); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, out.String())
+	}
+	got := map[string]string{}
+	for _, s := range specs {
+		got[filepath.Base(s.Path)] = s.Status
+	}
+	want := map[string]string{"rdma-cdi_rdma_a.yaml": "ok", "rdma-cdi_rdma_b.json": "modified", "rdma-cdi_rdma_c.yaml": "missing"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+}
+
+func TestExplainCmd(t *testing.T) {
+	dir := t.TempDir()
+	spec := `cdiVersion: "0.5.0"
+kind: rdma/net
+devices:
+  - name: "0000:17:00.0"
+    containerEdits:
+      deviceNodes:
+        - path: /dev/infiniband/uverbs0
+`
+	if err := os.WriteFile(filepath.Join(dir, "rdma-cdi_rdma_net.yaml"), []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+
 	root := rootCmd()
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"explain", "rdma/net=0000:17:00.0", "--output-dir", dir})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "/dev/infiniband/uverbs0") {
+		t.Errorf("explain output missing device node:\n%s", out.String())
+	}
+
+	root = rootCmd()
 	root.SetOut(&bytes.Buffer{})
 	root.SetErr(&bytes.Buffer{})
-	root.SetArgs([]string{"generate", "--all", "--name", "mydev"})
+	root.SetArgs([]string{"explain", "rdma/net=0000:41:00.0", "--output-dir", dir})
 	err := root.Execute()
-	if err == nil {
-		t.Error("expected error when --all and --name are both set")
+	if got := exitCodeFor(err); got != exitUsage {
+		t.Errorf("unknown device: exit code %d, want %d (err %v)", got, exitUsage, err)
 	}
 }
 
-func TestDiscoverCmd_PciAndIfnameConflict(t *testing.T) {
-	// Verify the command accepts both flags (validation is at runtime)
-	cmd := newDiscoverCmd()
-	pci := cmd.Flags().Lookup("pci")
-	ifn := cmd.Flags().Lookup("ifname")
-	if pci == nil || ifn == nil {
-		t.Error("discover should have both --pci and --ifname flags")
+func TestMigrateCmd(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "rdma-cdi_rdma_pci-0000-17-00-0.yaml")
+	spec := "cdiVersion: \"0.5.0\"\nkind: rdma/0000-17-00-0\ndevices:\n  - name: \"0000:17:00.0\"\n    containerEdits:\n      deviceNodes:\n        - path: /dev/infiniband/uverbs0\n"
+	if err := os.WriteFile(old, []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	state := &cdi.State{}
+	state.Record(old, "rdma/0000-17-00-0", []string{"0000:17:00.0"})
+	state.Save(statePath)
+	backupDir := t.TempDir()
+
+	run := func(args ...string) string {
+		t.Helper()
+		root := rootCmd()
+		var out bytes.Buffer
+		root.SetOut(&out)
+		root.SetErr(&bytes.Buffer{})
+		root.SetArgs(append([]string{"migrate", "--output-dir", dir, "--backup-dir", backupDir, "--state-file", statePath}, args...))
+		if err := root.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	if out := run("--dry-run"); !strings.Contains(out, "Would migrate: "+old) {
+		t.Errorf("unexpected dry-run output:\n%s", out)
+	}
+	if _, err := os.Stat(old); err != nil {
+		t.Fatal("--dry-run must not touch the spec")
+	}
+
+	out := run()
+	migrated := filepath.Join(dir, "rdma-cdi_rdma_dev-0000-17-00-0.yaml")
+	if _, err := os.Stat(migrated); err != nil {
+		t.Fatalf("migrated spec missing: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Backup saved as") {
+		t.Errorf("expected a backup, got:\n%s", out)
+	}
+	if ids, _ := cdi.ListBackups(backupDir); len(ids) != 1 {
+		t.Errorf("backups = %v, want one", ids)
+	}
+	state, _ = cdi.LoadState(statePath)
+	if rec, ok := state.Lookup(migrated); !ok || rec.Kind != "rdma/dev-0000-17-00-0" {
+		t.Errorf("state not moved to the migrated spec: %+v", state.Specs)
+	}
+	if _, ok := state.Lookup(old); ok {
+		t.Error("old path still recorded")
+	}
+
+	if out := run(); !strings.Contains(out, "Up to date: "+migrated) {
+		t.Errorf("second run should find nothing to do:\n%s", out)
 	}
 }
 
-// ──────────────────────────────────────────────
-//  Help output
-// ──────────────────────────────────────────────
+func TestCleanupCmd_Confirmation(t *testing.T) {
+	tests := []struct {
+		name      string
+		tty       bool
+		stdin     string
+		args      []string
+		wantErr   string
+		wantKept  bool
+		wantInOut string
+	}{
+		{"non_tty_refuses", false, "", nil, "pass --force or --dry-run", true, ""},
+		{"non_tty_force", false, "", []string{"--force"}, "", false, "Removed:"},
+		{"non_tty_dry_run", false, "", []string{"--dry-run"}, "", true, "Would remove:"},
+		{"tty_declined", true, "n\n", nil, "", true, "Aborted."},
+		{"tty_empty_answer", true, "", nil, "", true, "Aborted."},
+		{"tty_confirmed", true, "yes\n", nil, "", false, "will be removed"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := seedSpecs(t)
+			out, err := runCleanup(t, tc.tty, tc.stdin, append([]string{"--output-dir", dir, "--backup-dir", t.TempDir()}, tc.args...)...)
 
-func TestRootCmd_HelpOutput(t *testing.T) {
-	root := rootCmd()
-	var buf bytes.Buffer
-	root.SetOut(&buf)
-	root.SetArgs([]string{"--help"})
-	_ = root.Execute()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(out, tc.wantInOut) {
+				t.Errorf("output %q should contain %q", out, tc.wantInOut)
+			}
+			_, statErr := os.Stat(filepath.Join(dir, "rdma-cdi_rdma_a.yaml"))
+			if kept := statErr == nil; kept != tc.wantKept {
+				t.Errorf("file kept = %v, want %v", kept, tc.wantKept)
+			}
+		})
+	}
+}
 
-	output := buf.String()
-	if !strings.Contains(output, "RDMA") {
-		t.Error("help output should contain tool description")
+func TestCleanupCmd_MultipleDirs(t *testing.T) {
+	static, transient := seedSpecs(t), seedSpecs(t)
+	out, err := runCleanup(t, false, "", "--output-dir", static, "--output-dir", transient, "--dry-run")
+	if err != nil {
+		t.Fatal(err)
 	}
-	for _, sub := range []string{"generate", "discover", "doctor", "cleanup"} {
-		if !strings.Contains(output, sub) {
-			t.Errorf("help output should list %q subcommand", sub)
+	for _, dir := range []string{static, transient} {
+		if !strings.Contains(out, filepath.Join(dir, "rdma-cdi_rdma_a.yaml")) {
+			t.Errorf("dry run should list specs in %s, got %q", dir, out)
 		}
 	}
+
+	if _, err := runCleanup(t, false, "", "--output-dir", static, "--all-dirs", "--dry-run"); err == nil {
+		t.Error("--output-dir and --all-dirs should be mutually exclusive")
+	}
 }
 
-// ──────────────────────────────────────────────
-//  --log-level flag
-// ──────────────────────────────────────────────
+func TestCleanupCmd_Selectors(t *testing.T) {
+	dir := seedSpecs(t)
+	out, err := runCleanup(t, false, "", "--output-dir", dir, "--name-glob", "a*", "--dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "rdma-cdi_rdma_a.yaml") || strings.Contains(out, "rdma-cdi_rdma_b.json") {
+		t.Errorf("--name-glob a* should select only spec a, got %q", out)
+	}
+
+	out, err = runCleanup(t, false, "", "--output-dir", dir, "--older-than", "720h", "--dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "No matching spec files found.") {
+		t.Errorf("fresh specs should not match --older-than, got %q", out)
+	}
+
+	if _, err := runCleanup(t, false, "", "--output-dir", dir, "--name-glob", "[", "--dry-run"); err == nil {
+		t.Error("malformed --name-glob should fail")
+	}
+}
+
+func TestCleanupCmd_JSONSummary(t *testing.T) {
+	dir := seedSpecs(t)
+	out, err := runCleanup(t, false, "", "--output-dir", dir, "--backup-dir", t.TempDir(), "--force", "--output", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var summary cdi.CleanupSummary
+	if err := json.Unmarshal([]byte(out), &summary); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, out)
+	}
+	if len(summary.Matched) != 2 || len(summary.Removed) != 2 || len(summary.Skipped) != 0 || summary.DryRun {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	if summary.BackupID == "" {
+		t.Error("summary should carry the backup ID")
+	}
+
+	// JSON mode never prompts
+	dir = seedSpecs(t)
+	if _, err := runCleanup(t, true, "y\n", "--output-dir", dir, "--output", "json"); err == nil {
+		t.Error("--output json without --force or --dry-run should fail")
+	}
+
+	out, err = runCleanup(t, false, "", "--output-dir", dir, "--output", "json", "--dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(out), &summary); err != nil || !summary.DryRun || len(summary.Skipped) != 2 {
+		t.Errorf("unexpected dry-run summary %+v (%v)", summary, err)
+	}
+}
+
+func TestCleanupAndRestore(t *testing.T) {
+	dir := seedSpecs(t)
+	backupDir := t.TempDir()
+
+	out, err := runCleanup(t, false, "", "--output-dir", dir, "--backup-dir", backupDir, "--force")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "rdma-cdi restore --from") {
+		t.Errorf("cleanup should print the restore hint, got %q", out)
+	}
 
-func TestRootCmd_LogLevelFlag(t *testing.T) {
 	root := rootCmd()
-	f := root.PersistentFlags().Lookup("log-level")
-	if f == nil {
-		t.Fatal("root command missing --log-level flag")
+	var buf bytes.Buffer
+	root.SetOut(&buf)
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"restore", "--from", "latest", "--backup-dir", backupDir})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("restore failed: %v", err)
 	}
-	if f.DefValue != "info" {
-		t.Errorf("--log-level default = %q, want 'info'", f.DefValue)
+	for _, f := range []string{"rdma-cdi_rdma_a.yaml", "rdma-cdi_rdma_b.json"} {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			t.Errorf("%s not restored: %v", f, err)
+		}
 	}
 }
 
-func TestRootCmd_LogLevelInvalid(t *testing.T) {
+func TestRestoreCmd_RequiresFrom(t *testing.T) {
 	root := rootCmd()
-	root.SetArgs([]string{"--log-level", "bogus", "discover", "--all"})
-	var stderr bytes.Buffer
-	root.SetErr(&stderr)
 	root.SetOut(&bytes.Buffer{})
-	err := root.Execute()
-	if err == nil {
-		t.Error("expected error for invalid log level, got nil")
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"restore", "--backup-dir", t.TempDir()})
+	if err := root.Execute(); err == nil || !strings.Contains(err.Error(), "--from is required") {
+		t.Errorf("expected --from error, got %v", err)
 	}
-	if !strings.Contains(err.Error(), "invalid log level") {
-		t.Errorf("expected 'invalid log level' in error, got: %v", err)
+}
+
+func TestSplitFailedDevices(t *testing.T) {
+	devices := []*types.RdmaDevice{
+		{PciAddress: "0000:17:00.0"},
+		{PciAddress: "0000:41:00.0", Error: "discovery of 0000:41:00.0 panicked: boom"},
+	}
+	ok, failed := splitFailedDevices(devices)
+	if len(ok) != 1 || ok[0].PciAddress != "0000:17:00.0" {
+		t.Errorf("ok = %+v, want 0000:17:00.0 only", ok)
+	}
+	if len(failed) != 1 || failed[0].PciAddress != "0000:41:00.0" || failed[0].Success ||
+		!strings.Contains(failed[0].Error, "panicked: boom") {
+		t.Fatalf("failed = %+v, want a failed result for 0000:41:00.0", failed)
+	}
+	if err := newBatchError(append([]generateResult{{PciAddress: "0000:17:00.0", Success: true}}, failed...)); exitCodeFor(err) != exitPartialFailure {
+		t.Errorf("exit code = %d, want %d", exitCodeFor(err), exitPartialFailure)
+	}
+	if err := newBatchError(failed); exitCodeFor(err) != exitRuntimeError {
+		t.Errorf("exit code with no device generated = %d, want %d", exitCodeFor(err), exitRuntimeError)
 	}
 }
 
-func TestRootCmd_LogLevelValid(t *testing.T) {
-	for _, level := range []string{"trace", "debug", "info", "warn", "error"} {
+func TestDoctorCmd_DeviceIDFormat(t *testing.T) {
+	tree := rdmatest.MustBuildTree(t, filepath.Join("..", "..", "pkg", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+	run := func(format string) []doctor.CheckResult {
+		t.Helper()
 		root := rootCmd()
-		root.SetArgs([]string{"--log-level", level, "--help"})
-		root.SetOut(&bytes.Buffer{})
-		if err := root.Execute(); err != nil {
-			t.Errorf("--log-level %s should be valid, got error: %v", level, err)
+		var out bytes.Buffer
+		root.SetOut(&out)
+		root.SetErr(&bytes.Buffer{})
+		root.SetArgs([]string{"--sysfs-root", tree.SysfsRoot, "--dev-root", tree.DevRoot, "--device-id-format", format,
+			"doctor", "--pci", "0000:17:00.0", "--show-pass", "--output", "json"})
+		_, _ = execute(root) // the fixture host fails the kernel module checks
+		var results []doctor.CheckResult
+		if err := json.Unmarshal(out.Bytes(), &results); err != nil {
+			t.Fatalf("%s: %v\n%s", format, err, out.String())
+		}
+		return results
+	}
+	for format, want := range map[string]string{
+		"auto":  "mlx5_0 (0000:17:00.0)",
+		"pci":   "0000:17:00.0",
+		"ibdev": "mlx5_0",
+	} {
+		var found bool
+		for _, r := range run(format) {
+			if r.Check == "rdma_devices" {
+				found = true
+				if r.Device != want || r.PciAddress != "0000:17:00.0" {
+					t.Errorf("%s: device = %q (pci_address %q), want %q", format, r.Device, r.PciAddress, want)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s: no rdma_devices result", format)
 		}
 	}
 }
 
+func TestDoctorListChecksCmd(t *testing.T) {
+	root := rootCmd()
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetArgs([]string{"doctor", "list-checks", "--output", "json"})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	var list struct {
+		SchemaVersion int                `json:"schema_version"`
+		Checks        []doctor.CheckInfo `json:"checks"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &list); err != nil {
+		t.Fatalf("%v\n%s", err, out.String())
+	}
+	ids := make([]string, 0, len(list.Checks))
+	for _, c := range list.Checks {
+		ids = append(ids, c.ID)
+	}
+	for _, want := range []string{"doctor.kernel_modules", "doctor.link.state", "bench.bandwidth"} {
+		if !slices.Contains(ids, want) {
+			t.Errorf("list-checks should list %s, got %v", want, ids)
+		}
+	}
+	if list.SchemaVersion != doctor.SchemaVersion {
+		t.Errorf("schema_version = %d, want %d", list.SchemaVersion, doctor.SchemaVersion)
+	}
+
+	root = rootCmd()
+	root.SetOut(io.Discard)
+	root.SetArgs([]string{"doctor", "list-checks", "--output", "yaml"})
+	if _, err := execute(root); exitCodeFor(err) != exitUsage {
+		t.Errorf("unsupported output should be a usage error, got %v", err)
+	}
+}
+
+func TestDoctorCmd_CacheLoadOfFreshSpecs(t *testing.T) {
+	if specs, _ := cdi.FindManagedSpecs(cdi.StandardSpecDirs()); len(specs) > 0 {
+		t.Skip("this host has generated specs, which doctor checks instead")
+	}
+	tree := rdmatest.MustBuildTree(t, filepath.Join("..", "..", "pkg", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+	root := rootCmd()
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"--sysfs-root", tree.SysfsRoot, "--dev-root", tree.DevRoot, "doctor", "--all", "--show-pass", "--output", "json"})
+	execute(root) // the fixture host fails other checks
+
+	var results []doctor.CheckResult
+	if err := json.Unmarshal(out.Bytes(), &results); err != nil {
+		t.Fatalf("%v\n%s", err, out.String())
+	}
+	for _, r := range results {
+		if r.ID == "doctor.spec.cache_load" {
+			if r.Severity != doctor.Pass || !strings.Contains(r.Message, "resolve in the CDI cache") {
+				t.Errorf("the specs generate would write should load: %+v", r)
+			}
+			return
+		}
+	}
+	t.Errorf("no cache load result in %s", out.String())
+}
+
+func TestDoctorCmd_Baseline(t *testing.T) {
+	tree := rdmatest.MustBuildTree(t, filepath.Join("..", "..", "pkg", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+	dir := t.TempDir()
+	run := func(args ...string) (string, error) {
+		t.Helper()
+		root := rootCmd()
+		var out bytes.Buffer
+		root.SetOut(&out)
+		root.SetErr(&bytes.Buffer{})
+		root.SetArgs(append([]string{"--sysfs-root", tree.SysfsRoot, "--dev-root", tree.DevRoot,
+			"doctor", "--pci", "0000:17:00.0"}, args...))
+		_, err := execute(root)
+		return out.String(), err
+	}
+
+	// The fixture host has problems of its own, e.g. no kernel modules
+	before, err := run("--output", "json")
+	if exitCodeFor(err) != exitUnhealthy {
+		t.Fatalf("exit code = %d, want %d (err %v)", exitCodeFor(err), exitUnhealthy, err)
+	}
+	accepted := filepath.Join(dir, "before.json")
+	if err := os.WriteFile(accepted, []byte(before), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := run("--baseline", accepted)
+	if err != nil {
+		t.Errorf("pre-existing problems should be accepted: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "No changes from the baseline.") {
+		t.Errorf("output should report no changes:\n%s", out)
+	}
+
+	clean := filepath.Join(dir, "clean.json")
+	if err := os.WriteFile(clean, []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err = run("--baseline", clean, "--output", "json")
+	if exitCodeFor(err) != exitUnhealthy {
+		t.Errorf("exit code = %d, want %d (err %v)", exitCodeFor(err), exitUnhealthy, err)
+	}
+	var compared struct {
+		Results     []doctor.CheckResult `json:"results"`
+		Regressions []doctor.Change      `json:"regressions"`
+	}
+	if err := json.Unmarshal([]byte(out), &compared); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if len(compared.Regressions) == 0 || len(compared.Results) == 0 {
+		t.Errorf("want the report and its regressions, got %+v", compared)
+	}
+
+	if _, err := run("--baseline", filepath.Join(dir, "missing.json")); exitCodeFor(err) != exitUsage {
+		t.Errorf("missing baseline: exit code = %d, want %d", exitCodeFor(err), exitUsage)
+	}
+}
+
+func TestDoctorCmd_FromFile(t *testing.T) {
+	tree := rdmatest.MustBuildTree(t, filepath.Join("..", "..", "pkg", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+	root := rootCmd()
+	var inventory bytes.Buffer
+	root.SetOut(&inventory)
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"--sysfs-root", tree.SysfsRoot, "--dev-root", tree.DevRoot, "discover", "--output", "json"})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "devices.json")
+	if err := os.WriteFile(path, inventory.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(stdin io.Reader, args ...string) ([]doctor.CheckResult, error) {
+		t.Helper()
+		root := rootCmd()
+		var out bytes.Buffer
+		root.SetIn(stdin)
+		root.SetOut(&out)
+		root.SetErr(&bytes.Buffer{})
+		root.SetArgs(append([]string{"doctor", "--show-pass", "--output", "json"}, args...))
+		_, err := execute(root)
+		var results []doctor.CheckResult
+		if err == nil {
+			if err := json.Unmarshal(out.Bytes(), &results); err != nil {
+				t.Fatalf("%v\n%s", err, out.String())
+			}
+		}
+		return results, err
+	}
+
+	// The fixture host lacks the kernel modules, which is not judged here
+	results, err := run(nil, "--from-file", path, "--pci", "0000:17:00.0")
+	if err != nil {
+		t.Fatalf("doctor --from-file: %v", err)
+	}
+	severities := make(map[string]doctor.Severity)
+	for _, r := range results {
+		if r.PciAddress != "0000:17:00.0" {
+			t.Errorf("unexpected result for another device or the host: %+v", r)
+		}
+		severities[r.Check] = r.Severity
+	}
+	for check, want := range map[string]doctor.Severity{
+		"rdma_devices":   doctor.Pass,
+		"net_interface":  doctor.Pass,
+		"device_nodes":   doctor.Skip,
+		"kernel_modules": doctor.Skip,
+		"link_attrs":     doctor.Skip,
+		"pcie_errors":    doctor.Skip,
+	} {
+		if severities[check] != want {
+			t.Errorf("%s = %q, want %s", check, severities[check], want)
+		}
+	}
+
+	results, err = run(bytes.NewReader(inventory.Bytes()), "--from-file", "-")
+	if err != nil || len(results) == 0 {
+		t.Errorf("doctor --from-file - = %d results, %v", len(results), err)
+	}
+
+	if _, err := run(strings.NewReader("{"), "--from-file", "-"); exitCodeFor(err) != exitUsage {
+		t.Errorf("malformed inventory: exit code = %d, want %d (err %v)", exitCodeFor(err), exitUsage, err)
+	}
+	if _, err := run(nil, "--from-file", path, "--pci", "0000:ff:00.0"); exitCodeFor(err) != exitUsage {
+		t.Errorf("device not in the inventory: exit code = %d, want %d (err %v)", exitCodeFor(err), exitUsage, err)
+	}
+}
+
+func TestCollectCmd_FromBundle(t *testing.T) {
+	tree := rdmatest.MustBuildTree(t, filepath.Join("..", "..", "pkg", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+	roots := []string{"--sysfs-root", tree.SysfsRoot, "--dev-root", tree.DevRoot}
+	bundlePath := filepath.Join(t.TempDir(), "bundle.tar.gz")
+
+	run := func(args ...string) string {
+		t.Helper()
+		root := rootCmd()
+		var out bytes.Buffer
+		root.SetOut(&out)
+		root.SetErr(&bytes.Buffer{})
+		root.SetArgs(args)
+		if err := root.Execute(); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		return out.String()
+	}
+
+	run(append(roots, "collect", "--output", bundlePath)...)
+	live := run(append(roots, "discover", "--output", "json")...)
+	offline := run("discover", "--from-bundle", bundlePath, "--output", "json")
+	// Netlink knows nothing of the fixture's netdevs, which only live
+	// discovery queries
+	var liveDevs, offlineDevs []*types.RdmaDevice
+	if err := json.Unmarshal([]byte(live), &liveDevs); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(offline), &offlineDevs); err != nil {
+		t.Fatal(err)
+	}
+	for _, dev := range liveDevs {
+		dev.Warnings = nil
+	}
+	if !reflect.DeepEqual(offlineDevs, liveDevs) {
+		t.Errorf("discover --from-bundle differs from live discovery:\n got %s\nwant %s", offline, live)
+	}
+
+	// doctor judges the captured link state, not the host's over netlink
+	root := rootCmd()
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"doctor", "--from-bundle", bundlePath, "--output", "json"})
+	if err := root.Execute(); err != nil && !errors.Is(err, errChecksFailed) {
+		t.Fatalf("doctor --from-bundle: %v", err)
+	}
+	if !strings.Contains(out.String(), "Link state of enp23s0f0np0 not captured") {
+		t.Errorf("doctor --from-bundle did not run the offline link check:\n%s", out.String())
+	}
+}
+
+func TestStatsCmd(t *testing.T) {
+	tree := rdmatest.MustBuildTree(t, filepath.Join("..", "..", "pkg", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+	dir := filepath.Join(tree.SysfsRoot, "class/infiniband/mlx5_0/ports/1/hw_counters")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "np_cnp_sent"), []byte("42\n"), 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	root := rootCmd()
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"--sysfs-root", tree.SysfsRoot, "--dev-root", tree.DevRoot,
+		"stats", "--pci", "0000:17:00.0", "--output", "json"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	var ports []stats.PortStats
+	if err := json.Unmarshal(out.Bytes(), &ports); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	if len(ports) != 1 || ports[0].IbDev != "mlx5_0" || ports[0].HwCounters["np_cnp_sent"] != 42 {
+		t.Errorf("stats = %+v", ports)
+	}
+
+	textfileDir := t.TempDir()
+	root = rootCmd()
+	root.SetOut(io.Discard)
+	root.SetErr(io.Discard)
+	root.SetArgs([]string{"--sysfs-root", tree.SysfsRoot, "--dev-root", tree.DevRoot,
+		"stats", "--pci", "0000:17:00.0", "--output", "prometheus", "--textfile-dir", textfileDir})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("stats --textfile-dir: %v", err)
+	}
+	prom, err := os.ReadFile(filepath.Join(textfileDir, stats.TextfileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(prom), `counter="np_cnp_sent"} 42`) {
+		t.Errorf("textfile missing the hw counter:\n%s", prom)
+	}
+}
+
+func TestExportSriovPolicyCmd(t *testing.T) {
+	tree := rdmatest.MustBuildTree(t, filepath.Join("..", "..", "pkg", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+	pf := filepath.Join(tree.SysfsRoot, "bus/pci/devices/0000:17:00.0")
+	for attr, value := range map[string]string{"sriov_numvfs": "1\n", "sriov_totalvfs": "8\n"} {
+		if err := os.WriteFile(filepath.Join(pf, attr), []byte(value), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root := rootCmd()
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"--sysfs-root", tree.SysfsRoot, "--dev-root", tree.DevRoot,
+		"export", "sriov-policy", "--node", "node1"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("export sriov-policy: %v", err)
+	}
+	// Only the PF with VFs enabled gets a policy
+	got := out.String()
+	if strings.Count(got, "kind: SriovNetworkNodePolicy") != 1 ||
+		!strings.Contains(got, "- enp23s0f0np0") || !strings.Contains(got, "numVfs: 1") {
+		t.Errorf("unexpected policies:\n%s", got)
+	}
+}
+
+func TestDeviceSelection(t *testing.T) {
+	tree := rdmatest.MustBuildTree(t, filepath.Join("..", "..", "pkg", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+	run := func(args ...string) (string, error) {
+		root := rootCmd()
+		var out bytes.Buffer
+		root.SetOut(&out)
+		root.SetErr(&bytes.Buffer{})
+		root.SetArgs(append([]string{"--sysfs-root", tree.SysfsRoot, "--dev-root", tree.DevRoot, "--state-file", filepath.Join(t.TempDir(), "state.json")}, args...))
+		err := root.Execute()
+		return out.String(), err
+	}
+	pcis := func(out string) []string {
+		t.Helper()
+		var devices []types.RdmaDevice
+		if err := json.Unmarshal([]byte(out), &devices); err != nil {
+			t.Fatalf("%v:\n%s", err, out)
+		}
+		var got []string
+		for _, dev := range devices {
+			got = append(got, dev.PciAddress)
+		}
+		return got
+	}
+
+	out, err := run("discover", "--pci", "0000:41:00.0", "--ifname", "enp23s0f0np0", "--output", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(pcis(out)); got != "[0000:41:00.0 0000:17:00.0]" {
+		t.Errorf("discover with two targets = %s", got)
+	}
+
+	out, err = run("discover", "--selector", "driver=mlx5_core", "--output", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(pcis(out)); got != 3 {
+		t.Errorf("discover --selector driver=mlx5_core found %d devices, want 3", got)
+	}
+	if _, err := run("discover", "--selector", "driver=irdma"); !errors.Is(err, rdma.ErrNoRdmaDevices) {
+		t.Errorf("discover with a selector matching nothing: error = %v", err)
+	}
+	out, err = run("discover", "--selector", "ifname=enp*,vf!=true", "--selector", "numa=1", "--output", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(pcis(out)); got != "[0000:41:00.0]" {
+		t.Errorf("discover with combined selectors = %s", got)
+	}
+
+	dir := t.TempDir()
+	if _, err := run("generate", "--pci", "0000:17:00.0", "--pci", "0000:41:00.0", "--output-dir", dir); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if len(files) != 2 {
+		t.Errorf("generate with two targets wrote %v", files)
+	}
+
+	out, err = run("cleanup", "--output-dir", dir, "--selector", "numa=1", "--dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(out, "Would remove:") != 1 {
+		t.Errorf("cleanup --selector numa=1 should select one spec, got %q", out)
+	}
+}
+
+func TestMappingCmd(t *testing.T) {
+	tree := rdmatest.MustBuildTree(t, filepath.Join("..", "..", "pkg", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(config, []byte(`{"configList": [{"resourceName": "hca_a", "rdmaHcaMax": 10, "selectors": {"ifNames": ["enp23s0f0np0"]}}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	root := rootCmd()
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"--sysfs-root", tree.SysfsRoot, "--dev-root", tree.DevRoot,
+		"mapping", "--shared-dev-config", config, "--output-dir", t.TempDir()})
+	// No spec was generated, so the resource's device has no CDI device
+	if err := root.Execute(); !errors.Is(err, errChecksFailed) {
+		t.Fatalf("mapping error = %v, want errChecksFailed", err)
+	}
+	if !strings.Contains(out.String(), "rdma/hca_a") || !strings.Contains(out.String(), "no CDI device") {
+		t.Errorf("unexpected report:\n%s", out.String())
+	}
+}
+
+func TestBindCmd(t *testing.T) {
+	tree := rdmatest.MustBuildTree(t, filepath.Join("..", "..", "pkg", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+	if err := os.MkdirAll(filepath.Join(tree.SysfsRoot, "bus/pci/drivers/vfio-pci"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../../kernel/iommu_groups/7", filepath.Join(tree.SysfsRoot, "bus/pci/devices/0000:17:00.2/iommu_group")); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	run := func(args ...string) (string, error) {
+		root := rootCmd()
+		var out bytes.Buffer
+		root.SetOut(&out)
+		root.SetErr(&bytes.Buffer{})
+		root.SetArgs(append([]string{"--sysfs-root", tree.SysfsRoot, "--dev-root", tree.DevRoot,
+			"--state-file", filepath.Join(dir, "state.json"), "bind", "--output-dir", dir, "--proc-root", t.TempDir()}, args...))
+		err := root.Execute()
+		return out.String(), err
+	}
+
+	// Already on its kernel driver: only the spec is brought in step
+	out, err := run("--pci", "0000:17:00.2", "--driver", "mlx5_core")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "already bound to mlx5_core") || !strings.Contains(out, "rdma-cdi_rdma_pci-0000-17-00-2.yaml") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+
+	out, err = run("--pci", "0000:17:00.2", "--driver", "vfio-pci", "--dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "Would bind 0000:17:00.2 to vfio-pci (now mlx5_core)\n" {
+		t.Errorf("unexpected dry run output: %q", out)
+	}
+
+	if _, err := run("--pci", "0000:17:00.0", "--driver", "vfio-pci", "--dry-run"); err == nil || !strings.Contains(err.Error(), "IOMMU") {
+		t.Errorf("binding a function without IOMMU group to vfio-pci: error = %v", err)
+	}
+	if _, err := run("--pci", "0000:17:00.2", "--driver", "igb_uio", "--dry-run"); !errors.Is(err, bind.ErrDriverNotLoaded) {
+		t.Errorf("binding to a driver that is not loaded: error = %v", err)
+	}
+}
+
+func TestSystemdInstallCmd_BakesFlags(t *testing.T) {
+	unitFile := filepath.Join(t.TempDir(), "rdma-cdi.service")
+
+	root := rootCmd()
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"--host-root", "/host", "--require-devices", "uverbs,rdma_cm", "--quiet",
+		"systemd", "install", "--dry-run", "--unit-file", unitFile, "--binary", "/usr/bin/rdma-cdi",
+		"--generate-args=--aliases", "--guard-args=--all-dirs"})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"ExecStart=/usr/bin/rdma-cdi --host-root=/host --quiet --require-devices=uverbs,rdma_cm generate --all --aliases\n",
+		"ExecStart=/usr/bin/rdma-cdi --host-root=/host --quiet --require-devices=uverbs,rdma_cm guard --all-dirs\n",
+		"Type=notify\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("units missing %q:\n%s", want, out.String())
+		}
+	}
+	if _, err := os.Stat(unitFile); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote %s", unitFile)
+	}
+}
+
+func TestGuardCmd_NotifiesSystemd(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", sock)
+	received := func() string {
+		t.Helper()
+		buf := make([]byte, 64)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("no notification: %v", err)
+		}
+		return string(buf[:n])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	root := rootCmd()
+	root.SetOut(&bytes.Buffer{})
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"--state-file", filepath.Join(t.TempDir(), "state.json"), "guard", "--output-dir", t.TempDir()})
+	done := make(chan error, 1)
+	go func() { done <- root.ExecuteContext(ctx) }()
+
+	if got := received(); got != "READY=1" {
+		t.Errorf("first notification = %q, want READY=1", got)
+	}
+	cancel()
+	if got := received(); got != "STOPPING=1" {
+		t.Errorf("notification on shutdown = %q, want STOPPING=1", got)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("guard error = %v", err)
+	}
+}
+
+func TestGuardCmd_CountsRestores(t *testing.T) {
+	tree := rdmatest.MustBuildTree(t, filepath.Join("..", "..", "pkg", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+	dir, textfileDir := t.TempDir(), t.TempDir()
+	global := []string{"--sysfs-root", tree.SysfsRoot, "--dev-root", tree.DevRoot, "--state-file", filepath.Join(t.TempDir(), "state.json")}
+	command := func(args ...string) *cobra.Command {
+		root := rootCmd()
+		root.SetOut(&bytes.Buffer{})
+		root.SetErr(&bytes.Buffer{})
+		root.SetArgs(append(slices.Clone(global), args...))
+		return root
+	}
+	if err := command("generate", "--pci", "0000:17:00.0", "--output-dir", dir).Execute(); err != nil {
+		t.Fatal(err)
+	}
+	specs, _ := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if len(specs) != 1 {
+		t.Fatalf("generate wrote %v, want one spec", specs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- command("guard", "--output-dir", dir, "--textfile-dir", textfileDir).ExecuteContext(ctx)
+	}()
+	metrics := filepath.Join(textfileDir, stats.GuardTextfileName)
+	waitFor := func(want string) {
+		t.Helper()
+		var data []byte
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			if data, _ = os.ReadFile(metrics); strings.Contains(string(data), want) {
+				return
+			}
+		}
+		t.Fatalf("%s never contained %q:\n%s", metrics, want, data)
+	}
+
+	waitFor(`rdma_cdi_guard_restores_total{result="ok"} 0`)
+	if err := os.Remove(specs[0]); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(`rdma_cdi_guard_restores_total{result="ok"} 1`)
+	if _, err := os.Stat(specs[0]); err != nil {
+		t.Errorf("spec not restored: %v", err)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("guard error = %v", err)
+	}
+}
+
+func TestDeployManifestCmd(t *testing.T) {
+	root := rootCmd()
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"--sysfs-root", "/tmp/sys", "--require-devices", "uverbs,rdma_cm", "--plugins", "nvidia-gds",
+		"deploy", "manifest", "--image", "registry.example.com/rdma-cdi:v1", "--generate-args=--aliases"})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"kind: DaemonSet\n",
+		"- --host-root=/host\n",
+		"- --plugins=nvidia-gds\n",
+		"- --require-devices=uverbs,rdma_cm\n",
+		"- --aliases\n",
+		"image: registry.example.com/rdma-cdi:v1\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("manifest missing %q:\n%s", want, out.String())
+		}
+	}
+	// Host paths of this machine mean nothing in the pod
+	if strings.Contains(out.String(), "/tmp/sys") {
+		t.Errorf("manifest carries --sysfs-root:\n%s", out.String())
+	}
+
+	root = rootCmd()
+	root.SetOut(&bytes.Buffer{})
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"deploy", "manifest", "--image", "x", "--mode", "dra"})
+	var ue *usageError
+	if err := root.Execute(); !errors.As(err, &ue) {
+		t.Errorf("dra without --plugin-name: error = %v, want a usage error", err)
+	}
+}
+
+func TestUdevInstallCmd(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "90-rdma-cdi.rules")
+
+	root := rootCmd()
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"udev", "install", "--dry-run", "--rules-file", rulesFile,
+		"--binary", "/usr/bin/rdma-cdi", "--generate-args=--aliases"})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "/usr/bin/rdma-cdi --quiet generate --all --aliases") {
+		t.Errorf("dry run did not print the rule:\n%s", out.String())
+	}
+	if _, err := os.Stat(rulesFile); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote %s", rulesFile)
+	}
+
+	root = rootCmd()
+	root.SetOut(&bytes.Buffer{})
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"udev", "install", "--rules-file", rulesFile, "--binary", "/usr/bin/rdma-cdi"})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(rulesFile); err != nil {
+		t.Errorf("rule not installed: %v", err)
+	}
+
+	root = rootCmd()
+	root.SetOut(&bytes.Buffer{})
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"udev", "install", "--dry-run", "--binary", "rdma-cdi"})
+	_, err := execute(root)
+	if got := exitCodeFor(err); got != exitUsage {
+		t.Errorf("relative --binary: exit code = %d, want %d (err %v)", got, exitUsage, err)
+	}
+}
+
+// ──────────────────────────────────────────────
+//  XOR validation (simulate via rootCmd)
+// ──────────────────────────────────────────────
+
+func TestRootlessOutputDir_NotForRoot(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root")
+	}
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd, _, err := rootCmd().Find([]string{"generate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Root must get the preflight error, not specs where no runtime looks
+	unwritable := filepath.Join(file, "cdi")
+	if got := rootlessOutputDir(cmd, unwritable); got != unwritable {
+		t.Errorf("rootlessOutputDir() as root = %q, want %q", got, unwritable)
+	}
+}
+
+func TestGenerateCmd_NeitherPciNorIfname(t *testing.T) {
+	// Running generate without --pci, --ifname, or --all should produce an error.
+	root := rootCmd()
+	var stderr bytes.Buffer
+	root.SetErr(&stderr)
+	root.SetOut(&bytes.Buffer{})
+	root.SetArgs([]string{"generate"})
+
+	cmd := newGenerateCmd()
+	if cmd.Use != "generate" {
+		t.Errorf("expected Use=generate, got %q", cmd.Use)
+	}
+}
+
+func TestGenerateCmd_AllAndPciConflict(t *testing.T) {
+	root := rootCmd()
+	root.SetOut(&bytes.Buffer{})
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"generate", "--all", "--pci", "0000:17:00.0"})
+	err := root.Execute()
+	if err == nil {
+		t.Error("expected error when --all and --pci are both set")
+	}
+}
+
+func TestGenerateCmd_AllAndNameConflict(t *testing.T) {
+	root := rootCmd()
+	root.SetOut(&bytes.Buffer{})
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs([]string{"generate", "--all", "--name", "mydev"})
+	err := root.Execute()
+	if err == nil {
+		t.Error("expected error when --all and --name are both set")
+	}
+}
+
+func TestGenerateAll_ParallelOrderAndSummary(t *testing.T) {
+	dir := t.TempDir()
+	var devices []*types.RdmaDevice
+	for i := 0; i < 8; i++ {
+		devices = append(devices, &types.RdmaDevice{
+			PciAddress:  fmt.Sprintf("0000:%02x:00.0", 0x17+i),
+			Vendor:      "15b3",
+			DeviceSpecs: []types.DeviceSpec{{HostPath: "/dev/infiniband/uverbs0", ContainerPath: "/dev/infiniband/uverbs0", Permissions: "rw"}},
+		})
+	}
+	// A directory squatting on device 3's spec path makes its write fail
+	if err := os.Mkdir(filepath.Join(dir, "rdma-cdi_rdma_pci-0000-1a-00-0.yaml"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	w := specWriter{prefix: "rdma", outputDir: dir, format: "yaml"}
+	var running, peak int32
+	var mu sync.Mutex
+	results := generateAll(perDeviceTargets(devices), 4, false, func(tgt generateTarget) generateResult {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		defer func() { mu.Lock(); running--; mu.Unlock() }()
+		return w.write(tgt)
+	})
+
+	if peak > 4 {
+		t.Errorf("ran %d generators at once, limit is 4", peak)
+	}
+	for i, r := range results {
+		if r.PciAddress != devices[i].PciAddress {
+			t.Errorf("result %d is for %s, want %s (order must be preserved)", i, r.PciAddress, devices[i].PciAddress)
+		}
+	}
+	if countFailed(results) != 1 || results[3].Success {
+		t.Errorf("expected only device 3 to fail, got %+v", results)
+	}
+
+	var buf bytes.Buffer
+	if err := reportGenerate(&buf, "json", results); err != nil {
+		t.Fatal(err)
+	}
+	var summary struct {
+		Total     int `json:"total"`
+		Succeeded int `json:"succeeded"`
+		Failed    int `json:"failed"`
+		Results   []struct {
+			PciAddress string `json:"pci_address"`
+			SpecPath   string `json:"spec_path"`
+			Success    bool   `json:"success"`
+			Error      string `json:"error"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &summary); err != nil {
+		t.Fatalf("summary is not JSON: %v", err)
+	}
+	if summary.Total != 8 || summary.Succeeded != 7 || summary.Failed != 1 {
+		t.Errorf("unexpected totals: %+v", summary)
+	}
+	if summary.Results[0].SpecPath != filepath.Join(dir, "rdma-cdi_rdma_pci-0000-17-00-0.yaml") {
+		t.Errorf("spec_path = %q", summary.Results[0].SpecPath)
+	}
+	if summary.Results[3].Error == "" {
+		t.Error("failed result should carry its error message")
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var buf bytes.Buffer
+	p := &progress{w: &buf, tty: true, what: "generate"}
+	p.step(3, "0000:17:00.0")
+	p.step(3, "0000:17:00.2")
+	if got := buf.String(); got != "\r\033[Kgenerate: 1/3 0000:17:00.0\r\033[Kgenerate: 2/3 0000:17:00.2" {
+		t.Errorf("status line = %q", got)
+	}
+	p.finish()
+	if !strings.HasSuffix(buf.String(), "0000:17:00.2\r\033[K") {
+		t.Errorf("finish should clear the status line, got %q", buf.String())
+	}
+
+	// Without a terminal nothing is drawn; progress goes to the log
+	buf.Reset()
+	p = &progress{w: &buf, what: "doctor"}
+	p.report(1, 2, "0000:17:00.0")
+	p.finish()
+	if buf.Len() != 0 {
+		t.Errorf("non-terminal progress wrote %q", buf.String())
+	}
+}
+
+func TestGenerateAll_FailFast(t *testing.T) {
+	var devices []*types.RdmaDevice
+	for i := 0; i < 5; i++ {
+		devices = append(devices, &types.RdmaDevice{PciAddress: fmt.Sprintf("0000:%02x:00.0", 0x17+i)})
+	}
+	boom := errors.New("boom")
+	gen := func(tgt generateTarget) generateResult {
+		r := tgt.newResult()
+		if r.PciAddress == "0000:18:00.0" {
+			r.Error, r.err = boom.Error(), boom
+			return r
+		}
+		r.Success = true
+		return r
+	}
+
+	tests := []struct {
+		name        string
+		failFast    bool
+		wantSkipped int
+	}{
+		{"continue_on_error", false, 0},
+		{"fail_fast", true, 3},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// One job makes the scheduling order deterministic
+			results := generateAll(perDeviceTargets(devices), 1, tc.failFast, gen)
+			if got := countSkipped(results); got != tc.wantSkipped {
+				t.Errorf("skipped = %d, want %d", got, tc.wantSkipped)
+			}
+			if got := countFailed(results); got != 1 {
+				t.Errorf("failed = %d, want 1", got)
+			}
+
+			err := newBatchError(results)
+			if exitCodeFor(err) != exitPartialFailure {
+				t.Errorf("exit code = %d, want %d", exitCodeFor(err), exitPartialFailure)
+			}
+			if !errors.Is(err, boom) {
+				t.Errorf("batch error should wrap the device error, got: %v", err)
+			}
+			if !strings.Contains(err.Error(), "0000:18:00.0: boom") {
+				t.Errorf("batch error should list the failed address, got: %v", err)
+			}
+		})
+	}
+
+	if err := newBatchError([]generateResult{{Success: true}}); err != nil {
+		t.Errorf("expected nil error when all succeed, got: %v", err)
+	}
+}
+
+func TestGroupTargets(t *testing.T) {
+	devices := []*types.RdmaDevice{
+		{PciAddress: "0000:17:00.0", LinkType: "infiniband", Driver: "mlx5_core", NumaNode: 0},
+		{PciAddress: "0000:18:00.0", LinkType: "ether", Driver: "mlx5_core", NumaNode: 1},
+		{PciAddress: "0000:41:00.0", LinkType: "infiniband", Driver: "mlx4_core", NumaNode: -1},
+		{PciAddress: "0000:42:00.0", Driver: "irdma", NumaNode: 1},
+	}
+
+	tests := []struct {
+		by   string
+		want map[string][]string
+	}{
+		{"link-type", map[string][]string{
+			"infiniband": {"0000:17:00.0", "0000:41:00.0"},
+			"ether":      {"0000:18:00.0"},
+			"unknown":    {"0000:42:00.0"},
+		}},
+		{"driver", map[string][]string{
+			"mlx5_core": {"0000:17:00.0", "0000:18:00.0"},
+			"mlx4_core": {"0000:41:00.0"},
+			"irdma":     {"0000:42:00.0"},
+		}},
+		{"numa", map[string][]string{
+			"numa0":        {"0000:17:00.0"},
+			"numa1":        {"0000:18:00.0", "0000:42:00.0"},
+			"numa-unknown": {"0000:41:00.0"},
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.by, func(t *testing.T) {
+			targets := groupTargets(devices, groupKeys[tc.by])
+			if len(targets) != len(tc.want) {
+				t.Fatalf("got %d groups, want %d", len(targets), len(tc.want))
+			}
+			for _, tgt := range targets {
+				var got []string
+				for _, dev := range tgt.devices {
+					got = append(got, dev.PciAddress)
+				}
+				if fmt.Sprint(got) != fmt.Sprint(tc.want[tgt.name]) {
+					t.Errorf("group %q = %v, want %v", tgt.name, got, tc.want[tgt.name])
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateCmd_GroupBy(t *testing.T) {
+	dir := t.TempDir()
+	w := specWriter{prefix: "rdma", outputDir: dir, format: "yaml", opts: []cdi.Option{cdi.WithAllDevice()}}
+	dev := func(pci, link string) *types.RdmaDevice {
+		return &types.RdmaDevice{
+			PciAddress:  pci,
+			LinkType:    link,
+			DeviceSpecs: []types.DeviceSpec{{HostPath: "/dev/infiniband/uverbs0", ContainerPath: "/dev/infiniband/uverbs0", Permissions: "rw"}},
+		}
+	}
+	devices := []*types.RdmaDevice{dev("0000:17:00.0", "infiniband"), dev("0000:41:00.0", "infiniband"), dev("0000:18:00.0", "ether")}
+
+	results := generateAll(groupTargets(devices, groupKeys["link-type"]), 2, false, w.write)
+	if len(results) != 2 || countFailed(results) != 0 {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if results[0].Name != "infiniband" || fmt.Sprint(results[0].Devices) != "[0000:17:00.0 0000:41:00.0]" {
+		t.Errorf("unexpected group result: %+v", results[0])
+	}
+	if _, err := os.Stat(filepath.Join(dir, "rdma-cdi_rdma_infiniband.yaml")); err != nil {
+		t.Errorf("expected grouped spec: %v", err)
+	}
+
+	root := rootCmd()
+	root.SetOut(&bytes.Buffer{})
+	root.SetErr(&bytes.Buffer{})
+	for _, args := range [][]string{
+		{"generate", "--all", "--group-by", "vendor"},
+		{"generate", "--pci", "0000:17:00.0", "--group-by", "numa"},
+	} {
+		root.SetArgs(args)
+		if err := root.Execute(); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestGenerateCmd_DryRun(t *testing.T) {
+	tree := rdmatest.MustBuildTree(t, filepath.Join("..", "..", "pkg", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+	dir := t.TempDir()
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	generate := func(extra ...string) string {
+		t.Helper()
+		root := rootCmd()
+		var out bytes.Buffer
+		root.SetOut(&out)
+		root.SetErr(&bytes.Buffer{})
+		root.SetArgs(append([]string{"--sysfs-root", tree.SysfsRoot, "--dev-root", tree.DevRoot, "--state-file", stateFile,
+			"generate", "--pci", "0000:41:00.0", "--name", "b", "--output-dir", dir}, extra...))
+		if err := root.Execute(); err != nil {
+			t.Fatalf("generate %v: %v", extra, err)
+		}
+		return out.String()
+	}
+	path := filepath.Join(dir, "rdma-cdi_rdma_b.yaml")
+
+	out := generate("--dry-run")
+	if !strings.HasPrefix(out, "Would write CDI spec to "+path+":\n") || !strings.Contains(out, "kind: rdma/b") {
+		t.Errorf("unexpected preview:\n%s", out)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote %s", path)
+	}
+	if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+		t.Error("dry run recorded the spec in the state file")
+	}
+
+	generate()
+	if out := generate("--dry-run"); out != "CDI spec "+path+" is up to date\n" {
+		t.Errorf("unexpected output for an unchanged spec: %q", out)
+	}
+	out = generate("--dry-run", "--aliases")
+	if !strings.Contains(out, "Would update CDI spec "+path) || !strings.Contains(out, "+    rdma-cdi/alias-of: \"0000:41:00.0\"") ||
+		!strings.Contains(out, "+  name: enp65s0np0") {
+		t.Errorf("unexpected diff:\n%s", out)
+	}
+}
+
+func TestGenerateCmd_LAG(t *testing.T) {
+	tree := rdmatest.MustBuildTree(t, filepath.Join("..", "..", "pkg", "rdma", "rdmatest", "testdata", "cx6dx-lag.yaml"))
+	generate := func(extra ...string) []string {
+		t.Helper()
+		dir := t.TempDir()
+		root := rootCmd()
+		root.SetOut(&bytes.Buffer{})
+		root.SetErr(&bytes.Buffer{})
+		root.SetArgs(append([]string{"--sysfs-root", tree.SysfsRoot, "--dev-root", tree.DevRoot,
+			"--state-file", filepath.Join(t.TempDir(), "state.json"), "generate", "--all", "--output-dir", dir}, extra...))
+		if err := root.Execute(); err != nil {
+			t.Fatalf("generate %v: %v", extra, err)
+		}
+		var specs []string
+		paths, _ := filepath.Glob(filepath.Join(dir, "*.yaml"))
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			specs = append(specs, string(data))
+		}
+		return specs
+	}
+
+	specs := generate()
+	if len(specs) != 1 || !strings.Contains(specs[0], "rdma-cdi/bond: bond0") ||
+		!strings.Contains(specs[0], "rdma-cdi/bond-members: 0000:17:00.0=enp23s0f0np0,0000:17:00.1=enp23s0f1np1") {
+		t.Errorf("expected one spec for the LAG, got:\n%s", strings.Join(specs, "---\n"))
+	}
+	if specs := generate("--no-lag-collapse"); len(specs) != 1 || strings.Contains(specs[0], "rdma-cdi/bond") {
+		t.Errorf("expected a plain per-PF spec with --no-lag-collapse, got:\n%s", strings.Join(specs, "---\n"))
+	}
+}
+
+func TestGenerateCmd_Plugins(t *testing.T) {
+	tree := rdmatest.MustBuildTree(t, filepath.Join("..", "..", "pkg", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+	if err := os.WriteFile(filepath.Join(tree.DevRoot, "nvidia-fs0"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	generate := func(plugins string) (string, error) {
+		dir := t.TempDir()
+		root := rootCmd()
+		root.SetOut(&bytes.Buffer{})
+		root.SetErr(&bytes.Buffer{})
+		root.SetArgs([]string{"--sysfs-root", tree.SysfsRoot, "--dev-root", tree.DevRoot, "--plugins", plugins,
+			"--state-file", filepath.Join(t.TempDir(), "state.json"), "generate", "--pci", "0000:41:00.0", "--name", "p", "--output-dir", dir})
+		if err := root.Execute(); err != nil {
+			return "", err
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "rdma-cdi_rdma_p.yaml"))
+		return string(data), err
+	}
+
+	spec, err := generate("nvidia-gds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(spec, "hostPath: /dev/nvidia-fs0") {
+		t.Errorf("spec lacks the nvidia-fs node:\n%s", spec)
+	}
+	if _, err := generate("nvidia-gds,bogus"); exitCodeFor(err) != exitUsage || !strings.Contains(err.Error(), "nvidia-gds") {
+		t.Errorf("unknown plugin: err = %v, want a usage error listing the available ones", err)
+	}
+}
+
+func TestPlanCmd(t *testing.T) {
+	dir := t.TempDir()
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	fixture := filepath.Join("..", "..", "pkg", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml")
+	full := rdmatest.MustBuildTree(t, fixture)
+	fx, err := rdmatest.LoadFixture(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fx.Devices = slices.Delete(fx.Devices, 1, 2) // 0000:41:00.0 was pulled
+	fewer, err := rdmatest.BuildTree(t.TempDir(), fx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(tr rdmatest.Tree, args ...string) string {
+		t.Helper()
+		root := rootCmd()
+		var out bytes.Buffer
+		root.SetOut(&out)
+		root.SetErr(&bytes.Buffer{})
+		root.SetArgs(append([]string{"--sysfs-root", tr.SysfsRoot, "--dev-root", tr.DevRoot, "--state-file", stateFile}, args...))
+		if err := root.Execute(); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		return out.String()
+	}
+	plan := func(tr rdmatest.Tree, args ...string) planReport {
+		t.Helper()
+		var report planReport
+		out := run(tr, append([]string{"plan", "--output-dir", dir, "--output", "json"}, args...)...)
+		if err := json.Unmarshal([]byte(out), &report); err != nil {
+			t.Fatalf("%v\n%s", err, out)
+		}
+		return report
+	}
+
+	initial := plan(full)
+	if initial.Create == 0 || initial.Update+initial.Delete+initial.Unchanged != 0 || len(initial.Changes) != initial.Create {
+		t.Errorf("empty directory: want only creations, got %+v", initial)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("plan wrote %d file(s)", len(entries))
+	}
+	if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+		t.Errorf("plan wrote the state file")
+	}
+
+	run(full, "generate", "--all", "--output-dir", dir)
+	if out := run(full, "plan", "--output-dir", dir); !strings.Contains(out, "No changes") {
+		t.Errorf("plan after generate should find nothing to do:\n%s", out)
+	}
+	if got := plan(full, "--aliases"); got.Update != initial.Create || got.Changes[0].Diff == "" {
+		t.Errorf("--aliases: want %d updates with diffs, got %+v", initial.Create, got)
+	}
+
+	gone := plan(fewer)
+	if gone.Delete != 1 || gone.Unchanged != initial.Create-1 {
+		t.Fatalf("pulled card: want one deletion, got %+v", gone)
+	}
+	if c := gone.Changes[0]; c.Action != planDelete || !strings.Contains(c.SpecPath, "0000-41-00-0") {
+		t.Errorf("deletion = %+v, want the spec of 0000:41:00.0", c)
+	}
+	if out := run(fewer, "plan", "--output-dir", dir); !strings.Contains(out, "Plan: 0 to create, 0 to update, 1 to delete") {
+		t.Errorf("unexpected summary:\n%s", out)
+	}
+
+	// With every card pulled, plan deletes every spec rather than failing
+	fx.Devices = nil
+	none, err := rdmatest.BuildTree(t.TempDir(), fx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(none.SysfsRoot, "bus", "pci", "devices"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if empty := plan(none); empty.Delete != initial.Create || empty.Create+empty.Update+empty.Unchanged != 0 || empty.Changes[0].Action != planDelete {
+		t.Errorf("no devices: want %d deletions, got %+v", initial.Create, empty)
+	}
+}
+
+func TestGenerateCmd_KeepsSpecAcrossRename(t *testing.T) {
+	dir := t.TempDir()
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	// tree builds the fixture with 0000:41:00.0 having netdev and GUID
+	tree := func(netdev, guid string) rdmatest.Tree {
+		fx, err := rdmatest.LoadFixture(filepath.Join("..", "..", "pkg", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fx.Devices[1].Netdevs = []string{netdev}
+		fx.Devices[1].Ibdevs[0].NodeGUID = guid
+		tr, err := rdmatest.BuildTree(t.TempDir(), fx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tr
+	}
+	generate := func(tr rdmatest.Tree, args ...string) {
+		t.Helper()
+		root := rootCmd()
+		root.SetOut(&bytes.Buffer{})
+		root.SetErr(&bytes.Buffer{})
+		root.SetArgs(append([]string{"--sysfs-root", tr.SysfsRoot, "--dev-root", tr.DevRoot, "--state-file", stateFile,
+			"generate", "--output-dir", dir, "--aliases"}, args...))
+		if err := root.Execute(); err != nil {
+			t.Fatalf("generate %v: %v", args, err)
+		}
+	}
+	specsOf := func(pci string) []string {
+		t.Helper()
+		state, err := cdi.LoadState(stateFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, rec := range state.Specs {
+			if slices.Equal(rec.Devices, []string{pci}) {
+				paths = append(paths, filepath.Base(rec.Path))
+			}
+		}
+		return paths
+	}
+
+	generate(tree("enp65s0np0", "0c42:a103:0065:4f3a"), "--ifname", "enp65s0np0")
+	want := []string{"rdma-cdi_rdma_enp65s0np0.yaml"}
+
+	// udev renamed the interface: both the hot-plug rule's generate --all
+	// and generate --ifname with the new name update the existing spec
+	renamed := tree("ens1f0np0", "0c42:a103:0065:4f3a")
+	generate(renamed, "--all")
+	generate(renamed, "--ifname", "ens1f0np0")
+	if got := specsOf("0000:41:00.0"); !slices.Equal(got, want) {
+		t.Fatalf("specs of 0000:41:00.0 = %v, want %v", got, want)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, want[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "name: ens1f0np0") || strings.Contains(string(data), "name: enp65s0np0") {
+		t.Errorf("spec aliases not updated to the new interface name:\n%s", data)
+	}
+
+	// Another card in the same slot gets a spec of its own
+	generate(tree("ens1f0np0", "b859:9f03:00d4:1e20"), "--ifname", "ens1f0np0")
+	if got := specsOf("0000:41:00.0"); len(got) != 2 {
+		t.Errorf("specs of 0000:41:00.0 = %v, want a second one for the new card", got)
+	}
+}
+
+func TestCheckTargetNames(t *testing.T) {
+	ib0 := &types.RdmaDevice{PciAddress: "0000:17:00.0", IfName: "ib0"}
+	ib1 := &types.RdmaDevice{PciAddress: "0000:17:00.0", IfName: "ib1"}
+	other := &types.RdmaDevice{PciAddress: "0000:41:00.0", IfName: "ib2"}
+
+	tests := []struct {
+		name    string
+		targets []generateTarget
+		wantErr string
+	}{
+		{"distinct", perDeviceTargets([]*types.RdmaDevice{ib0, other}), ""},
+		{"same_pci_function", perDeviceTargets([]*types.RdmaDevice{ib0, ib1}), "0000:17:00.0 (ib0) and 0000:17:00.0 (ib1) would both be written"},
+		{"duplicate_in_group", []generateTarget{{name: "infiniband", devices: []*types.RdmaDevice{ib0, other, ib1}}}, `device name "0000:17:00.0" is declared twice`},
+		{"invalid_name", []generateTarget{{name: "-bad", devices: []*types.RdmaDevice{ib0}}}, "invalid CDI resource name"},
+		{"group_vs_cm", []generateTarget{{name: "cm", devices: []*types.RdmaDevice{ib0, other}}, {name: "cm", devices: []*types.RdmaDevice{ib1}}}, `group "cm" and 0000:17:00.0 (ib1)`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkTargetNames("rdma", "yaml", tc.targets)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("err = %v, want it to contain %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestGenerateCmd_SpecDirs(t *testing.T) {
+	tr := rdmatest.MustBuildTree(t, filepath.Join("..", "..", "pkg", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+	etc, run := t.TempDir(), t.TempDir()
+	generate := func(args ...string) (string, error) {
+		t.Helper()
+		root := rootCmd()
+		var out bytes.Buffer
+		root.SetOut(&out)
+		root.SetErr(&bytes.Buffer{})
+		root.SetArgs(append([]string{"--sysfs-root", tr.SysfsRoot, "--dev-root", tr.DevRoot,
+			"--state-file", filepath.Join(t.TempDir(), "state.json"),
+			"generate", "--pci", "0000:17:00.0", "--spec-dirs", etc + "," + run}, args...))
+		err := root.Execute()
+		return out.String(), err
+	}
+
+	if _, err := generate("--output-dir", etc); err != nil {
+		t.Fatal(err)
+	}
+	etcSpec := filepath.Join(etc, "rdma-cdi_rdma_pci-0000-17-00-0.yaml")
+
+	// A new spec in a higher-priority directory is diffed against the one
+	// runtimes use until then
+	out, err := generate("--output-dir", run, "--dry-run", "--aliases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "shadowing "+etcSpec) || !strings.Contains(out, "--- "+etcSpec) {
+		t.Errorf("dry-run should diff against %s:\n%s", etcSpec, out)
+	}
+
+	if _, err := generate("--output-dir", run); err != nil {
+		t.Errorf("shadowing a lower-priority spec should only warn: %v", err)
+	}
+	runSpec := filepath.Join(run, "rdma-cdi_rdma_pci-0000-17-00-0.yaml")
+	if _, err := generate("--output-dir", etc); err == nil || !strings.Contains(err.Error(), "would be shadowed by "+runSpec) {
+		t.Errorf("expected the /etc spec to be reported as shadowed, got %v", err)
+	}
+	if _, err := generate("--output-dir", run, "--format", "json"); err == nil || !strings.Contains(err.Error(), "is already defined by "+runSpec) {
+		t.Errorf("expected a duplicate definition in %s, got %v", run, err)
+	}
+	if _, err := generate("--output-dir", etc, "--force"); err != nil {
+		t.Errorf("--force should only warn: %v", err)
+	}
+}
+
+func TestCheckForeignKinds(t *testing.T) {
+	dir := t.TempDir()
+	foreign := filepath.Join(dir, "vendor-rdma.yaml")
+	os.WriteFile(foreign, []byte("cdiVersion: \"0.5.0\"\nkind: rdma/ib0\ndevices:\n  - name: x\n"), 0644)
+	// Our own spec of the same kind is not a conflict
+	os.WriteFile(filepath.Join(dir, "rdma-cdi_rdma_ib1.yaml"), []byte("cdiVersion: \"0.5.0\"\nkind: rdma/ib1\ndevices:\n  - name: x\n"), 0644)
+
+	targets := []generateTarget{{name: "ib0"}, {name: "ib1"}}
+	err := checkForeignKinds([]string{dir}, "rdma", targets, false)
+	if err == nil || !strings.Contains(err.Error(), "kind rdma/ib0 is already defined by "+foreign) {
+		t.Errorf("expected conflict for rdma/ib0, got: %v", err)
+	}
+	if strings.Contains(fmt.Sprint(err), "rdma/ib1") {
+		t.Errorf("own spec reported as conflict: %v", err)
+	}
+	if err := checkForeignKinds([]string{dir}, "rdma", targets, true); err != nil {
+		t.Errorf("--force should only warn: %v", err)
+	}
+	if err := checkForeignKinds([]string{dir}, "example.com", targets, false); err != nil {
+		t.Errorf("other prefix should not conflict: %v", err)
+	}
+}
+
+func TestSplitRdmaCM(t *testing.T) {
+	node := func(name string) types.DeviceSpec {
+		return types.DeviceSpec{HostPath: "/dev/infiniband/" + name, ContainerPath: "/dev/infiniband/" + name, Permissions: "rw"}
+	}
+	devices := []*types.RdmaDevice{
+		{PciAddress: "0000:17:00.0", DeviceSpecs: []types.DeviceSpec{node("uverbs0"), node("rdma_cm")}},
+		{PciAddress: "0000:18:00.0", DeviceSpecs: []types.DeviceSpec{node("uverbs1"), node("rdma_cm")}},
+	}
+
+	cm := splitRdmaCM(devices)
+	if cm == nil {
+		t.Fatal("expected a shared rdma_cm target")
+	}
+	if cm.name != cdi.RdmaCMResourceName || len(cm.devices) != 1 || len(cm.devices[0].DeviceSpecs) != 1 ||
+		cm.devices[0].DeviceSpecs[0].HostPath != "/dev/infiniband/rdma_cm" {
+		t.Errorf("unexpected rdma_cm target: %+v", cm)
+	}
+	for _, dev := range devices {
+		if len(dev.DeviceSpecs) != 1 || rdma.DeviceType(dev.DeviceSpecs[0].HostPath) != rdma.DeviceTypeUverbs {
+			t.Errorf("%s: rdma_cm not removed: %+v", dev.PciAddress, dev.DeviceSpecs)
+		}
+	}
+
+	if splitRdmaCM(devices) != nil {
+		t.Error("expected no target once rdma_cm is gone")
+	}
+
+	dir := t.TempDir()
+	w := specWriter{prefix: "rdma", outputDir: dir, format: "yaml"}
+	if r := w.write(*cm); !r.Success {
+		t.Fatalf("writing rdma_cm spec failed: %v", r.err)
+	}
+	// The shared spec must survive orphan cleanup
+	orphans, err := cdi.FindOrphanSpecs(dir, "rdma", "", func(string) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("rdma_cm spec reported as orphan: %v", orphans)
+	}
+}
+
+func TestGenerateCmd_InvalidSpecFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"permissions", []string{"--permissions", "rx"}, "invalid device permissions"},
+		{"device_types", []string{"--device-types", "uverbs,bogus"}, "unknown device type"},
+		{"summary", []string{"--summary", "xml"}, "unsupported summary format"},
+		{"include_devices", []string{"--include-devices", "umad"}, "unknown optional device type"},
+		{"rdma_cm", []string{"--rdma-cm", "once"}, "unsupported rdma_cm mode"},
+		{"file_mode", []string{"--file-mode", "rw-r--r--"}, "invalid file mode"},
+		{"file_owner", []string{"--file-owner", ":"}, "invalid owner"},
+		{"name", []string{"--name", "0000:17:00.0"}, "invalid CDI resource name"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			root := rootCmd()
+			root.SetOut(&bytes.Buffer{})
+			root.SetErr(&bytes.Buffer{})
+			root.SetArgs(append([]string{"generate", "--pci", "0000:17:00.0"}, tc.args...))
+			err := root.Execute()
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("expected %q error, got: %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestDiscoverCmd_PciAndIfnameConflict(t *testing.T) {
+	// Verify the command accepts both flags (validation is at runtime)
+	cmd := newDiscoverCmd()
+	pci := cmd.Flags().Lookup("pci")
+	ifn := cmd.Flags().Lookup("ifname")
+	if pci == nil || ifn == nil {
+		t.Error("discover should have both --pci and --ifname flags")
+	}
+}
+
+// ──────────────────────────────────────────────
+//  Help output
+// ──────────────────────────────────────────────
+
+func TestRootCmd_HelpOutput(t *testing.T) {
+	root := rootCmd()
+	var buf bytes.Buffer
+	root.SetOut(&buf)
+	root.SetArgs([]string{"--help"})
+	_ = root.Execute()
+
+	output := buf.String()
+	if !strings.Contains(output, "RDMA") {
+		t.Error("help output should contain tool description")
+	}
+	for _, sub := range []string{"generate", "discover", "doctor", "cleanup"} {
+		if !strings.Contains(output, sub) {
+			t.Errorf("help output should list %q subcommand", sub)
+		}
+	}
+}
+
+// ──────────────────────────────────────────────
+//  --log-level flag
+// ──────────────────────────────────────────────
+
+func TestRootCmd_LogLevelFlag(t *testing.T) {
+	root := rootCmd()
+	f := root.PersistentFlags().Lookup("log-level")
+	if f == nil {
+		t.Fatal("root command missing --log-level flag")
+	}
+	if f.DefValue != "info" {
+		t.Errorf("--log-level default = %q, want 'info'", f.DefValue)
+	}
+}
+
+func TestRootCmd_LogLevelInvalid(t *testing.T) {
+	root := rootCmd()
+	root.SetArgs([]string{"--log-level", "bogus", "discover", "--all"})
+	var stderr bytes.Buffer
+	root.SetErr(&stderr)
+	root.SetOut(&bytes.Buffer{})
+	err := root.Execute()
+	if err == nil {
+		t.Error("expected error for invalid log level, got nil")
+	}
+	if !strings.Contains(err.Error(), "invalid log level") {
+		t.Errorf("expected 'invalid log level' in error, got: %v", err)
+	}
+}
+
+func TestRootCmd_LogLevelValid(t *testing.T) {
+	for _, level := range []string{"trace", "debug", "info", "warn", "error"} {
+		root := rootCmd()
+		root.SetArgs([]string{"--log-level", level, "--help"})
+		root.SetOut(&bytes.Buffer{})
+		if err := root.Execute(); err != nil {
+			t.Errorf("--log-level %s should be valid, got error: %v", level, err)
+		}
+	}
+}
+
+func TestRootCmd_QuietAndNoColor(t *testing.T) {
+	defer log.SetLevel(log.GetLevel())
+	defer log.SetFormatter(log.StandardLogger().Formatter)
+
+	root := rootCmd()
+	root.SetArgs([]string{"--quiet", "--no-color", "version"})
+	root.SetOut(io.Discard)
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if log.GetLevel() != log.WarnLevel {
+		t.Errorf("--quiet log level = %v, want warning", log.GetLevel())
+	}
+	if f, ok := log.StandardLogger().Formatter.(*log.TextFormatter); !ok || !f.DisableColors {
+		t.Errorf("--no-color should disable log colors, formatter = %#v", log.StandardLogger().Formatter)
+	}
+
+	root = rootCmd()
+	root.SetArgs([]string{"--quiet", "--log-level", "debug", "version"})
+	root.SetOut(io.Discard)
+	if _, err := execute(root); exitCodeFor(err) != exitUsage {
+		t.Errorf("--quiet with --log-level should be a usage error, got %v", err)
+	}
+}
+
+func TestRootCmd_LogFile(t *testing.T) {
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	defer log.SetOutput(log.StandardLogger().Out)
+	log.SetOutput(io.Discard)
+
+	path := filepath.Join(t.TempDir(), "rdma-cdi.log")
+	root := rootCmd()
+	root.SetArgs([]string{"--log-file", path, "version"})
+	root.SetOut(io.Discard)
+	if _, err := execute(root); err != nil {
+		t.Fatal(err)
+	}
+	log.Warn("after the command")
+	if got, _ := os.ReadFile(path); !strings.Contains(string(got), `msg="after the command"`) {
+		t.Errorf("log file should receive the logs:\n%s", got)
+	}
+
+	root = rootCmd()
+	root.SetArgs([]string{"--log-max-backups", "-1", "version"})
+	root.SetOut(io.Discard)
+	if _, err := execute(root); exitCodeFor(err) != exitUsage {
+		t.Errorf("negative --log-max-backups should be a usage error, got %v", err)
+	}
+}
+
+func TestRootCmd_BackendFlag(t *testing.T) {
+	root := rootCmd()
+	f := root.PersistentFlags().Lookup("backend")
+	if f == nil {
+		t.Fatal("root command missing --backend flag")
+	}
+	if f.DefValue != "sysfs" {
+		t.Errorf("--backend default = %q, want 'sysfs'", f.DefValue)
+	}
+}
+
+func TestRootCmd_BackendInvalid(t *testing.T) {
+	root := rootCmd()
+	root.SetArgs([]string{"--backend", "bogus", "discover"})
+	root.SetErr(&bytes.Buffer{})
+	root.SetOut(&bytes.Buffer{})
+	err := root.Execute()
+	if err == nil || !strings.Contains(err.Error(), "unsupported discovery backend") {
+		t.Errorf("expected unsupported backend error, got: %v", err)
+	}
+}
+
+func TestRootCmd_RequireDevicesFlag(t *testing.T) {
+	root := rootCmd()
+	f := root.PersistentFlags().Lookup("require-devices")
+	if f == nil {
+		t.Fatal("root command missing --require-devices flag")
+	}
+	if f.DefValue != "[]" {
+		t.Errorf("--require-devices default = %q, want '[]' (per-driver profile)", f.DefValue)
+	}
+
+	root.SetArgs([]string{"--require-devices", "uverbs,bogus", "discover"})
+	root.SetErr(&bytes.Buffer{})
+	root.SetOut(&bytes.Buffer{})
+	if err := root.Execute(); err == nil || !strings.Contains(err.Error(), "unknown device type") {
+		t.Errorf("expected unknown device type error, got: %v", err)
+	}
+}
+
+func TestRequiredDevicesPolicy(t *testing.T) {
+	cmd := rootCmd()
+
+	if _, ok := requiredDevicesPolicy(cmd); ok {
+		t.Error("no flag and no env should defer to the vendor profile")
+	}
+
+	t.Setenv(envRequireDevices, "uverbs, rdma_cm")
+	got, ok := requiredDevicesPolicy(cmd)
+	if !ok || strings.Join(got, ",") != "uverbs,rdma_cm" {
+		t.Errorf("env policy = %v (ok=%v), want [uverbs rdma_cm]", got, ok)
+	}
+
+	// The flag wins over the environment
+	if err := cmd.ParseFlags([]string{"--require-devices", "uverbs"}); err != nil {
+		t.Fatal(err)
+	}
+	got, ok = requiredDevicesPolicy(cmd)
+	if !ok || strings.Join(got, ",") != "uverbs" {
+		t.Errorf("flag policy = %v (ok=%v), want [uverbs]", got, ok)
+	}
+}
+
+func TestHostRoots(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		env       string
+		wantSysfs string
+		wantDev   string
+	}{
+		{"defaults", nil, "", "/sys", "/dev"},
+		{"host_root", []string{"--host-root", "/host"}, "", "/host/sys", "/host/dev"},
+		{"env", nil, "/mnt/host", "/mnt/host/sys", "/mnt/host/dev"},
+		{"flag_over_env", []string{"--host-root", "/host"}, "/mnt/host", "/host/sys", "/host/dev"},
+		{"explicit_sysfs_wins", []string{"--host-root", "/host", "--sysfs-root", "/hostsys"}, "", "/hostsys", "/host/dev"},
+		{"explicit_only", []string{"--dev-root", "/hostdev"}, "", "/sys", "/hostdev"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(envHostRoot, tc.env)
+			cmd := rootCmd()
+			if err := cmd.ParseFlags(tc.args); err != nil {
+				t.Fatal(err)
+			}
+			sysfs, dev := hostRoots(cmd)
+			if sysfs != tc.wantSysfs || dev != tc.wantDev {
+				t.Errorf("hostRoots = (%s, %s), want (%s, %s)", sysfs, dev, tc.wantSysfs, tc.wantDev)
+			}
+		})
+	}
+}
+
 // ──────────────────────────────────────────────
 //  version command
 // ──────────────────────────────────────────────
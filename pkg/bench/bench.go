@@ -0,0 +1,298 @@
+// Package bench runs a short RDMA bandwidth and latency self-test on a
+// device, to check that RC traffic actually flows before the device is
+// handed to workloads. The tool is pure Go and does not bind libibverbs, so
+// the RC QP tests are driven through the perftest tools (ib_write_bw and
+// ib_write_lat), which must be installed.
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Nativu5/rdma-cdi/pkg/doctor"
+)
+
+const (
+	// DefaultPort is the TCP port perftest exchanges QP information on.
+	DefaultPort = 18515
+	// DefaultDuration is how long each test runs.
+	DefaultDuration = 5 * time.Second
+
+	bwTool  = "ib_write_bw"
+	latTool = "ib_write_lat"
+)
+
+// ErrToolMissing is returned (wrapped) when a perftest tool is not installed.
+var ErrToolMissing = errors.New("perftest tool not found")
+
+// connectRetries and connectDelay bound how long a client waits for its
+// server to listen.
+var (
+	connectRetries = 20
+	connectDelay   = 250 * time.Millisecond
+)
+
+// Option configures Run and Serve.
+type Option func(*options)
+
+type options struct {
+	ibPort   int
+	tcpPort  int
+	gidIndex int
+	duration time.Duration
+	bwTool   string
+	latTool  string
+}
+
+// WithIbPort sets the RDMA port of the device to test. Defaults to 1.
+func WithIbPort(port int) Option {
+	return func(o *options) {
+		o.ibPort = port
+	}
+}
+
+// WithTCPPort sets the TCP port perftest exchanges QP information on.
+// Defaults to DefaultPort.
+func WithTCPPort(port int) Option {
+	return func(o *options) {
+		o.tcpPort = port
+	}
+}
+
+// WithGIDIndex sets the GID index to use, which RoCE setups may need.
+// By default perftest picks one.
+func WithGIDIndex(index int) Option {
+	return func(o *options) {
+		o.gidIndex = index
+	}
+}
+
+// WithDuration sets how long each test runs. Defaults to DefaultDuration.
+func WithDuration(d time.Duration) Option {
+	return func(o *options) {
+		o.duration = d
+	}
+}
+
+// WithTools overrides the bandwidth and latency tools, e.g. to use
+// absolute paths. They must accept the ib_write_bw/ib_write_lat flags.
+func WithTools(bw, lat string) Option {
+	return func(o *options) {
+		o.bwTool = bw
+		o.latTool = lat
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		ibPort:   1,
+		tcpPort:  DefaultPort,
+		gidIndex: -1,
+		duration: DefaultDuration,
+		bwTool:   bwTool,
+		latTool:  latTool,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Result is the outcome of a self-test.
+type Result struct {
+	IbDev string `json:"ibdev"`
+	// Peer is the server tested against, or empty for loopback.
+	Peer string `json:"peer,omitempty"`
+	// BandwidthGbps is the average RDMA write bandwidth.
+	BandwidthGbps float64 `json:"bandwidth_gbps"`
+	// LatencyUsec is the average RDMA write latency.
+	LatencyUsec float64 `json:"latency_usec"`
+}
+
+// Run measures bandwidth and then latency on ibdev. With an empty peer
+// the test runs in loopback, server and client on this host; otherwise the
+// client connects to peer ("host" or "host:port"), where Serve must be
+// running.
+func Run(ctx context.Context, ibdev, peer string, opts ...Option) (*Result, error) {
+	o := newOptions(opts)
+	host := "localhost"
+	if peer != "" {
+		host = peer
+		if h, p, err := net.SplitHostPort(peer); err == nil {
+			port, err := strconv.Atoi(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid peer %q: %w", peer, err)
+			}
+			host, o.tcpPort = h, port
+		}
+	}
+	for _, tool := range []string{o.bwTool, o.latTool} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return nil, fmt.Errorf("%w: %s (install perftest): %w", ErrToolMissing, tool, err)
+		}
+	}
+
+	res := &Result{IbDev: ibdev, Peer: peer}
+	out, err := o.test(ctx, o.bwTool, ibdev, host, peer == "")
+	if err != nil {
+		return nil, err
+	}
+	if res.BandwidthGbps, err = parseColumn(out, 3); err != nil {
+		return nil, fmt.Errorf("cannot parse %s output: %w", o.bwTool, err)
+	}
+	if out, err = o.test(ctx, o.latTool, ibdev, host, peer == ""); err != nil {
+		return nil, err
+	}
+	if res.LatencyUsec, err = parseColumn(out, 2); err != nil {
+		return nil, fmt.Errorf("cannot parse %s output: %w", o.latTool, err)
+	}
+	return res, nil
+}
+
+// Serve runs the server side of the bandwidth and latency tests once, for
+// a Run with a peer on another host.
+func Serve(ctx context.Context, ibdev string, opts ...Option) error {
+	o := newOptions(opts)
+	for _, tool := range []string{o.bwTool, o.latTool} {
+		if out, err := exec.CommandContext(ctx, tool, o.args(tool, ibdev)...).CombinedOutput(); err != nil {
+			return fmt.Errorf("%s server failed: %w: %s", tool, err, bytes.TrimSpace(out))
+		}
+	}
+	return nil
+}
+
+// test runs tool as a client against host, starting the server first in
+// loopback mode, and returns the client output.
+func (o options) test(ctx context.Context, tool, ibdev, host string, loopback bool) ([]byte, error) {
+	var serverOut bytes.Buffer
+	stopServer := func() {}
+	if loopback {
+		server := exec.CommandContext(ctx, tool, o.args(tool, ibdev)...)
+		server.Stdout, server.Stderr = &serverOut, &serverOut
+		if err := server.Start(); err != nil {
+			return nil, fmt.Errorf("cannot start %s server: %w", tool, err)
+		}
+		var stopped bool
+		stopServer = func() {
+			if !stopped {
+				stopped = true
+				server.Process.Kill()
+				server.Wait()
+			}
+		}
+		defer stopServer()
+	}
+
+	var out []byte
+	var err error
+	for attempt := 0; attempt < connectRetries; attempt++ {
+		out, err = exec.CommandContext(ctx, tool, append(o.args(tool, ibdev), host)...).CombinedOutput()
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		// The server may not be listening yet
+		log.Debugf("%s client attempt %d failed: %v", tool, attempt+1, err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(connectDelay):
+		}
+	}
+	if err != nil {
+		if loopback {
+			// Wait for the server so its output is complete
+			stopServer()
+			return nil, fmt.Errorf("%s failed: %w: %s (server: %s)", tool, err, bytes.TrimSpace(out), bytes.TrimSpace(serverOut.Bytes()))
+		}
+		return nil, fmt.Errorf("%s failed: %w: %s", tool, err, bytes.TrimSpace(out))
+	}
+	return out, nil
+}
+
+// args returns the flags shared by server and client.
+func (o options) args(tool, ibdev string) []string {
+	args := []string{"-d", ibdev, "-i", strconv.Itoa(o.ibPort), "-p", strconv.Itoa(o.tcpPort),
+		"-D", strconv.Itoa(max(1, int(o.duration.Seconds()))), "-F"}
+	if tool == o.bwTool {
+		args = append(args, "--report_gbits")
+	}
+	if o.gidIndex >= 0 {
+		args = append(args, "-x", strconv.Itoa(o.gidIndex))
+	}
+	return args
+}
+
+// parseColumn returns field col of the first result row of perftest
+// output: the row of numbers below the "#bytes" header. In duration (-D)
+// mode bandwidth rows are "#bytes #iterations peak average msgrate" and
+// latency rows "#bytes #iterations t_avg tps".
+func parseColumn(out []byte, col int) (float64, error) {
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	var inResults bool
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "#bytes" {
+			inResults = true
+			continue
+		}
+		if !inResults {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[0]); err != nil {
+			continue
+		}
+		if len(fields) <= col {
+			return 0, fmt.Errorf("result row %q has no column %d", sc.Text(), col)
+		}
+		return strconv.ParseFloat(fields[col], 64)
+	}
+	return 0, errors.New("no result row")
+}
+
+func init() {
+	doctor.Register(doctor.CheckInfo{ID: "bench.bandwidth", Check: "bench_bandwidth", Scope: doctor.ScopeDevice,
+		DefaultSeverity: doctor.Fail, Description: "RDMA write bandwidth is at least --min-bw-gbps (bench only)"})
+	doctor.Register(doctor.CheckInfo{ID: "bench.latency", Check: "bench_latency", Scope: doctor.ScopeDevice,
+		DefaultSeverity: doctor.Fail, Description: "RDMA write latency is at most --max-lat-us (bench only)"})
+}
+
+// Thresholds are the limits Evaluate checks a Result against. Zero values
+// disable a check.
+type Thresholds struct {
+	MinBandwidthGbps float64
+	MaxLatencyUsec   float64
+}
+
+// Evaluate turns a Result into PASS/FAIL checks, in the form doctor
+// reports use.
+func Evaluate(res *Result, t Thresholds, device string) []doctor.CheckResult {
+	bw := doctor.CheckResult{Check: "bench_bandwidth", Severity: doctor.Pass, Device: device,
+		Message: fmt.Sprintf("RDMA write bandwidth: %.2f Gb/s", res.BandwidthGbps)}
+	if t.MinBandwidthGbps > 0 {
+		bw.Message += fmt.Sprintf(" (minimum %.2f)", t.MinBandwidthGbps)
+		if res.BandwidthGbps < t.MinBandwidthGbps {
+			bw.Severity = doctor.Fail
+		}
+	}
+	lat := doctor.CheckResult{Check: "bench_latency", Severity: doctor.Pass, Device: device,
+		Message: fmt.Sprintf("RDMA write latency: %.2f us", res.LatencyUsec)}
+	if t.MaxLatencyUsec > 0 {
+		lat.Message += fmt.Sprintf(" (maximum %.2f)", t.MaxLatencyUsec)
+		if res.LatencyUsec > t.MaxLatencyUsec {
+			lat.Severity = doctor.Fail
+		}
+	}
+	return []doctor.CheckResult{bw, lat}
+}
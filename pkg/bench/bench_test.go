@@ -0,0 +1,81 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Nativu5/rdma-cdi/pkg/doctor"
+)
+
+const (
+	bwOutput = `---------------------------------------------------------------------------------------
+ #bytes     #iterations    BW peak[Gb/sec]    BW average[Gb/sec]   MsgRate[Mpps]
+ 65536      846212           0.00               92.41              0.176263
+---------------------------------------------------------------------------------------
+`
+	latOutput = `---------------------------------------------------------------------------------------
+ #bytes        #iterations       t_avg[usec]    tps average
+ 2             2413478          1.04           963208.74
+---------------------------------------------------------------------------------------
+`
+)
+
+// fakeTool writes a script that prints output, standing in for perftest.
+func fakeTool(t *testing.T, name, output string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	script := "#!/bin/sh\ncat <<'EOF'\n" + output + "EOF\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun_Loopback(t *testing.T) {
+	tools := WithTools(fakeTool(t, "ib_write_bw", bwOutput), fakeTool(t, "ib_write_lat", latOutput))
+	res, err := Run(context.Background(), "mlx5_0", "", tools)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if res.BandwidthGbps != 92.41 || res.LatencyUsec != 1.04 {
+		t.Errorf("Run() = %+v, want 92.41 Gb/s and 1.04 us", res)
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	bw := fakeTool(t, "ib_write_bw", bwOutput)
+	if _, err := Run(context.Background(), "mlx5_0", "", WithTools(bw, filepath.Join(t.TempDir(), "missing"))); !errors.Is(err, ErrToolMissing) {
+		t.Errorf("missing tool: error = %v, want ErrToolMissing", err)
+	}
+	garbage := fakeTool(t, "ib_write_lat", "Couldn't connect\n")
+	if _, err := Run(context.Background(), "mlx5_0", "", WithTools(bw, garbage)); err == nil {
+		t.Error("output without a result row should fail")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	res := &Result{BandwidthGbps: 90, LatencyUsec: 2}
+	tests := []struct {
+		name       string
+		thresholds Thresholds
+		want       []doctor.Severity
+	}{
+		{"no thresholds", Thresholds{}, []doctor.Severity{doctor.Pass, doctor.Pass}},
+		{"within", Thresholds{MinBandwidthGbps: 80, MaxLatencyUsec: 3}, []doctor.Severity{doctor.Pass, doctor.Pass}},
+		{"slow", Thresholds{MinBandwidthGbps: 95}, []doctor.Severity{doctor.Fail, doctor.Pass}},
+		{"high latency", Thresholds{MaxLatencyUsec: 1.5}, []doctor.Severity{doctor.Pass, doctor.Fail}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checks := Evaluate(res, tt.thresholds, "0000:17:00.0")
+			for i, want := range tt.want {
+				if checks[i].Severity != want {
+					t.Errorf("%s = %s, want %s (%s)", checks[i].Check, checks[i].Severity, want, checks[i].Message)
+				}
+			}
+		})
+	}
+}
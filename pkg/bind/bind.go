@@ -0,0 +1,304 @@
+// Package bind moves PCI functions between drivers through sysfs, e.g.
+// from mlx5_core to vfio-pci before a DPDK workload takes a VF and back
+// afterwards, replacing the manual echo into driver/unbind.
+//
+// The driver is chosen with driver_override, so the function cannot be
+// claimed by another driver in between, and the result is verified.
+package bind
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Nativu5/rdma-cdi/pkg/rdma"
+)
+
+// VfioPCI is the userspace I/O driver DPDK and VMs take functions with.
+const VfioPCI = "vfio-pci"
+
+// DefaultProcRoot is where InUse looks for processes holding device nodes.
+const DefaultProcRoot = "/proc"
+
+var (
+	// ErrInUse means the function is busy and was left alone; WithForce
+	// skips the check.
+	ErrInUse = errors.New("device in use")
+	// ErrDriverNotLoaded means the target driver is not registered with
+	// the PCI bus, i.e. its module is not loaded.
+	ErrDriverNotLoaded = errors.New("driver not loaded")
+)
+
+// Option customizes Bind and InUse.
+type Option func(*options)
+
+type options struct {
+	sysfsRoot string
+	procRoot  string
+	nodes     []string
+	force     bool
+	dryRun    bool
+}
+
+// WithSysfsRoot sets where the host sysfs is mounted (e.g. "/host/sys").
+// Defaults to rdma.DefaultSysfsRoot.
+func WithSysfsRoot(root string) Option {
+	return func(o *options) {
+		o.sysfsRoot = root
+	}
+}
+
+// WithProcRoot sets where the host /proc is mounted. Defaults to
+// DefaultProcRoot.
+func WithProcRoot(root string) Option {
+	return func(o *options) {
+		o.procRoot = root
+	}
+}
+
+// WithDeviceNodes adds host device nodes of the function, such as its
+// uverbs devices, that must not be open for it to count as unused.
+func WithDeviceNodes(paths ...string) Option {
+	return func(o *options) {
+		o.nodes = append(o.nodes, paths...)
+	}
+}
+
+// WithForce rebinds the function even if it is in use.
+func WithForce() Option {
+	return func(o *options) {
+		o.force = true
+	}
+}
+
+// WithDryRun runs the checks without changing the binding.
+func WithDryRun() Option {
+	return func(o *options) {
+		o.dryRun = true
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{sysfsRoot: rdma.DefaultSysfsRoot, procRoot: DefaultProcRoot}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Result describes a rebind.
+type Result struct {
+	PciAddress string `json:"pci_address"`
+	// From is the driver bound before, "" if none was.
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Changed is false when the function was already bound to To, or on a
+	// dry run.
+	Changed bool `json:"changed"`
+	DryRun  bool `json:"dry_run,omitempty"`
+}
+
+// writeAttr writes a sysfs attribute; replaceable in tests, where no
+// kernel acts on the writes.
+var writeAttr = func(path, value string) error {
+	return os.WriteFile(path, []byte(value), 0200)
+}
+
+// Bind binds the function at pci to driver, unbinding it from its current
+// one. It fails with ErrInUse if the function is busy (see InUse), and
+// with ErrDriverNotLoaded if driver is not available. If the function does
+// not come up on driver, Bind tries to return it to its previous driver.
+func Bind(pci, driver string, opts ...Option) (*Result, error) {
+	o := newOptions(opts)
+	if driver == "" || strings.ContainsAny(driver, "/\n") {
+		return nil, fmt.Errorf("invalid driver name %q", driver)
+	}
+	devDir := filepath.Join(o.sysfsRoot, "bus/pci/devices", pci)
+	if _, err := os.Stat(devDir); err != nil {
+		return nil, fmt.Errorf("%w: PCI device %s", rdma.ErrDeviceNotFound, pci)
+	}
+	if _, err := os.Stat(filepath.Join(o.sysfsRoot, "bus/pci/drivers", driver)); err != nil {
+		return nil, fmt.Errorf("%w: %s (load it with 'modprobe %s')", ErrDriverNotLoaded, driver, driver)
+	}
+
+	from, err := CurrentDriver(o.sysfsRoot, pci)
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{PciAddress: pci, From: from, To: driver, DryRun: o.dryRun}
+	if from == driver {
+		return res, nil
+	}
+	if driver == VfioPCI {
+		if _, err := iommuGroup(devDir); err != nil {
+			return nil, fmt.Errorf("%s has no IOMMU group, so %s cannot take it: enable the IOMMU (e.g. intel_iommu=on or amd_iommu=on)", pci, VfioPCI)
+		}
+	}
+	if from != "" && !o.force {
+		reasons, err := inUse(devDir, o)
+		if err != nil {
+			return nil, err
+		}
+		if len(reasons) > 0 {
+			return nil, fmt.Errorf("%w: %s %s (use --force to rebind anyway)", ErrInUse, pci, strings.Join(reasons, "; "))
+		}
+	}
+	if o.dryRun {
+		return res, nil
+	}
+
+	if err := probe(o.sysfsRoot, devDir, pci, from, driver); err != nil {
+		if now, _ := CurrentDriver(o.sysfsRoot, pci); from != "" && now != from {
+			if rerr := probe(o.sysfsRoot, devDir, pci, now, from); rerr != nil {
+				log.Warnf("cannot return %s to %s: %v", pci, from, rerr)
+			}
+		}
+		return nil, err
+	}
+	res.Changed = true
+	return res, nil
+}
+
+// probe unbinds the function from its driver, if any, and probes it with
+// driver_override set to driver. The override is kept for vfio-pci, which
+// would otherwise lose the function to its kernel driver on a rescan, and
+// cleared for kernel drivers.
+func probe(sysfsRoot, devDir, pci, from, driver string) error {
+	override := filepath.Join(devDir, "driver_override")
+	if err := writeAttr(override, driver); err != nil {
+		return fmt.Errorf("cannot set driver_override of %s: %w", pci, err)
+	}
+	if from != "" {
+		if err := writeAttr(filepath.Join(devDir, "driver", "unbind"), pci); err != nil {
+			return fmt.Errorf("cannot unbind %s from %s: %w", pci, from, err)
+		}
+	}
+	if err := writeAttr(filepath.Join(sysfsRoot, "bus/pci/drivers_probe"), pci); err != nil {
+		return fmt.Errorf("cannot probe %s: %w", pci, err)
+	}
+	if driver != VfioPCI {
+		if err := writeAttr(override, "\n"); err != nil {
+			log.Warnf("cannot clear driver_override of %s: %v", pci, err)
+		}
+	}
+
+	got, err := CurrentDriver(sysfsRoot, pci)
+	if err != nil {
+		return err
+	}
+	if got != driver {
+		if got == "" {
+			got = "no driver"
+		}
+		return fmt.Errorf("%s is bound to %s after probing, want %s", pci, got, driver)
+	}
+	return nil
+}
+
+// CurrentDriver returns the driver bound to the function at pci, or "" if
+// none is.
+func CurrentDriver(sysfsRoot, pci string) (string, error) {
+	target, err := os.Readlink(filepath.Join(sysfsRoot, "bus/pci/devices", pci, "driver"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot read driver of %s: %w", pci, err)
+	}
+	return filepath.Base(target), nil
+}
+
+// InUse returns why the function at pci should not be unbound: network
+// interfaces that are up, enabled SR-IOV VFs, and processes holding its
+// device nodes (those of WithDeviceNodes and its VFIO group). An empty
+// list means it is idle.
+func InUse(pci string, opts ...Option) ([]string, error) {
+	o := newOptions(opts)
+	return inUse(filepath.Join(o.sysfsRoot, "bus/pci/devices", pci), o)
+}
+
+func inUse(devDir string, o options) ([]string, error) {
+	var reasons []string
+
+	netdevs, _ := os.ReadDir(filepath.Join(devDir, "net"))
+	for _, n := range netdevs {
+		data, err := os.ReadFile(filepath.Join(devDir, "net", n.Name(), "flags"))
+		if err != nil {
+			continue
+		}
+		// IFF_UP
+		if flags, err := strconv.ParseUint(strings.TrimSpace(string(data)), 0, 32); err == nil && flags&0x1 != 0 {
+			reasons = append(reasons, fmt.Sprintf("has interface %s up", n.Name()))
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(devDir, "sriov_numvfs")); err == nil {
+		if n, _ := strconv.Atoi(strings.TrimSpace(string(data))); n > 0 {
+			reasons = append(reasons, fmt.Sprintf("has %d SR-IOV VF(s) enabled", n))
+		}
+	}
+
+	nodes := slices.Clone(o.nodes)
+	if group, err := iommuGroup(devDir); err == nil {
+		nodes = append(nodes, filepath.Join("/dev/vfio", group))
+	}
+	holders, err := openers(o.procRoot, nodes)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		if pids := holders[node]; len(pids) > 0 {
+			reasons = append(reasons, fmt.Sprintf("has %s open in PID(s) %s", node, strings.Join(pids, ", ")))
+		}
+	}
+	return reasons, nil
+}
+
+// iommuGroup returns the IOMMU group number of the function at devDir.
+func iommuGroup(devDir string) (string, error) {
+	target, err := os.Readlink(filepath.Join(devDir, "iommu_group"))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(target), nil
+}
+
+// openers maps each of nodes that a process under procRoot has open to
+// the PIDs holding it. Processes that vanish or cannot be inspected are
+// skipped.
+func openers(procRoot string, nodes []string) (map[string][]string, error) {
+	holders := make(map[string][]string)
+	if len(nodes) == 0 {
+		return holders, nil
+	}
+	procs, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list processes: %w", err)
+	}
+	for _, p := range procs {
+		if _, err := strconv.Atoi(p.Name()); err != nil {
+			continue
+		}
+		fdDir := filepath.Join(procRoot, p.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !slices.Contains(nodes, target) {
+				continue
+			}
+			if !slices.Contains(holders[target], p.Name()) {
+				holders[target] = append(holders[target], p.Name())
+			}
+		}
+	}
+	return holders, nil
+}
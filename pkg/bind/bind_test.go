@@ -0,0 +1,153 @@
+package bind
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Nativu5/rdma-cdi/pkg/rdma"
+	"github.com/Nativu5/rdma-cdi/pkg/rdma/rdmatest"
+)
+
+// fakeKernel stands in for the PCI core: unbind drops the driver link and
+// drivers_probe binds the function to its driver_override, if loaded.
+func fakeKernel(t *testing.T, sysfs string) *[]string {
+	t.Helper()
+	var writes []string
+	orig := writeAttr
+	t.Cleanup(func() { writeAttr = orig })
+	writeAttr = func(path, value string) error {
+		rel, _ := filepath.Rel(sysfs, path)
+		writes = append(writes, rel+"="+strings.TrimSpace(value))
+		switch filepath.Base(path) {
+		case "unbind":
+			return os.Remove(filepath.Join(sysfs, "bus/pci/devices", value, "driver"))
+		case "drivers_probe":
+			devDir := filepath.Join(sysfs, "bus/pci/devices", value)
+			override, _ := os.ReadFile(filepath.Join(devDir, "driver_override"))
+			driver := filepath.Join(sysfs, "bus/pci/drivers", strings.TrimSpace(string(override)))
+			if _, err := os.Stat(driver); err != nil {
+				return nil // no driver takes it
+			}
+			return os.Symlink(driver, filepath.Join(devDir, "driver"))
+		}
+		return os.WriteFile(path, []byte(value), 0644)
+	}
+	return &writes
+}
+
+func setup(t *testing.T) string {
+	t.Helper()
+	tree := rdmatest.MustBuildTree(t, filepath.Join("..", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+	sys := tree.SysfsRoot
+	if err := os.MkdirAll(filepath.Join(sys, "bus/pci/drivers", VfioPCI), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(sys, "kernel/iommu_groups/42"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(sys, "kernel/iommu_groups/42"), filepath.Join(sys, "bus/pci/devices/0000:17:00.2/iommu_group")); err != nil {
+		t.Fatal(err)
+	}
+	return sys
+}
+
+func TestBind(t *testing.T) {
+	sys := setup(t)
+	writes := fakeKernel(t, sys)
+	proc := t.TempDir()
+
+	res, err := Bind("0000:17:00.2", VfioPCI, WithSysfsRoot(sys), WithProcRoot(proc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Changed || res.From != "mlx5_core" || res.To != VfioPCI {
+		t.Errorf("result = %+v", res)
+	}
+	want := "bus/pci/devices/0000:17:00.2/driver_override=vfio-pci " +
+		"bus/pci/devices/0000:17:00.2/driver/unbind=0000:17:00.2 bus/pci/drivers_probe=0000:17:00.2"
+	if got := strings.Join(*writes, " "); got != want {
+		t.Errorf("writes = %s, want %s", got, want)
+	}
+
+	// Back to the kernel driver, clearing the override
+	*writes = nil
+	if _, err := Bind("0000:17:00.2", "mlx5_core", WithSysfsRoot(sys), WithProcRoot(proc)); err != nil {
+		t.Fatal(err)
+	}
+	if got := (*writes)[len(*writes)-1]; got != "bus/pci/devices/0000:17:00.2/driver_override=" {
+		t.Errorf("last write = %s, want the override cleared", got)
+	}
+
+	// Already bound: nothing to do
+	*writes = nil
+	res, err = Bind("0000:17:00.2", "mlx5_core", WithSysfsRoot(sys))
+	if err != nil || res.Changed || len(*writes) != 0 {
+		t.Errorf("rebinding to the same driver: %+v, %v, writes %v", res, err, *writes)
+	}
+}
+
+func TestBind_Refusals(t *testing.T) {
+	sys := setup(t)
+	writes := fakeKernel(t, sys)
+	proc := t.TempDir()
+
+	pf := filepath.Join(sys, "bus/pci/devices/0000:17:00.0")
+	if err := os.WriteFile(filepath.Join(pf, "sriov_numvfs"), []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(pf, "net/enp23s0f0np0/flags"), []byte("0x1003\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A process holding the VF's uverbs device
+	if err := os.MkdirAll(filepath.Join(proc, "4242", "fd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/dev/infiniband/uverbs2", filepath.Join(proc, "4242", "fd", "7")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		pci    string
+		driver string
+		opts   []Option
+		want   error
+		msg    string
+	}{
+		{"no IOMMU group", "0000:17:00.0", VfioPCI, nil, nil, "no IOMMU group"},
+		{"open node", "0000:17:00.2", VfioPCI, []Option{WithDeviceNodes("/dev/infiniband/uverbs2")}, ErrInUse, "PID(s) 4242"},
+		{"unknown device", "0000:99:00.0", VfioPCI, nil, rdma.ErrDeviceNotFound, ""},
+		{"driver not loaded", "0000:17:00.2", "igb_uio", nil, ErrDriverNotLoaded, "modprobe igb_uio"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Bind(tt.pci, tt.driver, append([]Option{WithSysfsRoot(sys), WithProcRoot(proc)}, tt.opts...)...)
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+			if tt.msg != "" && (err == nil || !strings.Contains(err.Error(), tt.msg)) {
+				t.Errorf("error = %v, want it to mention %q", err, tt.msg)
+			}
+		})
+	}
+	if len(*writes) != 0 {
+		t.Errorf("refused binds wrote %v", *writes)
+	}
+
+	reasons, err := InUse("0000:17:00.0", WithSysfsRoot(sys), WithProcRoot(proc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reasons) != 2 || !strings.Contains(reasons[0], "enp23s0f0np0 up") || !strings.Contains(reasons[1], "1 SR-IOV VF") {
+		t.Errorf("InUse() = %v", reasons)
+	}
+
+	res, err := Bind("0000:17:00.2", VfioPCI, WithSysfsRoot(sys), WithProcRoot(proc),
+		WithDeviceNodes("/dev/infiniband/uverbs2"), WithForce(), WithDryRun())
+	if err != nil || res.Changed || !res.DryRun {
+		t.Errorf("forced dry run: %+v, %v", res, err)
+	}
+}
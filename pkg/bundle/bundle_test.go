@@ -0,0 +1,154 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Nativu5/rdma-cdi/pkg/rdma"
+	"github.com/Nativu5/rdma-cdi/pkg/rdma/rdmatest"
+)
+
+func TestCollectAndOpen_RoundTrip(t *testing.T) {
+	tree := rdmatest.MustBuildTree(t, filepath.Join("..", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+	// Identifiers that must not leave the host
+	mac := filepath.Join(tree.SysfsRoot, "class/net/enp23s0f0np0/address")
+	if err := os.WriteFile(mac, []byte("b8:ce:f6:00:00:01\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	specDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(specDir, "rdma-cdi_rdma_net.yaml"), []byte("cdiVersion: 0.6.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	live, err := tree.Discoverer(rdma.WithNetnsMode(rdma.NetnsModeExclusive)).DiscoverAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = Collect(&buf, live,
+		WithSysfsRoot(tree.SysfsRoot), WithDevRoot(tree.DevRoot), WithSpecDirs(specDir),
+		WithVersion("v1.2.3"), WithFile("doctor.json", []byte("{}\n")))
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+
+	bundlePath := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	if err := os.WriteFile(bundlePath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := Open(bundlePath)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer snap.Close()
+
+	if snap.Manifest.Version != "v1.2.3" || len(snap.Manifest.Devices) != 3 || snap.Manifest.NetnsMode != rdma.NetnsModeExclusive {
+		t.Errorf("manifest = %+v", snap.Manifest)
+	}
+	offline, err := rdma.NewDiscoverer(rdma.WithSysfsRoot(snap.SysfsRoot), rdma.WithDevRoot(snap.DevRoot),
+		rdma.WithOffline(), rdma.WithNetnsMode(snap.Manifest.NetnsMode)).DiscoverAll()
+	if err != nil {
+		t.Fatalf("DiscoverAll on the snapshot: %v", err)
+	}
+	// Netlink knows nothing of the fixture's netdevs, which only live
+	// discovery queries
+	for _, dev := range live {
+		dev.Warnings = nil
+	}
+	if !reflect.DeepEqual(offline, live) {
+		got, _ := json.Marshal(offline)
+		want, _ := json.Marshal(live)
+		t.Errorf("snapshot discovery differs from live:\n got %s\nwant %s", got, want)
+	}
+
+	if got, _ := os.ReadFile(filepath.Join(snap.SysfsRoot, "class/net/enp23s0f0np0/address")); string(got) != Redacted {
+		t.Errorf("MAC address captured as %q, want it redacted", got)
+	}
+	if _, err := os.Stat(filepath.Join(snap.Dir, SpecsDir, specDir, "rdma-cdi_rdma_net.yaml")); err != nil {
+		t.Errorf("spec file not captured: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(snap.Dir, "doctor.json")); err != nil {
+		t.Errorf("extra file not captured: %v", err)
+	}
+
+	dir := snap.Dir
+	snap.Close()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Close() left %s behind", dir)
+	}
+}
+
+func TestOpen_Invalid(t *testing.T) {
+	manifest := entry{name: ManifestFile, body: "{}"}
+	tests := []struct {
+		name    string
+		entries []entry
+	}{
+		{"no manifest", []entry{{name: "sys/", dir: true}}},
+		{"parent escape", []entry{manifest, {name: "../evil", body: "x"}}},
+		{"absolute symlink", []entry{manifest, {name: "sys/link", link: "/etc"}}},
+		{"relative symlink escape", []entry{manifest, {name: "sys/link", link: "../../.."}}},
+		{"chained symlinks", []entry{
+			manifest,
+			{name: "s/t/b", link: "../.."},
+			{name: "s/t/b/c", link: "../x"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTarGz(t, tt.entries)
+			if _, err := Open(path); !errors.Is(err, ErrInvalidBundle) {
+				t.Errorf("Open() error = %v, want ErrInvalidBundle", err)
+			}
+		})
+	}
+}
+
+type entry struct {
+	name string
+	body string
+	link string
+	dir  bool
+}
+
+func writeTarGz(t *testing.T, entries []entry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		switch {
+		case e.dir:
+			h.Typeflag, h.Size = tar.TypeDir, 0
+		case e.link != "":
+			h.Typeflag, h.Linkname, h.Size = tar.TypeSymlink, e.link, 0
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,443 @@
+// Package bundle collects and opens support bundles: a gzipped tar with a
+// sanitized snapshot of the sysfs and /dev entries RDMA discovery reads,
+// the loaded kernel modules, the installed CDI specs and the output of
+// discover and doctor, for attaching to bug reports.
+//
+// The snapshot keeps the layout discovery expects, with PCI devices as
+// plain directories under sys/bus/pci/devices, so an opened bundle can be
+// used as the sysfs and dev roots of an rdma.Discoverer.
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Nativu5/rdma-cdi/pkg/cdi"
+	"github.com/Nativu5/rdma-cdi/pkg/rdma"
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// Locations inside a bundle.
+const (
+	ManifestFile = "manifest.json"
+	SysDir       = "sys"
+	DevDir       = "dev"
+	SpecsDir     = "cdi"
+	ModulesFile  = "proc/modules"
+	// UptimeFile dates the AER counters, which count since boot.
+	UptimeFile = "proc/uptime"
+	// ProcConfigFile and BootDir hold the kernel build configuration, as
+	// /proc/config.gz and /boot/config-<release> on the host.
+	ProcConfigFile = "proc/config.gz"
+	BootDir        = "boot"
+)
+
+// Redacted replaces the value of identifying attributes such as MAC
+// addresses and GUIDs.
+const Redacted = "redacted\n"
+
+// maxAttrSize caps how much of a sysfs attribute is captured.
+const maxAttrSize = 64 << 10
+
+// Attributes captured per sysfs object. Everything else is left out, both
+// to keep bundles free of host identifiers and because reading arbitrary
+// sysfs files can block or have side effects.
+var (
+	pciAttrs = []string{
+		"vendor", "device", "subsystem_vendor", "subsystem_device", "class", "revision",
+		"numa_node", "local_cpulist", "sriov_numvfs", "sriov_totalvfs",
+		"current_link_speed", "current_link_width", "max_link_speed", "max_link_width",
+		"aer_dev_correctable", "aer_dev_nonfatal", "aer_dev_fatal",
+	}
+	netAttrs    = []string{"operstate", "carrier", "mtu", "type", "dev_id", "dev_port", "speed", "ifindex"}
+	ibdevAttrs  = []string{"node_type", "fw_ver", "hca_type", "hw_rev", "board_id"}
+	portAttrs   = []string{"state", "phys_state", "link_layer", "rate", "lid", "sm_lid"}
+	redactAttrs = map[string][]string{
+		"class/net":        {"address", "broadcast"},
+		"class/infiniband": {"node_guid", "sys_image_guid"},
+	}
+	// moduleParams are the modules whose parameters are captured; the
+	// others are recorded by name only.
+	moduleParams   = []string{"ib_core", "rdma_cm"}
+	charDevClasses = []string{"class/infiniband_verbs", "class/infiniband_mad", "class/infiniband_cm"}
+)
+
+// Manifest describes a bundle.
+type Manifest struct {
+	// Version is the rdma-cdi version that collected the bundle.
+	Version string `json:"version"`
+	// Created is when the bundle was collected.
+	Created time.Time `json:"created"`
+	// Kernel is the running kernel release.
+	Kernel string `json:"kernel,omitempty"`
+	// Devices lists the PCI addresses captured.
+	Devices []string `json:"devices"`
+	// NetnsMode is the RDMA netns mode the devices were discovered with.
+	NetnsMode string `json:"netns_mode,omitempty"`
+}
+
+// Option customizes Collect.
+type Option func(*options)
+
+type options struct {
+	sysfsRoot string
+	devRoot   string
+	specDirs  []string
+	version   string
+	files     map[string][]byte
+}
+
+// WithSysfsRoot sets where the host sysfs is mounted. Defaults to
+// rdma.DefaultSysfsRoot.
+func WithSysfsRoot(root string) Option {
+	return func(o *options) {
+		o.sysfsRoot = root
+	}
+}
+
+// WithDevRoot sets where the host /dev is mounted. Defaults to
+// rdma.DefaultDevRoot.
+func WithDevRoot(root string) Option {
+	return func(o *options) {
+		o.devRoot = root
+	}
+}
+
+// WithSpecDirs sets the CDI spec directories to capture. Defaults to
+// cdi.StandardSpecDirs.
+func WithSpecDirs(dirs ...string) Option {
+	return func(o *options) {
+		o.specDirs = append([]string{}, dirs...)
+	}
+}
+
+// WithVersion records the collecting tool's version in the manifest.
+func WithVersion(version string) Option {
+	return func(o *options) {
+		o.version = version
+	}
+}
+
+// WithFile adds a file at the top of the bundle, e.g. a doctor report.
+func WithFile(name string, data []byte) Option {
+	return func(o *options) {
+		o.files[name] = data
+	}
+}
+
+// Collect writes a bundle of devices, as discovered on the host, to w.
+// Parent PFs of VFs are captured as well. Unreadable entries are skipped:
+// a partial snapshot is more useful than none.
+func Collect(w io.Writer, devices []*types.RdmaDevice, opts ...Option) error {
+	o := options{
+		sysfsRoot: rdma.DefaultSysfsRoot,
+		devRoot:   rdma.DefaultDevRoot,
+		specDirs:  cdi.StandardSpecDirs(),
+		version:   "dev",
+		files:     make(map[string][]byte),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	gz := gzip.NewWriter(w)
+	c := &collector{
+		tw:   tar.NewWriter(gz),
+		o:    o,
+		now:  now().UTC(),
+		seen: make(map[string]bool),
+	}
+	c.collect(devices)
+	if c.err == nil {
+		c.err = c.tw.Close()
+	}
+	if err := gz.Close(); c.err == nil {
+		c.err = err
+	}
+	if c.err != nil {
+		return fmt.Errorf("failed to write bundle: %w", c.err)
+	}
+	return nil
+}
+
+// now is replaceable in tests.
+var now = time.Now
+
+// collector writes bundle entries, remembering the first error.
+type collector struct {
+	tw   *tar.Writer
+	o    options
+	now  time.Time
+	seen map[string]bool // entries already written
+	err  error
+}
+
+func (c *collector) collect(devices []*types.RdmaDevice) {
+	var pcis, ibdevs []string
+	for _, dev := range devices {
+		for _, addr := range []string{dev.PciAddress, dev.ParentPFAddress} {
+			if addr != "" && !slices.Contains(pcis, addr) {
+				pcis = append(pcis, addr)
+			}
+		}
+	}
+	for _, addr := range pcis {
+		ibdevs = append(ibdevs, c.pciDevice(addr)...)
+	}
+	// Only the slots holding the devices; a slot that lists a bridge
+	// above a device is not found again in the flat snapshot
+	for _, dev := range devices {
+		if dev.Slot != "" {
+			c.attrs(path.Join("bus/pci/slots", dev.Slot), []string{"address"})
+		}
+	}
+	for _, class := range charDevClasses {
+		c.charDevices(class, ibdevs)
+	}
+	c.attrs("class/misc/rdma_cm", []string{"dev"})
+	c.devNodes()
+	c.modules()
+	if data, err := os.ReadFile(filepath.Join(filepath.Dir(c.o.sysfsRoot), UptimeFile)); err == nil {
+		c.file(UptimeFile, data)
+	}
+	c.specs()
+
+	kernel, _ := os.ReadFile(filepath.Join(filepath.Dir(c.o.sysfsRoot), "proc/sys/kernel/osrelease"))
+	c.kernelConfig(strings.TrimSpace(string(kernel)))
+	c.json(ManifestFile, Manifest{
+		Version:   c.o.version,
+		Created:   c.now,
+		Kernel:    strings.TrimSpace(string(kernel)),
+		Devices:   pcis,
+		NetnsMode: netnsMode(devices),
+	})
+	names := make([]string, 0, len(c.o.files))
+	for name := range c.o.files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		c.file(name, c.o.files[name])
+	}
+}
+
+// pciDevice captures a PCI function with its netdevs and RDMA devices and
+// returns the names of the latter.
+func (c *collector) pciDevice(addr string) []string {
+	rel := path.Join("bus/pci/devices", addr)
+	c.attrs(rel, pciAttrs)
+
+	if target, err := os.Readlink(c.sys(rel, "driver")); err == nil {
+		driver := filepath.Base(target)
+		c.dir(path.Join(SysDir, "bus/pci/drivers", driver))
+		c.symlink(path.Join(SysDir, rel, "driver"), path.Join("../../../bus/pci/drivers", driver))
+	}
+	links, _ := filepath.Glob(c.sys(rel, "virtfn*"))
+	for _, link := range append(links, c.sys(rel, "physfn")) {
+		if target, err := os.Readlink(link); err == nil {
+			c.symlink(path.Join(SysDir, rel, filepath.Base(link)), path.Join("..", filepath.Base(target)))
+		}
+	}
+
+	netdevs, _ := os.ReadDir(c.sys(rel, "net"))
+	for _, n := range netdevs {
+		c.dir(path.Join(SysDir, rel, "net", n.Name()))
+		c.classDevice("class/net", n.Name(), addr, netAttrs)
+	}
+	var ibdevs []string
+	entries, _ := os.ReadDir(c.sys(rel, "infiniband"))
+	for _, ib := range entries {
+		ibdevs = append(ibdevs, ib.Name())
+		c.dir(path.Join(SysDir, rel, "infiniband", ib.Name()))
+		c.classDevice("class/infiniband", ib.Name(), addr, ibdevAttrs)
+		ports, _ := os.ReadDir(c.sys("class/infiniband", ib.Name(), "ports"))
+		for _, p := range ports {
+			c.attrs(path.Join("class/infiniband", ib.Name(), "ports", p.Name()), portAttrs)
+		}
+	}
+	return ibdevs
+}
+
+// classDevice captures <class>/<name> with its device link to addr.
+func (c *collector) classDevice(class, name, addr string, attrs []string) {
+	rel := path.Join(class, name)
+	c.attrs(rel, attrs)
+	for _, attr := range redactAttrs[class] {
+		if c.exists(c.sys(rel, attr)) {
+			c.file(path.Join(SysDir, rel, attr), []byte(Redacted))
+		}
+	}
+	c.symlink(path.Join(SysDir, rel, "device"), path.Join("../../../bus/pci/devices", addr))
+}
+
+// charDevices captures the char devices in class that belong to ibdevs.
+func (c *collector) charDevices(class string, ibdevs []string) {
+	entries, _ := os.ReadDir(c.sys(class))
+	for _, e := range entries {
+		owner, err := os.ReadFile(c.sys(class, e.Name(), "ibdev"))
+		if err != nil || !slices.Contains(ibdevs, strings.TrimSpace(string(owner))) {
+			continue
+		}
+		c.attrs(path.Join(class, e.Name()), []string{"ibdev", "dev"})
+	}
+}
+
+// devNodes records the RDMA device nodes as empty files: the bundle keeps
+// their names, not the nodes themselves.
+func (c *collector) devNodes() {
+	entries, _ := os.ReadDir(filepath.Join(c.o.devRoot, "infiniband"))
+	for _, e := range entries {
+		c.file(path.Join(DevDir, "infiniband", e.Name()), nil)
+	}
+}
+
+// modules records the loaded modules: /proc/modules next to the sysfs
+// root, and the module directories doctor looks for.
+func (c *collector) modules() {
+	if data, err := os.ReadFile(filepath.Join(filepath.Dir(c.o.sysfsRoot), ModulesFile)); err == nil {
+		c.file(ModulesFile, data)
+	}
+	entries, _ := os.ReadDir(c.sys("module"))
+	for _, e := range entries {
+		c.dir(path.Join(SysDir, "module", e.Name()))
+	}
+	for _, mod := range moduleParams {
+		params, _ := os.ReadDir(c.sys("module", mod, "parameters"))
+		names := make([]string, 0, len(params))
+		for _, p := range params {
+			names = append(names, p.Name())
+		}
+		c.attrs(path.Join("module", mod, "parameters"), names)
+	}
+}
+
+// kernelConfig captures the kernel build configuration doctor checks,
+// from next to the sysfs root like /proc/modules.
+func (c *collector) kernelConfig(release string) {
+	host := filepath.Dir(c.o.sysfsRoot)
+	names := []string{ProcConfigFile}
+	if release != "" {
+		names = append(names, path.Join(BootDir, "config-"+release))
+	}
+	for _, name := range names {
+		if data, err := os.ReadFile(filepath.Join(host, name)); err == nil {
+			c.file(name, data)
+		}
+	}
+}
+
+// specs captures the spec files of every CDI directory under SpecsDir,
+// keeping their host paths, e.g. cdi/etc/cdi/rdma-cdi_rdma_net.yaml.
+func (c *collector) specs() {
+	for _, dir := range c.o.specDirs {
+		files, _ := cdi.ListAllSpecs(dir)
+		for _, f := range files {
+			if data, err := readLimited(f); err == nil {
+				c.file(path.Join(SpecsDir, filepath.ToSlash(f)), data)
+			}
+		}
+	}
+}
+
+// attrs captures a sysfs object, if it exists, with those of the named
+// attribute files it has.
+func (c *collector) attrs(rel string, names []string) {
+	if !c.exists(c.sys(rel)) {
+		return
+	}
+	c.dir(path.Join(SysDir, rel))
+	for _, name := range names {
+		data, err := readLimited(c.sys(rel, name))
+		if err != nil {
+			continue
+		}
+		c.file(path.Join(SysDir, rel, name), data)
+	}
+}
+
+func (c *collector) sys(elem ...string) string {
+	return filepath.Join(append([]string{c.o.sysfsRoot}, elem...)...)
+}
+
+func (c *collector) exists(p string) bool {
+	_, err := os.Lstat(p)
+	return err == nil
+}
+
+func (c *collector) json(name string, v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		c.err = err
+		return
+	}
+	c.file(name, append(data, '\n'))
+}
+
+// dir writes a directory entry and any missing parents.
+func (c *collector) dir(name string) {
+	if c.err != nil || name == "." || c.seen[name] {
+		return
+	}
+	c.dir(path.Dir(name))
+	c.seen[name] = true
+	c.header(&tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: 0755})
+}
+
+func (c *collector) file(name string, data []byte) {
+	if c.seen[name] {
+		return
+	}
+	c.dir(path.Dir(name))
+	c.seen[name] = true
+	c.header(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(data))})
+	if c.err == nil {
+		_, c.err = c.tw.Write(data)
+	}
+}
+
+func (c *collector) symlink(name, target string) {
+	if c.seen[name] {
+		return
+	}
+	c.dir(path.Dir(name))
+	c.seen[name] = true
+	c.header(&tar.Header{Typeflag: tar.TypeSymlink, Name: name, Linkname: target, Mode: 0777})
+}
+
+func (c *collector) header(h *tar.Header) {
+	if c.err != nil {
+		return
+	}
+	h.ModTime = c.now
+	c.err = c.tw.WriteHeader(h)
+}
+
+// readLimited reads at most maxAttrSize bytes of a file.
+func readLimited(p string) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, maxAttrSize))
+}
+
+// netnsMode returns the RDMA netns mode devices were discovered with,
+// which is the same for all of them.
+func netnsMode(devices []*types.RdmaDevice) string {
+	for _, dev := range devices {
+		if dev.NetnsMode != "" {
+			return dev.NetnsMode
+		}
+	}
+	return ""
+}
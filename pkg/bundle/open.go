@@ -0,0 +1,191 @@
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidBundle is returned (wrapped) when a bundle cannot be opened.
+var ErrInvalidBundle = errors.New("invalid support bundle")
+
+// maxEntrySize caps the size of a single file extracted from a bundle.
+const maxEntrySize = 16 << 20
+
+// Snapshot is a bundle extracted to a temporary directory.
+type Snapshot struct {
+	// Dir is the extraction directory.
+	Dir string
+	// SysfsRoot and DevRoot are the captured sysfs and /dev trees.
+	SysfsRoot string
+	DevRoot   string
+	// Manifest describes the bundle.
+	Manifest Manifest
+}
+
+// Open extracts the bundle at path into a temporary directory. Entries
+// that would land outside it, through their name or a symlink target, make
+// the bundle invalid. Callers must Close the snapshot.
+func Open(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open bundle: %w", err)
+	}
+	defer f.Close()
+
+	dir, err := os.MkdirTemp("", "rdma-cdi-bundle-")
+	if err != nil {
+		return nil, fmt.Errorf("cannot open bundle: %w", err)
+	}
+	s := &Snapshot{
+		Dir:       dir,
+		SysfsRoot: filepath.Join(dir, SysDir),
+		DevRoot:   filepath.Join(dir, DevDir),
+	}
+	if err := extract(f, dir); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("%w %s: %w", ErrInvalidBundle, path, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err == nil {
+		err = json.Unmarshal(data, &s.Manifest)
+	}
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("%w %s: no readable manifest: %w", ErrInvalidBundle, path, err)
+	}
+	return s, nil
+}
+
+// KernelConfigPaths returns the captured kernel config files to try, in
+// the order doctor reads them on a live host.
+func (s *Snapshot) KernelConfigPaths() []string {
+	paths := []string{filepath.Join(s.Dir, ProcConfigFile)}
+	if s.Manifest.Kernel != "" {
+		paths = append(paths, filepath.Join(s.Dir, BootDir, "config-"+s.Manifest.Kernel))
+	}
+	return paths
+}
+
+// SpecDirs maps CDI spec directories of the collecting host to where
+// their captured specs are in the snapshot.
+func (s *Snapshot) SpecDirs(dirs []string) []string {
+	out := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		out = append(out, filepath.Join(s.Dir, SpecsDir, dir))
+	}
+	return out
+}
+
+// Close removes the extracted snapshot.
+func (s *Snapshot) Close() error {
+	return os.RemoveAll(s.Dir)
+}
+
+func extract(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(h.Name)
+		if !local(name) {
+			return fmt.Errorf("entry %q is outside the bundle", h.Name)
+		}
+		parent, err := mkdirIn(root, filepath.Dir(filepath.FromSlash(name)))
+		if err != nil {
+			return fmt.Errorf("entry %q: %w", h.Name, err)
+		}
+		target := filepath.Join(parent, path.Base(name))
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if h.Size > maxEntrySize {
+				return fmt.Errorf("entry %q is too large", h.Name)
+			}
+			data, err := io.ReadAll(io.LimitReader(tr, maxEntrySize))
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(target, data, 0644); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// Cleaning leaves ".." only in front, so the lexical check
+			// matches what the kernel resolves from the real parent
+			link := path.Clean(h.Linkname)
+			if path.IsAbs(link) || !within(root, filepath.Join(parent, filepath.FromSlash(link))) {
+				return fmt.Errorf("symlink %q points outside the bundle", h.Name)
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		default:
+			// Bundles only hold directories, files and symlinks
+			return fmt.Errorf("entry %q has unsupported type %q", h.Name, h.Typeflag)
+		}
+	}
+}
+
+// mkdirIn creates rel below root and returns its resolved path. Symlinks
+// extracted earlier may be followed, but not out of root.
+func mkdirIn(root, rel string) (string, error) {
+	dir := filepath.Join(root, rel)
+	// Resolve the deepest existing ancestor; what is left does not exist
+	// yet and so contains no symlinks
+	existing, rest := dir, ""
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = filepath.Dir(existing)
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	if !within(root, resolved) {
+		return "", errors.New("path leads outside the bundle")
+	}
+	resolved = filepath.Join(resolved, rest)
+	return resolved, os.MkdirAll(resolved, 0755)
+}
+
+// local reports whether the cleaned slash path p stays inside the bundle.
+func local(p string) bool {
+	return p != ".." && !strings.HasPrefix(p, "../") && !path.IsAbs(p)
+}
+
+// within reports whether p is root or below it.
+func within(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && local(filepath.ToSlash(rel))
+}
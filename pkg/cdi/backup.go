@@ -0,0 +1,204 @@
+package cdi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultBackupDir is where cleanup stores removed spec files.
+	DefaultBackupDir = "/var/lib/rdma-cdi/backups"
+
+	// LatestBackup can be passed to RestoreBackup instead of a backup ID.
+	LatestBackup = "latest"
+
+	backupManifest = "manifest.json"
+	backupIDLayout = "20060102T150405Z"
+)
+
+// ErrBackupNotFound is returned when a backup ID does not exist.
+var ErrBackupNotFound = errors.New("backup not found")
+
+// manifest records where each backed-up file came from so RestoreBackup can
+// put it back.
+type manifest struct {
+	CreatedAt time.Time      `json:"createdAt"`
+	Files     []manifestFile `json:"files"`
+}
+
+type manifestFile struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+// BackupSpecs copies the given spec files into a new timestamped directory
+// under backupRoot and returns its ID (the directory name).
+func BackupSpecs(backupRoot string, paths []string) (string, error) {
+	if backupRoot == "" {
+		backupRoot = DefaultBackupDir
+	}
+	if err := Files.MkdirAll(backupRoot, 0700); err != nil {
+		return "", fmt.Errorf("cannot create backup directory %s: %w", backupRoot, err)
+	}
+
+	ts := Clock().UTC()
+	id := ts.Format(backupIDLayout)
+	dir := filepath.Join(backupRoot, id)
+	// Two cleanups within the same second get distinct directories
+	for i := 2; ; i++ {
+		err := Files.Mkdir(dir, 0700)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", fmt.Errorf("cannot create backup directory %s: %w", dir, err)
+		}
+		id = fmt.Sprintf("%s-%d", ts.Format(backupIDLayout), i)
+		dir = filepath.Join(backupRoot, id)
+	}
+
+	m := manifest{CreatedAt: ts}
+	used := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return "", err
+		}
+		// Specs from different directories may share a base name
+		name := filepath.Base(p)
+		for i := 2; used[name]; i++ {
+			name = fmt.Sprintf("%d-%s", i, filepath.Base(p))
+		}
+		used[name] = true
+		if err := copyFile(abs, filepath.Join(dir, name)); err != nil {
+			return "", fmt.Errorf("cannot back up %s: %w", p, err)
+		}
+		m.Files = append(m.Files, manifestFile{Name: name, Source: abs})
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := Files.WriteFile(filepath.Join(dir, backupManifest), data, 0600); err != nil {
+		return "", fmt.Errorf("cannot write backup manifest: %w", err)
+	}
+
+	log.Infof("backed up %d spec file(s) to %s", len(paths), dir)
+	return id, nil
+}
+
+// ListBackups returns the IDs of all backups under backupRoot, oldest first.
+func ListBackups(backupRoot string) ([]string, error) {
+	if backupRoot == "" {
+		backupRoot = DefaultBackupDir
+	}
+	entries, err := Files.ReadDir(backupRoot)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read backup directory %s: %w", backupRoot, err)
+	}
+
+	var ids []string
+	for _, e := range entries {
+		if _, err := Files.Stat(filepath.Join(backupRoot, e.Name(), backupManifest)); e.IsDir() && err == nil {
+			ids = append(ids, e.Name())
+		}
+	}
+	// IDs are UTC timestamps, so lexical order is chronological
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// RestoreBackup copies the files of backup id (or LatestBackup) back to where
+// they were removed from, or into outputDir when it is non-empty. Existing
+// files are only overwritten when overwrite is true.
+func RestoreBackup(backupRoot, id, outputDir string, overwrite bool) ([]string, error) {
+	if backupRoot == "" {
+		backupRoot = DefaultBackupDir
+	}
+	if id == LatestBackup {
+		ids, err := ListBackups(backupRoot)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("%w: no backups in %s", ErrBackupNotFound, backupRoot)
+		}
+		id = ids[len(ids)-1]
+	}
+	// Reject IDs that would escape the backup root
+	if id == "" || filepath.Base(id) != id {
+		return nil, fmt.Errorf("%w: invalid backup ID %q", ErrBackupNotFound, id)
+	}
+
+	dir := filepath.Join(backupRoot, id)
+	data, err := Files.ReadFile(filepath.Join(dir, backupManifest))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrBackupNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read backup manifest: %w", err)
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("cannot parse backup manifest %s: %w", id, err)
+	}
+
+	// Check every destination first so a conflict restores nothing
+	dests := make([]string, len(m.Files))
+	seen := make(map[string]bool, len(m.Files))
+	for i, f := range m.Files {
+		dests[i] = f.Source
+		if outputDir != "" {
+			dests[i] = filepath.Join(outputDir, filepath.Base(f.Source))
+		}
+		if seen[dests[i]] {
+			return nil, fmt.Errorf("backup %s has several files for %s; restore without an output directory", id, dests[i])
+		}
+		seen[dests[i]] = true
+		if _, err := Files.Stat(dests[i]); err == nil && !overwrite {
+			return nil, fmt.Errorf("refusing to overwrite existing %s (use overwrite to replace it)", dests[i])
+		}
+	}
+
+	l, err := lockFiles(dests...)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+	restored := make([]string, 0, len(m.Files))
+	for i, f := range m.Files {
+		if err := Files.MkdirAll(filepath.Dir(dests[i]), 0755); err != nil {
+			return restored, err
+		}
+		if err := copyFile(filepath.Join(dir, f.Name), dests[i]); err != nil {
+			return restored, fmt.Errorf("cannot restore %s: %w", dests[i], err)
+		}
+		log.Infof("restored CDI spec file: %s", dests[i])
+		restored = append(restored, dests[i])
+	}
+	return restored, nil
+}
+
+// copyFile copies src to dst, preserving the permission bits.
+func copyFile(src, dst string) error {
+	fi, err := Files.Stat(src)
+	if err != nil {
+		return err
+	}
+	data, err := Files.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return Files.WriteFile(dst, data, fi.Mode().Perm())
+}
@@ -0,0 +1,143 @@
+package cdi
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func fixedClock(t *testing.T, ts time.Time) {
+	t.Helper()
+	orig := Clock
+	Clock = func() time.Time { return ts }
+	t.Cleanup(func() { Clock = orig })
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	fixedClock(t, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	specDir := t.TempDir()
+	backupRoot := t.TempDir()
+	seedCleanupDir(t, specDir)
+
+	files, _ := ListSpecs(specDir, "rdma", "")
+	id, err := BackupSpecs(backupRoot, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "20260301T120000Z" {
+		t.Errorf("backup ID = %q, want 20260301T120000Z", id)
+	}
+	if _, err := RemoveSpecs(files, false); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := RestoreBackup(backupRoot, id, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != len(files) {
+		t.Errorf("restored %d files, want %d", len(restored), len(files))
+	}
+	for _, f := range files {
+		if data, err := os.ReadFile(f); err != nil || string(data) != "test" {
+			t.Errorf("%s not restored intact: %q, %v", f, data, err)
+		}
+	}
+
+	// Restoring on top of the restored files needs overwrite
+	if _, err := RestoreBackup(backupRoot, id, "", false); err == nil {
+		t.Error("restore over existing files should fail without overwrite")
+	}
+	if _, err := RestoreBackup(backupRoot, id, "", true); err != nil {
+		t.Errorf("overwrite restore failed: %v", err)
+	}
+}
+
+func TestBackupSpecs_SameSecond(t *testing.T) {
+	fixedClock(t, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	backupRoot := t.TempDir()
+
+	first, err := BackupSpecs(backupRoot, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := BackupSpecs(backupRoot, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Errorf("backups in the same second share ID %q", first)
+	}
+
+	ids, err := ListBackups(backupRoot)
+	if err != nil || len(ids) != 2 {
+		t.Errorf("ListBackups = %v, %v; want 2 IDs", ids, err)
+	}
+}
+
+func TestBackupSpecs_SameNameInTwoDirs(t *testing.T) {
+	backupRoot := t.TempDir()
+	var specs []string
+	for _, content := range []string{"static", "transient"} {
+		p := filepath.Join(t.TempDir(), "rdma-cdi_rdma_dev1.yaml")
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		specs = append(specs, p)
+	}
+
+	id, err := BackupSpecs(backupRoot, specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RemoveSpecs(specs, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RestoreBackup(backupRoot, id, "", false); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"static", "transient"} {
+		if data, _ := os.ReadFile(specs[i]); string(data) != want {
+			t.Errorf("%s = %q, want %q", specs[i], data, want)
+		}
+	}
+}
+
+func TestRestoreBackup_LatestAndOutputDir(t *testing.T) {
+	backupRoot := t.TempDir()
+	specDir := t.TempDir()
+	spec := filepath.Join(specDir, "rdma-cdi_rdma_dev1.yaml")
+
+	for i, content := range []string{"old", "new"} {
+		fixedClock(t, time.Date(2026, 3, 1, 12, i, 0, 0, time.UTC))
+		if err := os.WriteFile(spec, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := BackupSpecs(backupRoot, []string{spec}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outDir := t.TempDir()
+	restored, err := RestoreBackup(backupRoot, LatestBackup, outDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 1 || filepath.Dir(restored[0]) != outDir {
+		t.Fatalf("expected restore into %s, got %v", outDir, restored)
+	}
+	if data, _ := os.ReadFile(restored[0]); string(data) != "new" {
+		t.Errorf("latest backup should be restored, got %q", data)
+	}
+}
+
+func TestRestoreBackup_NotFound(t *testing.T) {
+	backupRoot := t.TempDir()
+	for _, id := range []string{"20990101T000000Z", LatestBackup, "../etc"} {
+		if _, err := RestoreBackup(backupRoot, id, "", false); !errors.Is(err, ErrBackupNotFound) {
+			t.Errorf("RestoreBackup(%q) = %v, want ErrBackupNotFound", id, err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package cdi
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	oci "github.com/opencontainers/runtime-spec/specs-go"
+	cdiapi "tags.cncf.io/container-device-interface/pkg/cdi"
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
+)
+
+// CacheDevice is how a CDI runtime resolves one device of a spec file.
+type CacheDevice struct {
+	// Name is the qualified device name, e.g. "rdma/net=0000:17:00.0".
+	Name string
+	// Spec is the spec file defining the device.
+	Spec string
+	// ResolvedFrom is the spec file the runtime takes the device from:
+	// Spec, another file when a higher-priority one shadows it, or ""
+	// when the name does not resolve at all.
+	ResolvedFrom string
+	// Edits counts the device nodes, mounts, env entries and hooks the
+	// device injects, its spec's own included.
+	Edits int
+	// InjectErr is why injecting the device into a container failed.
+	InjectErr error
+}
+
+// CacheLoad is what a CDI runtime makes of a set of spec files.
+type CacheLoad struct {
+	// Errors are the cache errors of each spec file: parse and validation
+	// failures, and devices defined twice at the same priority.
+	Errors map[string][]error
+	// Devices are the devices of the spec files, in file order.
+	Devices []CacheDevice
+}
+
+// LoadInCache loads the specs under dirs into a CDI cache, with the same
+// library and priorities as container runtimes, and resolves every device
+// the spec files in paths define. With inject, each device is also
+// injected into an empty OCI spec, which stats its device nodes as a
+// runtime would; that only holds on the host the specs were written for.
+// It fails if the cache library panics, as it does on one-character
+// class names, which would crash the runtime just the same.
+func LoadInCache(dirs, paths []string, inject bool) (load *CacheLoad, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			load, err = nil, fmt.Errorf("the CDI cache crashed loading %s: %v", strings.Join(dirs, ", "), r)
+		}
+	}()
+	cache, _ := cdiapi.NewCache(cdiapi.WithSpecDirs(dirs...), cdiapi.WithAutoRefresh(false))
+	errs := cache.GetErrors()
+
+	load = &CacheLoad{Errors: make(map[string][]error)}
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		if len(errs[p]) > 0 {
+			load.Errors[p] = errs[p]
+		}
+		spec, err := LoadSpec(p)
+		if err != nil {
+			continue // the cache could not parse it either
+		}
+		for _, d := range spec.Devices {
+			cd := CacheDevice{Name: spec.Kind + "=" + d.Name, Spec: p}
+			if dev := cache.GetDevice(cd.Name); dev != nil {
+				cd.ResolvedFrom = dev.GetSpec().GetPath()
+				cd.Edits = countEdits(dev.GetSpec().ContainerEdits) + countEdits(dev.ContainerEdits)
+				if inject {
+					ociSpec := &oci.Spec{Process: &oci.Process{}, Linux: &oci.Linux{}}
+					_, cd.InjectErr = cache.InjectDevices(ociSpec, cd.Name)
+				}
+			}
+			load.Devices = append(load.Devices, cd)
+		}
+	}
+	return load, nil
+}
+
+// countEdits returns how many edits e makes to a container.
+func countEdits(e cdiSpecs.ContainerEdits) int {
+	return len(e.DeviceNodes) + len(e.Mounts) + len(e.Env) + len(e.Hooks) + len(e.NetDevices)
+}
@@ -0,0 +1,56 @@
+package cdi
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadInCache(t *testing.T) {
+	etc, run := t.TempDir(), t.TempDir()
+	low := writeExplainSpec(t, etc, "a.yaml", explainSpec)
+	// A fully specified node injects without the host having it
+	pinned := writeExplainSpec(t, etc, "b.yaml", `cdiVersion: "0.5.0"
+kind: rdma/ib
+devices:
+  - name: "0000:41:00.0"
+    containerEdits:
+      deviceNodes:
+        - path: /dev/infiniband/uverbs9
+          type: c
+          major: 231
+          minor: 201
+`)
+	broken := writeExplainSpec(t, etc, "c.yaml", "cdiVersion: \"0.5.0\"\nkind: rdma\ndevices: []\n")
+
+	load, err := LoadInCache([]string{etc}, []string{low, pinned, broken}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(load.Errors[broken]) == 0 || len(load.Errors[low]) != 0 {
+		t.Errorf("cache errors = %v, want only %s", load.Errors, broken)
+	}
+	if len(load.Devices) != 2 {
+		t.Fatalf("devices = %+v", load.Devices)
+	}
+	if d := load.Devices[0]; d.ResolvedFrom != low || d.Edits != 4 || d.InjectErr == nil {
+		t.Errorf("%s: %+v, want it resolved from %s with 4 edits, failing to stat its node", d.Name, d, low)
+	}
+	if d := load.Devices[1]; d.ResolvedFrom != pinned || d.Edits != 1 || d.InjectErr != nil {
+		t.Errorf("%s: %+v", d.Name, d)
+	}
+
+	high := writeExplainSpec(t, run, "a.yaml", explainSpec)
+	if load, err = LoadInCache([]string{etc, run}, []string{low}, false); err != nil {
+		t.Fatal(err)
+	}
+	if d := load.Devices[0]; d.ResolvedFrom != filepath.Clean(high) || d.InjectErr != nil {
+		t.Errorf("shadowed device: %+v, want it resolved from %s", d, high)
+	}
+
+	// The parser slices one-character class names out of range
+	writeExplainSpec(t, run, "short.yaml", "cdiVersion: \"0.5.0\"\nkind: rdma/a\ndevices:\n  - name: x\n")
+	if _, err := LoadInCache([]string{run}, nil, false); err == nil || !strings.Contains(err.Error(), "crashed") {
+		t.Errorf("a panicking cache should fail the load, got %v", err)
+	}
+}
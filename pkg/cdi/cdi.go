@@ -5,10 +5,16 @@ package cdi
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -16,6 +22,7 @@ import (
 	cdiparser "tags.cncf.io/container-device-interface/pkg/parser"
 	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
 
+	"github.com/Nativu5/rdma-cdi/pkg/plugin"
 	"github.com/Nativu5/rdma-cdi/pkg/types"
 
 	"sigs.k8s.io/yaml"
@@ -31,8 +38,216 @@ const (
 
 	// DefaultPrefix is used when no --prefix is provided.
 	DefaultPrefix = "rdma"
+
+	// AllDeviceName names the aggregate device added by WithAllDevice, so a
+	// whole spec can be requested as e.g. "rdma/infiniband=all".
+	AllDeviceName = "all"
+
+	// AliasAnnotation marks a device entry added by WithAliases; its value
+	// is the PCI address of the device the alias stands for.
+	AliasAnnotation = "rdma-cdi/alias-of"
+
+	// RdmaCMResourceName and RdmaCMDeviceName name the dedicated spec that
+	// carries the node-global rdma_cm device when it is shared instead of
+	// repeated in every per-device spec; it is requested as
+	// "<prefix>/cm=rdma_cm".
+	RdmaCMResourceName = "cm"
+	RdmaCMDeviceName   = "rdma_cm"
+
+	// GeneratedAtAnnotation records when a spec was written. It is only
+	// set with WithTimestamp, so regenerating unchanged hardware yields a
+	// byte-identical file.
+	GeneratedAtAnnotation = "rdma-cdi/generated-at"
+
+	// PortAnnotation marks a per-port device added by WithPerPort; its
+	// value is the port number. Such a device is named
+	// "<pci-address>-p<port>", and PortInterfaceAnnotation names the
+	// port's network interface when it has one.
+	PortAnnotation          = "rdma-cdi/port"
+	PortInterfaceAnnotation = "rdma-cdi/interface"
+
+	// BondAnnotation and BondMembersAnnotation mark the device entry of a
+	// RoCE LAG: the bonding interface, and the member functions as a
+	// comma-separated list of "<pci-address>=<netdev>".
+	BondAnnotation        = "rdma-cdi/bond"
+	BondMembersAnnotation = "rdma-cdi/bond-members"
+
+	// DeviceAnnotationPrefix namespaces the device-class annotations
+	// WithDeviceAnnotations adds to each device entry, for schedulers and
+	// DRA drivers that filter devices by the attributes in the spec:
+	//
+	//	rdma.cdi.x-k8s.io/link-type  link encapsulation, "infiniband" or "ether"
+	//	rdma.cdi.x-k8s.io/numa-node  NUMA node of the device, e.g. "1"
+	//	rdma.cdi.x-k8s.io/rate-gbps  active rate of the first port in Gb/s, e.g. "100"
+	//	rdma.cdi.x-k8s.io/vendor     PCI vendor ID, e.g. "15b3"
+	//	rdma.cdi.x-k8s.io/node-guid  node GUID, e.g. "0c42:a103:0065:4f3a"
+	//
+	// An attribute discovery could not determine is left out.
+	DeviceAnnotationPrefix = "rdma.cdi.x-k8s.io/"
+	LinkTypeAnnotation     = DeviceAnnotationPrefix + "link-type"
+	NumaNodeAnnotation     = DeviceAnnotationPrefix + "numa-node"
+	RateAnnotation         = DeviceAnnotationPrefix + "rate-gbps"
+	VendorAnnotation       = DeviceAnnotationPrefix + "vendor"
+	NodeGUIDAnnotation     = DeviceAnnotationPrefix + "node-guid"
+
+	// ChecksumAnnotation records the SpecChecksum of a spec as written, so
+	// changes made to it afterwards can be told apart from the original.
+	ChecksumAnnotation = "rdma-cdi/checksum"
+
+	// sysfsIbClass and sysfsVerbsClass are the sysfs views RDMA tooling
+	// (ibv_devinfo, ibstat, NCCL topology detection) reads.
+	sysfsIbClass    = "/sys/class/infiniband"
+	sysfsVerbsClass = "/sys/class/infiniband_verbs"
 )
 
+// ErrSpecCollision is returned when a spec file already holds another kind.
+var ErrSpecCollision = errors.New("CDI spec file collision")
+
+// ErrIncompleteNodeIdentity is returned with WithNodeIdentity when a device
+// node's type, numbers or owner are unknown.
+var ErrIncompleteNodeIdentity = errors.New("incomplete device node identity")
+
+// sysfsMountOptions bind-mounts sysfs paths read-only.
+var sysfsMountOptions = []string{"ro", "nosuid", "nodev", "noexec", "rbind"}
+
+// StandardSpecDirs returns the directories CDI runtimes search for specs:
+// the static /etc/cdi and the transient /var/run/cdi.
+func StandardSpecDirs() []string {
+	return append([]string{}, cdiapi.DefaultSpecDirs...)
+}
+
+// RootlessOutputDir returns the spec directory for users who cannot write
+// the standard ones: $XDG_RUNTIME_DIR/cdi, or ~/.config/cdi when there is
+// no runtime directory. Rootless Podman reads it once it is listed in
+// cdi_spec_dirs in containers.conf.
+func RootlessOutputDir() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "cdi"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot find a rootless spec directory: %w", err)
+	}
+	return filepath.Join(home, ".config", "cdi"), nil
+}
+
+// Option customizes spec generation.
+type Option func(*options)
+
+type options struct {
+	deviceNumbers bool
+	nodeIdentity  bool
+	perPort       bool
+	allDevice     bool
+	aliases       bool
+	sysfsMounts   bool
+	annotations   bool
+	fileMode      os.FileMode
+	ownerSet      bool
+	uid, gid      int
+	timestamp     bool
+	plugins       []plugin.Plugin
+}
+
+// WithDeviceNumbers emits the node type, major:minor numbers and owner
+// captured during discovery in each DeviceNode, instead of leaving them for
+// the runtime to resolve from the host path.
+func WithDeviceNumbers() Option {
+	return func(o *options) {
+		o.deviceNumbers = true
+	}
+}
+
+// WithNodeIdentity is WithDeviceNumbers for runtimes and policies that
+// require fully specified device nodes: spec generation fails with
+// ErrIncompleteNodeIdentity if the type, numbers or owner of any node could
+// not be captured, rather than leaving them to the runtime.
+func WithNodeIdentity() Option {
+	return func(o *options) {
+		o.deviceNumbers = true
+		o.nodeIdentity = true
+	}
+}
+
+// WithPerPort replaces the device entry of each multi-port device (one
+// with RdmaDevice.Ports) with one entry per port, named
+// "<pci-address>-p<port>", holding the function's shared nodes and that
+// port's own. Single-port devices are unaffected.
+func WithPerPort() Option {
+	return func(o *options) {
+		o.perPort = true
+	}
+}
+
+// WithAllDevice adds a device named AllDeviceName whose edits are the union
+// of every other device's nodes. Shared nodes such as rdma_cm appear once.
+func WithAllDevice() Option {
+	return func(o *options) {
+		o.allDevice = true
+	}
+}
+
+// WithAliases adds, for each device, entries named after its interface and
+// RDMA device (e.g. "enp23s0f0np0", "mlx5_0") with the same edits as the
+// PCI-address entry, so any of the three names can be requested.
+func WithAliases() Option {
+	return func(o *options) {
+		o.aliases = true
+	}
+}
+
+// WithSysfsMounts adds read-only bind mounts of each device's
+// /sys/class/infiniband/<ibdev> entry and of /sys/class/infiniband_verbs,
+// so containers without a full sysfs view can still enumerate the HCA.
+func WithSysfsMounts() Option {
+	return func(o *options) {
+		o.sysfsMounts = true
+	}
+}
+
+// WithDeviceAnnotations adds the device-class annotations under
+// DeviceAnnotationPrefix to the entry of each device, and to each of its
+// WithPerPort entries.
+func WithDeviceAnnotations() Option {
+	return func(o *options) {
+		o.annotations = true
+	}
+}
+
+// WithFileMode sets the permission bits of written spec files, regardless
+// of the umask. The output directory gets the same bits, plus search access
+// wherever read access is granted (0640 → 0750).
+func WithFileMode(mode os.FileMode) Option {
+	return func(o *options) {
+		o.fileMode = mode.Perm()
+	}
+}
+
+// WithOwner changes the owner of written spec files and of the output
+// directory. A uid or gid of -1 is left unchanged.
+func WithOwner(uid, gid int) Option {
+	return func(o *options) {
+		o.ownerSet = true
+		o.uid, o.gid = uid, gid
+	}
+}
+
+// WithTimestamp records the generation time in the spec's
+// GeneratedAtAnnotation. Leave it off for specs kept in version control.
+func WithTimestamp() Option {
+	return func(o *options) {
+		o.timestamp = true
+	}
+}
+
+// WithPlugins adds the container edits of the given vendor plugins to the
+// entries of the devices they match, e.g. companion device nodes.
+func WithPlugins(plugins ...plugin.Plugin) Option {
+	return func(o *options) {
+		o.plugins = append(o.plugins, plugins...)
+	}
+}
+
 // SpecFileName returns the deterministic file name for a given prefix, name, and format.
 // Format: rdma-cdi_<prefix>_<name>.<ext>
 func SpecFileName(prefix, name, format string) string {
@@ -41,32 +256,135 @@ func SpecFileName(prefix, name, format string) string {
 	return fmt.Sprintf("%s_%s_%s.%s", FilePrefix, safePrefix, name, format)
 }
 
+// KindFileName returns the SpecFileName of the spec of a CDI kind
+// (prefix/name).
+func KindFileName(kind, format string) (string, error) {
+	i := strings.LastIndex(kind, "/")
+	if i < 0 {
+		return "", fmt.Errorf("invalid CDI spec kind %q: want vendor/class", kind)
+	}
+	return SpecFileName(kind[:i], kind[i+1:], format), nil
+}
+
 // CreateCDISpec generates a CDI spec file for the given devices and writes it
 // to outputDir. The file is named according to SpecFileName().
-func CreateCDISpec(resourcePrefix, resourceName string, devices []types.RdmaDevice, outputDir, format string) error {
+func CreateCDISpec(resourcePrefix, resourceName string, devices []types.RdmaDevice, outputDir, format string, opts ...Option) error {
 	log.Debugf("creating CDI spec for resource %q (prefix=%s)", resourceName, resourcePrefix)
 
-	cdiDevices := make([]cdiSpecs.Device, 0, len(devices))
+	spec, err := BuildSpec(resourcePrefix, resourceName, devices, opts...)
+	if err != nil {
+		return err
+	}
+	return WriteSpec(spec, outputDir, format, opts...)
+}
+
+// WriteSpec validates spec and writes it to outputDir, named according to
+// SpecFileName() after its kind. The spec may come from BuildSpec or be
+// post-processed or assembled by the caller. Only options affecting the
+// file, like WithFileMode, apply.
+func WriteSpec(spec *cdiSpecs.Spec, outputDir, format string, opts ...Option) error {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	fileName, err := KindFileName(spec.Kind, format)
+	if err != nil {
+		return err
+	}
+	filePath := filepath.Join(outputDir, fileName)
+
+	data, err := MarshalSpec(spec, format)
+	if err != nil {
+		return err
+	}
+
+	if err := Files.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("cannot create output directory %s: %w", outputDir, err)
+	}
+	if err := o.applyAttrs(outputDir, dirMode(o.fileMode)); err != nil {
+		return fmt.Errorf("cannot set attributes of output directory %s: %w", outputDir, err)
+	}
+
+	l, err := lockFiles(filePath)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+	if err := checkKindCollision(filePath, spec.Kind); err != nil {
+		return err
+	}
+	if err := writeSpecFile(filePath, data, &o); err != nil {
+		return fmt.Errorf("cannot write CDI spec file %s: %w", filePath, err)
+	}
+
+	log.Debugf("CDI spec written to %s", filePath)
+	return nil
+}
+
+// MarshalSpec validates spec and renders it in format ("json" or "yaml")
+// exactly as WriteSpec writes it, e.g. to preview or diff a spec. The
+// output carries the ChecksumAnnotation; spec itself is not modified.
+func MarshalSpec(spec *cdiSpecs.Spec, format string) ([]byte, error) {
+	if err := validateSpec(spec); err != nil {
+		return nil, fmt.Errorf("CDI spec is invalid: %w", err)
+	}
+	signed, err := signSpec(spec)
+	if err != nil {
+		return nil, fmt.Errorf("cannot checksum CDI spec: %w", err)
+	}
+	data, err := marshalSpec(signed, format)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal CDI spec: %w", err)
+	}
+	return data, nil
+}
 
+// BuildSpec returns the validated CDI spec CreateCDISpec would write for
+// devices, of kind resourcePrefix/resourceName. Options only affecting the
+// file, like WithFileMode, are ignored.
+func BuildSpec(resourcePrefix, resourceName string, devices []types.RdmaDevice, opts ...Option) (*cdiSpecs.Spec, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return buildSpec(resourcePrefix, resourceName, devices, &o)
+}
+
+func buildSpec(resourcePrefix, resourceName string, devices []types.RdmaDevice, o *options) (*cdiSpecs.Spec, error) {
+	cdiDevices := make([]cdiSpecs.Device, 0, len(devices))
+	extras := make([]*cdiSpecs.ContainerEdits, 0, len(devices))
 	for _, dev := range devices {
-		containerEdit := cdiSpecs.ContainerEdits{
-			DeviceNodes: make([]*cdiSpecs.DeviceNode, 0, len(dev.DeviceSpecs)),
+		if err := validateDevice(dev); err != nil {
+			return nil, err
 		}
-
-		for _, spec := range dev.DeviceSpecs {
-			deviceNode := cdiSpecs.DeviceNode{
-				Path:        spec.ContainerPath,
-				HostPath:    spec.HostPath,
-				Permissions: spec.Permissions,
+		if o.nodeIdentity {
+			if err := checkNodeIdentity(dev); err != nil {
+				return nil, err
 			}
-			containerEdit.DeviceNodes = append(containerEdit.DeviceNodes, &deviceNode)
 		}
-
-		device := cdiSpecs.Device{
-			Name:           dev.PciAddress,
-			ContainerEdits: containerEdit,
+		extra, err := pluginEdits(dev, o.plugins)
+		if err != nil {
+			return nil, err
 		}
-		cdiDevices = append(cdiDevices, device)
+		d := buildDevice(dev, o)
+		appendEdits(&d, extra)
+		cdiDevices = append(cdiDevices, d)
+		extras = append(extras, extra)
+	}
+
+	// Aliases stand for the whole function, so they take the edits of the
+	// combined entries
+	var aliases []cdiSpecs.Device
+	if o.aliases {
+		aliases = aliasDevices(devices, cdiDevices)
+	}
+	if o.perPort {
+		cdiDevices = portDevices(devices, cdiDevices, extras, o)
+	}
+	cdiDevices = append(cdiDevices, aliases...)
+	if o.allDevice && len(cdiDevices) > 0 {
+		cdiDevices = append(cdiDevices, mergeDevices(AllDeviceName, cdiDevices))
 	}
 
 	spec := &cdiSpecs.Spec{
@@ -74,45 +392,351 @@ func CreateCDISpec(resourcePrefix, resourceName string, devices []types.RdmaDevi
 		Kind:    resourcePrefix + "/" + resourceName,
 		Devices: cdiDevices,
 	}
+	if o.sysfsMounts {
+		// Shared by every device in the spec, so injected once per container
+		spec.ContainerEdits.Mounts = []*cdiSpecs.Mount{sysfsMount(sysfsVerbsClass)}
+	}
+	if o.timestamp {
+		spec.Annotations = map[string]string{GeneratedAtAnnotation: Clock().UTC().Format(time.RFC3339)}
+	}
+	sortSpec(spec)
 
-	fileName := SpecFileName(resourcePrefix, resourceName, format)
-	filePath := filepath.Join(outputDir, fileName)
+	if err := validateSpec(spec); err != nil {
+		return nil, fmt.Errorf("generated CDI spec is invalid: %w", err)
+	}
+	return spec, nil
+}
 
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("cannot create output directory %s: %w", outputDir, err)
+// validateDevice checks dev before a spec is built from it. The node-global
+// rdma_cm device has no PCI address, so only its device specs are checked.
+func validateDevice(dev types.RdmaDevice) error {
+	if dev.PciAddress != RdmaCMDeviceName {
+		return dev.Validate()
+	}
+	for i := range dev.DeviceSpecs {
+		if err := dev.DeviceSpecs[i].Validate(); err != nil {
+			return fmt.Errorf("%s: %w", dev.PciAddress, err)
+		}
 	}
+	return nil
+}
 
-	// Validate the spec before writing
-	if err := validateSpec(spec); err != nil {
-		return fmt.Errorf("generated CDI spec is invalid: %w", err)
+// portDevices returns cdiDevices, the entries built for devices, with the
+// entry of each multi-port device replaced by one per port. Each port
+// entry gets the plugin edits of its device, extras.
+func portDevices(devices []types.RdmaDevice, cdiDevices []cdiSpecs.Device, extras []*cdiSpecs.ContainerEdits, o *options) []cdiSpecs.Device {
+	out := make([]cdiSpecs.Device, 0, len(cdiDevices))
+	for i, dev := range devices {
+		if len(dev.Ports) < 2 {
+			out = append(out, cdiDevices[i])
+			continue
+		}
+		// Nodes of one port are left out of the others' entries
+		owner := make(map[string]int)
+		for _, p := range dev.Ports {
+			for _, n := range p.RdmaDevices {
+				owner[n] = p.Number
+			}
+		}
+		for _, p := range dev.Ports {
+			portDev := dev
+			portDev.DeviceSpecs = slices.DeleteFunc(slices.Clone(dev.DeviceSpecs), func(s types.DeviceSpec) bool {
+				n, ok := owner[s.HostPath]
+				return ok && n != p.Number
+			})
+			d := buildDevice(portDev, o)
+			appendEdits(&d, extras[i])
+			d.Name = PortDeviceName(dev.PciAddress, p.Number)
+			if d.Annotations == nil {
+				d.Annotations = make(map[string]string)
+			}
+			d.Annotations[PortAnnotation] = strconv.Itoa(p.Number)
+			if p.IfName != "" {
+				d.Annotations[PortInterfaceAnnotation] = p.IfName
+			}
+			out = append(out, d)
+		}
 	}
+	return out
+}
 
-	data, err := marshalSpec(spec, format)
-	if err != nil {
-		return fmt.Errorf("cannot marshal CDI spec: %w", err)
+// PortDeviceName returns the name of the WithPerPort entry of port on the
+// device at pciAddr, e.g. "0000:17:00.0-p2".
+func PortDeviceName(pciAddr string, port int) string {
+	return fmt.Sprintf("%s-p%d", pciAddr, port)
+}
+
+// deviceAddress returns the PCI address a primary device entry stands for:
+// its name, less the port suffix of a per-port entry.
+func deviceAddress(d cdiSpecs.Device) string {
+	if port := d.Annotations[PortAnnotation]; port != "" {
+		if pci, ok := strings.CutSuffix(d.Name, "-p"+port); ok {
+			return pci
+		}
 	}
+	return d.Name
+}
 
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
-		return fmt.Errorf("cannot write CDI spec file %s: %w", filePath, err)
+// checkNodeIdentity checks that every device node of dev has its type,
+// numbers and owner captured, for WithNodeIdentity.
+func checkNodeIdentity(dev types.RdmaDevice) error {
+	for _, spec := range dev.DeviceSpecs {
+		if spec.Type == "" || spec.UID == nil || spec.GID == nil {
+			return fmt.Errorf("%s: %w: cannot inspect %s", dev.PciAddress, ErrIncompleteNodeIdentity, spec.HostPath)
+		}
 	}
+	return nil
+}
 
-	log.Debugf("CDI spec written to %s", filePath)
+// buildDevice returns the CDI device entry for dev, named by its PCI address.
+func buildDevice(dev types.RdmaDevice, o *options) cdiSpecs.Device {
+	containerEdit := cdiSpecs.ContainerEdits{
+		DeviceNodes: make([]*cdiSpecs.DeviceNode, 0, len(dev.DeviceSpecs)),
+	}
+
+	for _, spec := range dev.DeviceSpecs {
+		deviceNode := cdiSpecs.DeviceNode{
+			Path:        spec.ContainerPath,
+			HostPath:    spec.HostPath,
+			Permissions: spec.Permissions,
+		}
+		if o.deviceNumbers && spec.Type != "" {
+			deviceNode.Type = spec.Type
+			deviceNode.Major = spec.Major
+			deviceNode.Minor = spec.Minor
+			deviceNode.UID = spec.UID
+			deviceNode.GID = spec.GID
+		}
+		containerEdit.DeviceNodes = append(containerEdit.DeviceNodes, &deviceNode)
+	}
+	if o.sysfsMounts && dev.IbDev != "" {
+		containerEdit.Mounts = append(containerEdit.Mounts, sysfsMount(path.Join(sysfsIbClass, dev.IbDev)))
+	}
+
+	d := cdiSpecs.Device{
+		Name:           dev.PciAddress,
+		ContainerEdits: containerEdit,
+	}
+	if dev.Bond != nil {
+		members := make([]string, 0, len(dev.Bond.Members))
+		for _, m := range dev.Bond.Members {
+			members = append(members, m.PciAddress+"="+m.IfName)
+		}
+		d.Annotations = map[string]string{
+			BondAnnotation:        dev.Bond.IfName,
+			BondMembersAnnotation: strings.Join(members, ","),
+		}
+	}
+	// The node-global rdma_cm belongs to no device
+	if o.annotations && dev.PciAddress != RdmaCMDeviceName {
+		for key, value := range deviceAnnotations(dev) {
+			if d.Annotations == nil {
+				d.Annotations = make(map[string]string)
+			}
+			d.Annotations[key] = value
+		}
+	}
+	return d
+}
+
+// deviceAnnotations returns the device-class annotations of dev, leaving
+// out what discovery did not determine.
+func deviceAnnotations(dev types.RdmaDevice) map[string]string {
+	out := make(map[string]string)
+	if dev.LinkType != "" {
+		out[LinkTypeAnnotation] = dev.LinkType
+	}
+	if dev.NumaNode >= 0 {
+		out[NumaNodeAnnotation] = strconv.Itoa(dev.NumaNode)
+	}
+	// "100 Gb/sec (4X EDR)" or "2.5 Gb/sec (1X SDR)"
+	if rate, _, ok := strings.Cut(dev.Rate, " "); ok {
+		if _, err := strconv.ParseFloat(rate, 64); err == nil {
+			out[RateAnnotation] = rate
+		}
+	}
+	if dev.Vendor != "" {
+		out[VendorAnnotation] = dev.Vendor
+	}
+	if dev.NodeGUID != "" {
+		out[NodeGUIDAnnotation] = dev.NodeGUID
+	}
+	return out
+}
+
+// pluginEdits collects the container edits the plugins matching dev add
+// to its entry, or nil if there are none.
+func pluginEdits(dev types.RdmaDevice, plugins []plugin.Plugin) (*cdiSpecs.ContainerEdits, error) {
+	var edits *cdiapi.ContainerEdits
+	for _, p := range plugins {
+		if !p.Match(&dev) {
+			continue
+		}
+		e, err := p.ContainerEdits(&dev)
+		if err != nil {
+			return nil, fmt.Errorf("%s: plugin %s: %w", dev.PciAddress, p.Name(), err)
+		}
+		edits = edits.Append(&cdiapi.ContainerEdits{ContainerEdits: e})
+	}
+	if edits == nil {
+		return nil, nil
+	}
+	return edits.ContainerEdits, nil
+}
+
+// appendEdits adds extra, if any, to the container edits of d.
+func appendEdits(d *cdiSpecs.Device, extra *cdiSpecs.ContainerEdits) {
+	if extra != nil {
+		(&cdiapi.ContainerEdits{ContainerEdits: &d.ContainerEdits}).Append(&cdiapi.ContainerEdits{ContainerEdits: extra})
+	}
+}
+
+// checkKindCollision refuses to overwrite an existing spec of another kind.
+// SpecFileName maps '/' to '_', so e.g. rdma_x/y and rdma/x_y share a file.
+func checkKindCollision(path, kind string) error {
+	existing, err := LoadSpec(path)
+	if err != nil || existing.Kind == "" || existing.Kind == kind {
+		// Missing or unreadable files are simply replaced
+		return nil
+	}
+	return fmt.Errorf("%w: %s already holds kind %s, refusing to overwrite it with %s", ErrSpecCollision, path, existing.Kind, kind)
+}
+
+// writeSpecFile writes data to path, applies the requested mode and owner,
+// and syncs both the file and its directory so the spec survives a crash
+// right after generation.
+func writeSpecFile(path string, data []byte, o *options) error {
+	if err := Files.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	if err := o.applyAttrs(path, o.fileMode); err != nil {
+		return err
+	}
+	return Files.SyncDir(filepath.Dir(path))
+}
+
+// applyAttrs sets mode (unless zero) and the configured owner on path.
+func (o *options) applyAttrs(path string, mode os.FileMode) error {
+	if mode != 0 {
+		if err := Files.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+	if o.ownerSet {
+		return Files.Chown(path, o.uid, o.gid)
+	}
 	return nil
 }
 
-// CreateContainerAnnotations generates CDI container annotations for the
-// given devices. The returned map can be passed directly to a container runtime.
-// Keys are CDI qualified names (vendor/class=deviceName).
-func CreateContainerAnnotations(devices []types.RdmaDevice, resourcePrefix, resourceKind string) (map[string]string, error) {
+// dirMode derives a directory mode from a file mode by adding search access
+// wherever read access is granted.
+func dirMode(mode os.FileMode) os.FileMode {
+	return mode | (mode&0444)>>2
+}
+
+// aliasDevices returns the alias entries for devices, whose primary entries
+// are cdiDevices in the same order. Aliases that are not valid CDI device
+// names, or that clash with a name already in the spec, are skipped; on a
+// clash between two devices the lower PCI address keeps the alias.
+func aliasDevices(devices []types.RdmaDevice, cdiDevices []cdiSpecs.Device) []cdiSpecs.Device {
+	used := make(map[string]bool, len(cdiDevices))
+	for _, d := range cdiDevices {
+		used[d.Name] = true
+	}
+
+	order := make([]int, len(devices))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return strings.Compare(devices[a].PciAddress, devices[b].PciAddress)
+	})
+
+	var aliases []cdiSpecs.Device
+	for _, i := range order {
+		dev := devices[i]
+		for _, alias := range []string{dev.IfName, dev.IbDev} {
+			if alias == "" {
+				continue
+			}
+			if err := cdiparser.ValidateDeviceName(alias); err != nil {
+				log.Warnf("not adding alias %q for %s: %v", alias, dev.PciAddress, err)
+				continue
+			}
+			if used[alias] {
+				log.Warnf("not adding alias %q for %s: name already used in the spec", alias, dev.PciAddress)
+				continue
+			}
+			used[alias] = true
+			aliases = append(aliases, cdiSpecs.Device{
+				Name:           alias,
+				Annotations:    map[string]string{AliasAnnotation: dev.PciAddress},
+				ContainerEdits: cdiDevices[i].ContainerEdits,
+			})
+		}
+	}
+	return aliases
+}
+
+// sysfsMount returns a read-only bind mount of p at the same path.
+func sysfsMount(p string) *cdiSpecs.Mount {
+	return &cdiSpecs.Mount{
+		HostPath:      p,
+		ContainerPath: p,
+		Type:          "bind",
+		Options:       append([]string{}, sysfsMountOptions...),
+	}
+}
+
+// mergeDevices returns a device carrying the device nodes and mounts of all
+// devices, de-duplicated by container path.
+func mergeDevices(name string, devices []cdiSpecs.Device) cdiSpecs.Device {
+	merged := cdiSpecs.Device{Name: name}
+	seenNodes := make(map[string]bool)
+	seenMounts := make(map[string]bool)
+	for _, dev := range devices {
+		for _, node := range dev.ContainerEdits.DeviceNodes {
+			if seenNodes[node.Path] {
+				continue
+			}
+			seenNodes[node.Path] = true
+			merged.ContainerEdits.DeviceNodes = append(merged.ContainerEdits.DeviceNodes, node)
+		}
+		for _, m := range dev.ContainerEdits.Mounts {
+			if seenMounts[m.ContainerPath] {
+				continue
+			}
+			seenMounts[m.ContainerPath] = true
+			merged.ContainerEdits.Mounts = append(merged.ContainerEdits.Mounts, m)
+		}
+	}
+	return merged
+}
+
+// CreateContainerAnnotations generates the CDI device injection annotation
+// for the given devices, as a device plugin would hand it to the kubelet or
+// a CRI runtime. The key is derived from plugin ("vendor.device-type") and
+// deviceID (the allocation the devices belong to); the value lists the
+// devices' qualified names (vendor/class=deviceName), comma-separated.
+func CreateContainerAnnotations(devices []types.RdmaDevice, resourcePrefix, resourceKind, plugin, deviceID string) (map[string]string, error) {
+	return UpdateAnnotations(make(map[string]string), devices, resourcePrefix, resourceKind, plugin, deviceID)
+}
+
+// UpdateAnnotations is like CreateContainerAnnotations but adds the
+// annotation to an existing map, which is returned. On error annotations
+// is left unchanged.
+func UpdateAnnotations(annotations map[string]string, devices []types.RdmaDevice, resourcePrefix, resourceKind, plugin, deviceID string) (map[string]string, error) {
 	if len(devices) == 0 {
-		return nil, fmt.Errorf("devices list is empty")
+		return annotations, fmt.Errorf("devices list is empty")
 	}
 
-	annotations := make(map[string]string)
+	names := make([]string, 0, len(devices))
 	for _, dev := range devices {
-		qn := cdiparser.QualifiedName(resourcePrefix, resourceKind, dev.PciAddress)
-		// Each qualified name is its own annotation key=value pair
-		annotations[qn] = qn
+		names = append(names, cdiparser.QualifiedName(resourcePrefix, resourceKind, dev.PciAddress))
+	}
+	annotations, err := cdiapi.UpdateAnnotations(annotations, plugin, deviceID, names)
+	if err != nil {
+		return annotations, err
 	}
 
 	log.Debugf("created CDI annotations: %v", annotations)
@@ -123,36 +747,431 @@ func CreateContainerAnnotations(devices []types.RdmaDevice, resourcePrefix, reso
 // If name is empty, all specs matching the given prefix are removed.
 // If name is non-empty, only the exact match is removed.
 func CleanupSpecs(dir, prefix, name string, dryRun bool) ([]string, error) {
+	matches, err := ListSpecs(dir, prefix, name)
+	if err != nil {
+		return nil, err
+	}
+	return cleanupFiles(matches, dryRun)
+}
+
+// ListSpecs returns the spec files created by this tool in dir for the given
+// prefix, optionally narrowed to one resource name. Only existing files are
+// returned.
+func ListSpecs(dir, prefix, name string) ([]string, error) {
 	if dir == "" {
 		dir = DefaultOutputDir
 	}
 
 	safePrefix := strings.ReplaceAll(prefix, "/", "_")
-	var pattern string
+	var matches []string
 	if name != "" {
 		// Exact match (both json and yaml)
-		patternJSON := filepath.Join(dir, fmt.Sprintf("%s_%s_%s.json", FilePrefix, safePrefix, name))
-		patternYAML := filepath.Join(dir, fmt.Sprintf("%s_%s_%s.yaml", FilePrefix, safePrefix, name))
-		return cleanupFiles([]string{patternJSON, patternYAML}, dryRun)
+		for _, ext := range []string{"json", "yaml"} {
+			p := filepath.Join(dir, fmt.Sprintf("%s_%s_%s.%s", FilePrefix, safePrefix, name, ext))
+			if _, err := Files.Stat(p); err == nil {
+				matches = append(matches, p)
+			}
+		}
+		return matches, nil
 	}
 
 	// Match all specs under the given prefix — restrict to known extensions only
-	var matches []string
 	for _, ext := range []string{"json", "yaml"} {
-		pattern = filepath.Join(dir, fmt.Sprintf("%s_%s_*.%s", FilePrefix, safePrefix, ext))
-		m, err := filepath.Glob(pattern)
+		pattern := filepath.Join(dir, fmt.Sprintf("%s_%s_*.%s", FilePrefix, safePrefix, ext))
+		m, err := glob(pattern)
 		if err != nil {
 			return nil, fmt.Errorf("glob error for pattern %s: %w", pattern, err)
 		}
 		matches = append(matches, m...)
 	}
-	return cleanupFiles(matches, dryRun)
+	return matches, nil
+}
+
+// SpecSelector narrows a list of spec files. Zero fields select everything.
+type SpecSelector struct {
+	// NameGlob matches the resource name part of the file name
+	// (e.g. "pci-0000-3b-*"), using filepath.Match syntax.
+	NameGlob string
+	// OlderThan keeps only files last modified more than this long ago.
+	OlderThan time.Duration
+}
+
+// Validate checks the glob pattern and age.
+func (s SpecSelector) Validate() error {
+	if _, err := filepath.Match(s.NameGlob, ""); err != nil {
+		return fmt.Errorf("invalid name glob %q: %w", s.NameGlob, err)
+	}
+	if s.OlderThan < 0 {
+		return fmt.Errorf("invalid age %s: must not be negative", s.OlderThan)
+	}
+	return nil
+}
+
+// Filter returns the paths, created under prefix, that match the selector.
+func (s SpecSelector) Filter(paths []string, prefix string) ([]string, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	cutoff := Clock().Add(-s.OlderThan)
+	var out []string
+	for _, p := range paths {
+		if s.NameGlob != "" {
+			if ok, _ := filepath.Match(s.NameGlob, ResourceName(p, prefix)); !ok {
+				continue
+			}
+		}
+		if s.OlderThan > 0 {
+			fi, err := Files.Stat(p)
+			if err != nil || !fi.ModTime().Before(cutoff) {
+				continue
+			}
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// ResourceName extracts the resource name from a spec file path written by
+// CreateCDISpec for prefix, i.e. the inverse of SpecFileName. It returns ""
+// if the file name does not follow that layout.
+func ResourceName(specPath, prefix string) string {
+	base := filepath.Base(specPath)
+	head := fmt.Sprintf("%s_%s_", FilePrefix, strings.ReplaceAll(prefix, "/", "_"))
+	if !strings.HasPrefix(base, head) {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(base, head), filepath.Ext(base))
+}
+
+// CleanupOrphanSpecs removes the spec files returned by FindOrphanSpecs.
+func CleanupOrphanSpecs(dir, prefix, name string, present func(pciAddr string) bool, dryRun bool) ([]string, error) {
+	orphans, err := FindOrphanSpecs(dir, prefix, name, present)
+	if err != nil {
+		return nil, err
+	}
+	return cleanupFiles(orphans, dryRun)
+}
+
+// FindOrphanSpecs returns the spec files (selected as in ListSpecs) whose
+// devices are all gone. present reports whether a PCI address — the CDI
+// device name used by CreateCDISpec — still has RDMA hardware. Specs that
+// cannot be parsed, or that still reference some present device, are kept.
+func FindOrphanSpecs(dir, prefix, name string, present func(pciAddr string) bool) ([]string, error) {
+	matches, err := ListSpecs(dir, prefix, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []string
+	for _, p := range matches {
+		spec, err := LoadSpec(p)
+		if err != nil {
+			log.Warnf("skipping unreadable spec %s: %v", p, err)
+			continue
+		}
+		var gone []string
+		pcis := specPCIAddresses(spec)
+		for _, pci := range pcis {
+			if !present(pci) {
+				gone = append(gone, pci)
+			}
+		}
+		switch {
+		case len(gone) == 0:
+			continue
+		case len(gone) < len(pcis):
+			log.Warnf("spec %s references missing device(s) %s; keeping it because other devices are present",
+				p, strings.Join(gone, ", "))
+			continue
+		}
+		orphans = append(orphans, p)
+	}
+	return orphans, nil
+}
+
+// SelectSpecs returns the spec files among paths whose devices all satisfy
+// match, which is given their PCI addresses. Specs that cannot be parsed,
+// or that hold no per-device entry, are left out.
+func SelectSpecs(paths []string, match func(pciAddr string) bool) []string {
+	var out []string
+	for _, p := range paths {
+		spec, err := LoadSpec(p)
+		if err != nil {
+			log.Warnf("skipping unreadable spec %s: %v", p, err)
+			continue
+		}
+		pcis := specPCIAddresses(spec)
+		if len(pcis) > 0 && !slices.ContainsFunc(pcis, func(pci string) bool { return !match(pci) }) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// specPCIAddresses returns the names of the per-device entries of spec,
+// which CreateCDISpec names after their PCI address.
+func specPCIAddresses(spec *cdiSpecs.Spec) []string {
+	var pcis []string
+	for _, dev := range spec.Devices {
+		if dev.Name == AllDeviceName || dev.Name == RdmaCMDeviceName || dev.Annotations[AliasAnnotation] != "" {
+			continue // aggregate, alias or node-global device, not a PCI address
+		}
+		if pci := deviceAddress(dev); !slices.Contains(pcis, pci) {
+			pcis = append(pcis, pci)
+		}
+	}
+	return pcis
+}
+
+// LoadSpec parses a JSON or YAML spec file as is.
+func LoadSpec(path string) (*cdiSpecs.Spec, error) {
+	data, err := Files.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSpec(data)
+}
+
+// ParseSpec parses a JSON or YAML spec as is.
+func ParseSpec(data []byte) (*cdiSpecs.Spec, error) {
+	var spec cdiSpecs.Spec
+	// YAML is a superset of JSON, so one decoder handles both formats
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("cannot parse CDI spec: %w", err)
+	}
+	return &spec, nil
+}
+
+// SpecMeta is the spec-level information ReadSpec recovers alongside the
+// devices.
+type SpecMeta struct {
+	// Path is the spec file that was read.
+	Path string
+	// Version is the CDI version the spec declares.
+	Version string
+	// Kind is the CDI kind; Prefix and Name are its two halves as passed
+	// to CreateCDISpec.
+	Kind   string
+	Prefix string
+	Name   string
+	// Format is "json" or "yaml", taken from the file extension.
+	Format string
+	// Managed reports whether the file follows this tool's naming scheme.
+	Managed bool
+	// Annotations are the spec-level annotations.
+	Annotations map[string]string
+	// Aliases maps alias device names to the PCI address they stand for.
+	Aliases map[string]string
+	// AllDevice reports whether the spec has the aggregate "all" device.
+	AllDevice bool
+	// SysfsMounts reports whether the spec carries sysfs mounts.
+	SysfsMounts bool
+	// PerPort reports whether the spec has per-port devices (WithPerPort).
+	PerPort bool
+}
+
+// parseBond returns the RoCE LAG recorded by the BondAnnotation and
+// BondMembersAnnotation of a device entry.
+func parseBond(master, members string) *types.RdmaBond {
+	bond := &types.RdmaBond{IfName: master}
+	for _, m := range strings.Split(members, ",") {
+		if pci, ifName, ok := strings.Cut(m, "="); ok {
+			bond.Members = append(bond.Members, types.BondMember{PciAddress: pci, IfName: ifName})
+		}
+	}
+	return bond
+}
+
+// ReadSpec parses a spec file back into the device model CreateCDISpec
+// was given. Alias and aggregate devices are reported in the SpecMeta
+// rather than as devices, and per-port devices are folded into one device
+// per PCI address. Fields a spec does not record, such as IfName or
+// the PCI IDs, are left empty; IbDev is recovered from a sysfs mount.
+func ReadSpec(specPath string) ([]types.RdmaDevice, SpecMeta, error) {
+	spec, err := LoadSpec(specPath)
+	if err != nil {
+		return nil, SpecMeta{}, err
+	}
+
+	meta := SpecMeta{
+		Path:        specPath,
+		Version:     spec.Version,
+		Kind:        spec.Kind,
+		Format:      strings.TrimPrefix(filepath.Ext(specPath), "."),
+		Managed:     IsManaged(specPath),
+		Annotations: spec.Annotations,
+		Aliases:     make(map[string]string),
+		SysfsMounts: slices.ContainsFunc(spec.ContainerEdits.Mounts, func(m *cdiSpecs.Mount) bool {
+			return m.ContainerPath == sysfsVerbsClass
+		}),
+	}
+	if meta.Format == "yml" {
+		meta.Format = "yaml"
+	}
+	if i := strings.LastIndex(spec.Kind, "/"); i >= 0 {
+		meta.Prefix, meta.Name = spec.Kind[:i], spec.Kind[i+1:]
+	}
+
+	var devices []types.RdmaDevice
+	for _, d := range spec.Devices {
+		if target := d.Annotations[AliasAnnotation]; target != "" {
+			meta.Aliases[d.Name] = target
+			continue
+		}
+		if d.Name == AllDeviceName {
+			meta.AllDevice = true
+			continue
+		}
+		// The entries of a device's ports fold back into the device
+		pci := deviceAddress(d)
+		i := slices.IndexFunc(devices, func(dev types.RdmaDevice) bool { return dev.PciAddress == pci })
+		if i < 0 {
+			devices = append(devices, types.RdmaDevice{PciAddress: pci, NumaNode: -1})
+			i = len(devices) - 1
+		}
+		dev := &devices[i]
+		if port, err := strconv.Atoi(d.Annotations[PortAnnotation]); err == nil {
+			meta.PerPort = true
+			dev.Ports = append(dev.Ports, types.RdmaPort{Number: port, IfName: d.Annotations[PortInterfaceAnnotation]})
+		}
+		if master := d.Annotations[BondAnnotation]; master != "" {
+			dev.Bond = parseBond(master, d.Annotations[BondMembersAnnotation])
+		}
+		for _, n := range d.ContainerEdits.DeviceNodes {
+			hostPath := n.HostPath
+			if hostPath == "" {
+				hostPath = n.Path
+			}
+			if slices.ContainsFunc(dev.DeviceSpecs, func(s types.DeviceSpec) bool { return s.HostPath == hostPath }) {
+				continue
+			}
+			dev.DeviceSpecs = append(dev.DeviceSpecs, types.DeviceSpec{
+				HostPath:      hostPath,
+				ContainerPath: n.Path,
+				Permissions:   n.Permissions,
+				Type:          n.Type,
+				Major:         n.Major,
+				Minor:         n.Minor,
+				UID:           n.UID,
+				GID:           n.GID,
+			})
+		}
+		for _, m := range d.ContainerEdits.Mounts {
+			if path.Dir(m.ContainerPath) == sysfsIbClass {
+				dev.IbDev = path.Base(m.ContainerPath)
+				meta.SysfsMounts = true
+			}
+		}
+	}
+	return devices, meta, nil
+}
+
+// CleanupSummary is the machine-readable result of a cleanup run.
+type CleanupSummary struct {
+	// Matched lists every spec file selected for removal.
+	Matched []string `json:"matched"`
+	// Removed lists the files actually deleted.
+	Removed []string `json:"removed"`
+	// Skipped lists matched files left in place (dry run, declined
+	// confirmation, or a removal error).
+	Skipped []string `json:"skipped"`
+	// Modified lists files left in place because they changed since
+	// generate wrote them.
+	Modified []string `json:"modified,omitempty"`
+	// Errors holds one message per file that could not be removed.
+	Errors   []string `json:"errors"`
+	DryRun   bool     `json:"dry_run"`
+	Aborted  bool     `json:"aborted,omitempty"`
+	BackupID string   `json:"backup_id,omitempty"`
+}
+
+// PrintCleanupJSON renders a cleanup summary as JSON. Empty lists are
+// emitted as [] rather than null so consumers can index them directly.
+func PrintCleanupJSON(w io.Writer, s *CleanupSummary) error {
+	out := *s
+	for _, l := range []*[]string{&out.Matched, &out.Removed, &out.Skipped, &out.Errors} {
+		if *l == nil {
+			*l = []string{}
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// RemoveSpecs removes the given spec files, typically a list previously
+// returned by ListSpecs or a dry run of CleanupSpecs. Paths whose base name
+// lacks this tool's FilePrefix are refused, so a stale or hand-built list
+// can never delete specs owned by other tools.
+func RemoveSpecs(paths []string, dryRun bool) ([]string, error) {
+	for _, p := range paths {
+		if !IsManaged(p) {
+			return nil, fmt.Errorf("refusing to remove %s: not a spec file created by %s", p, FilePrefix)
+		}
+	}
+	return cleanupFiles(paths, dryRun)
+}
+
+// IsManaged reports whether a spec file name follows this tool's naming
+// scheme, i.e. whether it was written by CreateCDISpec.
+func IsManaged(specPath string) bool {
+	return strings.HasPrefix(filepath.Base(specPath), FilePrefix+"_")
+}
+
+// ListAllSpecs returns every spec file in dir that a CDI runtime would load
+// (.json, .yaml and .yml), whoever wrote it. A missing dir yields no files.
+func ListAllSpecs(dir string) ([]string, error) {
+	entries, err := Files.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read spec directory %s: %w", dir, err)
+	}
+	var files []string
+	for _, e := range entries {
+		switch filepath.Ext(e.Name()) {
+		case ".json", ".yaml", ".yml":
+			if !e.IsDir() {
+				files = append(files, filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// FindForeignKinds returns, for each of kinds that is already defined by a
+// spec under dirs that this tool did not write, the paths of those specs.
+// Two producers defining devices under one kind confuse runtimes, which
+// merge or shadow them. Unreadable specs are skipped.
+func FindForeignKinds(dirs, kinds []string) (map[string][]string, error) {
+	conflicts := make(map[string][]string)
+	for _, dir := range dirs {
+		files, err := ListAllSpecs(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if IsManaged(f) {
+				continue
+			}
+			spec, err := LoadSpec(f)
+			if err != nil {
+				log.Debugf("skipping unreadable spec %s: %v", f, err)
+				continue
+			}
+			if slices.Contains(kinds, spec.Kind) {
+				conflicts[spec.Kind] = append(conflicts[spec.Kind], f)
+			}
+		}
+	}
+	return conflicts, nil
 }
 
 func cleanupFiles(paths []string, dryRun bool) ([]string, error) {
 	removed := make([]string, 0)
 	for _, p := range paths {
-		if _, err := os.Stat(p); os.IsNotExist(err) {
+		if _, err := Files.Stat(p); errors.Is(err, os.ErrNotExist) {
 			continue
 		}
 		if dryRun {
@@ -161,7 +1180,13 @@ func cleanupFiles(paths []string, dryRun bool) ([]string, error) {
 			continue
 		}
 		log.Infof("removing CDI spec file: %s", p)
-		if err := os.Remove(p); err != nil {
+		l, err := lockFiles(p)
+		if err != nil {
+			return removed, err
+		}
+		err = Files.Remove(p)
+		l.Release()
+		if err != nil {
 			return removed, fmt.Errorf("cannot remove %s: %w", p, err)
 		}
 		removed = append(removed, p)
@@ -169,6 +1194,29 @@ func cleanupFiles(paths []string, dryRun bool) ([]string, error) {
 	return removed, nil
 }
 
+// sortSpec puts devices, device nodes, mounts and env entries in a canonical
+// order, so the same hardware always marshals to the same bytes regardless
+// of discovery order. Map keys are already sorted by the encoders.
+func sortSpec(spec *cdiSpecs.Spec) {
+	slices.SortStableFunc(spec.Devices, func(a, b cdiSpecs.Device) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	sortEdits(&spec.ContainerEdits)
+	for i := range spec.Devices {
+		sortEdits(&spec.Devices[i].ContainerEdits)
+	}
+}
+
+func sortEdits(e *cdiSpecs.ContainerEdits) {
+	slices.Sort(e.Env)
+	slices.SortStableFunc(e.DeviceNodes, func(a, b *cdiSpecs.DeviceNode) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	slices.SortStableFunc(e.Mounts, func(a, b *cdiSpecs.Mount) int {
+		return strings.Compare(a.ContainerPath, b.ContainerPath)
+	})
+}
+
 // validateSpec performs basic validation on a CDI spec.
 func validateSpec(spec *cdiSpecs.Spec) error {
 	if spec.Kind == "" {
@@ -177,6 +1225,19 @@ func validateSpec(spec *cdiSpecs.Spec) error {
 	if len(spec.Devices) == 0 {
 		return fmt.Errorf("spec must contain at least one device")
 	}
+	// Specs handed to WriteSpec may have been edited by the caller
+	if err := (&cdiapi.ContainerEdits{ContainerEdits: &spec.ContainerEdits}).Validate(); err != nil {
+		return err
+	}
+	for i := range spec.Devices {
+		dev := &spec.Devices[i]
+		if err := cdiparser.ValidateDeviceName(dev.Name); err != nil {
+			return err
+		}
+		if err := (&cdiapi.ContainerEdits{ContainerEdits: &dev.ContainerEdits}).Validate(); err != nil {
+			return fmt.Errorf("device %s: %w", dev.Name, err)
+		}
+	}
 	return nil
 }
 
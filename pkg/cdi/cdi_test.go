@@ -1,12 +1,22 @@
 package cdi
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
+	cdiapi "tags.cncf.io/container-device-interface/pkg/cdi"
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
+
+	"github.com/Nativu5/rdma-cdi/pkg/plugin"
 	"github.com/Nativu5/rdma-cdi/pkg/types"
 )
 
@@ -128,6 +138,84 @@ func TestCreateCDISpec_JSON(t *testing.T) {
 	}
 }
 
+func TestBuildSpec(t *testing.T) {
+	spec, err := BuildSpec("rdma", "test-dev", sampleDevices(), WithAllDevice())
+	if err != nil {
+		t.Fatalf("BuildSpec() failed: %v", err)
+	}
+	if spec.Kind != "rdma/test-dev" || spec.Version != cdiSpecs.CurrentVersion {
+		t.Errorf("kind, version = %s, %s", spec.Kind, spec.Version)
+	}
+	var names []string
+	for _, dev := range spec.Devices {
+		names = append(names, dev.Name)
+	}
+	if want := []string{"0000:17:00.0", AllDeviceName}; !slices.Equal(names, want) {
+		t.Errorf("devices = %v, want %v", names, want)
+	}
+	if n := len(spec.Devices[0].ContainerEdits.DeviceNodes); n != 3 {
+		t.Errorf("device has %d nodes, want 3", n)
+	}
+
+	if _, err := BuildSpec("rdma", "test-dev", []types.RdmaDevice{{IfName: "ib0"}}); err == nil {
+		t.Error("BuildSpec() of a device without a PCI address should fail")
+	}
+}
+
+func TestWriteSpec_PostProcessed(t *testing.T) {
+	spec, err := BuildSpec("example.com/rdma", "net", sampleDevices())
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec.ContainerEdits.Env = append(spec.ContainerEdits.Env, "NCCL_IB_HCA=mlx5_0")
+
+	dir := t.TempDir()
+	if err := WriteSpec(spec, dir, "json", WithFileMode(0600)); err != nil {
+		t.Fatalf("WriteSpec() failed: %v", err)
+	}
+	path := filepath.Join(dir, "rdma-cdi_example.com_rdma_net.json")
+	got, err := LoadSpec(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.ContainerEdits.Env, []string{"NCCL_IB_HCA=mlx5_0"}) {
+		t.Errorf("env = %v, want the post-processed edit", got.ContainerEdits.Env)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+
+	// Edits that break the spec are caught before anything is written
+	spec.Devices[0].Name = ""
+	if err := WriteSpec(spec, t.TempDir(), "yaml"); err == nil {
+		t.Error("WriteSpec() of an invalid spec should fail")
+	}
+	if err := WriteSpec(&cdiSpecs.Spec{Kind: "noslash"}, t.TempDir(), "yaml"); err == nil {
+		t.Error("WriteSpec() of a kind without a class should fail")
+	}
+}
+
+func TestCreateCDISpec_KindCollision(t *testing.T) {
+	dir := t.TempDir()
+	if err := CreateCDISpec("rdma", "x_y", sampleDevices(), dir, "yaml"); err != nil {
+		t.Fatal(err)
+	}
+	// Same kind may be regenerated in place
+	if err := CreateCDISpec("rdma", "x_y", sampleDevices(), dir, "yaml"); err != nil {
+		t.Fatalf("regenerating the same kind failed: %v", err)
+	}
+
+	// rdma_x/y maps to the same file name as rdma/x_y
+	err := CreateCDISpec("rdma/x", "y", sampleDevices(), dir, "yaml")
+	if !errors.Is(err, ErrSpecCollision) {
+		t.Fatalf("err = %v, want ErrSpecCollision", err)
+	}
+	spec, err := LoadSpec(filepath.Join(dir, "rdma-cdi_rdma_x_y.yaml"))
+	if err != nil || spec.Kind != "rdma/x_y" {
+		t.Errorf("original spec was overwritten: %+v, %v", spec, err)
+	}
+}
+
 func TestCreateCDISpec_InvalidFormat(t *testing.T) {
 	dir := t.TempDir()
 	err := CreateCDISpec("rdma", "x", sampleDevices(), dir, "xml")
@@ -139,6 +227,19 @@ func TestCreateCDISpec_InvalidFormat(t *testing.T) {
 	}
 }
 
+func TestCreateCDISpec_InvalidDevice(t *testing.T) {
+	dir := t.TempDir()
+	devs := sampleDevices()
+	devs[0].DeviceSpecs[0].HostPath = "uverbs0"
+	err := CreateCDISpec("rdma", "bad", devs, dir, "yaml")
+	if !errors.Is(err, types.ErrInvalidDevice) {
+		t.Fatalf("expected ErrInvalidDevice, got %v", err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("nothing should be written for an invalid device, got %v", files)
+	}
+}
+
 func TestCreateCDISpec_EmptyDevices(t *testing.T) {
 	dir := t.TempDir()
 	err := CreateCDISpec("rdma", "empty", []types.RdmaDevice{}, dir, "yaml")
@@ -160,6 +261,500 @@ func TestCreateCDISpec_NamingConvention(t *testing.T) {
 	}
 }
 
+func TestCreateCDISpec_DeviceNumbers(t *testing.T) {
+	uid, gid := uint32(0), uint32(27)
+	devs := sampleDevices()
+	devs[0].DeviceSpecs[1].Type = "c"
+	devs[0].DeviceSpecs[1].Major = 231
+	devs[0].DeviceSpecs[1].Minor = 192
+	devs[0].DeviceSpecs[1].UID = &uid
+	devs[0].DeviceSpecs[1].GID = &gid
+
+	readNodes := func(t *testing.T, dir string) []map[string]interface{} {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(dir, "rdma-cdi_rdma_nums.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parsed struct {
+			Devices []struct {
+				ContainerEdits struct {
+					DeviceNodes []map[string]interface{} `json:"deviceNodes"`
+				} `json:"containerEdits"`
+			} `json:"devices"`
+		}
+		if err := json.Unmarshal(data, &parsed); err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Devices[0].ContainerEdits.DeviceNodes
+	}
+
+	// Default: numbers are left to the runtime
+	dir := t.TempDir()
+	if err := CreateCDISpec("rdma", "nums", devs, dir, "json"); err != nil {
+		t.Fatal(err)
+	}
+	// Nodes are sorted by path: rdma_cm, umad0, uverbs0
+	if _, ok := readNodes(t, dir)[2]["major"]; ok {
+		t.Error("major should be omitted without WithDeviceNumbers")
+	}
+
+	dir = t.TempDir()
+	if err := CreateCDISpec("rdma", "nums", devs, dir, "json", WithDeviceNumbers()); err != nil {
+		t.Fatal(err)
+	}
+	nodes := readNodes(t, dir)
+	if nodes[2]["type"] != "c" || nodes[2]["major"] != float64(231) || nodes[2]["minor"] != float64(192) || nodes[2]["gid"] != float64(27) {
+		t.Errorf("unexpected device node: %v", nodes[2])
+	}
+	// Entries without captured numbers stay untouched
+	if _, ok := nodes[0]["type"]; ok {
+		t.Errorf("node without numbers should not get a type: %v", nodes[0])
+	}
+}
+
+func TestBuildSpec_NodeIdentity(t *testing.T) {
+	devs := sampleDevices()
+	if _, err := BuildSpec("rdma", "id", devs, WithNodeIdentity()); !errors.Is(err, ErrIncompleteNodeIdentity) {
+		t.Fatalf("BuildSpec() error = %v, want ErrIncompleteNodeIdentity", err)
+	}
+
+	uid, gid := uint32(0), uint32(27)
+	for i := range devs[0].DeviceSpecs {
+		s := &devs[0].DeviceSpecs[i]
+		s.Type, s.Major, s.Minor, s.UID, s.GID = "c", 231, int64(192+i), &uid, &gid
+	}
+	spec, err := BuildSpec("rdma", "id", devs, WithNodeIdentity())
+	if err != nil {
+		t.Fatalf("BuildSpec() error = %v", err)
+	}
+	for _, n := range spec.Devices[0].ContainerEdits.DeviceNodes {
+		if n.Type != "c" || n.Major != 231 || n.UID == nil || *n.GID != 27 {
+			t.Errorf("device node not fully specified: %+v", n)
+		}
+	}
+}
+
+func TestCreateCDISpec_AllDevice(t *testing.T) {
+	devs := sampleDevices()
+	second := devs[0]
+	second.PciAddress = "0000:18:00.0"
+	second.DeviceSpecs = []types.DeviceSpec{
+		{HostPath: "/dev/infiniband/uverbs1", ContainerPath: "/dev/infiniband/uverbs1", Permissions: "rw"},
+		{HostPath: "/dev/infiniband/rdma_cm", ContainerPath: "/dev/infiniband/rdma_cm", Permissions: "rw"},
+	}
+	devs = append(devs, second)
+
+	dir := t.TempDir()
+	if err := CreateCDISpec("rdma", "infiniband", devs, dir, "yaml", WithAllDevice()); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := LoadSpec(filepath.Join(dir, "rdma-cdi_rdma_infiniband.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spec.Devices) != 3 || spec.Devices[2].Name != AllDeviceName {
+		t.Fatalf("expected two devices plus %q, got %+v", AllDeviceName, spec.Devices)
+	}
+
+	want := len(devs[0].DeviceSpecs) + 1 // rdma_cm is shared
+	if got := len(spec.Devices[2].ContainerEdits.DeviceNodes); got != want {
+		t.Errorf("aggregate device has %d nodes, want %d", got, want)
+	}
+
+	// The aggregate is not a PCI address and must not make a spec look partial
+	orphans, err := FindOrphanSpecs(dir, "rdma", "", func(string) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 {
+		t.Errorf("expected grouped spec to be an orphan, got %v", orphans)
+	}
+}
+
+func TestCreateCDISpec_Deterministic(t *testing.T) {
+	devs := sampleDevices()
+	// second shares the first device's ifname, so alias assignment must not
+	// depend on the order either
+	second := devs[0]
+	second.PciAddress = "0000:03:00.0"
+	second.DeviceSpecs = []types.DeviceSpec{
+		{HostPath: "/dev/infiniband/uverbs1", ContainerPath: "/dev/infiniband/uverbs1", Permissions: "rw"},
+		{HostPath: "/dev/infiniband/rdma_cm", ContainerPath: "/dev/infiniband/rdma_cm", Permissions: "rw"},
+	}
+	reversed := []types.RdmaDevice{second, devs[0]}
+	reversed[1].DeviceSpecs = slices.Clone(devs[0].DeviceSpecs)
+	slices.Reverse(reversed[1].DeviceSpecs)
+
+	read := func(devices []types.RdmaDevice, opts ...Option) []byte {
+		t.Helper()
+		dir := t.TempDir()
+		if err := CreateCDISpec("rdma", "det", devices, dir, "yaml", opts...); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "rdma-cdi_rdma_det.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	a := read(append(devs, second), WithAliases())
+	b := read(reversed, WithAliases())
+	if !bytes.Equal(a, b) {
+		t.Errorf("spec depends on discovery order:\n%s\n---\n%s", a, b)
+	}
+	if bytes.Contains(a, []byte(GeneratedAtAnnotation)) {
+		t.Error("timestamp must not be written unless requested")
+	}
+
+	orig := Clock
+	defer func() { Clock = orig }()
+	Clock = func() time.Time { return time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC) }
+	if ts := read(devs, WithTimestamp()); !bytes.Contains(ts, []byte(GeneratedAtAnnotation+": \"2026-03-01T12:00:00Z\"")) {
+		t.Errorf("expected generated-at annotation, got:\n%s", ts)
+	}
+}
+
+func TestCreateCDISpec_FileAttrs(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantFile os.FileMode
+		wantDir  os.FileMode
+		exact    bool // explicit modes ignore the umask
+	}{
+		{"default", nil, 0644, 0755, false},
+		{"private", []Option{WithFileMode(0600)}, 0600, 0700, true},
+		{"group_readable", []Option{WithFileMode(0640)}, 0640, 0750, true},
+		{"owner", []Option{WithOwner(os.Getuid(), os.Getgid())}, 0644, 0755, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "cdi")
+			if err := CreateCDISpec("rdma", "attrs", sampleDevices(), dir, "yaml", tc.opts...); err != nil {
+				t.Fatal(err)
+			}
+			check := func(path string, want os.FileMode) {
+				t.Helper()
+				fi, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := fi.Mode().Perm()
+				if (tc.exact && got != want) || got&^want != 0 {
+					t.Errorf("%s: mode = %o, want %o", filepath.Base(path), got, want)
+				}
+			}
+			check(filepath.Join(dir, "rdma-cdi_rdma_attrs.yaml"), tc.wantFile)
+			check(dir, tc.wantDir)
+		})
+	}
+}
+
+func TestCreateCDISpec_SysfsMounts(t *testing.T) {
+	devs := sampleDevices()
+	devs[0].IbDev = "mlx5_0"
+
+	dir := t.TempDir()
+	if err := CreateCDISpec("rdma", "sysfs", devs, dir, "yaml"); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := LoadSpec(filepath.Join(dir, "rdma-cdi_rdma_sysfs.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spec.ContainerEdits.Mounts) != 0 || len(spec.Devices[0].ContainerEdits.Mounts) != 0 {
+		t.Error("mounts should be omitted without WithSysfsMounts")
+	}
+
+	if err := CreateCDISpec("rdma", "sysfs", devs, dir, "yaml", WithSysfsMounts()); err != nil {
+		t.Fatal(err)
+	}
+	if spec, err = LoadSpec(filepath.Join(dir, "rdma-cdi_rdma_sysfs.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if m := spec.ContainerEdits.Mounts; len(m) != 1 || m[0].HostPath != "/sys/class/infiniband_verbs" {
+		t.Errorf("expected spec-wide infiniband_verbs mount, got %+v", m)
+	}
+	m := spec.Devices[0].ContainerEdits.Mounts
+	if len(m) != 1 || m[0].HostPath != "/sys/class/infiniband/mlx5_0" || m[0].ContainerPath != m[0].HostPath {
+		t.Fatalf("expected per-device ibdev mount, got %+v", m)
+	}
+	if !strings.Contains(strings.Join(m[0].Options, ","), "ro") {
+		t.Errorf("sysfs mount must be read-only, got options %v", m[0].Options)
+	}
+}
+
+func TestCreateCDISpec_Aliases(t *testing.T) {
+	devs := sampleDevices()
+	devs[0].IbDev = "mlx5_0"
+
+	dir := t.TempDir()
+	if err := CreateCDISpec("rdma", "net", devs, dir, "yaml", WithAliases()); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := LoadSpec(filepath.Join(dir, "rdma-cdi_rdma_net.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, d := range spec.Devices {
+		names = append(names, d.Name)
+		if len(d.ContainerEdits.DeviceNodes) != len(devs[0].DeviceSpecs) {
+			t.Errorf("%s: %d device nodes, want %d", d.Name, len(d.ContainerEdits.DeviceNodes), len(devs[0].DeviceSpecs))
+		}
+	}
+	if want := "[0000:17:00.0 enp23s0f0np0 mlx5_0]"; fmt.Sprint(names) != want {
+		t.Errorf("device names = %v, want %s", names, want)
+	}
+	if got := spec.Devices[1].Annotations[AliasAnnotation]; got != "0000:17:00.0" {
+		t.Errorf("alias annotation = %q, want 0000:17:00.0", got)
+	}
+
+	// Aliases never make a spec look partially orphaned
+	orphans, err := FindOrphanSpecs(dir, "rdma", "", func(pci string) bool { return pci == "0000:17:00.0" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("spec with present device reported as orphan: %v", orphans)
+	}
+
+	// Nor do they take part in selecting specs by device
+	path := filepath.Join(dir, "rdma-cdi_rdma_net.yaml")
+	if got := SelectSpecs([]string{path}, func(pci string) bool { return pci == "0000:17:00.0" }); len(got) != 1 {
+		t.Errorf("SelectSpecs() = %v, want the spec", got)
+	}
+	if got := SelectSpecs([]string{path}, func(string) bool { return false }); len(got) != 0 {
+		t.Errorf("SelectSpecs() = %v, want none", got)
+	}
+}
+
+// ──────────────────────────────────────────────
+//  ReadSpec
+// ──────────────────────────────────────────────
+
+func TestReadSpec_RoundTrip(t *testing.T) {
+	uid, gid := uint32(0), uint32(0)
+	devs := sampleDevices()
+	devs[0].IbDev = "mlx5_0"
+	devs[0].NumaNode = -1
+	for i := range devs[0].DeviceSpecs {
+		devs[0].DeviceSpecs[i].Type = "c"
+		devs[0].DeviceSpecs[i].Major = 231
+		devs[0].DeviceSpecs[i].Minor = int64(i)
+		devs[0].DeviceSpecs[i].UID = &uid
+		devs[0].DeviceSpecs[i].GID = &gid
+	}
+
+	for _, format := range []string{"yaml", "json"} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			err := CreateCDISpec("rdma", "rt", devs, dir, format,
+				WithDeviceNumbers(), WithAliases(), WithAllDevice(), WithSysfsMounts())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, meta, err := ReadSpec(filepath.Join(dir, SpecFileName("rdma", "rt", format)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Kind != "rdma/rt" || meta.Prefix != "rdma" || meta.Name != "rt" || meta.Format != format || !meta.Managed {
+				t.Errorf("unexpected meta: %+v", meta)
+			}
+			if !meta.AllDevice || !meta.SysfsMounts {
+				t.Errorf("all device / sysfs mounts not detected: %+v", meta)
+			}
+			wantAliases := map[string]string{"enp23s0f0np0": "0000:17:00.0", "mlx5_0": "0000:17:00.0"}
+			if fmt.Sprint(meta.Aliases) != fmt.Sprint(wantAliases) {
+				t.Errorf("Aliases = %v, want %v", meta.Aliases, wantAliases)
+			}
+
+			if len(got) != 1 {
+				t.Fatalf("got %d devices, want 1: %+v", len(got), got)
+			}
+			want := devs[0]
+			want.IfName = "" // not recorded in the spec
+			want.DeviceSpecs = slices.Clone(want.DeviceSpecs)
+			slices.SortFunc(want.DeviceSpecs, func(a, b types.DeviceSpec) int {
+				return strings.Compare(a.ContainerPath, b.ContainerPath)
+			})
+			if !reflect.DeepEqual(got[0], want) {
+				t.Errorf("round trip mismatch:\n got %+v\nwant %+v", got[0], want)
+			}
+		})
+	}
+}
+
+func TestCreateCDISpec_PerPort(t *testing.T) {
+	devs := sampleDevices()
+	devs[0].DeviceSpecs = append(devs[0].DeviceSpecs,
+		types.DeviceSpec{HostPath: "/dev/infiniband/umad1", ContainerPath: "/dev/infiniband/umad1", Permissions: "rw"})
+	devs[0].Ports = []types.RdmaPort{
+		{Number: 1, IfName: "ib0", RdmaDevices: []string{"/dev/infiniband/umad0"}},
+		{Number: 2, RdmaDevices: []string{"/dev/infiniband/umad1"}},
+	}
+
+	spec, err := BuildSpec("rdma", "net", devs, WithPerPort())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, d := range spec.Devices {
+		names = append(names, d.Name)
+	}
+	if want := []string{"0000:17:00.0-p1", "0000:17:00.0-p2"}; !slices.Equal(names, want) {
+		t.Fatalf("devices = %v, want %v", names, want)
+	}
+	p1 := spec.Devices[0]
+	if p1.Annotations[PortAnnotation] != "1" || p1.Annotations[PortInterfaceAnnotation] != "ib0" {
+		t.Errorf("port 1 annotations = %v", p1.Annotations)
+	}
+	var nodes []string
+	for _, n := range p1.ContainerEdits.DeviceNodes {
+		nodes = append(nodes, n.Path)
+	}
+	if want := []string{"/dev/infiniband/rdma_cm", "/dev/infiniband/umad0", "/dev/infiniband/uverbs0"}; !slices.Equal(nodes, want) {
+		t.Errorf("port 1 nodes = %v, want %v", nodes, want)
+	}
+
+	// Without WithPerPort the function stays one device
+	if spec, err := BuildSpec("rdma", "net", devs); err != nil || len(spec.Devices) != 1 {
+		t.Errorf("combined BuildSpec = %v, %v", spec, err)
+	}
+
+	// ReadSpec folds the ports back into one device
+	dir := t.TempDir()
+	if err := CreateCDISpec("rdma", "net", devs, dir, "yaml", WithPerPort()); err != nil {
+		t.Fatal(err)
+	}
+	read, meta, err := ReadSpec(filepath.Join(dir, SpecFileName("rdma", "net", "yaml")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.PerPort || len(read) != 1 || read[0].PciAddress != "0000:17:00.0" || len(read[0].DeviceSpecs) != 4 || len(read[0].Ports) != 2 {
+		t.Errorf("ReadSpec = %+v, %+v", read, meta)
+	}
+}
+
+func TestBuildSpec_Bond(t *testing.T) {
+	devs := sampleDevices()
+	devs[0].IfName = "bond0"
+	devs[0].Bond = &types.RdmaBond{IfName: "bond0", Members: []types.BondMember{
+		{PciAddress: "0000:17:00.0", IfName: "enp23s0f0np0"},
+		{PciAddress: "0000:17:00.1", IfName: "enp23s0f1np1"},
+	}}
+
+	dir := t.TempDir()
+	if err := CreateCDISpec("rdma", "lag", devs, dir, "yaml", WithAliases()); err != nil {
+		t.Fatal(err)
+	}
+	got, meta, err := ReadSpec(filepath.Join(dir, SpecFileName("rdma", "lag", "yaml")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !reflect.DeepEqual(got[0].Bond, devs[0].Bond) {
+		t.Errorf("Bond = %+v, want %+v", got[0].Bond, devs[0].Bond)
+	}
+	if meta.Aliases["bond0"] != "0000:17:00.0" {
+		t.Errorf("bond interface should alias the device, got %v", meta.Aliases)
+	}
+
+	spec, err := BuildSpec("rdma", "lag", devs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := spec.Devices[0].Annotations; a[BondAnnotation] != "bond0" ||
+		a[BondMembersAnnotation] != "0000:17:00.0=enp23s0f0np0,0000:17:00.1=enp23s0f1np1" {
+		t.Errorf("unexpected bond annotations %v", a)
+	}
+}
+
+func TestBuildSpec_DeviceAnnotations(t *testing.T) {
+	devs := sampleDevices()
+	devs[0].LinkType, devs[0].NumaNode, devs[0].Rate = "infiniband", 1, "2.5 Gb/sec (1X SDR)"
+	devs[0].Vendor, devs[0].NodeGUID = "15b3", "0c42:a103:0065:4f3a"
+	devs[0].Ports = []types.RdmaPort{{Number: 1}, {Number: 2}}
+
+	spec, err := BuildSpec("rdma", "net", devs, WithDeviceAnnotations(), WithPerPort(), WithAliases(), WithAllDevice())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"rdma.cdi.x-k8s.io/link-type": "infiniband",
+		"rdma.cdi.x-k8s.io/numa-node": "1",
+		"rdma.cdi.x-k8s.io/rate-gbps": "2.5",
+		"rdma.cdi.x-k8s.io/vendor":    "15b3",
+		"rdma.cdi.x-k8s.io/node-guid": "0c42:a103:0065:4f3a",
+	}
+	for _, d := range spec.Devices {
+		_, isPort := d.Annotations[PortAnnotation]
+		for key, value := range want {
+			if got, ok := d.Annotations[key]; isPort && got != value || !isPort && ok {
+				t.Errorf("%s: %s = %q (set %v)", d.Name, key, got, ok)
+			}
+		}
+	}
+
+	// Unknown attributes are left out, and specs are unchanged by default
+	devs[0].NumaNode, devs[0].Rate, devs[0].NodeGUID, devs[0].Ports = -1, "", "", nil
+	spec, err = BuildSpec("rdma", "net", devs, WithDeviceAnnotations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := spec.Devices[0].Annotations; len(a) != 2 || a[LinkTypeAnnotation] != "infiniband" || a[VendorAnnotation] != "15b3" {
+		t.Errorf("annotations = %v, want link type and vendor only", a)
+	}
+	if spec, _ := BuildSpec("rdma", "net", devs); spec.Devices[0].Annotations != nil {
+		t.Errorf("annotations without WithDeviceAnnotations = %v", spec.Devices[0].Annotations)
+	}
+}
+
+// nodePlugin adds a companion device node to every device.
+type nodePlugin struct{}
+
+func (nodePlugin) Name() string                                  { return "node" }
+func (nodePlugin) Match(*types.RdmaDevice) bool                  { return true }
+func (nodePlugin) Discover(plugin.Host, *types.RdmaDevice) error { return nil }
+func (nodePlugin) ContainerEdits(*types.RdmaDevice) (*cdiSpecs.ContainerEdits, error) {
+	return &cdiSpecs.ContainerEdits{DeviceNodes: []*cdiSpecs.DeviceNode{{Path: "/dev/companion0"}}}, nil
+}
+
+func TestBuildSpec_Plugins(t *testing.T) {
+	devs := sampleDevices()
+	spec, err := BuildSpec("rdma", "p", devs, WithPlugins(nodePlugin{}), WithAliases())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range spec.Devices {
+		if !slices.ContainsFunc(d.ContainerEdits.DeviceNodes, func(n *cdiSpecs.DeviceNode) bool { return n.Path == "/dev/companion0" }) {
+			t.Errorf("device %s lacks the plugin's node", d.Name)
+		}
+	}
+	plain, err := BuildSpec("rdma", "p", devs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(spec.Devices[0].ContainerEdits.DeviceNodes), len(plain.Devices[0].ContainerEdits.DeviceNodes)+1; got != want {
+		t.Errorf("got %d device nodes, want %d", got, want)
+	}
+}
+
+func TestReadSpec_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	if _, _, err := ReadSpec(filepath.Join(dir, "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("missing file: err = %v, want not-exist", err)
+	}
+	bad := filepath.Join(dir, "bad.yaml")
+	os.WriteFile(bad, []byte("devices: ["), 0644)
+	if _, _, err := ReadSpec(bad); err == nil {
+		t.Error("expected parse error")
+	}
+}
+
 // ──────────────────────────────────────────────
 //  CleanupSpecs — safety boundary tests
 // ──────────────────────────────────────────────
@@ -310,23 +905,258 @@ func TestCleanupSpecs_PrefixWithSlash(t *testing.T) {
 	}
 }
 
+func TestRemoveSpecs(t *testing.T) {
+	dir := t.TempDir()
+	seedCleanupDir(t, dir)
+	foreign := filepath.Join(dir, "nvidia-cdi_rdma_gpu0.yaml")
+
+	if _, err := RemoveSpecs([]string{foreign}, false); err == nil {
+		t.Error("RemoveSpecs should refuse files not created by this tool")
+	}
+	if _, err := os.Stat(foreign); err != nil {
+		t.Errorf("foreign spec must survive: %v", err)
+	}
+
+	listed, err := ListSpecs(dir, "rdma", "dev1")
+	if err != nil || len(listed) == 0 {
+		t.Fatalf("ListSpecs: %v, %v", listed, err)
+	}
+	removed, err := RemoveSpecs(listed, false)
+	if err != nil || len(removed) != len(listed) {
+		t.Errorf("RemoveSpecs(%v) = %v, %v", listed, removed, err)
+	}
+}
+
+func TestStandardSpecDirs(t *testing.T) {
+	dirs := StandardSpecDirs()
+	if len(dirs) != 2 || dirs[0] != "/etc/cdi" || dirs[1] != "/var/run/cdi" {
+		t.Errorf("StandardSpecDirs() = %v, want [/etc/cdi /var/run/cdi]", dirs)
+	}
+	// Callers must not be able to mutate the package default
+	dirs[0] = "/tmp"
+	if StandardSpecDirs()[0] != "/etc/cdi" {
+		t.Error("StandardSpecDirs should return a copy")
+	}
+}
+
+func TestRootlessOutputDir(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	if got, err := RootlessOutputDir(); err != nil || got != "/run/user/1000/cdi" {
+		t.Errorf("RootlessOutputDir() = %q, %v, want /run/user/1000/cdi", got, err)
+	}
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	t.Setenv("HOME", "/home/dev")
+	if got, err := RootlessOutputDir(); err != nil || got != "/home/dev/.config/cdi" {
+		t.Errorf("RootlessOutputDir() = %q, %v, want /home/dev/.config/cdi", got, err)
+	}
+}
+
+func TestPrintCleanupJSON(t *testing.T) {
+	var buf bytes.Buffer
+	s := &CleanupSummary{Matched: []string{"/etc/cdi/rdma-cdi_rdma_a.yaml"}, Skipped: []string{"/etc/cdi/rdma-cdi_rdma_a.yaml"}, DryRun: true}
+	if err := PrintCleanupJSON(&buf, s); err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	for _, key := range []string{"matched", "removed", "skipped", "errors"} {
+		if _, ok := got[key].([]interface{}); !ok {
+			t.Errorf("%s should be a JSON array, got %v", key, got[key])
+		}
+	}
+	if got["dry_run"] != true {
+		t.Errorf("dry_run = %v, want true", got["dry_run"])
+	}
+	if s.Removed != nil {
+		t.Error("PrintCleanupJSON must not modify the summary")
+	}
+}
+
+// ──────────────────────────────────────────────
+//  SpecSelector
+// ──────────────────────────────────────────────
+
+func TestResourceName(t *testing.T) {
+	tests := []struct {
+		path   string
+		prefix string
+		want   string
+	}{
+		{"/etc/cdi/rdma-cdi_rdma_pci-0000-3b-00-0.yaml", "rdma", "pci-0000-3b-00-0"},
+		{"rdma-cdi_example.io_rdma_dev1.json", "example.io/rdma", "dev1"},
+		{"rdma-cdi_custom_dev3.json", "rdma", ""},
+		{"other-tool.json", "rdma", ""},
+	}
+	for _, tc := range tests {
+		if got := ResourceName(tc.path, tc.prefix); got != tc.want {
+			t.Errorf("ResourceName(%q, %q) = %q, want %q", tc.path, tc.prefix, got, tc.want)
+		}
+	}
+}
+
+func TestSpecSelector_Filter(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour)
+	files := map[string]time.Time{
+		"rdma-cdi_rdma_pci-0000-3b-00-0.yaml": old,
+		"rdma-cdi_rdma_pci-0000-3b-00-1.yaml": time.Now(),
+		"rdma-cdi_rdma_pci-0000-86-00-0.yaml": old,
+	}
+	var paths []string
+	for name, mtime := range files {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+
+	tests := []struct {
+		name string
+		sel  SpecSelector
+		want int
+	}{
+		{"zero", SpecSelector{}, 3},
+		{"glob", SpecSelector{NameGlob: "pci-0000-3b-*"}, 2},
+		{"age", SpecSelector{OlderThan: 24 * time.Hour}, 2},
+		{"glob_and_age", SpecSelector{NameGlob: "pci-0000-3b-*", OlderThan: 24 * time.Hour}, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.sel.Filter(paths, "rdma")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tc.want {
+				t.Errorf("selected %v, want %d files", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := (SpecSelector{NameGlob: "pci-["}).Filter(paths, "rdma"); err == nil {
+		t.Error("malformed glob should be rejected")
+	}
+}
+
+// ──────────────────────────────────────────────
+//  CleanupOrphanSpecs
+// ──────────────────────────────────────────────
+
+func TestCleanupOrphanSpecs(t *testing.T) {
+	dir := t.TempDir()
+	mk := func(name, pci, format string) string {
+		devs := sampleDevices()
+		devs[0].PciAddress = pci
+		if err := CreateCDISpec("rdma", name, devs, dir, format); err != nil {
+			t.Fatal(err)
+		}
+		return filepath.Join(dir, SpecFileName("rdma", name, format))
+	}
+	alive := mk("alive", "0000:17:00.0", "yaml")
+	goneYAML := mk("gone-vf", "0000:17:00.2", "yaml")
+	goneJSON := mk("gone-card", "0000:41:00.0", "json")
+	present := func(pci string) bool { return pci == "0000:17:00.0" }
+
+	// Dry run reports orphans but keeps them
+	removed, err := CleanupOrphanSpecs(dir, "rdma", "", present, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 {
+		t.Fatalf("dry run: expected 2 orphans, got %v", removed)
+	}
+	if _, err := os.Stat(goneYAML); err != nil {
+		t.Error("dry run should not remove files")
+	}
+
+	removed, err = CleanupOrphanSpecs(dir, "rdma", "", present, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("expected 2 removed, got %v", removed)
+	}
+	for _, p := range []string{goneYAML, goneJSON} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("orphan %s should be removed", p)
+		}
+	}
+	if _, err := os.Stat(alive); err != nil {
+		t.Errorf("spec for present device should be kept: %v", err)
+	}
+}
+
+func TestCleanupOrphanSpecs_KeepsPartialAndUnreadable(t *testing.T) {
+	dir := t.TempDir()
+	devs := append(sampleDevices(), sampleDevices()...)
+	devs[1].PciAddress = "0000:17:00.2"
+	if err := CreateCDISpec("rdma", "multi", devs, dir, "yaml"); err != nil {
+		t.Fatal(err)
+	}
+	junk := filepath.Join(dir, "rdma-cdi_rdma_junk.yaml")
+	if err := os.WriteFile(junk, []byte("{not: [valid"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := CleanupOrphanSpecs(dir, "rdma", "", func(pci string) bool { return pci == "0000:17:00.0" }, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("partially present and unreadable specs must be kept, removed %v", removed)
+	}
+}
+
 // ──────────────────────────────────────────────
 //  CreateContainerAnnotations
 // ──────────────────────────────────────────────
 
 func TestCreateContainerAnnotations_Basic(t *testing.T) {
-	devs := sampleDevices()
-	annotations, err := CreateContainerAnnotations(devs, "rdma", "net")
+	devs := append(sampleDevices(), types.RdmaDevice{PciAddress: "0000:41:00.0"})
+	annotations, err := CreateContainerAnnotations(devs, "rdma", "net", "rdma-cdi.net", "alloc-1")
 	if err != nil {
 		t.Fatalf("CreateContainerAnnotations failed: %v", err)
 	}
-	if len(annotations) != 1 {
-		t.Errorf("expected 1 annotation, got %d", len(annotations))
+	want := map[string]string{
+		"cdi.k8s.io/rdma-cdi.net_alloc-1": "rdma/net=0000:17:00.0,rdma/net=0000:41:00.0",
+	}
+	if !reflect.DeepEqual(annotations, want) {
+		t.Errorf("annotations = %v, want %v", annotations, want)
+	}
+
+	// Must be consumable by CDI-aware runtimes
+	_, names, err := cdiapi.ParseAnnotations(annotations)
+	if err != nil || !slices.Equal(names, []string{"rdma/net=0000:17:00.0", "rdma/net=0000:41:00.0"}) {
+		t.Errorf("ParseAnnotations = %v, %v", names, err)
+	}
+}
+
+func TestUpdateAnnotations(t *testing.T) {
+	existing := map[string]string{"io.kubernetes.cri/other": "x"}
+	annotations, err := UpdateAnnotations(existing, sampleDevices(), "rdma", "net", "rdma-cdi.net", "alloc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annotations) != 2 || annotations["io.kubernetes.cri/other"] != "x" {
+		t.Errorf("existing annotations not kept: %v", annotations)
+	}
+
+	if _, err := UpdateAnnotations(annotations, sampleDevices(), "rdma", "net", "rdma-cdi.net", "alloc-1"); err == nil {
+		t.Error("expected conflict for a key that is already set")
+	}
+	if _, err := UpdateAnnotations(map[string]string{}, sampleDevices(), "rdma", "net", "", "alloc-1"); err == nil {
+		t.Error("expected error for an empty plugin name")
 	}
 }
 
 func TestCreateContainerAnnotations_Empty(t *testing.T) {
-	_, err := CreateContainerAnnotations(nil, "rdma", "net")
+	_, err := CreateContainerAnnotations(nil, "rdma", "net", "rdma-cdi.net", "alloc-1")
 	if err == nil {
 		t.Error("expected error for empty devices")
 	}
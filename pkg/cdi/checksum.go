@@ -0,0 +1,135 @@
+package cdi
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
+)
+
+// checksumPrefix names the hash algorithm in ChecksumAnnotation values.
+const checksumPrefix = "sha256:"
+
+// SpecChecksum returns the checksum of the content of spec, ignoring its
+// ChecksumAnnotation. It depends on what the spec says, not on how the
+// file is laid out, so the same spec in YAML and JSON has one checksum.
+func SpecChecksum(spec *cdiSpecs.Spec) (string, error) {
+	unsigned := *spec
+	if _, ok := spec.Annotations[ChecksumAnnotation]; ok {
+		unsigned.Annotations = maps.Clone(spec.Annotations)
+		delete(unsigned.Annotations, ChecksumAnnotation)
+	}
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return "", err
+	}
+	return checksumPrefix + checksum(data), nil
+}
+
+// signSpec returns a copy of spec carrying its ChecksumAnnotation.
+func signSpec(spec *cdiSpecs.Spec) (*cdiSpecs.Spec, error) {
+	sum, err := SpecChecksum(spec)
+	if err != nil {
+		return nil, err
+	}
+	signed := *spec
+	signed.Annotations = maps.Clone(spec.Annotations)
+	if signed.Annotations == nil {
+		signed.Annotations = make(map[string]string, 1)
+	}
+	signed.Annotations[ChecksumAnnotation] = sum
+	return &signed, nil
+}
+
+// fileSpecChecksum returns the SpecChecksum of the spec file at path.
+func fileSpecChecksum(path string) (string, error) {
+	spec, err := LoadSpec(path)
+	if err != nil {
+		return "", err
+	}
+	return SpecChecksum(spec)
+}
+
+// Integrity statuses reported by VerifySpec.
+const (
+	// IntegrityIntact means the spec is as this tool wrote it, up to
+	// formatting.
+	IntegrityIntact = "intact"
+	// IntegrityEdited means the spec is valid but its content changed
+	// since it was written: an edit by hand or by another tool.
+	IntegrityEdited = "edited"
+	// IntegrityCorrupt means the spec no longer parses as a valid CDI
+	// spec, e.g. after a truncated write or disk damage.
+	IntegrityCorrupt = "corrupt"
+	// IntegrityUnverified means the spec has neither a checksum annotation
+	// nor a state record to check it against, e.g. one written by an
+	// earlier release.
+	IntegrityUnverified = "unverified"
+)
+
+// SpecIntegrity is the result of VerifySpec.
+type SpecIntegrity struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	// Detail explains an edited or corrupt status.
+	Detail string `json:"detail,omitempty"`
+}
+
+// VerifySpec checks the spec file at path against the checksum it was
+// written with: the one recorded in state if there is a record, since an
+// annotation can be rewritten together with the content, and otherwise
+// its ChecksumAnnotation. state may be nil. The error is only set when the
+// file cannot be read at all.
+func VerifySpec(path string, state *State) (SpecIntegrity, error) {
+	res := SpecIntegrity{Path: path}
+	data, err := Files.ReadFile(path)
+	if err != nil {
+		return res, err
+	}
+	corrupt := func(format string, args ...any) (SpecIntegrity, error) {
+		res.Status, res.Detail = IntegrityCorrupt, fmt.Sprintf(format, args...)
+		return res, nil
+	}
+	if len(data) == 0 {
+		return corrupt("file is empty")
+	}
+	spec, err := LoadSpec(path)
+	if err != nil {
+		return corrupt("%v", err)
+	}
+	if err := validateSpec(spec); err != nil {
+		return corrupt("not a valid CDI spec: %v", err)
+	}
+	sum, err := SpecChecksum(spec)
+	if err != nil {
+		return res, err
+	}
+
+	var rec SpecRecord
+	var recorded bool
+	if state != nil {
+		rec, recorded = state.Lookup(path)
+	}
+	switch annotated := spec.Annotations[ChecksumAnnotation]; {
+	case recorded && rec.Checksum != "":
+		res.Status = IntegrityIntact
+		if sum != rec.Checksum {
+			res.Status, res.Detail = IntegrityEdited, "content differs from the checksum in the state file"
+		}
+	case annotated != "":
+		res.Status = IntegrityIntact
+		if sum != annotated {
+			res.Status, res.Detail = IntegrityEdited, "content differs from its "+ChecksumAnnotation+" annotation"
+		}
+	case recorded:
+		// Recorded by a release that only kept the file checksum
+		res.Status = IntegrityIntact
+		if checksum(data) != rec.SHA256 {
+			res.Status, res.Detail = IntegrityEdited, "file differs from the checksum in the state file"
+		}
+	default:
+		res.Status = IntegrityUnverified
+	}
+	return res, nil
+}
@@ -0,0 +1,90 @@
+package cdi
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSpecChecksum_IgnoresFormat(t *testing.T) {
+	dir := t.TempDir()
+	for _, format := range []string{"yaml", "json"} {
+		if err := CreateCDISpec("rdma", format, sampleDevices(), dir, format); err != nil {
+			t.Fatal(err)
+		}
+	}
+	yamlSpec, err := LoadSpec(filepath.Join(dir, "rdma-cdi_rdma_yaml.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonSpec, err := LoadSpec(filepath.Join(dir, "rdma-cdi_rdma_json.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonSpec.Kind = yamlSpec.Kind
+	yamlSum, _ := SpecChecksum(yamlSpec)
+	jsonSum, _ := SpecChecksum(jsonSpec)
+	if yamlSum != jsonSum || !strings.HasPrefix(yamlSum, "sha256:") {
+		t.Errorf("checksums differ across formats: %s vs %s", yamlSum, jsonSum)
+	}
+	if yamlSpec.Annotations[ChecksumAnnotation] == "" {
+		t.Error("written spec has no checksum annotation")
+	}
+}
+
+func TestVerifySpec(t *testing.T) {
+	dir := t.TempDir()
+	if err := CreateCDISpec("rdma", "a", sampleDevices(), dir, "json"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "rdma-cdi_rdma_a.json")
+	orig, _ := os.ReadFile(path)
+	state := &State{}
+	if err := state.Record(path, "rdma/a", []string{"0000:17:00.0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-signing an edit fools the annotation, not the state record
+	edited, err := LoadSpec(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited.Devices[0].ContainerEdits.DeviceNodes = edited.Devices[0].ContainerEdits.DeviceNodes[:1]
+	edited, _ = signSpec(edited)
+	resigned, err := marshalSpec(edited, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		state   *State
+		want    string
+	}{
+		{"as written", string(orig), state, IntegrityIntact},
+		{"reformatted", strings.ReplaceAll(string(orig), "  ", "\t"), state, IntegrityIntact},
+		{"edited", strings.Replace(string(orig), "uverbs0", "uverbs9", 2), nil, IntegrityEdited},
+		{"re-signed edit", string(resigned), state, IntegrityEdited},
+		{"re-signed edit without state", string(resigned), nil, IntegrityIntact},
+		{"truncated", string(orig[:len(orig)/2]), state, IntegrityCorrupt},
+		{"empty", "", state, IntegrityCorrupt},
+		{"invalid", `{"cdiVersion": "0.3.0", "kind": "rdma/a", "devices": []}`, nil, IntegrityCorrupt},
+		{"no checksum", strings.Replace(string(orig), ChecksumAnnotation, "other/note", 1), nil, IntegrityUnverified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := VerifySpec(path, tt.state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.want {
+				t.Errorf("VerifySpec() = %+v, want %s", got, tt.want)
+			}
+		})
+	}
+}
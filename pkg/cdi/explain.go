@@ -0,0 +1,183 @@
+package cdi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	cdiparser "tags.cncf.io/container-device-interface/pkg/parser"
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
+
+	"github.com/Nativu5/rdma-cdi/pkg/utils"
+)
+
+// ErrUnresolvedDevice is returned (wrapped) by Resolve when no spec defines
+// the requested device.
+var ErrUnresolvedDevice = errors.New("CDI device not found")
+
+// Resolution is what a runtime would inject for one qualified device name.
+type Resolution struct {
+	// Name is the qualified device name, e.g. "rdma/net=0000:17:00.0".
+	Name string `json:"name"`
+	// SpecPath is the spec file the device is taken from.
+	SpecPath string `json:"spec_path"`
+	// Shadowed lists lower-priority spec files that define the same device
+	// and are ignored by the runtime.
+	Shadowed []string `json:"shadowed,omitempty"`
+	// Edits are the spec-wide edits followed by the device's own edits.
+	Edits cdiSpecs.ContainerEdits `json:"edits"`
+}
+
+// Resolve finds the device named by a fully qualified CDI name
+// (vendor/class=device) in the spec files under dirs. As with CDI
+// runtimes, a spec in a later directory takes priority over one in an
+// earlier directory.
+func Resolve(dirs []string, qualifiedName string) (*Resolution, error) {
+	vendor, class, device, err := cdiparser.ParseQualifiedName(qualifiedName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CDI device name: %w", err)
+	}
+	kind := vendor + "/" + class
+
+	var r *Resolution
+	for _, dir := range dirs {
+		files, err := ListAllSpecs(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			spec, err := LoadSpec(f)
+			if err != nil || spec.Kind != kind {
+				continue
+			}
+			for _, dev := range spec.Devices {
+				if dev.Name != device {
+					continue
+				}
+				next := &Resolution{Name: qualifiedName, SpecPath: f, Edits: mergeEdits(spec.ContainerEdits, dev.ContainerEdits)}
+				if r != nil {
+					next.Shadowed = append(r.Shadowed, r.SpecPath)
+				}
+				r = next
+			}
+		}
+	}
+	if r == nil {
+		return nil, fmt.Errorf("%w: %s in %s", ErrUnresolvedDevice, qualifiedName, strings.Join(dirs, ", "))
+	}
+	return r, nil
+}
+
+// Definition is a spec file defining a CDI device.
+type Definition struct {
+	Path string
+	// Priority is the index of the spec's directory in the search path;
+	// runtimes take a device from the definition with the highest.
+	Priority int
+}
+
+// FindDefinitions returns, for each of the fully qualified device names,
+// the spec files under dirs that define it, lowest priority first.
+// Unreadable specs are skipped.
+func FindDefinitions(dirs, names []string) (map[string][]Definition, error) {
+	defs := make(map[string][]Definition)
+	for i, dir := range dirs {
+		files, err := ListAllSpecs(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			spec, err := LoadSpec(f)
+			if err != nil {
+				continue
+			}
+			for _, dev := range spec.Devices {
+				if name := spec.Kind + "=" + dev.Name; slices.Contains(names, name) {
+					defs[name] = append(defs[name], Definition{Path: f, Priority: i})
+				}
+			}
+		}
+	}
+	return defs, nil
+}
+
+// mergeEdits appends the device edits to the spec-wide ones, in the order
+// a runtime applies them.
+func mergeEdits(spec, dev cdiSpecs.ContainerEdits) cdiSpecs.ContainerEdits {
+	return cdiSpecs.ContainerEdits{
+		Env:            append(append([]string{}, spec.Env...), dev.Env...),
+		DeviceNodes:    append(append([]*cdiSpecs.DeviceNode{}, spec.DeviceNodes...), dev.DeviceNodes...),
+		NetDevices:     append(append([]*cdiSpecs.LinuxNetDevice{}, spec.NetDevices...), dev.NetDevices...),
+		Hooks:          append(append([]*cdiSpecs.Hook{}, spec.Hooks...), dev.Hooks...),
+		Mounts:         append(append([]*cdiSpecs.Mount{}, spec.Mounts...), dev.Mounts...),
+		AdditionalGIDs: append(append([]uint32{}, spec.AdditionalGIDs...), dev.AdditionalGIDs...),
+	}
+}
+
+// PrintResolution renders a resolution as human-readable text. Device
+// nodes missing on the host, looked up under devRoot (e.g. "/host/dev"),
+// are flagged.
+func PrintResolution(w io.Writer, r *Resolution, devRoot string) {
+	fmt.Fprintf(w, "Device: %s\n", r.Name)
+	fmt.Fprintf(w, "Spec:   %s\n", r.SpecPath)
+	for _, s := range r.Shadowed {
+		fmt.Fprintf(w, "        (overrides %s)\n", s)
+	}
+
+	fmt.Fprintln(w, "\nDevice nodes:")
+	if len(r.Edits.DeviceNodes) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+	for _, n := range r.Edits.DeviceNodes {
+		host := n.HostPath
+		if host == "" {
+			host = n.Path
+		}
+		line := fmt.Sprintf("  %s <- %s", n.Path, host)
+		if n.Permissions != "" {
+			line += " (" + n.Permissions + ")"
+		}
+		if n.Type != "" {
+			line += fmt.Sprintf(" %s %d:%d", n.Type, n.Major, n.Minor)
+		}
+		if _, err := os.Stat(utils.RebasePath(host, "/dev", devRoot)); errors.Is(err, os.ErrNotExist) {
+			line += "  [missing on host]"
+		}
+		fmt.Fprintln(w, line)
+	}
+
+	fmt.Fprintln(w, "\nMounts:")
+	if len(r.Edits.Mounts) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+	for _, m := range r.Edits.Mounts {
+		fmt.Fprintf(w, "  %s <- %s (%s)\n", m.ContainerPath, m.HostPath, strings.Join(m.Options, ","))
+	}
+
+	fmt.Fprintln(w, "\nEnv:")
+	if len(r.Edits.Env) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+	for _, e := range r.Edits.Env {
+		fmt.Fprintf(w, "  %s\n", e)
+	}
+
+	fmt.Fprintln(w, "\nHooks:")
+	if len(r.Edits.Hooks) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+	for _, h := range r.Edits.Hooks {
+		fmt.Fprintf(w, "  %s: %s %s\n", h.HookName, h.Path, strings.Join(h.Args, " "))
+	}
+}
+
+// PrintResolutionJSON renders a resolution as JSON.
+func PrintResolutionJSON(w io.Writer, r *Resolution) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
@@ -0,0 +1,127 @@
+package cdi
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeExplainSpec(t *testing.T, dir, file, body string) string {
+	t.Helper()
+	p := filepath.Join(dir, file)
+	if err := os.WriteFile(p, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+const explainSpec = `cdiVersion: "0.5.0"
+kind: rdma/net
+containerEdits:
+  env:
+    - SHARED=1
+devices:
+  - name: "0000:17:00.0"
+    containerEdits:
+      env:
+        - DEV=17
+      deviceNodes:
+        - path: /dev/infiniband/uverbs0
+      mounts:
+        - hostPath: /sys/class/infiniband
+          containerPath: /sys/class/infiniband
+          options: [ro, rbind]
+`
+
+func TestResolve(t *testing.T) {
+	etc, run := t.TempDir(), t.TempDir()
+	low := writeExplainSpec(t, etc, "a.yaml", explainSpec)
+	high := writeExplainSpec(t, run, "b.yaml", explainSpec)
+
+	tests := []struct {
+		name     string
+		dirs     []string
+		qname    string
+		wantSpec string
+		wantErr  error
+	}{
+		{"single_dir", []string{etc}, "rdma/net=0000:17:00.0", low, nil},
+		{"later_dir_wins", []string{etc, run}, "rdma/net=0000:17:00.0", high, nil},
+		{"unknown_device", []string{etc}, "rdma/net=0000:41:00.0", "", ErrUnresolvedDevice},
+		{"unknown_kind", []string{etc}, "rdma/ib=0000:17:00.0", "", ErrUnresolvedDevice},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := Resolve(tc.dirs, tc.qname)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.SpecPath != tc.wantSpec {
+				t.Errorf("SpecPath = %s, want %s", r.SpecPath, tc.wantSpec)
+			}
+			if got := strings.Join(r.Edits.Env, ","); got != "SHARED=1,DEV=17" {
+				t.Errorf("Env = %s, want spec-wide then device env", got)
+			}
+		})
+	}
+
+	r, err := Resolve([]string{etc, run}, "rdma/net=0000:17:00.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Shadowed) != 1 || r.Shadowed[0] != low {
+		t.Errorf("Shadowed = %v, want [%s]", r.Shadowed, low)
+	}
+
+	if _, err := Resolve([]string{etc}, "not-a-name"); err == nil {
+		t.Error("expected error for unqualified name")
+	}
+}
+
+func TestFindDefinitions(t *testing.T) {
+	etc, run := t.TempDir(), t.TempDir()
+	low := writeExplainSpec(t, etc, "a.yaml", explainSpec)
+	high := writeExplainSpec(t, run, "b.yaml", explainSpec)
+	writeExplainSpec(t, run, "broken.yaml", "kind: [")
+
+	defs, err := FindDefinitions([]string{etc, run, filepath.Join(etc, "missing")},
+		[]string{"rdma/net=0000:17:00.0", "rdma/net=0000:41:00.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Definition{{Path: low, Priority: 0}, {Path: high, Priority: 1}}
+	if got := defs["rdma/net=0000:17:00.0"]; !slices.Equal(got, want) {
+		t.Errorf("definitions = %+v, want %+v", got, want)
+	}
+	if got := defs["rdma/net=0000:41:00.0"]; len(got) != 0 {
+		t.Errorf("undefined device has definitions %+v", got)
+	}
+}
+
+func TestPrintResolution(t *testing.T) {
+	dir := t.TempDir()
+	writeExplainSpec(t, dir, "a.yaml", explainSpec)
+	r, err := Resolve([]string{dir}, "rdma/net=0000:17:00.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	PrintResolution(&buf, r, "/dev")
+	out := buf.String()
+	for _, want := range []string{"/dev/infiniband/uverbs0", "/sys/class/infiniband (ro,rbind)", "DEV=17", "Hooks:\n  (none)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package cdi
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FS is the filesystem the package writes spec files, the state file and
+// backups through, and reads them back from. Paths are host paths, as with
+// the os package.
+//
+// Guard watches for changes with fsnotify, and LoadInCache and
+// FindDefinitions load specs with the CDI library, so those only see the
+// host filesystem.
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	// WriteFile creates or truncates name and writes data to it, durably:
+	// the data is on disk when it returns.
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	Mkdir(name string, perm fs.FileMode) error
+	MkdirAll(name string, perm fs.FileMode) error
+	Rename(oldname, newname string) error
+	Remove(name string) error
+	Chmod(name string, mode fs.FileMode) error
+	Chown(name string, uid, gid int) error
+	// SyncDir flushes the entries of directory name to disk.
+	SyncDir(name string) error
+}
+
+// Files is the filesystem of the package, the host's by default. Tests, and
+// embedders that want writes redirected, replace it, e.g. with a MemFS.
+var Files FS = OSFS{}
+
+// Clock is the clock of the package, stamping generated specs, state
+// records and backups and aging specs for cleanup. Replaceable like Files.
+var Clock = time.Now
+
+// OSFS is the host filesystem.
+type OSFS struct{}
+
+func (OSFS) ReadFile(name string) ([]byte, error)         { return os.ReadFile(name) }
+func (OSFS) ReadDir(name string) ([]fs.DirEntry, error)   { return os.ReadDir(name) }
+func (OSFS) Stat(name string) (fs.FileInfo, error)        { return os.Stat(name) }
+func (OSFS) Mkdir(name string, perm fs.FileMode) error    { return os.Mkdir(name, perm) }
+func (OSFS) MkdirAll(name string, perm fs.FileMode) error { return os.MkdirAll(name, perm) }
+func (OSFS) Rename(oldname, newname string) error         { return os.Rename(oldname, newname) }
+func (OSFS) Remove(name string) error                     { return os.Remove(name) }
+func (OSFS) Chmod(name string, mode fs.FileMode) error    { return os.Chmod(name, mode) }
+func (OSFS) Chown(name string, uid, gid int) error        { return os.Chown(name, uid, gid) }
+
+func (OSFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (OSFS) SyncDir(name string) error {
+	d, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// MemFS is an in-memory FS. As on disk, a file needs an existing parent
+// directory; modification times come from Clock.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+}
+
+type memFile struct {
+	data     []byte
+	mode     fs.FileMode
+	modTime  time.Time
+	uid, gid int
+}
+
+// NewMemFS returns an empty MemFS holding only the root directory.
+func NewMemFS() *MemFS {
+	return &MemFS{files: map[string]*memFile{"/": {mode: fs.ModeDir | 0755, modTime: Clock()}}}
+}
+
+// Owner returns the owner Chown set on name, or 0, 0.
+func (m *MemFS) Owner(name string) (uid, gid int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if f, ok := m.files[memPath(name)]; ok {
+		return f.uid, f.gid
+	}
+	return 0, 0
+}
+
+func memPath(name string) string {
+	return filepath.Clean("/" + name)
+}
+
+func (m *MemFS) lookup(op, name string) (string, *memFile, error) {
+	p := memPath(name)
+	f, ok := m.files[p]
+	if !ok {
+		return p, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return p, f, nil
+}
+
+// parentDir fails unless the parent of p is an existing directory.
+func (m *MemFS) parentDir(op, name, p string) error {
+	if parent, ok := m.files[filepath.Dir(p)]; !ok || !parent.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, f, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if f.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	return slices.Clone(f.data), nil
+}
+
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, f, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if !f.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdirent", Path: name, Err: errors.New("not a directory")}
+	}
+	var entries []fs.DirEntry
+	for child, cf := range m.files {
+		if child != p && filepath.Dir(child) == p {
+			entries = append(entries, fs.FileInfoToDirEntry(memInfo{filepath.Base(child), cf}))
+		}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, f, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return memInfo{filepath.Base(p), f}, nil
+}
+
+func (m *MemFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := memPath(name)
+	if err := m.parentDir("open", name, p); err != nil {
+		return err
+	}
+	if f, ok := m.files[p]; ok {
+		if f.mode.IsDir() {
+			return &fs.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+		}
+		f.data, f.modTime = slices.Clone(data), Clock()
+		return nil
+	}
+	m.files[p] = &memFile{data: slices.Clone(data), mode: perm.Perm(), modTime: Clock()}
+	return nil
+}
+
+func (m *MemFS) Mkdir(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := memPath(name)
+	if _, ok := m.files[p]; ok {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if err := m.parentDir("mkdir", name, p); err != nil {
+		return err
+	}
+	m.files[p] = &memFile{mode: fs.ModeDir | perm.Perm(), modTime: Clock()}
+	return nil
+}
+
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := memPath(name)
+	var missing []string
+	for ; ; p = filepath.Dir(p) {
+		if f, ok := m.files[p]; ok {
+			if !f.mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: name, Err: errors.New("not a directory")}
+			}
+			break
+		}
+		missing = append(missing, p)
+	}
+	for _, dir := range missing {
+		m.files[dir] = &memFile{mode: fs.ModeDir | perm.Perm(), modTime: Clock()}
+	}
+	return nil
+}
+
+func (m *MemFS) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldp, f, err := m.lookup("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newp := memPath(newname)
+	if err := m.parentDir("rename", newname, newp); err != nil {
+		return err
+	}
+	if f.mode.IsDir() {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: errors.New("renaming directories is not supported")}
+	}
+	delete(m.files, oldp)
+	m.files[newp] = f
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, f, err := m.lookup("remove", name)
+	if err != nil {
+		return err
+	}
+	if f.mode.IsDir() {
+		for child := range m.files {
+			if child != p && filepath.Dir(child) == p {
+				return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+			}
+		}
+	}
+	delete(m.files, p)
+	return nil
+}
+
+func (m *MemFS) Chmod(name string, mode fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, f, err := m.lookup("chmod", name)
+	if err != nil {
+		return err
+	}
+	f.mode = f.mode.Type() | mode.Perm()
+	return nil
+}
+
+func (m *MemFS) Chown(name string, uid, gid int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, f, err := m.lookup("chown", name)
+	if err != nil {
+		return err
+	}
+	f.uid, f.gid = uid, gid
+	return nil
+}
+
+func (m *MemFS) SyncDir(name string) error {
+	_, err := m.Stat(name)
+	return err
+}
+
+// memInfo is the fs.FileInfo of a MemFS file.
+type memInfo struct {
+	name string
+	f    *memFile
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return int64(len(i.f.data)) }
+func (i memInfo) Mode() fs.FileMode  { return i.f.mode }
+func (i memInfo) ModTime() time.Time { return i.f.modTime }
+func (i memInfo) IsDir() bool        { return i.f.mode.IsDir() }
+func (i memInfo) Sys() any           { return nil }
+
+// glob is filepath.Glob on Files, for patterns with a meta character in
+// the file name only.
+func glob(pattern string) ([]string, error) {
+	dir, base := filepath.Split(pattern)
+	if _, err := filepath.Match(base, ""); err != nil {
+		return nil, err
+	}
+	entries, err := Files.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil, nil // like filepath.Glob, a missing directory matches nothing
+	}
+	var matches []string
+	for _, e := range entries {
+		if ok, _ := filepath.Match(base, e.Name()); ok {
+			matches = append(matches, filepath.Join(dir, e.Name()))
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,130 @@
+package cdi
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+)
+
+// memFiles replaces Files with a fresh MemFS for the test.
+func memFiles(t *testing.T) *MemFS {
+	t.Helper()
+	orig := Files
+	m := NewMemFS()
+	Files = m
+	t.Cleanup(func() { Files = orig })
+	return m
+}
+
+func TestMemFS(t *testing.T) {
+	m := NewMemFS()
+
+	if err := m.WriteFile("/etc/cdi/a.yaml", []byte("a"), 0644); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("WriteFile() without a parent = %v, want ErrNotExist", err)
+	}
+	if err := m.MkdirAll("/etc/cdi", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Mkdir("/etc/cdi", 0755); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Mkdir() of an existing dir = %v, want ErrExist", err)
+	}
+	for _, name := range []string{"b.json", "a.yaml"} {
+		if err := m.WriteFile("/etc/cdi/"+name, []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Chmod("/etc/cdi/a.yaml", 0640); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := m.Stat("/etc/cdi/a.yaml"); err != nil || info.Mode() != 0640 || info.Size() != 6 {
+		t.Errorf("Stat() = %v, %v", info, err)
+	}
+	entries, err := m.ReadDir("/etc/cdi")
+	if err != nil || len(entries) != 2 || entries[0].Name() != "a.yaml" || entries[0].IsDir() {
+		t.Errorf("ReadDir() = %v, %v, want a.yaml then b.json", entries, err)
+	}
+	if err := m.Remove("/etc/cdi"); err == nil {
+		t.Error("Remove() of a non-empty directory should fail")
+	}
+	if err := m.Rename("/etc/cdi/b.json", "/etc/cdi/c.json"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := m.ReadFile("/etc/cdi/c.json"); err != nil || string(data) != "b.json" {
+		t.Errorf("ReadFile() after Rename = %q, %v", data, err)
+	}
+	if _, err := m.ReadFile("/etc/cdi/b.json"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the renamed file should be gone, got %v", err)
+	}
+	if err := m.Chown("/etc/cdi/a.yaml", 1000, 100); err != nil {
+		t.Fatal(err)
+	}
+	if uid, gid := m.Owner("/etc/cdi/a.yaml"); uid != 1000 || gid != 100 {
+		t.Errorf("Owner() = %d, %d", uid, gid)
+	}
+}
+
+// TestMemFS_SpecLifecycle generates, records, backs up, ages out and
+// restores a spec without touching the disk.
+func TestMemFS_SpecLifecycle(t *testing.T) {
+	m := memFiles(t)
+	generated := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	fixedClock(t, generated)
+
+	const dir = "/etc/cdi"
+	if err := CreateCDISpec("rdma", "test-dev", sampleDevices(), dir, "yaml", WithFileMode(0640), WithOwner(0, 42)); err != nil {
+		t.Fatal(err)
+	}
+	paths, err := ListSpecs(dir, "rdma", "")
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("ListSpecs() = %v, %v", paths, err)
+	}
+	spec := paths[0]
+	if info, err := m.Stat(spec); err != nil || info.Mode() != 0640 {
+		t.Errorf("spec mode = %v, %v, want 0640", info, err)
+	}
+	if _, gid := m.Owner(spec); gid != 42 {
+		t.Errorf("spec group = %d, want 42", gid)
+	}
+
+	state := &State{}
+	if err := state.Record(spec, "rdma/test-dev", []string{"0000:17:00.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Save("/var/lib/rdma-cdi/state.json"); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadState("/var/lib/rdma-cdi/state.json")
+	if err != nil || len(loaded.Specs) != 1 || !loaded.Specs[0].GeneratedAt.Equal(generated) {
+		t.Fatalf("LoadState() = %+v, %v", loaded, err)
+	}
+	if entries, _ := m.ReadDir("/var/lib/rdma-cdi"); len(entries) != 1 {
+		t.Errorf("the temporary state file should be renamed away, got %v", entries)
+	}
+
+	// A day later the spec is old enough for cleanup --older-than 12h
+	fixedClock(t, generated.Add(24*time.Hour))
+	old, err := SpecSelector{OlderThan: 12 * time.Hour}.Filter(paths, "rdma")
+	if err != nil || len(old) != 1 {
+		t.Fatalf("Filter() = %v, %v", old, err)
+	}
+	id, err := BackupSpecs("/var/lib/rdma-cdi/backups", old)
+	if err != nil || id != "20260302T120000Z" {
+		t.Fatalf("BackupSpecs() = %q, %v", id, err)
+	}
+	if removed, err := RemoveSpecs(old, false); err != nil || len(removed) != 1 {
+		t.Fatalf("RemoveSpecs() = %v, %v", removed, err)
+	}
+	if _, err := m.Stat(spec); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("the spec should be removed, got %v", err)
+	}
+
+	restored, err := RestoreBackup("/var/lib/rdma-cdi/backups", LatestBackup, "", false)
+	if err != nil || len(restored) != 1 || restored[0] != spec {
+		t.Fatalf("RestoreBackup() = %v, %v", restored, err)
+	}
+	if modified, err := loaded.Modified(spec); err != nil || modified {
+		t.Errorf("the restored spec should match its record: %v, %v", modified, err)
+	}
+}
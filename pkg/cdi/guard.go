@@ -0,0 +1,233 @@
+package cdi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Nativu5/rdma-cdi/pkg/utils"
+)
+
+// guardSettle is how long the guard waits for a burst of file system
+// events to settle before reconciling, long enough for generate to write
+// a spec and then record it in the state file.
+var guardSettle = time.Second
+
+// RestoreEvent reports a spec file the guard found modified or removed.
+// Err is nil when the recorded content was written back.
+type RestoreEvent struct {
+	Path string
+	Err  error
+}
+
+// guardedSpec is the last content of a spec file that matched its state
+// record.
+type guardedSpec struct {
+	data []byte
+	mode os.FileMode
+}
+
+type specGuard struct {
+	dirs      []string
+	statePath string
+	settle    time.Duration
+	watcher   *fsnotify.Watcher
+	resync    <-chan struct{}
+	specs     map[string]guardedSpec
+	// reported maps specs that could not be restored to the record
+	// checksum at the time, so each failure is reported once.
+	reported map[string]string
+}
+
+// GuardOption configures GuardSpecs.
+type GuardOption func(*specGuard)
+
+// GuardResync has the guard re-read the state file and reconcile at once
+// whenever resync receives, e.g. on SIGHUP, rather than on the next file
+// system event.
+func GuardResync(resync <-chan struct{}) GuardOption {
+	return func(g *specGuard) {
+		g.resync = resync
+	}
+}
+
+// GuardSpecs watches dirs and restores spec files recorded in the state
+// file at statePath when something other than this tool modifies or
+// removes them, e.g. a configuration management run wiping /etc/cdi.
+// Changes that generate, cleanup or migrate record in the state file are
+// accepted. The content of each spec is captured when the guard starts,
+// so files already modified then cannot be restored. Directories that are
+// removed are recreated. The returned channel is closed when ctx is done.
+func GuardSpecs(ctx context.Context, dirs []string, statePath string, opts ...GuardOption) (<-chan RestoreEvent, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("cannot watch spec directories: %w", err)
+	}
+	g := &specGuard{
+		statePath: statePath,
+		settle:    guardSettle,
+		watcher:   watcher,
+		specs:     make(map[string]guardedSpec),
+		reported:  make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	for _, dir := range dirs {
+		g.dirs = append(g.dirs, absPath(dir))
+	}
+	if err := g.watchDirs(); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	// Seed the copies; nothing can be restored yet
+	g.reconcile()
+
+	events := make(chan RestoreEvent)
+	go g.loop(ctx, events)
+	return events, nil
+}
+
+// watchDirs (re)creates and watches every guarded directory.
+func (g *specGuard) watchDirs() error {
+	for _, dir := range g.dirs {
+		if err := Files.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+		if err := g.watcher.Add(dir); err != nil {
+			return fmt.Errorf("cannot watch %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// loop reconciles on every (settled) burst of events and emits the
+// restorations.
+func (g *specGuard) loop(ctx context.Context, events chan<- RestoreEvent) {
+	defer close(events)
+	defer g.watcher.Close()
+
+	for {
+		wait := true
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-g.watcher.Errors:
+			log.Warnf("Spec directory watch error: %v", err)
+			continue
+		case <-g.watcher.Events:
+		case <-g.resync:
+			wait = false
+		}
+
+		// Let a burst of events settle before reconciling
+		timer := time.NewTimer(g.settle)
+	settle:
+		for wait {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-g.watcher.Events:
+			case <-timer.C:
+				break settle
+			}
+		}
+		timer.Stop()
+
+		if err := g.watchDirs(); err != nil {
+			log.Warnf("Spec guard: %v", err)
+		}
+		for _, ev := range g.reconcile() {
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// reconcile compares the guarded directories with the state file: specs
+// matching their record become the new copy, the others are restored from
+// the copy if it still matches the record. Specs no longer recorded stop
+// being guarded.
+func (g *specGuard) reconcile() []RestoreEvent {
+	state, err := LoadState(g.statePath)
+	if err != nil {
+		log.Warnf("Spec guard: %v", err)
+		return nil
+	}
+
+	var out []RestoreEvent
+	recorded := make(map[string]bool)
+	for _, rec := range state.Specs {
+		if !g.guards(rec.Path) {
+			continue
+		}
+		recorded[rec.Path] = true
+
+		data, err := Files.ReadFile(rec.Path)
+		if err == nil && checksum(data) == rec.SHA256 {
+			mode := os.FileMode(0644)
+			if info, err := Files.Stat(rec.Path); err == nil {
+				mode = info.Mode().Perm()
+			}
+			g.specs[rec.Path] = guardedSpec{data: data, mode: mode}
+			delete(g.reported, rec.Path)
+			continue
+		}
+		if g.reported[rec.Path] == rec.SHA256 {
+			continue
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			g.reported[rec.Path] = rec.SHA256
+			out = append(out, RestoreEvent{Path: rec.Path, Err: err})
+			continue
+		}
+
+		spec, ok := g.specs[rec.Path]
+		if !ok || checksum(spec.data) != rec.SHA256 {
+			g.reported[rec.Path] = rec.SHA256
+			out = append(out, RestoreEvent{Path: rec.Path, Err: errors.New("no copy matching the state file to restore from")})
+			continue
+		}
+		err = g.restore(rec.Path, spec)
+		out = append(out, RestoreEvent{Path: rec.Path, Err: err})
+	}
+
+	for path := range g.specs {
+		if !recorded[path] {
+			delete(g.specs, path)
+		}
+	}
+	for path := range g.reported {
+		if !recorded[path] {
+			delete(g.reported, path)
+		}
+	}
+	return out
+}
+
+// restore writes the copy of spec back to path, under the lock of the file
+// so it does not race a generate run rewriting it.
+func (g *specGuard) restore(path string, spec guardedSpec) error {
+	l, err := lockFiles(path)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+	return utils.WriteFileAtomic(path, spec.data, spec.mode)
+}
+
+// guards reports whether path is directly inside a guarded directory.
+func (g *specGuard) guards(path string) bool {
+	return slices.Contains(g.dirs, filepath.Dir(path))
+}
@@ -0,0 +1,191 @@
+package cdi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// guardFixture writes a recorded spec into a fresh directory and starts a
+// guard on it.
+func guardFixture(t *testing.T) (dir, spec, statePath string, events <-chan RestoreEvent) {
+	t.Helper()
+	orig := guardSettle
+	t.Cleanup(func() { guardSettle = orig })
+	guardSettle = 20 * time.Millisecond
+
+	dir = filepath.Join(t.TempDir(), "cdi")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	spec = filepath.Join(dir, "rdma-cdi_rdma_a.yaml")
+	statePath = filepath.Join(t.TempDir(), "state.json")
+	writeRecorded(t, spec, statePath, "v1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	events, err := GuardSpecs(ctx, []string{dir}, statePath)
+	if err != nil {
+		t.Fatalf("GuardSpecs() error = %v", err)
+	}
+	return dir, spec, statePath, events
+}
+
+// writeRecorded writes content to spec and records it, as generate does.
+func writeRecorded(t *testing.T, spec, statePath, content string) {
+	t.Helper()
+	if err := os.WriteFile(spec, []byte(content), 0640); err != nil {
+		t.Fatal(err)
+	}
+	state, err := LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Record(spec, "rdma/a", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Save(statePath); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func nextRestore(t *testing.T, events <-chan RestoreEvent) RestoreEvent {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a restore event")
+		return RestoreEvent{}
+	}
+}
+
+func assertContent(t *testing.T, path, want string) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	if string(got) != want {
+		t.Errorf("%s = %q, want %q", path, got, want)
+	}
+}
+
+func TestGuardSpecs_RestoresRemovedAndModified(t *testing.T) {
+	dir, spec, _, events := guardFixture(t)
+
+	if err := os.Remove(spec); err != nil {
+		t.Fatal(err)
+	}
+	ev := nextRestore(t, events)
+	if ev.Path != spec || ev.Err != nil {
+		t.Fatalf("event = %+v, want restore of %s", ev, spec)
+	}
+	assertContent(t, spec, "v1")
+	if info, _ := os.Stat(spec); info.Mode().Perm() != 0640 {
+		t.Errorf("restored mode = %v, want 0640", info.Mode().Perm())
+	}
+
+	if err := os.WriteFile(spec, []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextRestore(t, events); ev.Err != nil {
+		t.Fatalf("restore after edit failed: %v", ev.Err)
+	}
+	assertContent(t, spec, "v1")
+
+	// A wiped directory is recreated
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextRestore(t, events); ev.Err != nil {
+		t.Fatalf("restore after rm -rf failed: %v", ev.Err)
+	}
+	assertContent(t, spec, "v1")
+}
+
+func TestGuardSpecs_AcceptsRecordedChanges(t *testing.T) {
+	_, spec, statePath, events := guardFixture(t)
+
+	// A regenerated spec is the new copy, not a modification
+	writeRecorded(t, spec, statePath, "v2")
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event for a recorded change: %+v", ev)
+	case <-time.After(200 * time.Millisecond):
+	}
+	assertContent(t, spec, "v2")
+
+	// ...and is what gets restored
+	if err := os.Remove(spec); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextRestore(t, events); ev.Err != nil {
+		t.Fatalf("restore failed: %v", ev.Err)
+	}
+	assertContent(t, spec, "v2")
+
+	// cleanup removes the file and forgets the record
+	state, _ := LoadState(statePath)
+	state.Forget(spec)
+	if err := state.Save(statePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(spec); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event after cleanup: %+v", ev)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if _, err := os.Stat(spec); !os.IsNotExist(err) {
+		t.Errorf("cleaned-up spec was restored")
+	}
+}
+
+func TestGuardSpecs_Resync(t *testing.T) {
+	orig := guardSettle
+	t.Cleanup(func() { guardSettle = orig })
+	guardSettle = 20 * time.Millisecond
+
+	dir := t.TempDir()
+	spec := filepath.Join(dir, "rdma-cdi_rdma_a.yaml")
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	// The guard starts on a spec that no longer matches its record, so it
+	// has no copy to restore from
+	writeRecorded(t, spec, statePath, "v1")
+	if err := os.WriteFile(spec, []byte("v2"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	resync := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	events, err := GuardSpecs(ctx, []string{dir}, statePath, GuardResync(resync))
+	if err != nil {
+		t.Fatalf("GuardSpecs() error = %v", err)
+	}
+
+	// Recording the file touches only the state file, outside the guarded
+	// directory; the resync makes the guard take it as the copy
+	state, _ := LoadState(statePath)
+	if err := state.Record(spec, "rdma/a", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Save(statePath); err != nil {
+		t.Fatal(err)
+	}
+	resync <- struct{}{}
+	resync <- struct{}{} // received once the first reconcile is done
+
+	if err := os.Remove(spec); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextRestore(t, events); ev.Err != nil {
+		t.Fatalf("restore after resync failed: %v", ev.Err)
+	}
+	assertContent(t, spec, "v2")
+}
@@ -0,0 +1,59 @@
+package cdi
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"time"
+
+	"github.com/Nativu5/rdma-cdi/pkg/singleton"
+)
+
+// DefaultLockTimeout is how long a write waits for the lock of a spec file
+// or the state file by default.
+const DefaultLockTimeout = 30 * time.Second
+
+// LockDir holds the advisory locks the package takes around each write of
+// a spec file and each update of a state file, so generate runs from udev,
+// cron and an operator serialize instead of interleaving their writes.
+// Empty disables locking, e.g. for a private MemFS.
+var LockDir = filepath.Join(singleton.LockDir(), "files")
+
+// LockTimeout is how long a write waits for a lock held by another
+// process before failing with singleton.ErrLockTimeout; 0 fails at once.
+var LockTimeout = DefaultLockTimeout
+
+// lockFiles takes the locks of paths, in the same order in every process.
+func lockFiles(paths ...string) (*singleton.Lock, error) {
+	if LockDir == "" {
+		return &singleton.Lock{}, nil
+	}
+	return singleton.Wait(LockDir, LockTimeout, paths...)
+}
+
+// UpdateState loads the state file at path, applies update to it and saves
+// the result if it changed, all under the lock of the state file, so
+// concurrent updates do not lose each other's records.
+func UpdateState(path string, update func(*State) error) error {
+	if path == "" {
+		path = DefaultStateFile
+	}
+	l, err := lockFiles(path)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	state, err := LoadState(path)
+	if err != nil {
+		return err
+	}
+	before, _ := json.Marshal(state)
+	if err := update(state); err != nil {
+		return err
+	}
+	if after, _ := json.Marshal(state); bytes.Equal(before, after) {
+		return nil
+	}
+	return state.Save(path)
+}
@@ -0,0 +1,89 @@
+package cdi
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Nativu5/rdma-cdi/pkg/singleton"
+)
+
+// lockDir points LockDir at a fresh directory for the test.
+func lockDir(t *testing.T) string {
+	t.Helper()
+	orig := LockDir
+	LockDir = t.TempDir()
+	t.Cleanup(func() { LockDir = orig })
+	return LockDir
+}
+
+func TestUpdateState_Concurrent(t *testing.T) {
+	lockDir(t)
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state.json")
+
+	// Each update holds its own lock file open, as separate processes do
+	var wg sync.WaitGroup
+	errs := make([]error, 16)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = UpdateState(statePath, func(s *State) error {
+				spec := filepath.Join(dir, fmt.Sprintf("rdma-cdi_rdma_dev%02d.yaml", i))
+				if err := os.WriteFile(spec, []byte("kind: rdma/dev\n"), 0644); err != nil {
+					return err
+				}
+				return s.Record(spec, "rdma/dev", []string{"0000:17:00.0"})
+			})
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		t.Fatal(err)
+	}
+	state, err := LoadState(statePath)
+	if err != nil || len(state.Specs) != len(errs) {
+		t.Fatalf("LoadState() = %d records, %v; want every update kept", len(state.Specs), err)
+	}
+}
+
+func TestUpdateState_Unchanged(t *testing.T) {
+	lockDir(t)
+	m := memFiles(t)
+	if err := UpdateState("/var/lib/rdma-cdi/state.json", func(*State) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Stat("/var/lib/rdma-cdi/state.json"); err == nil {
+		t.Error("an update changing nothing should not write the state file")
+	}
+	failed := errors.New("no")
+	if err := UpdateState("/var/lib/rdma-cdi/state.json", func(*State) error { return failed }); !errors.Is(err, failed) {
+		t.Errorf("UpdateState() = %v, want the update's error", err)
+	}
+}
+
+func TestWriteSpec_WaitsForLock(t *testing.T) {
+	dir := lockDir(t)
+	out := t.TempDir()
+	held, err := singleton.Wait(dir, 0, filepath.Join(out, SpecFileName("rdma", "test-dev", "yaml")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := LockTimeout
+	LockTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { LockTimeout = orig })
+
+	err = CreateCDISpec("rdma", "test-dev", sampleDevices(), out, "yaml")
+	if !errors.Is(err, singleton.ErrLockTimeout) {
+		t.Fatalf("CreateCDISpec() = %v, want ErrLockTimeout while another writer holds the spec", err)
+	}
+	held.Release()
+	if err := CreateCDISpec("rdma", "test-dev", sampleDevices(), out, "yaml"); err != nil {
+		t.Fatalf("CreateCDISpec() after the lock is released: %v", err)
+	}
+}
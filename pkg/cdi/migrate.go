@@ -0,0 +1,176 @@
+package cdi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
+
+	"github.com/Nativu5/rdma-cdi/pkg/utils"
+)
+
+// Migration describes how one spec file written by an earlier release is
+// brought up to the current conventions.
+type Migration struct {
+	// Path is the spec file as found.
+	Path string `json:"path"`
+	// NewPath is where the spec ends up; equal to Path unless renamed.
+	NewPath string `json:"new_path"`
+	// Kind is the spec's CDI kind after migration.
+	Kind string `json:"kind"`
+	// Changes lists what was (or, in a dry run, would be) changed. Empty
+	// when the spec is already current.
+	Changes []string `json:"changes"`
+	// Error is set when the spec could not be migrated.
+	Error string `json:"error,omitempty"`
+
+	data []byte
+}
+
+// Renamed reports whether the migration moves the spec to a new file.
+func (m Migration) Renamed() bool {
+	return m.NewPath != m.Path
+}
+
+// FindManagedSpecs returns the spec files written by this tool in dirs,
+// whatever their prefix or extension.
+func FindManagedSpecs(dirs []string) ([]string, error) {
+	var out []string
+	for _, dir := range dirs {
+		files, err := ListAllSpecs(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if IsManaged(f) {
+				out = append(out, f)
+			}
+		}
+	}
+	return out, nil
+}
+
+// PlanMigrations works out, without touching any file, what bringing each
+// spec up to date involves: the current CDI version, a resource name that
+// satisfies today's naming rules, the canonical device order, and the file
+// name SpecFileName gives for the result. Fields unknown to the CDI spec
+// types are not carried over.
+func PlanMigrations(paths []string) []Migration {
+	plans := make([]Migration, 0, len(paths))
+	targets := make(map[string]string, len(paths))
+	for _, p := range paths {
+		m := planMigration(p)
+		if m.Error == "" {
+			if prev, ok := targets[m.NewPath]; ok && m.Renamed() {
+				m.Error = fmt.Sprintf("would be renamed to %s, which %s also maps to", m.NewPath, prev)
+			}
+			targets[m.NewPath] = p
+		}
+		plans = append(plans, m)
+	}
+	return plans
+}
+
+func planMigration(p string) Migration {
+	m := Migration{Path: p, NewPath: p, Changes: []string{}}
+	fail := func(err error) Migration {
+		m.Error = err.Error()
+		return m
+	}
+
+	data, err := Files.ReadFile(p)
+	if err != nil {
+		return fail(err)
+	}
+	spec, err := LoadSpec(p)
+	if err != nil {
+		return fail(err)
+	}
+	m.Kind = spec.Kind
+
+	i := strings.LastIndex(spec.Kind, "/")
+	if i < 0 {
+		return fail(fmt.Errorf("invalid kind %q", spec.Kind))
+	}
+	prefix, name := spec.Kind[:i], spec.Kind[i+1:]
+
+	if spec.Version != cdiSpecs.CurrentVersion {
+		m.Changes = append(m.Changes, fmt.Sprintf("cdiVersion %s -> %s", spec.Version, cdiSpecs.CurrentVersion))
+		spec.Version = cdiSpecs.CurrentVersion
+	}
+	if utils.ValidateCDIName(name) != nil {
+		newName := utils.SanitizeName(name)
+		if newName == "" {
+			return fail(fmt.Errorf("resource name %q cannot be made valid", name))
+		}
+		m.Kind = prefix + "/" + newName
+		m.Changes = append(m.Changes, fmt.Sprintf("kind %s -> %s", spec.Kind, m.Kind))
+		spec.Kind = m.Kind
+		name = newName
+	}
+
+	format := strings.TrimPrefix(filepath.Ext(p), ".")
+	if format == "yml" {
+		format = "yaml"
+	}
+	sortSpec(spec)
+	if err := validateSpec(spec); err != nil {
+		return fail(err)
+	}
+	if spec.Annotations[ChecksumAnnotation] != "" {
+		// Keep the checksum in step with the migrated content
+		if spec, err = signSpec(spec); err != nil {
+			return fail(err)
+		}
+	}
+	if m.data, err = marshalSpec(spec, format); err != nil {
+		return fail(err)
+	}
+	if !bytes.Equal(m.data, data) && len(m.Changes) == 0 {
+		m.Changes = append(m.Changes, "rewritten in canonical form")
+	}
+
+	if want := filepath.Join(filepath.Dir(p), SpecFileName(prefix, name, format)); want != p {
+		if _, err := Files.Stat(want); err == nil {
+			return fail(fmt.Errorf("would be renamed to %s, which already exists", want))
+		}
+		m.NewPath = want
+		m.Changes = append(m.Changes, "renamed to "+filepath.Base(want))
+	}
+	return m
+}
+
+// ApplyMigration writes a planned migration: the updated spec goes to
+// NewPath with the original file's mode, and a renamed original is removed.
+func ApplyMigration(m Migration) error {
+	if m.Error != "" {
+		return errors.New(m.Error)
+	}
+	if len(m.Changes) == 0 {
+		return nil
+	}
+	l, err := lockFiles(m.Path, m.NewPath)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+	info, err := Files.Stat(m.Path)
+	if err != nil {
+		return err
+	}
+	o := options{fileMode: info.Mode().Perm()}
+	if err := writeSpecFile(m.NewPath, m.data, &o); err != nil {
+		return fmt.Errorf("cannot write CDI spec file %s: %w", m.NewPath, err)
+	}
+	if m.Renamed() {
+		if err := Files.Remove(m.Path); err != nil {
+			return fmt.Errorf("migrated to %s but cannot remove %s: %w", m.NewPath, m.Path, err)
+		}
+	}
+	log.Infof("migrated CDI spec file %s: %s", m.NewPath, strings.Join(m.Changes, "; "))
+	return nil
+}
@@ -0,0 +1,100 @@
+package cdi
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
+)
+
+// oldSpec is laid out as an earlier release wrote it: an older CDI version,
+// devices out of order and a resource name starting with a digit.
+const oldSpec = `cdiVersion: "0.5.0"
+kind: rdma/0000-17-00-0
+devices:
+  - name: "0000:18:00.0"
+    containerEdits:
+      deviceNodes:
+        - path: /dev/infiniband/uverbs1
+  - name: "0000:17:00.0"
+    containerEdits:
+      deviceNodes:
+        - path: /dev/infiniband/uverbs0
+`
+
+func TestPlanMigrations(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "rdma-cdi_rdma_0000-17-00-0.yaml")
+	if err := os.WriteFile(old, []byte(oldSpec), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateCDISpec("rdma", "current", sampleDevices(), dir, "yaml"); err != nil {
+		t.Fatal(err)
+	}
+	current := filepath.Join(dir, "rdma-cdi_rdma_current.yaml")
+	os.WriteFile(filepath.Join(dir, "other-vendor.yaml"), []byte(oldSpec), 0644)
+
+	files, err := FindManagedSpecs([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("FindManagedSpecs = %v, want only this tool's two specs", files)
+	}
+
+	plans := PlanMigrations(files)
+	byPath := make(map[string]Migration)
+	for _, m := range plans {
+		byPath[m.Path] = m
+	}
+	if m := byPath[current]; len(m.Changes) != 0 || m.Renamed() || m.Error != "" {
+		t.Errorf("current spec should be up to date: %+v", m)
+	}
+	m := byPath[old]
+	want := filepath.Join(dir, "rdma-cdi_rdma_dev-0000-17-00-0.yaml")
+	if m.Error != "" || m.NewPath != want || m.Kind != "rdma/dev-0000-17-00-0" || len(m.Changes) != 3 {
+		t.Fatalf("unexpected plan: %+v", m)
+	}
+	if _, err := os.Stat(want); !os.IsNotExist(err) {
+		t.Error("planning must not write anything")
+	}
+
+	if err := ApplyMigration(m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("original file should be removed after a rename")
+	}
+	spec, err := LoadSpec(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Version != cdiSpecs.CurrentVersion || spec.Kind != m.Kind || spec.Devices[0].Name != "0000:17:00.0" {
+		t.Errorf("migrated spec not current: %+v", spec)
+	}
+	if info, _ := os.Stat(want); info.Mode().Perm() != 0640 {
+		t.Errorf("mode = %v, want original 0640", info.Mode().Perm())
+	}
+
+	// Migrating again is a no-op
+	if again := PlanMigrations([]string{want}); len(again[0].Changes) != 0 {
+		t.Errorf("second migration should find nothing to do: %+v", again[0])
+	}
+}
+
+func TestPlanMigrations_RenameConflict(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "rdma-cdi_rdma_0000-17-00-0.yaml")
+	os.WriteFile(old, []byte(oldSpec), 0644)
+	os.WriteFile(filepath.Join(dir, "rdma-cdi_rdma_dev-0000-17-00-0.yaml"), []byte("x"), 0644)
+
+	m := PlanMigrations([]string{old})[0]
+	if !strings.Contains(m.Error, "already exists") {
+		t.Errorf("expected rename conflict, got %+v", m)
+	}
+	if err := ApplyMigration(m); err == nil {
+		t.Error("applying a failed plan should return its error")
+	}
+}
@@ -0,0 +1,225 @@
+package cdi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// DefaultStateFile records the spec files written by generate.
+const DefaultStateFile = "/var/lib/rdma-cdi/state.json"
+
+// SpecRecord describes one spec file written by this tool.
+type SpecRecord struct {
+	// Path is the absolute path of the spec file.
+	Path string `json:"path"`
+	// Kind is the CDI kind (prefix/name) of the spec.
+	Kind string `json:"kind"`
+	// Devices lists the PCI addresses the spec was generated from.
+	Devices []string `json:"devices"`
+	// SHA256 is the hex checksum of the file as written.
+	SHA256 string `json:"sha256"`
+	// Checksum is the SpecChecksum of the spec as written, which unlike
+	// SHA256 ignores formatting. Empty in records of earlier releases.
+	Checksum string `json:"checksum,omitempty"`
+	// GeneratedAt is when the file was written.
+	GeneratedAt time.Time `json:"generatedAt"`
+	// Identities is the persistent identity of each device, which
+	// survives interface renames. Empty in records of earlier releases.
+	Identities []DeviceIdentity `json:"identities,omitempty"`
+}
+
+// DeviceIdentity identifies the hardware behind a spec device: its PCI
+// address and the node GUID of its RDMA device, which together change
+// when a card is swapped but not when udev renames its interfaces.
+type DeviceIdentity struct {
+	PciAddress string `json:"pciAddress"`
+	NodeGUID   string `json:"nodeGUID,omitempty"`
+}
+
+// IdentityOf returns the persistent identity of dev.
+func IdentityOf(dev *types.RdmaDevice) DeviceIdentity {
+	return DeviceIdentity{PciAddress: dev.PciAddress, NodeGUID: dev.NodeGUID}
+}
+
+// matches reports whether id and other are the same hardware. An unknown
+// GUID matches any, so records of earlier releases match on PCI address.
+func (id DeviceIdentity) matches(other DeviceIdentity) bool {
+	if id.PciAddress != other.PciAddress {
+		return false
+	}
+	return id.NodeGUID == "" || other.NodeGUID == "" || id.NodeGUID == other.NodeGUID
+}
+
+// State is the set of spec files this tool has written, keyed by path.
+// Records are kept sorted by path.
+type State struct {
+	Specs []SpecRecord `json:"specs"`
+}
+
+// LoadState reads the state file at path. A missing file yields an empty
+// state, since nothing has been generated yet.
+func LoadState(path string) (*State, error) {
+	if path == "" {
+		path = DefaultStateFile
+	}
+	data, err := Files.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &State{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read state file %s: %w", path, err)
+	}
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("cannot parse state file %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// Save writes the state to path atomically. Updates that may race with
+// another process go through UpdateState instead.
+func (s *State) Save(path string) error {
+	if path == "" {
+		path = DefaultStateFile
+	}
+	if err := Files.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("cannot create state directory: %w", err)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".state-%d.json", os.Getpid()))
+	if err := Files.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		Files.Remove(tmp)
+		return fmt.Errorf("cannot write state file %s: %w", path, err)
+	}
+	if err := Files.Rename(tmp, path); err != nil {
+		Files.Remove(tmp)
+		return fmt.Errorf("cannot write state file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Lookup returns the record for a spec file.
+func (s *State) Lookup(specPath string) (SpecRecord, bool) {
+	specPath = absPath(specPath)
+	i, ok := s.index(specPath)
+	if !ok {
+		return SpecRecord{}, false
+	}
+	return s.Specs[i], true
+}
+
+// Record checksums the spec file at specPath as it is now and stores it,
+// replacing any earlier record for the same path. ids are the identities
+// of devices, when known.
+func (s *State) Record(specPath, kind string, devices []string, ids ...DeviceIdentity) error {
+	sum, err := fileChecksum(specPath)
+	if err != nil {
+		return err
+	}
+	// A file that is not a spec can still be tracked by its bytes
+	content, _ := fileSpecChecksum(specPath)
+	rec := SpecRecord{
+		Path:        absPath(specPath),
+		Kind:        kind,
+		Devices:     slices.Clone(devices),
+		SHA256:      sum,
+		Checksum:    content,
+		GeneratedAt: Clock().UTC(),
+		Identities:  slices.Clone(ids),
+	}
+	if i, ok := s.index(rec.Path); ok {
+		s.Specs[i] = rec
+	} else {
+		s.Specs = slices.Insert(s.Specs, i, rec)
+	}
+	return nil
+}
+
+// Forget drops the record for a spec file and reports whether one existed.
+func (s *State) Forget(specPath string) bool {
+	i, ok := s.index(absPath(specPath))
+	if ok {
+		s.Specs = slices.Delete(s.Specs, i, i+1)
+	}
+	return ok
+}
+
+// SpecFor returns the record of the spec in dir generated from the single
+// device id alone, if its file still exists. It lets a spec named after an
+// interface be found again after the interface is renamed.
+func (s *State) SpecFor(dir string, id DeviceIdentity) (SpecRecord, bool) {
+	dir = absPath(dir)
+	for _, rec := range s.Specs {
+		if filepath.Dir(rec.Path) != dir || len(rec.Devices) != 1 || rec.Devices[0] != id.PciAddress {
+			continue
+		}
+		if len(rec.Identities) == 1 && !rec.Identities[0].matches(id) {
+			continue // another card in the same slot
+		}
+		if _, err := Files.Stat(rec.Path); err != nil {
+			continue
+		}
+		return rec, true
+	}
+	return SpecRecord{}, false
+}
+
+// Modified reports whether a recorded spec file no longer matches the
+// checksum taken when it was written. Files without a record, or that no
+// longer exist, are not considered modified.
+func (s *State) Modified(specPath string) (bool, error) {
+	rec, ok := s.Lookup(specPath)
+	if !ok {
+		return false, nil
+	}
+	sum, err := fileChecksum(specPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return sum != rec.SHA256, nil
+}
+
+// index returns the position of specPath in the sorted records, or where
+// it would be inserted.
+func (s *State) index(specPath string) (int, bool) {
+	return slices.BinarySearchFunc(s.Specs, specPath, func(r SpecRecord, p string) int {
+		return strings.Compare(r.Path, p)
+	})
+}
+
+func fileChecksum(path string) (string, error) {
+	data, err := Files.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return checksum(data), nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// absPath makes state keys independent of the working directory.
+func absPath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
@@ -0,0 +1,106 @@
+package cdi
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestState_RecordAndModified(t *testing.T) {
+	dir := t.TempDir()
+	spec := filepath.Join(dir, "rdma-cdi_rdma_a.yaml")
+	if err := os.WriteFile(spec, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	orig := Clock
+	defer func() { Clock = orig }()
+	Clock = func() time.Time { return time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC) }
+
+	state, err := LoadState(filepath.Join(dir, "missing.json"))
+	if err != nil || len(state.Specs) != 0 {
+		t.Fatalf("missing state file should load empty, got %v, %v", state, err)
+	}
+	if err := state.Record(spec, "rdma/a", []string{"0000:17:00.0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	statePath := filepath.Join(dir, "state", "state.json")
+	if err := state.Save(statePath); err != nil {
+		t.Fatal(err)
+	}
+	state, err = LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, ok := state.Lookup(spec)
+	if !ok || rec.Kind != "rdma/a" || rec.Devices[0] != "0000:17:00.0" || !rec.GeneratedAt.Equal(Clock()) {
+		t.Fatalf("unexpected record %+v (found=%v)", rec, ok)
+	}
+
+	if m, err := state.Modified(spec); err != nil || m {
+		t.Errorf("fresh spec reported modified (%v, %v)", m, err)
+	}
+	if err := os.WriteFile(spec, []byte("v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := state.Modified(spec); !m {
+		t.Error("edited spec should be reported modified")
+	}
+	if m, _ := state.Modified(filepath.Join(dir, "unknown.yaml")); m {
+		t.Error("unrecorded spec should not be reported modified")
+	}
+
+	// Re-recording replaces the entry instead of adding a second one
+	if err := state.Record(spec, "rdma/a", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Specs) != 1 {
+		t.Errorf("expected 1 record, got %d", len(state.Specs))
+	}
+	if !state.Forget(spec) || state.Forget(spec) {
+		t.Error("Forget should report whether a record existed")
+	}
+}
+
+func TestState_SpecFor(t *testing.T) {
+	dir := t.TempDir()
+	spec := filepath.Join(dir, "rdma-cdi_rdma_enp65s0np0.yaml")
+	if err := os.WriteFile(spec, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	state := &State{}
+	id := DeviceIdentity{PciAddress: "0000:41:00.0", NodeGUID: "0c42:a103:0065:4f3a"}
+	if err := state.Record(spec, "rdma/enp65s0np0", []string{id.PciAddress}, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec, ok := state.SpecFor(dir, id); !ok || rec.Path != spec {
+		t.Errorf("SpecFor(same identity) = %+v, %v", rec, ok)
+	}
+	// Bundles and older records carry no GUID; the PCI address decides
+	if _, ok := state.SpecFor(dir, DeviceIdentity{PciAddress: id.PciAddress}); !ok {
+		t.Error("an identity without GUID should match on the PCI address")
+	}
+	if _, ok := state.SpecFor(dir, DeviceIdentity{PciAddress: id.PciAddress, NodeGUID: "b859:9f03:00d4:1e20"}); ok {
+		t.Error("another card in the same slot should not match")
+	}
+	if _, ok := state.SpecFor(t.TempDir(), id); ok {
+		t.Error("a spec in another directory should not match")
+	}
+	os.Remove(spec)
+	if _, ok := state.SpecFor(dir, id); ok {
+		t.Error("a deleted spec should not match")
+	}
+}
+
+func TestLoadState_Corrupt(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(p, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadState(p); err == nil {
+		t.Error("expected error for corrupt state file")
+	}
+}
@@ -0,0 +1,266 @@
+package cdi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
+)
+
+// ErrSpecNotFound is returned by SpecStore.Get for a kind without a spec.
+var ErrSpecNotFound = errors.New("CDI spec not found")
+
+// SpecStore keeps CDI specs, one per kind: spec files in a directory, as
+// DirStore does, or elsewhere, e.g. in memory or a Kubernetes ConfigMap,
+// when specs are generated away from the nodes using them.
+type SpecStore interface {
+	// Get returns the spec of kind, or ErrSpecNotFound.
+	Get(ctx context.Context, kind string) (*cdiSpecs.Spec, error)
+	// Put creates or replaces the spec of spec.Kind.
+	Put(ctx context.Context, spec *cdiSpecs.Spec) error
+	// Delete removes the spec of kind. A missing spec is not an error.
+	Delete(ctx context.Context, kind string) error
+	// List returns the kinds of the stored specs, sorted.
+	List(ctx context.Context) ([]string, error)
+}
+
+// DirStore is the SpecStore of a spec directory, writing spec files the
+// way WriteSpec does. generate, bind and cleanup write and remove their
+// spec files through it: generate writes a batch of specs with Write,
+// after its own collision and foreign-kind checks, and records them with
+// a single Record.
+type DirStore struct {
+	// Dir defaults to DefaultOutputDir.
+	Dir string
+	// Format is "yaml" (the default) or "json".
+	Format string
+	// StateFile, when set, records the written specs there, as generate
+	// does, so cleanup and guard know about them.
+	StateFile string
+	// Options apply to the written files, e.g. WithFileMode.
+	Options []Option
+}
+
+func (s *DirStore) dir() string {
+	if s.Dir == "" {
+		return DefaultOutputDir
+	}
+	return s.Dir
+}
+
+func (s *DirStore) format() string {
+	if s.Format == "" {
+		return "yaml"
+	}
+	return s.Format
+}
+
+// path returns the spec file of kind.
+func (s *DirStore) path(kind string) (string, error) {
+	name, err := KindFileName(kind, s.format())
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir(), name), nil
+}
+
+// Get reads the spec file of kind.
+func (s *DirStore) Get(_ context.Context, kind string) (*cdiSpecs.Spec, error) {
+	path, err := s.path(kind)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := LoadSpec(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrSpecNotFound, kind)
+	}
+	return spec, err
+}
+
+// Put writes the spec file of spec.Kind and records it in the state file
+// with the PCI addresses of its devices. As with generate, failing to
+// record it only warns.
+func (s *DirStore) Put(_ context.Context, spec *cdiSpecs.Spec) error {
+	path, err := s.Write(spec)
+	if err != nil {
+		return err
+	}
+	s.Record(StoredSpec{Path: path, Kind: spec.Kind, Devices: specAddresses(spec)})
+	return nil
+}
+
+// Write writes the spec file of spec.Kind without recording it and
+// returns its path.
+func (s *DirStore) Write(spec *cdiSpecs.Spec) (string, error) {
+	path, err := s.path(spec.Kind)
+	if err != nil {
+		return "", err
+	}
+	if err := WriteSpec(spec, s.dir(), s.format(), s.Options...); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// StoredSpec is a spec file written by Write, as Record records it.
+type StoredSpec struct {
+	Path string
+	Kind string
+	// Devices are the PCI addresses of the devices of the spec.
+	Devices    []string
+	Identities []DeviceIdentity
+}
+
+// Record adds specs to the state file, if any, in a single update.
+// Failing to record a spec only costs cleanup precision, so it only warns.
+func (s *DirStore) Record(specs ...StoredSpec) {
+	if s.StateFile == "" || len(specs) == 0 {
+		return
+	}
+	err := UpdateState(s.StateFile, func(state *State) error {
+		for _, spec := range specs {
+			if err := state.Record(spec.Path, spec.Kind, spec.Devices, spec.Identities...); err != nil {
+				log.Warnf("not recording %s: %v", spec.Path, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warnf("not recording generated specs: %v", err)
+	}
+}
+
+// Delete removes the spec file of kind and its state record.
+func (s *DirStore) Delete(_ context.Context, kind string) error {
+	path, err := s.path(kind)
+	if err != nil {
+		return err
+	}
+	return s.Remove(path)
+}
+
+// Remove removes the spec file at path, which must be in the directory,
+// and its state record. A missing file is not an error.
+func (s *DirStore) Remove(path string) error {
+	if filepath.Dir(absPath(path)) != absPath(s.dir()) {
+		return fmt.Errorf("%s is not in %s", path, s.dir())
+	}
+	if _, err := RemoveSpecs([]string{path}, false); err != nil {
+		return err
+	}
+	if s.StateFile == "" {
+		return nil
+	}
+	err := UpdateState(s.StateFile, func(state *State) error {
+		state.Forget(path)
+		return nil
+	})
+	if err != nil {
+		log.Warnf("cannot update state file: %v", err)
+	}
+	return nil
+}
+
+// specAddresses returns the PCI addresses of the devices of spec, as
+// ReadSpec would read them back.
+func specAddresses(spec *cdiSpecs.Spec) []string {
+	var pcis []string
+	for _, d := range spec.Devices {
+		if d.Annotations[AliasAnnotation] != "" || d.Name == AllDeviceName {
+			continue
+		}
+		if pci := deviceAddress(d); !slices.Contains(pcis, pci) {
+			pcis = append(pcis, pci)
+		}
+	}
+	return pcis
+}
+
+// List returns the kinds of the spec files in the directory that follow
+// this tool's naming scheme. Files that do not parse are skipped.
+func (s *DirStore) List(_ context.Context) ([]string, error) {
+	paths, err := ListAllSpecs(s.dir())
+	if err != nil {
+		return nil, err
+	}
+	var kinds []string
+	for _, p := range paths {
+		if !IsManaged(p) || strings.TrimPrefix(filepath.Ext(p), ".") != s.format() {
+			continue
+		}
+		spec, err := LoadSpec(p)
+		if err != nil {
+			log.Debugf("skipping %s: %v", p, err)
+			continue
+		}
+		if !slices.Contains(kinds, spec.Kind) {
+			kinds = append(kinds, spec.Kind)
+		}
+	}
+	slices.Sort(kinds)
+	return kinds, nil
+}
+
+// MemStore is a SpecStore keeping specs in memory, e.g. to render the
+// specs of a fleet centrally or to test code using a SpecStore.
+type MemStore struct {
+	mu    sync.Mutex
+	specs map[string][]byte
+}
+
+// NewMemStore returns an empty MemStore.
+func NewMemStore() *MemStore {
+	return &MemStore{specs: make(map[string][]byte)}
+}
+
+// Get returns a copy of the spec of kind.
+func (s *MemStore) Get(_ context.Context, kind string) (*cdiSpecs.Spec, error) {
+	s.mu.Lock()
+	data, ok := s.specs[kind]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSpecNotFound, kind)
+	}
+	var spec cdiSpecs.Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// Put validates spec and stores a copy of it, with its ChecksumAnnotation
+// as WriteSpec would write it.
+func (s *MemStore) Put(_ context.Context, spec *cdiSpecs.Spec) error {
+	data, err := MarshalSpec(spec, "json")
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.specs[spec.Kind] = data
+	return nil
+}
+
+// Delete drops the spec of kind.
+func (s *MemStore) Delete(_ context.Context, kind string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.specs, kind)
+	return nil
+}
+
+// List returns the kinds of the stored specs.
+func (s *MemStore) List(_ context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Sorted(maps.Keys(s.specs)), nil
+}
@@ -0,0 +1,115 @@
+package cdi
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
+)
+
+// testStore puts, gets, lists and deletes a spec in store.
+func testStore(t *testing.T, store SpecStore) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := store.Get(ctx, "rdma/test-dev"); !errors.Is(err, ErrSpecNotFound) {
+		t.Errorf("Get() of a missing spec = %v, want ErrSpecNotFound", err)
+	}
+	spec, err := BuildSpec("rdma", "test-dev", sampleDevices())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, spec); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, &cdiSpecs.Spec{Kind: "rdma/empty"}); err == nil {
+		t.Error("Put() of an invalid spec should fail")
+	}
+	got, err := store.Get(ctx, spec.Kind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := SpecChecksum(spec); got.Annotations[ChecksumAnnotation] != want {
+		t.Errorf("Get() = %+v, want the spec put, signed", got)
+	}
+	if kinds, err := store.List(ctx); err != nil || !slices.Equal(kinds, []string{"rdma/test-dev"}) {
+		t.Errorf("List() = %v, %v", kinds, err)
+	}
+
+	if err := store.Delete(ctx, spec.Kind); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, spec.Kind); err != nil {
+		t.Errorf("deleting a missing spec = %v, want nil", err)
+	}
+	if kinds, err := store.List(ctx); err != nil || len(kinds) != 0 {
+		t.Errorf("List() after Delete() = %v, %v", kinds, err)
+	}
+}
+
+func TestDirStore(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	testStore(t, &DirStore{Dir: dir, Format: "json", StateFile: statePath})
+
+	// The state file followed the spec in and out
+	state, err := LoadState(statePath)
+	if err != nil || len(state.Specs) != 0 {
+		t.Errorf("state after Delete() = %+v, %v", state, err)
+	}
+	store := &DirStore{Dir: dir, StateFile: statePath}
+	spec, _ := BuildSpec("rdma", "test-dev", sampleDevices())
+	if err := store.Put(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+	state, _ = LoadState(statePath)
+	rec, ok := state.Lookup(filepath.Join(dir, "rdma-cdi_rdma_test-dev.yaml"))
+	if !ok || rec.Kind != "rdma/test-dev" || !slices.Equal(rec.Devices, []string{"0000:17:00.0"}) {
+		t.Errorf("state record = %+v, %v", rec, ok)
+	}
+
+	// Remove only takes files of the directory
+	if err := store.Remove(filepath.Join(t.TempDir(), "rdma-cdi_rdma_test-dev.yaml")); err == nil {
+		t.Error("Remove() of a file elsewhere succeeded, want an error")
+	}
+	if err := store.Remove(rec.Path); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ = LoadState(statePath); len(state.Specs) != 0 {
+		t.Errorf("state after Remove() = %+v", state)
+	}
+}
+
+func TestDirStore_WriteAndRecord(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	store := &DirStore{Dir: dir, StateFile: statePath}
+
+	var specs []StoredSpec
+	for _, name := range []string{"a", "b"} {
+		spec, _ := BuildSpec("rdma", name, sampleDevices())
+		path, err := store.Write(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.Join(dir, "rdma-cdi_rdma_"+name+".yaml"); path != want {
+			t.Errorf("Write() = %s, want %s", path, want)
+		}
+		specs = append(specs, StoredSpec{Path: path, Kind: spec.Kind, Devices: []string{"0000:17:00.0"}})
+	}
+	// Nothing is recorded until Record
+	if state, _ := LoadState(statePath); len(state.Specs) != 0 {
+		t.Errorf("state after Write() = %+v", state)
+	}
+	store.Record(specs...)
+	state, _ := LoadState(statePath)
+	if len(state.Specs) != 2 {
+		t.Errorf("state after Record() = %+v, want both specs", state)
+	}
+}
+
+func TestMemStore(t *testing.T) {
+	testStore(t, NewMemStore())
+}
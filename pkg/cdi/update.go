@@ -0,0 +1,190 @@
+package cdi
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// UpdateSpec patches the spec file at specPath in place instead of
+// regenerating it. Devices named in removeDeviceNames are dropped together
+// with their aliases and per-port entries; addDevices are added, replacing any device of the
+// same name and its aliases (pass WithAliases to recreate them).
+// Everything else in the file is kept as is, including fields this tool
+// does not know about and edits or annotations added by hand or by other
+// producers. An existing "all" device is rebuilt from the resulting
+// devices. opts apply to the added devices as in CreateCDISpec;
+// the file keeps its format and, unless WithFileMode is given, its mode.
+// Names in removeDeviceNames that are not in the spec are ignored.
+func UpdateSpec(specPath string, addDevices []types.RdmaDevice, removeDeviceNames []string, opts ...Option) error {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	l, err := lockFiles(specPath)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+	data, err := Files.ReadFile(specPath)
+	if err != nil {
+		return err
+	}
+	// Work on the generic document so unknown fields survive the rewrite
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return fmt.Errorf("cannot parse CDI spec %s: %w", specPath, err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(jsonData, &doc); err != nil {
+		return fmt.Errorf("cannot parse CDI spec %s: %w", specPath, err)
+	}
+	rawDevices, _ := doc["devices"].([]any)
+
+	added := make([]cdiSpecs.Device, 0, len(addDevices))
+	extras := make([]*cdiSpecs.ContainerEdits, 0, len(addDevices))
+	for _, dev := range addDevices {
+		if err := validateDevice(dev); err != nil {
+			return err
+		}
+		extra, err := pluginEdits(dev, o.plugins)
+		if err != nil {
+			return err
+		}
+		d := buildDevice(dev, &o)
+		appendEdits(&d, extra)
+		sortEdits(&d.ContainerEdits)
+		added = append(added, d)
+		extras = append(extras, extra)
+	}
+	combined := added
+	if o.perPort {
+		added = portDevices(addDevices, added, extras, &o)
+		for i := range added {
+			sortEdits(&added[i].ContainerEdits)
+		}
+	}
+
+	drop := make(map[string]bool)
+	for _, name := range removeDeviceNames {
+		drop[name] = true
+	}
+	for _, d := range added {
+		drop[d.Name] = true
+		drop[deviceAddress(d)] = true
+	}
+
+	var kept []any
+	var primaries []cdiSpecs.Device
+	hadAll := false
+	for _, raw := range rawDevices {
+		var d cdiSpecs.Device
+		if err := remarshal(raw, &d); err != nil {
+			return fmt.Errorf("cannot parse CDI spec %s: %w", specPath, err)
+		}
+		switch {
+		case d.Name == AllDeviceName:
+			hadAll = true
+			continue
+		case drop[d.Name], drop[deviceAddress(d)], drop[d.Annotations[AliasAnnotation]]:
+			continue
+		}
+		kept = append(kept, raw)
+		if d.Annotations[AliasAnnotation] == "" {
+			primaries = append(primaries, d)
+		}
+	}
+
+	newDevices := slices.Clone(added)
+	if o.aliases {
+		used := make(map[string]bool, len(kept))
+		for _, raw := range kept {
+			used[rawName(raw)] = true
+		}
+		for _, alias := range aliasDevices(addDevices, combined) {
+			if used[alias.Name] {
+				log.Warnf("not adding alias %q for %s: name already used in the spec", alias.Name, alias.Annotations[AliasAnnotation])
+				continue
+			}
+			newDevices = append(newDevices, alias)
+		}
+	}
+	primaries = append(primaries, added...)
+	if hadAll && len(primaries) > 0 {
+		newDevices = append(newDevices, mergeDevices(AllDeviceName, primaries))
+	}
+	for _, d := range newDevices {
+		var raw any
+		if err := remarshal(d, &raw); err != nil {
+			return err
+		}
+		kept = append(kept, raw)
+	}
+
+	slices.SortStableFunc(kept, func(a, b any) int {
+		return strings.Compare(rawName(a), rawName(b))
+	})
+	doc["devices"] = kept
+
+	// Check the result still parses as a valid spec before writing it
+	var spec cdiSpecs.Spec
+	if err := remarshal(doc, &spec); err != nil {
+		return fmt.Errorf("updated CDI spec is invalid: %w", err)
+	}
+	if err := validateSpec(&spec); err != nil {
+		return fmt.Errorf("updated CDI spec is invalid: %w", err)
+	}
+	if annotations, ok := doc["annotations"].(map[string]any); ok && annotations[ChecksumAnnotation] != nil {
+		// Keep the checksum in step with the patched content
+		sum, err := SpecChecksum(&spec)
+		if err != nil {
+			return err
+		}
+		annotations[ChecksumAnnotation] = sum
+	}
+
+	format := strings.TrimPrefix(filepath.Ext(specPath), ".")
+	if format == "yml" {
+		format = "yaml"
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err == nil && format == "yaml" {
+		out, err = yaml.JSONToYAML(out)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot marshal CDI spec: %w", err)
+	}
+	if err := writeSpecFile(specPath, out, &o); err != nil {
+		return fmt.Errorf("cannot write CDI spec file %s: %w", specPath, err)
+	}
+	log.Debugf("CDI spec %s updated: %d added, %d removal(s) requested", specPath, len(addDevices), len(removeDeviceNames))
+	return nil
+}
+
+// remarshal converts between a typed value and its generic JSON form.
+func remarshal(in, out any) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// rawName returns the name of a device in generic form.
+func rawName(raw any) string {
+	if m, ok := raw.(map[string]any); ok {
+		if name, ok := m["name"].(string); ok {
+			return name
+		}
+	}
+	return ""
+}
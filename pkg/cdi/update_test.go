@@ -0,0 +1,132 @@
+package cdi
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+func updateDevice(pci, ifname, uverbs string) types.RdmaDevice {
+	return types.RdmaDevice{
+		PciAddress: pci,
+		IfName:     ifname,
+		DeviceSpecs: []types.DeviceSpec{
+			{HostPath: "/dev/infiniband/" + uverbs, ContainerPath: "/dev/infiniband/" + uverbs, Permissions: "rw"},
+		},
+	}
+}
+
+func deviceNames(t *testing.T, path string) []string {
+	t.Helper()
+	spec, err := LoadSpec(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, d := range spec.Devices {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
+func TestUpdateSpec(t *testing.T) {
+	for _, format := range []string{"yaml", "json"} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			devs := []types.RdmaDevice{updateDevice("0000:17:00.0", "ib0", "uverbs0"), updateDevice("0000:18:00.0", "ib1", "uverbs1")}
+			if err := CreateCDISpec("rdma", "multi", devs, dir, format, WithAliases(), WithAllDevice()); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, SpecFileName("rdma", "multi", format))
+
+			// Another producer adds a field and an annotation this tool does not know about
+			data, _ := os.ReadFile(path)
+			var edited string
+			if format == "json" {
+				edited = strings.Replace(string(data), "\"annotations\": {", "\"x-extra\": 42,\n  \"annotations\": {\"other/owner\": \"ops\",", 1)
+			} else {
+				edited = "x-extra: 42\n" + strings.Replace(string(data), "annotations:\n", "annotations:\n  other/owner: ops\n", 1)
+			}
+			if err := os.WriteFile(path, []byte(edited), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(path, 0640); err != nil {
+				t.Fatal(err)
+			}
+
+			add := []types.RdmaDevice{updateDevice("0000:41:00.0", "ib2", "uverbs2")}
+			if err := UpdateSpec(path, add, []string{"0000:18:00.0", "0000:99:00.0"}, WithAliases()); err != nil {
+				t.Fatal(err)
+			}
+
+			want := []string{"0000:17:00.0", "0000:41:00.0", "all", "ib0", "ib2"}
+			if got := deviceNames(t, path); !slices.Equal(got, want) {
+				t.Errorf("devices = %v, want %v", got, want)
+			}
+
+			spec, err := LoadSpec(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if spec.Annotations["other/owner"] != "ops" {
+				t.Errorf("foreign annotation lost: %v", spec.Annotations)
+			}
+			// The checksum now covers the foreign edit too
+			if res, err := VerifySpec(path, nil); err != nil || res.Status != IntegrityIntact {
+				t.Errorf("VerifySpec() = %+v, %v; want the checksum updated", res, err)
+			}
+			out, _ := os.ReadFile(path)
+			if !strings.Contains(string(out), "x-extra") {
+				t.Errorf("unknown field lost:\n%s", out)
+			}
+			for _, d := range spec.Devices {
+				if d.Name != AllDeviceName {
+					continue
+				}
+				var paths []string
+				for _, n := range d.ContainerEdits.DeviceNodes {
+					paths = append(paths, n.Path)
+				}
+				if wantPaths := []string{"/dev/infiniband/uverbs0", "/dev/infiniband/uverbs2"}; !slices.Equal(paths, wantPaths) {
+					t.Errorf("all device nodes = %v, want %v", paths, wantPaths)
+				}
+			}
+			if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+				t.Errorf("file mode not preserved: %v, %v", info.Mode(), err)
+			}
+		})
+	}
+}
+
+func TestUpdateSpec_Replace(t *testing.T) {
+	dir := t.TempDir()
+	if err := CreateCDISpec("rdma", "one", []types.RdmaDevice{updateDevice("0000:17:00.0", "ib0", "uverbs0")}, dir, "yaml", WithAliases()); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, SpecFileName("rdma", "one", "yaml"))
+
+	if err := UpdateSpec(path, []types.RdmaDevice{updateDevice("0000:17:00.0", "ib0", "uverbs5")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	devices, _, err := ReadSpec(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].DeviceSpecs[0].HostPath != "/dev/infiniband/uverbs5" {
+		t.Errorf("device not replaced: %+v", devices)
+	}
+	if got := deviceNames(t, path); !slices.Equal(got, []string{"0000:17:00.0"}) {
+		t.Errorf("stale alias kept: %v", got)
+	}
+
+	if err := UpdateSpec(path, nil, []string{"0000:17:00.0"}); err == nil {
+		t.Error("expected an error when removing the last device")
+	}
+	if got := deviceNames(t, path); len(got) != 1 {
+		t.Errorf("failed update must leave the file untouched, devices = %v", got)
+	}
+}
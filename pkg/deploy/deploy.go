@@ -0,0 +1,412 @@
+// Package deploy renders the Kubernetes manifests that run rdma-cdi, or a
+// DRA driver or device plugin embedding it, on every node of a cluster.
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/Nativu5/rdma-cdi/pkg/export"
+	"github.com/Nativu5/rdma-cdi/pkg/kubeevents"
+)
+
+// Mode selects what the DaemonSet runs.
+type Mode string
+
+const (
+	// ModeWatch generates the specs of every device at start in an init
+	// container, then guards them for as long as the pod runs.
+	ModeWatch Mode = "watch"
+	// ModeDRA runs a DRA driver image embedding rdma-cdi, registered with
+	// the kubelet through the plugin watcher.
+	ModeDRA Mode = "dra"
+	// ModeDevicePlugin runs a device plugin image embedding rdma-cdi.
+	ModeDevicePlugin Mode = "device-plugin"
+)
+
+// Defaults for Options.
+const (
+	DefaultName      = "rdma-cdi"
+	DefaultNamespace = "kube-system"
+	DefaultHostRoot  = "/host"
+)
+
+// Kubelet directories the plugin modes use.
+const (
+	pluginsRegistryDir = "/var/lib/kubelet/plugins_registry"
+	pluginsDir         = "/var/lib/kubelet/plugins"
+	devicePluginsDir   = "/var/lib/kubelet/device-plugins"
+)
+
+// ErrInvalidOptions is returned when a manifest cannot be rendered.
+var ErrInvalidOptions = errors.New("invalid deploy options")
+
+// ParseMode parses a --mode value.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case ModeWatch, ModeDRA, ModeDevicePlugin:
+		return m, nil
+	}
+	return "", fmt.Errorf("%w: unsupported mode %q: use %s, %s or %s", ErrInvalidOptions, s, ModeWatch, ModeDRA, ModeDevicePlugin)
+}
+
+// Options parameterize a manifest.
+type Options struct {
+	Mode Mode
+	// Image is the container image to run. Required.
+	Image string
+	// Name and Namespace of the DaemonSet; DefaultName and
+	// DefaultNamespace when empty.
+	Name      string
+	Namespace string
+	// HostRoot is where the host's / is mounted read-only in the pod;
+	// DefaultHostRoot when empty. rdma-cdi is run with --host-root set to
+	// it.
+	HostRoot string
+	// GlobalArgs are global rdma-cdi flags for every command, e.g.
+	// "--require-devices=uverbs".
+	GlobalArgs []string
+	// GenerateArgs are extra flags of generate in ModeWatch, e.g.
+	// "--aliases".
+	GenerateArgs []string
+	// SpecDirs are the host CDI spec directories mounted read-write, and
+	// guarded in ModeWatch.
+	SpecDirs []string
+	// StateFile is the state file generate and guard share, kept on the
+	// host so it survives the pod.
+	StateFile string
+	// LockDir is where instances lock the spec directories, shared with
+	// the host so a guard run there conflicts with the pod's.
+	LockDir string
+	// PluginName is the DRA driver name (ModeDRA) or the device plugin's
+	// resource name (ModeDevicePlugin).
+	PluginName string
+	// NodeSelector restricts the nodes the DaemonSet runs on.
+	NodeSelector map[string]string
+	// Events runs the pod under a service account allowed to report
+	// Kubernetes Events and node conditions, rendered along with its
+	// RBAC rules. In ModeWatch, guard reports them, running doctor every
+	// DoctorInterval.
+	Events bool
+}
+
+// DoctorInterval is how often guard runs doctor with Options.Events.
+const DoctorInterval = "5m"
+
+// Manifest renders the DaemonSet for o as YAML.
+func Manifest(o Options) ([]byte, error) {
+	if o.Image == "" {
+		return nil, fmt.Errorf("%w: no image", ErrInvalidOptions)
+	}
+	if _, err := ParseMode(string(o.Mode)); err != nil {
+		return nil, err
+	}
+	if o.Mode != ModeWatch && o.PluginName == "" {
+		return nil, fmt.Errorf("%w: mode %s needs a plugin name", ErrInvalidOptions, o.Mode)
+	}
+	if o.Name == "" {
+		o.Name = DefaultName
+	}
+	if o.Namespace == "" {
+		o.Namespace = DefaultNamespace
+	}
+	if o.HostRoot == "" {
+		o.HostRoot = DefaultHostRoot
+	}
+
+	m := &mounts{}
+	m.add("host-root", "/", o.HostRoot, true)
+	for _, dir := range o.SpecDirs {
+		m.add("", dir, dir, false)
+	}
+	if o.StateFile != "" {
+		m.add("", path.Dir(o.StateFile), path.Dir(o.StateFile), false)
+	}
+	if o.LockDir != "" {
+		m.add("", o.LockDir, o.LockDir, false)
+	}
+
+	args := slices.Concat([]string{"--host-root=" + o.HostRoot}, o.GlobalArgs)
+	if o.StateFile != "" {
+		args = append(args, "--state-file="+o.StateFile)
+	}
+	labels := map[string]string{"app.kubernetes.io/name": o.Name, "app.kubernetes.io/component": string(o.Mode)}
+	pod := podSpec{
+		// Netlink queries (link types, addresses, netns mode) need the
+		// host's network namespace
+		HostNetwork:       true,
+		DNSPolicy:         "ClusterFirstWithHostNet",
+		PriorityClassName: "system-node-critical",
+		NodeSelector:      o.NodeSelector,
+		Tolerations:       []toleration{{Operator: "Exists"}},
+	}
+	privileged := &securityContext{Privileged: true}
+	nodeName := envVar{Name: "NODE_NAME", ValueFrom: &envSource{FieldRef: &fieldRef{FieldPath: "spec.nodeName"}}}
+	if o.Events {
+		pod.ServiceAccountName = o.Name
+	}
+
+	switch o.Mode {
+	case ModeWatch:
+		guardArgs := append(slices.Clone(args), "guard")
+		for _, dir := range o.SpecDirs {
+			guardArgs = append(guardArgs, "--output-dir="+dir)
+		}
+		var guardEnv []envVar
+		if o.Events {
+			guardArgs = append(guardArgs, "--k8s-events", "--node-condition="+kubeevents.DefaultCondition, "--doctor-interval="+DoctorInterval)
+			guardEnv = []envVar{nodeName}
+		}
+		pod.InitContainers = []container{{
+			Name:            "generate",
+			Image:           o.Image,
+			Command:         []string{"rdma-cdi"},
+			Args:            slices.Concat(args, []string{"generate", "--all"}, specDirArgs(o.SpecDirs), o.GenerateArgs),
+			SecurityContext: privileged,
+			VolumeMounts:    m.volumeMounts,
+		}}
+		pod.Containers = []container{{
+			Name:            "guard",
+			Image:           o.Image,
+			Command:         []string{"rdma-cdi"},
+			Args:            guardArgs,
+			Env:             guardEnv,
+			SecurityContext: privileged,
+			VolumeMounts:    m.volumeMounts,
+		}}
+	case ModeDRA, ModeDevicePlugin:
+		m.add("plugins-registry", pluginsRegistryDir, pluginsRegistryDir, false)
+		if o.Mode == ModeDRA {
+			dir := path.Join(pluginsDir, o.PluginName)
+			m.add("plugin", dir, dir, false)
+		} else {
+			m.add("device-plugins", devicePluginsDir, devicePluginsDir, false)
+		}
+		// The image's own entrypoint; rdma-cdi's flags are passed on
+		pod.Containers = []container{{
+			Name:            string(o.Mode),
+			Image:           o.Image,
+			Args:            args,
+			Env:             []envVar{{Name: "PLUGIN_NAME", Value: o.PluginName}, nodeName},
+			SecurityContext: privileged,
+			VolumeMounts:    m.volumeMounts,
+		}}
+	}
+	pod.Volumes = m.volumes
+
+	ds := daemonSet{
+		APIVersion: "apps/v1",
+		Kind:       "DaemonSet",
+		Metadata:   objectMeta{Name: o.Name, Namespace: o.Namespace, Labels: labels},
+		Spec: daemonSetSpec{
+			Selector:       labelSelector{MatchLabels: labels},
+			UpdateStrategy: updateStrategy{Type: "RollingUpdate"},
+			Template: podTemplate{
+				Metadata: objectMeta{Labels: labels},
+				Spec:     pod,
+			},
+		},
+	}
+	objects := []any{ds}
+	if o.Events {
+		objects = append(objects, eventsRBAC(o.Name, o.Namespace)...)
+	}
+	data, err := export.MarshalYAMLDocuments(objects)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(fmt.Sprintf("# Generated by rdma-cdi deploy manifest --mode %s.\n", o.Mode)), data...), nil
+}
+
+// eventsRBAC returns the service account of the pod and the rules letting
+// it create node events and patch node conditions.
+func eventsRBAC(name, namespace string) []any {
+	return []any{
+		serviceAccount{APIVersion: "v1", Kind: "ServiceAccount", Metadata: objectMeta{Name: name, Namespace: namespace}},
+		clusterRole{
+			APIVersion: "rbac.authorization.k8s.io/v1",
+			Kind:       "ClusterRole",
+			Metadata:   objectMeta{Name: name},
+			Rules: []policyRule{
+				{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create", "patch"}},
+				{APIGroups: []string{""}, Resources: []string{"nodes/status"}, Verbs: []string{"patch"}},
+			},
+		},
+		clusterRoleBinding{
+			APIVersion: "rbac.authorization.k8s.io/v1",
+			Kind:       "ClusterRoleBinding",
+			Metadata:   objectMeta{Name: name},
+			RoleRef:    roleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: name},
+			Subjects:   []subject{{Kind: "ServiceAccount", Name: name, Namespace: namespace}},
+		},
+	}
+}
+
+// specDirArgs points generate at the first spec directory, where the
+// kubelet's runtimes look first.
+func specDirArgs(dirs []string) []string {
+	if len(dirs) == 0 {
+		return nil
+	}
+	return []string{"--output-dir=" + dirs[0]}
+}
+
+// mounts collects hostPath volumes and their mounts, once per host path.
+type mounts struct {
+	volumes      []volume
+	volumeMounts []volumeMount
+}
+
+// add mounts hostPath at mountPath. Volumes without a name are named
+// after their path, e.g. "etc-cdi".
+func (m *mounts) add(name, hostPath, mountPath string, readOnly bool) {
+	if slices.ContainsFunc(m.volumes, func(v volume) bool { return v.HostPath.Path == hostPath }) {
+		return
+	}
+	if name == "" {
+		name = strings.ReplaceAll(strings.Trim(hostPath, "/"), "/", "-")
+		name = strings.ReplaceAll(strings.ReplaceAll(name, "_", "-"), ".", "-")
+	}
+	typ := "DirectoryOrCreate"
+	if readOnly {
+		typ = "Directory"
+	}
+	m.volumes = append(m.volumes, volume{Name: name, HostPath: hostPathSource{Path: hostPath, Type: typ}})
+	m.volumeMounts = append(m.volumeMounts, volumeMount{Name: name, MountPath: mountPath, ReadOnly: readOnly})
+}
+
+// The subset of the Kubernetes API types a DaemonSet manifest needs,
+// avoiding a dependency on k8s.io/api.
+type daemonSet struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   objectMeta    `json:"metadata"`
+	Spec       daemonSetSpec `json:"spec"`
+}
+
+type objectMeta struct {
+	Name      string            `json:"name,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+type daemonSetSpec struct {
+	Selector       labelSelector  `json:"selector"`
+	UpdateStrategy updateStrategy `json:"updateStrategy"`
+	Template       podTemplate    `json:"template"`
+}
+
+type labelSelector struct {
+	MatchLabels map[string]string `json:"matchLabels"`
+}
+
+type updateStrategy struct {
+	Type string `json:"type"`
+}
+
+type podTemplate struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     podSpec    `json:"spec"`
+}
+
+type podSpec struct {
+	ServiceAccountName string            `json:"serviceAccountName,omitempty"`
+	HostNetwork        bool              `json:"hostNetwork,omitempty"`
+	DNSPolicy          string            `json:"dnsPolicy,omitempty"`
+	PriorityClassName  string            `json:"priorityClassName,omitempty"`
+	NodeSelector       map[string]string `json:"nodeSelector,omitempty"`
+	Tolerations        []toleration      `json:"tolerations,omitempty"`
+	InitContainers     []container       `json:"initContainers,omitempty"`
+	Containers         []container       `json:"containers"`
+	Volumes            []volume          `json:"volumes,omitempty"`
+}
+
+type toleration struct {
+	Operator string `json:"operator"`
+}
+
+type container struct {
+	Name            string           `json:"name"`
+	Image           string           `json:"image"`
+	Command         []string         `json:"command,omitempty"`
+	Args            []string         `json:"args,omitempty"`
+	Env             []envVar         `json:"env,omitempty"`
+	SecurityContext *securityContext `json:"securityContext,omitempty"`
+	VolumeMounts    []volumeMount    `json:"volumeMounts,omitempty"`
+}
+
+type envVar struct {
+	Name      string     `json:"name"`
+	Value     string     `json:"value,omitempty"`
+	ValueFrom *envSource `json:"valueFrom,omitempty"`
+}
+
+type envSource struct {
+	FieldRef *fieldRef `json:"fieldRef,omitempty"`
+}
+
+type fieldRef struct {
+	FieldPath string `json:"fieldPath"`
+}
+
+type securityContext struct {
+	Privileged bool `json:"privileged"`
+}
+
+type volumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+}
+
+type volume struct {
+	Name     string         `json:"name"`
+	HostPath hostPathSource `json:"hostPath"`
+}
+
+type hostPathSource struct {
+	Path string `json:"path"`
+	Type string `json:"type,omitempty"`
+}
+
+type serviceAccount struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+}
+
+type clusterRole struct {
+	APIVersion string       `json:"apiVersion"`
+	Kind       string       `json:"kind"`
+	Metadata   objectMeta   `json:"metadata"`
+	Rules      []policyRule `json:"rules"`
+}
+
+type policyRule struct {
+	APIGroups []string `json:"apiGroups"`
+	Resources []string `json:"resources"`
+	Verbs     []string `json:"verbs"`
+}
+
+type clusterRoleBinding struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	RoleRef    roleRef    `json:"roleRef"`
+	Subjects   []subject  `json:"subjects"`
+}
+
+type roleRef struct {
+	APIGroup string `json:"apiGroup"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+}
+
+type subject struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
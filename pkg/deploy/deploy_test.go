@@ -0,0 +1,183 @@
+package deploy
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func render(t *testing.T, o Options) daemonSet {
+	t.Helper()
+	out, err := Manifest(o)
+	if err != nil {
+		t.Fatalf("Manifest() error = %v", err)
+	}
+	if !strings.HasPrefix(string(out), "# Generated by rdma-cdi deploy manifest --mode "+string(o.Mode)) {
+		t.Errorf("manifest does not start with the header:\n%s", out)
+	}
+	var ds daemonSet
+	if err := yaml.Unmarshal(out, &ds); err != nil {
+		t.Fatalf("manifest does not parse: %v\n%s", err, out)
+	}
+	return ds
+}
+
+func mountPaths(c container) []string {
+	var paths []string
+	for _, m := range c.VolumeMounts {
+		paths = append(paths, m.MountPath)
+	}
+	return paths
+}
+
+func TestManifest_Watch(t *testing.T) {
+	ds := render(t, Options{
+		Mode:         ModeWatch,
+		Image:        "registry.example.com/rdma-cdi:v1",
+		GlobalArgs:   []string{"--require-devices=uverbs"},
+		GenerateArgs: []string{"--aliases"},
+		SpecDirs:     []string{"/etc/cdi", "/var/run/cdi"},
+		StateFile:    "/var/lib/rdma-cdi/state.json",
+		LockDir:      "/run/rdma-cdi",
+	})
+
+	if ds.Metadata.Name != DefaultName || ds.Metadata.Namespace != DefaultNamespace {
+		t.Errorf("metadata = %+v, want the defaults", ds.Metadata)
+	}
+	pod := ds.Spec.Template.Spec
+	if !pod.HostNetwork || len(pod.Tolerations) != 1 || pod.Tolerations[0].Operator != "Exists" {
+		t.Errorf("pod spec = %+v, want host network and tolerating every taint", pod)
+	}
+	if len(pod.InitContainers) != 1 || len(pod.Containers) != 1 {
+		t.Fatalf("want one init container and one container, got %+v", pod)
+	}
+
+	gen, guard := pod.InitContainers[0], pod.Containers[0]
+	wantGen := []string{"--host-root=/host", "--require-devices=uverbs", "--state-file=/var/lib/rdma-cdi/state.json",
+		"generate", "--all", "--output-dir=/etc/cdi", "--aliases"}
+	if !slices.Equal(gen.Args, wantGen) {
+		t.Errorf("generate args = %q, want %q", gen.Args, wantGen)
+	}
+	wantGuard := []string{"--host-root=/host", "--require-devices=uverbs", "--state-file=/var/lib/rdma-cdi/state.json",
+		"guard", "--output-dir=/etc/cdi", "--output-dir=/var/run/cdi"}
+	if !slices.Equal(guard.Args, wantGuard) {
+		t.Errorf("guard args = %q, want %q", guard.Args, wantGuard)
+	}
+	if guard.SecurityContext == nil || !guard.SecurityContext.Privileged {
+		t.Error("guard container is not privileged")
+	}
+
+	wantMounts := []string{"/host", "/etc/cdi", "/var/run/cdi", "/var/lib/rdma-cdi", "/run/rdma-cdi"}
+	if got := mountPaths(guard); !slices.Equal(got, wantMounts) {
+		t.Errorf("mounts = %q, want %q", got, wantMounts)
+	}
+	if !guard.VolumeMounts[0].ReadOnly || guard.VolumeMounts[1].ReadOnly {
+		t.Errorf("want only the host root read-only: %+v", guard.VolumeMounts)
+	}
+	if len(pod.Volumes) != len(wantMounts) || pod.Volumes[0].HostPath.Path != "/" {
+		t.Errorf("volumes = %+v", pod.Volumes)
+	}
+}
+
+func TestManifest_Plugins(t *testing.T) {
+	for mode, want := range map[Mode]string{
+		ModeDRA:          "/var/lib/kubelet/plugins/rdma.example.com",
+		ModeDevicePlugin: "/var/lib/kubelet/device-plugins",
+	} {
+		ds := render(t, Options{
+			Mode:       mode,
+			Image:      "registry.example.com/rdma-dra:v1",
+			Namespace:  "rdma",
+			HostRoot:   "/hostfs",
+			PluginName: "rdma.example.com",
+		})
+		pod := ds.Spec.Template.Spec
+		if len(pod.InitContainers) != 0 || len(pod.Containers) != 1 {
+			t.Fatalf("%s: want a single container, got %+v", mode, pod)
+		}
+		c := pod.Containers[0]
+		if len(c.Command) != 0 || !slices.Equal(c.Args, []string{"--host-root=/hostfs"}) {
+			t.Errorf("%s: want the image's entrypoint with rdma-cdi flags, got %q %q", mode, c.Command, c.Args)
+		}
+		mounts := mountPaths(c)
+		if !slices.Contains(mounts, "/var/lib/kubelet/plugins_registry") || !slices.Contains(mounts, want) {
+			t.Errorf("%s: mounts = %q, want the registry and %s", mode, mounts, want)
+		}
+		if ds.Metadata.Namespace != "rdma" {
+			t.Errorf("%s: namespace = %q", mode, ds.Metadata.Namespace)
+		}
+	}
+}
+
+func TestManifest_Events(t *testing.T) {
+	out, err := Manifest(Options{Mode: ModeWatch, Image: "x", Namespace: "rdma", SpecDirs: []string{"/etc/cdi"}, Events: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := strings.Split(string(out), "\n---\n")
+	if len(docs) != 4 {
+		t.Fatalf("want the DaemonSet and three RBAC objects, got %d documents:\n%s", len(docs), out)
+	}
+	var ds daemonSet
+	if err := yaml.Unmarshal([]byte(docs[0]), &ds); err != nil {
+		t.Fatal(err)
+	}
+	pod := ds.Spec.Template.Spec
+	if pod.ServiceAccountName != DefaultName {
+		t.Errorf("serviceAccountName = %q, want %q", pod.ServiceAccountName, DefaultName)
+	}
+	guard := pod.Containers[0]
+	for _, arg := range []string{"--k8s-events", "--node-condition=RDMAHealthy", "--doctor-interval=" + DoctorInterval} {
+		if !slices.Contains(guard.Args, arg) {
+			t.Errorf("guard args = %q, want %s", guard.Args, arg)
+		}
+	}
+	if len(guard.Env) != 1 || guard.Env[0].Name != "NODE_NAME" || guard.Env[0].ValueFrom.FieldRef.FieldPath != "spec.nodeName" {
+		t.Errorf("guard env = %+v, want NODE_NAME from the downward API", guard.Env)
+	}
+
+	var role clusterRole
+	if err := yaml.Unmarshal([]byte(docs[2]), &role); err != nil {
+		t.Fatal(err)
+	}
+	if role.Kind != "ClusterRole" || len(role.Rules) != 2 || role.Rules[1].Resources[0] != "nodes/status" {
+		t.Errorf("cluster role = %+v", role)
+	}
+	var binding clusterRoleBinding
+	if err := yaml.Unmarshal([]byte(docs[3]), &binding); err != nil {
+		t.Fatal(err)
+	}
+	if len(binding.Subjects) != 1 || binding.Subjects[0] != (subject{"ServiceAccount", DefaultName, "rdma"}) {
+		t.Errorf("binding subjects = %+v", binding.Subjects)
+	}
+
+	// Without events the guard needs neither
+	plain := render(t, Options{Mode: ModeWatch, Image: "x"})
+	if p := plain.Spec.Template.Spec; p.ServiceAccountName != "" || len(p.Containers[0].Env) != 0 {
+		t.Errorf("pod without events = %+v", p)
+	}
+}
+
+func TestManifest_Invalid(t *testing.T) {
+	for name, o := range map[string]Options{
+		"no image":       {Mode: ModeWatch},
+		"unknown mode":   {Mode: "serve", Image: "x"},
+		"no plugin name": {Mode: ModeDRA, Image: "x"},
+	} {
+		if _, err := Manifest(o); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("%s: error = %v, want ErrInvalidOptions", name, err)
+		}
+	}
+}
+
+func TestMountName(t *testing.T) {
+	m := &mounts{}
+	m.add("", "/var/lib/kubelet/plugins_registry", "/registry", false)
+	m.add("", "/var/lib/kubelet/plugins_registry", "/again", false)
+	if len(m.volumes) != 1 || m.volumes[0].Name != "var-lib-kubelet-plugins-registry" {
+		t.Errorf("volumes = %+v, want one named after its path", m.volumes)
+	}
+}
@@ -3,7 +3,10 @@ package discover
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/olekukonko/tablewriter"
@@ -11,12 +14,45 @@ import (
 	"github.com/Nativu5/rdma-cdi/pkg/types"
 )
 
-// PrintTable renders discovered RDMA devices as a human-readable table.
-func PrintTable(w io.Writer, devices []*types.RdmaDevice) {
+// vfIndent prefixes VF rows in the table so they read as children of their PF.
+const vfIndent = "└─ "
+
+// PrintTable renders discovered RDMA devices as a human-readable table,
+// naming them as f does. VFs are listed, indented, directly below their
+// parent PF, and the host's RDMA netns mode and any discovery warnings
+// follow the table.
+func PrintTable(w io.Writer, devices []*types.RdmaDevice, f types.DeviceIDFormat) {
+	printTable(w, devices, f, false)
+}
+
+// PrintWideTable is PrintTable with the NUMA node, physical slot, board
+// ID, IP addresses and, when discovery recorded it, the usage of each
+// device added: the attached kernel ULPs and the number of open verbs
+// contexts.
+func PrintWideTable(w io.Writer, devices []*types.RdmaDevice, f types.DeviceIDFormat) {
+	printTable(w, devices, f, true)
+}
+
+func printTable(w io.Writer, devices []*types.RdmaDevice, f types.DeviceIDFormat, wide bool) {
 	table := tablewriter.NewTable(w)
-	table.Header("PCI ADDRESS", "INTERFACE", "DRIVER", "LINK TYPE", "DEVICES")
-	for _, dev := range devices {
+	first := "DEVICE"
+	if f == types.DeviceIDPCI {
+		first = "PCI ADDRESS"
+	}
+	header := []any{first, "MODEL", "INTERFACE", "DRIVER", "LINK TYPE", "DEVICES"}
+	if wide {
+		header = append(header, "NUMA", "SLOT", "BOARD ID", "ADDRESSES", "ULPS", "USERS")
+	}
+	table.Header(header...)
+	for _, dev := range groupByPF(devices) {
+		label := dev.Label(f)
+		if dev.IsVF {
+			label = vfIndent + label
+		}
 		ifname := dev.IfName
+		if len(dev.IfNames) > 0 {
+			ifname = strings.Join(dev.IfNames, ", ")
+		}
 		if ifname == "" {
 			ifname = "(none)"
 		}
@@ -29,33 +65,138 @@ func PrintTable(w io.Writer, devices []*types.RdmaDevice) {
 			linkType = "(unknown)"
 		}
 		charDevs := strings.Join(dev.RdmaDevices, ", ")
-		table.Append(dev.PciAddress, ifname, driver, linkType, charDevs)
+		row := []any{label, Model(dev), ifname, driver, linkType, charDevs}
+		if wide {
+			numa := "-"
+			if dev.NumaNode >= 0 {
+				numa = strconv.Itoa(dev.NumaNode)
+			}
+			slot, board := dev.Slot, dev.BoardID
+			if slot == "" {
+				slot = "-"
+			}
+			if board == "" {
+				board = "-"
+			}
+			addrs := strings.Join(dev.Addresses, "\n")
+			if addrs == "" {
+				addrs = "(none)"
+			}
+			row = append(row, numa, slot, board, addrs)
+			row = append(row, usageCells(dev.Usage)...)
+		}
+		table.Append(row...)
 	}
 	table.Render()
+	// The netns mode is host-wide, so it is shown once below the table
+	for _, dev := range devices {
+		if dev.NetnsMode != "" {
+			fmt.Fprintf(w, "RDMA netns mode: %s\n", dev.NetnsMode)
+			break
+		}
+	}
+	printWarnings(w, devices, f)
 }
 
-// DeviceJSON is the JSON representation of a discovered RDMA device.
-type DeviceJSON struct {
-	PciAddress  string   `json:"pci_address"`
-	IfName      string   `json:"interface,omitempty"`
-	Driver      string   `json:"driver,omitempty"`
-	LinkType    string   `json:"link_type,omitempty"`
-	RdmaDevices []string `json:"rdma_devices"`
+// usageCells renders the ULPS and USERS columns, "-" when usage was not
+// gathered.
+func usageCells(u *types.RdmaUsage) []any {
+	if u == nil {
+		return []any{"-", "-"}
+	}
+	ulps := strings.Join(u.ULPs, ", ")
+	if ulps == "" {
+		ulps = "(none)"
+	}
+	users := strconv.Itoa(u.VerbsUsers)
+	if len(u.PIDs) > 0 {
+		pids := make([]string, len(u.PIDs))
+		for i, pid := range u.PIDs {
+			pids[i] = strconv.Itoa(pid)
+		}
+		users += " (pid " + strings.Join(pids, ", ") + ")"
+	}
+	return []any{ulps, users}
 }
 
-// PrintJSON renders discovered RDMA devices as JSON.
-func PrintJSON(w io.Writer, devices []*types.RdmaDevice) error {
-	out := make([]DeviceJSON, 0, len(devices))
+// printWarnings lists below the table what discovery could not determine,
+// including devices it failed on altogether, so blank or "(unknown)" cells
+// are not mistaken for facts.
+func printWarnings(w io.Writer, devices []*types.RdmaDevice, f types.DeviceIDFormat) {
+	var incomplete int
+	for _, dev := range devices {
+		if len(dev.Warnings) > 0 || dev.Error != "" {
+			incomplete++
+		}
+	}
+	if incomplete == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Warning: discovery is incomplete for %d device(s):\n", incomplete)
+	for _, dev := range groupByPF(devices) {
+		if dev.Error != "" {
+			fmt.Fprintf(w, "  %s: failed: %s\n", dev.Label(f), dev.Error)
+		}
+		for _, warning := range dev.Warnings {
+			fmt.Fprintf(w, "  %s: %s: %s\n", dev.Label(f), warning.Field, warning.Message)
+		}
+	}
+}
+
+// Model returns the model name of dev, or its raw "vendor:device" PCI IDs
+// when the PCI IDs database does not know them.
+func Model(dev *types.RdmaDevice) string {
+	switch {
+	case dev.ModelName != "":
+		return dev.ModelName
+	case dev.Vendor != "" || dev.DeviceID != "":
+		return dev.Vendor + ":" + dev.DeviceID
+	default:
+		return "(unknown)"
+	}
+}
+
+// groupByPF orders devices so that each PF is followed by its VFs (by VF
+// index). VFs whose PF is not in the list keep their relative order at the end.
+func groupByPF(devices []*types.RdmaDevice) []*types.RdmaDevice {
+	vfsByPF := make(map[string][]*types.RdmaDevice)
+	present := make(map[string]bool)
+	for _, dev := range devices {
+		if !dev.IsVF {
+			present[dev.PciAddress] = true
+		}
+	}
+
+	var out, orphans []*types.RdmaDevice
+	for _, dev := range devices {
+		switch {
+		case !dev.IsVF:
+		case present[dev.ParentPFAddress]:
+			vfsByPF[dev.ParentPFAddress] = append(vfsByPF[dev.ParentPFAddress], dev)
+		default:
+			orphans = append(orphans, dev)
+		}
+	}
+
 	for _, dev := range devices {
-		out = append(out, DeviceJSON{
-			PciAddress:  dev.PciAddress,
-			IfName:      dev.IfName,
-			Driver:      dev.Driver,
-			LinkType:    dev.LinkType,
-			RdmaDevices: dev.RdmaDevices,
-		})
+		if dev.IsVF {
+			continue
+		}
+		out = append(out, dev)
+		vfs := vfsByPF[dev.PciAddress]
+		sort.SliceStable(vfs, func(i, j int) bool { return vfs[i].VFIndex < vfs[j].VFIndex })
+		out = append(out, vfs...)
+	}
+	return append(out, orphans...)
+}
+
+// PrintJSON renders discovered RDMA devices in the canonical inventory
+// form (see types.InventorySchema).
+func PrintJSON(w io.Writer, devices []*types.RdmaDevice) error {
+	if devices == nil {
+		devices = []*types.RdmaDevice{}
 	}
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
-	return enc.Encode(out)
+	return enc.Encode(devices)
 }
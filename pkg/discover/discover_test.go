@@ -34,7 +34,7 @@ func sampleDevices() []*types.RdmaDevice {
 
 func TestPrintTable_Basic(t *testing.T) {
 	var buf bytes.Buffer
-	PrintTable(&buf, sampleDevices())
+	PrintTable(&buf, sampleDevices(), types.DeviceIDPCI)
 	output := buf.String()
 
 	// Should contain headers
@@ -62,9 +62,73 @@ func TestPrintTable_Basic(t *testing.T) {
 	}
 }
 
+func TestPrintTable_NetnsMode(t *testing.T) {
+	devices := sampleDevices()
+	var buf bytes.Buffer
+	PrintTable(&buf, devices, types.DeviceIDAuto)
+	if strings.Contains(buf.String(), "netns mode") {
+		t.Error("table should not show an unknown netns mode")
+	}
+
+	devices[1].NetnsMode = "exclusive"
+	buf.Reset()
+	PrintTable(&buf, devices, types.DeviceIDAuto)
+	if !strings.Contains(buf.String(), "RDMA netns mode: exclusive") {
+		t.Errorf("table should show the netns mode, got:\n%s", buf.String())
+	}
+}
+
+func TestPrintTable_IfNames(t *testing.T) {
+	var buf bytes.Buffer
+	PrintTable(&buf, []*types.RdmaDevice{{PciAddress: "0000:04:00.0", IfName: "ib0", IfNames: []string{"ib0", "ib1"}}}, types.DeviceIDAuto)
+	if !strings.Contains(buf.String(), "ib0, ib1") {
+		t.Errorf("table should list every interface:\n%s", buf.String())
+	}
+}
+
+func TestPrintWideTable(t *testing.T) {
+	var buf bytes.Buffer
+	PrintWideTable(&buf, []*types.RdmaDevice{{
+		PciAddress: "0000:17:00.0", NumaNode: 1, Addresses: []string{"192.0.2.10/24"},
+		Slot: "PCIe-Slot-2", BoardID: "MT_0000000010",
+		Usage: &types.RdmaUsage{ULPs: []string{"ipoib", "srp"}, VerbsUsers: 3, PIDs: []int{17, 4242}},
+	}}, types.DeviceIDAuto)
+	for _, want := range []string{"ADDRESSES", "NUMA", "192.0.2.10/24", "SLOT", "PCIe-Slot-2", "MT_0000000010", "ULPS", "ipoib, srp", "3 (pid 17, 4242)"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("wide table should contain %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestPrintTable_Warnings(t *testing.T) {
+	var buf bytes.Buffer
+	PrintTable(&buf, []*types.RdmaDevice{
+		{PciAddress: "0000:17:00.0"},
+		{PciAddress: "0000:41:00.0", Warnings: []types.DiscoveryWarning{
+			{Field: "driver", Message: "cannot read driver symlink"},
+		}},
+		{PciAddress: "0000:61:00.0", Error: "discovery of 0000:61:00.0 timed out after 30s"},
+	}, types.DeviceIDAuto)
+	for _, want := range []string{
+		"incomplete for 2 device(s)",
+		"0000:41:00.0: driver: cannot read driver symlink",
+		"0000:61:00.0: failed: discovery of 0000:61:00.0 timed out",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("table footer should contain %q:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	PrintTable(&buf, []*types.RdmaDevice{{PciAddress: "0000:17:00.0"}}, types.DeviceIDAuto)
+	if strings.Contains(buf.String(), "incomplete") {
+		t.Errorf("no footer expected without warnings:\n%s", buf.String())
+	}
+}
+
 func TestPrintTable_Empty(t *testing.T) {
 	var buf bytes.Buffer
-	PrintTable(&buf, nil)
+	PrintTable(&buf, nil, types.DeviceIDPCI)
 	output := buf.String()
 
 	// Should still render headers
@@ -80,7 +144,7 @@ func TestPrintJSON_Basic(t *testing.T) {
 		t.Fatalf("PrintJSON failed: %v", err)
 	}
 
-	var result []DeviceJSON
+	var result []types.RdmaDevice
 	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
 		t.Fatalf("output is not valid JSON: %v", err)
 	}
@@ -103,7 +167,7 @@ func TestPrintJSON_Empty(t *testing.T) {
 		t.Fatalf("PrintJSON with nil failed: %v", err)
 	}
 
-	var result []DeviceJSON
+	var result []types.RdmaDevice
 	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
 		t.Fatalf("output is not valid JSON: %v", err)
 	}
@@ -111,3 +175,87 @@ func TestPrintJSON_Empty(t *testing.T) {
 		t.Errorf("expected 0 devices, got %d", len(result))
 	}
 }
+
+func sriovDevices() []*types.RdmaDevice {
+	return []*types.RdmaDevice{
+		{PciAddress: "0000:17:00.3", IsVF: true, VFIndex: 1, ParentPFAddress: "0000:17:00.0"},
+		{PciAddress: "0000:17:00.0", IfName: "enp23s0f0np0"},
+		{PciAddress: "0000:17:00.2", IsVF: true, VFIndex: 0, ParentPFAddress: "0000:17:00.0"},
+		{PciAddress: "0000:41:00.0", IfName: "enp65s0np0"},
+		{PciAddress: "0000:99:00.1", IsVF: true, VFIndex: 0, ParentPFAddress: "0000:99:00.0"},
+	}
+}
+
+func TestGroupByPF(t *testing.T) {
+	var got []string
+	for _, d := range groupByPF(sriovDevices()) {
+		got = append(got, d.PciAddress)
+	}
+	want := []string{"0000:17:00.0", "0000:17:00.2", "0000:17:00.3", "0000:41:00.0", "0000:99:00.1"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("groupByPF order = %v, want %v", got, want)
+	}
+}
+
+func TestPrintTable_IndentsVFs(t *testing.T) {
+	var buf bytes.Buffer
+	PrintTable(&buf, sriovDevices(), types.DeviceIDPCI)
+	output := buf.String()
+
+	if !strings.Contains(output, vfIndent+"0000:17:00.2") {
+		t.Errorf("VF row should be indented under its PF; got:\n%s", output)
+	}
+	if strings.Contains(output, vfIndent+"0000:17:00.0") {
+		t.Error("PF row must not be indented")
+	}
+}
+
+func TestPrintTable_DeviceIDFormat(t *testing.T) {
+	devices := []*types.RdmaDevice{{PciAddress: "0000:17:00.0", IbDev: "mlx5_0", IfName: "ens1f0"}}
+	for f, want := range map[types.DeviceIDFormat]string{
+		types.DeviceIDAuto:  "mlx5_0 (0000:17:00.0)",
+		types.DeviceIDIbdev: "│ mlx5_0 ",
+		types.DeviceIDPCI:   "PCI ADDRESS",
+	} {
+		var buf bytes.Buffer
+		PrintTable(&buf, devices, f)
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("%s: table should contain %q:\n%s", f, want, buf.String())
+		}
+	}
+}
+
+func TestPrintJSON_VFFields(t *testing.T) {
+	var buf bytes.Buffer
+	if err := PrintJSON(&buf, sriovDevices()); err != nil {
+		t.Fatalf("PrintJSON failed: %v", err)
+	}
+
+	var result []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatalf("output is not valid JSON: %v", err)
+	}
+	vf := result[2]
+	if vf["is_vf"] != true || vf["vf_index"] != float64(0) || vf["parent_pf"] != "0000:17:00.0" {
+		t.Errorf("unexpected VF JSON: %v", vf)
+	}
+	if _, ok := result[1]["vf_index"]; ok {
+		t.Errorf("PF JSON should omit vf_index: %v", result[1])
+	}
+}
+
+func TestModel(t *testing.T) {
+	tests := []struct {
+		dev  types.RdmaDevice
+		want string
+	}{
+		{types.RdmaDevice{Vendor: "15b3", DeviceID: "101d", ModelName: "Mellanox ConnectX-6 Dx"}, "Mellanox ConnectX-6 Dx"},
+		{types.RdmaDevice{Vendor: "abcd", DeviceID: "1234"}, "abcd:1234"},
+		{types.RdmaDevice{}, "(unknown)"},
+	}
+	for _, tt := range tests {
+		if got := Model(&tt.dev); got != tt.want {
+			t.Errorf("Model(%+v) = %q, want %q", tt.dev, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package doctor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// maxCorrectablePerHour is the rate of corrected PCIe errors above which a
+// link is considered flaky. A healthy link sees next to none; a marginal
+// one retrains and replays TLPs, which shows up as RDMA timeouts long
+// before it fails outright.
+const maxCorrectablePerHour = 10
+
+// AER counter files of a PCI device, each listing counters by error type
+// and a TOTAL_ERR_* line.
+const (
+	aerCorrectable = "aer_dev_correctable"
+	aerNonFatal    = "aer_dev_nonfatal"
+	aerFatal       = "aer_dev_fatal"
+)
+
+// syslog(2) actions; see klogctl(3).
+const (
+	syslogActionReadAll    = 3
+	syslogActionSizeBuffer = 10
+)
+
+// readKernelLog returns the kernel ring buffer, replaceable in tests.
+var readKernelLog = func() ([]byte, error) {
+	size, err := unix.Klogctl(syslogActionSizeBuffer, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	n, err := unix.Klogctl(syslogActionReadAll, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// readAERTotals reads the TOTAL_ERR_* counter of each AER file of the PCI
+// device at dir; ok is false if the device reports no AER counters.
+func readAERTotals(dir string) (totals map[string]int, ok bool) {
+	totals = make(map[string]int)
+	for _, name := range []string{aerCorrectable, aerNonFatal, aerFatal} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		ok = true
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 2 || !strings.HasPrefix(fields[0], "TOTAL_ERR_") {
+				continue
+			}
+			if n, err := strconv.Atoi(fields[1]); err == nil {
+				totals[name] = n
+			}
+		}
+	}
+	return totals, ok
+}
+
+// readUptime returns the uptime of the host whose /proc is next to
+// sysfsRoot, or 0 if unknown.
+func readUptime(sysfsRoot string) time.Duration {
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(sysfsRoot), "proc/uptime"))
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0
+	}
+	secs, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(secs * float64(time.Second))
+}
+
+// aerLogEntries returns the kernel log lines about PCIe or memory
+// controller errors that name pciAddr, oldest first, without their
+// syslog priority prefix.
+func aerLogEntries(klog []byte, pciAddr string) []string {
+	var out []string
+	sc := bufio.NewScanner(bytes.NewReader(klog))
+	for sc.Scan() {
+		line := sc.Text()
+		if !strings.Contains(line, pciAddr) {
+			continue
+		}
+		if !strings.Contains(line, "AER") && !strings.Contains(line, "EDAC") && !strings.Contains(line, "PCIe Bus Error") {
+			continue
+		}
+		if strings.HasPrefix(line, "<") {
+			if i := strings.Index(line, ">"); i > 0 {
+				line = line[i+1:]
+			}
+		}
+		out = append(out, strings.TrimSpace(line))
+	}
+	return out
+}
+
+// checkAER reports the PCIe Advanced Error Reporting counters of the
+// device, or of its PF for a VF without its own, and, on the running host,
+// the AER and EDAC kernel log entries naming it. A fatal error, or
+// corrected errors at more than maxCorrectablePerHour since boot, is a
+// warning. Devices without AER counters or log entries get no result.
+func checkAER(report *Report, dev *types.RdmaDevice, sysfsRoot string, offline bool) {
+	addr := dev.PciAddress
+	totals, ok := readAERTotals(filepath.Join(sysfsRoot, "bus/pci/devices", addr))
+	if !ok && dev.ParentPFAddress != "" {
+		addr = dev.ParentPFAddress
+		totals, ok = readAERTotals(filepath.Join(sysfsRoot, "bus/pci/devices", addr))
+	}
+	var entries []string
+	if !offline {
+		if klog, err := readKernelLog(); err == nil {
+			entries = aerLogEntries(klog, dev.PciAddress)
+		}
+	}
+	if !ok && len(entries) == 0 {
+		return
+	}
+
+	severity := Pass
+	var problems []string
+	if ok {
+		if n := totals[aerFatal]; n > 0 {
+			severity = Warn
+			problems = append(problems, fmt.Sprintf("%d fatal", n))
+		}
+		if n := totals[aerNonFatal]; n > 0 {
+			problems = append(problems, fmt.Sprintf("%d uncorrectable non-fatal", n))
+		}
+		if n := totals[aerCorrectable]; n > 0 {
+			msg := fmt.Sprintf("%d corrected", n)
+			if uptime := readUptime(sysfsRoot); uptime > 0 {
+				// Judge rates over at least an hour, so a few errors during
+				// boot do not count as a burst
+				rate := float64(n) / max(uptime.Hours(), 1)
+				msg += fmt.Sprintf(" (%.1f/h since boot)", rate)
+				if rate > maxCorrectablePerHour {
+					severity = Warn
+				}
+			}
+			problems = append(problems, msg)
+		}
+	}
+	if len(entries) > 0 {
+		severity = Warn
+	}
+
+	var msg string
+	switch {
+	case len(problems) == 0 && len(entries) == 0:
+		msg = "No PCIe errors recorded"
+	case len(problems) > 0:
+		msg = "PCIe errors recorded: " + strings.Join(problems, ", ")
+		if addr != dev.PciAddress {
+			msg += " on PF " + addr
+		}
+	default:
+		msg = "PCIe errors in the kernel log"
+	}
+	if len(entries) > 0 {
+		msg += fmt.Sprintf("; %d kernel log entries, the last: %s", len(entries), entries[len(entries)-1])
+	}
+	if severity == Warn {
+		msg += "; reseat the card or check its slot and riser, as a flaky link surfaces as RDMA timeouts"
+	}
+	report.add(CheckResult{
+		Check:    "pcie_errors",
+		Severity: severity,
+		Message:  msg,
+		Device:   dev.PciAddress,
+	})
+}
@@ -0,0 +1,118 @@
+package doctor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// writeAER writes the AER counter files of pciAddr under <root>/sys.
+func writeAER(t *testing.T, root, pciAddr string, correctable, nonFatal, fatal int) {
+	t.Helper()
+	dir := filepath.Join(root, "sys", "bus/pci/devices", pciAddr)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		aerCorrectable: fmt.Sprintf("RxErr 0\nBadTLP %d\nTOTAL_ERR_COR %d\n", correctable, correctable),
+		aerNonFatal:    fmt.Sprintf("Undefined 0\nTOTAL_ERR_NONFATAL %d\n", nonFatal),
+		aerFatal:       fmt.Sprintf("SurpriseDown %d\nTOTAL_ERR_FATAL %d\n", fatal, fatal),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func writeUptime(t *testing.T, root, uptime string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(root, "proc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "proc/uptime"), []byte(uptime+" 1234.56\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckAER(t *testing.T) {
+	orig := readKernelLog
+	t.Cleanup(func() { readKernelLog = orig })
+	klog := ""
+	readKernelLog = func() ([]byte, error) { return []byte(klog), nil }
+
+	root := t.TempDir()
+	sysfs := filepath.Join(root, "sys")
+	writeUptime(t, root, "36000.00") // 10 hours
+	pf := &types.RdmaDevice{PciAddress: "0000:17:00.0"}
+	vf := &types.RdmaDevice{PciAddress: "0000:17:00.2", IsVF: true, ParentPFAddress: "0000:17:00.0"}
+
+	tests := []struct {
+		name                         string
+		correctable, nonFatal, fatal int
+		klog                         string
+		dev                          *types.RdmaDevice
+		want                         Severity
+		wantMsg                      []string
+	}{
+		{"clean", 0, 0, 0, "", pf, Pass, []string{"No PCIe errors recorded"}},
+		{"few_corrected", 50, 0, 0, "", pf, Pass, []string{"50 corrected (5.0/h since boot)"}},
+		{"corrected_burst", 500, 0, 0, "", pf, Warn, []string{"500 corrected (50.0/h", "reseat the card"}},
+		{"fatal", 0, 1, 1, "", pf, Warn, []string{"1 fatal", "1 uncorrectable non-fatal"}},
+		{"vf_reads_its_pf", 0, 0, 2, "", vf, Warn, []string{"2 fatal on PF 0000:17:00.0"}},
+		{"kernel_log", 0, 0, 0,
+			"<4>[  12.3] e1000e 0000:00:19.0: AER: Corrected error received: 0000:00:19.0\n" +
+				"<3>[ 815.2] mlx5_core 0000:17:00.0: PCIe Bus Error: severity=Corrected, type=Physical Layer, (Receiver ID)\n" +
+				"<3>[ 815.3] mlx5_core 0000:17:00.0: device [15b3:1017] error status/mask=00000001/00002000\n",
+			pf, Warn, []string{"1 kernel log entries", "the last: [ 815.2] mlx5_core 0000:17:00.0: PCIe Bus Error"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			writeAER(t, root, "0000:17:00.0", tc.correctable, tc.nonFatal, tc.fatal)
+			klog = tc.klog
+			report := &Report{}
+			checkAER(report, tc.dev, sysfs, false)
+			if len(report.Results) != 1 {
+				t.Fatalf("expected one result, got %+v", report.Results)
+			}
+			r := report.Results[0]
+			if r.Check != "pcie_errors" || r.Severity != tc.want || r.Device != tc.dev.PciAddress {
+				t.Errorf("result = %+v, want %s", r, tc.want)
+			}
+			for _, want := range tc.wantMsg {
+				if !strings.Contains(r.Message, want) {
+					t.Errorf("message %q lacks %q", r.Message, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckAER_NoCounters(t *testing.T) {
+	orig := readKernelLog
+	t.Cleanup(func() { readKernelLog = orig })
+	readKernelLog = func() ([]byte, error) { return nil, errors.New("operation not permitted") }
+
+	report := &Report{}
+	checkAER(report, &types.RdmaDevice{PciAddress: "0000:17:00.0"}, t.TempDir(), false)
+	if len(report.Results) != 0 {
+		t.Errorf("expected no result without AER counters, got %+v", report.Results)
+	}
+
+	// Offline, the kernel log of the running host is not consulted, and
+	// without uptime corrected errors are only counted
+	root := t.TempDir()
+	writeAER(t, root, "0000:17:00.0", 5000, 0, 0)
+	readKernelLog = func() ([]byte, error) {
+		t.Error("the kernel log should not be read offline")
+		return nil, nil
+	}
+	checkAER(report, &types.RdmaDevice{PciAddress: "0000:17:00.0"}, filepath.Join(root, "sys"), true)
+	if len(report.Results) != 1 || report.Results[0].Severity != Pass || !strings.HasSuffix(report.Results[0].Message, "5000 corrected") {
+		t.Errorf("offline result = %+v", report.Results)
+	}
+}
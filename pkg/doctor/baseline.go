@@ -0,0 +1,175 @@
+package doctor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// Change is a check whose outcome differs from a baseline report.
+type Change struct {
+	Check      string   `json:"check"`
+	Device     string   `json:"device,omitempty"`
+	PciAddress string   `json:"pci_address,omitempty"`
+	Was        Severity `json:"was"`
+	Now        Severity `json:"now"`
+	Message    string   `json:"message"`
+}
+
+// Comparison is a report compared with a baseline: the new or worse
+// warnings and failures, and those that went away or improved.
+type Comparison struct {
+	Regressions []Change `json:"regressions"`
+	Recoveries  []Change `json:"recoveries"`
+}
+
+// LoadBaseline reads a report written by doctor --output json, with or
+// without --baseline.
+func LoadBaseline(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read baseline: %w", err)
+	}
+	var results []CheckResult
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var compared struct {
+			Results []CheckResult `json:"results"`
+		}
+		err = json.Unmarshal(data, &compared)
+		results = compared.Results
+	} else {
+		err = json.Unmarshal(data, &results)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a doctor JSON report: %w", path, err)
+	}
+	return NewReport(results...), nil
+}
+
+// Compare compares current with baseline. Results are matched on their
+// check and device, by PCI address when known so the device label format
+// does not matter; a result missing on one side counts as passed, as
+// reports without --show-pass leave passes out. A warning whose message
+// changed, e.g. with a new link speed, is not a regression as long as the
+// baseline warned about the same check and device as severely.
+func Compare(baseline, current *Report) *Comparison {
+	c := &Comparison{
+		Regressions: worsened(baseline.Results, current.Results),
+		Recoveries:  worsened(current.Results, baseline.Results),
+	}
+	// The recoveries were found the other way round
+	for i := range c.Recoveries {
+		c.Recoveries[i].Was, c.Recoveries[i].Now = c.Recoveries[i].Now, c.Recoveries[i].Was
+	}
+	return c
+}
+
+// resultKey identifies what a result is about.
+type resultKey struct {
+	check, device string
+}
+
+func keyOf(cr CheckResult) resultKey {
+	if cr.PciAddress != "" {
+		return resultKey{cr.Check, cr.PciAddress}
+	}
+	return resultKey{cr.Check, cr.Device}
+}
+
+func rank(s Severity) int {
+	switch s {
+	case Warn:
+		return 1
+	case Fail:
+		return 2
+	}
+	return 0
+}
+
+// worsened returns the warnings and failures of after that nothing in
+// before covers: no result of before has the same check, device and
+// message at the same or a worse severity, nor does one about the same
+// check and device whose message after lost.
+func worsened(before, after []CheckResult) []Change {
+	messages := make(map[resultKey]map[string]bool)
+	for _, cr := range after {
+		k := keyOf(cr)
+		if messages[k] == nil {
+			messages[k] = make(map[string]bool)
+		}
+		messages[k][cr.Message] = true
+	}
+	byKey := make(map[resultKey][]CheckResult)
+	for _, cr := range before {
+		byKey[keyOf(cr)] = append(byKey[keyOf(cr)], cr)
+	}
+
+	changes := []Change{}
+	for _, cr := range after {
+		if cr.Severity == Pass {
+			continue
+		}
+		k := keyOf(cr)
+		was, covered := Pass, false
+		for _, b := range byKey[k] {
+			if rank(b.Severity) > rank(was) {
+				was = b.Severity
+			}
+			sameMessage := b.Message == cr.Message
+			if rank(b.Severity) >= rank(cr.Severity) && (sameMessage || !messages[k][b.Message]) {
+				covered = true
+			}
+		}
+		if !covered {
+			changes = append(changes, Change{
+				Check: cr.Check, Device: cr.Device, PciAddress: cr.PciAddress,
+				Was: was, Now: cr.Severity, Message: cr.Message,
+			})
+		}
+	}
+	return changes
+}
+
+// PrintComparison renders the changes from the baseline as a table.
+func PrintComparison(w io.Writer, c *Comparison) {
+	if len(c.Regressions) == 0 && len(c.Recoveries) == 0 {
+		fmt.Fprintln(w, "No changes from the baseline.")
+		return
+	}
+	fmt.Fprintf(w, "Changes from the baseline: %d regression(s), %d recovery(ies)\n", len(c.Regressions), len(c.Recoveries))
+	table := tablewriter.NewTable(w)
+	table.Header("CHANGE", "CHECK", "DEVICE", "WAS", "NOW", "MESSAGE")
+	for _, set := range []struct {
+		label   string
+		changes []Change
+	}{{"✗ regressed", c.Regressions}, {"✓ recovered", c.Recoveries}} {
+		for _, ch := range set.changes {
+			dev := ch.Device
+			if dev == "" {
+				dev = "(host)"
+			}
+			table.Append(set.label, ch.Check, dev, string(ch.Was), string(ch.Now), ch.Message)
+		}
+	}
+	table.Render()
+}
+
+// PrintComparisonJSON renders the report and its changes from the
+// baseline as one JSON object, which LoadBaseline reads back.
+// When showPass is false, only WARN/FAIL results are included.
+func PrintComparisonJSON(w io.Writer, report *Report, c *Comparison, showPass bool) error {
+	out := struct {
+		Results []CheckResult `json:"results"`
+		*Comparison
+	}{report.filtered(showPass), c}
+	if out.Results == nil {
+		out.Results = []CheckResult{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
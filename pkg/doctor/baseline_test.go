@@ -0,0 +1,102 @@
+package doctor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	baseline := NewReport(
+		CheckResult{Check: "link_attrs", Severity: Warn, Message: "Link speed 10000 Mb/s", Device: "0000:17:00.0"},
+		CheckResult{Check: "kernel_config", Severity: Warn, Message: "CONFIG_A is not set"},
+		CheckResult{Check: "rdma_devices", Severity: Fail, Message: "No RDMA character devices found", Device: "0000:41:00.0"},
+		CheckResult{Check: "net_interface", Severity: Pass, Message: "Interface: ens1f0", Device: "0000:17:00.0"},
+	)
+	current := NewReport(
+		// Same warning in other words, and under another label
+		CheckResult{Check: "link_attrs", Severity: Warn, Message: "Link speed 25000 Mb/s",
+			Device: "mlx5_0 (0000:17:00.0)", PciAddress: "0000:17:00.0"},
+		// A second warning of a check that already warned
+		CheckResult{Check: "kernel_config", Severity: Warn, Message: "CONFIG_A is not set"},
+		CheckResult{Check: "kernel_config", Severity: Warn, Message: "CONFIG_B is not set"},
+		CheckResult{Check: "rdma_devices", Severity: Pass, Message: "All required RDMA devices present (3)", Device: "0000:41:00.0"},
+		CheckResult{Check: "net_interface", Severity: Fail, Message: "Interface: ens1f0 is down", Device: "0000:17:00.0"},
+	)
+
+	got := Compare(baseline, current)
+	want := &Comparison{
+		Regressions: []Change{
+			{Check: "kernel_config", Was: Warn, Now: Warn, Message: "CONFIG_B is not set"},
+			{Check: "net_interface", Device: "0000:17:00.0", Was: Pass, Now: Fail, Message: "Interface: ens1f0 is down"},
+		},
+		Recoveries: []Change{
+			{Check: "rdma_devices", Device: "0000:41:00.0", Was: Fail, Now: Pass, Message: "No RDMA character devices found"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Compare() =\n%+v\nwant\n%+v", got, want)
+	}
+
+	if c := Compare(current, current); len(c.Regressions) != 0 || len(c.Recoveries) != 0 {
+		t.Errorf("a report compared with itself changed: %+v", c)
+	}
+}
+
+func TestLoadBaseline(t *testing.T) {
+	report := NewReport(
+		CheckResult{Check: "rdma_devices", Severity: Fail, Message: "No RDMA character devices found", Device: "0000:41:00.0"},
+		CheckResult{Check: "kernel_version", Severity: Pass, Message: "ok"},
+	)
+	dir := t.TempDir()
+
+	var plain, compared bytes.Buffer
+	if err := PrintJSON(&plain, report, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := PrintComparisonJSON(&compared, report, Compare(report, report), true); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{"plain.json": plain.Bytes(), "compared.json": compared.Bytes()} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := LoadBaseline(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(loaded.Results, report.Results) || !loaded.HasFail {
+			t.Errorf("%s: loaded %+v, want %+v", name, loaded, report)
+		}
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte("PASS"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBaseline(bad); err == nil || !strings.Contains(err.Error(), "not a doctor JSON report") {
+		t.Errorf("LoadBaseline(bad) error = %v", err)
+	}
+}
+
+func TestPrintComparison(t *testing.T) {
+	var buf bytes.Buffer
+	PrintComparison(&buf, &Comparison{
+		Regressions: []Change{{Check: "kernel_config", Was: Pass, Now: Warn, Message: "CONFIG_B is not set"}},
+	})
+	for _, want := range []string{"1 regression(s), 0 recovery(ies)", "regressed", "(host)", "CONFIG_B"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output should contain %q:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	PrintComparison(&buf, &Comparison{})
+	if !strings.Contains(buf.String(), "No changes") {
+		t.Errorf("unexpected output without changes:\n%s", buf.String())
+	}
+}
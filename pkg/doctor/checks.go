@@ -0,0 +1,132 @@
+package doctor
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// SchemaVersion is the version of the JSON form of CheckResult, recorded
+// in every result. Within a version, fields are only added, never renamed
+// or removed, and a check ID keeps its meaning; JSON consumers should
+// ignore fields they do not know.
+const SchemaVersion = 1
+
+// Scopes of a check: what its results are about.
+const (
+	ScopeDevice = "device"
+	ScopeHost   = "host"
+	ScopeSpec   = "spec"
+)
+
+// CheckInfo describes a check, for doctor list-checks.
+type CheckInfo struct {
+	// ID is the stable machine-readable name of the check, e.g.
+	// "doctor.link.state", set in the id field of its results.
+	ID string `json:"id"`
+	// Check is the name in the check field of its results.
+	Check string `json:"check"`
+	Scope string `json:"scope"`
+	// DefaultSeverity is the worst severity the check reports with the
+	// default options.
+	DefaultSeverity Severity `json:"default_severity"`
+	Description     string   `json:"description"`
+}
+
+var (
+	checksMu sync.RWMutex
+	checks   = make(map[string]CheckInfo)
+)
+
+func init() {
+	for _, c := range []CheckInfo{
+		{"doctor.discovery", "discovery", ScopeDevice, Fail, "Device discovery completed"},
+		{"doctor.device.model", "device_model", ScopeDevice, Pass, "Adapter model, from the PCI IDs database, with its board ID and physical slot"},
+		{"doctor.device.rdma_devices", "rdma_devices", ScopeDevice, Fail, "The RDMA character devices the vendor profile requires exist"},
+		{"doctor.device.nodes", "device_nodes", ScopeDevice, Fail, "Device node numbers under /dev match sysfs"},
+		{"doctor.device.vendor_nodes", "vendor_dev_nodes", ScopeDevice, Warn, "Vendor-specific device nodes exist, e.g. hfi1_N for Omni-Path"},
+		{"doctor.kernel_modules", "kernel_modules", ScopeDevice, Fail, "The kernel modules the vendor profile needs are loaded"},
+		{"doctor.net.interface", "net_interface", ScopeDevice, Warn, "The device has a network interface"},
+		{"doctor.link.attrs", "link_attrs", ScopeDevice, Warn, "The link of the interface can be queried"},
+		{"doctor.link.admin_state", "link_admin_state", ScopeDevice, Warn, "The link is administratively up"},
+		{"doctor.link.carrier", "link_carrier", ScopeDevice, Warn, "The link has a carrier"},
+		{"doctor.link.state", "link_state", ScopeDevice, Warn, "The link is operationally up; reports encapsulation and MTU"},
+		{"doctor.link.address", "link_address", ScopeDevice, Warn, "The interface has an IP address, as RoCE and rdma_cm need"},
+		{"doctor.rdma_netns_mode", "rdma_netns_mode", ScopeDevice, Warn, "The RDMA subsystem is in the expected netns mode, exclusive by default"},
+		{"doctor.pcie.errors", "pcie_errors", ScopeDevice, Warn, "AER counters and the kernel log show no fatal and few corrected PCIe errors"},
+		{"doctor.kernel.version", "kernel_version", ScopeHost, Fail, "The running kernel is at least --min-kernel"},
+		{"doctor.kernel.config", "kernel_config", ScopeHost, Fail, "The kernel is built with the options containerized RDMA needs"},
+		{"doctor.multi_rail.sysctl", "multi_rail_sysctl", ScopeHost, Warn, "Reverse-path and ARP sysctls suit several RoCE interfaces on one subnet"},
+		{"doctor.spec.drift", "spec_drift", ScopeSpec, Warn, "The specs on disk still match the hardware"},
+		{"doctor.spec.cache_load", "spec_cache_load", ScopeSpec, Fail, "The specs load in the CDI cache and their devices resolve to concrete edits"},
+		{"doctor.spec.netns_mode", "spec_netns_mode", ScopeSpec, Warn, "The specs suit the RDMA netns mode: per-device specs need exclusive mode, the \"all\" device shared mode"},
+		{"doctor.spec.integrity", "spec_integrity", ScopeSpec, Fail, "The specs are valid and unchanged since they were generated"},
+	} {
+		Register(c)
+	}
+}
+
+// Register adds a check run outside this package, such as the bench
+// self-test, to the catalog. It panics if the ID or check name is empty or
+// already taken, as two modules claiming one is a build mistake.
+func Register(c CheckInfo) {
+	checksMu.Lock()
+	defer checksMu.Unlock()
+	if c.ID == "" || c.Check == "" {
+		panic("doctor: Register of a check without an ID or name")
+	}
+	for _, other := range checks {
+		if other.ID == c.ID || other.Check == c.Check {
+			panic("doctor: Register called twice for " + c.ID)
+		}
+	}
+	checks[c.Check] = c
+}
+
+// Checks returns the known checks, sorted by ID.
+func Checks() []CheckInfo {
+	checksMu.RLock()
+	defer checksMu.RUnlock()
+	out := make([]CheckInfo, 0, len(checks))
+	for _, c := range checks {
+		out = append(out, c)
+	}
+	slices.SortFunc(out, func(a, b CheckInfo) int { return strings.Compare(a.ID, b.ID) })
+	return out
+}
+
+// IDOf returns the ID of the check named check, or "doctor.<check>" for a
+// check that is not registered.
+func IDOf(check string) string {
+	checksMu.RLock()
+	defer checksMu.RUnlock()
+	if c, ok := checks[check]; ok {
+		return c.ID
+	}
+	return "doctor." + check
+}
+
+// PrintChecks renders the checks as a table.
+func PrintChecks(w io.Writer, list []CheckInfo) {
+	table := tablewriter.NewTable(w)
+	table.Header("ID", "SCOPE", "SEVERITY", "DESCRIPTION")
+	for _, c := range list {
+		table.Append(c.ID, c.Scope, string(c.DefaultSeverity), c.Description)
+	}
+	table.Render()
+}
+
+// PrintChecksJSON renders the checks as a JSON object with the schema
+// version of the results.
+func PrintChecksJSON(w io.Writer, list []CheckInfo) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		SchemaVersion int         `json:"schema_version"`
+		Checks        []CheckInfo `json:"checks"`
+	}{SchemaVersion, list})
+}
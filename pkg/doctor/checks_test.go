@@ -0,0 +1,77 @@
+package doctor
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestReport_FillsIDs(t *testing.T) {
+	report := NewReport(
+		CheckResult{Check: "kernel_modules", Severity: Fail},
+		CheckResult{Check: "link_state", Severity: Warn, Device: "0000:17:00.0"},
+		CheckResult{Check: "made_up", Severity: Pass},
+	)
+	for i, want := range []string{"doctor.kernel_modules", "doctor.link.state", "doctor.made_up"} {
+		if got := report.Results[i]; got.ID != want || got.SchemaVersion != SchemaVersion {
+			t.Errorf("result %d: ID %q, schema %d, want %q, %d", i, got.ID, got.SchemaVersion, want, SchemaVersion)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := PrintJSON(&buf, report, true); err != nil {
+		t.Fatal(err)
+	}
+	// The JSON form stays an array of results, as before the schema version
+	var decoded []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded[0]["id"] != "doctor.kernel_modules" || decoded[0]["check"] != "kernel_modules" || decoded[0]["schema_version"] != float64(1) {
+		t.Errorf("unexpected JSON result: %v", decoded[0])
+	}
+}
+
+func TestChecks(t *testing.T) {
+	list := Checks()
+	seen := make(map[string]bool)
+	for i, c := range list {
+		if i > 0 && list[i-1].ID >= c.ID {
+			t.Errorf("checks not sorted by ID: %s before %s", list[i-1].ID, c.ID)
+		}
+		if !strings.HasPrefix(c.ID, "doctor.") && !strings.HasPrefix(c.ID, "bench.") || c.Scope == "" || c.Description == "" {
+			t.Errorf("incomplete check: %+v", c)
+		}
+		seen[c.Check] = true
+	}
+	for _, check := range []string{"discovery", "rdma_devices", "kernel_version", "spec_integrity", "multi_rail_sysctl"} {
+		if !seen[check] {
+			t.Errorf("check %s is not listed", check)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a check twice should panic")
+		}
+	}()
+	Register(CheckInfo{ID: "doctor.kernel_modules", Check: "kernel_modules_again"})
+}
+
+func TestPrintChecksJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := PrintChecksJSON(&buf, Checks()); err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		SchemaVersion int         `json:"schema_version"`
+		Checks        []CheckInfo `json:"checks"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.SchemaVersion != SchemaVersion || len(out.Checks) != len(Checks()) || out.Checks[0].DefaultSeverity == "" {
+		t.Errorf("unexpected list-checks JSON:\n%s", buf.String())
+	}
+}
@@ -1,20 +1,27 @@
 // Package doctor provides RDMA environment diagnostics.
 // It checks character device presence, kernel modules, link attributes,
-// and RDMA network namespace mode.
+// RDMA network namespace mode and PCIe errors, using per-vendor
+// expectations from rdma.QuirkForDriver, as well as the kernel version and build options
+// the host needs for containerized RDMA, the sysctls of multi-rail RoCE
+// hosts and whether the specs on disk still match the hardware.
 package doctor
 
 import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/olekukonko/tablewriter"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 
 	"github.com/Nativu5/rdma-cdi/pkg/rdma"
 	"github.com/Nativu5/rdma-cdi/pkg/types"
+	"github.com/Nativu5/rdma-cdi/pkg/utils"
 )
 
 // Severity levels for diagnostic checks.
@@ -24,18 +31,26 @@ const (
 	Pass Severity = "PASS"
 	Warn Severity = "WARN"
 	Fail Severity = "FAIL"
+	// Skip marks a check that could not run on the data at hand, such as
+	// a live-host check against a captured inventory.
+	Skip Severity = "SKIPPED"
 )
 
-// requiredKernelModules lists the kernel modules that must be loaded
-// for RDMA/CDI to function correctly.
-var requiredKernelModules = []string{"ib_core", "ib_uverbs", "ib_umad", "rdma_cm", "rdma_ucm"}
-
-// CheckResult represents one diagnostic check outcome.
+// CheckResult represents one diagnostic check outcome. Its JSON form is
+// versioned by SchemaVersion.
 type CheckResult struct {
+	SchemaVersion int `json:"schema_version"`
+	// ID is the stable name of the check, see CheckInfo; Check is its
+	// shorter legacy name.
+	ID       string   `json:"id"`
 	Check    string   `json:"check"`
 	Severity Severity `json:"severity"`
 	Message  string   `json:"message"`
-	Device   string   `json:"device,omitempty"`
+	// Device names the device checked, by PCI address until Report.Label
+	// renames it.
+	Device string `json:"device,omitempty"`
+	// PciAddress keeps the PCI address of a device renamed by Label.
+	PciAddress string `json:"pci_address,omitempty"`
 }
 
 // Report holds all diagnostic results for a device or the whole host.
@@ -45,8 +60,13 @@ type Report struct {
 	HasFail bool          `json:"-"`
 }
 
-// add appends a result and updates summary flags.
+// add appends a result, filling in its ID and schema version, and updates
+// summary flags.
 func (r *Report) add(cr CheckResult) {
+	if cr.ID == "" {
+		cr.ID = IDOf(cr.Check)
+	}
+	cr.SchemaVersion = SchemaVersion
 	r.Results = append(r.Results, cr)
 	switch cr.Severity {
 	case Warn:
@@ -56,6 +76,18 @@ func (r *Report) add(cr CheckResult) {
 	}
 }
 
+// skip adds a Skip result for each check of dev that needs the live host.
+func (r *Report) skip(dev *types.RdmaDevice, checks ...string) {
+	for _, check := range checks {
+		r.add(CheckResult{
+			Check:    check,
+			Severity: Skip,
+			Message:  "Needs the live host, which the inventory does not record",
+			Device:   dev.PciAddress,
+		})
+	}
+}
+
 // filtered returns results, optionally excluding PASS entries.
 func (r *Report) filtered(showPass bool) []CheckResult {
 	if showPass {
@@ -70,10 +102,135 @@ func (r *Report) filtered(showPass bool) []CheckResult {
 	return out
 }
 
-// DiagnoseDevice runs all checks on a single RDMA device.
-func DiagnoseDevice(dev *types.RdmaDevice) *Report {
+// Label names the devices of the results as f refers to them, e.g.
+// "mlx5_0 (0000:17:00.0)", keeping each PCI address in PciAddress.
+// Results of devices not in devices, such as spec files without hardware,
+// keep their name.
+func (r *Report) Label(devices []*types.RdmaDevice, f types.DeviceIDFormat) {
+	byPCI := make(map[string]*types.RdmaDevice, len(devices))
+	for _, dev := range devices {
+		byPCI[dev.PciAddress] = dev
+	}
+	for i := range r.Results {
+		cr := &r.Results[i]
+		if dev, ok := byPCI[cr.Device]; ok && cr.PciAddress == "" {
+			cr.PciAddress = cr.Device
+			cr.Device = dev.Label(f)
+		}
+	}
+}
+
+// Option customizes DiagnoseDevice.
+type Option func(*options)
+
+type options struct {
+	requiredDevices []string
+	sysfsRoot       string
+	devRoot         string
+	offline         bool
+	static          bool
+	netnsMode       string
+}
+
+// WithSysfsRoot sets where the host sysfs is mounted (e.g. "/host/sys")
+// for the kernel module checks. Defaults to rdma.DefaultSysfsRoot.
+func WithSysfsRoot(root string) Option {
+	return func(o *options) {
+		o.sysfsRoot = root
+	}
+}
+
+// WithDevRoot sets where the host /dev is mounted (e.g. "/host/dev") for
+// the device node checks. Defaults to rdma.DefaultDevRoot.
+func WithDevRoot(root string) Option {
+	return func(o *options) {
+		o.devRoot = root
+	}
+}
+
+// WithOffline restricts the checks to the sysfs and dev roots, for
+// snapshots of another host such as support bundles: link state is read
+// from <sysfs>/class/net/<if> instead of netlink on the running host.
+func WithOffline() Option {
+	return func(o *options) {
+		o.offline = true
+	}
+}
+
+// WithStatic restricts the checks to the facts recorded in the device,
+// for inventories captured on another host: checks that need its sysfs,
+// /dev, netlink or kernel log are reported as Skip instead of run.
+func WithStatic() Option {
+	return func(o *options) {
+		o.static = true
+	}
+}
+
+// WithExpectedNetnsMode sets the RDMA netns mode the host is meant to be
+// in, "shared" or "exclusive" (the default). Shared mode passes when it is
+// expected, and exclusive mode then warns.
+func WithExpectedNetnsMode(mode string) Option {
+	return func(o *options) {
+		o.netnsMode = mode
+	}
+}
+
+// WithRequiredDevices overrides the RDMA device types a device must expose
+// (default: the vendor quirk for the device's driver).
+func WithRequiredDevices(required ...string) Option {
+	return func(o *options) {
+		o.requiredDevices = append([]string{}, required...)
+	}
+}
+
+// DiagnoseDevice runs all checks on a single RDMA device. Expectations
+// (required device types, kernel modules, vendor device nodes) come from
+// the rdma.Quirk matching the device's bound driver.
+func DiagnoseDevice(dev *types.RdmaDevice, opts ...Option) *Report {
+	quirk := rdma.QuirkForDriver(dev.Driver)
+	o := options{
+		requiredDevices: quirk.RequiredDevices,
+		sysfsRoot:       rdma.DefaultSysfsRoot,
+		devRoot:         rdma.DefaultDevRoot,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
 	report := &Report{}
 
+	// Nothing else is known about a device whose discovery failed
+	if dev.Error != "" {
+		report.add(CheckResult{
+			Check:    "discovery",
+			Severity: Fail,
+			Message:  fmt.Sprintf("Discovery failed: %s", dev.Error),
+			Device:   dev.PciAddress,
+		})
+		return report
+	}
+
+	// 0. Identity, so reports name the adapter model and, where the
+	// platform tells, the card to pull from the chassis
+	if dev.Vendor != "" || dev.DeviceID != "" {
+		model := "not in the PCI IDs database"
+		if dev.ModelName != "" {
+			model = dev.ModelName
+		}
+		msg := fmt.Sprintf("%s (%s:%s)", model, dev.Vendor, dev.DeviceID)
+		if dev.BoardID != "" {
+			msg += ", board " + dev.BoardID
+		}
+		if dev.Slot != "" {
+			msg += ", in slot " + dev.Slot
+		}
+		report.add(CheckResult{
+			Check:    "device_model",
+			Severity: Pass,
+			Message:  msg,
+			Device:   dev.PciAddress,
+		})
+	}
+
 	// 1. RDMA character devices — presence and required types
 	if len(dev.RdmaDevices) == 0 {
 		report.add(CheckResult{
@@ -82,7 +239,7 @@ func DiagnoseDevice(dev *types.RdmaDevice) *Report {
 			Message:  "No RDMA character devices found",
 			Device:   dev.PciAddress,
 		})
-	} else if err := rdma.VerifyRdmaDevices(dev.RdmaDevices); err != nil {
+	} else if err := rdma.VerifyRequiredDevices(dev.RdmaDevices, o.requiredDevices); err != nil {
 		report.add(CheckResult{
 			Check:    "rdma_devices",
 			Severity: Fail,
@@ -98,18 +255,39 @@ func DiagnoseDevice(dev *types.RdmaDevice) *Report {
 		})
 	}
 
-	// 2. Kernel modules
-	checkKernelModules(report)
+	// 2. Device node numbers vs. sysfs
+	// 3. Kernel modules and vendor-specific device nodes
+	if o.static {
+		report.skip(dev, "device_nodes", "kernel_modules")
+		if len(quirk.ExtraDevNodes) > 0 {
+			report.skip(dev, "vendor_dev_nodes")
+		}
+	} else {
+		checkDeviceNodes(report, dev, o.devRoot)
+		checkKernelModules(report, quirk, o.sysfsRoot)
+		checkExtraDevNodes(report, dev, quirk, o.devRoot)
+	}
 
-	// 3. Network interface & link attributes
+	// 4. Network interface & link attributes
 	if dev.IfName != "" {
+		msg := fmt.Sprintf("Interface: %s", dev.IfName)
+		if len(dev.IfNames) > 1 {
+			msg = fmt.Sprintf("Interfaces: %s", strings.Join(dev.IfNames, ", "))
+		}
 		report.add(CheckResult{
 			Check:    "net_interface",
 			Severity: Pass,
-			Message:  fmt.Sprintf("Interface: %s", dev.IfName),
+			Message:  msg,
 			Device:   dev.PciAddress,
 		})
-		checkLinkAttrs(report, dev)
+		switch {
+		case o.static:
+			report.skip(dev, "link_attrs")
+		case o.offline:
+			checkSysfsLinkAttrs(report, dev, o.sysfsRoot)
+		default:
+			checkLinkAttrs(report, dev)
+		}
 	} else {
 		report.add(CheckResult{
 			Check:    "net_interface",
@@ -119,17 +297,78 @@ func DiagnoseDevice(dev *types.RdmaDevice) *Report {
 		})
 	}
 
-	// 4. RDMA netns mode
-	checkRdmaNetnsMode(report, dev.PciAddress)
+	// 5. RDMA netns mode
+	if o.static && dev.NetnsMode == "" {
+		report.skip(dev, "rdma_netns_mode")
+	} else {
+		checkRdmaNetnsMode(report, dev, o.offline || o.static, o.netnsMode)
+	}
+
+	// 6. PCIe errors
+	if o.static {
+		report.skip(dev, "pcie_errors")
+	} else {
+		checkAER(report, dev, o.sysfsRoot, o.offline)
+	}
 
 	return report
 }
 
-// checkKernelModules verifies that essential RDMA kernel modules are loaded.
-func checkKernelModules(report *Report) {
+// checkDeviceNodes compares each host device node's major:minor against the
+// numbers the kernel reports in sysfs. A mismatch means the node is stale,
+// typically left behind by a driver reload without udev recreating /dev.
+// Nodes are looked up under devRoot.
+func checkDeviceNodes(report *Report, dev *types.RdmaDevice, devRoot string) {
+	var checked int
+	var problems []string
+	for _, spec := range dev.DeviceSpecs {
+		if spec.Type != "c" {
+			continue
+		}
+		checked++
+
+		var st unix.Stat_t
+		if err := unix.Stat(utils.RebasePath(spec.HostPath, rdma.DefaultDevRoot, devRoot), &st); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", spec.HostPath, err))
+			continue
+		}
+		if st.Mode&unix.S_IFMT != unix.S_IFCHR {
+			problems = append(problems, fmt.Sprintf("%s is not a character device", spec.HostPath))
+			continue
+		}
+		major, minor := int64(unix.Major(uint64(st.Rdev))), int64(unix.Minor(uint64(st.Rdev)))
+		if major != spec.Major || minor != spec.Minor {
+			problems = append(problems, fmt.Sprintf("%s is %d:%d, kernel reports %d:%d",
+				spec.HostPath, major, minor, spec.Major, spec.Minor))
+		}
+	}
+
+	switch {
+	case checked == 0:
+		return // numbers unknown; nothing to compare
+	case len(problems) > 0:
+		report.add(CheckResult{
+			Check:    "device_nodes",
+			Severity: Fail,
+			Message:  fmt.Sprintf("Stale or missing device nodes (recreate them or re-trigger udev): %s", strings.Join(problems, "; ")),
+			Device:   dev.PciAddress,
+		})
+	default:
+		report.add(CheckResult{
+			Check:    "device_nodes",
+			Severity: Pass,
+			Message:  fmt.Sprintf("Device node numbers match sysfs (%d node(s))", checked),
+			Device:   dev.PciAddress,
+		})
+	}
+}
+
+// checkKernelModules verifies that the RDMA kernel modules the vendor
+// profile needs are loaded.
+func checkKernelModules(report *Report, quirk rdma.Quirk, sysfsRoot string) {
 	var missing []string
-	for _, mod := range requiredKernelModules {
-		path := fmt.Sprintf("/sys/module/%s", mod)
+	for _, mod := range quirk.KernelModules {
+		path := filepath.Join(sysfsRoot, "module", mod)
 		if _, err := os.Stat(path); os.IsNotExist(err) {
 			missing = append(missing, mod)
 		}
@@ -138,20 +377,52 @@ func checkKernelModules(report *Report) {
 		report.add(CheckResult{
 			Check:    "kernel_modules",
 			Severity: Fail,
-			Message:  fmt.Sprintf("Missing kernel modules: %s", strings.Join(missing, ", ")),
+			Message:  fmt.Sprintf("Missing kernel modules (%s profile): %s", quirk.Name, strings.Join(missing, ", ")),
 		})
 	} else {
 		report.add(CheckResult{
 			Check:    "kernel_modules",
 			Severity: Pass,
-			Message:  fmt.Sprintf("All required kernel modules loaded: %s", strings.Join(requiredKernelModules, ", ")),
+			Message:  fmt.Sprintf("All required kernel modules loaded (%s profile): %s", quirk.Name, strings.Join(quirk.KernelModules, ", ")),
 		})
 	}
 }
 
-// checkLinkAttrs uses netlink to inspect link state and encap type.
+// checkExtraDevNodes verifies vendor-specific device nodes under devRoot,
+// such as the hfi1_N nodes Omni-Path PSM2 needs next to the verbs devices.
+func checkExtraDevNodes(report *Report, dev *types.RdmaDevice, quirk rdma.Quirk, devRoot string) {
+	for _, pattern := range quirk.ExtraDevNodes {
+		matches, _ := filepath.Glob(filepath.Join(devRoot, pattern))
+		if len(matches) == 0 {
+			report.add(CheckResult{
+				Check:    "vendor_dev_nodes",
+				Severity: Warn,
+				Message:  fmt.Sprintf("No %s device nodes found (%s profile)", filepath.Join(devRoot, pattern), quirk.Name),
+				Device:   dev.PciAddress,
+			})
+			continue
+		}
+		report.add(CheckResult{
+			Check:    "vendor_dev_nodes",
+			Severity: Pass,
+			Message:  fmt.Sprintf("Vendor device nodes present (%s profile): %s", quirk.Name, strings.Join(matches, ", ")),
+			Device:   dev.PciAddress,
+		})
+	}
+}
+
+// linkByName and getAddresses look up the netdev and its IP addresses,
+// replaceable in tests.
+var (
+	linkByName   = netlink.LinkByName
+	getAddresses = rdma.GetAddresses
+)
+
+// checkLinkAttrs uses netlink to inspect link state and encap type. An
+// administratively down link, a missing carrier and a link without IP
+// addresses are reported separately, as each has a different fix.
 func checkLinkAttrs(report *Report, dev *types.RdmaDevice) {
-	link, err := netlink.LinkByName(dev.IfName)
+	link, err := linkByName(dev.IfName)
 	if err != nil {
 		report.add(CheckResult{
 			Check:    "link_attrs",
@@ -165,68 +436,161 @@ func checkLinkAttrs(report *Report, dev *types.RdmaDevice) {
 	attrs := link.Attrs()
 	dev.LinkType = attrs.EncapType
 
-	state := attrs.OperState.String()
-	if attrs.OperState == netlink.OperUp {
+	switch {
+	case attrs.Flags&net.FlagUp == 0:
 		report.add(CheckResult{
-			Check:    "link_state",
-			Severity: Pass,
-			Message:  fmt.Sprintf("Link %s is %s (encap: %s, MTU: %d)", dev.IfName, state, attrs.EncapType, attrs.MTU),
+			Check:    "link_admin_state",
+			Severity: Warn,
+			Message:  fmt.Sprintf("Link %s is administratively down; bring it up with 'ip link set %s up'", dev.IfName, dev.IfName),
 			Device:   dev.PciAddress,
 		})
-	} else {
+	case attrs.RawFlags&unix.IFF_LOWER_UP == 0:
+		report.add(CheckResult{
+			Check:    "link_carrier",
+			Severity: Warn,
+			Message:  fmt.Sprintf("Link %s has no carrier; check the cable and the switch port", dev.IfName),
+			Device:   dev.PciAddress,
+		})
+	default:
+		severity := Pass
+		if attrs.OperState != netlink.OperUp {
+			severity = Warn
+		}
 		report.add(CheckResult{
 			Check:    "link_state",
+			Severity: severity,
+			Message:  fmt.Sprintf("Link %s is %s (encap: %s, MTU: %d)", dev.IfName, attrs.OperState, attrs.EncapType, attrs.MTU),
+			Device:   dev.PciAddress,
+		})
+	}
+
+	if len(getAddresses(dev.IfName)) == 0 {
+		report.add(CheckResult{
+			Check:    "link_address",
 			Severity: Warn,
-			Message:  fmt.Sprintf("Link %s is %s (encap: %s, MTU: %d)", dev.IfName, state, attrs.EncapType, attrs.MTU),
+			Message:  fmt.Sprintf("Link %s has no IP address; RoCE and rdma_cm connections need one", dev.IfName),
+			Device:   dev.PciAddress,
+		})
+	} else {
+		report.add(CheckResult{
+			Check:    "link_address",
+			Severity: Pass,
+			Message:  fmt.Sprintf("Link %s has an IP address", dev.IfName),
 			Device:   dev.PciAddress,
 		})
 	}
 }
 
-// checkRdmaNetnsMode reads RDMA netns mode from sysfs.
-func checkRdmaNetnsMode(report *Report, pciAddr string) {
-	data, err := os.ReadFile("/sys/module/rdma_cm/parameters/net_ns_mode")
+// checkSysfsLinkAttrs is checkLinkAttrs for offline snapshots, reading the
+// link state, carrier and MTU captured under sysfsRoot. IP addresses are
+// not part of a snapshot and are not checked.
+func checkSysfsLinkAttrs(report *Report, dev *types.RdmaDevice, sysfsRoot string) {
+	netDir := filepath.Join(sysfsRoot, "class/net", dev.IfName)
+	state, err := os.ReadFile(filepath.Join(netDir, "operstate"))
 	if err != nil {
-		data, err = os.ReadFile("/sys/module/ib_core/parameters/netns_mode")
-		if err != nil {
+		report.add(CheckResult{
+			Check:    "link_attrs",
+			Severity: Warn,
+			Message:  fmt.Sprintf("Link state of %s not captured", dev.IfName),
+			Device:   dev.PciAddress,
+		})
+		return
+	}
+	mtu, _ := os.ReadFile(filepath.Join(netDir, "mtu"))
+
+	// carrier is only readable while the link is administratively up.
+	if carrier, err := os.ReadFile(filepath.Join(netDir, "carrier")); err == nil &&
+		strings.TrimSpace(string(carrier)) == "0" {
+		report.add(CheckResult{
+			Check:    "link_carrier",
+			Severity: Warn,
+			Message:  fmt.Sprintf("Link %s has no carrier; check the cable and the switch port", dev.IfName),
+			Device:   dev.PciAddress,
+		})
+		return
+	}
+
+	severity := Warn
+	if strings.TrimSpace(string(state)) == "up" {
+		severity = Pass
+	}
+	report.add(CheckResult{
+		Check:    "link_state",
+		Severity: severity,
+		Message: fmt.Sprintf("Link %s is %s (encap: %s, MTU: %s)", dev.IfName,
+			strings.TrimSpace(string(state)), dev.LinkType, strings.TrimSpace(string(mtu))),
+		Device: dev.PciAddress,
+	})
+}
+
+// getNetnsMode queries the RDMA netns mode, replaceable in tests.
+var getNetnsMode = rdma.GetNetnsMode
+
+// checkRdmaNetnsMode checks the RDMA subsystem netns mode, as discovered
+// or queried via RDMA netlink on the running host, against expected.
+func checkRdmaNetnsMode(report *Report, dev *types.RdmaDevice, offline bool, expected string) {
+	mode := dev.NetnsMode
+	if mode == "" && !offline {
+		var err error
+		if mode, err = getNetnsMode(); err != nil {
 			report.add(CheckResult{
 				Check:    "rdma_netns_mode",
 				Severity: Warn,
-				Message:  "Cannot read RDMA netns mode (sysfs path not available)",
-				Device:   pciAddr,
+				Message:  fmt.Sprintf("Cannot read RDMA netns mode: %v", err),
+				Device:   dev.PciAddress,
 			})
 			return
 		}
 	}
 
-	mode := strings.TrimSpace(string(data))
-	switch mode {
-	case "exclusive", "1", "Y":
+	switch {
+	case mode == rdma.NetnsModeExclusive && expected == rdma.NetnsModeShared:
+		report.add(CheckResult{
+			Check:    "rdma_netns_mode",
+			Severity: Warn,
+			Message:  "RDMA netns mode: exclusive, but shared is expected — containers only see a device moved to their network namespace; run 'rdma-cdi netns set shared'",
+			Device:   dev.PciAddress,
+		})
+	case mode == rdma.NetnsModeShared && expected == rdma.NetnsModeShared:
+		report.add(CheckResult{
+			Check:    "rdma_netns_mode",
+			Severity: Pass,
+			Message:  "RDMA netns mode: shared, as expected",
+			Device:   dev.PciAddress,
+		})
+	case mode == rdma.NetnsModeExclusive:
 		report.add(CheckResult{
 			Check:    "rdma_netns_mode",
 			Severity: Pass,
-			Message:  fmt.Sprintf("RDMA netns mode: exclusive (%s)", mode),
-			Device:   pciAddr,
+			Message:  "RDMA netns mode: exclusive",
+			Device:   dev.PciAddress,
 		})
-	case "shared", "0", "N":
+	case mode == rdma.NetnsModeShared:
 		report.add(CheckResult{
 			Check:    "rdma_netns_mode",
 			Severity: Warn,
-			Message:  fmt.Sprintf("RDMA netns mode: shared (%s) — containers may not isolate RDMA traffic", mode),
-			Device:   pciAddr,
+			Message:  "RDMA netns mode: shared — containers may not isolate RDMA traffic; run 'rdma-cdi netns set exclusive'",
+			Device:   dev.PciAddress,
+		})
+	case mode == "":
+		report.add(CheckResult{
+			Check:    "rdma_netns_mode",
+			Severity: Warn,
+			Message:  "RDMA netns mode was not recorded",
+			Device:   dev.PciAddress,
 		})
 	default:
 		report.add(CheckResult{
 			Check:    "rdma_netns_mode",
 			Severity: Warn,
 			Message:  fmt.Sprintf("Unknown RDMA netns mode: %q", mode),
-			Device:   pciAddr,
+			Device:   dev.PciAddress,
 		})
 	}
 }
 
 // PrintTable renders the diagnostic report as a table.
-// When showPass is false, only WARN/FAIL results are shown.
+// When showPass is false, PASS results are left out.
 func PrintTable(w io.Writer, report *Report, showPass bool) {
 	results := report.filtered(showPass)
 	if len(results) == 0 {
@@ -242,6 +606,8 @@ func PrintTable(w io.Writer, report *Report, showPass bool) {
 			marker = "!"
 		case Fail:
 			marker = "✗"
+		case Skip:
+			marker = "-"
 		}
 		dev := r.Device
 		if dev == "" {
@@ -254,7 +620,7 @@ func PrintTable(w io.Writer, report *Report, showPass bool) {
 }
 
 // PrintJSON renders the diagnostic report as JSON.
-// When showPass is false, only WARN/FAIL results are included.
+// When showPass is false, PASS results are left out.
 func PrintJSON(w io.Writer, report *Report, showPass bool) error {
 	results := report.filtered(showPass)
 	if results == nil {
@@ -265,6 +631,16 @@ func PrintJSON(w io.Writer, report *Report, showPass bool) error {
 	return enc.Encode(results)
 }
 
+// NewReport returns a report of results, for checks run outside this
+// package such as the bench self-test.
+func NewReport(results ...CheckResult) *Report {
+	r := &Report{}
+	for _, cr := range results {
+		r.add(cr)
+	}
+	return r
+}
+
 // MergeReports combines multiple per-device reports into one.
 func MergeReports(reports ...*Report) *Report {
 	merged := &Report{}
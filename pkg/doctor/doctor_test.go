@@ -3,9 +3,18 @@ package doctor
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/Nativu5/rdma-cdi/pkg/rdma"
 	"github.com/Nativu5/rdma-cdi/pkg/types"
 )
 
@@ -75,6 +84,75 @@ func TestDiagnoseDevice_NoCharDevices(t *testing.T) {
 	}
 }
 
+func TestDiagnoseDevice_DiscoveryFailed(t *testing.T) {
+	dev := &types.RdmaDevice{PciAddress: "0000:17:00.0", Error: "discovery of 0000:17:00.0 timed out after 30s"}
+	report := DiagnoseDevice(dev)
+
+	if len(report.Results) != 1 || report.Results[0].Check != "discovery" || report.Results[0].Severity != Fail {
+		t.Errorf("expected a single discovery FAIL, got %+v", report.Results)
+	}
+}
+
+func TestDiagnoseDevice_Identity(t *testing.T) {
+	dev := fullDevice()
+	dev.Vendor, dev.DeviceID, dev.ModelName = "15b3", "1017", "Mellanox ConnectX-5"
+	dev.BoardID, dev.Slot = "MT_0000000010", "3"
+	report := DiagnoseDevice(dev)
+
+	want := "Mellanox ConnectX-5 (15b3:1017), board MT_0000000010, in slot 3"
+	for _, r := range report.Results {
+		if r.Check == "device_model" {
+			if r.Message != want {
+				t.Errorf("device_model message = %q, want %q", r.Message, want)
+			}
+			return
+		}
+	}
+	t.Error("expected a device_model result")
+}
+
+func TestDiagnoseDevice_Static(t *testing.T) {
+	orig := getNetnsMode
+	t.Cleanup(func() { getNetnsMode = orig })
+	getNetnsMode = func() (string, error) {
+		t.Error("the netns mode of this host should not be read for an inventory")
+		return "", nil
+	}
+
+	dev := fullDevice()
+	dev.NetnsMode = ""
+	report := DiagnoseDevice(dev, WithStatic(), WithSysfsRoot("/nonexistent"), WithDevRoot("/nonexistent"))
+	if report.HasFail || report.HasWarn {
+		t.Errorf("checks needing the live host should not fail: %+v", report.Results)
+	}
+	got := make(map[string]Severity)
+	for _, r := range report.Results {
+		got[r.Check] = r.Severity
+	}
+	for check, want := range map[string]Severity{
+		"rdma_devices":    Pass,
+		"net_interface":   Pass,
+		"device_nodes":    Skip,
+		"kernel_modules":  Skip,
+		"link_attrs":      Skip,
+		"rdma_netns_mode": Skip,
+		"pcie_errors":     Skip,
+	} {
+		if got[check] != want {
+			t.Errorf("%s = %q, want %s", check, got[check], want)
+		}
+	}
+
+	// A recorded netns mode is judged
+	dev.NetnsMode = "shared"
+	report = DiagnoseDevice(dev, WithStatic(), WithExpectedNetnsMode("shared"))
+	for _, r := range report.Results {
+		if r.Check == "rdma_netns_mode" && r.Severity != Pass {
+			t.Errorf("recorded netns mode = %+v, want PASS", r)
+		}
+	}
+}
+
 func TestDiagnoseDevice_NoInterface(t *testing.T) {
 	dev := fullDevice()
 	dev.IfName = ""
@@ -107,6 +185,68 @@ func TestDiagnoseDevice_MissingRequiredDevices(t *testing.T) {
 	}
 }
 
+func TestDiagnoseDevice_RequiredDevicesPolicy(t *testing.T) {
+	dev := fullDevice()
+	dev.RdmaDevices = []string{"/dev/infiniband/rdma_cm", "/dev/infiniband/uverbs0"}
+	report := DiagnoseDevice(dev, WithRequiredDevices("uverbs", "rdma_cm"))
+
+	for _, r := range report.Results {
+		if r.Check == "rdma_devices" && r.Severity != Pass {
+			t.Errorf("RoCE-only device should pass with relaxed policy, got %s: %s", r.Severity, r.Message)
+		}
+	}
+}
+
+func TestDiagnoseDevice_VendorQuirk(t *testing.T) {
+	dev := fullDevice()
+	dev.Driver = "ice"
+	dev.RdmaDevices = []string{"/dev/infiniband/rdma_cm", "/dev/infiniband/uverbs0"}
+	report := DiagnoseDevice(dev)
+
+	for _, r := range report.Results {
+		switch r.Check {
+		case "rdma_devices":
+			if r.Severity != Pass {
+				t.Errorf("irdma device without umad should pass, got %s: %s", r.Severity, r.Message)
+			}
+		case "kernel_modules":
+			if !strings.Contains(r.Message, "irdma profile") || strings.Contains(r.Message, "rdma_ucm") {
+				t.Errorf("kernel_modules should use the irdma module list, got %q", r.Message)
+			}
+		}
+	}
+}
+
+func TestCheckExtraDevNodes(t *testing.T) {
+	devRoot := t.TempDir()
+
+	dev := fullDevice()
+	dev.Driver = "hfi1"
+	quirk := rdma.QuirkForDriver(dev.Driver)
+
+	report := &Report{}
+	checkExtraDevNodes(report, dev, quirk, devRoot)
+	if len(report.Results) != 1 || report.Results[0].Severity != Warn {
+		t.Fatalf("expected WARN without hfi1 nodes, got %+v", report.Results)
+	}
+
+	if err := os.WriteFile(filepath.Join(devRoot, "hfi1_0"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	report = &Report{}
+	checkExtraDevNodes(report, dev, quirk, devRoot)
+	if len(report.Results) != 1 || report.Results[0].Severity != Pass {
+		t.Errorf("expected PASS with hfi1_0 present, got %+v", report.Results)
+	}
+
+	// Mellanox has no vendor-specific nodes to check
+	report = &Report{}
+	checkExtraDevNodes(report, fullDevice(), rdma.QuirkForDriver("mlx5_core"), devRoot)
+	if len(report.Results) != 0 {
+		t.Errorf("mlx5 profile should add no vendor node checks, got %+v", report.Results)
+	}
+}
+
 func TestDiagnoseDevice_KernelModulesCheck(t *testing.T) {
 	dev := fullDevice()
 	report := DiagnoseDevice(dev)
@@ -122,6 +262,185 @@ func TestDiagnoseDevice_KernelModulesCheck(t *testing.T) {
 	}
 }
 
+func TestCheckKernelModules_SysfsRoot(t *testing.T) {
+	root := t.TempDir()
+	quirk := rdma.QuirkForDriver("mlx5_core")
+	for _, mod := range quirk.KernelModules {
+		if err := os.MkdirAll(filepath.Join(root, "module", mod), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report := &Report{}
+	checkKernelModules(report, quirk, root)
+	if len(report.Results) != 1 || report.Results[0].Severity != Pass {
+		t.Errorf("expected PASS with all modules under %s, got %+v", root, report.Results)
+	}
+
+	os.Remove(filepath.Join(root, "module", quirk.KernelModules[0]))
+	report = &Report{}
+	checkKernelModules(report, quirk, root)
+	if len(report.Results) != 1 || report.Results[0].Severity != Fail ||
+		!strings.Contains(report.Results[0].Message, quirk.KernelModules[0]) {
+		t.Errorf("expected FAIL naming %s, got %+v", quirk.KernelModules[0], report.Results)
+	}
+}
+
+func TestCheckSysfsLinkAttrs(t *testing.T) {
+	root := t.TempDir()
+	dev := fullDevice()
+	netDir := filepath.Join(root, "class/net", dev.IfName)
+
+	report := &Report{}
+	checkSysfsLinkAttrs(report, dev, root)
+	if len(report.Results) != 1 || report.Results[0].Severity != Warn {
+		t.Errorf("expected WARN without captured attributes, got %+v", report.Results)
+	}
+
+	if err := os.MkdirAll(netDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for attr, value := range map[string]string{"operstate": "up\n", "mtu": "9000\n"} {
+		if err := os.WriteFile(filepath.Join(netDir, attr), []byte(value), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report = &Report{}
+	checkSysfsLinkAttrs(report, dev, root)
+	if len(report.Results) != 1 || report.Results[0].Severity != Pass ||
+		!strings.Contains(report.Results[0].Message, "MTU: 9000") {
+		t.Errorf("expected PASS with MTU 9000, got %+v", report.Results)
+	}
+}
+
+func TestCheckLinkAttrs_DistinctProblems(t *testing.T) {
+	origLink, origAddrs := linkByName, getAddresses
+	t.Cleanup(func() { linkByName, getAddresses = origLink, origAddrs })
+
+	tests := []struct {
+		name     string
+		flags    net.Flags
+		rawFlags uint32
+		oper     netlink.LinkOperState
+		addrs    []string
+		want     map[string]Severity
+	}{
+		{"healthy", net.FlagUp, unix.IFF_UP | unix.IFF_LOWER_UP, netlink.OperUp, []string{"192.0.2.1/24"},
+			map[string]Severity{"link_state": Pass, "link_address": Pass}},
+		{"admin down", 0, 0, netlink.OperDown, []string{"192.0.2.1/24"},
+			map[string]Severity{"link_admin_state": Warn, "link_address": Pass}},
+		{"no carrier", net.FlagUp, unix.IFF_UP, netlink.OperDown, []string{"192.0.2.1/24"},
+			map[string]Severity{"link_carrier": Warn, "link_address": Pass}},
+		{"no address", net.FlagUp, unix.IFF_UP | unix.IFF_LOWER_UP, netlink.OperUp, nil,
+			map[string]Severity{"link_state": Pass, "link_address": Warn}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			linkByName = func(name string) (netlink.Link, error) {
+				return &netlink.Device{LinkAttrs: netlink.LinkAttrs{
+					Name: name, Flags: tt.flags, RawFlags: tt.rawFlags, OperState: tt.oper,
+					EncapType: "ether", MTU: 1500,
+				}}, nil
+			}
+			getAddresses = func(...string) []string { return tt.addrs }
+
+			report := &Report{}
+			checkLinkAttrs(report, fullDevice())
+			got := map[string]Severity{}
+			for _, r := range report.Results {
+				got[r.Check] = r.Severity
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected checks %v, got %+v", tt.want, report.Results)
+			}
+			for check, severity := range tt.want {
+				if got[check] != severity {
+					t.Errorf("%s: expected %s, got %+v", check, severity, report.Results)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckSysfsLinkAttrs_NoCarrier(t *testing.T) {
+	root := t.TempDir()
+	dev := fullDevice()
+	netDir := filepath.Join(root, "class/net", dev.IfName)
+	if err := os.MkdirAll(netDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for attr, value := range map[string]string{"operstate": "down\n", "carrier": "0\n", "mtu": "1500\n"} {
+		if err := os.WriteFile(filepath.Join(netDir, attr), []byte(value), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report := &Report{}
+	checkSysfsLinkAttrs(report, dev, root)
+	if len(report.Results) != 1 || report.Results[0].Check != "link_carrier" || report.Results[0].Severity != Warn {
+		t.Errorf("expected link_carrier WARN, got %+v", report.Results)
+	}
+}
+
+func TestCheckDeviceNodes_DevRoot(t *testing.T) {
+	// /dev/null seen through a dev root that is the host's /dev again
+	root := t.TempDir()
+	if err := os.Symlink("/dev/null", filepath.Join(root, "null")); err != nil {
+		t.Fatal(err)
+	}
+	dev := fullDevice()
+	dev.DeviceSpecs = []types.DeviceSpec{{HostPath: "/dev/null", Type: "c", Major: 1, Minor: 3}}
+	report := &Report{}
+	checkDeviceNodes(report, dev, root)
+	if len(report.Results) != 1 || report.Results[0].Severity != Pass {
+		t.Errorf("expected PASS via dev root, got %+v", report.Results)
+	}
+
+	report = &Report{}
+	checkDeviceNodes(report, dev, t.TempDir())
+	if len(report.Results) != 1 || report.Results[0].Severity != Fail {
+		t.Errorf("expected FAIL for a node missing under the dev root, got %+v", report.Results)
+	}
+}
+
+func TestCheckDeviceNodes_Stale(t *testing.T) {
+	dev := fullDevice()
+	dev.DeviceSpecs = []types.DeviceSpec{
+		// /dev/null is 1:3 everywhere; pretend sysfs reports something else
+		{HostPath: "/dev/null", Type: "c", Major: 231, Minor: 192},
+	}
+	report := &Report{}
+	checkDeviceNodes(report, dev, rdma.DefaultDevRoot)
+
+	if len(report.Results) != 1 || report.Results[0].Severity != Fail {
+		t.Fatalf("expected one FAIL result, got %+v", report.Results)
+	}
+	if !strings.Contains(report.Results[0].Message, "1:3") {
+		t.Errorf("message should show on-disk numbers, got %q", report.Results[0].Message)
+	}
+}
+
+func TestCheckDeviceNodes_Match(t *testing.T) {
+	dev := fullDevice()
+	dev.DeviceSpecs = []types.DeviceSpec{{HostPath: "/dev/null", Type: "c", Major: 1, Minor: 3}}
+	report := &Report{}
+	checkDeviceNodes(report, dev, rdma.DefaultDevRoot)
+
+	if len(report.Results) != 1 || report.Results[0].Severity != Pass {
+		t.Errorf("expected one PASS result, got %+v", report.Results)
+	}
+}
+
+func TestCheckDeviceNodes_Unknown(t *testing.T) {
+	dev := fullDevice()
+	dev.DeviceSpecs = []types.DeviceSpec{{HostPath: "/dev/infiniband/uverbs0"}}
+	report := &Report{}
+	checkDeviceNodes(report, dev, rdma.DefaultDevRoot)
+
+	if len(report.Results) != 0 {
+		t.Errorf("specs without numbers should be skipped, got %+v", report.Results)
+	}
+}
+
 // MergeReports tests
 
 func TestMergeReports(t *testing.T) {
@@ -144,6 +463,27 @@ func TestMergeReports(t *testing.T) {
 	}
 }
 
+func TestReport_Label(t *testing.T) {
+	devices := []*types.RdmaDevice{{PciAddress: "0000:17:00.0", IbDev: "mlx5_0", IfName: "ens1f0"}}
+	report := NewReport(
+		CheckResult{Check: "rdma_devices", Severity: Pass, Device: "0000:17:00.0"},
+		CheckResult{Check: "spec_drift", Severity: Warn, Device: "0000:99:00.0"},
+		CheckResult{Check: "kernel_version", Severity: Pass},
+	)
+	report.Label(devices, types.DeviceIDAuto)
+	// Results already labelled are left alone
+	report.Label(devices, types.DeviceIDIbdev)
+
+	want := NewReport(
+		CheckResult{Check: "rdma_devices", Severity: Pass, Device: "mlx5_0 (0000:17:00.0)", PciAddress: "0000:17:00.0"},
+		CheckResult{Check: "spec_drift", Severity: Warn, Device: "0000:99:00.0"},
+		CheckResult{Check: "kernel_version", Severity: Pass},
+	).Results
+	if !reflect.DeepEqual(report.Results, want) {
+		t.Errorf("Label() = %+v, want %+v", report.Results, want)
+	}
+}
+
 func TestMergeReports_WithFail(t *testing.T) {
 	r1 := &Report{}
 	r1.add(CheckResult{Check: "a", Severity: Pass})
@@ -273,3 +613,38 @@ func TestSeverityValues(t *testing.T) {
 		t.Errorf("Fail = %q, want FAIL", Fail)
 	}
 }
+
+func TestCheckRdmaNetnsMode(t *testing.T) {
+	orig := getNetnsMode
+	t.Cleanup(func() { getNetnsMode = orig })
+	getNetnsMode = func() (string, error) { return "", errors.New("netlink unavailable") }
+
+	tests := []struct {
+		name     string
+		mode     string
+		offline  bool
+		expected string
+		severity Severity
+		message  string
+	}{
+		{"exclusive", "exclusive", false, "", Pass, "exclusive"},
+		{"shared suggests the fix", "shared", false, "", Warn, "rdma-cdi netns set exclusive"},
+		{"shared expected", "shared", false, "shared", Pass, "as expected"},
+		{"exclusive when shared expected", "exclusive", false, "shared", Warn, "rdma-cdi netns set shared"},
+		{"query fails", "", false, "", Warn, "netlink unavailable"},
+		{"offline not recorded", "", true, "", Warn, "not recorded"},
+		{"unknown", "bogus", false, "", Warn, "Unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dev := fullDevice()
+			dev.NetnsMode = tt.mode
+			report := &Report{}
+			checkRdmaNetnsMode(report, dev, tt.offline, tt.expected)
+			if len(report.Results) != 1 || report.Results[0].Severity != tt.severity ||
+				!strings.Contains(report.Results[0].Message, tt.message) {
+				t.Errorf("got %+v, want %s containing %q", report.Results, tt.severity, tt.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package doctor
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Nativu5/rdma-cdi/pkg/cdi"
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// DiagnoseSpecs compares the specs this tool wrote in dirs against devices,
+// the result of a full discovery, and warns about drift: spec devices
+// whose hardware is gone, spec device nodes the hardware no longer has
+// (e.g. after uverbs renumbering), and devices no spec covers. Spec node
+// sets may be a subset of the hardware's, as --device-types and
+// --rdma-cm shared produce. Nothing is reported when no managed spec exists.
+func DiagnoseSpecs(dirs []string, devices []*types.RdmaDevice) *Report {
+	report := &Report{}
+	paths, err := cdi.FindManagedSpecs(dirs)
+	if err != nil {
+		report.add(CheckResult{
+			Check:    "spec_drift",
+			Severity: Warn,
+			Message:  fmt.Sprintf("Cannot list CDI specs: %v", err),
+		})
+		return report
+	}
+	if len(paths) == 0 {
+		return report
+	}
+
+	hardware := make(map[string]*types.RdmaDevice, len(devices))
+	for _, dev := range devices {
+		hardware[dev.PciAddress] = dev
+	}
+	covered := make(map[string]bool)
+	var checked int
+
+	for _, p := range paths {
+		specDevices, meta, err := cdi.ReadSpec(p)
+		if err != nil {
+			report.add(CheckResult{
+				Check:    "spec_drift",
+				Severity: Warn,
+				Message:  fmt.Sprintf("Cannot read %s: %v", p, err),
+			})
+			continue
+		}
+		for _, sd := range specDevices {
+			if sd.PciAddress == cdi.RdmaCMDeviceName {
+				continue // node-global, not tied to a PCI device
+			}
+			checked++
+			dev, ok := hardware[sd.PciAddress]
+			if !ok {
+				report.add(CheckResult{
+					Check:    "spec_drift",
+					Severity: Warn,
+					Message:  fmt.Sprintf("%s=%s in %s has no matching RDMA device (run rdma-cdi cleanup --orphans)", meta.Kind, sd.PciAddress, p),
+					Device:   sd.PciAddress,
+				})
+				continue
+			}
+			covered[sd.PciAddress] = true
+			if stale := staleNodes(sd, dev); len(stale) > 0 {
+				report.add(CheckResult{
+					Check:    "spec_drift",
+					Severity: Warn,
+					Message: fmt.Sprintf("%s=%s in %s lists device nodes the hardware no longer has: %s (regenerate the spec)",
+						meta.Kind, sd.PciAddress, p, strings.Join(stale, ", ")),
+					Device: sd.PciAddress,
+				})
+			}
+		}
+	}
+
+	for _, dev := range devices {
+		if !covered[dev.PciAddress] {
+			report.add(CheckResult{
+				Check:    "spec_drift",
+				Severity: Warn,
+				Message:  "No CDI spec covers this device (run rdma-cdi generate)",
+				Device:   dev.PciAddress,
+			})
+		}
+	}
+
+	if !report.HasWarn {
+		report.add(CheckResult{
+			Check:    "spec_drift",
+			Severity: Pass,
+			Message:  fmt.Sprintf("%d spec file(s) match the hardware (%d device(s))", len(paths), checked),
+		})
+	}
+	return report
+}
+
+// DiagnoseSpecIntegrity checks the specs this tool wrote in dirs against
+// the checksums they were written with (see cdi.VerifySpec): an edited
+// spec is a warning, since it may be deliberate, and a corrupt one a
+// failure, since container runtimes will reject it. state may be nil.
+// Nothing is reported when no managed spec exists.
+func DiagnoseSpecIntegrity(dirs []string, state *cdi.State) *Report {
+	report := &Report{}
+	paths, err := cdi.FindManagedSpecs(dirs)
+	if err != nil {
+		report.add(CheckResult{
+			Check:    "spec_integrity",
+			Severity: Warn,
+			Message:  fmt.Sprintf("Cannot list CDI specs: %v", err),
+		})
+		return report
+	}
+	if len(paths) == 0 {
+		return report
+	}
+
+	var intact, unverified int
+	for _, p := range paths {
+		res, err := cdi.VerifySpec(p, state)
+		switch {
+		case err != nil:
+			report.add(CheckResult{
+				Check:    "spec_integrity",
+				Severity: Warn,
+				Message:  fmt.Sprintf("Cannot read %s: %v", p, err),
+			})
+		case res.Status == cdi.IntegrityEdited:
+			report.add(CheckResult{
+				Check:    "spec_integrity",
+				Severity: Warn,
+				Message:  fmt.Sprintf("%s was changed since rdma-cdi wrote it: %s (regenerate it unless the edit is intended)", p, res.Detail),
+			})
+		case res.Status == cdi.IntegrityCorrupt:
+			report.add(CheckResult{
+				Check:    "spec_integrity",
+				Severity: Fail,
+				Message:  fmt.Sprintf("%s is corrupt: %s (regenerate it)", p, res.Detail),
+			})
+		case res.Status == cdi.IntegrityUnverified:
+			unverified++
+		default:
+			intact++
+		}
+	}
+
+	if !report.HasWarn && !report.HasFail {
+		msg := fmt.Sprintf("%d spec file(s) match their checksum", intact)
+		if unverified > 0 {
+			msg += fmt.Sprintf(", %d without one (regenerate to add it)", unverified)
+		}
+		report.add(CheckResult{Check: "spec_integrity", Severity: Pass, Message: msg})
+	}
+	return report
+}
+
+// DiagnoseCacheLoad loads the specs under dirs into a CDI cache, as
+// container runtimes do, and checks each spec this tool wrote there: the
+// cache must report no errors for it, and each of its devices must resolve,
+// from that spec, to concrete edits. This catches what validating a spec
+// alone misses, such as names the cache rejects or devices defined twice.
+// With inject, devices are also injected into a container spec, which
+// needs their device nodes, so only on the host itself (see
+// cdi.LoadInCache). Nothing is reported when no managed spec exists.
+func DiagnoseCacheLoad(dirs []string, inject bool) *Report {
+	report := &Report{}
+	paths, err := cdi.FindManagedSpecs(dirs)
+	if err != nil {
+		report.add(CheckResult{
+			Check:    "spec_cache_load",
+			Severity: Warn,
+			Message:  fmt.Sprintf("Cannot list CDI specs: %v", err),
+		})
+		return report
+	}
+	if len(paths) == 0 {
+		return report
+	}
+
+	load, err := cdi.LoadInCache(dirs, paths, inject)
+	if err != nil {
+		report.add(CheckResult{
+			Check:    "spec_cache_load",
+			Severity: Fail,
+			Message:  fmt.Sprintf("Cannot load the specs: %v", err),
+		})
+		return report
+	}
+	for _, p := range paths {
+		for _, err := range load.Errors[filepath.Clean(p)] {
+			report.add(CheckResult{
+				Check:    "spec_cache_load",
+				Severity: Fail,
+				Message:  fmt.Sprintf("The CDI cache rejects %s: %v", p, err),
+			})
+		}
+	}
+	for _, d := range load.Devices {
+		switch {
+		case d.ResolvedFrom == "":
+			report.add(CheckResult{
+				Check:    "spec_cache_load",
+				Severity: Fail,
+				Message:  fmt.Sprintf("%s from %s does not resolve in the CDI cache", d.Name, d.Spec),
+			})
+		case d.ResolvedFrom != d.Spec:
+			report.add(CheckResult{
+				Check:    "spec_cache_load",
+				Severity: Warn,
+				Message:  fmt.Sprintf("%s resolves to %s, which shadows %s", d.Name, d.ResolvedFrom, d.Spec),
+			})
+		case d.Edits == 0:
+			report.add(CheckResult{
+				Check:    "spec_cache_load",
+				Severity: Fail,
+				Message:  fmt.Sprintf("%s in %s resolves to no container edits", d.Name, d.Spec),
+			})
+		case d.InjectErr != nil:
+			report.add(CheckResult{
+				Check:    "spec_cache_load",
+				Severity: Fail,
+				Message:  fmt.Sprintf("%s in %s cannot be injected: %v", d.Name, d.Spec, d.InjectErr),
+			})
+		}
+	}
+
+	if !report.HasWarn && !report.HasFail {
+		report.add(CheckResult{
+			Check:    "spec_cache_load",
+			Severity: Pass,
+			Message:  fmt.Sprintf("%d device(s) of %d spec file(s) resolve in the CDI cache", len(load.Devices), len(paths)),
+		})
+	}
+	return report
+}
+
+// staleNodes returns the host device nodes in the spec device that the
+// discovered device does not have.
+func staleNodes(spec types.RdmaDevice, dev *types.RdmaDevice) []string {
+	have := make([]string, 0, len(dev.DeviceSpecs))
+	for _, s := range dev.DeviceSpecs {
+		have = append(have, s.HostPath)
+	}
+	var stale []string
+	for _, s := range spec.DeviceSpecs {
+		if !slices.Contains(have, s.HostPath) {
+			stale = append(stale, s.HostPath)
+		}
+	}
+	return stale
+}
@@ -0,0 +1,166 @@
+package doctor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Nativu5/rdma-cdi/pkg/cdi"
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+func driftDevice(pci string, nodes ...string) *types.RdmaDevice {
+	dev := &types.RdmaDevice{PciAddress: pci}
+	for _, n := range nodes {
+		dev.DeviceSpecs = append(dev.DeviceSpecs, types.DeviceSpec{HostPath: n, ContainerPath: n, Permissions: "rw"})
+	}
+	return dev
+}
+
+func TestDiagnoseSpecs(t *testing.T) {
+	dir := t.TempDir()
+	for name, dev := range map[string]*types.RdmaDevice{
+		"a": driftDevice("0000:17:00.0", "/dev/infiniband/uverbs0"),
+		"b": driftDevice("0000:18:00.0", "/dev/infiniband/uverbs1"),
+	} {
+		if err := cdi.CreateCDISpec("rdma", name, []types.RdmaDevice{*dev}, dir, "yaml"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		devices []*types.RdmaDevice
+		want    map[string]string // device -> message fragment
+	}{
+		{"in_sync", []*types.RdmaDevice{
+			driftDevice("0000:17:00.0", "/dev/infiniband/uverbs0", "/dev/infiniband/rdma_cm"),
+			driftDevice("0000:18:00.0", "/dev/infiniband/uverbs1"),
+		}, nil},
+		{"drift", []*types.RdmaDevice{
+			driftDevice("0000:17:00.0", "/dev/infiniband/uverbs2"),
+			driftDevice("0000:41:00.0", "/dev/infiniband/uverbs3"),
+		}, map[string]string{
+			"0000:17:00.0": "/dev/infiniband/uverbs0",
+			"0000:18:00.0": "no matching RDMA device",
+			"0000:41:00.0": "No CDI spec covers",
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			report := DiagnoseSpecs([]string{dir}, tc.devices)
+			if tc.want == nil {
+				if report.HasWarn || len(report.Results) != 1 || report.Results[0].Severity != Pass {
+					t.Errorf("expected a single PASS, got %+v", report.Results)
+				}
+				return
+			}
+			if len(report.Results) != len(tc.want) {
+				t.Fatalf("expected %d warnings, got %+v", len(tc.want), report.Results)
+			}
+			for _, r := range report.Results {
+				if r.Severity != Warn || r.Check != "spec_drift" || !strings.Contains(r.Message, tc.want[r.Device]) {
+					t.Errorf("unexpected result %+v", r)
+				}
+			}
+		})
+	}
+
+	if report := DiagnoseSpecs([]string{t.TempDir()}, []*types.RdmaDevice{driftDevice("0000:17:00.0")}); len(report.Results) != 0 {
+		t.Errorf("no managed specs should report nothing, got %+v", report.Results)
+	}
+}
+
+func TestDiagnoseSpecIntegrity(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := cdi.CreateCDISpec("rdma", name, []types.RdmaDevice{*driftDevice("0000:17:00.0", "/dev/infiniband/uverbs0")}, dir, "yaml"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report := DiagnoseSpecIntegrity([]string{dir}, nil)
+	if report.HasWarn || report.HasFail || !strings.Contains(report.Results[0].Message, "3 spec file(s) match") {
+		t.Fatalf("untouched specs: %+v", report.Results)
+	}
+
+	edit := func(name, from, to string) {
+		path := filepath.Join(dir, "rdma-cdi_rdma_"+name+".yaml")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(strings.Replace(string(data), from, to, 1)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	edit("a", "uverbs0", "uverbs7")
+	edit("b", "cdiVersion", "cdiVersion: [")
+
+	report = DiagnoseSpecIntegrity([]string{dir}, nil)
+	var edited, corrupt bool
+	for _, r := range report.Results {
+		edited = edited || (r.Severity == Warn && strings.Contains(r.Message, "rdma-cdi_rdma_a.yaml was changed"))
+		corrupt = corrupt || (r.Severity == Fail && strings.Contains(r.Message, "rdma-cdi_rdma_b.yaml is corrupt"))
+	}
+	if !edited || !corrupt || len(report.Results) != 2 {
+		t.Errorf("unexpected results: %+v", report.Results)
+	}
+}
+
+func TestDiagnoseCacheLoad(t *testing.T) {
+	dir := t.TempDir()
+	for name, dev := range map[string]*types.RdmaDevice{
+		"net-a": driftDevice("0000:17:00.0", "/dev/infiniband/uverbs0"),
+		"net-b": driftDevice("0000:18:00.0", "/dev/infiniband/uverbs1"),
+	} {
+		if err := cdi.CreateCDISpec("rdma", name, []types.RdmaDevice{*dev}, dir, "yaml"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r := DiagnoseCacheLoad([]string{t.TempDir()}, false); len(r.Results) != 0 {
+		t.Errorf("no specs: want no results, got %+v", r.Results)
+	}
+
+	report := DiagnoseCacheLoad([]string{dir}, false)
+	if report.HasWarn || report.HasFail || !strings.Contains(report.Results[0].Message, "2 device(s) of 2 spec file(s) resolve") {
+		t.Fatalf("valid specs: %+v", report.Results)
+	}
+
+	// The nodes are not on this host, and are not pinned with --device-numbers
+	report = DiagnoseCacheLoad([]string{dir}, true)
+	if !report.HasFail || !strings.Contains(report.Results[0].Message, "cannot be injected") {
+		t.Errorf("injecting devices without nodes: %+v", report.Results)
+	}
+
+	// The same device in a JSON copy of a spec, in the same directory
+	dup := driftDevice("0000:17:00.0", "/dev/infiniband/uverbs0")
+	if err := cdi.CreateCDISpec("rdma", "net-a", []types.RdmaDevice{*dup}, dir, "json"); err != nil {
+		t.Fatal(err)
+	}
+	// and in a higher-priority directory
+	run := t.TempDir()
+	if err := cdi.CreateCDISpec("rdma", "net-b", []types.RdmaDevice{*driftDevice("0000:18:00.0", "/dev/infiniband/uverbs1")}, run, "yaml"); err != nil {
+		t.Fatal(err)
+	}
+	report = DiagnoseCacheLoad([]string{dir, run}, false)
+	var rejected, unresolved, shadowed bool
+	for _, r := range report.Results {
+		rejected = rejected || r.Severity == Fail && strings.Contains(r.Message, "The CDI cache rejects") && strings.Contains(r.Message, "conflicting device")
+		unresolved = unresolved || r.Severity == Fail && strings.Contains(r.Message, "rdma/net-a=0000:17:00.0 from") && strings.Contains(r.Message, "does not resolve")
+		shadowed = shadowed || r.Severity == Warn && strings.Contains(r.Message, "rdma/net-b=0000:18:00.0 resolves to "+filepath.Join(run, "rdma-cdi_rdma_net-b.yaml"))
+	}
+	if !rejected || !unresolved || !shadowed {
+		t.Errorf("conflicts: rejected %v, unresolved %v, shadowed %v in %+v", rejected, unresolved, shadowed, report.Results)
+	}
+
+	// The CDI parser panics on one-character class names
+	if err := cdi.CreateCDISpec("rdma", "x", []types.RdmaDevice{*driftDevice("0000:19:00.0", "/dev/infiniband/uverbs2")}, run, "yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if report = DiagnoseCacheLoad([]string{run}, false); !report.HasFail || !strings.Contains(report.Results[0].Message, "crashed") {
+		t.Errorf("one-character class: %+v", report.Results)
+	}
+}
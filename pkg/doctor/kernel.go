@@ -0,0 +1,270 @@
+package doctor
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/Nativu5/rdma-cdi/pkg/rdma"
+)
+
+// DefaultMinKernel is the oldest kernel DiagnoseHost accepts by default:
+// 5.3 added the exclusive RDMA netns mode that isolates RDMA devices
+// between containers.
+const DefaultMinKernel = "5.3"
+
+// procConfig is where the running kernel exposes its build configuration
+// when built with CONFIG_IKCONFIG_PROC.
+const procConfig = "/proc/config.gz"
+
+// kconfigOption is a kernel build option containerized RDMA relies on.
+type kconfigOption struct {
+	name string
+	// module provides the option when it is built as a module; its
+	// presence under <sysfs>/module stands in for an unreadable config.
+	module string
+	// path, if set, exists when the option is enabled; used like module.
+	path     string
+	severity Severity
+	purpose  string
+}
+
+var kconfigOptions = []kconfigOption{
+	{name: "CONFIG_INFINIBAND", module: "ib_core", severity: Fail, purpose: "RDMA core"},
+	{name: "CONFIG_INFINIBAND_USER_ACCESS", module: "ib_uverbs", severity: Fail, purpose: "uverbs devices"},
+	{name: "CONFIG_INFINIBAND_ADDR_TRANS", module: "rdma_ucm", severity: Warn, purpose: "rdma_cm"},
+	{name: "CONFIG_INFINIBAND_USER_MAD", module: "ib_umad", severity: Warn, purpose: "umad devices"},
+	{name: "CONFIG_NET_NS", path: "/proc/self/ns/net", severity: Fail, purpose: "network namespaces"},
+}
+
+// HostOption customizes DiagnoseHost.
+type HostOption func(*hostOptions)
+
+type hostOptions struct {
+	minKernel   string
+	release     string
+	sysfsRoot   string
+	configPaths []string
+}
+
+// WithMinKernel sets the oldest acceptable kernel release, e.g. "5.15".
+// Defaults to DefaultMinKernel.
+func WithMinKernel(version string) HostOption {
+	return func(o *hostOptions) {
+		o.minKernel = version
+	}
+}
+
+// WithKernelRelease checks release, e.g. one recorded in a support
+// bundle, instead of the running kernel. It also selects the default
+// config file config-<release>.
+func WithKernelRelease(release string) HostOption {
+	return func(o *hostOptions) {
+		o.release = release
+	}
+}
+
+// WithHostSysfsRoot sets where the host sysfs is mounted, for the module
+// fallback of the kconfig check. Defaults to rdma.DefaultSysfsRoot.
+func WithHostSysfsRoot(root string) HostOption {
+	return func(o *hostOptions) {
+		o.sysfsRoot = root
+	}
+}
+
+// WithKernelConfig sets the kernel config files to try, in order. Defaults
+// to /proc/config.gz, then config-<release> in the boot directory next to
+// the sysfs root (/boot, or e.g. /host/boot for /host/sys).
+func WithKernelConfig(paths ...string) HostOption {
+	return func(o *hostOptions) {
+		o.configPaths = append([]string{}, paths...)
+	}
+}
+
+// DiagnoseHost runs the host-wide kernel checks: the running kernel is at
+// least the minimum version, and the RDMA-relevant kconfig options are
+// enabled. Results carry no device.
+func DiagnoseHost(opts ...HostOption) *Report {
+	o := hostOptions{minKernel: DefaultMinKernel, sysfsRoot: rdma.DefaultSysfsRoot}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	report := &Report{}
+
+	release := o.release
+	if release == "" {
+		var uts unix.Utsname
+		if err := unix.Uname(&uts); err != nil {
+			report.add(CheckResult{
+				Check:    "kernel_version",
+				Severity: Warn,
+				Message:  fmt.Sprintf("Cannot determine the kernel release: %v", err),
+			})
+			return report
+		}
+		release = unix.ByteSliceToString(uts.Release[:])
+	}
+	checkKernelVersion(report, release, o.minKernel)
+
+	paths := o.configPaths
+	if paths == nil {
+		paths = []string{procConfig, filepath.Join(filepath.Dir(o.sysfsRoot), "boot", "config-"+release)}
+	}
+	config, source := readKernelConfig(paths)
+	checkKernelConfig(report, config, source, o.sysfsRoot)
+	return report
+}
+
+// checkKernelVersion fails when release is older than minimum.
+func checkKernelVersion(report *Report, release, minimum string) {
+	have, err := parseKernelVersion(release)
+	if err != nil {
+		report.add(CheckResult{Check: "kernel_version", Severity: Warn, Message: err.Error()})
+		return
+	}
+	want, err := parseKernelVersion(minimum)
+	if err != nil {
+		report.add(CheckResult{Check: "kernel_version", Severity: Warn, Message: fmt.Sprintf("Invalid minimum kernel: %v", err)})
+		return
+	}
+	if compareVersions(have, want) < 0 {
+		report.add(CheckResult{
+			Check:    "kernel_version",
+			Severity: Fail,
+			Message:  fmt.Sprintf("Kernel %s is older than %s, the minimum for containerized RDMA", release, minimum),
+		})
+		return
+	}
+	report.add(CheckResult{
+		Check:    "kernel_version",
+		Severity: Pass,
+		Message:  fmt.Sprintf("Kernel %s (minimum %s)", release, minimum),
+	})
+}
+
+// parseKernelVersion returns the numeric major, minor and patch levels of
+// a release such as "5.15.0-91-generic" or "6.8".
+func parseKernelVersion(release string) ([3]int, error) {
+	var v [3]int
+	numeric, _, _ := strings.Cut(release, "-")
+	parts := strings.SplitN(numeric, ".", 4)
+	if len(parts) < 2 {
+		return v, fmt.Errorf("unrecognized kernel release %q", release)
+	}
+	for i := 0; i < len(parts) && i < 3; i++ {
+		// Trailing non-digits such as "+" or "rc1" do not affect ordering here
+		digits := strings.TrimRightFunc(parts[i], func(r rune) bool { return r < '0' || r > '9' })
+		n, err := strconv.Atoi(digits)
+		if err != nil {
+			return v, fmt.Errorf("unrecognized kernel release %q", release)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] - b[i]
+		}
+	}
+	return 0
+}
+
+// readKernelConfig returns the options of the first readable config file
+// among paths (gzipped if the name ends in .gz) and the file it came
+// from, or nil if none could be read.
+func readKernelConfig(paths []string) (map[string]string, string) {
+	for _, p := range paths {
+		config, err := parseKernelConfigFile(p)
+		if err == nil {
+			return config, p
+		}
+	}
+	return nil, ""
+}
+
+func parseKernelConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	config := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, value, ok := strings.Cut(line, "="); ok && strings.HasPrefix(name, "CONFIG_") {
+			config[name] = value
+		}
+	}
+	return config, scanner.Err()
+}
+
+// checkKernelConfig verifies kconfigOptions against config, read from
+// source. Without a config, or for options the config leaves unset, the
+// option's module (under sysfsRoot) or path being present counts as
+// enabled.
+func checkKernelConfig(report *Report, config map[string]string, source, sysfsRoot string) {
+	var enabled []string
+	for _, opt := range kconfigOptions {
+		if v := config[opt.name]; v == "y" || v == "m" {
+			enabled = append(enabled, opt.name+"="+v)
+			continue
+		}
+		if opt.module != "" {
+			if _, err := os.Stat(filepath.Join(sysfsRoot, "module", opt.module)); err == nil {
+				enabled = append(enabled, opt.name+" (module "+opt.module+" loaded)")
+				continue
+			}
+		}
+		if opt.path != "" {
+			if _, err := os.Stat(opt.path); err == nil {
+				enabled = append(enabled, opt.name+" ("+opt.path+" present)")
+				continue
+			}
+		}
+
+		msg := fmt.Sprintf("%s (%s) is not enabled", opt.name, opt.purpose)
+		if config == nil {
+			fallback := "module " + opt.module + " not loaded"
+			if opt.module == "" {
+				fallback = opt.path + " missing"
+			}
+			msg = fmt.Sprintf("%s (%s) cannot be confirmed: no kernel config readable and %s", opt.name, opt.purpose, fallback)
+		}
+		report.add(CheckResult{Check: "kernel_config", Severity: opt.severity, Message: msg})
+	}
+
+	if len(enabled) == 0 {
+		return
+	}
+	from := "loaded modules"
+	if source != "" {
+		from = source
+	}
+	report.add(CheckResult{
+		Check:    "kernel_config",
+		Severity: Pass,
+		Message:  fmt.Sprintf("Enabled per %s: %s", from, strings.Join(enabled, ", ")),
+	})
+}
@@ -0,0 +1,139 @@
+package doctor
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseKernelVersion(t *testing.T) {
+	tests := []struct {
+		release string
+		want    [3]int
+		wantErr bool
+	}{
+		{"5.15.0-91-generic", [3]int{5, 15, 0}, false},
+		{"6.8", [3]int{6, 8, 0}, false},
+		{"4.18.0-513.el8.x86_64", [3]int{4, 18, 0}, false},
+		{"6.1.0+", [3]int{6, 1, 0}, false},
+		{"6.10.0-rc1", [3]int{6, 10, 0}, false},
+		{"linux", [3]int{}, true},
+		{"x.y", [3]int{}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.release, func(t *testing.T) {
+			got, err := parseKernelVersion(tc.release)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("parseKernelVersion(%q) = %v, want %v", tc.release, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckKernelVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		release  string
+		minimum  string
+		severity Severity
+	}{
+		{"newer", "5.15.0-91-generic", "5.3", Pass},
+		{"equal", "5.3.0", "5.3", Pass},
+		{"older", "4.18.0-513.el8.x86_64", "5.3", Fail},
+		{"minor_ordering", "5.10.0", "5.9", Pass},
+		{"bad_minimum", "5.15.0", "five", Warn},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			report := &Report{}
+			checkKernelVersion(report, tc.release, tc.minimum)
+			if len(report.Results) != 1 || report.Results[0].Severity != tc.severity {
+				t.Errorf("expected one %s result, got %+v", tc.severity, report.Results)
+			}
+		})
+	}
+}
+
+func TestDiagnoseHost_KernelRelease(t *testing.T) {
+	report := DiagnoseHost(WithKernelRelease("4.18.0-513.el8.x86_64"), WithKernelConfig(), WithHostSysfsRoot(t.TempDir()))
+	for _, r := range report.Results {
+		if r.Check == "kernel_version" {
+			if r.Severity != Fail || !strings.Contains(r.Message, "4.18.0-513.el8.x86_64") {
+				t.Errorf("expected FAIL for the given release, got %+v", r)
+			}
+			return
+		}
+	}
+	t.Errorf("no kernel_version result in %+v", report.Results)
+}
+
+func TestReadKernelConfig(t *testing.T) {
+	dir := t.TempDir()
+	body := "# CONFIG_INFINIBAND_USER_MAD is not set\nCONFIG_INFINIBAND=m\nCONFIG_NET_NS=y\n"
+
+	gzPath := filepath.Join(dir, "config.gz")
+	f, err := os.Create(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte(body))
+	gz.Close()
+	f.Close()
+
+	config, source := readKernelConfig([]string{filepath.Join(dir, "missing"), gzPath})
+	if source != gzPath || config["CONFIG_INFINIBAND"] != "m" || config["CONFIG_NET_NS"] != "y" {
+		t.Errorf("readKernelConfig = %v from %q", config, source)
+	}
+	if _, ok := config["CONFIG_INFINIBAND_USER_MAD"]; ok {
+		t.Error("options that are not set must not be reported")
+	}
+
+	if config, source := readKernelConfig([]string{filepath.Join(dir, "missing")}); config != nil || source != "" {
+		t.Errorf("expected no config, got %v from %q", config, source)
+	}
+}
+
+func TestCheckKernelConfig(t *testing.T) {
+	sysfs := t.TempDir()
+	all := map[string]string{
+		"CONFIG_INFINIBAND":             "m",
+		"CONFIG_INFINIBAND_USER_ACCESS": "m",
+		"CONFIG_INFINIBAND_ADDR_TRANS":  "y",
+		"CONFIG_INFINIBAND_USER_MAD":    "m",
+		"CONFIG_NET_NS":                 "y",
+	}
+
+	report := &Report{}
+	checkKernelConfig(report, all, "/proc/config.gz", sysfs)
+	if report.HasFail || report.HasWarn || len(report.Results) != 1 {
+		t.Errorf("expected a single PASS, got %+v", report.Results)
+	}
+
+	// An option missing from the config fails, unless its module is loaded
+	delete(all, "CONFIG_INFINIBAND_USER_ACCESS")
+	report = &Report{}
+	checkKernelConfig(report, all, "/proc/config.gz", sysfs)
+	if !report.HasFail || !strings.Contains(report.Results[0].Message, "CONFIG_INFINIBAND_USER_ACCESS") {
+		t.Errorf("expected FAIL for CONFIG_INFINIBAND_USER_ACCESS, got %+v", report.Results)
+	}
+
+	os.MkdirAll(filepath.Join(sysfs, "module", "ib_uverbs"), 0755)
+	report = &Report{}
+	checkKernelConfig(report, all, "/proc/config.gz", sysfs)
+	if report.HasFail {
+		t.Errorf("loaded ib_uverbs should satisfy the option, got %+v", report.Results)
+	}
+
+	// Without any config only module presence can confirm options
+	report = &Report{}
+	checkKernelConfig(report, nil, "", sysfs)
+	if !report.HasFail || !strings.Contains(report.Results[0].Message, "cannot be confirmed") {
+		t.Errorf("expected unconfirmed options, got %+v", report.Results)
+	}
+}
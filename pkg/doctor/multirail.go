@@ -0,0 +1,155 @@
+package doctor
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// ipv4Conf holds the per-interface IPv4 sysctls of the running host's
+// network namespace, replaceable in tests.
+var ipv4Conf = "/proc/sys/net/ipv4/conf"
+
+// railSysctl is an IPv4 sysctl that must be set on every rail of a
+// multi-rail RoCE host. The kernel applies the larger of the "all" and
+// per-interface values, so setting the interface alone is enough to fix
+// it.
+type railSysctl struct {
+	name string
+	ok   func(v int) bool
+	want int
+	why  string
+}
+
+var railSysctls = []railSysctl{
+	{"rp_filter", func(v int) bool { return v != 1 }, 2,
+		"strict reverse-path filtering drops replies arriving on another rail in the same subnet"},
+	{"arp_ignore", func(v int) bool { return v >= 1 }, 1,
+		"every rail answers ARP for its peers' addresses, so traffic lands on the wrong HCA"},
+	{"arp_announce", func(v int) bool { return v >= 2 }, 2,
+		"ARP requests may carry another rail's source address, poisoning peers' neighbour tables"},
+}
+
+// DiagnoseMultiRail checks the reverse-path and ARP sysctls of RoCE
+// netdevs that share an IPv4 subnet with another RoCE netdev, the setup
+// multi-rail NCCL and MPI jobs use. The defaults make the kernel route
+// and answer for all rails through one of them, which breaks such
+// traffic. Nothing is reported for single-rail hosts.
+func DiagnoseMultiRail(devices []*types.RdmaDevice) *Report {
+	report := &Report{}
+
+	type rail struct {
+		ifName string
+		dev    *types.RdmaDevice
+	}
+	subnets := make(map[string][]rail)
+	var order []string
+	for _, dev := range devices {
+		if dev.LinkType != "ether" {
+			continue
+		}
+		names := dev.IfNames
+		if len(names) == 0 {
+			names = []string{dev.IfName}
+		}
+		for _, ifName := range names {
+			if ifName == "" {
+				continue
+			}
+			seen := make(map[string]bool)
+			for _, addr := range getAddresses(ifName) {
+				_, subnet, err := net.ParseCIDR(addr)
+				if err != nil || subnet.IP.To4() == nil || seen[subnet.String()] {
+					continue
+				}
+				seen[subnet.String()] = true
+				key := subnet.String()
+				if _, ok := subnets[key]; !ok {
+					order = append(order, key)
+				}
+				subnets[key] = append(subnets[key], rail{ifName, dev})
+			}
+		}
+	}
+
+	for _, subnet := range order {
+		rails := subnets[subnet]
+		if len(rails) < 2 {
+			continue
+		}
+		var names []string
+		for _, r := range rails {
+			names = append(names, r.ifName)
+		}
+		for _, r := range rails {
+			others := slices.DeleteFunc(slices.Clone(names), func(n string) bool { return n == r.ifName })
+			var bad []string
+			for _, s := range railSysctls {
+				v, err := effectiveSysctl(r.ifName, s.name)
+				if err != nil {
+					report.add(CheckResult{
+						Check:    "multi_rail_sysctl",
+						Severity: Warn,
+						Message:  fmt.Sprintf("Cannot read %s of %s: %v", s.name, r.ifName, err),
+						Device:   r.dev.PciAddress,
+					})
+					continue
+				}
+				if !s.ok(v) {
+					bad = append(bad, fmt.Sprintf("%s is %d (%s); set net.ipv4.conf.%s.%s=%d",
+						s.name, v, s.why, sysctlIfName(r.ifName), s.name, s.want))
+				}
+			}
+			if len(bad) > 0 {
+				report.add(CheckResult{
+					Check:    "multi_rail_sysctl",
+					Severity: Warn,
+					Message: fmt.Sprintf("%s shares subnet %s with %s: %s", r.ifName, subnet,
+						strings.Join(others, ", "), strings.Join(bad, "; ")),
+					Device: r.dev.PciAddress,
+				})
+			} else {
+				report.add(CheckResult{
+					Check:    "multi_rail_sysctl",
+					Severity: Pass,
+					Message:  fmt.Sprintf("%s is set up for multi-rail traffic in subnet %s", r.ifName, subnet),
+					Device:   r.dev.PciAddress,
+				})
+			}
+		}
+	}
+	return report
+}
+
+// effectiveSysctl returns the value of an IPv4 conf sysctl the kernel
+// applies to ifName: the larger of its own and the "all" setting.
+func effectiveSysctl(ifName, name string) (int, error) {
+	v, err := readSysctl(filepath.Join(ipv4Conf, ifName, name))
+	if err != nil {
+		return 0, err
+	}
+	if all, err := readSysctl(filepath.Join(ipv4Conf, "all", name)); err == nil && all > v {
+		v = all
+	}
+	return v, nil
+}
+
+func readSysctl(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// sysctlIfName escapes the dots of VLAN interfaces such as "eth0.100",
+// which sysctl keys spell with a slash.
+func sysctlIfName(ifName string) string {
+	return strings.ReplaceAll(ifName, ".", "/")
+}
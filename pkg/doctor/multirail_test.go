@@ -0,0 +1,89 @@
+package doctor
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+func writeSysctls(t *testing.T, root, ifName string, values map[string]int) {
+	t.Helper()
+	dir := filepath.Join(root, ifName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, v := range values {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(strconv.Itoa(v)+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDiagnoseMultiRail(t *testing.T) {
+	origConf, origAddrs := ipv4Conf, getAddresses
+	t.Cleanup(func() { ipv4Conf, getAddresses = origConf, origAddrs })
+	ipv4Conf = t.TempDir()
+	addrs := map[string][]string{
+		"rail0": {"10.0.0.1/24"},
+		"rail1": {"10.0.0.2/24"},
+		"mgmt":  {"192.0.2.10/24"},
+	}
+	getAddresses = func(ifNames ...string) []string { return addrs[ifNames[0]] }
+
+	writeSysctls(t, ipv4Conf, "all", map[string]int{"rp_filter": 0, "arp_ignore": 0, "arp_announce": 0})
+	writeSysctls(t, ipv4Conf, "rail0", map[string]int{"rp_filter": 2, "arp_ignore": 1, "arp_announce": 2})
+	writeSysctls(t, ipv4Conf, "rail1", map[string]int{"rp_filter": 1, "arp_ignore": 0, "arp_announce": 2})
+
+	devices := []*types.RdmaDevice{
+		{PciAddress: "0000:17:00.0", IfName: "rail0", LinkType: "ether"},
+		{PciAddress: "0000:41:00.0", IfName: "rail1", LinkType: "ether"},
+		{PciAddress: "0000:61:00.0", IfName: "mgmt", LinkType: "ether"},
+	}
+	report := DiagnoseMultiRail(devices)
+	if len(report.Results) != 2 {
+		t.Fatalf("expected one result per rail, got %+v", report.Results)
+	}
+	if r := report.Results[0]; r.Device != "0000:17:00.0" || r.Severity != Pass {
+		t.Errorf("expected PASS for rail0, got %+v", r)
+	}
+	r := report.Results[1]
+	if r.Device != "0000:41:00.0" || r.Severity != Warn {
+		t.Fatalf("expected WARN for rail1, got %+v", r)
+	}
+	for _, want := range []string{"net.ipv4.conf.rail1.rp_filter=2", "net.ipv4.conf.rail1.arp_ignore=1", "with rail0"} {
+		if !strings.Contains(r.Message, want) {
+			t.Errorf("message %q lacks %q", r.Message, want)
+		}
+	}
+	if strings.Contains(r.Message, "arp_announce") {
+		t.Errorf("arp_announce is fine on rail1, got %q", r.Message)
+	}
+
+	// "all" overrides a laxer per-interface value
+	writeSysctls(t, ipv4Conf, "all", map[string]int{"rp_filter": 1})
+	writeSysctls(t, ipv4Conf, "rail0", map[string]int{"rp_filter": 0})
+	report = DiagnoseMultiRail(devices)
+	if r := report.Results[0]; r.Severity != Warn || !strings.Contains(r.Message, "rp_filter is 1") {
+		t.Errorf("expected all.rp_filter=1 to apply to rail0, got %+v", r)
+	}
+}
+
+func TestDiagnoseMultiRail_SingleRail(t *testing.T) {
+	origAddrs := getAddresses
+	t.Cleanup(func() { getAddresses = origAddrs })
+	getAddresses = func(ifNames ...string) []string { return []string{"10.0.0.1/24"} }
+
+	// InfiniBand ports and a lone RoCE port are not multi-rail RoCE
+	devices := []*types.RdmaDevice{
+		{PciAddress: "0000:17:00.0", IfName: "ib0", LinkType: "infiniband"},
+		{PciAddress: "0000:41:00.0", IfName: "ib1", LinkType: "infiniband"},
+		{PciAddress: "0000:61:00.0", IfName: "eth0", LinkType: "ether"},
+	}
+	if report := DiagnoseMultiRail(devices); len(report.Results) != 0 {
+		t.Errorf("expected no results, got %+v", report.Results)
+	}
+}
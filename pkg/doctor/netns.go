@@ -0,0 +1,74 @@
+package doctor
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/Nativu5/rdma-cdi/pkg/cdi"
+	"github.com/Nativu5/rdma-cdi/pkg/rdma"
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// DiagnoseNetnsSpecs checks that the specs this tool wrote in dirs suit
+// the RDMA netns mode discovery recorded in devices. Specs are meant for
+// expected, "shared" or "exclusive", or when it is empty for the mode
+// they imply: a spec with the "all" device shares every device between
+// containers, which needs shared mode, while per-device specs assign each
+// device to one container, which only exclusive mode enforces. Nothing is
+// reported without managed specs or a recorded mode.
+func DiagnoseNetnsSpecs(dirs []string, devices []*types.RdmaDevice, expected string) *Report {
+	report := &Report{}
+	var mode string
+	for _, dev := range devices {
+		if dev.NetnsMode != "" {
+			mode = dev.NetnsMode
+			break
+		}
+	}
+	if mode == "" {
+		return report
+	}
+	paths, err := cdi.FindManagedSpecs(dirs)
+	if err != nil || len(paths) == 0 {
+		// DiagnoseSpecs reports specs that cannot be listed
+		return report
+	}
+
+	want, why := expected, "as --expected-netns-mode says"
+	if want == "" {
+		want = rdma.NetnsModeExclusive
+		why = fmt.Sprintf("as the %d per-device spec(s) imply", len(paths))
+		for _, p := range paths {
+			if _, meta, err := cdi.ReadSpec(p); err == nil && meta.AllDevice {
+				want = rdma.NetnsModeShared
+				why = fmt.Sprintf("as the %q device of %s implies", cdi.AllDeviceName, filepath.Base(p))
+				break
+			}
+		}
+	}
+
+	var msg string
+	switch {
+	case mode == want:
+		report.add(CheckResult{
+			Check:    "spec_netns_mode",
+			Severity: Pass,
+			Message:  fmt.Sprintf("The specs are meant for %s RDMA netns mode, which the host is in", mode),
+		})
+		return report
+	case want == rdma.NetnsModeExclusive:
+		msg = fmt.Sprintf("The specs are meant for exclusive RDMA netns mode (%s), but the host is in shared mode: "+
+			"every container sees every RDMA device, so assigning devices one per container does not isolate them "+
+			"(run 'rdma-cdi netns set exclusive' before starting the containers)", why)
+	default:
+		msg = fmt.Sprintf("The specs are meant for shared RDMA netns mode (%s), but the host is in %s mode: "+
+			"a device is only usable in the network namespace it was moved to, so containers sharing it "+
+			"open its device nodes but find no RDMA device (run 'rdma-cdi netns set shared', or generate per-device specs)", why, mode)
+	}
+	report.add(CheckResult{
+		Check:    "spec_netns_mode",
+		Severity: Warn,
+		Message:  msg,
+	})
+	return report
+}
@@ -0,0 +1,57 @@
+package doctor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Nativu5/rdma-cdi/pkg/cdi"
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+func TestDiagnoseNetnsSpecs(t *testing.T) {
+	perDevice, withAll := t.TempDir(), t.TempDir()
+	dev := driftDevice("0000:17:00.0", "/dev/infiniband/uverbs0")
+	if err := cdi.CreateCDISpec("rdma", "a", []types.RdmaDevice{*dev}, perDevice, "yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cdi.CreateCDISpec("rdma", "pool", []types.RdmaDevice{*dev}, withAll, "yaml", cdi.WithAllDevice()); err != nil {
+		t.Fatal(err)
+	}
+	hostIn := func(mode string) []*types.RdmaDevice {
+		d := *dev
+		d.NetnsMode = mode
+		return []*types.RdmaDevice{&d}
+	}
+
+	tests := []struct {
+		name     string
+		dir      string
+		mode     string
+		expected string
+		severity Severity
+		message  string
+	}{
+		{"per_device_exclusive", perDevice, "exclusive", "", Pass, "meant for exclusive"},
+		{"per_device_shared", perDevice, "shared", "", Warn, "per-device spec(s) imply), but the host is in shared mode"},
+		{"all_device_exclusive", withAll, "exclusive", "", Warn, `"all" device of rdma-cdi_rdma_pool.yaml implies`},
+		{"all_device_shared", withAll, "shared", "", Pass, "meant for shared"},
+		{"expected_overrides", perDevice, "exclusive", "shared", Warn, "--expected-netns-mode says"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := DiagnoseNetnsSpecs([]string{tt.dir}, hostIn(tt.mode), tt.expected)
+			if len(report.Results) != 1 || report.Results[0].Severity != tt.severity ||
+				!strings.Contains(report.Results[0].Message, tt.message) {
+				t.Errorf("got %+v, want %s containing %q", report.Results, tt.severity, tt.message)
+			}
+		})
+	}
+
+	// Nothing to judge without specs or a recorded mode
+	if r := DiagnoseNetnsSpecs([]string{t.TempDir()}, hostIn("shared"), ""); len(r.Results) != 0 {
+		t.Errorf("expected no result without specs, got %+v", r.Results)
+	}
+	if r := DiagnoseNetnsSpecs([]string{perDevice}, hostIn(""), ""); len(r.Results) != 0 {
+		t.Errorf("expected no result without a mode, got %+v", r.Results)
+	}
+}
@@ -0,0 +1,29 @@
+package export
+
+import (
+	"sigs.k8s.io/yaml"
+)
+
+// configMap is the subset of a Kubernetes ConfigMap the exports need,
+// avoiding a dependency on the Kubernetes API types.
+type configMap struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   objectMeta        `json:"metadata"`
+	Data       map[string]string `json:"data"`
+}
+
+type objectMeta struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// ConfigMap renders a ConfigMap manifest holding data under key.
+func ConfigMap(name, namespace, key string, data []byte) ([]byte, error) {
+	return yaml.Marshal(configMap{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Metadata:   objectMeta{Name: name, Namespace: namespace},
+		Data:       map[string]string{key: string(data)},
+	})
+}
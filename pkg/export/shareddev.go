@@ -0,0 +1,117 @@
+// Package export converts discovery results into the configuration of
+// other Kubernetes RDMA components, easing migration to and coexistence
+// with CDI.
+package export
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// Defaults of the plugin's deployment manifests.
+const (
+	SharedDevPluginConfigMap = "rdma-devices"
+	SharedDevPluginNamespace = "kube-system"
+	SharedDevPluginConfigKey = "config.json"
+)
+
+// Grouping of devices into k8s-rdma-shared-dev-plugin resources.
+const (
+	// GroupInterface makes one resource per PF, selected by interface name.
+	GroupInterface = "interface"
+	// GroupModel makes one resource per adapter model, selected by PCI
+	// vendor and device ID.
+	GroupModel = "model"
+	// GroupLinkType makes one resource per link type, e.g. all InfiniBand
+	// HCAs.
+	GroupLinkType = "link-type"
+)
+
+// DefaultRdmaHcaMax is how many pods may share an HCA, the plugin's own
+// example value.
+const DefaultRdmaHcaMax = 1000
+
+// SharedDevPluginConfig is the config.json of k8s-rdma-shared-dev-plugin.
+type SharedDevPluginConfig struct {
+	PeriodicUpdateInterval *int                `json:"periodicUpdateInterval,omitempty"`
+	ConfigList             []SharedDevResource `json:"configList"`
+}
+
+// SharedDevResource is one extended resource of the plugin.
+type SharedDevResource struct {
+	ResourceName   string             `json:"resourceName"`
+	ResourcePrefix string             `json:"resourcePrefix,omitempty"`
+	RdmaHcaMax     int                `json:"rdmaHcaMax"`
+	Selectors      SharedDevSelectors `json:"selectors"`
+}
+
+// SharedDevSelectors selects the devices of a resource.
+type SharedDevSelectors struct {
+	Vendors   []string `json:"vendors,omitempty"`
+	DeviceIDs []string `json:"deviceIDs,omitempty"`
+	Drivers   []string `json:"drivers,omitempty"`
+	IfNames   []string `json:"ifNames,omitempty"`
+	LinkTypes []string `json:"linkTypes,omitempty"`
+}
+
+// ValidGroup reports whether groupBy is a grouping SharedDevPlugin supports.
+func ValidGroup(groupBy string) bool {
+	return groupBy == GroupInterface || groupBy == GroupModel || groupBy == GroupLinkType
+}
+
+// invalidResourceChars matches what the plugin rejects in resource names.
+var invalidResourceChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// SharedDevPlugin returns the plugin configuration sharing the PFs of
+// devices, grouped by groupBy (GroupInterface, GroupModel or
+// GroupLinkType), each resource shared by up to rdmaHcaMax pods. VFs are
+// left out: the plugin shares whole HCAs, and VFs are handed out by the
+// SR-IOV device plugin instead.
+func SharedDevPlugin(devices []*types.RdmaDevice, groupBy string, rdmaHcaMax int) (*SharedDevPluginConfig, error) {
+	byName := make(map[string]*SharedDevResource)
+	for _, dev := range devices {
+		if dev.IsVF {
+			continue
+		}
+		var suffix string
+		var sel SharedDevSelectors
+		switch groupBy {
+		case GroupInterface:
+			if dev.IfName == "" {
+				continue
+			}
+			suffix, sel = dev.IfName, SharedDevSelectors{IfNames: []string{dev.IfName}}
+		case GroupModel:
+			if dev.Vendor == "" || dev.DeviceID == "" {
+				continue
+			}
+			suffix = dev.Vendor + "_" + dev.DeviceID
+			sel = SharedDevSelectors{Vendors: []string{dev.Vendor}, DeviceIDs: []string{dev.DeviceID}}
+		case GroupLinkType:
+			if dev.LinkType == "" {
+				continue
+			}
+			suffix, sel = dev.LinkType, SharedDevSelectors{LinkTypes: []string{dev.LinkType}}
+		default:
+			return nil, fmt.Errorf("unsupported group key %q: use %s, %s or %s", groupBy, GroupInterface, GroupModel, GroupLinkType)
+		}
+
+		name := "rdma_" + strings.ToLower(invalidResourceChars.ReplaceAllString(suffix, "_"))
+		if _, ok := byName[name]; !ok {
+			byName[name] = &SharedDevResource{ResourceName: name, RdmaHcaMax: rdmaHcaMax, Selectors: sel}
+		}
+	}
+
+	cfg := &SharedDevPluginConfig{ConfigList: []SharedDevResource{}}
+	for _, res := range byName {
+		cfg.ConfigList = append(cfg.ConfigList, *res)
+	}
+	slices.SortFunc(cfg.ConfigList, func(a, b SharedDevResource) int {
+		return strings.Compare(a.ResourceName, b.ResourceName)
+	})
+	return cfg, nil
+}
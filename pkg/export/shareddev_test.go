@@ -0,0 +1,72 @@
+package export
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+func sampleDevices() []*types.RdmaDevice {
+	return []*types.RdmaDevice{
+		{PciAddress: "0000:17:00.0", IfName: "enp23s0f0np0", Vendor: "15b3", DeviceID: "1017", LinkType: "ether"},
+		{PciAddress: "0000:17:00.1", IfName: "enp23s0f1np1", Vendor: "15b3", DeviceID: "1017", LinkType: "ether"},
+		{PciAddress: "0000:17:00.2", IfName: "enp23s0f0v0", Vendor: "15b3", DeviceID: "1018", LinkType: "ether",
+			IsVF: true, ParentPFAddress: "0000:17:00.0"},
+		{PciAddress: "0000:41:00.0", IfName: "ib0", Vendor: "15b3", DeviceID: "101b", LinkType: "infiniband"},
+	}
+}
+
+func TestSharedDevPlugin(t *testing.T) {
+	tests := []struct {
+		groupBy string
+		want    []SharedDevResource
+	}{
+		{GroupInterface, []SharedDevResource{
+			{ResourceName: "rdma_enp23s0f0np0", RdmaHcaMax: 10, Selectors: SharedDevSelectors{IfNames: []string{"enp23s0f0np0"}}},
+			{ResourceName: "rdma_enp23s0f1np1", RdmaHcaMax: 10, Selectors: SharedDevSelectors{IfNames: []string{"enp23s0f1np1"}}},
+			{ResourceName: "rdma_ib0", RdmaHcaMax: 10, Selectors: SharedDevSelectors{IfNames: []string{"ib0"}}},
+		}},
+		{GroupModel, []SharedDevResource{
+			{ResourceName: "rdma_15b3_1017", RdmaHcaMax: 10, Selectors: SharedDevSelectors{Vendors: []string{"15b3"}, DeviceIDs: []string{"1017"}}},
+			{ResourceName: "rdma_15b3_101b", RdmaHcaMax: 10, Selectors: SharedDevSelectors{Vendors: []string{"15b3"}, DeviceIDs: []string{"101b"}}},
+		}},
+		{GroupLinkType, []SharedDevResource{
+			{ResourceName: "rdma_ether", RdmaHcaMax: 10, Selectors: SharedDevSelectors{LinkTypes: []string{"ether"}}},
+			{ResourceName: "rdma_infiniband", RdmaHcaMax: 10, Selectors: SharedDevSelectors{LinkTypes: []string{"infiniband"}}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.groupBy, func(t *testing.T) {
+			cfg, err := SharedDevPlugin(sampleDevices(), tt.groupBy, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cfg.ConfigList, tt.want) {
+				t.Errorf("ConfigList = %+v, want %+v", cfg.ConfigList, tt.want)
+			}
+		})
+	}
+
+	if _, err := SharedDevPlugin(sampleDevices(), "numa", 10); err == nil {
+		t.Error("unsupported group key should fail")
+	}
+}
+
+func TestConfigMap(t *testing.T) {
+	out, err := ConfigMap("rdma-devices", "kube-system", "config.json", []byte("{\"configList\": []}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cm configMap
+	if err := yaml.Unmarshal(out, &cm); err != nil {
+		t.Fatalf("invalid manifest:\n%s", out)
+	}
+	if cm.Kind != "ConfigMap" || cm.Metadata.Namespace != "kube-system" ||
+		!strings.Contains(cm.Data["config.json"], "configList") {
+		t.Errorf("manifest = %+v", cm)
+	}
+}
@@ -0,0 +1,108 @@
+package export
+
+import (
+	"bytes"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// SriovOperatorNamespace is where sriov-network-operator expects its
+// policies by default.
+const SriovOperatorNamespace = "sriov-network-operator"
+
+// SriovNetworkNodePolicy is the subset of the sriov-network-operator
+// SriovNetworkNodePolicy resource the export fills in.
+type SriovNetworkNodePolicy struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Metadata   objectMeta      `json:"metadata"`
+	Spec       SriovPolicySpec `json:"spec"`
+}
+
+// SriovPolicySpec is the spec of a SriovNetworkNodePolicy.
+type SriovPolicySpec struct {
+	ResourceName string            `json:"resourceName"`
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	Priority     int               `json:"priority"`
+	NumVfs       int               `json:"numVfs"`
+	NicSelector  SriovNicSelector  `json:"nicSelector"`
+	DeviceType   string            `json:"deviceType"`
+	IsRdma       bool              `json:"isRdma"`
+	LinkType     string            `json:"linkType,omitempty"`
+}
+
+// SriovNicSelector selects the PFs a policy configures.
+type SriovNicSelector struct {
+	Vendor   string   `json:"vendor,omitempty"`
+	DeviceID string   `json:"deviceID,omitempty"`
+	PfNames  []string `json:"pfNames,omitempty"`
+}
+
+// SriovPF is an RDMA PF to write a policy for.
+type SriovPF struct {
+	Device *types.RdmaDevice
+	// NumVFs is the number of VFs the policy creates, usually the number
+	// currently enabled.
+	NumVFs int
+}
+
+// operatorLinkTypes maps discovered link types to the operator's.
+var operatorLinkTypes = map[string]string{
+	"ether":      "eth",
+	"infiniband": "ib",
+}
+
+// SriovPolicies returns one SriovNetworkNodePolicy skeleton per PF, with
+// RDMA enabled and the netdevice device type RDMA VFs need, pinned to node
+// when it is set. Resource names and priorities are placeholders for the
+// operator to review.
+func SriovPolicies(pfs []SriovPF, namespace, node string) []SriovNetworkNodePolicy {
+	var out []SriovNetworkNodePolicy
+	for _, pf := range pfs {
+		dev := pf.Device
+		name := strings.ToLower(invalidResourceChars.ReplaceAllString(dev.IfName, "_"))
+		policy := SriovNetworkNodePolicy{
+			APIVersion: "sriovnetwork.openshift.io/v1",
+			Kind:       "SriovNetworkNodePolicy",
+			Metadata:   objectMeta{Name: "rdma-" + strings.ReplaceAll(name, "_", "-"), Namespace: namespace},
+			Spec: SriovPolicySpec{
+				ResourceName: "rdma_" + name,
+				Priority:     99,
+				NumVfs:       pf.NumVFs,
+				NicSelector: SriovNicSelector{
+					Vendor:   dev.Vendor,
+					DeviceID: dev.DeviceID,
+					PfNames:  []string{dev.IfName},
+				},
+				DeviceType: "netdevice",
+				IsRdma:     true,
+				LinkType:   operatorLinkTypes[dev.LinkType],
+			},
+		}
+		if node != "" {
+			policy.Metadata.Name += "-" + node
+			policy.Spec.NodeSelector = map[string]string{"kubernetes.io/hostname": node}
+		}
+		out = append(out, policy)
+	}
+	return out
+}
+
+// MarshalYAMLDocuments renders objects as a multi-document YAML stream.
+func MarshalYAMLDocuments[T any](objects []T) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, obj := range objects {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
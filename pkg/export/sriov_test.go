@@ -0,0 +1,41 @@
+package export
+
+import (
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func TestSriovPolicies(t *testing.T) {
+	devices := sampleDevices()
+	pfs := []SriovPF{{Device: devices[0], NumVFs: 4}, {Device: devices[3], NumVFs: 8}}
+
+	policies := SriovPolicies(pfs, SriovOperatorNamespace, "node1")
+	if len(policies) != 2 {
+		t.Fatalf("got %d policies, want 2", len(policies))
+	}
+	p := policies[0]
+	if p.Metadata.Name != "rdma-enp23s0f0np0-node1" || p.Spec.ResourceName != "rdma_enp23s0f0np0" ||
+		p.Spec.NumVfs != 4 || !p.Spec.IsRdma || p.Spec.DeviceType != "netdevice" || p.Spec.LinkType != "eth" ||
+		p.Spec.NicSelector.PfNames[0] != "enp23s0f0np0" || p.Spec.NicSelector.DeviceID != "1017" ||
+		p.Spec.NodeSelector["kubernetes.io/hostname"] != "node1" {
+		t.Errorf("policy = %+v", p)
+	}
+	if policies[1].Spec.LinkType != "ib" {
+		t.Errorf("InfiniBand PF link type = %q, want ib", policies[1].Spec.LinkType)
+	}
+
+	out, err := MarshalYAMLDocuments(policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := strings.Split(string(out), "---\n")
+	if len(docs) != 2 {
+		t.Fatalf("got %d YAML documents, want 2:\n%s", len(docs), out)
+	}
+	var back SriovNetworkNodePolicy
+	if err := yaml.Unmarshal([]byte(docs[1]), &back); err != nil || back.Spec.NumVfs != 8 {
+		t.Errorf("second document = %+v, %v", back, err)
+	}
+}
@@ -0,0 +1,149 @@
+// Package kubeapi is a minimal client of the Kubernetes API server's REST
+// API, authenticated with the pod's service account. It avoids a
+// dependency on client-go for the few requests kubeevents and kubestore
+// send, which build their own request bodies.
+package kubeapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ServiceAccountDir is where a pod's service account token and the
+// cluster CA are mounted, replaceable in tests.
+var ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// ErrNotInCluster is returned by InCluster outside a Kubernetes pod.
+var ErrNotInCluster = errors.New("not running in a Kubernetes pod: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are unset")
+
+// Client sends requests to the API server at Server.
+type Client struct {
+	// Server is the base URL of the API server, e.g.
+	// "https://10.96.0.1:443".
+	Server string
+	// Token is the bearer token authenticating requests.
+	Token string
+	// TokenFile, if set, holds the token instead. It is read for every
+	// request, as the kubelet rotates projected service account tokens.
+	TokenFile string
+	// HTTP sends the requests; http.DefaultClient when nil.
+	HTTP *http.Client
+}
+
+// InCluster returns a Client for the API server of the cluster the pod
+// runs in, authenticated with the pod's service account.
+func InCluster() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, ErrNotInCluster
+	}
+	tokenFile := filepath.Join(ServiceAccountDir, "token")
+	if _, err := readToken(tokenFile); err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(filepath.Join(ServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("reading the cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate in %s", filepath.Join(ServiceAccountDir, "ca.crt"))
+	}
+	return &Client{
+		Server:    "https://" + net.JoinHostPort(host, port),
+		TokenFile: tokenFile,
+		HTTP: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}},
+		},
+	}, nil
+}
+
+// APIError is an error response of the API server.
+type APIError struct {
+	Method, Path string
+	// Code is the HTTP status code, e.g. http.StatusNotFound.
+	Code    int
+	Status  string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s: %s: %s", e.Method, e.Path, e.Status, e.Message)
+}
+
+// Do sends a request to path, with body as JSON of contentType unless body
+// is nil, and decodes the JSON response into out unless out is nil. Error
+// responses are returned as *APIError.
+func (c *Client) Do(ctx context.Context, method, path, contentType string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.Server, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	token := c.Token
+	if c.TokenFile != "" {
+		if token, err = readToken(c.TokenFile); err != nil {
+			return err
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		// The API server explains itself in a Status object
+		var status struct {
+			Message string `json:"message"`
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(msg, &status) == nil && status.Message != "" {
+			msg = []byte(status.Message)
+		}
+		return &APIError{Method: method, Path: path, Code: resp.StatusCode, Status: resp.Status, Message: strings.TrimSpace(string(msg))}
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// readToken reads the bearer token in path.
+func readToken(path string) (string, error) {
+	token, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading the service account token: %w", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
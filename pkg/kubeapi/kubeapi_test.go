@@ -0,0 +1,125 @@
+package kubeapi
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestClient_Do(t *testing.T) {
+	var auth, contentType, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		auth, contentType, body = r.Header.Get("Authorization"), r.Header.Get("Content-Type"), string(data)
+		io.WriteString(w, `{"name":"worker-1"}`)
+	}))
+	defer srv.Close()
+	c := &Client{Server: srv.URL + "/", Token: "secret"}
+
+	var out struct {
+		Name string `json:"name"`
+	}
+	if err := c.Do(context.Background(), http.MethodPatch, "/api/v1/nodes/worker-1", "application/merge-patch+json", map[string]string{"a": "b"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer secret" || contentType != "application/merge-patch+json" || body != `{"a":"b"}` {
+		t.Errorf("request = %q %q %s", auth, contentType, body)
+	}
+	if out.Name != "worker-1" {
+		t.Errorf("decoded response = %+v", out)
+	}
+}
+
+func TestClient_DoRereadsTokenFile(t *testing.T) {
+	var auth []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	c := &Client{Server: srv.URL, TokenFile: tokenFile}
+
+	// The kubelet rotates the token between the two requests
+	for _, token := range []string{"first\n", "second\n"} {
+		if err := os.WriteFile(tokenFile, []byte(token), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Do(context.Background(), http.MethodGet, "/api/v1/nodes/worker-1", "", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"Bearer first", "Bearer second"}; !slices.Equal(auth, want) {
+		t.Errorf("Authorization headers = %q, want %q", auth, want)
+	}
+
+	os.Remove(tokenFile)
+	if err := c.Do(context.Background(), http.MethodGet, "/api/v1/nodes/worker-1", "", nil, nil); err == nil {
+		t.Error("Do() without the token file succeeded, want an error")
+	}
+}
+
+func TestClient_APIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `{"kind":"Status","message":"events is forbidden: User \"system:serviceaccount:kube-system:default\" cannot create resource \"events\""}`)
+	}))
+	defer srv.Close()
+	c := &Client{Server: srv.URL}
+
+	err := c.Do(context.Background(), http.MethodPost, "/api/v1/namespaces/default/events", "application/json", map[string]string{}, nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+		t.Fatalf("Do() error = %v, want an *APIError with code 403", err)
+	}
+	if !strings.Contains(err.Error(), "403 Forbidden") || !strings.Contains(err.Error(), "cannot create resource") {
+		t.Errorf("Do() error = %v, want the API server's message", err)
+	}
+}
+
+func TestInCluster(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	if _, err := InCluster(); !errors.Is(err, ErrNotInCluster) {
+		t.Errorf("InCluster() error = %v, want ErrNotInCluster", err)
+	}
+
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	dir := t.TempDir()
+	saved := ServiceAccountDir
+	ServiceAccountDir = dir
+	defer func() { ServiceAccountDir = saved }()
+	host, port, _ := strings.Cut(strings.TrimPrefix(srv.URL, "https://"), ":")
+	t.Setenv("KUBERNETES_SERVICE_HOST", host)
+	t.Setenv("KUBERNETES_SERVICE_PORT", port)
+
+	if _, err := InCluster(); err == nil {
+		t.Error("InCluster() should fail without a service account token")
+	}
+	os.WriteFile(filepath.Join(dir, "token"), []byte("secret\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "ca.crt"), []byte("not a certificate"), 0644)
+	if _, err := InCluster(); err == nil {
+		t.Error("InCluster() should fail without a CA certificate")
+	}
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	os.WriteFile(filepath.Join(dir, "ca.crt"), ca, 0644)
+	c, err := InCluster()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Server != srv.URL || c.TokenFile != filepath.Join(dir, "token") {
+		t.Errorf("InCluster() = %+v", c)
+	}
+	// The TLS handshake trusts the cluster CA; the 404 comes from the server
+	if err := c.Do(context.Background(), http.MethodGet, "/api/v1/nodes/worker-1", "", nil, nil); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Do() error = %v, want a 404 from the server", err)
+	}
+}
@@ -0,0 +1,190 @@
+// Package kubeevents reports RDMA problems of a node to the Kubernetes API
+// server, so cluster operators see them in kubectl describe node rather than
+// in node-local logs: as Events on the Node object and, optionally, as a
+// node condition such as RDMAHealthy.
+//
+// It sends its requests with a kubeapi.Client, usually authenticated with
+// the pod's service account, which needs to create events and patch
+// nodes/status.
+package kubeevents
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Nativu5/rdma-cdi/pkg/kubeapi"
+)
+
+// Event types, as the Kubernetes API names them.
+const (
+	Normal  = "Normal"
+	Warning = "Warning"
+)
+
+// DefaultCondition is the node condition type rdma-cdi maintains.
+const DefaultCondition = "RDMAHealthy"
+
+// Component is the source component of the events.
+const Component = "rdma-cdi"
+
+// eventNamespace is where node events are created, as the kubelet does:
+// nodes have no namespace and kubectl describe node searches all of them.
+const eventNamespace = "default"
+
+// maxMessage bounds event and condition messages; the API server refuses
+// event messages over 1 KiB.
+const maxMessage = 1024
+
+// Event is a Kubernetes Event about the node.
+type Event struct {
+	// Type is Normal or Warning.
+	Type string
+	// Reason is a short CamelCase machine-readable cause, e.g.
+	// "SpecReconcileFailed".
+	Reason  string
+	Message string
+}
+
+// Condition is a node condition, e.g. RDMAHealthy.
+type Condition struct {
+	Type string
+	// Status is true when the condition holds.
+	Status  bool
+	Reason  string
+	Message string
+	// Since is when Status last changed; now when zero.
+	Since time.Time
+}
+
+// Sink publishes events and conditions about a node. Client publishes them
+// to the API server.
+type Sink interface {
+	Event(ctx context.Context, ev Event) error
+	SetCondition(ctx context.Context, c Condition) error
+}
+
+// Client is a Sink creating Events and patching the status of Node through
+// the API server.
+type Client struct {
+	// API sends the requests, e.g. a client from kubeapi.InCluster.
+	API *kubeapi.Client
+	// Node is the name of the node the events are about.
+	Node string
+
+	now func() time.Time
+}
+
+// The subset of the Kubernetes API types Client sends, avoiding a
+// dependency on k8s.io/api.
+type objectMeta struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type objectReference struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	UID  string `json:"uid,omitempty"`
+}
+
+type eventSource struct {
+	Component string `json:"component"`
+	Host      string `json:"host,omitempty"`
+}
+
+type event struct {
+	APIVersion         string          `json:"apiVersion"`
+	Kind               string          `json:"kind"`
+	Metadata           objectMeta      `json:"metadata"`
+	InvolvedObject     objectReference `json:"involvedObject"`
+	Reason             string          `json:"reason"`
+	Message            string          `json:"message"`
+	Type               string          `json:"type"`
+	Source             eventSource     `json:"source"`
+	FirstTimestamp     string          `json:"firstTimestamp"`
+	LastTimestamp      string          `json:"lastTimestamp"`
+	Count              int             `json:"count"`
+	ReportingComponent string          `json:"reportingComponent"`
+	ReportingInstance  string          `json:"reportingInstance"`
+}
+
+type nodeCondition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason,omitempty"`
+	Message            string `json:"message,omitempty"`
+	LastHeartbeatTime  string `json:"lastHeartbeatTime"`
+	LastTransitionTime string `json:"lastTransitionTime"`
+}
+
+// Event creates ev on the node.
+func (c *Client) Event(ctx context.Context, ev Event) error {
+	now := c.clock()
+	ts := now.UTC().Format(time.RFC3339)
+	body := event{
+		APIVersion: "v1",
+		Kind:       "Event",
+		// Named like client-go's recorder does
+		Metadata: objectMeta{Name: fmt.Sprintf("%s.%x", c.Node, now.UnixNano()), Namespace: eventNamespace},
+		// kubectl describe node matches node events by name, with the
+		// node name as UID like the kubelet's
+		InvolvedObject:     objectReference{Kind: "Node", Name: c.Node, UID: c.Node},
+		Reason:             ev.Reason,
+		Message:            truncate(ev.Message),
+		Type:               ev.Type,
+		Source:             eventSource{Component: Component, Host: c.Node},
+		FirstTimestamp:     ts,
+		LastTimestamp:      ts,
+		Count:              1,
+		ReportingComponent: Component,
+		ReportingInstance:  Component + "-" + c.Node,
+	}
+	return c.API.Do(ctx, http.MethodPost, "/api/v1/namespaces/"+eventNamespace+"/events", "application/json", body, nil)
+}
+
+// SetCondition sets cond on the node's status, replacing the condition of
+// the same type.
+func (c *Client) SetCondition(ctx context.Context, cond Condition) error {
+	now := c.clock()
+	since := cond.Since
+	if since.IsZero() {
+		since = now
+	}
+	status := "False"
+	if cond.Status {
+		status = "True"
+	}
+	patch := map[string]any{"status": map[string]any{"conditions": []nodeCondition{{
+		Type:               cond.Type,
+		Status:             status,
+		Reason:             cond.Reason,
+		Message:            truncate(cond.Message),
+		LastHeartbeatTime:  now.UTC().Format(time.RFC3339),
+		LastTransitionTime: since.UTC().Format(time.RFC3339),
+	}}}}
+	// Conditions merge by type in a strategic merge patch
+	return c.API.Do(ctx, http.MethodPatch, "/api/v1/nodes/"+url.PathEscape(c.Node)+"/status", "application/strategic-merge-patch+json", patch, nil)
+}
+
+func (c *Client) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// truncate shortens s to maxMessage bytes, on a rune boundary.
+func truncate(s string) string {
+	if len(s) <= maxMessage {
+		return s
+	}
+	cut := maxMessage - len("...")
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
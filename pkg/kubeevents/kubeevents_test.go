@@ -0,0 +1,97 @@
+package kubeevents
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nativu5/rdma-cdi/pkg/kubeapi"
+)
+
+type request struct {
+	method, path, contentType, auth string
+	body                            map[string]any
+}
+
+func apiServer(t *testing.T, status int) (*httptest.Server, *[]request) {
+	t.Helper()
+	var got []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		req := request{method: r.Method, path: r.URL.Path, contentType: r.Header.Get("Content-Type"), auth: r.Header.Get("Authorization")}
+		if err := json.Unmarshal(data, &req.body); err != nil {
+			t.Errorf("request body is not JSON: %v", err)
+		}
+		got = append(got, req)
+		w.WriteHeader(status)
+		if status/100 != 2 {
+			io.WriteString(w, `{"kind":"Status","message":"events is forbidden: User \"system:serviceaccount:kube-system:default\" cannot create resource \"events\""}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &got
+}
+
+func TestClient_Event(t *testing.T) {
+	srv, got := apiServer(t, http.StatusCreated)
+	now := time.Date(2026, 10, 16, 8, 30, 0, 0, time.UTC)
+	c := &Client{API: &kubeapi.Client{Server: srv.URL, Token: "secret"}, Node: "worker-1", now: func() time.Time { return now }}
+
+	if err := c.Event(context.Background(), Event{Type: Warning, Reason: ReasonReconcileFailed, Message: strings.Repeat("x", 2000)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*got) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*got))
+	}
+	req := (*got)[0]
+	if req.method != http.MethodPost || req.path != "/api/v1/namespaces/default/events" || req.auth != "Bearer secret" {
+		t.Errorf("request = %s %s (%s)", req.method, req.path, req.auth)
+	}
+	obj := req.body["involvedObject"].(map[string]any)
+	if obj["kind"] != "Node" || obj["name"] != "worker-1" || obj["uid"] != "worker-1" {
+		t.Errorf("involvedObject = %v", obj)
+	}
+	if req.body["reason"] != ReasonReconcileFailed || req.body["type"] != Warning || req.body["lastTimestamp"] != "2026-10-16T08:30:00Z" {
+		t.Errorf("event = %v", req.body)
+	}
+	if msg := req.body["message"].(string); len(msg) != maxMessage || !strings.HasSuffix(msg, "...") {
+		t.Errorf("message of %d bytes should be truncated to %d", len(msg), maxMessage)
+	}
+}
+
+func TestClient_SetCondition(t *testing.T) {
+	srv, got := apiServer(t, http.StatusOK)
+	now := time.Date(2026, 10, 16, 8, 30, 0, 0, time.UTC)
+	c := &Client{API: &kubeapi.Client{Server: srv.URL}, Node: "worker-1", now: func() time.Time { return now }}
+
+	cond := Condition{Type: DefaultCondition, Reason: ReasonUnhealthy, Message: "1 check(s) failing", Since: now.Add(-time.Hour)}
+	if err := c.SetCondition(context.Background(), cond); err != nil {
+		t.Fatal(err)
+	}
+	req := (*got)[0]
+	if req.method != http.MethodPatch || req.path != "/api/v1/nodes/worker-1/status" || req.contentType != "application/strategic-merge-patch+json" {
+		t.Errorf("request = %s %s (%s)", req.method, req.path, req.contentType)
+	}
+	conds := req.body["status"].(map[string]any)["conditions"].([]any)
+	want := map[string]any{
+		"type":               DefaultCondition,
+		"status":             "False",
+		"reason":             ReasonUnhealthy,
+		"message":            "1 check(s) failing",
+		"lastHeartbeatTime":  "2026-10-16T08:30:00Z",
+		"lastTransitionTime": "2026-10-16T07:30:00Z",
+	}
+	if len(conds) != 1 {
+		t.Fatalf("conditions = %v", conds)
+	}
+	for k, v := range want {
+		if conds[0].(map[string]any)[k] != v {
+			t.Errorf("condition %s = %v, want %v", k, conds[0].(map[string]any)[k], v)
+		}
+	}
+}
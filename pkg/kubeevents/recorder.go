@@ -0,0 +1,138 @@
+package kubeevents
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Nativu5/rdma-cdi/pkg/doctor"
+)
+
+// Event reasons of Recorder.
+const (
+	ReasonHealthy         = "RDMAHealthy"
+	ReasonDegraded        = "RDMADegraded"
+	ReasonUnhealthy       = "RDMAUnhealthy"
+	ReasonReconcileFailed = "SpecReconcileFailed"
+)
+
+// Recorder turns doctor reports and spec reconciliation failures into
+// events on a Sink. Reports are only published when the doctor status
+// changes, so a node that stays broken does not flood the API server.
+// Failing to publish is logged, never returned: the guard or plugin
+// reporting through the Recorder carries on.
+type Recorder struct {
+	sink Sink
+	// condition is the node condition type to maintain, or "" for none.
+	condition string
+
+	mu sync.Mutex
+	// last is the status of the last published report, nil before any.
+	last *status
+	// since is when the condition last flipped.
+	since time.Time
+}
+
+// status is what Recorder compares reports by.
+type status struct {
+	severity doctor.Severity
+	summary  string
+}
+
+// NewRecorder returns a Recorder publishing to sink. With a non-empty
+// condition type, it also sets that node condition: true unless a doctor
+// check fails.
+func NewRecorder(sink Sink, condition string) *Recorder {
+	return &Recorder{sink: sink, condition: condition}
+}
+
+// ObserveReport publishes report if its status differs from the last one
+// observed: a Warning event when checks fail or warn, a Normal one when
+// they recover, and the node condition when it flips.
+func (r *Recorder) ObserveReport(ctx context.Context, report *doctor.Report) {
+	cur := reportStatus(report)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prev := r.last
+	if prev != nil && *prev == cur {
+		return
+	}
+	r.last = &cur
+
+	// A node healthy from the start has nothing to tell
+	if prev != nil || cur.severity != doctor.Pass {
+		ev := Event{Type: Warning, Reason: ReasonUnhealthy, Message: cur.summary}
+		switch cur.severity {
+		case doctor.Pass:
+			ev = Event{Type: Normal, Reason: ReasonHealthy, Message: cur.summary}
+		case doctor.Warn:
+			ev.Reason = ReasonDegraded
+		}
+		if err := r.sink.Event(ctx, ev); err != nil {
+			log.Warnf("Cannot record the %s event: %v", ev.Reason, err)
+		}
+	}
+
+	if r.condition == "" {
+		return
+	}
+	// The message follows every change, the transition time only flips
+	healthy := cur.severity != doctor.Fail
+	if prev == nil || (prev.severity != doctor.Fail) != healthy {
+		r.since = time.Now()
+	}
+	reason := ReasonHealthy
+	if !healthy {
+		reason = ReasonUnhealthy
+	}
+	c := Condition{Type: r.condition, Status: healthy, Reason: reason, Message: cur.summary, Since: r.since}
+	if err := r.sink.SetCondition(ctx, c); err != nil {
+		log.Warnf("Cannot set the %s node condition: %v", r.condition, err)
+	}
+}
+
+// ReconcileFailed publishes a Warning event for a spec file that could not
+// be written back or brought in line with the hardware.
+func (r *Recorder) ReconcileFailed(ctx context.Context, spec string, err error) {
+	ev := Event{Type: Warning, Reason: ReasonReconcileFailed, Message: fmt.Sprintf("Cannot reconcile CDI spec %s: %v", spec, err)}
+	if err := r.sink.Event(ctx, ev); err != nil {
+		log.Warnf("Cannot record the %s event: %v", ev.Reason, err)
+	}
+}
+
+// reportStatus sums report up by its worst severity and the checks that
+// reached it.
+func reportStatus(report *doctor.Report) status {
+	worst := doctor.Pass
+	switch {
+	case report.HasFail:
+		worst = doctor.Fail
+	case report.HasWarn:
+		worst = doctor.Warn
+	}
+	if worst == doctor.Pass {
+		return status{worst, "All RDMA checks pass"}
+	}
+
+	var parts []string
+	for _, cr := range report.Results {
+		if cr.Severity != worst {
+			continue
+		}
+		part := cr.ID + ": " + cr.Message
+		if cr.Device != "" {
+			part = cr.ID + " on " + cr.Device + ": " + cr.Message
+		}
+		parts = append(parts, part)
+	}
+	verb := "failing"
+	if worst == doctor.Warn {
+		verb = "warning"
+	}
+	return status{worst, fmt.Sprintf("%d check(s) %s: %s", len(parts), verb, strings.Join(parts, "; "))}
+}
@@ -0,0 +1,99 @@
+package kubeevents
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Nativu5/rdma-cdi/pkg/doctor"
+)
+
+type fakeSink struct {
+	events     []Event
+	conditions []Condition
+	err        error
+}
+
+func (s *fakeSink) Event(_ context.Context, ev Event) error {
+	s.events = append(s.events, ev)
+	return s.err
+}
+
+func (s *fakeSink) SetCondition(_ context.Context, c Condition) error {
+	s.conditions = append(s.conditions, c)
+	return s.err
+}
+
+func TestRecorder_ObserveReport(t *testing.T) {
+	sink := &fakeSink{}
+	r := NewRecorder(sink, DefaultCondition)
+	ctx := context.Background()
+
+	healthy := doctor.NewReport(doctor.CheckResult{Check: "link_state", Severity: doctor.Pass, Message: "up"})
+	linkDown := doctor.NewReport(doctor.CheckResult{Check: "link_state", Severity: doctor.Warn, Message: "link is down", Device: "0000:17:00.0"})
+	noModule := doctor.NewReport(
+		doctor.CheckResult{Check: "link_state", Severity: doctor.Warn, Message: "link is down", Device: "0000:17:00.0"},
+		doctor.CheckResult{Check: "kernel_modules", Severity: doctor.Fail, Message: "mlx5_ib is not loaded", Device: "0000:17:00.0"},
+	)
+
+	r.ObserveReport(ctx, healthy)
+	if len(sink.events) != 0 || len(sink.conditions) != 1 || !sink.conditions[0].Status {
+		t.Fatalf("a healthy start should only set the condition: %+v, %+v", sink.events, sink.conditions)
+	}
+	since := sink.conditions[0].Since
+
+	r.ObserveReport(ctx, healthy)
+	r.ObserveReport(ctx, linkDown)
+	r.ObserveReport(ctx, linkDown)
+	r.ObserveReport(ctx, noModule)
+	r.ObserveReport(ctx, healthy)
+
+	wantEvents := []Event{
+		{Warning, ReasonDegraded, "1 check(s) warning: doctor.link.state on 0000:17:00.0: link is down"},
+		{Warning, ReasonUnhealthy, "1 check(s) failing: doctor.kernel_modules on 0000:17:00.0: mlx5_ib is not loaded"},
+		{Normal, ReasonHealthy, "All RDMA checks pass"},
+	}
+	if len(sink.events) != len(wantEvents) {
+		t.Fatalf("events = %+v, want %+v", sink.events, wantEvents)
+	}
+	for i, want := range wantEvents {
+		if sink.events[i] != want {
+			t.Errorf("event %d = %+v, want %+v", i, sink.events[i], want)
+		}
+	}
+
+	// Warnings update the message without flipping the condition
+	if len(sink.conditions) != 4 {
+		t.Fatalf("conditions = %+v", sink.conditions)
+	}
+	if c := sink.conditions[1]; !c.Status || !c.Since.Equal(since) || !strings.Contains(c.Message, "link is down") {
+		t.Errorf("condition after a warning = %+v", c)
+	}
+	if c := sink.conditions[2]; c.Status || c.Reason != ReasonUnhealthy || c.Since.Before(since) {
+		t.Errorf("condition after a failure = %+v", c)
+	}
+	if c := sink.conditions[3]; !c.Status || c.Reason != ReasonHealthy {
+		t.Errorf("condition after recovery = %+v", c)
+	}
+}
+
+func TestRecorder_NoCondition(t *testing.T) {
+	sink := &fakeSink{err: errors.New("forbidden")}
+	r := NewRecorder(sink, "")
+	ctx := context.Background()
+
+	r.ObserveReport(ctx, doctor.NewReport(doctor.CheckResult{Check: "kernel_version", Severity: doctor.Fail, Message: "too old"}))
+	r.ReconcileFailed(ctx, "/etc/cdi/rdma.yaml", errors.New("read-only file system"))
+
+	if len(sink.conditions) != 0 {
+		t.Errorf("no condition should be set without a condition type: %+v", sink.conditions)
+	}
+	want := []Event{
+		{Warning, ReasonUnhealthy, "1 check(s) failing: doctor.kernel.version: too old"},
+		{Warning, ReasonReconcileFailed, "Cannot reconcile CDI spec /etc/cdi/rdma.yaml: read-only file system"},
+	}
+	if len(sink.events) != 2 || sink.events[0] != want[0] || sink.events[1] != want[1] {
+		t.Errorf("events = %+v, want %+v", sink.events, want)
+	}
+}
@@ -0,0 +1,294 @@
+// Package kubeletplugin holds the kubelet plugin-watcher registration
+// plumbing shared by the Kubernetes modes embedding rdma-cdi (a DRA
+// driver or a device plugin): it serves the registration socket under
+// the kubelet's plugins_registry directory, brings it back when the
+// kubelet restarts and wipes it, and tracks whether the kubelet accepted
+// the plugin, for health probes and metrics.
+//
+// The package is transport-agnostic: the mode supplies the gRPC server
+// carrying the kubelet's pluginregistration.Registration service, whose
+// GetInfo returns Registrar.Info and whose NotifyRegistrationStatus
+// forwards to Registrar.NotifyRegistrationStatus.
+package kubeletplugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultRegistryDir is where the kubelet's plugin watcher looks for
+// registration sockets.
+const DefaultRegistryDir = "/var/lib/kubelet/plugins_registry"
+
+// Plugin types, as the kubelet's pluginregistration API names them.
+const (
+	DRAPlugin    = "DRAPlugin"
+	DevicePlugin = "DevicePlugin"
+)
+
+// ErrNotRegistered is returned by Registrar.Healthy until the kubelet
+// reports that it registered the plugin.
+var ErrNotRegistered = errors.New("plugin is not registered with the kubelet")
+
+// restartDelay is how long Run waits before serving again after the
+// server failed, replaceable in tests.
+var restartDelay = time.Second
+
+// Server serves the plugin's gRPC services on a listener; *grpc.Server
+// satisfies it. Stop must make Serve return.
+type Server interface {
+	Serve(net.Listener) error
+	Stop()
+}
+
+// Info is what the Registration service's GetInfo reports to the kubelet.
+type Info struct {
+	// Type is DRAPlugin or DevicePlugin.
+	Type string
+	// Name is the plugin name, e.g. the DRA driver name or the device
+	// plugin's resource name.
+	Name string
+	// Endpoint is the socket of the plugin's own service, when it differs
+	// from the registration socket (DRA drivers serve it separately).
+	Endpoint string
+	// SupportedVersions lists the versions of the plugin's service API.
+	SupportedVersions []string
+}
+
+// Config configures a Registrar.
+type Config struct {
+	Info Info
+	// RegistryDir defaults to DefaultRegistryDir.
+	RegistryDir string
+	// NewServer returns the server for each (re)created socket; a stopped
+	// gRPC server cannot serve again.
+	NewServer func() Server
+}
+
+// Registrar keeps a plugin registered with the kubelet. It is safe for
+// concurrent use.
+type Registrar struct {
+	cfg    Config
+	socket string
+
+	mu         sync.Mutex
+	serving    bool
+	registered bool
+	lastError  string
+	counts     struct{ registrations, failures, restarts, serveErrors uint64 }
+}
+
+// NewRegistrar returns a Registrar for cfg, which must name the plugin,
+// its type, and how to create its server.
+func NewRegistrar(cfg Config) (*Registrar, error) {
+	if cfg.Info.Type != DRAPlugin && cfg.Info.Type != DevicePlugin {
+		return nil, fmt.Errorf("unsupported plugin type %q: use %s or %s", cfg.Info.Type, DRAPlugin, DevicePlugin)
+	}
+	if cfg.Info.Name == "" || strings.ContainsRune(cfg.Info.Name, '/') {
+		return nil, fmt.Errorf("invalid plugin name %q", cfg.Info.Name)
+	}
+	if cfg.NewServer == nil {
+		return nil, errors.New("no server for the registration socket")
+	}
+	if cfg.RegistryDir == "" {
+		cfg.RegistryDir = DefaultRegistryDir
+	}
+	return &Registrar{cfg: cfg, socket: filepath.Join(cfg.RegistryDir, cfg.Info.Name+"-reg.sock")}, nil
+}
+
+// SocketPath returns the registration socket, e.g.
+// /var/lib/kubelet/plugins_registry/rdma.example.com-reg.sock.
+func (r *Registrar) SocketPath() string {
+	return r.socket
+}
+
+// Info returns what GetInfo reports: the configured Info, with the
+// registration socket as Endpoint unless one was set.
+func (r *Registrar) Info() Info {
+	info := r.cfg.Info
+	if info.Endpoint == "" {
+		info.Endpoint = r.socket
+	}
+	return info
+}
+
+// NotifyRegistrationStatus records the kubelet's verdict on the plugin,
+// as sent through the Registration service.
+func (r *Registrar) NotifyRegistrationStatus(registered bool, errMsg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.registered, r.lastError = registered, errMsg
+	if registered {
+		r.counts.registrations++
+		log.Infof("Registered %s %s with the kubelet", r.cfg.Info.Type, r.cfg.Info.Name)
+	} else {
+		r.counts.failures++
+		log.Warnf("The kubelet refused %s %s: %s", r.cfg.Info.Type, r.cfg.Info.Name, errMsg)
+	}
+}
+
+// Healthy returns nil while the registration socket is served and the
+// kubelet has registered the plugin, for liveness and readiness probes.
+func (r *Registrar) Healthy() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case !r.serving:
+		return fmt.Errorf("registration socket %s is not served", r.socket)
+	case !r.registered && r.lastError != "":
+		return fmt.Errorf("%w: %s", ErrNotRegistered, r.lastError)
+	case !r.registered:
+		return ErrNotRegistered
+	}
+	return nil
+}
+
+// Run serves the registration socket until ctx is done, then removes it.
+// When the socket disappears, as when the kubelet restarts with a clean
+// plugins_registry, or the server fails, it is created again so the
+// kubelet's watcher registers the plugin anew; until it does, Healthy
+// reports ErrNotRegistered.
+func (r *Registrar) Run(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("cannot watch %s: %w", r.cfg.RegistryDir, err)
+	}
+	defer watcher.Close()
+
+	for first := true; ; first = false {
+		if err := r.watchDir(watcher); err != nil {
+			return err
+		}
+		srv, lis, serveErr, err := r.serve()
+		if err != nil {
+			return err
+		}
+		if !first {
+			r.mu.Lock()
+			r.counts.restarts++
+			r.mu.Unlock()
+		}
+
+		failed := false
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				r.stop(srv, lis)
+				os.Remove(r.socket)
+				return nil
+			case err := <-serveErr:
+				log.Warnf("Registration server of %s failed: %v", r.cfg.Info.Name, err)
+				failed = true
+				break wait
+			case err := <-watcher.Errors:
+				log.Warnf("Plugin registry watch error: %v", err)
+			case ev := <-watcher.Events:
+				if r.removed(ev) {
+					log.Infof("Registration socket %s was removed; recreating it", r.socket)
+					break wait
+				}
+			}
+		}
+		r.stop(srv, lis)
+		if failed {
+			r.mu.Lock()
+			r.counts.serveErrors++
+			r.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(restartDelay):
+			}
+		}
+	}
+}
+
+// removed reports whether ev removed the registration socket or the
+// registry directory. Events of the sockets Run itself replaced are
+// told apart by the socket being back in place.
+func (r *Registrar) removed(ev fsnotify.Event) bool {
+	if ev.Name != r.socket && ev.Name != r.cfg.RegistryDir || ev.Op&(fsnotify.Remove|fsnotify.Rename) == 0 {
+		return false
+	}
+	_, err := os.Stat(r.socket)
+	return err != nil
+}
+
+// watchDir (re)creates and watches the registry directory.
+func (r *Registrar) watchDir(watcher *fsnotify.Watcher) error {
+	if err := os.MkdirAll(r.cfg.RegistryDir, 0750); err != nil {
+		return fmt.Errorf("failed to create %s: %w", r.cfg.RegistryDir, err)
+	}
+	if err := watcher.Add(r.cfg.RegistryDir); err != nil {
+		return fmt.Errorf("cannot watch %s: %w", r.cfg.RegistryDir, err)
+	}
+	return nil
+}
+
+// serve listens on the registration socket, replacing a stale one, and
+// starts a server on it.
+func (r *Registrar) serve() (Server, net.Listener, <-chan error, error) {
+	if err := os.Remove(r.socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil, fmt.Errorf("cannot remove stale socket: %w", err)
+	}
+	lis, err := net.Listen("unix", r.socket)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("cannot listen on %s: %w", r.socket, err)
+	}
+	srv := r.cfg.NewServer()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(lis)
+	}()
+
+	r.mu.Lock()
+	r.serving = true
+	r.mu.Unlock()
+	return srv, lis, serveErr, nil
+}
+
+// stop stops srv; the kubelet has to register the plugin again.
+func (r *Registrar) stop(srv Server, lis net.Listener) {
+	srv.Stop()
+	lis.Close()
+	r.mu.Lock()
+	r.serving, r.registered, r.lastError = false, false, ""
+	r.mu.Unlock()
+}
+
+// WriteMetrics renders the registration state in the Prometheus text
+// exposition format.
+func (r *Registrar) WriteMetrics(w io.Writer) error {
+	r.mu.Lock()
+	registered, counts := r.registered, r.counts
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	labels := fmt.Sprintf(`{plugin=%q,type=%q}`, r.cfg.Info.Name, r.cfg.Info.Type)
+	metric := func(name, typ, help string, value uint64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n%s%s %d\n", name, help, name, typ, name, labels, value)
+	}
+	var up uint64
+	if registered {
+		up = 1
+	}
+	metric("rdma_cdi_kubelet_registered", "gauge", "Whether the kubelet has registered the plugin.", up)
+	metric("rdma_cdi_kubelet_registrations_total", "counter", "Registrations the kubelet reported.", counts.registrations)
+	metric("rdma_cdi_kubelet_registration_failures_total", "counter", "Registrations the kubelet refused.", counts.failures)
+	metric("rdma_cdi_kubelet_socket_restarts_total", "counter", "Times the registration socket was recreated.", counts.restarts)
+	metric("rdma_cdi_kubelet_server_errors_total", "counter", "Times the registration server failed.", counts.serveErrors)
+	return bw.Flush()
+}
@@ -0,0 +1,133 @@
+package kubeletplugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer accepts and drops connections until stopped.
+type fakeServer struct {
+	mu  sync.Mutex
+	lis net.Listener
+}
+
+func (s *fakeServer) Serve(lis net.Listener) error {
+	s.mu.Lock()
+	s.lis = lis
+	s.mu.Unlock()
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return err
+		}
+		conn.Close()
+	}
+}
+
+func (s *fakeServer) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lis != nil {
+		s.lis.Close()
+	}
+}
+
+// eventually polls cond for up to five seconds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting until %s", what)
+}
+
+func TestNewRegistrar_Invalid(t *testing.T) {
+	newServer := func() Server { return &fakeServer{} }
+	for _, cfg := range []Config{
+		{Info: Info{Type: "CSIPlugin", Name: "rdma"}, NewServer: newServer},
+		{Info: Info{Type: DRAPlugin, Name: "a/b"}, NewServer: newServer},
+		{Info: Info{Type: DRAPlugin, Name: "rdma"}},
+	} {
+		if _, err := NewRegistrar(cfg); err == nil {
+			t.Errorf("NewRegistrar(%+v) should fail", cfg)
+		}
+	}
+}
+
+func TestRegistrar_Run(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRegistrar(Config{
+		Info:        Info{Type: DRAPlugin, Name: "rdma.example.com", SupportedVersions: []string{"v1"}},
+		RegistryDir: dir,
+		NewServer:   func() Server { return &fakeServer{} },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Info().Endpoint; got != dir+"/rdma.example.com-reg.sock" {
+		t.Errorf("Endpoint = %q, want the registration socket", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- r.Run(ctx) }()
+
+	dial := func() bool {
+		conn, err := net.Dial("unix", r.SocketPath())
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}
+	eventually(t, "the socket is served", dial)
+	if err := r.Healthy(); !errors.Is(err, ErrNotRegistered) {
+		t.Errorf("Healthy() before registration = %v, want ErrNotRegistered", err)
+	}
+	r.NotifyRegistrationStatus(false, "version mismatch")
+	if err := r.Healthy(); !errors.Is(err, ErrNotRegistered) || !strings.Contains(err.Error(), "version mismatch") {
+		t.Errorf("Healthy() after a refusal = %v", err)
+	}
+	r.NotifyRegistrationStatus(true, "")
+	if err := r.Healthy(); err != nil {
+		t.Errorf("Healthy() after registration = %v", err)
+	}
+
+	// A kubelet restart wiping the directory brings the socket back, and
+	// the plugin has to be registered again
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the socket is recreated", func() bool { return dial() && errors.Is(r.Healthy(), ErrNotRegistered) })
+
+	var metrics bytes.Buffer
+	if err := r.WriteMetrics(&metrics); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`rdma_cdi_kubelet_registered{plugin="rdma.example.com",type="DRAPlugin"} 0`,
+		`rdma_cdi_kubelet_registrations_total{plugin="rdma.example.com",type="DRAPlugin"} 1`,
+		`rdma_cdi_kubelet_registration_failures_total{plugin="rdma.example.com",type="DRAPlugin"} 1`,
+		`rdma_cdi_kubelet_socket_restarts_total{plugin="rdma.example.com",type="DRAPlugin"} 1`,
+	} {
+		if !strings.Contains(metrics.String(), want) {
+			t.Errorf("metrics lack %q:\n%s", want, metrics.String())
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run() = %v", err)
+	}
+	if _, err := os.Stat(r.SocketPath()); !os.IsNotExist(err) {
+		t.Error("the socket should be removed on shutdown")
+	}
+}
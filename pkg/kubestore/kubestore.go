@@ -0,0 +1,216 @@
+// Package kubestore keeps CDI specs in a Kubernetes ConfigMap or Secret,
+// for generating them away from the nodes, e.g. on the control plane from
+// captured sysfs trees. Each spec is one key of the object, named like its
+// spec file, so mounting the object at /etc/cdi on a node hands the specs
+// to its container runtime. An object holds at most 1 MiB of specs.
+package kubestore
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
+
+	"github.com/Nativu5/rdma-cdi/pkg/cdi"
+	"github.com/Nativu5/rdma-cdi/pkg/kubeapi"
+)
+
+// managedBy labels the objects the store creates.
+const managedBy = "rdma-cdi"
+
+// Store is a cdi.SpecStore keeping specs in the ConfigMap, or Secret, Name
+// in Namespace, which the first Put creates. Its service account needs to
+// get, create and patch the object.
+type Store struct {
+	Client    *kubeapi.Client
+	Namespace string
+	Name      string
+	// Secret keeps the specs in a Secret instead of a ConfigMap.
+	Secret bool
+	// Format is "yaml" (the default) or "json".
+	Format string
+}
+
+// object is the subset of a ConfigMap or Secret the store reads and
+// writes. Secret values are base64-encoded.
+type object struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   objectMeta        `json:"metadata"`
+	Data       map[string]string `json:"data"`
+}
+
+type objectMeta struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+func (s *Store) format() string {
+	if s.Format == "" {
+		return "yaml"
+	}
+	return s.Format
+}
+
+// path returns the API path of the object, or with name "" of the
+// collection it is created in.
+func (s *Store) path(name string) string {
+	resource := "configmaps"
+	if s.Secret {
+		resource = "secrets"
+	}
+	p := "/api/v1/namespaces/" + url.PathEscape(s.Namespace) + "/" + resource
+	if name != "" {
+		p += "/" + url.PathEscape(name)
+	}
+	return p
+}
+
+func (s *Store) encode(data []byte) string {
+	if s.Secret {
+		return base64.StdEncoding.EncodeToString(data)
+	}
+	return string(data)
+}
+
+func (s *Store) decode(value string) ([]byte, error) {
+	if s.Secret {
+		return base64.StdEncoding.DecodeString(value)
+	}
+	return []byte(value), nil
+}
+
+// get fetches the data of the object, which is nil if it does not exist.
+func (s *Store) get(ctx context.Context) (map[string]string, error) {
+	var obj object
+	err := s.Client.Do(ctx, http.MethodGet, s.path(s.Name), "", nil, &obj)
+	if isStatus(err, http.StatusNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return obj.Data, nil
+}
+
+// Get returns the spec of kind.
+func (s *Store) Get(ctx context.Context, kind string) (*cdiSpecs.Spec, error) {
+	key, err := cdi.KindFileName(kind, s.format())
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := data[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", cdi.ErrSpecNotFound, kind)
+	}
+	raw, err := s.decode(value)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode %s: %w", key, err)
+	}
+	return cdi.ParseSpec(raw)
+}
+
+// Put validates spec and stores it as WriteSpec would write it, creating
+// the object if needed. Only the key of spec is patched, so writers of
+// other specs do not overwrite each other.
+func (s *Store) Put(ctx context.Context, spec *cdiSpecs.Spec) error {
+	key, err := cdi.KindFileName(spec.Kind, s.format())
+	if err != nil {
+		return err
+	}
+	raw, err := cdi.MarshalSpec(spec, s.format())
+	if err != nil {
+		return err
+	}
+	value := s.encode(raw)
+
+	err = s.patch(ctx, key, value)
+	if !isStatus(err, http.StatusNotFound) {
+		return err
+	}
+	kind := "ConfigMap"
+	if s.Secret {
+		kind = "Secret"
+	}
+	obj := object{
+		APIVersion: "v1",
+		Kind:       kind,
+		Metadata: objectMeta{Name: s.Name, Namespace: s.Namespace,
+			Labels: map[string]string{"app.kubernetes.io/managed-by": managedBy}},
+		Data: map[string]string{key: value},
+	}
+	err = s.Client.Do(ctx, http.MethodPost, s.path(""), "application/json", obj, nil)
+	if isStatus(err, http.StatusConflict) {
+		// Created by another writer meanwhile
+		return s.patch(ctx, key, value)
+	}
+	return err
+}
+
+// Delete removes the key of kind from the object.
+func (s *Store) Delete(ctx context.Context, kind string) error {
+	key, err := cdi.KindFileName(kind, s.format())
+	if err != nil {
+		return err
+	}
+	if err := s.patch(ctx, key, nil); !isStatus(err, http.StatusNotFound) {
+		return err
+	}
+	return nil
+}
+
+// patch sets key to value, or removes it when value is nil, with a JSON
+// merge patch.
+func (s *Store) patch(ctx context.Context, key string, value any) error {
+	body := map[string]any{"data": map[string]any{key: value}}
+	return s.Client.Do(ctx, http.MethodPatch, s.path(s.Name), "application/merge-patch+json", body, nil)
+}
+
+// List returns the kinds of the specs in the object that follow this
+// tool's naming scheme. Values that do not parse are skipped.
+func (s *Store) List(ctx context.Context) ([]string, error) {
+	data, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var kinds []string
+	for key, value := range data {
+		if !cdi.IsManaged(key) || strings.TrimPrefix(filepath.Ext(key), ".") != s.format() {
+			continue
+		}
+		raw, err := s.decode(value)
+		if err != nil {
+			log.Debugf("skipping %s: %v", key, err)
+			continue
+		}
+		spec, err := cdi.ParseSpec(raw)
+		if err != nil {
+			log.Debugf("skipping %s: %v", key, err)
+			continue
+		}
+		if !slices.Contains(kinds, spec.Kind) {
+			kinds = append(kinds, spec.Kind)
+		}
+	}
+	slices.Sort(kinds)
+	return kinds, nil
+}
+
+// isStatus reports whether err is an API error with status code.
+func isStatus(err error, code int) bool {
+	var apiErr *kubeapi.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
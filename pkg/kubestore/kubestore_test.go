@@ -0,0 +1,153 @@
+package kubestore
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
+
+	"github.com/Nativu5/rdma-cdi/pkg/cdi"
+	"github.com/Nativu5/rdma-cdi/pkg/kubeapi"
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// apiServer fakes the API server for the objects of one namespace,
+// applying merge patches to their data only.
+type apiServer struct {
+	mu      sync.Mutex
+	objects map[string]*object // by API path
+}
+
+func newAPIServer(t *testing.T) (*apiServer, *kubeapi.Client) {
+	t.Helper()
+	a := &apiServer{objects: make(map[string]*object)}
+	srv := httptest.NewServer(a)
+	t.Cleanup(srv.Close)
+	return a, &kubeapi.Client{Server: srv.URL}
+}
+
+func (a *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	fail := func(code int) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"kind": "Status", "message": http.StatusText(code)})
+	}
+	switch r.Method {
+	case http.MethodGet:
+		obj, ok := a.objects[r.URL.Path]
+		if !ok {
+			fail(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(obj)
+	case http.MethodPost:
+		var obj object
+		json.NewDecoder(r.Body).Decode(&obj)
+		p := r.URL.Path + "/" + obj.Metadata.Name
+		if _, ok := a.objects[p]; ok {
+			fail(http.StatusConflict)
+			return
+		}
+		a.objects[p] = &obj
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodPatch:
+		obj, ok := a.objects[r.URL.Path]
+		if !ok {
+			fail(http.StatusNotFound)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/merge-patch+json" {
+			fail(http.StatusUnsupportedMediaType)
+			return
+		}
+		var patch struct {
+			Data map[string]*string `json:"data"`
+		}
+		json.NewDecoder(r.Body).Decode(&patch)
+		if obj.Data == nil {
+			obj.Data = make(map[string]string)
+		}
+		for k, v := range patch.Data {
+			if v == nil {
+				delete(obj.Data, k)
+			} else {
+				obj.Data[k] = *v
+			}
+		}
+	default:
+		fail(http.StatusMethodNotAllowed)
+	}
+}
+
+func testSpec(t *testing.T, name string) *cdiSpecs.Spec {
+	t.Helper()
+	dev := types.RdmaDevice{PciAddress: "0000:17:00.0", DeviceSpecs: []types.DeviceSpec{{HostPath: "/dev/infiniband/uverbs0", ContainerPath: "/dev/infiniband/uverbs0", Permissions: "rw"}}}
+	spec, err := cdi.BuildSpec("rdma", name, []types.RdmaDevice{dev})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+func TestStore(t *testing.T) {
+	for _, secret := range []bool{false, true} {
+		api, client := newAPIServer(t)
+		store := &Store{Client: client, Namespace: "rdma", Name: "cdi-specs", Secret: secret}
+		ctx := context.Background()
+
+		if kinds, err := store.List(ctx); err != nil || len(kinds) != 0 {
+			t.Errorf("List() before the object exists = %v, %v", kinds, err)
+		}
+		if _, err := store.Get(ctx, "rdma/dev-a"); !errors.Is(err, cdi.ErrSpecNotFound) {
+			t.Errorf("Get() before the object exists = %v, want ErrSpecNotFound", err)
+		}
+		if err := store.Delete(ctx, "rdma/dev-a"); err != nil {
+			t.Errorf("Delete() before the object exists = %v", err)
+		}
+		for _, name := range []string{"dev-a", "dev-b"} {
+			if err := store.Put(ctx, testSpec(t, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		path := "/api/v1/namespaces/rdma/configmaps/cdi-specs"
+		if secret {
+			path = "/api/v1/namespaces/rdma/secrets/cdi-specs"
+		}
+		obj := api.objects[path]
+		if obj == nil || len(obj.Data) != 2 || obj.Metadata.Labels["app.kubernetes.io/managed-by"] != "rdma-cdi" {
+			t.Fatalf("object at %s = %+v", path, obj)
+		}
+		value := obj.Data["rdma-cdi_rdma_dev-a.yaml"]
+		if secret {
+			raw, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				t.Fatalf("Secret value is not base64: %v", err)
+			}
+			value = string(raw)
+		}
+		if !strings.Contains(value, "kind: rdma/dev-a") {
+			t.Errorf("stored spec = %q", value)
+		}
+
+		got, err := store.Get(ctx, "rdma/dev-b")
+		if err != nil || got.Kind != "rdma/dev-b" || got.Annotations[cdi.ChecksumAnnotation] == "" {
+			t.Errorf("Get() = %+v, %v", got, err)
+		}
+		if err := store.Delete(ctx, "rdma/dev-a"); err != nil {
+			t.Fatal(err)
+		}
+		if kinds, err := store.List(ctx); err != nil || !slices.Equal(kinds, []string{"rdma/dev-b"}) {
+			t.Errorf("List() after Delete() = %v, %v", kinds, err)
+		}
+	}
+}
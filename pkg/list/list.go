@@ -0,0 +1,186 @@
+// Package list provides output formatting for the list subcommand.
+package list
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
+
+	"github.com/Nativu5/rdma-cdi/pkg/cdi"
+	"github.com/Nativu5/rdma-cdi/pkg/rdma"
+	"github.com/Nativu5/rdma-cdi/pkg/utils"
+)
+
+// Producer of an installed spec, as shown by list.
+const (
+	ToolSelf  = cdi.FilePrefix
+	ToolOther = "other"
+)
+
+// InstalledSpec is a spec file found in a CDI directory.
+type InstalledSpec struct {
+	Path string `json:"path"`
+	Kind string `json:"kind,omitempty"`
+	// Tool is ToolSelf for specs written by this tool, ToolOther otherwise.
+	Tool    string            `json:"tool"`
+	Devices []InstalledDevice `json:"devices"`
+	// Nodes is the number of spec-wide device nodes, injected along with
+	// any of the devices.
+	Nodes int `json:"nodes"`
+	// MissingNodes lists spec-wide device nodes absent on the host.
+	MissingNodes []string `json:"missing_nodes,omitempty"`
+	// Error is set when the file could not be parsed.
+	Error string `json:"error,omitempty"`
+}
+
+// InstalledDevice is one device of an installed spec.
+type InstalledDevice struct {
+	Name string `json:"name"`
+	// Nodes is the number of device nodes the device declares.
+	Nodes int `json:"nodes"`
+	// MissingNodes lists declared host device nodes that do not exist.
+	MissingNodes []string `json:"missing_nodes,omitempty"`
+}
+
+// Installed parses every spec file in dirs. Unparseable files are reported
+// with Error set rather than failing the whole listing. Host device nodes
+// are looked up under devRoot (e.g. "/host/dev" in a container).
+func Installed(dirs []string, devRoot string) ([]InstalledSpec, error) {
+	var out []InstalledSpec
+	for _, dir := range dirs {
+		files, err := cdi.ListAllSpecs(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			out = append(out, inspect(f, devRoot))
+		}
+	}
+	return out, nil
+}
+
+func inspect(path, devRoot string) InstalledSpec {
+	s := InstalledSpec{Path: path, Tool: ToolOther, Devices: []InstalledDevice{}}
+	if cdi.IsManaged(path) {
+		s.Tool = ToolSelf
+	}
+	spec, err := cdi.LoadSpec(path)
+	if err != nil {
+		s.Error = err.Error()
+		return s
+	}
+	s.Kind = spec.Kind
+	s.Nodes = len(spec.ContainerEdits.DeviceNodes)
+	s.MissingNodes = missingNodes(spec.ContainerEdits.DeviceNodes, devRoot)
+	for _, dev := range spec.Devices {
+		s.Devices = append(s.Devices, InstalledDevice{
+			Name:         dev.Name,
+			Nodes:        len(dev.ContainerEdits.DeviceNodes),
+			MissingNodes: missingNodes(dev.ContainerEdits.DeviceNodes, devRoot),
+		})
+	}
+	return s
+}
+
+// missingNodes returns the host paths of nodes that do not exist under
+// devRoot. As in the CDI spec, HostPath defaults to Path.
+func missingNodes(nodes []*cdiSpecs.DeviceNode, devRoot string) []string {
+	var missing []string
+	for _, n := range nodes {
+		p := n.HostPath
+		if p == "" {
+			p = n.Path
+		}
+		if _, err := os.Stat(utils.RebasePath(p, rdma.DefaultDevRoot, devRoot)); errors.Is(err, os.ErrNotExist) {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// PrintInstalledTable renders installed specs as a table with one row per
+// device.
+func PrintInstalledTable(w io.Writer, specs []InstalledSpec) {
+	table := tablewriter.NewTable(w)
+	table.Header("KIND", "DEVICE", "TOOL", "HOST NODES", "FILE")
+	for _, s := range specs {
+		if s.Error != "" {
+			table.Append("(unparseable)", "", s.Tool, s.Error, s.Path)
+			continue
+		}
+		for _, d := range s.Devices {
+			table.Append(s.Kind, d.Name, s.Tool, nodeStatus(s, d), s.Path)
+		}
+	}
+	table.Render()
+}
+
+// nodeStatus summarizes host node presence, e.g. "3/3" or "2/3 (missing
+// /dev/infiniband/uverbs1)". Spec-wide nodes count towards every device.
+func nodeStatus(s InstalledSpec, d InstalledDevice) string {
+	missing := append(append([]string{}, d.MissingNodes...), s.MissingNodes...)
+	total := d.Nodes + s.Nodes
+	if len(missing) == 0 {
+		return fmt.Sprintf("%d/%d", total, total)
+	}
+	return fmt.Sprintf("%d/%d (missing %s)", total-len(missing), total, strings.Join(missing, ", "))
+}
+
+// PrintInstalledJSON renders installed specs as JSON.
+func PrintInstalledJSON(w io.Writer, specs []InstalledSpec) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(specs)
+}
+
+// Status of a managed spec file compared to its state record.
+const (
+	StatusOK       = "ok"
+	StatusModified = "modified"
+	StatusMissing  = "missing"
+)
+
+// ManagedSpec is a state record together with the current status of its file.
+type ManagedSpec struct {
+	cdi.SpecRecord
+	Status string `json:"status"`
+}
+
+// Managed checks every spec recorded in state against the file on disk.
+func Managed(state *cdi.State) []ManagedSpec {
+	out := make([]ManagedSpec, 0, len(state.Specs))
+	for _, rec := range state.Specs {
+		status := StatusOK
+		if _, err := os.Stat(rec.Path); errors.Is(err, os.ErrNotExist) {
+			status = StatusMissing
+		} else if modified, err := state.Modified(rec.Path); err != nil || modified {
+			status = StatusModified
+		}
+		out = append(out, ManagedSpec{SpecRecord: rec, Status: status})
+	}
+	return out
+}
+
+// PrintManagedTable renders managed specs as a human-readable table.
+func PrintManagedTable(w io.Writer, specs []ManagedSpec) {
+	table := tablewriter.NewTable(w)
+	table.Header("KIND", "PATH", "DEVICES", "STATUS", "GENERATED")
+	for _, s := range specs {
+		table.Append(s.Kind, s.Path, strings.Join(s.Devices, ", "), s.Status, s.GeneratedAt.Format(time.RFC3339))
+	}
+	table.Render()
+}
+
+// PrintManagedJSON renders managed specs as JSON.
+func PrintManagedJSON(w io.Writer, specs []ManagedSpec) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(specs)
+}
@@ -0,0 +1,139 @@
+package list
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nativu5/rdma-cdi/pkg/cdi"
+	"github.com/Nativu5/rdma-cdi/pkg/rdma"
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+func TestInstalled_DevRoot(t *testing.T) {
+	dir, devRoot := t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(devRoot, "infiniband"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(devRoot, "infiniband", "uverbs0"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	devices := []types.RdmaDevice{{
+		PciAddress: "0000:17:00.0",
+		DeviceSpecs: []types.DeviceSpec{
+			{HostPath: "/dev/infiniband/uverbs0", ContainerPath: "/dev/infiniband/uverbs0", Permissions: "rw"},
+			{HostPath: "/dev/infiniband/uverbs9", ContainerPath: "/dev/infiniband/uverbs9", Permissions: "rw"},
+		},
+	}}
+	if err := cdi.CreateCDISpec("rdma", "net", devices, dir, "yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	specs, err := Installed([]string{dir}, devRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Missing nodes are still reported by their host path
+	if len(specs) != 1 || fmt.Sprint(specs[0].Devices[0].MissingNodes) != "[/dev/infiniband/uverbs9]" {
+		t.Errorf("unexpected node status: %+v", specs)
+	}
+}
+
+func TestInstalled(t *testing.T) {
+	dir := t.TempDir()
+	present := filepath.Join(t.TempDir(), "uverbs0")
+	if err := os.WriteFile(present, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(t.TempDir(), "uverbs1")
+
+	devices := []types.RdmaDevice{{
+		PciAddress: "0000:17:00.0",
+		DeviceSpecs: []types.DeviceSpec{
+			{HostPath: present, ContainerPath: "/dev/infiniband/uverbs0", Permissions: "rw"},
+			{HostPath: missing, ContainerPath: "/dev/infiniband/uverbs1", Permissions: "rw"},
+		},
+	}}
+	if err := cdi.CreateCDISpec("rdma", "net", devices, dir, "yaml"); err != nil {
+		t.Fatal(err)
+	}
+	other := "cdiVersion: 0.6.0\nkind: vendor.com/gpu\ndevices:\n- name: gpu0\n  containerEdits:\n    deviceNodes:\n    - path: " + present + "\n"
+	files := map[string]string{
+		"vendor-gpu.yml": other,
+		"broken.json":    "{",
+		"notes.txt":      "not a spec",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	specs, err := Installed([]string{dir, filepath.Join(dir, "does-not-exist")}, rdma.DefaultDevRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byFile := map[string]InstalledSpec{}
+	for _, s := range specs {
+		byFile[filepath.Base(s.Path)] = s
+	}
+	if len(byFile) != 3 {
+		t.Fatalf("expected 3 spec files (txt ignored), got %v", byFile)
+	}
+
+	ours := byFile["rdma-cdi_rdma_net.yaml"]
+	if ours.Tool != ToolSelf || ours.Kind != "rdma/net" || len(ours.Devices) != 1 {
+		t.Fatalf("unexpected spec: %+v", ours)
+	}
+	if d := ours.Devices[0]; d.Nodes != 2 || len(d.MissingNodes) != 1 || d.MissingNodes[0] != missing {
+		t.Errorf("unexpected node status: %+v", d)
+	}
+
+	gpu := byFile["vendor-gpu.yml"]
+	if gpu.Tool != ToolOther || gpu.Kind != "vendor.com/gpu" || len(gpu.Devices[0].MissingNodes) != 0 {
+		t.Errorf("unexpected foreign spec: %+v", gpu)
+	}
+	if byFile["broken.json"].Error == "" {
+		t.Error("unparseable spec should carry an error")
+	}
+
+	var buf bytes.Buffer
+	PrintInstalledTable(&buf, specs)
+	if !strings.Contains(buf.String(), "1/2 (missing "+missing+")") {
+		t.Errorf("table should show node status, got:\n%s", buf.String())
+	}
+}
+
+func TestManaged(t *testing.T) {
+	dir := t.TempDir()
+	state := &cdi.State{}
+	for _, f := range []string{"ok.yaml", "edited.yaml", "gone.yaml"} {
+		p := filepath.Join(dir, f)
+		if err := os.WriteFile(p, []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := state.Record(p, "rdma/x", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "edited.yaml"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "gone.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"ok.yaml": StatusOK, "edited.yaml": StatusModified, "gone.yaml": StatusMissing}
+	for _, s := range Managed(state) {
+		if got := s.Status; got != want[filepath.Base(s.Path)] {
+			t.Errorf("%s: status = %s, want %s", filepath.Base(s.Path), got, want[filepath.Base(s.Path)])
+		}
+		if s.GeneratedAt.IsZero() || time.Since(s.GeneratedAt) > time.Hour {
+			t.Errorf("%s: unexpected generation time %v", s.Path, s.GeneratedAt)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// JournalSocket is where journald listens for native protocol messages.
+var JournalSocket = "/run/systemd/journal/socket"
+
+// JournalAvailable reports whether the journal socket exists.
+func JournalAvailable() bool {
+	_, err := os.Stat(JournalSocket)
+	return err == nil
+}
+
+// JournalHook is a logrus hook sending every entry to journald over its
+// native protocol, with the entry's fields as journal fields, so e.g.
+// journalctl DEVICE=0000:17:00.0 finds the lines about one device.
+type JournalHook struct {
+	conn   *net.UnixConn
+	fields map[string]string
+}
+
+// NewJournalHook connects to the journal. Entries carry identifier as
+// SYSLOG_IDENTIFIER, plus fields.
+func NewJournalHook(identifier string, fields map[string]string) (*JournalHook, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: JournalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to the journal: %w", err)
+	}
+	h := &JournalHook{conn: conn, fields: map[string]string{"SYSLOG_IDENTIFIER": identifier}}
+	for k, v := range fields {
+		h.fields[journalFieldName(k)] = v
+	}
+	return h, nil
+}
+
+// Levels implements log.Hook.
+func (h *JournalHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire implements log.Hook.
+func (h *JournalHook) Fire(entry *log.Entry) error {
+	fields := map[string]string{
+		"MESSAGE":  entry.Message,
+		"PRIORITY": fmt.Sprint(journalPriority(entry.Level)),
+	}
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	for k, v := range entry.Data {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		fields[journalFieldName(k)] = fmt.Sprint(v)
+	}
+	_, err := h.conn.Write(encodeJournal(fields))
+	return err
+}
+
+// Close disconnects from the journal.
+func (h *JournalHook) Close() error {
+	return h.conn.Close()
+}
+
+// journalPriority maps a logrus level to a syslog priority.
+func journalPriority(l log.Level) int {
+	switch l {
+	case log.PanicLevel, log.FatalLevel:
+		return 2 // crit
+	case log.ErrorLevel:
+		return 3
+	case log.WarnLevel:
+		return 4
+	case log.InfoLevel:
+		return 6
+	}
+	return 7 // debug
+}
+
+// journalFieldName turns a logrus field key into a valid journal field
+// name: upper case letters, digits and underscores, not starting with an
+// underscore, which journald reserves for trusted fields.
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+	name = strings.TrimLeft(name, "_")
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "F_" + name
+	}
+	return name
+}
+
+// encodeJournal renders fields in the journal's native format, sorted by
+// name. Values with newlines use the length-prefixed binary form.
+func encodeJournal(fields map[string]string) []byte {
+	var buf bytes.Buffer
+	for _, k := range slices.Sorted(maps.Keys(fields)) {
+		v := fields[k]
+		if !strings.Contains(v, "\n") {
+			fmt.Fprintf(&buf, "%s=%s\n", k, v)
+			continue
+		}
+		buf.WriteString(k + "\n")
+		binary.Write(&buf, binary.LittleEndian, uint64(len(v)))
+		buf.WriteString(v + "\n")
+	}
+	return buf.Bytes()
+}
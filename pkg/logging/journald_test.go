@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestJournalFieldName(t *testing.T) {
+	for key, want := range map[string]string{
+		"device":     "DEVICE",
+		"pci-addr":   "PCI_ADDR",
+		"_hostname":  "HOSTNAME",
+		"9lives":     "F_9LIVES",
+		"spec.path":  "SPEC_PATH",
+		"SYSLOG_PID": "SYSLOG_PID",
+	} {
+		if got := journalFieldName(key); got != want {
+			t.Errorf("journalFieldName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestEncodeJournal(t *testing.T) {
+	got := encodeJournal(map[string]string{"PRIORITY": "4", "MESSAGE": "two\nlines"})
+	want := "MESSAGE\n\x09\x00\x00\x00\x00\x00\x00\x00two\nlines\nPRIORITY=4\n"
+	if string(got) != want {
+		t.Errorf("encodeJournal() = %q, want %q", got, want)
+	}
+}
+
+func TestJournalHook(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "journal.socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("cannot listen on a datagram socket: %v", err)
+	}
+	defer conn.Close()
+	saved := JournalSocket
+	JournalSocket = socket
+	defer func() { JournalSocket = saved }()
+
+	if !JournalAvailable() {
+		t.Fatal("JournalAvailable() = false with the socket present")
+	}
+	hook, err := NewJournalHook("rdma-cdi", map[string]string{"rdma_cdi_command": "rdma-cdi guard"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+
+	logger := log.New()
+	logger.SetOutput(&strings.Builder{})
+	logger.AddHook(hook)
+	logger.WithField("spec", "/etc/cdi/x.yaml").WithError(errors.New("permission denied")).Error("Cannot restore")
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ERROR=permission denied\nMESSAGE=Cannot restore\nPRIORITY=3\nRDMA_CDI_COMMAND=rdma-cdi guard\nSPEC=/etc/cdi/x.yaml\nSYSLOG_IDENTIFIER=rdma-cdi\n"
+	if got := string(buf[:n]); got != want {
+		t.Errorf("journal entry = %q, want %q", got, want)
+	}
+
+	JournalSocket = filepath.Join(t.TempDir(), "missing")
+	if JournalAvailable() {
+		t.Error("JournalAvailable() = true without a socket")
+	}
+	if _, err := NewJournalHook("rdma-cdi", nil); err == nil {
+		t.Error("NewJournalHook should fail without a socket")
+	}
+}
@@ -0,0 +1,144 @@
+// Package logging sends the tool's logs somewhere that outlives stderr:
+// a size-rotated log file, or the systemd journal with structured fields.
+// stderr is lost when the tool runs from a udev rule or as an
+// initContainer that has exited.
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults for RotatingFile.
+const (
+	DefaultMaxSize    = 10 << 20 // bytes
+	DefaultMaxBackups = 3
+)
+
+// RotatingFile is an append-only log file that is renamed to path.1 (and
+// older backups shifted to path.2, ...) once a write would grow it past
+// MaxSize bytes, keeping at most MaxBackups backups.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenRotatingFile opens path for appending, creating it and its
+// directory if needed. A maxSize of 0 disables rotation.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	if maxSize < 0 || maxBackups < 0 {
+		return nil, fmt.Errorf("invalid log rotation: max size %d, max backups %d", maxSize, maxBackups)
+	}
+	r := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("cannot create log directory: %w", err)
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open log file: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("cannot open log file: %w", err)
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+// Write appends p, rotating first if p would not fit. A single write
+// larger than MaxSize goes to a fresh file of its own.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups, moves the current file to path.1 and opens
+// a new one. With no backups kept, the file is just truncated.
+func (r *RotatingFile) rotate() error {
+	r.f.Close()
+	if r.maxBackups == 0 {
+		if err := os.Truncate(r.path, 0); err != nil {
+			return fmt.Errorf("cannot rotate log file: %w", err)
+		}
+		return r.open()
+	}
+	for i := r.maxBackups - 1; i > 0; i-- {
+		// Missing backups are fine: the file has not rotated that often yet
+		os.Rename(backupName(r.path, i), backupName(r.path, i+1))
+	}
+	if err := os.Rename(r.path, backupName(r.path, 1)); err != nil {
+		return fmt.Errorf("cannot rotate log file: %w", err)
+	}
+	return r.open()
+}
+
+func backupName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// Close closes the file. Later writes fail.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// FileHook is a logrus hook copying every entry to a file as text with
+// full timestamps, whatever the format of the logger's own output.
+type FileHook struct {
+	w         *RotatingFile
+	formatter log.Formatter
+}
+
+// NewFileHook returns a hook writing to w.
+func NewFileHook(w *RotatingFile) *FileHook {
+	return &FileHook{w: w, formatter: &log.TextFormatter{DisableColors: true, FullTimestamp: true}}
+}
+
+// Levels implements log.Hook.
+func (h *FileHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire implements log.Hook.
+func (h *FileHook) Fire(entry *log.Entry) error {
+	line, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = h.w.Write(line)
+	return err
+}
@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "rdma-cdi.log")
+	f, err := OpenRotatingFile(path, 12, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "a line of its own\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]string{
+		path:        "a line of its own\n",
+		path + ".1": "three\nfour\n",
+		path + ".2": "one\ntwo\n",
+	}
+	for p, content := range want {
+		if got, err := os.ReadFile(p); err != nil || string(got) != content {
+			t.Errorf("%s = %q, %v, want %q", filepath.Base(p), got, err, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("only two backups should be kept")
+	}
+
+	f.Close()
+	if _, err := f.Write([]byte("late\n")); err == nil {
+		t.Error("write after Close should fail")
+	}
+}
+
+func TestRotatingFile_Appends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rdma-cdi.log")
+	os.WriteFile(path, []byte("earlier run\n"), 0644)
+
+	f, err := OpenRotatingFile(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("this run\n"))
+	f.Close()
+	if got, _ := os.ReadFile(path); string(got) != "earlier run\nthis run\n" {
+		t.Errorf("log file = %q", got)
+	}
+
+	if _, err := OpenRotatingFile(path, -1, 0); err == nil {
+		t.Error("negative max size should be refused")
+	}
+}
+
+func TestFileHook(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rdma-cdi.log")
+	f, err := OpenRotatingFile(path, DefaultMaxSize, DefaultMaxBackups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	logger := log.New()
+	logger.SetOutput(&strings.Builder{})
+	logger.AddHook(NewFileHook(f))
+	logger.WithField("device", "0000:17:00.0").Warn("link down")
+
+	got, _ := os.ReadFile(path)
+	for _, want := range []string{"level=warning", `msg="link down"`, "device=\"0000:17:00.0\"", "time="} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("log file should contain %s:\n%s", want, got)
+		}
+	}
+}
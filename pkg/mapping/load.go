@@ -0,0 +1,212 @@
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/Nativu5/rdma-cdi/pkg/export"
+)
+
+// ResourceNameAnnotation is the annotation Multus reads the resource a
+// NetworkAttachmentDefinition needs from.
+const ResourceNameAnnotation = "k8s.v1.cni.cncf.io/resourceName"
+
+// Default resource prefixes of the device plugins.
+const (
+	sharedDevPluginPrefix = "rdma"
+	sriovDPPrefix         = "intel.com"
+)
+
+// LoadSharedDevConfig reads the resources of a k8s-rdma-shared-dev-plugin
+// config, given as its config.json or as the ConfigMap holding it.
+func LoadSharedDevConfig(path string) ([]Resource, error) {
+	data, err := readConfig(path, export.SharedDevPluginConfigKey)
+	if err != nil {
+		return nil, err
+	}
+	var cfg export.SharedDevPluginConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("cannot parse shared device plugin config %s: %w", path, err)
+	}
+	var out []Resource
+	for _, r := range cfg.ConfigList {
+		prefix := r.ResourcePrefix
+		if prefix == "" {
+			prefix = sharedDevPluginPrefix
+		}
+		out = append(out, Resource{
+			Name:   prefix + "/" + r.ResourceName,
+			Source: path,
+			Selector: Selector{
+				Vendors:   r.Selectors.Vendors,
+				DeviceIDs: r.Selectors.DeviceIDs,
+				Drivers:   r.Selectors.Drivers,
+				IfNames:   r.Selectors.IfNames,
+				LinkTypes: r.Selectors.LinkTypes,
+			},
+		})
+	}
+	return out, nil
+}
+
+// sriovDPConfig is the config.json of sriov-network-device-plugin.
+type sriovDPConfig struct {
+	ResourceList []struct {
+		ResourceName   string `json:"resourceName"`
+		ResourcePrefix string `json:"resourcePrefix"`
+		// Selectors is an object, or a list of objects any of which
+		// selects a device in newer releases.
+		Selectors json.RawMessage `json:"selectors"`
+	} `json:"resourceList"`
+}
+
+type sriovDPSelector struct {
+	Vendors     []string `json:"vendors"`
+	Devices     []string `json:"devices"`
+	Drivers     []string `json:"drivers"`
+	PfNames     []string `json:"pfNames"`
+	RootDevices []string `json:"rootDevices"`
+	LinkTypes   []string `json:"linkTypes"`
+}
+
+// LoadSriovDPConfig reads the resources of a sriov-network-device-plugin
+// config, given as its config.json or as the ConfigMap holding it.
+func LoadSriovDPConfig(path string) ([]Resource, error) {
+	data, err := readConfig(path, "config.json")
+	if err != nil {
+		return nil, err
+	}
+	var cfg sriovDPConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("cannot parse SR-IOV device plugin config %s: %w", path, err)
+	}
+	var out []Resource
+	for _, r := range cfg.ResourceList {
+		prefix := r.ResourcePrefix
+		if prefix == "" {
+			prefix = sriovDPPrefix
+		}
+		var selectors []sriovDPSelector
+		if strings.HasPrefix(strings.TrimSpace(string(r.Selectors)), "[") {
+			err = json.Unmarshal(r.Selectors, &selectors)
+		} else if len(r.Selectors) > 0 {
+			selectors = make([]sriovDPSelector, 1)
+			err = json.Unmarshal(r.Selectors, &selectors[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse selectors of %s in %s: %w", r.ResourceName, path, err)
+		}
+		for _, s := range selectors {
+			// "ens1f0#0-3" selects a range of the PF's VFs
+			pfNames := make([]string, 0, len(s.PfNames))
+			for _, name := range s.PfNames {
+				pfNames = append(pfNames, strings.SplitN(name, "#", 2)[0])
+			}
+			out = append(out, Resource{
+				Name:   prefix + "/" + r.ResourceName,
+				Source: path,
+				Selector: Selector{
+					Vendors:     s.Vendors,
+					DeviceIDs:   s.Devices,
+					Drivers:     s.Drivers,
+					PfNames:     pfNames,
+					RootDevices: s.RootDevices,
+					LinkTypes:   s.LinkTypes,
+				},
+			})
+		}
+	}
+	return out, nil
+}
+
+// readConfig returns the config file at path, or the value of key when
+// the file is a ConfigMap manifest.
+func readConfig(path, key string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cm struct {
+		Kind string            `json:"kind"`
+		Data map[string]string `json:"data"`
+	}
+	if yaml.Unmarshal(data, &cm) == nil && cm.Kind == "ConfigMap" {
+		value, ok := cm.Data[key]
+		if !ok {
+			return nil, fmt.Errorf("ConfigMap %s has no %s key", path, key)
+		}
+		return []byte(value), nil
+	}
+	return data, nil
+}
+
+// object is the part of a Kubernetes object LoadNetworks reads.
+type object struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	// Items holds the objects of a List, as kubectl get -o yaml prints.
+	Items []object `json:"items"`
+}
+
+// documentSeparator splits a YAML stream into documents.
+var documentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// LoadNetworks reads the NetworkAttachmentDefinitions with a resource
+// name annotation from manifests at paths. Directories are read for
+// *.yaml, *.yml and *.json files.
+func LoadNetworks(paths []string) ([]Network, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		for _, ext := range []string{"*.yaml", "*.yml", "*.json"} {
+			matches, _ := filepath.Glob(filepath.Join(path, ext))
+			files = append(files, matches...)
+		}
+	}
+
+	var out []Network
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range documentSeparator.Split(string(data), -1) {
+			if strings.TrimSpace(doc) == "" {
+				continue
+			}
+			var obj object
+			if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+				return nil, fmt.Errorf("cannot parse %s: %w", file, err)
+			}
+			for _, o := range append([]object{obj}, obj.Items...) {
+				res := o.Metadata.Annotations[ResourceNameAnnotation]
+				if o.Kind != "NetworkAttachmentDefinition" || res == "" {
+					continue
+				}
+				ns := o.Metadata.Namespace
+				if ns == "" {
+					ns = "default"
+				}
+				out = append(out, Network{Name: ns + "/" + o.Metadata.Name, Resource: res, Source: file})
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,230 @@
+// Package mapping relates each RDMA device to the CDI devices, Kubernetes
+// extended resources and NetworkAttachmentDefinitions that refer to it, for
+// the mapping subcommand, so that the three can be checked for consistency
+// on a node.
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+
+	"github.com/Nativu5/rdma-cdi/pkg/cdi"
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// Resource is an extended resource a device plugin advertises, with the
+// selector choosing its devices.
+type Resource struct {
+	// Name is the full resource name, e.g. "rdma/hca_shared_devices_a".
+	Name string
+	// Source is the config file the resource was read from.
+	Source   string
+	Selector Selector
+}
+
+// Selector selects devices the way the device plugins do: a device must
+// match every non-empty field.
+type Selector struct {
+	Vendors   []string
+	DeviceIDs []string
+	Drivers   []string
+	IfNames   []string
+	// PfNames match the interface of a VF's parent PF, or of a PF itself.
+	PfNames []string
+	// RootDevices match the PCI address of a VF's parent PF, or of a PF.
+	RootDevices []string
+	LinkTypes   []string
+}
+
+// Network is a NetworkAttachmentDefinition requesting a resource.
+type Network struct {
+	// Name is "namespace/name".
+	Name     string `json:"name"`
+	Resource string `json:"resource"`
+	Source   string `json:"source"`
+}
+
+// Entry is the mapping of one device.
+type Entry struct {
+	PciAddress string   `json:"pci_address"`
+	IfName     string   `json:"interface,omitempty"`
+	CDIDevices []string `json:"cdi_devices"`
+	Resources  []string `json:"resources"`
+	Networks   []string `json:"networks"`
+	// Problems lists inconsistencies, e.g. a resource without a CDI spec.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// Report is the mapping of every device of a node.
+type Report struct {
+	Devices []Entry `json:"devices"`
+	// UnmatchedNetworks request resources no device of this node provides.
+	UnmatchedNetworks []Network `json:"unmatched_networks,omitempty"`
+	HasProblems       bool      `json:"-"`
+}
+
+// Build maps devices to the CDI devices defined in specDirs, the resources
+// selecting them and the networks requesting those resources. A device a
+// resource or network refers to must have a CDI device, and when networks
+// are given, each resource of a device must be requested by one.
+func Build(devices []*types.RdmaDevice, specDirs []string, resources []Resource, networks []Network) (*Report, error) {
+	cdiDevices, err := cdiDevicesByPCI(specDirs)
+	if err != nil {
+		return nil, err
+	}
+	byPCI := make(map[string]*types.RdmaDevice, len(devices))
+	for _, dev := range devices {
+		byPCI[dev.PciAddress] = dev
+	}
+
+	report := &Report{Devices: []Entry{}}
+	provided := make(map[string]bool)
+	for _, dev := range devices {
+		e := Entry{
+			PciAddress: dev.PciAddress,
+			IfName:     dev.IfName,
+			CDIDevices: append([]string{}, cdiDevices[dev.PciAddress]...),
+			Resources:  []string{},
+			Networks:   []string{},
+		}
+		for _, res := range resources {
+			if res.Selector.matches(dev, byPCI[dev.ParentPFAddress]) && !slices.Contains(e.Resources, res.Name) {
+				e.Resources = append(e.Resources, res.Name)
+				provided[res.Name] = true
+			}
+		}
+		for _, res := range e.Resources {
+			var requested bool
+			for _, n := range networks {
+				if n.Resource == res {
+					requested = true
+					e.Networks = append(e.Networks, n.Name)
+				}
+			}
+			if !requested && len(networks) > 0 {
+				e.Problems = append(e.Problems, fmt.Sprintf("resource %s is not requested by any NetworkAttachmentDefinition", res))
+			}
+		}
+		if len(e.CDIDevices) == 0 && len(e.Resources) > 0 {
+			e.Problems = append(e.Problems, "no CDI device; run rdma-cdi generate")
+		}
+		if len(e.Problems) > 0 {
+			report.HasProblems = true
+		}
+		report.Devices = append(report.Devices, e)
+	}
+
+	for _, n := range networks {
+		if !provided[n.Resource] {
+			report.UnmatchedNetworks = append(report.UnmatchedNetworks, n)
+			report.HasProblems = true
+		}
+	}
+	return report, nil
+}
+
+// cdiDevicesByPCI returns the qualified CDI device names defined in dirs
+// for each PCI address, aliases included.
+func cdiDevicesByPCI(dirs []string) (map[string][]string, error) {
+	out := make(map[string][]string)
+	for _, dir := range dirs {
+		paths, err := cdi.ListAllSpecs(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			devices, meta, err := cdi.ReadSpec(path)
+			if err != nil {
+				// Unparseable specs are list's business
+				continue
+			}
+			for _, dev := range devices {
+				if len(dev.Ports) == 0 {
+					out[dev.PciAddress] = append(out[dev.PciAddress], meta.Kind+"="+dev.PciAddress)
+				}
+				for _, p := range dev.Ports {
+					out[dev.PciAddress] = append(out[dev.PciAddress], meta.Kind+"="+cdi.PortDeviceName(dev.PciAddress, p.Number))
+				}
+			}
+			for alias, target := range meta.Aliases {
+				out[target] = append(out[target], meta.Kind+"="+alias)
+			}
+		}
+	}
+	for pci := range out {
+		slices.Sort(out[pci])
+	}
+	return out, nil
+}
+
+// matches reports whether dev, whose parent PF is pf (nil for a PF),
+// satisfies the selector.
+func (s Selector) matches(dev, pf *types.RdmaDevice) bool {
+	root := dev
+	if dev.IsVF {
+		if pf == nil {
+			pf = &types.RdmaDevice{PciAddress: dev.ParentPFAddress}
+		}
+		root = pf
+	}
+	return matchAny(s.Vendors, dev.Vendor) &&
+		matchAny(s.DeviceIDs, dev.DeviceID) &&
+		matchAny(s.Drivers, dev.Driver) &&
+		matchAnyOf(s.IfNames, ifNames(dev)) &&
+		matchAny(s.PfNames, root.IfName) &&
+		matchAny(s.RootDevices, root.PciAddress) &&
+		matchAny(s.LinkTypes, dev.LinkType)
+}
+
+// matchAnyOf reports whether one of values is in allowed, or allowed is
+// empty.
+func matchAnyOf(allowed, values []string) bool {
+	return len(allowed) == 0 || slices.ContainsFunc(values, func(v string) bool { return matchAny(allowed, v) })
+}
+
+// ifNames returns every network interface of dev.
+func ifNames(dev *types.RdmaDevice) []string {
+	if len(dev.IfNames) > 0 {
+		return dev.IfNames
+	}
+	return []string{dev.IfName}
+}
+
+// matchAny reports whether value is in allowed, or allowed is empty.
+func matchAny(allowed []string, value string) bool {
+	return len(allowed) == 0 || (value != "" && slices.Contains(allowed, value))
+}
+
+// PrintTable renders the mapping, followed by the networks no device
+// provides a resource for.
+func PrintTable(w io.Writer, r *Report) {
+	table := tablewriter.NewTable(w)
+	table.Header("PCI ADDRESS", "INTERFACE", "CDI DEVICES", "RESOURCES", "NETWORKS", "PROBLEMS")
+	for _, e := range r.Devices {
+		table.Append(e.PciAddress, orNone(e.IfName), orNone(strings.Join(e.CDIDevices, "\n")),
+			orNone(strings.Join(e.Resources, "\n")), orNone(strings.Join(e.Networks, "\n")), strings.Join(e.Problems, "\n"))
+	}
+	table.Render()
+	for _, n := range r.UnmatchedNetworks {
+		fmt.Fprintf(w, "Network %s requests %s, which no device of this node provides\n", n.Name, n.Resource)
+	}
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
+
+// PrintJSON renders the mapping as JSON.
+func PrintJSON(w io.Writer, r *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
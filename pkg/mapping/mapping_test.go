@@ -0,0 +1,101 @@
+package mapping
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Nativu5/rdma-cdi/pkg/cdi"
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBuild(t *testing.T) {
+	pf := &types.RdmaDevice{PciAddress: "0000:17:00.0", IfName: "enp23s0f0np0", Vendor: "15b3", DeviceID: "1017", LinkType: "ether",
+		DeviceSpecs: []types.DeviceSpec{{HostPath: "/dev/infiniband/uverbs0", ContainerPath: "/dev/infiniband/uverbs0", Permissions: "rw"}}}
+	vf := &types.RdmaDevice{PciAddress: "0000:17:00.2", IfName: "enp23s0f0v0", Vendor: "15b3", DeviceID: "1018", LinkType: "ether",
+		IsVF: true, ParentPFAddress: pf.PciAddress}
+	specDir := t.TempDir()
+	if err := cdi.CreateCDISpec("rdma", "net", []types.RdmaDevice{*pf}, specDir, "yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	shared, err := LoadSharedDevConfig(writeFile(t, dir, "shared.yaml", `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: rdma-devices
+data:
+  config.json: |
+    {"configList": [{"resourceName": "hca_a", "rdmaHcaMax": 100, "selectors": {"ifNames": ["enp23s0f0np0"]}}]}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sriov, err := LoadSriovDPConfig(writeFile(t, dir, "sriov.json",
+		`{"resourceList": [{"resourceName": "mlnx_vfs", "resourcePrefix": "nvidia.com", "selectors": [{"devices": ["1018"], "pfNames": ["enp23s0f0np0#0-3"]}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nadDir := t.TempDir()
+	writeFile(t, nadDir, "nads.yaml", `apiVersion: k8s.cni.cncf.io/v1
+kind: NetworkAttachmentDefinition
+metadata:
+  name: rdma-net
+  namespace: ml
+  annotations:
+    k8s.v1.cni.cncf.io/resourceName: rdma/hca_a
+---
+apiVersion: k8s.cni.cncf.io/v1
+kind: NetworkAttachmentDefinition
+metadata:
+  name: other
+  annotations:
+    k8s.v1.cni.cncf.io/resourceName: example.com/missing
+`)
+	networks, err := LoadNetworks([]string{nadDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Build([]*types.RdmaDevice{pf, vf}, []string{specDir}, append(shared, sriov...), networks)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	want := []Entry{
+		{
+			PciAddress: pf.PciAddress, IfName: pf.IfName,
+			CDIDevices: []string{"rdma/net=0000:17:00.0"},
+			Resources:  []string{"rdma/hca_a"},
+			Networks:   []string{"ml/rdma-net"},
+		},
+		{
+			PciAddress: vf.PciAddress, IfName: vf.IfName,
+			CDIDevices: []string{},
+			Resources:  []string{"nvidia.com/mlnx_vfs"},
+			Networks:   []string{},
+			Problems: []string{
+				"resource nvidia.com/mlnx_vfs is not requested by any NetworkAttachmentDefinition",
+				"no CDI device; run rdma-cdi generate",
+			},
+		},
+	}
+	if !reflect.DeepEqual(report.Devices, want) {
+		t.Errorf("Devices = %+v\nwant %+v", report.Devices, want)
+	}
+	if !report.HasProblems {
+		t.Error("HasProblems should be set")
+	}
+	if len(report.UnmatchedNetworks) != 1 || report.UnmatchedNetworks[0].Name != "default/other" {
+		t.Errorf("UnmatchedNetworks = %+v", report.UnmatchedNetworks)
+	}
+}
@@ -0,0 +1,147 @@
+// Package pciids resolves PCI vendor and device IDs to human-readable names
+// using the pci.ids database (https://pci-ids.ucw.cz/) shipped by most
+// distributions, falling back to a small built-in subset covering common
+// RDMA adapters.
+package pciids
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// DefaultPaths are the locations distributions install pci.ids to.
+var DefaultPaths = []string{
+	"/usr/share/hwdata/pci.ids",
+	"/usr/share/misc/pci.ids",
+	"/usr/share/pci.ids",
+}
+
+//go:embed builtin.ids
+var builtinIDs string
+
+// DB maps PCI vendor and device IDs to names. The zero value knows no IDs.
+type DB struct {
+	vendors map[string]vendor
+}
+
+type vendor struct {
+	name    string
+	devices map[string]string
+}
+
+// Parse reads a database in pci.ids format. Subsystem entries and the
+// device class section are skipped.
+func Parse(r io.Reader) (*DB, error) {
+	db := &DB{vendors: make(map[string]vendor)}
+	var cur *vendor
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "\t\t"):
+			// Subsystem
+		case line[0] == '\t':
+			if cur == nil {
+				continue
+			}
+			if id, name, ok := splitEntry(line[1:]); ok {
+				cur.devices[id] = name
+			}
+		case strings.HasPrefix(line, "C "):
+			// Device classes follow the vendors up to the end of the file
+			return db, nil
+		default:
+			id, name, ok := splitEntry(line)
+			if !ok {
+				cur = nil
+				continue
+			}
+			v := vendor{name: name, devices: make(map[string]string)}
+			db.vendors[id] = v
+			cur = &v
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read PCI IDs database: %w", err)
+	}
+	return db, nil
+}
+
+// splitEntry splits an "<id>  <name>" line with a four-digit hex ID.
+func splitEntry(line string) (id, name string, ok bool) {
+	id, name, ok = strings.Cut(line, " ")
+	if !ok || len(id) != 4 {
+		return "", "", false
+	}
+	return strings.ToLower(id), strings.TrimSpace(name), true
+}
+
+// Load parses the first readable database in paths and falls back to
+// Builtin when there is none.
+func Load(paths ...string) *DB {
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		db, err := Parse(f)
+		f.Close()
+		if err == nil {
+			return db
+		}
+	}
+	return Builtin()
+}
+
+// Builtin returns the database embedded in the binary.
+func Builtin() *DB {
+	db, err := Parse(strings.NewReader(builtinIDs))
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// Lookup returns the full vendor and device names of vendorID:deviceID
+// (hex, e.g. "15b3", "101d"). Unknown IDs yield empty names.
+func (db *DB) Lookup(vendorID, deviceID string) (vendorName, deviceName string) {
+	if db == nil {
+		return "", ""
+	}
+	v, ok := db.vendors[strings.ToLower(vendorID)]
+	if !ok {
+		return "", ""
+	}
+	return v.name, v.devices[strings.ToLower(deviceID)]
+}
+
+// Model returns a short model name for vendorID:deviceID such as
+// "Mellanox ConnectX-6 Dx", or "" when the device is not in the database.
+// Names of the form "MT2892 Family [ConnectX-6 Dx]" are reduced to the
+// marketing name in brackets, and the vendor to its first word (e.g.
+// "Amazon" for "Amazon.com, Inc.").
+func (db *DB) Model(vendorID, deviceID string) string {
+	vendorName, deviceName := db.Lookup(vendorID, deviceID)
+	if deviceName == "" {
+		return ""
+	}
+	if i := strings.LastIndex(deviceName, "["); i >= 0 && strings.HasSuffix(deviceName, "]") &&
+		strings.Contains(deviceName[:i], "Family") {
+		deviceName = deviceName[i+1 : len(deviceName)-1]
+	}
+	short := vendorName
+	if i := strings.IndexAny(short, " .,"); i >= 0 {
+		short = short[:i]
+	}
+	if short == "" || strings.HasPrefix(deviceName, short) {
+		return deviceName
+	}
+	return short + " " + deviceName
+}
@@ -0,0 +1,64 @@
+package pciids
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sample = `# comment
+15b3  Mellanox Technologies
+	101d  MT2892 Family [ConnectX-6 Dx]
+		15b3 0042  ConnectX-6 Dx EN adapter card
+	a2d6  MT42822 BlueField-2 integrated ConnectX-6 Dx network controller
+1d0f  Amazon.com, Inc.
+	efa0  Elastic Fabric Adapter (EFA)
+C 02  Network controller
+	07  Infiniband controller
+`
+
+func TestModel(t *testing.T) {
+	db, err := Parse(strings.NewReader(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		vendor, device string
+		want           string
+	}{
+		{"15b3", "101d", "Mellanox ConnectX-6 Dx"},
+		{"15B3", "101D", "Mellanox ConnectX-6 Dx"},
+		{"15b3", "a2d6", "Mellanox MT42822 BlueField-2 integrated ConnectX-6 Dx network controller"},
+		{"1d0f", "efa0", "Amazon Elastic Fabric Adapter (EFA)"},
+		{"15b3", "ffff", ""},
+		{"ffff", "101d", ""},
+		// Class lines must not be read as vendors
+		{"02", "07", ""},
+	}
+	for _, tt := range tests {
+		if got := db.Model(tt.vendor, tt.device); got != tt.want {
+			t.Errorf("Model(%s, %s) = %q, want %q", tt.vendor, tt.device, got, tt.want)
+		}
+	}
+	if _, name := db.Lookup("15b3", "101d"); name != "MT2892 Family [ConnectX-6 Dx]" {
+		t.Errorf("Lookup() device name = %q", name)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pci.ids")
+	if err := os.WriteFile(path, []byte(sample), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db := Load(filepath.Join(t.TempDir(), "missing"), path)
+	if got := db.Model("1d0f", "efa0"); got != "Amazon Elastic Fabric Adapter (EFA)" {
+		t.Errorf("Model() from file = %q", got)
+	}
+
+	// Without a system database the built-in subset is used
+	db = Load(filepath.Join(t.TempDir(), "missing"))
+	if got := db.Model("15b3", "1017"); got != "Mellanox ConnectX-5" {
+		t.Errorf("Model() from builtin = %q", got)
+	}
+}
@@ -0,0 +1,118 @@
+// Package nvidiagds is the "nvidia-gds" plugin: it pairs Mellanox/NVIDIA
+// HCAs with the NVIDIA GPUs on their NUMA node, and gives the containers
+// of such a device the nvidia-fs nodes GPUDirect Storage (GDS) reads and
+// writes through. Importing the package registers the plugin.
+package nvidiagds
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
+
+	"github.com/Nativu5/rdma-cdi/pkg/plugin"
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// Name is the plugin name.
+const Name = "nvidia-gds"
+
+const (
+	// GPUsAttribute lists the PCI addresses of the NVIDIA GPUs on the
+	// device's NUMA node, comma-separated.
+	GPUsAttribute = "nvidia.com/gpus"
+	// GDSDevicesAttribute lists the host paths of the nvidia-fs nodes,
+	// comma-separated, when the nvidia-fs module is loaded.
+	GDSDevicesAttribute = "nvidia.com/gds-devices"
+)
+
+const (
+	vendorMellanox = "15b3"
+	vendorNVIDIA   = "0x10de"
+	// classDisplay is the PCI base class of VGA and 3D controllers.
+	classDisplay = "0x03"
+)
+
+func init() {
+	plugin.Register(gds{})
+}
+
+type gds struct{}
+
+func (gds) Name() string { return Name }
+
+// Match accepts Mellanox/NVIDIA HCAs, the adapters GDS supports.
+func (gds) Match(dev *types.RdmaDevice) bool {
+	return dev.Vendor == vendorMellanox
+}
+
+// Discover records the GPUs sharing the device's NUMA node (all of them on
+// hosts that report no NUMA locality) and the nvidia-fs nodes.
+func (gds) Discover(host plugin.Host, dev *types.RdmaDevice) error {
+	pciDir := filepath.Join(host.SysfsRoot, "bus/pci/devices")
+	entries, err := os.ReadDir(pciDir)
+	if err != nil {
+		return err
+	}
+	var gpus []string
+	for _, e := range entries {
+		dir := filepath.Join(pciDir, e.Name())
+		if readAttr(dir, "vendor") != vendorNVIDIA || !strings.HasPrefix(readAttr(dir, "class"), classDisplay) {
+			continue
+		}
+		node, err := strconv.Atoi(readAttr(dir, "numa_node"))
+		if err != nil {
+			node = -1
+		}
+		if dev.NumaNode < 0 || node < 0 || node == dev.NumaNode {
+			gpus = append(gpus, e.Name())
+		}
+	}
+
+	nodes, _ := filepath.Glob(filepath.Join(host.DevRoot, "nvidia-fs*"))
+	var hostPaths []string
+	for _, n := range nodes {
+		hostPaths = append(hostPaths, path.Join("/dev", filepath.Base(n)))
+	}
+	slices.Sort(hostPaths)
+
+	setAttribute(dev, GPUsAttribute, gpus)
+	setAttribute(dev, GDSDevicesAttribute, hostPaths)
+	return nil
+}
+
+// ContainerEdits adds the nvidia-fs nodes Discover found, if any.
+func (gds) ContainerEdits(dev *types.RdmaDevice) (*cdiSpecs.ContainerEdits, error) {
+	paths := dev.Attributes[GDSDevicesAttribute]
+	if paths == "" {
+		return nil, nil
+	}
+	edits := &cdiSpecs.ContainerEdits{}
+	for _, p := range strings.Split(paths, ",") {
+		edits.DeviceNodes = append(edits.DeviceNodes, &cdiSpecs.DeviceNode{Path: p, HostPath: p, Permissions: "rw"})
+	}
+	return edits, nil
+}
+
+// setAttribute records values under key, or nothing if there are none.
+func setAttribute(dev *types.RdmaDevice, key string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	if dev.Attributes == nil {
+		dev.Attributes = make(map[string]string)
+	}
+	dev.Attributes[key] = strings.Join(values, ",")
+}
+
+func readAttr(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,65 @@
+package nvidiagds
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Nativu5/rdma-cdi/pkg/plugin"
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+func writeAttrs(t *testing.T, dir string, attrs map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, v := range attrs {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(v+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGDS(t *testing.T) {
+	root := t.TempDir()
+	host := plugin.Host{SysfsRoot: filepath.Join(root, "sys"), DevRoot: filepath.Join(root, "dev")}
+	pci := filepath.Join(host.SysfsRoot, "bus/pci/devices")
+	writeAttrs(t, filepath.Join(pci, "0000:18:00.0"), map[string]string{"vendor": "0x10de", "class": "0x030200", "numa_node": "0"})
+	writeAttrs(t, filepath.Join(pci, "0000:b1:00.0"), map[string]string{"vendor": "0x10de", "class": "0x030200", "numa_node": "1"})
+	// An NVIDIA audio function is not a GPU
+	writeAttrs(t, filepath.Join(pci, "0000:18:00.1"), map[string]string{"vendor": "0x10de", "class": "0x040300", "numa_node": "0"})
+	writeAttrs(t, host.DevRoot, map[string]string{"nvidia-fs0": "", "nvidia-fs1": ""})
+
+	p, err := plugin.Lookup(Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := p[0]
+	dev := &types.RdmaDevice{PciAddress: "0000:17:00.0", Vendor: "15b3", NumaNode: 0}
+	if !g.Match(dev) || g.Match(&types.RdmaDevice{Vendor: "8086"}) {
+		t.Fatal("the plugin should match Mellanox devices only")
+	}
+	if err := g.Discover(host, dev); err != nil {
+		t.Fatal(err)
+	}
+	if got := dev.Attributes[GPUsAttribute]; got != "0000:18:00.0" {
+		t.Errorf("%s = %q, want the GPU on NUMA node 0", GPUsAttribute, got)
+	}
+	if got := dev.Attributes[GDSDevicesAttribute]; got != "/dev/nvidia-fs0,/dev/nvidia-fs1" {
+		t.Errorf("%s = %q", GDSDevicesAttribute, got)
+	}
+
+	edits, err := g.ContainerEdits(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edits.DeviceNodes) != 2 || edits.DeviceNodes[1].HostPath != "/dev/nvidia-fs1" {
+		t.Errorf("unexpected edits %+v", edits)
+	}
+
+	// Without nvidia-fs there is nothing to add
+	if edits, err := g.ContainerEdits(&types.RdmaDevice{Vendor: "15b3"}); edits != nil || err != nil {
+		t.Errorf("ContainerEdits() = %+v, %v, want nil", edits, err)
+	}
+}
@@ -0,0 +1,110 @@
+// Package plugin lets vendor modules add depth to discovery and spec
+// generation while the core stays vendor-neutral: a plugin records extra
+// attributes on the devices it recognises, and contributes container
+// edits (e.g. companion device nodes) to their CDI device entries.
+//
+// Plugins are compiled in and register themselves from an init function;
+// the rdma and cdi packages only run those a caller enables, so specs do
+// not change unless asked to.
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// ErrUnknownPlugin is returned by Lookup for names nothing registered.
+var ErrUnknownPlugin = errors.New("unknown plugin")
+
+// Host locates the host filesystem a plugin inspects.
+type Host struct {
+	// SysfsRoot is where the host sysfs is mounted (e.g. "/sys").
+	SysfsRoot string
+	// DevRoot is where the host /dev is mounted (e.g. "/dev").
+	DevRoot string
+}
+
+// Plugin is a vendor module.
+type Plugin interface {
+	// Name identifies the plugin, e.g. "nvidia-gds".
+	Name() string
+	// Match reports whether the plugin applies to dev, e.g. by vendor ID
+	// or driver.
+	Match(dev *types.RdmaDevice) bool
+	// Discover enriches a matched device, typically by setting Attributes
+	// under a vendor prefix (e.g. "nvidia.com/gpus"). An error leaves dev
+	// usable and is reported as a discovery warning.
+	Discover(host Host, dev *types.RdmaDevice) error
+	// ContainerEdits returns the edits to add to the CDI device entry of a
+	// matched device, from what Discover recorded, or nil for none. Host
+	// paths in the edits are paths on the host, whatever the roots.
+	ContainerEdits(dev *types.RdmaDevice) (*cdiSpecs.ContainerEdits, error)
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]Plugin)
+)
+
+// Register makes a plugin available by its name. It panics if the name is
+// empty or already taken, as two modules claiming one name is a build
+// mistake.
+func Register(p Plugin) {
+	mu.Lock()
+	defer mu.Unlock()
+	name := p.Name()
+	if name == "" {
+		panic("plugin: Register of a plugin without a name")
+	}
+	if _, dup := registry[name]; dup {
+		panic("plugin: Register called twice for " + name)
+	}
+	registry[name] = p
+}
+
+// Names returns the names of the registered plugins, sorted.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return sortedNames()
+}
+
+// sortedNames is Names for callers holding mu.
+func sortedNames() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Lookup returns the registered plugins with the given names, in order.
+// It fails with ErrUnknownPlugin, listing what is available, if a name is
+// not registered.
+func Lookup(names ...string) ([]Plugin, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	plugins := make([]Plugin, 0, len(names))
+	for _, name := range names {
+		p, ok := registry[name]
+		if !ok {
+			available := "none"
+			if len(registry) > 0 {
+				available = strings.Join(sortedNames(), ", ")
+			}
+			return nil, fmt.Errorf("%w %q (available: %s)", ErrUnknownPlugin, name, available)
+		}
+		if !slices.ContainsFunc(plugins, func(q Plugin) bool { return q.Name() == name }) {
+			plugins = append(plugins, p)
+		}
+	}
+	return plugins, nil
+}
@@ -0,0 +1,60 @@
+package plugin
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+type fakePlugin struct{ name string }
+
+func (f fakePlugin) Name() string                         { return f.name }
+func (fakePlugin) Match(*types.RdmaDevice) bool           { return true }
+func (fakePlugin) Discover(Host, *types.RdmaDevice) error { return nil }
+func (fakePlugin) ContainerEdits(*types.RdmaDevice) (*cdiSpecs.ContainerEdits, error) {
+	return nil, nil
+}
+
+// withRegistry runs the test against an empty registry.
+func withRegistry(t *testing.T) {
+	orig := registry
+	registry = make(map[string]Plugin)
+	t.Cleanup(func() { registry = orig })
+}
+
+func TestRegistry(t *testing.T) {
+	withRegistry(t)
+	Register(fakePlugin{"b"})
+	Register(fakePlugin{"a"})
+
+	if got := Names(); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("Names() = %v, want [a b]", got)
+	}
+	plugins, err := Lookup("b", "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plugins) != 2 || plugins[0].Name() != "b" || plugins[1].Name() != "a" {
+		t.Errorf("Lookup() = %v, want b, a once each", plugins)
+	}
+	_, err = Lookup("c")
+	if !errors.Is(err, ErrUnknownPlugin) || !strings.Contains(err.Error(), "available: a, b") {
+		t.Errorf("Lookup of an unknown plugin: err = %v", err)
+	}
+}
+
+func TestRegister_Duplicate(t *testing.T) {
+	withRegistry(t)
+	Register(fakePlugin{"a"})
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice should panic")
+		}
+	}()
+	Register(fakePlugin{"a"})
+}
@@ -0,0 +1,129 @@
+// Package preflight checks, before a command changes anything, that the
+// process holds the privileges it will need, so it fails up front with a
+// list of what is missing instead of with EACCES halfway through a batch.
+package preflight
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrInsufficientPrivileges is returned by Check when requirements are not
+// met.
+var ErrInsufficientPrivileges = errors.New("insufficient privileges")
+
+// Requirement is one privilege a command needs, with the reason it is
+// needed for the error message.
+type Requirement struct {
+	// What names the privilege, e.g. "write access to /etc/cdi".
+	What string
+	// Why is what the command needs it for, e.g. "to write CDI specs".
+	Why string
+	// check returns why the privilege is missing, or "" if it is held.
+	check func() string
+}
+
+// Writable requires that path can be written: created in the nearest
+// existing ancestor directory if it does not exist, as os.MkdirAll and
+// file writes do.
+func Writable(path, why string) Requirement {
+	return Requirement{What: "write access to " + path, Why: why, check: func() string {
+		p := filepath.Clean(path)
+		for {
+			_, err := os.Stat(p)
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, os.ErrNotExist) || filepath.Dir(p) == p {
+				return err.Error()
+			}
+			p = filepath.Dir(p)
+		}
+		return access(p, unix.W_OK)
+	}}
+}
+
+// Readable requires that path exists and can be read.
+func Readable(path, why string) Requirement {
+	return Requirement{What: "read access to " + path, Why: why, check: func() string {
+		return access(path, unix.R_OK)
+	}}
+}
+
+// Capability requires the effective capability c, e.g. unix.CAP_NET_ADMIN.
+func Capability(c int, why string) Requirement {
+	return Requirement{What: capabilityName(c), Why: why, check: func() string {
+		caps, err := effectiveCaps()
+		if err != nil {
+			return err.Error()
+		}
+		if caps&(1<<uint(c)) == 0 {
+			return "not in the effective capability set"
+		}
+		return ""
+	}}
+}
+
+// access checks path against mode with the effective IDs, as the kernel
+// will for the actual operation.
+func access(path string, mode uint32) string {
+	err := unix.Faccessat(unix.AT_FDCWD, path, mode, unix.AT_EACCESS)
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, unix.EROFS):
+		return "read-only file system"
+	default:
+		return err.Error()
+	}
+}
+
+// Check checks every requirement and returns an ErrInsufficientPrivileges
+// error listing each one that is not met.
+func Check(reqs ...Requirement) error {
+	var missing []string
+	for _, r := range reqs {
+		if reason := r.check(); reason != "" {
+			missing = append(missing, fmt.Sprintf("%s %s (%s)", r.What, r.Why, reason))
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: missing %s; run as root or grant them", ErrInsufficientPrivileges, strings.Join(missing, "; "))
+}
+
+// effectiveCaps returns the effective capability set of the process;
+// replaceable in tests.
+var effectiveCaps = func() (uint64, error) {
+	data, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return 0, fmt.Errorf("cannot read capabilities: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if v, ok := strings.CutPrefix(line, "CapEff:"); ok {
+			return strconv.ParseUint(strings.TrimSpace(v), 16, 64)
+		}
+	}
+	return 0, errors.New("cannot read capabilities: no CapEff in /proc/self/status")
+}
+
+// capabilityNames names the capabilities commands ask for.
+var capabilityNames = map[int]string{
+	unix.CAP_DAC_OVERRIDE: "CAP_DAC_OVERRIDE",
+	unix.CAP_NET_ADMIN:    "CAP_NET_ADMIN",
+	unix.CAP_SYS_ADMIN:    "CAP_SYS_ADMIN",
+}
+
+func capabilityName(c int) string {
+	if name, ok := capabilityNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("capability %d", c)
+}
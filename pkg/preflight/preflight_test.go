@@ -0,0 +1,60 @@
+package preflight
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	orig := effectiveCaps
+	defer func() { effectiveCaps = orig }()
+	effectiveCaps = func() (uint64, error) { return 1 << unix.CAP_DAC_OVERRIDE, nil }
+
+	if err := Check(
+		Writable(dir, "to write specs"),
+		Writable(filepath.Join(dir, "a", "b", "spec.yaml"), "to write specs"),
+		Readable(dir, "to read sysfs"),
+		Capability(unix.CAP_DAC_OVERRIDE, "to override permissions"),
+	); err != nil {
+		t.Fatalf("Check() = %v, want nil", err)
+	}
+
+	err := Check(
+		Readable(filepath.Join(dir, "missing"), "to discover devices"),
+		Capability(unix.CAP_NET_ADMIN, "to change the RDMA netns mode"),
+	)
+	if !errors.Is(err, ErrInsufficientPrivileges) {
+		t.Fatalf("Check() = %v, want ErrInsufficientPrivileges", err)
+	}
+	for _, want := range []string{"read access to " + filepath.Join(dir, "missing") + " to discover devices",
+		"CAP_NET_ADMIN to change the RDMA netns mode (not in the effective capability set)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestWritable_ReadOnlyDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root bypasses file permissions")
+	}
+	dir := filepath.Join(t.TempDir(), "ro")
+	if err := os.Mkdir(dir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	if err := Check(Writable(filepath.Join(dir, "cdi"), "to write specs")); !errors.Is(err, ErrInsufficientPrivileges) {
+		t.Errorf("Check() = %v, want ErrInsufficientPrivileges", err)
+	}
+}
+
+func TestEffectiveCaps(t *testing.T) {
+	if _, err := effectiveCaps(); err != nil {
+		t.Skipf("no capability information: %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package rdma
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+	"github.com/Nativu5/rdma-cdi/pkg/utils"
+)
+
+// DefaultCacheTTL is a reasonable TTL for long-running loops.
+const DefaultCacheTTL = 30 * time.Second
+
+// cacheEntry is one cached discovery result.
+type cacheEntry struct {
+	dev     *types.RdmaDevice
+	expires time.Time
+}
+
+// CachedDiscoverer wraps a types.RdmaDeviceDiscoverer and memoizes successful
+// results keyed by PCI address for a fixed TTL. Errors are never cached.
+// Returned devices are copies, so callers may modify them freely.
+type CachedDiscoverer struct {
+	inner types.RdmaDeviceDiscoverer
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu         sync.Mutex
+	byPCI      map[string]cacheEntry
+	ifToPCI    map[string]string
+	all        []string // PCI addresses of the last DiscoverAll
+	allExpires time.Time
+}
+
+// NewCachedDiscoverer returns a caching wrapper around inner.
+func NewCachedDiscoverer(inner types.RdmaDeviceDiscoverer, ttl time.Duration) *CachedDiscoverer {
+	return &CachedDiscoverer{
+		inner:   inner,
+		ttl:     ttl,
+		now:     time.Now,
+		byPCI:   make(map[string]cacheEntry),
+		ifToPCI: make(map[string]string),
+	}
+}
+
+// DiscoverByPCI returns the cached device for pciAddress or discovers it.
+// Entries are keyed by canonical address, so "86:00.0" hits the entry of
+// "0000:86:00.0".
+func (c *CachedDiscoverer) DiscoverByPCI(pciAddress string) (*types.RdmaDevice, error) {
+	pciAddress = canonicalPCI(pciAddress)
+	c.mu.Lock()
+	if dev, ok := c.lookup(pciAddress); ok {
+		c.mu.Unlock()
+		return dev, nil
+	}
+	c.mu.Unlock()
+
+	dev, err := c.inner.DiscoverByPCI(pciAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.store(dev)
+	return dev.Clone(), nil
+}
+
+// DiscoverByIfName returns the cached device for ifName or discovers it.
+func (c *CachedDiscoverer) DiscoverByIfName(ifName string) (*types.RdmaDevice, error) {
+	c.mu.Lock()
+	if pciAddr, ok := c.ifToPCI[ifName]; ok {
+		if dev, ok := c.lookup(pciAddr); ok && dev.IfName == ifName {
+			c.mu.Unlock()
+			return dev, nil
+		}
+	}
+	c.mu.Unlock()
+
+	dev, err := c.inner.DiscoverByIfName(ifName)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.store(dev)
+	c.ifToPCI[ifName] = dev.PciAddress
+	return dev.Clone(), nil
+}
+
+// DiscoverAll returns the cached device list or rescans the host.
+func (c *CachedDiscoverer) DiscoverAll() ([]*types.RdmaDevice, error) {
+	c.mu.Lock()
+	if c.all != nil && c.now().Before(c.allExpires) {
+		devices := make([]*types.RdmaDevice, 0, len(c.all))
+		complete := true
+		for _, pciAddr := range c.all {
+			dev, ok := c.lookup(pciAddr)
+			if !ok {
+				complete = false
+				break
+			}
+			devices = append(devices, dev)
+		}
+		if complete {
+			c.mu.Unlock()
+			return devices, nil
+		}
+	}
+	c.mu.Unlock()
+
+	devices, err := c.inner.DiscoverAll()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.all = make([]string, 0, len(devices))
+	out := make([]*types.RdmaDevice, 0, len(devices))
+	for _, dev := range devices {
+		c.store(dev)
+		c.all = append(c.all, dev.PciAddress)
+		out = append(out, dev.Clone())
+	}
+	c.allExpires = c.now().Add(c.ttl)
+	return out, nil
+}
+
+// Invalidate drops the cached entry for one PCI address.
+func (c *CachedDiscoverer) Invalidate(pciAddress string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.byPCI, canonicalPCI(pciAddress))
+	c.all = nil
+}
+
+// InvalidateAll drops every cached entry.
+func (c *CachedDiscoverer) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byPCI = make(map[string]cacheEntry)
+	c.ifToPCI = make(map[string]string)
+	c.all = nil
+}
+
+// InvalidateOnChange subscribes to kernel uevent and netlink link
+// notifications and flushes the cache whenever one arrives, until ctx is done.
+func (c *CachedDiscoverer) InvalidateOnChange(ctx context.Context) error {
+	triggers, err := newTriggerSource(ctx)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-triggers:
+				if !ok {
+					return
+				}
+				c.InvalidateAll()
+			}
+		}
+	}()
+	return nil
+}
+
+// canonicalPCI returns the canonical form of pciAddress, or pciAddress
+// itself if it is invalid, for the inner discoverer to reject.
+func canonicalPCI(pciAddress string) string {
+	if canonical, err := utils.NormalizePCIAddress(pciAddress); err == nil {
+		return canonical
+	}
+	return pciAddress
+}
+
+// lookup returns a copy of an unexpired entry. Callers must hold c.mu.
+func (c *CachedDiscoverer) lookup(pciAddress string) (*types.RdmaDevice, bool) {
+	e, ok := c.byPCI[pciAddress]
+	if !ok || !c.now().Before(e.expires) {
+		return nil, false
+	}
+	return e.dev.Clone(), true
+}
+
+// store caches a copy of dev. Callers must hold c.mu.
+func (c *CachedDiscoverer) store(dev *types.RdmaDevice) {
+	c.byPCI[dev.PciAddress] = cacheEntry{dev: dev.Clone(), expires: c.now().Add(c.ttl)}
+}
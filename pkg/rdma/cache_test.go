@@ -0,0 +1,159 @@
+package rdma
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// countingDiscoverer records how often the wrapped discoverer is hit.
+type countingDiscoverer struct {
+	calls int
+	err   error
+}
+
+func (f *countingDiscoverer) DiscoverByPCI(pciAddress string) (*types.RdmaDevice, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &types.RdmaDevice{PciAddress: pciAddress, IfName: "eth0", RdmaDevices: []string{"/dev/infiniband/uverbs0"}}, nil
+}
+
+func (f *countingDiscoverer) DiscoverByIfName(ifName string) (*types.RdmaDevice, error) {
+	f.calls++
+	return &types.RdmaDevice{PciAddress: "0000:17:00.0", IfName: ifName}, nil
+}
+
+func (f *countingDiscoverer) DiscoverAll() ([]*types.RdmaDevice, error) {
+	f.calls++
+	return []*types.RdmaDevice{
+		{PciAddress: "0000:17:00.0", IfName: "eth0"},
+		{PciAddress: "0000:41:00.0", IfName: "eth1"},
+	}, nil
+}
+
+func TestCachedDiscoverer_TTL(t *testing.T) {
+	inner := &countingDiscoverer{}
+	c := NewCachedDiscoverer(inner, time.Minute)
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.DiscoverByPCI("0000:17:00.0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if inner.calls != 1 {
+		t.Errorf("expected 1 inner call within TTL, got %d", inner.calls)
+	}
+
+	now = now.Add(2 * time.Minute)
+	_, _ = c.DiscoverByPCI("0000:17:00.0")
+	if inner.calls != 2 {
+		t.Errorf("expected re-discovery after TTL expiry, got %d calls", inner.calls)
+	}
+}
+
+func TestCachedDiscoverer_NormalizesPCIAddress(t *testing.T) {
+	inner := &countingDiscoverer{}
+	c := NewCachedDiscoverer(inner, time.Minute)
+
+	for _, addr := range []string{"0000:17:00.0", "17:00.0", "0000:17:00.0"} {
+		dev, err := c.DiscoverByPCI(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dev.PciAddress != "0000:17:00.0" {
+			t.Errorf("DiscoverByPCI(%q).PciAddress = %q", addr, dev.PciAddress)
+		}
+	}
+	if inner.calls != 1 {
+		t.Errorf("expected short forms to hit the cache, got %d inner calls", inner.calls)
+	}
+
+	c.Invalidate("17:00.0")
+	_, _ = c.DiscoverByPCI("0000:17:00.0")
+	if inner.calls != 2 {
+		t.Errorf("expected re-discovery after Invalidate of the short form, got %d calls", inner.calls)
+	}
+}
+
+func TestCachedDiscoverer_ReturnsCopies(t *testing.T) {
+	c := NewCachedDiscoverer(&countingDiscoverer{}, time.Minute)
+
+	dev, _ := c.DiscoverByPCI("0000:17:00.0")
+	dev.IfName = "mutated"
+	dev.RdmaDevices[0] = "mutated"
+
+	again, _ := c.DiscoverByPCI("0000:17:00.0")
+	if again.IfName != "eth0" || again.RdmaDevices[0] != "/dev/infiniband/uverbs0" {
+		t.Errorf("cache entry was mutated through a returned device: %+v", again)
+	}
+}
+
+func TestCachedDiscoverer_ErrorsNotCached(t *testing.T) {
+	inner := &countingDiscoverer{err: ErrDeviceNotFound}
+	c := NewCachedDiscoverer(inner, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.DiscoverByPCI("0000:ff:00.0"); !errors.Is(err, ErrDeviceNotFound) {
+			t.Fatalf("expected ErrDeviceNotFound, got %v", err)
+		}
+	}
+	if inner.calls != 2 {
+		t.Errorf("errors should not be cached, got %d inner calls", inner.calls)
+	}
+}
+
+func TestCachedDiscoverer_DiscoverAllAndInvalidate(t *testing.T) {
+	inner := &countingDiscoverer{}
+	c := NewCachedDiscoverer(inner, time.Minute)
+
+	devices, _ := c.DiscoverAll()
+	if len(devices) != 2 {
+		t.Fatalf("expected 2 devices, got %d", len(devices))
+	}
+	_, _ = c.DiscoverAll()
+	// DiscoverAll also populates per-PCI entries
+	_, _ = c.DiscoverByPCI("0000:41:00.0")
+	if inner.calls != 1 {
+		t.Errorf("expected 1 inner call, got %d", inner.calls)
+	}
+
+	c.Invalidate("0000:41:00.0")
+	_, _ = c.DiscoverAll()
+	if inner.calls != 2 {
+		t.Errorf("expected rescan after Invalidate, got %d calls", inner.calls)
+	}
+}
+
+func TestCachedDiscoverer_InvalidateOnChange(t *testing.T) {
+	triggers := fakeTriggers(t)
+	inner := &countingDiscoverer{}
+	c := NewCachedDiscoverer(inner, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.InvalidateOnChange(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = c.DiscoverByPCI("0000:17:00.0")
+	triggers <- struct{}{}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		n := len(c.byPCI)
+		c.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("cache was not flushed after a kernel notification")
+}
@@ -0,0 +1,84 @@
+package rdma
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+	"github.com/Nativu5/rdma-cdi/pkg/utils"
+)
+
+// Inventory is a types.RdmaDeviceDiscoverer over devices discovered
+// elsewhere, such as the output of discover --output json captured on
+// another host. Returned devices are copies.
+type Inventory struct {
+	devices []*types.RdmaDevice
+}
+
+// ReadInventory parses a device inventory (see types.InventorySchema) from
+// r. Every device must be valid, except that devices whose discovery
+// failed only need a PCI address, so their failure can be reported.
+func ReadInventory(r io.Reader) (*Inventory, error) {
+	var devices []*types.RdmaDevice
+	if err := json.NewDecoder(r).Decode(&devices); err != nil {
+		return nil, fmt.Errorf("parsing the device inventory: %w", err)
+	}
+	seen := make(map[string]bool, len(devices))
+	for i, dev := range devices {
+		if dev == nil {
+			return nil, fmt.Errorf("device %d of the inventory is null", i)
+		}
+		v := *dev
+		v.Error = ""
+		if err := v.Validate(); err != nil {
+			return nil, fmt.Errorf("device %d of the inventory: %w", i, err)
+		}
+		if seen[dev.PciAddress] {
+			return nil, fmt.Errorf("device %s is listed twice in the inventory", dev.PciAddress)
+		}
+		seen[dev.PciAddress] = true
+	}
+	return &Inventory{devices: devices}, nil
+}
+
+// DiscoverByPCI returns the device with the given PCI address.
+func (inv *Inventory) DiscoverByPCI(pciAddress string) (*types.RdmaDevice, error) {
+	pciAddress, err := utils.NormalizePCIAddress(pciAddress)
+	if err != nil {
+		return nil, err
+	}
+	for _, dev := range inv.devices {
+		if dev.PciAddress == pciAddress {
+			return dev.Clone(), nil
+		}
+	}
+	return nil, fmt.Errorf("PCI address %s: %w", pciAddress, ErrDeviceNotFound)
+}
+
+// DiscoverByIfName returns the device the network interface belongs to.
+func (inv *Inventory) DiscoverByIfName(ifName string) (*types.RdmaDevice, error) {
+	for _, dev := range inv.devices {
+		if dev.IfName == ifName {
+			return dev.Clone(), nil
+		}
+		for _, name := range dev.IfNames {
+			if name == ifName {
+				return dev.Clone(), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("network interface %q: %w", ifName, ErrDeviceNotFound)
+}
+
+// DiscoverAll returns every device, or ErrNoRdmaDevices if there are none.
+func (inv *Inventory) DiscoverAll() ([]*types.RdmaDevice, error) {
+	if len(inv.devices) == 0 {
+		return nil, fmt.Errorf("%w in the inventory", ErrNoRdmaDevices)
+	}
+	out := make([]*types.RdmaDevice, 0, len(inv.devices))
+	for _, dev := range inv.devices {
+		out = append(out, dev.Clone())
+	}
+	return out, nil
+}
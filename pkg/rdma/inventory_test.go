@@ -0,0 +1,69 @@
+package rdma
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+func TestReadInventory(t *testing.T) {
+	devices := []*types.RdmaDevice{
+		{PciAddress: "0000:17:00.0", IfName: "bond0", IfNames: []string{"bond0", "enp23s0f0np0"},
+			RdmaDevices: []string{"/dev/infiniband/uverbs0"}},
+		{PciAddress: "0000:41:00.0", Error: "no RDMA device"},
+	}
+	data, err := json.Marshal(devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, err := ReadInventory(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := inv.DiscoverAll()
+	if err != nil || len(all) != 2 || all[1].Error != "no RDMA device" {
+		t.Fatalf("DiscoverAll() = %+v, %v", all, err)
+	}
+	all[0].RdmaDevices[0] = "/changed"
+	if dev, err := inv.DiscoverByPCI("17:00.0"); err != nil || dev.RdmaDevices[0] != "/dev/infiniband/uverbs0" {
+		t.Errorf("DiscoverByPCI() = %+v, %v; devices should be copies", dev, err)
+	}
+	if dev, err := inv.DiscoverByIfName("enp23s0f0np0"); err != nil || dev.PciAddress != "0000:17:00.0" {
+		t.Errorf("DiscoverByIfName() of a bond member = %+v, %v", dev, err)
+	}
+	if _, err := inv.DiscoverByPCI("0000:ff:00.0"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("missing device: expected ErrDeviceNotFound, got %v", err)
+	}
+	if _, err := inv.DiscoverByIfName("nosuch0"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("missing interface: expected ErrDeviceNotFound, got %v", err)
+	}
+
+	empty, err := ReadInventory(strings.NewReader("[]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := empty.DiscoverAll(); !errors.Is(err, ErrNoRdmaDevices) {
+		t.Errorf("empty inventory: expected ErrNoRdmaDevices, got %v", err)
+	}
+}
+
+func TestReadInventory_Invalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"not_json":      "discover output",
+		"not_array":     `{"pci_address": "0000:17:00.0"}`,
+		"null_device":   `[null]`,
+		"no_address":    `[{"ifname": "eth0"}]`,
+		"bad_address":   `[{"pci_address": "17:00.0"}]`,
+		"relative_path": `[{"pci_address": "0000:17:00.0", "rdma_devices": ["uverbs0"]}]`,
+		"duplicate":     `[{"pci_address": "0000:17:00.0"}, {"pci_address": "0000:17:00.0"}]`,
+	} {
+		if _, err := ReadInventory(strings.NewReader(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
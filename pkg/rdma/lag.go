@@ -0,0 +1,111 @@
+package rdma
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// WithoutLAGCollapse reports each bonded physical function of a RoCE LAG
+// on its own, as discovery did before LAG support, instead of one device
+// for the bond. The non-primary functions then usually have no RDMA
+// device of their own and are left out.
+func WithoutLAGCollapse() Option {
+	return func(d *Discoverer) {
+		d.noLAGCollapse = true
+	}
+}
+
+// GetBond returns the RoCE LAG the RDMA device ibdev serves, or nil if it
+// is not bonded. With mlx5 LAG the functions' netdevs are enslaved to a
+// bonding interface, and the single RDMA device registered on the first
+// function binds its GIDs to that interface; a bond of plain RDMA
+// functions leaves them bound to the slaves.
+func (d *Discoverer) GetBond(ibdev string) *types.RdmaBond {
+	master := readSysfsAttr(d.sysPath(sysInfiniband, ibdev, "ports/1/gid_attrs/ndevs/0"))
+	if master == "" {
+		return nil
+	}
+	slaves := strings.Fields(readSysfsAttr(d.sysPath(sysNetDevices, master, "bonding/slaves")))
+	bond := &types.RdmaBond{IfName: master}
+	for _, slave := range slaves {
+		pciAddr, err := d.GetPciAddress(slave)
+		if err != nil {
+			continue // not a PCI function, e.g. a veth
+		}
+		bond.Members = append(bond.Members, types.BondMember{PciAddress: pciAddr, IfName: slave})
+	}
+	if len(bond.Members) < 2 {
+		return nil
+	}
+	slices.SortFunc(bond.Members, func(a, b types.BondMember) int { return strings.Compare(a.PciAddress, b.PciAddress) })
+	return bond
+}
+
+// bondMaster returns the bonding interface ifName is enslaved to, or "".
+func (d *Discoverer) bondMaster(ifName string) string {
+	target, err := os.Readlink(d.sysPath(sysNetDevices, ifName, "master"))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// lagOwner returns the PCI address of the function whose RDMA device
+// serves the RoCE LAG on the bonding interface master, or "" if master
+// is not such a bond.
+func (d *Discoverer) lagOwner(master string) string {
+	entries, _ := os.ReadDir(d.sysPath(sysInfiniband))
+	for _, e := range entries {
+		if bond := d.GetBond(e.Name()); bond != nil && bond.IfName == master {
+			pciAddr, err := d.GetPciAddressForIbdev(e.Name())
+			if err != nil {
+				return ""
+			}
+			return pciAddr
+		}
+	}
+	return ""
+}
+
+// lagOwnerOf returns the PCI address of the function serving the RoCE LAG
+// the function at pciAddr is a member of, or "" if it is not bonded.
+func (d *Discoverer) lagOwnerOf(pciAddr string) string {
+	names, _ := d.GetNetNames(pciAddr)
+	for _, name := range names {
+		if master := d.bondMaster(name); master != "" {
+			if owner := d.lagOwner(master); owner != "" {
+				return owner
+			}
+		}
+	}
+	return ""
+}
+
+// collapseLAGs drops the devices that are bonded members of another
+// device's RoCE LAG, which stands for all of them.
+func collapseLAGs(devices []*types.RdmaDevice) []*types.RdmaDevice {
+	covered := make(map[string]string)
+	for _, dev := range devices {
+		if dev.Bond == nil {
+			continue
+		}
+		for _, m := range dev.Bond.Members {
+			if m.PciAddress != dev.PciAddress {
+				covered[m.PciAddress] = dev.PciAddress
+			}
+		}
+	}
+	return slices.DeleteFunc(devices, func(dev *types.RdmaDevice) bool {
+		owner, ok := covered[dev.PciAddress]
+		if ok {
+			log.Infof("%s is bonded into the RoCE LAG of %s, which covers it", dev.PciAddress, owner)
+		}
+		return ok
+	})
+}
@@ -0,0 +1,25 @@
+package rdma
+
+import (
+	"testing"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+func TestCollapseLAGs(t *testing.T) {
+	lag := &types.RdmaDevice{PciAddress: "0000:17:00.0", IfName: "bond0", Bond: &types.RdmaBond{
+		IfName: "bond0",
+		Members: []types.BondMember{
+			{PciAddress: "0000:17:00.0", IfName: "enp23s0f0np0"},
+			{PciAddress: "0000:17:00.1", IfName: "enp23s0f1np1"},
+		},
+	}}
+	// A member that still registers an RDMA device of its own
+	member := &types.RdmaDevice{PciAddress: "0000:17:00.1", IfName: "enp23s0f1np1"}
+	other := &types.RdmaDevice{PciAddress: "0000:41:00.0", IfName: "enp65s0np0"}
+
+	got := collapseLAGs([]*types.RdmaDevice{lag, member, other})
+	if len(got) != 2 || got[0] != lag || got[1] != other {
+		t.Errorf("collapseLAGs() = %+v, want the LAG and the unbonded device", got)
+	}
+}
@@ -0,0 +1,198 @@
+package rdma
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// Backend selects how a Discoverer enumerates RDMA devices.
+type Backend string
+
+const (
+	// BackendSysfs scans <sysfs>/bus/pci/devices/*/infiniband (default).
+	BackendSysfs Backend = "sysfs"
+	// BackendNetlink enumerates RDMA devices through the RDMA netlink (nldev)
+	// API, which is authoritative even when sysfs views diverge in
+	// exclusive netns mode.
+	BackendNetlink Backend = "netlink"
+)
+
+// sysInfiniband is the RDMA device class directory relative to the sysfs root.
+const sysInfiniband = "class/infiniband"
+
+// Netlink entry points, replaceable in tests.
+var (
+	rdmaLinkList           = netlink.RdmaLinkList
+	rdmaSystemGetNetnsMode = netlink.RdmaSystemGetNetnsMode
+	rdmaSystemSetNetnsMode = netlink.RdmaSystemSetNetnsMode
+)
+
+// RDMA subsystem netns modes.
+const (
+	// NetnsModeShared makes every RDMA device visible in all network
+	// namespaces.
+	NetnsModeShared = "shared"
+	// NetnsModeExclusive binds each RDMA device to a single network
+	// namespace, so containers only see the devices moved into theirs.
+	NetnsModeExclusive = "exclusive"
+)
+
+// ParseBackend validates a backend name given on the command line.
+func ParseBackend(s string) (Backend, error) {
+	switch Backend(s) {
+	case "", BackendSysfs:
+		return BackendSysfs, nil
+	case BackendNetlink:
+		return BackendNetlink, nil
+	default:
+		return "", fmt.Errorf("unsupported discovery backend %q: use sysfs or netlink", s)
+	}
+}
+
+// WithBackend selects the discovery backend. Defaults to BackendSysfs.
+func WithBackend(b Backend) Option {
+	return func(d *Discoverer) {
+		d.backend = b
+	}
+}
+
+// GetNetnsMode returns the RDMA subsystem netns mode ("shared" or
+// "exclusive") as reported by RDMA netlink.
+func GetNetnsMode() (string, error) {
+	mode, err := rdmaSystemGetNetnsMode()
+	if err != nil {
+		return "", fmt.Errorf("cannot query RDMA netns mode via netlink: %w", err)
+	}
+	return mode, nil
+}
+
+// SetNetnsMode switches the RDMA subsystem netns mode via RDMA netlink,
+// like "rdma system set netns". It requires CAP_NET_ADMIN, and the kernel
+// refuses to change the mode while network namespaces other than the
+// initial one exist.
+func SetNetnsMode(mode string) error {
+	if mode != NetnsModeShared && mode != NetnsModeExclusive {
+		return fmt.Errorf("unsupported RDMA netns mode %q: use %s or %s", mode, NetnsModeShared, NetnsModeExclusive)
+	}
+	if err := rdmaSystemSetNetnsMode(mode); err != nil {
+		return fmt.Errorf("cannot set RDMA netns mode to %s via netlink: %w", mode, err)
+	}
+	return nil
+}
+
+// WithNetnsMode reports mode as the RDMA netns mode of discovered devices
+// instead of querying RDMA netlink, e.g. the mode recorded in a support
+// bundle.
+func WithNetnsMode(mode string) Option {
+	return func(d *Discoverer) {
+		d.netns = mode
+		d.netnsSet = true
+	}
+}
+
+// netnsMode returns the RDMA netns mode for RdmaDevice.NetnsMode, queried
+// once per Discoverer. It is empty offline or when the query fails.
+func (d *Discoverer) netnsMode() string {
+	d.netnsOnce.Do(func() {
+		if d.netnsSet || d.offline {
+			return
+		}
+		mode, err := GetNetnsMode()
+		if err != nil {
+			log.Debugf("%v", err)
+			d.netnsErr = err
+			return
+		}
+		d.netns = mode
+	})
+	return d.netns
+}
+
+// GetPciAddressForIbdev resolves the PCI address that backs an RDMA device
+// (e.g. "mlx5_0") via the <sysfs>/class/infiniband/<ibdev>/device symlink.
+func (d *Discoverer) GetPciAddressForIbdev(ibdev string) (string, error) {
+	target, err := os.Readlink(d.sysPath(sysInfiniband, ibdev, "device"))
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve PCI device for RDMA device %s: %w", ibdev, err)
+	}
+	return path.Base(target), nil
+}
+
+// netlinkIbdevsByPCI lists RDMA links over netlink and groups their names
+// by backing PCI address. Links without a PCI parent (e.g. rxe) are skipped.
+func (d *Discoverer) netlinkIbdevsByPCI() (map[string][]string, error) {
+	links, err := rdmaLinkList()
+	if err != nil {
+		return nil, fmt.Errorf("cannot list RDMA links via netlink: %w", err)
+	}
+
+	byPCI := make(map[string][]string)
+	for _, link := range links {
+		pciAddr, err := d.GetPciAddressForIbdev(link.Attrs.Name)
+		if err != nil {
+			log.Debugf("skipping RDMA link %s: %v", link.Attrs.Name, err)
+			continue
+		}
+		byPCI[pciAddr] = append(byPCI[pciAddr], link.Attrs.Name)
+	}
+	return byPCI, nil
+}
+
+// netlinkIbdevsForPcidev returns the RDMA device names bound to pciAddress
+// according to RDMA netlink. ok is false if RDMA netlink cannot be used by
+// this process, so the caller should fall back to sysfs.
+func (d *Discoverer) netlinkIbdevsForPcidev(pciAddress string) (names []string, ok bool) {
+	byPCI, err := d.netlinkIbdevsByPCI()
+	if err != nil {
+		log.Debugf("%v", err)
+		return nil, !netlinkUnavailable(err)
+	}
+	return byPCI[pciAddress], true
+}
+
+// netlinkUnavailable reports whether err means RDMA netlink is not usable
+// by this process at all, e.g. denied to an unprivileged user or not
+// supported in its network namespace, as opposed to a failed query.
+func netlinkUnavailable(err error) bool {
+	return errors.Is(err, fs.ErrPermission) || errors.Is(err, unix.EPROTONOSUPPORT)
+}
+
+// discoverAllNetlink is the BackendNetlink implementation of DiscoverAll.
+func (d *Discoverer) discoverAllNetlink() ([]*types.RdmaDevice, error) {
+	byPCI, err := d.netlinkIbdevsByPCI()
+	if err != nil {
+		return nil, err
+	}
+
+	pciAddrs := make([]string, 0, len(byPCI))
+	for pciAddr := range byPCI {
+		pciAddrs = append(pciAddrs, pciAddr)
+	}
+	slices.Sort(pciAddrs)
+
+	var found []rdmaPCIDevice
+	for _, pciAddr := range pciAddrs {
+		charDevs, err := isolate(d, pciAddr, func() []string {
+			var charDevs []string
+			for _, ibdev := range byPCI[pciAddr] {
+				charDevs = append(charDevs, d.GetRdmaCharDevicesForIbdev(ibdev)...)
+			}
+			return charDevs
+		})
+		if err == nil && len(charDevs) == 0 {
+			continue
+		}
+		found = append(found, rdmaPCIDevice{pciAddr, charDevs, err})
+	}
+	return d.buildAll(found)
+}
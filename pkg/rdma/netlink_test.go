@@ -0,0 +1,129 @@
+package rdma
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// fakeRdmaLinks replaces the netlink link listing for the duration of a test.
+func fakeRdmaLinks(t *testing.T, names ...string) {
+	t.Helper()
+	orig := rdmaLinkList
+	t.Cleanup(func() { rdmaLinkList = orig })
+
+	rdmaLinkList = func() ([]*netlink.RdmaLink, error) {
+		links := make([]*netlink.RdmaLink, 0, len(names))
+		for i, n := range names {
+			links = append(links, &netlink.RdmaLink{Attrs: netlink.RdmaLinkAttrs{Index: uint32(i), Name: n}})
+		}
+		return links, nil
+	}
+}
+
+// linkIbdev creates <sysfs>/class/infiniband/<ibdev>/device → PCI device.
+func linkIbdev(t *testing.T, sysRoot, ibdev, pciAddr string) {
+	t.Helper()
+	dir := filepath.Join(sysRoot, sysInfiniband, ibdev)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join("..", "..", "..", sysBusPci, pciAddr)
+	if err := os.Symlink(target, filepath.Join(dir, "device")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseBackend(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Backend
+		wantErr bool
+	}{
+		{"", BackendSysfs, false},
+		{"sysfs", BackendSysfs, false},
+		{"netlink", BackendNetlink, false},
+		{"udev", "", true},
+	}
+	for _, tc := range tests {
+		got, err := ParseBackend(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseBackend(%q) error = %v, wantErr %v", tc.in, err, tc.wantErr)
+		}
+		if got != tc.want {
+			t.Errorf("ParseBackend(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestGetPciAddressForIbdev(t *testing.T) {
+	sysRoot, _ := seedRdmaSysfs(t)
+	linkIbdev(t, sysRoot, "mlx5_0", "0000:17:00.0")
+	d := NewDiscoverer(WithSysfsRoot(sysRoot))
+
+	got, err := d.GetPciAddressForIbdev("mlx5_0")
+	if err != nil {
+		t.Fatalf("GetPciAddressForIbdev failed: %v", err)
+	}
+	if got != "0000:17:00.0" {
+		t.Errorf("GetPciAddressForIbdev = %q, want 0000:17:00.0", got)
+	}
+}
+
+func TestNetlinkBackend_DiscoverAll(t *testing.T) {
+	sysRoot, devRoot := seedRdmaSysfs(t)
+	linkIbdev(t, sysRoot, "mlx5_0", "0000:17:00.0")
+	fakeRdmaLinks(t, "mlx5_0", "rxe0") // rxe0 has no PCI parent
+
+	d := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot), WithBackend(BackendNetlink))
+	devices, err := d.DiscoverAll()
+	if err != nil {
+		t.Fatalf("DiscoverAll failed: %v", err)
+	}
+	if len(devices) != 1 || devices[0].PciAddress != "0000:17:00.0" {
+		t.Fatalf("expected only 0000:17:00.0, got %v", devices)
+	}
+	if len(devices[0].RdmaDevices) != 4 {
+		t.Errorf("expected 4 char devices, got %v", devices[0].RdmaDevices)
+	}
+}
+
+func TestNetlinkBackend_DiscoverByPCI(t *testing.T) {
+	sysRoot, devRoot := seedRdmaSysfs(t)
+	linkIbdev(t, sysRoot, "mlx5_0", "0000:17:00.0")
+	d := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot), WithBackend(BackendNetlink))
+
+	// mlx5_0 exists in sysfs but netlink (e.g. another netns) does not report it
+	fakeRdmaLinks(t)
+	if _, err := d.DiscoverByPCI("0000:17:00.0"); !errors.Is(err, ErrNoRdmaDevices) {
+		t.Errorf("expected ErrNoRdmaDevices when netlink reports no links, got %v", err)
+	}
+
+	fakeRdmaLinks(t, "mlx5_0")
+	if _, err := d.DiscoverByPCI("0000:17:00.0"); err != nil {
+		t.Errorf("DiscoverByPCI failed: %v", err)
+	}
+}
+
+func TestNetlinkBackend_FallsBackToSysfs(t *testing.T) {
+	sysRoot, devRoot := seedRdmaSysfs(t)
+	orig := rdmaLinkList
+	t.Cleanup(func() { rdmaLinkList = orig })
+	rdmaLinkList = func() ([]*netlink.RdmaLink, error) { return nil, unix.EPERM }
+
+	d := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot), WithBackend(BackendNetlink))
+	devices, err := d.DiscoverAll()
+	if err != nil {
+		t.Fatalf("DiscoverAll failed: %v", err)
+	}
+	if len(devices) != 1 || devices[0].PciAddress != "0000:17:00.0" {
+		t.Errorf("expected 0000:17:00.0 from sysfs, got %v", devices)
+	}
+	if _, err := d.DiscoverByPCI("0000:17:00.0"); err != nil {
+		t.Errorf("DiscoverByPCI failed: %v", err)
+	}
+}
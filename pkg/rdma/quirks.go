@@ -0,0 +1,85 @@
+package rdma
+
+import (
+	"slices"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// Quirk describes what a healthy RDMA device looks like for one vendor's
+// driver stack. Mellanox/NVIDIA HCAs expose every char device type and need
+// the full verbs + CM module set; other vendors differ (iWARP NICs have no
+// MADs, Omni-Path ships its own hfi1 char device, ...).
+type Quirk struct {
+	// Name identifies the profile in diagnostics (e.g. "irdma").
+	Name string
+	// Drivers lists the PCI drivers the profile applies to.
+	Drivers []string
+	// RequiredDevices lists the char device types a usable device exposes.
+	RequiredDevices []string
+	// KernelModules lists the modules that must be loaded.
+	KernelModules []string
+	// ExtraDevNodes lists glob patterns under /dev for vendor-specific
+	// device nodes (e.g. "hfi1_*") that should exist alongside the RDMA ones.
+	ExtraDevNodes []string
+}
+
+// DefaultQuirk is used for drivers without a dedicated profile.
+var DefaultQuirk = Quirk{
+	Name:            "generic",
+	RequiredDevices: types.RequiredRdmaDevices,
+	KernelModules:   []string{"ib_core", "ib_uverbs", "ib_umad", "rdma_cm", "rdma_ucm"},
+}
+
+// quirks is keyed by the PCI driver bound to the device. The RDMA half of
+// most non-Mellanox stacks is an auxiliary driver (irdma, bnxt_re, qedr)
+// layered on the Ethernet PCI driver.
+var quirks = []Quirk{
+	{
+		Name:            "mlx5",
+		Drivers:         []string{"mlx5_core"},
+		RequiredDevices: types.RequiredRdmaDevices,
+		KernelModules:   []string{"ib_core", "ib_uverbs", "ib_umad", "rdma_cm", "rdma_ucm", "mlx5_ib"},
+	},
+	{
+		Name:            "mlx4",
+		Drivers:         []string{"mlx4_core"},
+		RequiredDevices: types.RequiredRdmaDevices,
+		KernelModules:   []string{"ib_core", "ib_uverbs", "ib_umad", "rdma_cm", "rdma_ucm", "mlx4_ib"},
+	},
+	{
+		Name:            "irdma",
+		Drivers:         []string{"ice", "i40e"},
+		RequiredDevices: []string{"uverbs", "rdma_cm"},
+		KernelModules:   []string{"ib_core", "ib_uverbs", "rdma_cm", "irdma"},
+	},
+	{
+		Name:            "bnxt_re",
+		Drivers:         []string{"bnxt_en"},
+		RequiredDevices: []string{"uverbs", "rdma_cm"},
+		KernelModules:   []string{"ib_core", "ib_uverbs", "rdma_cm", "bnxt_re"},
+	},
+	{
+		Name:            "qedr",
+		Drivers:         []string{"qede"},
+		RequiredDevices: []string{"uverbs", "rdma_cm"},
+		KernelModules:   []string{"ib_core", "ib_uverbs", "rdma_cm", "qedr"},
+	},
+	{
+		Name:            "opa",
+		Drivers:         []string{"hfi1"},
+		RequiredDevices: []string{"uverbs", "umad"},
+		KernelModules:   []string{"ib_core", "ib_uverbs", "ib_umad", "rdmavt", "hfi1"},
+		ExtraDevNodes:   []string{"hfi1_*"},
+	},
+}
+
+// QuirkForDriver returns the profile for a PCI driver, or DefaultQuirk.
+func QuirkForDriver(driver string) Quirk {
+	for _, q := range quirks {
+		if slices.Contains(q.Drivers, driver) {
+			return q
+		}
+	}
+	return DefaultQuirk
+}
@@ -0,0 +1,67 @@
+package rdma
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestQuirkForDriver(t *testing.T) {
+	tests := []struct {
+		driver   string
+		want     string
+		needUmad bool
+	}{
+		{"mlx5_core", "mlx5", true},
+		{"ice", "irdma", false},
+		{"i40e", "irdma", false},
+		{"bnxt_en", "bnxt_re", false},
+		{"qede", "qedr", false},
+		{"hfi1", "opa", true},
+		{"", "generic", true},
+		{"unknown_drv", "generic", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.want+"/"+tc.driver, func(t *testing.T) {
+			q := QuirkForDriver(tc.driver)
+			if q.Name != tc.want {
+				t.Errorf("QuirkForDriver(%q).Name = %q, want %q", tc.driver, q.Name, tc.want)
+			}
+			if got := slices.Contains(q.RequiredDevices, DeviceTypeUmad); got != tc.needUmad {
+				t.Errorf("%s requires umad = %v, want %v", q.Name, got, tc.needUmad)
+			}
+			if len(q.KernelModules) == 0 {
+				t.Errorf("%s profile lists no kernel modules", q.Name)
+			}
+		})
+	}
+}
+
+func TestQuirks_NonMellanoxSkipRdmaUcm(t *testing.T) {
+	for _, driver := range []string{"ice", "bnxt_en", "qede", "hfi1"} {
+		if q := QuirkForDriver(driver); slices.Contains(q.KernelModules, "rdma_ucm") {
+			t.Errorf("%s profile should not require rdma_ucm", q.Name)
+		}
+	}
+}
+
+func TestDiscoverByPCI_QuirkRequiredDevices(t *testing.T) {
+	sysRoot, devRoot := seedRdmaSysfs(t)
+	// An irdma (ice) port exposes no umad nodes
+	if err := os.RemoveAll(filepath.Join(sysRoot, sysUmad)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../../bus/pci/drivers/ice", filepath.Join(sysRoot, sysBusPci, "0000:17:00.0", "driver")); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot))
+	dev, err := d.DiscoverByPCI("0000:17:00.0")
+	if err != nil {
+		t.Fatalf("irdma profile should accept a device without umad, got %v", err)
+	}
+	if dev.Driver != "ice" {
+		t.Errorf("Driver = %q, want ice", dev.Driver)
+	}
+}
@@ -1,32 +1,272 @@
 // Package rdma provides RDMA device discovery helpers.
-// It wraps the Mellanox/rdmamap library to translate PCI addresses and
-// network interface names into lists of RDMA character device paths.
+// It walks sysfs to translate PCI addresses and network interface names
+// into lists of RDMA character device paths. The sysfs and /dev roots are
+// configurable so discovery also works from inside a container that has the
+// host filesystem mounted under a prefix.
 package rdma
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/Mellanox/rdmamap"
+	log "github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 
+	"github.com/Nativu5/rdma-cdi/pkg/pciids"
+	"github.com/Nativu5/rdma-cdi/pkg/plugin"
 	"github.com/Nativu5/rdma-cdi/pkg/types"
+	"github.com/Nativu5/rdma-cdi/pkg/utils"
 )
 
+const (
+	// DefaultSysfsRoot is the mount point of sysfs on the host.
+	DefaultSysfsRoot = "/sys"
+	// DefaultDevRoot is the mount point of devtmpfs on the host.
+	DefaultDevRoot = "/dev"
+
+	// rdmaDevDir is the directory holding RDMA character devices, relative
+	// to the dev root. Paths reported in RdmaDevice always use the host view.
+	rdmaDevDir = "infiniband"
+	// rdmaCmName is the node-global RDMA connection manager device.
+	rdmaCmName = "rdma_cm"
+)
+
+// sysfs locations relative to the sysfs root.
+const (
+	sysNetDevices = "class/net"
+	sysBusPci     = "bus/pci/devices"
+	sysPciSlots   = "bus/pci/slots"
+	sysUverbs     = "class/infiniband_verbs"
+	sysUmad       = "class/infiniband_mad"
+	sysUcm        = "class/infiniband_cm"
+	sysMisc       = "class/misc"
+)
+
+// Sentinel errors returned (wrapped) by discovery. Use errors.Is to test for them.
 var (
-	sysNetDevices = "/sys/class/net"
-	sysBusPci     = "/sys/bus/pci/devices"
+	// ErrNoRdmaDevices means the device, or the whole host, exposes no RDMA
+	// character devices.
+	ErrNoRdmaDevices = errors.New("no RDMA character devices found")
+	// ErrDeviceNotFound means the requested PCI device or network interface
+	// does not exist.
+	ErrDeviceNotFound = errors.New("device not found")
+	// ErrMissingRequiredDevice means the device has RDMA character devices but
+	// lacks one of the required types (see types.RequiredRdmaDevices and
+	// WithRequiredDevices).
+	ErrMissingRequiredDevice = errors.New("required RDMA device type not found")
 )
 
-// Discoverer implements types.RdmaDeviceDiscoverer using real sysfs + rdmamap.
-type Discoverer struct{}
+// Option configures a Discoverer.
+type Option func(*Discoverer)
+
+// WithSysfsRoot sets the directory where the host sysfs is mounted
+// (e.g. "/host/sys"). Defaults to DefaultSysfsRoot.
+func WithSysfsRoot(root string) Option {
+	return func(d *Discoverer) {
+		d.sysfsRoot = root
+	}
+}
+
+// WithDevRoot sets the directory where the host /dev is mounted
+// (e.g. "/host/dev"). It is only used to probe for device nodes;
+// discovered paths are always reported as host paths under /dev.
+// Defaults to DefaultDevRoot.
+func WithDevRoot(root string) Option {
+	return func(d *Discoverer) {
+		d.devRoot = root
+	}
+}
+
+// WithSpecOptions sets the options used to build the DeviceSpecs of every
+// discovered device.
+func WithSpecOptions(opts ...SpecOption) Option {
+	return func(d *Discoverer) {
+		d.specOpts = append(d.specOpts, opts...)
+	}
+}
 
-// NewDiscoverer returns a real RDMA device discoverer.
-func NewDiscoverer() *Discoverer {
-	return &Discoverer{}
+// WithRequiredDevices overrides the device types (e.g. "uverbs", "rdma_cm")
+// a device must expose for DiscoverByPCI to accept it, instead of the
+// per-driver Quirk. RoCE-only setups typically drop "umad"; with no
+// arguments any device set is accepted.
+func WithRequiredDevices(required ...string) Option {
+	return func(d *Discoverer) {
+		d.required = append([]string{}, required...)
+		d.requiredSet = true
+	}
+}
+
+// WithIncludeDevices sets which OptionalDeviceTypes (e.g. "issm", "ucm")
+// discovery reports; all of them by default, as rdmamap did. With no
+// arguments only the core device types are reported.
+func WithIncludeDevices(deviceTypes ...string) Option {
+	return func(d *Discoverer) {
+		d.include = append([]string{}, deviceTypes...)
+	}
+}
+
+// ProgressFunc is called by DiscoverAll after each RDMA device is built:
+// done of total devices are complete, the last being pciAddress.
+type ProgressFunc func(done, total int, pciAddress string)
+
+// WithProgress makes DiscoverAll report its progress to fn, for hosts with
+// many devices (e.g. dozens of VFs) where enumeration takes a while.
+func WithProgress(fn ProgressFunc) Option {
+	return func(d *Discoverer) {
+		d.progress = fn
+	}
+}
+
+// DefaultDeviceTimeout bounds how long DiscoverAll waits for a single
+// device before reporting it as failed.
+const DefaultDeviceTimeout = 30 * time.Second
+
+// WithDeviceTimeout sets how long DiscoverAll waits for each device, e.g.
+// one whose sysfs reads hang behind wedged firmware, before reporting it
+// as failed and moving on. Defaults to DefaultDeviceTimeout.
+func WithDeviceTimeout(timeout time.Duration) Option {
+	return func(d *Discoverer) {
+		d.deviceTimeout = timeout
+	}
+}
+
+// WithOffline makes discovery read nothing but the sysfs and dev roots,
+// for snapshots of another host such as support bundles: link types come
+// from <sysfs>/class/net/<if>/type instead of netlink on the running host.
+func WithOffline() Option {
+	return func(d *Discoverer) {
+		d.offline = true
+	}
+}
+
+// withAddrList replaces how discovery lists interface addresses, for
+// tests.
+func withAddrList(fn addrLister) Option {
+	return func(d *Discoverer) {
+		d.addrList = fn
+	}
+}
+
+// WithPlugins runs the given vendor plugins on every device discovery
+// builds: those that match the device record their attributes on it.
+func WithPlugins(plugins ...plugin.Plugin) Option {
+	return func(d *Discoverer) {
+		d.plugins = append(d.plugins, plugins...)
+	}
+}
+
+// WithPCIIDs sets the database model names are looked up in. Defaults to
+// the system pci.ids, or the built-in subset when there is none.
+func WithPCIIDs(db *pciids.DB) Option {
+	return func(d *Discoverer) {
+		d.pciIDs = db
+	}
+}
+
+// Discoverer implements types.RdmaDeviceDiscoverer using sysfs + netlink.
+type Discoverer struct {
+	sysfsRoot string
+	devRoot   string
+	backend   Backend
+	specOpts  []SpecOption
+	include   []string
+	progress  ProgressFunc
+	offline   bool
+	pciIDs    *pciids.DB
+	// netns is queried once unless netnsSet by WithNetnsMode; netnsErr
+	// is why the query failed.
+	netns     string
+	netnsErr  error
+	netnsSet  bool
+	netnsOnce sync.Once
+	// required overrides the per-driver Quirk when requiredSet is true.
+	required      []string
+	requiredSet   bool
+	deviceTimeout time.Duration
+	noLAGCollapse bool
+	plugins       []plugin.Plugin
+	usage         bool
+	addrList      addrLister
+}
+
+// NewDiscoverer returns an RDMA device discoverer reading the host sysfs
+// and /dev, unless overridden by options.
+func NewDiscoverer(opts ...Option) *Discoverer {
+	d := &Discoverer{
+		sysfsRoot:     DefaultSysfsRoot,
+		devRoot:       DefaultDevRoot,
+		backend:       BackendSysfs,
+		include:       OptionalDeviceTypes,
+		deviceTimeout: DefaultDeviceTimeout,
+		addrList:      netlinkAddrList,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+var (
+	systemPCIIDsOnce sync.Once
+	systemPCIIDs     *pciids.DB
+)
+
+// pciDB returns the configured PCI IDs database, loading the system one
+// on first use.
+func (d *Discoverer) pciDB() *pciids.DB {
+	if d.pciIDs != nil {
+		return d.pciIDs
+	}
+	systemPCIIDsOnce.Do(func() {
+		systemPCIIDs = pciids.Load(pciids.DefaultPaths...)
+	})
+	return systemPCIIDs
+}
+
+// SysfsRoot returns the sysfs root this discoverer reads from.
+func (d *Discoverer) SysfsRoot() string {
+	return d.sysfsRoot
+}
+
+// DevRoot returns the /dev root this discoverer probes.
+func (d *Discoverer) DevRoot() string {
+	return d.devRoot
+}
+
+// requiredDevicesFor returns the device types DiscoverByPCI insists on for
+// a PCI device: the WithRequiredDevices override, or the driver's Quirk.
+func (d *Discoverer) requiredDevicesFor(pciAddr string) []string {
+	if d.requiredSet {
+		return d.required
+	}
+	driver, _ := d.GetPCIDevDriver(pciAddr)
+	return QuirkForDriver(driver).RequiredDevices
+}
+
+// Backend returns the discovery backend in use.
+func (d *Discoverer) Backend() Backend {
+	return d.backend
+}
+
+// sysPath joins elem onto the configured sysfs root.
+func (d *Discoverer) sysPath(elem ...string) string {
+	return filepath.Join(append([]string{d.sysfsRoot}, elem...)...)
+}
+
+// devPath joins elem onto the configured /dev root.
+func (d *Discoverer) devPath(elem ...string) string {
+	return filepath.Join(append([]string{d.devRoot}, elem...)...)
 }
 
 // ───────────────────────────────────────────
@@ -34,10 +274,13 @@ func NewDiscoverer() *Discoverer {
 // ───────────────────────────────────────────
 
 // GetPciAddress returns the PCI address for a given network interface name
-// by reading the /sys/class/net/<ifName>/device symlink.
-func GetPciAddress(ifName string) (string, error) {
-	ifaceDir := path.Join(sysNetDevices, ifName, "device")
+// by reading the <sysfs>/class/net/<ifName>/device symlink.
+func (d *Discoverer) GetPciAddress(ifName string) (string, error) {
+	ifaceDir := d.sysPath(sysNetDevices, ifName, "device")
 	dirInfo, err := os.Lstat(ifaceDir)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("network interface %q: %w", ifName, ErrDeviceNotFound)
+	}
 	if err != nil {
 		return "", fmt.Errorf("cannot stat device symlink for interface %q: %w", ifName, err)
 	}
@@ -56,9 +299,9 @@ func GetPciAddress(ifName string) (string, error) {
 }
 
 // GetNetNames returns the network interface names associated with a PCI device
-// by listing /sys/bus/pci/devices/<pciAddr>/net/.
-func GetNetNames(pciAddr string) ([]string, error) {
-	netDir := filepath.Join(sysBusPci, pciAddr, "net")
+// by listing <sysfs>/bus/pci/devices/<pciAddr>/net/.
+func (d *Discoverer) GetNetNames(pciAddr string) ([]string, error) {
+	netDir := d.sysPath(sysBusPci, pciAddr, "net")
 	if _, err := os.Lstat(netDir); err != nil {
 		return nil, fmt.Errorf("no net directory under PCI device %s: %w", pciAddr, err)
 	}
@@ -76,8 +319,8 @@ func GetNetNames(pciAddr string) ([]string, error) {
 }
 
 // GetPCIDevDriver returns the kernel driver currently bound to a PCI device.
-func GetPCIDevDriver(pciAddr string) (string, error) {
-	driverLink := filepath.Join(sysBusPci, pciAddr, "driver")
+func (d *Discoverer) GetPCIDevDriver(pciAddr string) (string, error) {
+	driverLink := d.sysPath(sysBusPci, pciAddr, "driver")
 	driverInfo, err := os.Readlink(driverLink)
 	if err != nil {
 		return "", fmt.Errorf("cannot read driver symlink for PCI device %s: %w", pciAddr, err)
@@ -86,25 +329,239 @@ func GetPCIDevDriver(pciAddr string) (string, error) {
 }
 
 // GetPCIVendor returns the PCI vendor ID for a device (e.g. "0x15b3" → "15b3").
-func GetPCIVendor(pciAddr string) string {
-	return readSysfsAttr(filepath.Join(sysBusPci, pciAddr, "vendor"))
+func (d *Discoverer) GetPCIVendor(pciAddr string) string {
+	return readSysfsAttr(d.sysPath(sysBusPci, pciAddr, "vendor"))
 }
 
 // GetPCIDeviceID returns the PCI device/product ID for a device.
-func GetPCIDeviceID(pciAddr string) string {
-	return readSysfsAttr(filepath.Join(sysBusPci, pciAddr, "device"))
+func (d *Discoverer) GetPCIDeviceID(pciAddr string) string {
+	return readSysfsAttr(d.sysPath(sysBusPci, pciAddr, "device"))
+}
+
+// zeroGUID is the node GUID of devices that have none assigned yet (e.g.
+// VFs before the PF configures them).
+const zeroGUID = "0000:0000:0000:0000"
+
+var guidRe = regexp.MustCompile(`^[0-9a-f]{4}(:[0-9a-f]{4}){3}$`)
+
+// GetNodeGUID returns the node GUID of an RDMA device (e.g. "mlx5_0"), or
+// "" if it is unset or unknown, as in support bundles, which redact it.
+func (d *Discoverer) GetNodeGUID(ibdev string) string {
+	guid := readSysfsAttr(d.sysPath(sysInfiniband, ibdev, "node_guid"))
+	if guid == zeroGUID || !guidRe.MatchString(guid) {
+		return ""
+	}
+	return guid
+}
+
+// GetBoardID returns the OEM board identifier of an RDMA device (e.g.
+// "MT_0000000010" or a vendor part number), or "" if the driver does not
+// report one.
+func (d *Discoverer) GetBoardID(ibdev string) string {
+	return readSysfsAttr(d.sysPath(sysInfiniband, ibdev, "board_id"))
+}
+
+// GetRate returns the active rate of the first port of an RDMA device
+// (e.g. "100 Gb/sec (4X EDR)"), or "" if unknown.
+func (d *Discoverer) GetRate(ibdev string) string {
+	return readSysfsAttr(d.sysPath(sysInfiniband, ibdev, "ports/1/rate"))
+}
+
+var bdfRe = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+
+// GetPhysicalSlot returns the label of the physical slot holding a PCI
+// device (e.g. "3"), as named under /sys/bus/pci/slots, or "" if the
+// platform does not describe its slots. A slot lists the domain:bus:device
+// address of the card it holds, so the device itself, its PF and the
+// bridges above it (a card with its own PCIe switch) are tried in turn.
+func (d *Discoverer) GetPhysicalSlot(pciAddr string) string {
+	entries, err := os.ReadDir(d.sysPath(sysPciSlots))
+	if err != nil || len(entries) == 0 {
+		return ""
+	}
+	slots := make(map[string]string, len(entries))
+	for _, e := range entries {
+		if addr := readSysfsAttr(d.sysPath(sysPciSlots, e.Name(), "address")); addr != "" {
+			slots[addr] = e.Name()
+		}
+	}
+
+	candidates := []string{pciAddr}
+	if parent, _, ok := d.GetParentPF(pciAddr); ok {
+		candidates = append(candidates, parent)
+	}
+	if real, err := filepath.EvalSymlinks(d.sysPath(sysBusPci, pciAddr)); err == nil {
+		elems := strings.Split(filepath.ToSlash(real), "/")
+		for i := len(elems) - 1; i >= 0; i-- {
+			if bdfRe.MatchString(elems[i]) {
+				candidates = append(candidates, elems[i])
+			}
+		}
+	}
+	for _, addr := range candidates {
+		// Strip the function: slots hold whole devices
+		if label, ok := slots[addr[:strings.LastIndex(addr, ".")]]; ok {
+			return label
+		}
+	}
+	return ""
+}
+
+// GetNumaNode returns the NUMA node of a PCI device, or -1 if sysfs does
+// not report one (single-node hosts, or firmware without locality info).
+func (d *Discoverer) GetNumaNode(pciAddr string) int {
+	n, err := strconv.Atoi(readSysfsAttr(d.sysPath(sysBusPci, pciAddr, "numa_node")))
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// GetParentPF reports whether pciAddr is an SR-IOV VF by reading its physfn
+// symlink. For a VF it returns the parent PF address and the VF index taken
+// from the matching virtfnN link under the PF; otherwise ok is false.
+func (d *Discoverer) GetParentPF(pciAddr string) (parent string, vfIndex int, ok bool) {
+	target, err := os.Readlink(d.sysPath(sysBusPci, pciAddr, "physfn"))
+	if err != nil {
+		return "", 0, false
+	}
+	parent = filepath.Base(target)
+
+	links, _ := filepath.Glob(d.sysPath(sysBusPci, parent, "virtfn*"))
+	for _, link := range links {
+		vfTarget, err := os.Readlink(link)
+		if err != nil || filepath.Base(vfTarget) != pciAddr {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(link), "virtfn")); err == nil {
+			return parent, n, true
+		}
+	}
+	return parent, 0, true
+}
+
+// GetSriovVFs returns the number of VFs currently enabled on the PF
+// pciAddr and the most it supports, from sriov_numvfs and sriov_totalvfs.
+// It fails for devices that are not SR-IOV capable PFs.
+func (d *Discoverer) GetSriovVFs(pciAddr string) (numVFs, totalVFs int, err error) {
+	for _, attr := range []struct {
+		name string
+		v    *int
+	}{{"sriov_numvfs", &numVFs}, {"sriov_totalvfs", &totalVFs}} {
+		data, err := os.ReadFile(d.sysPath(sysBusPci, pciAddr, attr.name))
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s is not an SR-IOV capable PF: %w", pciAddr, err)
+		}
+		if *attr.v, err = strconv.Atoi(strings.TrimSpace(string(data))); err != nil {
+			return 0, 0, fmt.Errorf("invalid %s of %s: %w", attr.name, pciAddr, err)
+		}
+	}
+	return numVFs, totalVFs, nil
 }
 
 // GetLinkType returns the link encapsulation type for a network interface via netlink.
 func GetLinkType(ifName string) string {
+	linkType, _ := getLinkType(ifName)
+	return linkType
+}
+
+// getLinkType is GetLinkType reporting why the link could not be queried.
+func getLinkType(ifName string) (string, error) {
 	if ifName == "" {
-		return ""
+		return "", nil
+	}
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return "", fmt.Errorf("cannot query link %s: %w", ifName, err)
 	}
+	return link.Attrs().EncapType, nil
+}
+
+// addrLister lists the addresses of a network interface.
+type addrLister func(ifName string) ([]netlink.Addr, error)
+
+// netlinkAddrList is the addrLister of the running host.
+func netlinkAddrList(ifName string) ([]netlink.Addr, error) {
 	link, err := netlink.LinkByName(ifName)
 	if err != nil {
+		return nil, err
+	}
+	return netlink.AddrList(link, netlink.FAMILY_ALL)
+}
+
+// GetAddresses returns the IPv4 and IPv6 addresses of the given network
+// interfaces via netlink, in CIDR notation. Interfaces that cannot be
+// queried are skipped.
+func GetAddresses(ifNames ...string) []string {
+	addrs, err := getAddresses(netlinkAddrList, ifNames...)
+	if err != nil {
+		log.Debugf("%v", err)
+	}
+	return addrs
+}
+
+// getAddresses is GetAddresses listing with addrList and also returning
+// the errors of the interfaces that could not be queried.
+func getAddresses(addrList addrLister, ifNames ...string) ([]string, error) {
+	var addrs []string
+	var errs []error
+	for _, name := range ifNames {
+		if name == "" {
+			continue
+		}
+		list, err := addrList(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot list addresses of %s: %w", name, err))
+			continue
+		}
+		for _, a := range list {
+			if a.IPNet != nil {
+				addrs = append(addrs, a.IPNet.String())
+			}
+		}
+	}
+	return addrs, errors.Join(errs...)
+}
+
+// ifNamesOf returns every network interface of dev.
+func ifNamesOf(dev *types.RdmaDevice) []string {
+	if len(dev.IfNames) > 0 {
+		return dev.IfNames
+	}
+	return []string{dev.IfName}
+}
+
+// arphrdEncap maps the ARPHRD_* values of <sysfs>/class/net/<if>/type to
+// the encapsulation names netlink reports, for the link types of RDMA
+// netdevs.
+var arphrdEncap = map[string]string{
+	"1":   "ether",
+	"32":  "infiniband",
+	"772": "loopback",
+}
+
+// linkType returns the link encapsulation type of a network interface,
+// from sysfs offline and from netlink otherwise.
+func (d *Discoverer) linkType(ifName string) string {
+	linkType, _ := d.lookupLinkType(ifName)
+	return linkType
+}
+
+// lookupLinkType is linkType reporting why netlink could not be queried.
+func (d *Discoverer) lookupLinkType(ifName string) (string, error) {
+	if d.offline {
+		return d.sysfsLinkType(ifName), nil
+	}
+	return getLinkType(ifName)
+}
+
+// sysfsLinkType returns the link encapsulation type of a network interface
+// from its sysfs type attribute, or "" if unknown.
+func (d *Discoverer) sysfsLinkType(ifName string) string {
+	if ifName == "" {
 		return ""
 	}
-	return link.Attrs().EncapType
+	return arphrdEncap[readSysfsAttr(d.sysPath(sysNetDevices, ifName, "type"))]
 }
 
 // readSysfsAttr reads a single sysfs attribute file, strips the "0x" prefix and whitespace.
@@ -122,22 +579,155 @@ func readSysfsAttr(path string) string {
 //  RDMA character device discovery
 // ───────────────────────────────────────────
 
+// GetRdmaDevicesForPcidev returns the RDMA device names (e.g. "mlx5_0")
+// registered under <sysfs>/bus/pci/devices/<pciAddr>/infiniband/, or
+// reported by RDMA netlink when using BackendNetlink. If RDMA netlink is
+// unavailable to the process (e.g. rootless), sysfs is read instead.
+func (d *Discoverer) GetRdmaDevicesForPcidev(pciAddress string) []string {
+	if d.backend == BackendNetlink {
+		if names, ok := d.netlinkIbdevsForPcidev(pciAddress); ok {
+			return names
+		}
+	}
+
+	entries, err := os.ReadDir(d.sysPath(sysBusPci, pciAddress, "infiniband"))
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+// GetRdmaCharDevicesForIbdev returns the character device paths that belong
+// to a single RDMA device (e.g. "mlx5_0"), plus the shared rdma_cm node when
+// it is present under the dev root. issm and ucm nodes are included unless
+// left out with WithIncludeDevices.
+func (d *Discoverer) GetRdmaCharDevicesForIbdev(ibdev string) []string {
+	var charDevs []string
+	charDevs = append(charDevs, d.classCharDevices(sysUverbs, "uverbs", ibdev)...)
+	charDevs = append(charDevs, d.classCharDevices(sysUmad, "umad", ibdev)...)
+	if slices.Contains(d.include, DeviceTypeIssm) {
+		charDevs = append(charDevs, d.classCharDevices(sysUmad, "issm", ibdev)...)
+	}
+	if slices.Contains(d.include, DeviceTypeUcm) {
+		charDevs = append(charDevs, d.classCharDevices(sysUcm, "ucm", ibdev)...)
+	}
+	if _, err := os.Stat(d.devPath(rdmaDevDir, rdmaCmName)); err == nil {
+		charDevs = append(charDevs, hostDevPath(rdmaCmName))
+	}
+	return charDevs
+}
+
+// classCharDevices lists <sysfs>/<classDir>/<prefix>N entries whose ibdev
+// attribute matches ibdev and returns their /dev/infiniband paths.
+func (d *Discoverer) classCharDevices(classDir, prefix, ibdev string) []string {
+	entries, err := os.ReadDir(d.sysPath(classDir))
+	if err != nil {
+		return nil
+	}
+	var charDevs []string
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		owner := readSysfsAttr(d.sysPath(classDir, e.Name(), "ibdev"))
+		if owner == ibdev {
+			charDevs = append(charDevs, hostDevPath(e.Name()))
+		}
+	}
+	return charDevs
+}
+
+// hostDevPath returns the host path of an RDMA character device.
+func hostDevPath(name string) string {
+	return path.Join(DefaultDevRoot, rdmaDevDir, name)
+}
+
 // GetRdmaCharDevices returns all RDMA character device paths for a PCI address.
 // Example: ["/dev/infiniband/uverbs0", "/dev/infiniband/rdma_cm"].
-func GetRdmaCharDevices(pciAddress string) []string {
-	rdmaResources := rdmamap.GetRdmaDevicesForPcidev(pciAddress)
+func (d *Discoverer) GetRdmaCharDevices(pciAddress string) []string {
+	rdmaResources := d.GetRdmaDevicesForPcidev(pciAddress)
 	rdmaDevices := make([]string, 0, len(rdmaResources))
 	for _, resource := range rdmaResources {
-		charDevs := rdmamap.GetRdmaCharDevices(resource)
+		charDevs := d.GetRdmaCharDevicesForIbdev(resource)
 		rdmaDevices = append(rdmaDevices, charDevs...)
 	}
 	return rdmaDevices
 }
 
+// GetPorts returns the ports of the RDMA device ibdev, listed under
+// <sysfs>/class/infiniband/<ibdev>/ports, with the netdev of each and the
+// umad (and, unless left out, issm) nodes bound to it.
+func (d *Discoverer) GetPorts(ibdev string) []types.RdmaPort {
+	entries, err := os.ReadDir(d.sysPath(sysInfiniband, ibdev, "ports"))
+	if err != nil {
+		return nil
+	}
+	var ports []types.RdmaPort
+	for _, e := range entries {
+		n, err := strconv.Atoi(e.Name())
+		if err != nil || n < 1 {
+			continue
+		}
+		ports = append(ports, types.RdmaPort{Number: n, IfName: d.portNetdev(ibdev, n)})
+	}
+	slices.SortFunc(ports, func(a, b types.RdmaPort) int { return a.Number - b.Number })
+
+	prefixes := []string{"umad"}
+	if slices.Contains(d.include, DeviceTypeIssm) {
+		prefixes = append(prefixes, "issm")
+	}
+	madEntries, _ := os.ReadDir(d.sysPath(sysUmad))
+	for _, e := range madEntries {
+		if !slices.ContainsFunc(prefixes, func(p string) bool { return strings.HasPrefix(e.Name(), p) }) ||
+			readSysfsAttr(d.sysPath(sysUmad, e.Name(), "ibdev")) != ibdev {
+			continue
+		}
+		port, err := strconv.Atoi(readSysfsAttr(d.sysPath(sysUmad, e.Name(), "port")))
+		if err != nil {
+			continue
+		}
+		if i := slices.IndexFunc(ports, func(p types.RdmaPort) bool { return p.Number == port }); i >= 0 {
+			ports[i].RdmaDevices = append(ports[i].RdmaDevices, hostDevPath(e.Name()))
+		}
+	}
+	return ports
+}
+
+// portNetdev returns the netdev of port n of ibdev: the one its first GID
+// is bound to (RoCE), else the netdev of the function whose dev_port
+// matches (IPoIB and Ethernet ports of multi-port functions).
+func (d *Discoverer) portNetdev(ibdev string, n int) string {
+	if name := readSysfsAttr(d.sysPath(sysInfiniband, ibdev, "ports", strconv.Itoa(n), "gid_attrs/ndevs/0")); name != "" {
+		return name
+	}
+	pciAddr, err := d.GetPciAddressForIbdev(ibdev)
+	if err != nil {
+		return ""
+	}
+	names, _ := d.GetNetNames(pciAddr)
+	for _, name := range names {
+		if devPort, err := strconv.Atoi(readSysfsAttr(d.sysPath(sysNetDevices, name, "dev_port"))); err == nil && devPort == n-1 {
+			return name
+		}
+	}
+	return ""
+}
+
 // VerifyRdmaDevices checks that all required RDMA character device types
 // (rdma_cm, umad, uverbs) are present in the given device paths.
 func VerifyRdmaDevices(charDevPaths []string) error {
-	for _, required := range types.RequiredRdmaDevices {
+	return VerifyRequiredDevices(charDevPaths, types.RequiredRdmaDevices)
+}
+
+// VerifyRequiredDevices checks that every device type in required (e.g.
+// "uverbs", "rdma_cm") is present in the given device paths. An empty
+// required list accepts any device set.
+func VerifyRequiredDevices(charDevPaths, required []string) error {
+	for _, required := range required {
 		found := false
 		for _, devPath := range charDevPaths {
 			if strings.Contains(filepath.Base(devPath), required) {
@@ -146,7 +736,7 @@ func VerifyRdmaDevices(charDevPaths []string) error {
 			}
 		}
 		if !found {
-			return fmt.Errorf("required RDMA device type %q not found", required)
+			return fmt.Errorf("%w: %q", ErrMissingRequiredDevice, required)
 		}
 	}
 	return nil
@@ -156,103 +746,367 @@ func VerifyRdmaDevices(charDevPaths []string) error {
 //  device building
 // ───────────────────────────────────────────
 
-// buildDeviceSpecs converts RDMA character device paths to DeviceSpec entries.
-func buildDeviceSpecs(charDevs []string) []types.DeviceSpec {
-	specs := make([]types.DeviceSpec, 0, len(charDevs))
-	for _, dev := range charDevs {
-		specs = append(specs, types.DeviceSpec{
-			HostPath:      dev,
-			ContainerPath: dev,
-			Permissions:   "rw",
-		})
+// charDevClass maps an RDMA char device name to its sysfs class directory.
+func charDevClass(name string) string {
+	switch {
+	case strings.HasPrefix(name, "uverbs"):
+		return sysUverbs
+	case strings.HasPrefix(name, "umad"), strings.HasPrefix(name, "issm"):
+		return sysUmad
+	case strings.HasPrefix(name, "ucm"):
+		return sysUcm
+	case name == rdmaCmName:
+		return sysMisc
+	default:
+		return ""
+	}
+}
+
+// GetCharDevNumbers returns the major:minor numbers the kernel assigned to an
+// RDMA char device (e.g. "uverbs0") by reading its sysfs "dev" attribute.
+func (d *Discoverer) GetCharDevNumbers(name string) (major, minor int64, err error) {
+	class := charDevClass(name)
+	if class == "" {
+		return 0, 0, fmt.Errorf("unknown RDMA char device class for %s", name)
+	}
+	devAttr := d.sysPath(class, name, "dev")
+	raw := readSysfsAttr(devAttr)
+	if _, err := fmt.Sscanf(raw, "%d:%d", &major, &minor); err != nil {
+		return 0, 0, fmt.Errorf("cannot parse device numbers %q from %s: %w", raw, devAttr, err)
+	}
+	return major, minor, nil
+}
+
+// withDeviceNumbers fills Type/Major/Minor and UID/GID from the stat of the
+// device node under the dev root, taking the numbers from sysfs when it is
+// not a character device (or missing). Entries that cannot be resolved are
+// left untouched.
+func (d *Discoverer) withDeviceNumbers(specs []types.DeviceSpec) []types.DeviceSpec {
+	for i := range specs {
+		name := filepath.Base(specs[i].HostPath)
+		major, minor, err := d.GetCharDevNumbers(name)
+		var st *syscall.Stat_t
+		fi, statErr := os.Stat(d.devPath(rdmaDevDir, name))
+		if statErr == nil {
+			st, _ = fi.Sys().(*syscall.Stat_t)
+		}
+		if st != nil && fi.Mode()&os.ModeCharDevice != 0 {
+			// The node itself is authoritative over sysfs
+			major, minor, err = int64(unix.Major(uint64(st.Rdev))), int64(unix.Minor(uint64(st.Rdev))), nil
+		}
+		if err != nil {
+			continue
+		}
+		specs[i].Type = "c"
+		specs[i].Major = major
+		specs[i].Minor = minor
+		if st != nil {
+			uid, gid := st.Uid, st.Gid
+			specs[i].UID = &uid
+			specs[i].GID = &gid
+		}
 	}
 	return specs
 }
 
-// buildRdmaDevice populates an RdmaDevice with metadata from sysfs and netlink.
-func buildRdmaDevice(pciAddr string, charDevs []string) *types.RdmaDevice {
+// buildRdmaDevice populates an RdmaDevice with metadata from sysfs and
+// netlink. Enrichment that fails leaves its fields empty and adds a
+// warning naming them, rather than failing the device.
+func (d *Discoverer) buildRdmaDevice(pciAddr string, charDevs []string) *types.RdmaDevice {
 	dev := &types.RdmaDevice{
 		PciAddress:  pciAddr,
 		RdmaDevices: charDevs,
-		DeviceSpecs: buildDeviceSpecs(charDevs),
-		Vendor:      GetPCIVendor(pciAddr),
-		DeviceID:    GetPCIDeviceID(pciAddr),
+		DeviceSpecs: d.withDeviceNumbers(BuildDeviceSpecs(charDevs, d.specOpts...)),
+		Vendor:      d.GetPCIVendor(pciAddr),
+		DeviceID:    d.GetPCIDeviceID(pciAddr),
+		NumaNode:    d.GetNumaNode(pciAddr),
+		Slot:        d.GetPhysicalSlot(pciAddr),
+	}
+	dev.ModelName = d.pciDB().Model(dev.Vendor, dev.DeviceID)
+	dev.NetnsMode = d.netnsMode()
+	if d.netnsErr != nil {
+		addWarning(dev, "netns_mode", d.netnsErr)
 	}
 
 	// Best-effort enrichment — errors are non-fatal
-	if names, err := GetNetNames(pciAddr); err == nil && len(names) > 0 {
+	if names, err := d.GetNetNames(pciAddr); err == nil && len(names) > 0 {
 		dev.IfName = names[0]
+		if len(names) > 1 {
+			dev.IfNames = names
+		}
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		// Functions without a netdev have no net directory at all
+		addWarning(dev, "interface", err)
 	}
-	if driver, err := GetPCIDevDriver(pciAddr); err == nil {
+	if ibdevs := d.GetRdmaDevicesForPcidev(pciAddr); len(ibdevs) > 0 {
+		dev.IbDev = ibdevs[0]
+		dev.NodeGUID = d.GetNodeGUID(dev.IbDev)
+		dev.BoardID = d.GetBoardID(dev.IbDev)
+		dev.Rate = d.GetRate(dev.IbDev)
+		if ports := d.GetPorts(dev.IbDev); len(ports) > 1 {
+			dev.Ports = ports
+		}
+		if !d.noLAGCollapse {
+			// Traffic of a LAG goes through the bond, not the function's own netdev
+			if bond := d.GetBond(dev.IbDev); bond != nil {
+				dev.Bond = bond
+				dev.IfName, dev.IfNames = bond.IfName, nil
+			}
+		}
+	}
+	if driver, err := d.GetPCIDevDriver(pciAddr); err == nil {
 		dev.Driver = driver
+	} else {
+		addWarning(dev, "driver", err)
+	}
+	if parent, idx, ok := d.GetParentPF(pciAddr); ok {
+		dev.IsVF = true
+		dev.VFIndex = idx
+		dev.ParentPFAddress = parent
+	}
+	linkType, err := d.lookupLinkType(dev.IfName)
+	if err != nil {
+		addWarning(dev, "link_type", err)
+	}
+	dev.LinkType = linkType
+	if !d.offline {
+		addrs, err := getAddresses(d.addrList, ifNamesOf(dev)...)
+		if err != nil {
+			addWarning(dev, "addresses", err)
+		}
+		dev.Addresses = addrs
+		if d.usage {
+			dev.Usage = d.GetUsage(dev)
+		}
+	}
+	host := plugin.Host{SysfsRoot: d.sysfsRoot, DevRoot: d.devRoot}
+	for _, p := range d.plugins {
+		if !p.Match(dev) {
+			continue
+		}
+		if err := p.Discover(host, dev); err != nil {
+			addWarning(dev, "plugin "+p.Name(), err)
+		}
 	}
-	dev.LinkType = GetLinkType(dev.IfName)
 
 	return dev
 }
 
+// addWarning records on dev that discovery could not fill field.
+func addWarning(dev *types.RdmaDevice, field string, err error) {
+	log.Debugf("%s: incomplete %s: %v", dev.PciAddress, field, err)
+	dev.Warnings = append(dev.Warnings, types.DiscoveryWarning{Field: field, Message: err.Error()})
+}
+
 // ───────────────────────────────────────────
 //  Discoverer methods
 // ───────────────────────────────────────────
 
 // DiscoverByPCI discovers an RdmaDevice from a PCI BDF address.
+// Short or uppercase forms (e.g. "86:00.0") are normalized first.
 func (d *Discoverer) DiscoverByPCI(pciAddress string) (*types.RdmaDevice, error) {
-	charDevs := GetRdmaCharDevices(pciAddress)
+	pciAddress, err := utils.NormalizePCIAddress(pciAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(d.sysPath(sysBusPci, pciAddress)); os.IsNotExist(err) {
+		return nil, fmt.Errorf("PCI address %s: %w", pciAddress, ErrDeviceNotFound)
+	}
+
+	charDevs := d.GetRdmaCharDevices(pciAddress)
 	if len(charDevs) == 0 {
-		return nil, fmt.Errorf("no RDMA character devices found for PCI address %s", pciAddress)
+		// A bonded member of a RoCE LAG has its RDMA device on another function
+		if !d.noLAGCollapse {
+			if owner := d.lagOwnerOf(pciAddress); owner != "" {
+				log.Infof("%s is bonded into the RoCE LAG of %s; discovering that instead", pciAddress, owner)
+				return d.DiscoverByPCI(owner)
+			}
+		}
+		return nil, fmt.Errorf("%w for PCI address %s", ErrNoRdmaDevices, pciAddress)
 	}
 
-	if err := VerifyRdmaDevices(charDevs); err != nil {
+	if err := VerifyRequiredDevices(charDevs, d.requiredDevicesFor(pciAddress)); err != nil {
 		return nil, fmt.Errorf("RDMA device verification failed for %s: %w", pciAddress, err)
 	}
 
-	return buildRdmaDevice(pciAddress, charDevs), nil
+	return d.buildRdmaDevice(pciAddress, charDevs), nil
 }
 
-// DiscoverByIfName discovers an RdmaDevice from a network interface name.
+// DiscoverByIfName discovers an RdmaDevice from a network interface name,
+// which may also be the bonding interface of a RoCE LAG.
 func (d *Discoverer) DiscoverByIfName(ifName string) (*types.RdmaDevice, error) {
-	pciAddr, err := GetPciAddress(ifName)
+	pciAddr, err := d.GetPciAddress(ifName)
 	if err != nil {
-		return nil, fmt.Errorf("cannot resolve PCI address for interface %q: %w", ifName, err)
+		owner := ""
+		if !d.noLAGCollapse {
+			owner = d.lagOwner(ifName)
+		}
+		if owner == "" {
+			return nil, fmt.Errorf("cannot resolve PCI address for interface %q: %w", ifName, err)
+		}
+		pciAddr = owner
 	}
 
 	dev, err := d.DiscoverByPCI(pciAddr)
 	if err != nil {
 		return nil, err
 	}
-	dev.IfName = ifName // prefer user-specified name
+	// The requested interface becomes the primary one, with the link type
+	// it has rather than that of the function's first interface. A LAG
+	// keeps its bond, whichever member was named.
+	if ifName != dev.IfName && dev.Bond == nil {
+		if i := slices.Index(dev.IfNames, ifName); i > 0 {
+			dev.IfNames = slices.Concat([]string{ifName}, dev.IfNames[:i], dev.IfNames[i+1:])
+		}
+		dev.IfName = ifName
+		dev.LinkType = d.linkType(ifName)
+	}
 	return dev, nil
 }
 
-// DiscoverAll enumerates all PCI devices under /sys/bus/pci/devices/ and returns
-// those that have RDMA character devices. Non-RDMA devices are silently skipped.
+// DiscoverAll enumerates all PCI devices under <sysfs>/bus/pci/devices/ (or all
+// RDMA netlink links with BackendNetlink) and returns those that have RDMA
+// character devices. Non-RDMA devices are silently skipped. If RDMA netlink
+// is unavailable to the process (e.g. rootless), sysfs is scanned instead.
+// A device whose discovery panics or outlasts the device timeout is
+// returned with only its PCI address and Error set, so one misbehaving
+// device cannot hang or crash the enumeration of the others.
 func (d *Discoverer) DiscoverAll() ([]*types.RdmaDevice, error) {
-	entries, err := os.ReadDir(sysBusPci)
+	if d.backend == BackendNetlink {
+		devices, err := d.discoverAllNetlink()
+		if err == nil || !netlinkUnavailable(err) {
+			return devices, err
+		}
+		log.Warnf("%v; falling back to sysfs discovery", err)
+	}
+
+	pciDir := d.sysPath(sysBusPci)
+	entries, err := os.ReadDir(pciDir)
 	if err != nil {
-		return nil, fmt.Errorf("cannot read PCI bus directory %s: %w", sysBusPci, err)
+		return nil, fmt.Errorf("cannot read PCI bus directory %s: %w", pciDir, err)
 	}
 
-	var devices []*types.RdmaDevice
+	var found []rdmaPCIDevice
 	for _, entry := range entries {
 		pciAddr := entry.Name()
-		charDevs := GetRdmaCharDevices(pciAddr)
-		if len(charDevs) == 0 {
+		charDevs, err := isolate(d, pciAddr, func() []string { return d.GetRdmaCharDevices(pciAddr) })
+		if err == nil && len(charDevs) == 0 {
 			continue // not an RDMA device
 		}
-		devices = append(devices, buildRdmaDevice(pciAddr, charDevs))
+		found = append(found, rdmaPCIDevice{pciAddr, charDevs, err})
 	}
+	return d.buildAll(found)
+}
+
+// rdmaPCIDevice is a PCI device found to have RDMA character devices, or
+// whose character devices could not be listed (err).
+type rdmaPCIDevice struct {
+	pciAddr  string
+	charDevs []string
+	err      error
+}
 
-	if len(devices) == 0 {
-		return nil, fmt.Errorf("no RDMA devices found on the host")
+// isolate runs fn, part of the discovery of the device at pciAddr, turning
+// a panic into an error and giving up after the device timeout. A call
+// stuck in the kernel (e.g. a sysfs read in D state) cannot be cancelled
+// and is left running.
+func isolate[T any](d *Discoverer, pciAddr string, fn func() T) (T, error) {
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- result{err: fmt.Errorf("discovery of %s panicked: %v", pciAddr, p)}
+			}
+		}()
+		done <- result{v: fn()}
+	}()
+
+	timer := time.NewTimer(d.deviceTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-timer.C:
+		var zero T
+		return zero, fmt.Errorf("discovery of %s timed out after %s", pciAddr, d.deviceTimeout)
+	}
+}
+
+// failedDevice is the RdmaDevice reported for a device whose discovery
+// failed with err.
+func failedDevice(pciAddr string, err error) *types.RdmaDevice {
+	log.Errorf("%v", err)
+	return &types.RdmaDevice{PciAddress: pciAddr, NumaNode: -1, Error: err.Error()}
+}
+
+// buildAll builds the RdmaDevice of each found device, reporting progress
+// if WithProgress was given.
+func (d *Discoverer) buildAll(found []rdmaPCIDevice) ([]*types.RdmaDevice, error) {
+	if len(found) == 0 {
+		return nil, fmt.Errorf("%w on the host", ErrNoRdmaDevices)
+	}
+	devices := make([]*types.RdmaDevice, 0, len(found))
+	for i, f := range found {
+		err := f.err
+		var dev *types.RdmaDevice
+		if err == nil {
+			dev, err = isolate(d, f.pciAddr, func() *types.RdmaDevice { return d.buildRdmaDevice(f.pciAddr, f.charDevs) })
+		}
+		if err != nil {
+			dev = failedDevice(f.pciAddr, err)
+		}
+		devices = append(devices, dev)
+		if d.progress != nil {
+			d.progress(i+1, len(found), f.pciAddr)
+		}
+	}
+	if !d.noLAGCollapse {
+		devices = collapseLAGs(devices)
 	}
 	return devices, nil
 }
 
 // ───────────────────────────────────────────
 //  Package-level convenience functions
-//  (kept for backward compatibility with M1)
+//  (kept for backward compatibility with M1;
+//  they always read the host /sys and /dev)
 // ───────────────────────────────────────────
 
+// GetPciAddress resolves the PCI address of a network interface (convenience wrapper).
+func GetPciAddress(ifName string) (string, error) {
+	return NewDiscoverer().GetPciAddress(ifName)
+}
+
+// GetNetNames lists the network interfaces of a PCI device (convenience wrapper).
+func GetNetNames(pciAddr string) ([]string, error) {
+	return NewDiscoverer().GetNetNames(pciAddr)
+}
+
+// GetPCIDevDriver returns the driver bound to a PCI device (convenience wrapper).
+func GetPCIDevDriver(pciAddr string) (string, error) {
+	return NewDiscoverer().GetPCIDevDriver(pciAddr)
+}
+
+// GetPCIVendor returns the PCI vendor ID of a device (convenience wrapper).
+func GetPCIVendor(pciAddr string) string {
+	return NewDiscoverer().GetPCIVendor(pciAddr)
+}
+
+// GetPCIDeviceID returns the PCI device ID of a device (convenience wrapper).
+func GetPCIDeviceID(pciAddr string) string {
+	return NewDiscoverer().GetPCIDeviceID(pciAddr)
+}
+
+// GetRdmaCharDevices returns the RDMA character devices of a PCI device (convenience wrapper).
+func GetRdmaCharDevices(pciAddress string) []string {
+	return NewDiscoverer().GetRdmaCharDevices(pciAddress)
+}
+
 // DiscoverDevice builds an RdmaDevice from a PCI address (convenience wrapper).
 func DiscoverDevice(pciAddress string) (*types.RdmaDevice, error) {
 	return NewDiscoverer().DiscoverByPCI(pciAddress)
@@ -1,11 +1,19 @@
 package rdma
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
 
 	"github.com/Nativu5/rdma-cdi/pkg/types"
+	"github.com/Nativu5/rdma-cdi/pkg/utils"
 )
 
 // ──────────────────────────────────────────────
@@ -34,6 +42,13 @@ func TestVerifyRdmaDevices_MissingUverbs(t *testing.T) {
 	}
 }
 
+func TestVerifyRdmaDevices_SentinelError(t *testing.T) {
+	err := VerifyRdmaDevices([]string{"/dev/infiniband/uverbs0"})
+	if !errors.Is(err, ErrMissingRequiredDevice) {
+		t.Errorf("expected ErrMissingRequiredDevice, got: %v", err)
+	}
+}
+
 func TestVerifyRdmaDevices_MissingRdmaCm(t *testing.T) {
 	charDevs := []string{
 		"/dev/infiniband/umad0",
@@ -66,31 +81,36 @@ func TestVerifyRdmaDevices_Multiple(t *testing.T) {
 	}
 }
 
-// ──────────────────────────────────────────────
-//  buildDeviceSpecs (unexported)
-// ──────────────────────────────────────────────
-
-func TestBuildDeviceSpecs(t *testing.T) {
-	charDevs := []string{"/dev/infiniband/uverbs0", "/dev/infiniband/rdma_cm"}
-	specs := buildDeviceSpecs(charDevs)
+func TestVerifyRequiredDevices(t *testing.T) {
+	roce := []string{"/dev/infiniband/rdma_cm", "/dev/infiniband/uverbs0"}
 
-	if len(specs) != 2 {
-		t.Fatalf("expected 2 specs, got %d", len(specs))
+	if err := VerifyRequiredDevices(roce, []string{"uverbs", "rdma_cm"}); err != nil {
+		t.Errorf("relaxed policy should accept RoCE-only set, got: %v", err)
 	}
-	for _, s := range specs {
-		if s.HostPath != s.ContainerPath {
-			t.Errorf("HostPath (%s) != ContainerPath (%s)", s.HostPath, s.ContainerPath)
-		}
-		if s.Permissions != "rw" {
-			t.Errorf("expected permissions 'rw', got %q", s.Permissions)
-		}
+	if err := VerifyRequiredDevices(nil, nil); err != nil {
+		t.Errorf("empty policy should accept anything, got: %v", err)
+	}
+	err := VerifyRequiredDevices(roce, []string{"umad"})
+	if !errors.Is(err, ErrMissingRequiredDevice) || !strings.Contains(err.Error(), "umad") {
+		t.Errorf("expected missing umad error, got: %v", err)
 	}
 }
 
-func TestBuildDeviceSpecs_Empty(t *testing.T) {
-	specs := buildDeviceSpecs(nil)
-	if len(specs) != 0 {
-		t.Errorf("expected empty specs, got %d", len(specs))
+func TestDiscoverByPCI_RequiredDevices(t *testing.T) {
+	sysRoot, devRoot := seedRdmaSysfs(t)
+	// Drop umad0 so the device looks RoCE-only
+	if err := os.RemoveAll(filepath.Join(sysRoot, sysUmad)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot)).DiscoverByPCI("0000:17:00.0")
+	if !errors.Is(err, ErrMissingRequiredDevice) {
+		t.Fatalf("default policy: expected ErrMissingRequiredDevice, got %v", err)
+	}
+
+	d := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot), WithRequiredDevices("uverbs", "rdma_cm"))
+	if _, err := d.DiscoverByPCI("0000:17:00.0"); err != nil {
+		t.Errorf("relaxed policy: unexpected error %v", err)
 	}
 }
 
@@ -137,48 +157,105 @@ func TestReadSysfsAttr_NotExist(t *testing.T) {
 // ──────────────────────────────────────────────
 
 func TestGetPCIVendor_FakeSysfs(t *testing.T) {
-	origSysBusPci := sysBusPci
-	defer func() { sysBusPci = origSysBusPci }()
-
-	dir := t.TempDir()
-	pciDir := filepath.Join(dir, "0000:17:00.0")
+	root := t.TempDir()
+	pciDir := filepath.Join(root, sysBusPci, "0000:17:00.0")
 	os.MkdirAll(pciDir, 0755)
 	os.WriteFile(filepath.Join(pciDir, "vendor"), []byte("0x15b3\n"), 0644)
 	os.WriteFile(filepath.Join(pciDir, "device"), []byte("0x1017\n"), 0644)
 
-	sysBusPci = dir
+	d := NewDiscoverer(WithSysfsRoot(root))
 
-	vendor := GetPCIVendor("0000:17:00.0")
+	vendor := d.GetPCIVendor("0000:17:00.0")
 	if vendor != "15b3" {
 		t.Errorf("expected vendor '15b3', got %q", vendor)
 	}
 
-	deviceID := GetPCIDeviceID("0000:17:00.0")
+	deviceID := d.GetPCIDeviceID("0000:17:00.0")
 	if deviceID != "1017" {
 		t.Errorf("expected device ID '1017', got %q", deviceID)
 	}
 }
 
+func TestGetSriovVFs_FakeSysfs(t *testing.T) {
+	root := t.TempDir()
+	pciDir := filepath.Join(root, sysBusPci, "0000:17:00.0")
+	os.MkdirAll(pciDir, 0755)
+	os.WriteFile(filepath.Join(pciDir, "sriov_numvfs"), []byte("4\n"), 0644)
+	os.WriteFile(filepath.Join(pciDir, "sriov_totalvfs"), []byte("16\n"), 0644)
+	os.MkdirAll(filepath.Join(root, sysBusPci, "0000:41:00.0"), 0755)
+
+	d := NewDiscoverer(WithSysfsRoot(root))
+	if num, total, err := d.GetSriovVFs("0000:17:00.0"); err != nil || num != 4 || total != 16 {
+		t.Errorf("GetSriovVFs() = %d, %d, %v, want 4, 16", num, total, err)
+	}
+	if _, _, err := d.GetSriovVFs("0000:41:00.0"); err == nil {
+		t.Error("GetSriovVFs() should fail for a device without SR-IOV")
+	}
+}
+
+func TestGetNodeGUID_FakeSysfs(t *testing.T) {
+	root := t.TempDir()
+	d := NewDiscoverer(WithSysfsRoot(root))
+	for guid, want := range map[string]string{
+		"0c42:a103:0065:4f3a\n": "0c42:a103:0065:4f3a",
+		"0000:0000:0000:0000\n": "",
+		"REDACTED\n":            "",
+	} {
+		dir := filepath.Join(root, sysInfiniband, "mlx5_0")
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(filepath.Join(dir, "node_guid"), []byte(guid), 0644)
+		if got := d.GetNodeGUID("mlx5_0"); got != want {
+			t.Errorf("GetNodeGUID() with %q = %q, want %q", guid, got, want)
+		}
+	}
+	if got := d.GetNodeGUID("mlx5_9"); got != "" {
+		t.Errorf("GetNodeGUID() of a missing device = %q", got)
+	}
+}
+
+func TestGetPhysicalSlot_FakeSysfs(t *testing.T) {
+	root := t.TempDir()
+	// A card with its own PCIe switch: the slot lists the switch's
+	// upstream port, not the function
+	real := filepath.Join(root, "devices", "pci0000:16", "0000:16:02.0", "0000:17:00.0", "0000:18:00.0", "0000:19:00.1")
+	os.MkdirAll(real, 0755)
+	os.MkdirAll(filepath.Join(root, sysBusPci), 0755)
+	os.Symlink(real, filepath.Join(root, sysBusPci, "0000:19:00.1"))
+	os.MkdirAll(filepath.Join(root, sysBusPci, "0000:41:00.0"), 0755)
+	for label, addr := range map[string]string{"PCIe-Slot-2": "0000:17:00\n", "7": "0000:5e:00\n"} {
+		os.MkdirAll(filepath.Join(root, sysPciSlots, label), 0755)
+		os.WriteFile(filepath.Join(root, sysPciSlots, label, "address"), []byte(addr), 0644)
+	}
+
+	d := NewDiscoverer(WithSysfsRoot(root))
+	if got := d.GetPhysicalSlot("0000:19:00.1"); got != "PCIe-Slot-2" {
+		t.Errorf("GetPhysicalSlot() behind a switch = %q, want PCIe-Slot-2", got)
+	}
+	if got := d.GetPhysicalSlot("0000:41:00.0"); got != "" {
+		t.Errorf("GetPhysicalSlot() of a device in no slot = %q", got)
+	}
+	if got := NewDiscoverer(WithSysfsRoot(t.TempDir())).GetPhysicalSlot("0000:19:00.1"); got != "" {
+		t.Errorf("GetPhysicalSlot() without slots = %q", got)
+	}
+}
+
 // ──────────────────────────────────────────────
 //  GetNetNames with fake sysfs
 // ──────────────────────────────────────────────
 
 func TestGetNetNames_FakeSysfs(t *testing.T) {
-	origSysBusPci := sysBusPci
-	defer func() { sysBusPci = origSysBusPci }()
-
-	dir := t.TempDir()
+	root := t.TempDir()
 	// Simulate a ConnectX-5 PF with one net interface
-	pciDir := filepath.Join(dir, "0000:17:00.0", "net", "enp23s0f0np0")
+	pciDir := filepath.Join(root, sysBusPci, "0000:17:00.0", "net", "enp23s0f0np0")
 	os.MkdirAll(pciDir, 0755)
 
 	// Simulate a second PF on a different bus
-	pciDir2 := filepath.Join(dir, "0000:41:00.0", "net", "enp65s0np0")
+	pciDir2 := filepath.Join(root, sysBusPci, "0000:41:00.0", "net", "enp65s0np0")
 	os.MkdirAll(pciDir2, 0755)
 
-	sysBusPci = dir
+	d := NewDiscoverer(WithSysfsRoot(root))
 
-	names, err := GetNetNames("0000:17:00.0")
+	names, err := d.GetNetNames("0000:17:00.0")
 	if err != nil {
 		t.Fatalf("GetNetNames failed: %v", err)
 	}
@@ -191,13 +268,321 @@ func TestGetNetNames_FakeSysfs(t *testing.T) {
 }
 
 func TestGetNetNames_NoPciDevice(t *testing.T) {
-	origSysBusPci := sysBusPci
-	defer func() { sysBusPci = origSysBusPci }()
+	d := NewDiscoverer(WithSysfsRoot(t.TempDir()))
 
-	sysBusPci = t.TempDir()
-
-	_, err := GetNetNames("0000:ff:ff.0")
+	_, err := d.GetNetNames("0000:ff:ff.0")
 	if err == nil {
 		t.Error("expected error for non-existent PCI device")
 	}
 }
+
+// ──────────────────────────────────────────────
+//  Discoverer options and char device discovery
+// ──────────────────────────────────────────────
+
+func TestNewDiscoverer_Defaults(t *testing.T) {
+	d := NewDiscoverer()
+	if d.SysfsRoot() != DefaultSysfsRoot {
+		t.Errorf("SysfsRoot() = %q, want %q", d.SysfsRoot(), DefaultSysfsRoot)
+	}
+	if d.DevRoot() != DefaultDevRoot {
+		t.Errorf("DevRoot() = %q, want %q", d.DevRoot(), DefaultDevRoot)
+	}
+}
+
+func TestNewDiscoverer_Options(t *testing.T) {
+	d := NewDiscoverer(WithSysfsRoot("/host/sys"), WithDevRoot("/host/dev"))
+	if d.SysfsRoot() != "/host/sys" {
+		t.Errorf("SysfsRoot() = %q, want /host/sys", d.SysfsRoot())
+	}
+	if d.DevRoot() != "/host/dev" {
+		t.Errorf("DevRoot() = %q, want /host/dev", d.DevRoot())
+	}
+}
+
+// seedRdmaSysfs builds a minimal sysfs + /dev tree for one ConnectX PF
+// (0000:17:00.0 → mlx5_0) and one non-RDMA PCI device.
+func seedRdmaSysfs(t *testing.T) (sysRoot, devRoot string) {
+	t.Helper()
+	sysRoot = t.TempDir()
+	devRoot = t.TempDir()
+
+	mkdir := func(p string) {
+		if err := os.MkdirAll(p, 0755); err != nil {
+			t.Fatalf("mkdir %s: %v", p, err)
+		}
+	}
+	write := func(p, v string) {
+		if err := os.WriteFile(p, []byte(v), 0644); err != nil {
+			t.Fatalf("write %s: %v", p, err)
+		}
+	}
+
+	pf := filepath.Join(sysRoot, sysBusPci, "0000:17:00.0")
+	mkdir(filepath.Join(pf, "infiniband", "mlx5_0"))
+	mkdir(filepath.Join(pf, "net", "enp23s0f0np0"))
+	write(filepath.Join(pf, "vendor"), "0x15b3\n")
+	write(filepath.Join(pf, "device"), "0x1017\n")
+	mkdir(filepath.Join(sysRoot, sysBusPci, "0000:00:1f.0")) // non-RDMA
+
+	for class, entries := range map[string]map[string]string{
+		sysUverbs: {"uverbs0": "mlx5_0", "uverbs1": "mlx5_1"},
+		sysUmad:   {"umad0": "mlx5_0", "umad1": "mlx5_1", "issm0": "mlx5_0"},
+	} {
+		for name, ibdev := range entries {
+			dir := filepath.Join(sysRoot, class, name)
+			mkdir(dir)
+			write(filepath.Join(dir, "ibdev"), ibdev+"\n")
+		}
+	}
+
+	mkdir(filepath.Join(devRoot, "infiniband"))
+	write(filepath.Join(devRoot, "infiniband", "rdma_cm"), "")
+	return sysRoot, devRoot
+}
+
+func TestGetRdmaCharDevices_FakeSysfs(t *testing.T) {
+	sysRoot, devRoot := seedRdmaSysfs(t)
+	d := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot))
+
+	got := d.GetRdmaCharDevices("0000:17:00.0")
+	want := map[string]bool{
+		"/dev/infiniband/uverbs0": true,
+		"/dev/infiniband/umad0":   true,
+		"/dev/infiniband/issm0":   true,
+		"/dev/infiniband/rdma_cm": true,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d char devices, got %d: %v", len(want), len(got), got)
+	}
+	for _, p := range got {
+		if !want[p] {
+			t.Errorf("unexpected char device %q", p)
+		}
+	}
+}
+
+func TestGetRdmaCharDevices_NoRdmaCmUnderDevRoot(t *testing.T) {
+	sysRoot, _ := seedRdmaSysfs(t)
+	d := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(t.TempDir()))
+
+	for _, p := range d.GetRdmaCharDevices("0000:17:00.0") {
+		if filepath.Base(p) == "rdma_cm" {
+			t.Errorf("rdma_cm reported although it is absent under the dev root")
+		}
+	}
+}
+
+func TestDiscoverByPCI_FakeSysfs(t *testing.T) {
+	sysRoot, devRoot := seedRdmaSysfs(t)
+	d := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot))
+
+	dev, err := d.DiscoverByPCI("0000:17:00.0")
+	if err != nil {
+		t.Fatalf("DiscoverByPCI failed: %v", err)
+	}
+	if dev.Vendor != "15b3" || dev.DeviceID != "1017" {
+		t.Errorf("unexpected PCI IDs %s:%s", dev.Vendor, dev.DeviceID)
+	}
+	if dev.IfName != "enp23s0f0np0" {
+		t.Errorf("IfName = %q, want enp23s0f0np0", dev.IfName)
+	}
+	if len(dev.DeviceSpecs) != 4 {
+		t.Errorf("expected 4 device specs, got %d", len(dev.DeviceSpecs))
+	}
+}
+
+func TestDiscoverByPCI_OfflineLinkType(t *testing.T) {
+	sysRoot, devRoot := seedRdmaSysfs(t)
+	typeAttr := filepath.Join(sysRoot, "class", "net", "enp23s0f0np0", "type")
+	if err := os.MkdirAll(filepath.Dir(typeAttr), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(typeAttr, []byte("32\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dev, err := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot), WithOffline()).DiscoverByPCI("0000:17:00.0")
+	if err != nil {
+		t.Fatalf("DiscoverByPCI failed: %v", err)
+	}
+	if dev.LinkType != "infiniband" {
+		t.Errorf("LinkType = %q, want infiniband from sysfs", dev.LinkType)
+	}
+}
+
+func TestDiscoverByPCI_Addresses(t *testing.T) {
+	sysRoot, devRoot := seedRdmaSysfs(t)
+	addrList := func(ifName string) ([]netlink.Addr, error) {
+		if ifName != "enp23s0f0np0" {
+			return nil, fmt.Errorf("no such interface %s", ifName)
+		}
+		v4, _ := netlink.ParseAddr("192.0.2.10/24")
+		v6, _ := netlink.ParseAddr("2001:db8::10/64")
+		return []netlink.Addr{*v4, *v6}, nil
+	}
+
+	dev, err := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot), withAddrList(addrList)).DiscoverByPCI("0000:17:00.0")
+	if err != nil {
+		t.Fatalf("DiscoverByPCI failed: %v", err)
+	}
+	if want := []string{"192.0.2.10/24", "2001:db8::10/64"}; !slices.Equal(dev.Addresses, want) {
+		t.Errorf("Addresses = %v, want %v", dev.Addresses, want)
+	}
+
+	// Offline there is no netlink to ask
+	dev, err = NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot), WithOffline(), withAddrList(addrList)).DiscoverByPCI("0000:17:00.0")
+	if err != nil {
+		t.Fatalf("DiscoverByPCI failed: %v", err)
+	}
+	if dev.Addresses != nil {
+		t.Errorf("offline Addresses = %v, want none", dev.Addresses)
+	}
+}
+
+func TestDiscoverAll_IsolatesFailingDevice(t *testing.T) {
+	sysRoot, devRoot := seedRdmaSysfs(t)
+	// The hung discovery is left running by design; release it when done
+	hung := make(chan struct{})
+	t.Cleanup(func() { close(hung) })
+
+	tests := []struct {
+		name    string
+		addrs   addrLister
+		wantErr string
+	}{
+		{"panic", func(string) ([]netlink.Addr, error) { panic("boom") }, "panicked: boom"},
+		{"hang", func(string) ([]netlink.Addr, error) { <-hung; return nil, nil }, "timed out after 50ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devices, err := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot),
+				WithDeviceTimeout(50*time.Millisecond), withAddrList(tt.addrs)).DiscoverAll()
+			if err != nil {
+				t.Fatalf("DiscoverAll should report the device, not fail: %v", err)
+			}
+			if len(devices) != 1 || devices[0].PciAddress != "0000:17:00.0" ||
+				!strings.Contains(devices[0].Error, tt.wantErr) {
+				t.Fatalf("expected 0000:17:00.0 with error %q, got %+v", tt.wantErr, devices)
+			}
+			if devices[0].IfName != "" || devices[0].RdmaDevices != nil {
+				t.Errorf("a failed device should carry no partial data, got %+v", devices[0])
+			}
+		})
+	}
+}
+
+func TestDiscoverByPCI_Warnings(t *testing.T) {
+	// The seeded function has no driver symlink; offline, nothing else is
+	// left to fail
+	sysRoot, devRoot := seedRdmaSysfs(t)
+	dev, err := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot), WithOffline()).DiscoverByPCI("0000:17:00.0")
+	if err != nil {
+		t.Fatalf("DiscoverByPCI should degrade, not fail: %v", err)
+	}
+	if dev.Driver != "" || len(dev.Warnings) != 1 || dev.Warnings[0].Field != "driver" {
+		t.Errorf("expected one driver warning, got driver %q, warnings %+v", dev.Driver, dev.Warnings)
+	}
+	if dev.IfName == "" {
+		t.Error("the rest of the device should still be discovered")
+	}
+}
+
+func TestDiscoverAll_FakeSysfs(t *testing.T) {
+	sysRoot, devRoot := seedRdmaSysfs(t)
+	d := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot))
+
+	devices, err := d.DiscoverAll()
+	if err != nil {
+		t.Fatalf("DiscoverAll failed: %v", err)
+	}
+	if len(devices) != 1 || devices[0].PciAddress != "0000:17:00.0" {
+		t.Errorf("expected only 0000:17:00.0, got %v", devices)
+	}
+}
+
+func TestDiscoverAll_Progress(t *testing.T) {
+	sysRoot, devRoot := seedRdmaSysfs(t)
+	var calls []string
+	d := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot), WithProgress(func(done, total int, pciAddress string) {
+		calls = append(calls, fmt.Sprintf("%d/%d %s", done, total, pciAddress))
+	}))
+
+	if _, err := d.DiscoverAll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || calls[0] != "1/1 0000:17:00.0" {
+		t.Errorf("progress calls = %v, want [1/1 0000:17:00.0]", calls)
+	}
+}
+
+func TestDiscoverByPCI_SentinelErrors(t *testing.T) {
+	sysRoot, devRoot := seedRdmaSysfs(t)
+	d := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot))
+
+	if _, err := d.DiscoverByPCI("0000:ff:00.0"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("missing PCI device: expected ErrDeviceNotFound, got %v", err)
+	}
+	if _, err := d.DiscoverByPCI("0000:00:1f.0"); !errors.Is(err, ErrNoRdmaDevices) {
+		t.Errorf("non-RDMA PCI device: expected ErrNoRdmaDevices, got %v", err)
+	}
+	if _, err := d.DiscoverByIfName("nosuch0"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("missing interface: expected ErrDeviceNotFound, got %v", err)
+	}
+
+	// Without rdma_cm the device exists but is incomplete
+	d = NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(t.TempDir()))
+	if _, err := d.DiscoverByPCI("0000:17:00.0"); !errors.Is(err, ErrMissingRequiredDevice) {
+		t.Errorf("incomplete device: expected ErrMissingRequiredDevice, got %v", err)
+	}
+}
+
+func TestDiscoverAll_NoDevices(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, sysBusPci, "0000:00:1f.0"), 0755)
+	d := NewDiscoverer(WithSysfsRoot(root))
+
+	if _, err := d.DiscoverAll(); !errors.Is(err, ErrNoRdmaDevices) {
+		t.Errorf("expected ErrNoRdmaDevices, got %v", err)
+	}
+}
+
+func TestDiscoverByPCI_NormalizesAddress(t *testing.T) {
+	sysRoot, devRoot := seedRdmaSysfs(t)
+	d := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot))
+
+	dev, err := d.DiscoverByPCI(" 17:00.0 ")
+	if err != nil {
+		t.Fatalf("DiscoverByPCI with short form failed: %v", err)
+	}
+	if dev.PciAddress != "0000:17:00.0" {
+		t.Errorf("PciAddress = %q, want 0000:17:00.0", dev.PciAddress)
+	}
+
+	if _, err := d.DiscoverByPCI("not-a-bdf"); !errors.Is(err, utils.ErrInvalidPCIAddress) {
+		t.Errorf("expected ErrInvalidPCIAddress, got %v", err)
+	}
+}
+
+func TestGetCharDevNumbers(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, sysUverbs, "uverbs0")
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "dev"), []byte("231:192\n"), 0644)
+	d := NewDiscoverer(WithSysfsRoot(root))
+
+	major, minor, err := d.GetCharDevNumbers("uverbs0")
+	if err != nil {
+		t.Fatalf("GetCharDevNumbers failed: %v", err)
+	}
+	if major != 231 || minor != 192 {
+		t.Errorf("got %d:%d, want 231:192", major, minor)
+	}
+
+	if _, _, err := d.GetCharDevNumbers("umad0"); err == nil {
+		t.Error("expected error when the dev attribute is missing")
+	}
+	if _, _, err := d.GetCharDevNumbers("bogus0"); err == nil {
+		t.Error("expected error for unknown char device class")
+	}
+}
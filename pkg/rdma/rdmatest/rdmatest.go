@@ -0,0 +1,302 @@
+// Package rdmatest provides test doubles for code that performs RDMA device
+// discovery: an in-memory FakeDiscoverer, and a loader that materializes a
+// fake sysfs and /dev tree from a YAML fixture so the real rdma.Discoverer
+// can be exercised without hardware.
+package rdmatest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/Nativu5/rdma-cdi/pkg/rdma"
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+	"github.com/Nativu5/rdma-cdi/pkg/utils"
+)
+
+// ───────────────────────────────────────────
+//  FakeDiscoverer
+// ───────────────────────────────────────────
+
+// FakeDiscoverer is an in-memory types.RdmaDeviceDiscoverer.
+type FakeDiscoverer struct {
+	// Devices is the set of devices the fake reports.
+	Devices []*types.RdmaDevice
+	// Err, when non-nil, is returned by every method.
+	Err error
+}
+
+// NewFakeDiscoverer returns a FakeDiscoverer reporting devices.
+func NewFakeDiscoverer(devices ...*types.RdmaDevice) *FakeDiscoverer {
+	return &FakeDiscoverer{Devices: devices}
+}
+
+// DiscoverByPCI returns the device with the given PCI address.
+func (f *FakeDiscoverer) DiscoverByPCI(pciAddress string) (*types.RdmaDevice, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	pciAddress, err := utils.NormalizePCIAddress(pciAddress)
+	if err != nil {
+		return nil, err
+	}
+	for _, dev := range f.Devices {
+		if dev.PciAddress == pciAddress {
+			return dev.Clone(), nil
+		}
+	}
+	return nil, fmt.Errorf("PCI address %s: %w", pciAddress, rdma.ErrDeviceNotFound)
+}
+
+// DiscoverByIfName returns the device whose IfName matches.
+func (f *FakeDiscoverer) DiscoverByIfName(ifName string) (*types.RdmaDevice, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	for _, dev := range f.Devices {
+		if dev.IfName == ifName {
+			return dev.Clone(), nil
+		}
+	}
+	return nil, fmt.Errorf("network interface %q: %w", ifName, rdma.ErrDeviceNotFound)
+}
+
+// DiscoverAll returns all devices, or rdma.ErrNoRdmaDevices if there are none.
+func (f *FakeDiscoverer) DiscoverAll() ([]*types.RdmaDevice, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	if len(f.Devices) == 0 {
+		return nil, fmt.Errorf("%w on the host", rdma.ErrNoRdmaDevices)
+	}
+	out := make([]*types.RdmaDevice, 0, len(f.Devices))
+	for _, dev := range f.Devices {
+		out = append(out, dev.Clone())
+	}
+	return out, nil
+}
+
+// ───────────────────────────────────────────
+//  sysfs fixtures
+// ───────────────────────────────────────────
+
+// Fixture describes a host's RDMA hardware as seen through sysfs.
+type Fixture struct {
+	// Devices lists the physical functions; VFs are nested under them.
+	Devices []PCIDevice `json:"devices"`
+	// RdmaCM creates /dev/infiniband/rdma_cm when true.
+	RdmaCM bool `json:"rdmaCm"`
+	// Bonds are bonding interfaces over the devices' netdevs.
+	Bonds []Bond `json:"bonds,omitempty"`
+}
+
+// Bond is a bonding interface in a Fixture. With Ibdev set it is a RoCE
+// LAG: the RDMA device Ibdev binds its GIDs to the bond.
+type Bond struct {
+	Name   string   `json:"name"`
+	Slaves []string `json:"slaves"`
+	Ibdev  string   `json:"ibdev,omitempty"`
+}
+
+// PCIDevice is one PCI function in a Fixture.
+type PCIDevice struct {
+	PCI      string `json:"pci"`
+	Vendor   string `json:"vendor,omitempty"`
+	Device   string `json:"device,omitempty"`
+	Driver   string `json:"driver,omitempty"`
+	NumaNode *int   `json:"numaNode,omitempty"`
+	// Slot is the label of the physical slot holding a PF; a slot of that
+	// name listing the PF's address is created when set.
+	Slot    string      `json:"slot,omitempty"`
+	Netdevs []string    `json:"netdevs,omitempty"`
+	Ibdevs  []Ibdev     `json:"ibdevs,omitempty"`
+	VFs     []PCIDevice `json:"vfs,omitempty"`
+}
+
+// Ibdev is an RDMA device registered on a PCI function, with its
+// character devices (e.g. "uverbs0", "umad0").
+type Ibdev struct {
+	Name   string   `json:"name"`
+	Uverbs []string `json:"uverbs,omitempty"`
+	Umad   []string `json:"umad,omitempty"`
+	Issm   []string `json:"issm,omitempty"`
+	Ucm    []string `json:"ucm,omitempty"`
+	// Ports is the number of ports to create; umad and netdev i of the
+	// function belong to port i+1.
+	Ports int `json:"ports,omitempty"`
+	// NodeGUID is written to the node_guid attribute when set.
+	NodeGUID string `json:"nodeGuid,omitempty"`
+	// BoardID is written to the board_id attribute when set.
+	BoardID string `json:"boardId,omitempty"`
+	// Rate is written to the rate attribute of port 1 when set.
+	Rate string `json:"rate,omitempty"`
+}
+
+// Tree is a materialized fixture.
+type Tree struct {
+	SysfsRoot string
+	DevRoot   string
+}
+
+// Discoverer returns an rdma.Discoverer rooted at the tree.
+func (t Tree) Discoverer(opts ...rdma.Option) *rdma.Discoverer {
+	return rdma.NewDiscoverer(append([]rdma.Option{rdma.WithSysfsRoot(t.SysfsRoot), rdma.WithDevRoot(t.DevRoot)}, opts...)...)
+}
+
+// LoadFixture parses a YAML (or JSON) fixture file.
+func LoadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read fixture %s: %w", path, err)
+	}
+	var fx Fixture
+	if err := yaml.UnmarshalStrict(data, &fx); err != nil {
+		return nil, fmt.Errorf("cannot parse fixture %s: %w", path, err)
+	}
+	return &fx, nil
+}
+
+// BuildTree materializes fx under dir as <dir>/sys and <dir>/dev.
+func BuildTree(dir string, fx *Fixture) (Tree, error) {
+	t := Tree{SysfsRoot: filepath.Join(dir, "sys"), DevRoot: filepath.Join(dir, "dev")}
+	b := &builder{tree: t}
+	b.minor = 192
+	for _, pf := range fx.Devices {
+		b.pciDevice(pf, "")
+		for i, vf := range pf.VFs {
+			b.pciDevice(vf, pf.PCI)
+			b.symlink(filepath.Join("..", vf.PCI), t.SysfsRoot, "bus/pci/devices", pf.PCI, fmt.Sprintf("virtfn%d", i))
+		}
+	}
+	for _, bond := range fx.Bonds {
+		b.file(strings.Join(bond.Slaves, " ")+"\n", t.SysfsRoot, "class/net", bond.Name, "bonding/slaves")
+		for _, slave := range bond.Slaves {
+			b.symlink(filepath.Join("..", bond.Name), t.SysfsRoot, "class/net", slave, "master")
+		}
+		if bond.Ibdev != "" {
+			b.file(bond.Name+"\n", t.SysfsRoot, "class/infiniband", bond.Ibdev, "ports/1/gid_attrs/ndevs/0")
+		}
+	}
+	if fx.RdmaCM {
+		b.file("10:58\n", t.SysfsRoot, "class/misc/rdma_cm/dev")
+		b.file("", t.DevRoot, "infiniband", "rdma_cm")
+	}
+	return t, b.err
+}
+
+// MustBuildTree loads the fixture at path and materializes it in a
+// per-test temporary directory, failing the test on error.
+func MustBuildTree(tb testing.TB, path string) Tree {
+	tb.Helper()
+	fx, err := LoadFixture(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	t, err := BuildTree(tb.TempDir(), fx)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return t
+}
+
+// builder accumulates the first error while creating the tree.
+type builder struct {
+	tree  Tree
+	minor int // next minor number handed out to an RDMA char device
+	err   error
+}
+
+func (b *builder) pciDevice(dev PCIDevice, physfn string) {
+	sys := b.tree.SysfsRoot
+	b.mkdir(sys, "bus/pci/devices", dev.PCI)
+	if dev.Vendor != "" {
+		b.file("0x"+dev.Vendor+"\n", sys, "bus/pci/devices", dev.PCI, "vendor")
+	}
+	if dev.Device != "" {
+		b.file("0x"+dev.Device+"\n", sys, "bus/pci/devices", dev.PCI, "device")
+	}
+	if dev.Driver != "" {
+		b.mkdir(sys, "bus/pci/drivers", dev.Driver)
+		b.symlink(filepath.Join("..", "..", "..", "bus/pci/drivers", dev.Driver), sys, "bus/pci/devices", dev.PCI, "driver")
+	}
+	if dev.NumaNode != nil {
+		b.file(fmt.Sprintf("%d\n", *dev.NumaNode), sys, "bus/pci/devices", dev.PCI, "numa_node")
+	}
+	if dev.Slot != "" {
+		b.file(dev.PCI[:strings.LastIndex(dev.PCI, ".")]+"\n", sys, "bus/pci/slots", dev.Slot, "address")
+	}
+	if physfn != "" {
+		b.symlink(filepath.Join("..", physfn), sys, "bus/pci/devices", dev.PCI, "physfn")
+	}
+	for i, n := range dev.Netdevs {
+		b.mkdir(sys, "bus/pci/devices", dev.PCI, "net", n)
+		b.file(fmt.Sprintf("%d\n", i), sys, "class/net", n, "dev_port")
+		b.symlink(filepath.Join("..", "..", "..", "bus/pci/devices", dev.PCI), sys, "class/net", n, "device")
+	}
+	for _, ib := range dev.Ibdevs {
+		b.mkdir(sys, "bus/pci/devices", dev.PCI, "infiniband", ib.Name)
+		b.mkdir(sys, "class/infiniband", ib.Name)
+		b.symlink(filepath.Join("..", "..", "..", "bus/pci/devices", dev.PCI), sys, "class/infiniband", ib.Name, "device")
+		if ib.NodeGUID != "" {
+			b.file(ib.NodeGUID+"\n", sys, "class/infiniband", ib.Name, "node_guid")
+		}
+		if ib.BoardID != "" {
+			b.file(ib.BoardID+"\n", sys, "class/infiniband", ib.Name, "board_id")
+		}
+		if ib.Rate != "" {
+			b.file(ib.Rate+"\n", sys, "class/infiniband", ib.Name, "ports/1/rate")
+		}
+		for _, c := range ib.Uverbs {
+			b.charDev("class/infiniband_verbs", c, ib.Name)
+		}
+		for p := 1; p <= ib.Ports; p++ {
+			b.mkdir(sys, "class/infiniband", ib.Name, "ports", fmt.Sprint(p))
+		}
+		for i, c := range ib.Umad {
+			b.charDev("class/infiniband_mad", c, ib.Name)
+			b.file(fmt.Sprintf("%d\n", i+1), sys, "class/infiniband_mad", c, "port")
+		}
+		for i, c := range ib.Issm {
+			b.charDev("class/infiniband_mad", c, ib.Name)
+			b.file(fmt.Sprintf("%d\n", i+1), sys, "class/infiniband_mad", c, "port")
+		}
+		for _, c := range ib.Ucm {
+			b.charDev("class/infiniband_cm", c, ib.Name)
+		}
+	}
+}
+
+// charDev registers a char device in its sysfs class and creates its /dev
+// node. Device numbers use the infiniband major (231) with sequential minors.
+func (b *builder) charDev(class, name, ibdev string) {
+	b.file(ibdev+"\n", b.tree.SysfsRoot, class, name, "ibdev")
+	b.file(fmt.Sprintf("231:%d\n", b.minor), b.tree.SysfsRoot, class, name, "dev")
+	b.minor++
+	b.file("", b.tree.DevRoot, "infiniband", name)
+}
+
+func (b *builder) mkdir(elem ...string) {
+	if b.err == nil {
+		b.err = os.MkdirAll(filepath.Join(elem...), 0755)
+	}
+}
+
+func (b *builder) file(content string, elem ...string) {
+	p := filepath.Join(elem...)
+	b.mkdir(filepath.Dir(p))
+	if b.err == nil {
+		b.err = os.WriteFile(p, []byte(content), 0644)
+	}
+}
+
+func (b *builder) symlink(target string, elem ...string) {
+	p := filepath.Join(elem...)
+	b.mkdir(filepath.Dir(p))
+	if b.err == nil {
+		b.err = os.Symlink(target, p)
+	}
+}
@@ -0,0 +1,309 @@
+package rdmatest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/Nativu5/rdma-cdi/pkg/rdma"
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// FakeDiscoverer must satisfy the discoverer interface.
+var _ types.RdmaDeviceDiscoverer = (*FakeDiscoverer)(nil)
+
+func TestFakeDiscoverer(t *testing.T) {
+	f := NewFakeDiscoverer(
+		&types.RdmaDevice{PciAddress: "0000:17:00.0", IfName: "enp23s0f0np0"},
+		&types.RdmaDevice{PciAddress: "0000:41:00.0", IfName: "enp65s0np0"},
+	)
+
+	dev, err := f.DiscoverByPCI("17:00.0")
+	if err != nil || dev.IfName != "enp23s0f0np0" {
+		t.Errorf("DiscoverByPCI = %v, %v", dev, err)
+	}
+	dev, err = f.DiscoverByIfName("enp65s0np0")
+	if err != nil || dev.PciAddress != "0000:41:00.0" {
+		t.Errorf("DiscoverByIfName = %v, %v", dev, err)
+	}
+	if _, err := f.DiscoverByPCI("0000:ff:00.0"); !errors.Is(err, rdma.ErrDeviceNotFound) {
+		t.Errorf("expected ErrDeviceNotFound, got %v", err)
+	}
+	all, err := f.DiscoverAll()
+	if err != nil || len(all) != 2 {
+		t.Errorf("DiscoverAll = %v, %v", all, err)
+	}
+
+	if _, err := NewFakeDiscoverer().DiscoverAll(); !errors.Is(err, rdma.ErrNoRdmaDevices) {
+		t.Errorf("empty fake: expected ErrNoRdmaDevices, got %v", err)
+	}
+
+	f.Err = errors.New("injected")
+	if _, err := f.DiscoverAll(); err != f.Err {
+		t.Errorf("expected injected error, got %v", err)
+	}
+}
+
+func TestMustBuildTree_DiscoverAll(t *testing.T) {
+	tree := MustBuildTree(t, filepath.Join("testdata", "cx5-sriov.yaml"))
+
+	devices, err := tree.Discoverer().DiscoverAll()
+	if err != nil {
+		t.Fatalf("DiscoverAll on fixture failed: %v", err)
+	}
+	if len(devices) != 3 {
+		t.Fatalf("expected 3 devices (2 PFs + 1 VF), got %d", len(devices))
+	}
+
+	byPCI := map[string]*types.RdmaDevice{}
+	for _, d := range devices {
+		byPCI[d.PciAddress] = d
+	}
+	vf := byPCI["0000:17:00.2"]
+	if vf == nil {
+		t.Fatal("VF 0000:17:00.2 not discovered")
+	}
+	if vf.Driver != "mlx5_core" || vf.DeviceID != "1018" || vf.IfName != "enp23s0f0v0" || vf.IbDev != "mlx5_2" {
+		t.Errorf("unexpected VF metadata: %+v", vf)
+	}
+	if len(vf.RdmaDevices) != 3 {
+		t.Errorf("expected uverbs2, umad2, rdma_cm for VF, got %v", vf.RdmaDevices)
+	}
+	// The VF sits in its PF's slot; the second PF's slot is not described
+	if pf := byPCI["0000:17:00.0"]; pf.Slot != "3" || pf.BoardID != "MT_0000000010" || vf.Slot != "3" {
+		t.Errorf("slot and board of 0000:17:00.0 = %q, %q, of its VF %q", pf.Slot, pf.BoardID, vf.Slot)
+	}
+	if pf := byPCI["0000:17:00.0"]; pf.Rate != "100 Gb/sec (4X EDR)" || len(pf.Ports) != 0 {
+		t.Errorf("rate of 0000:17:00.0 = %q with ports %+v, want one port at 100 Gb/sec", pf.Rate, pf.Ports)
+	}
+	if pf := byPCI["0000:41:00.0"]; pf.Slot != "" || pf.BoardID != "" {
+		t.Errorf("slot and board of 0000:41:00.0 = %q, %q, want none", pf.Slot, pf.BoardID)
+	}
+}
+
+func TestMustBuildTree_Layout(t *testing.T) {
+	tree := MustBuildTree(t, filepath.Join("testdata", "cx5-sriov.yaml"))
+
+	checks := []string{
+		filepath.Join(tree.SysfsRoot, "bus/pci/devices/0000:17:00.0/virtfn0"),
+		filepath.Join(tree.SysfsRoot, "bus/pci/devices/0000:17:00.2/physfn"),
+		filepath.Join(tree.SysfsRoot, "class/infiniband/mlx5_1/device"),
+		filepath.Join(tree.DevRoot, "infiniband/issm0"),
+		filepath.Join(tree.DevRoot, "infiniband/rdma_cm"),
+	}
+	for _, p := range checks {
+		if _, err := os.Lstat(p); err != nil {
+			t.Errorf("expected %s in fixture tree: %v", p, err)
+		}
+	}
+
+	pci, err := tree.Discoverer().GetPciAddress("enp65s0np0")
+	if err != nil || pci != "0000:41:00.0" {
+		t.Errorf("GetPciAddress(enp65s0np0) = %q, %v", pci, err)
+	}
+}
+
+func TestLoadFixture_UnknownField(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "bad.yaml")
+	os.WriteFile(p, []byte("devices:\n  - pci: \"0000:17:00.0\"\n    bogus: 1\n"), 0644)
+	if _, err := LoadFixture(p); err == nil {
+		t.Error("expected error for unknown fixture field")
+	}
+}
+
+func TestMustBuildTree_VFRelationship(t *testing.T) {
+	tree := MustBuildTree(t, filepath.Join("testdata", "cx5-sriov.yaml"))
+	d := tree.Discoverer()
+
+	vf, err := d.DiscoverByPCI("0000:17:00.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !vf.IsVF || vf.VFIndex != 0 || vf.ParentPFAddress != "0000:17:00.0" {
+		t.Errorf("VF relationship = (%v, %d, %q), want (true, 0, 0000:17:00.0)",
+			vf.IsVF, vf.VFIndex, vf.ParentPFAddress)
+	}
+
+	pf, err := d.DiscoverByPCI("0000:17:00.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pf.IsVF || pf.ParentPFAddress != "" {
+		t.Errorf("PF reported as VF: %+v", pf)
+	}
+}
+
+func TestMustBuildTree_IncludeDevices(t *testing.T) {
+	tree := MustBuildTree(t, filepath.Join("testdata", "cx5-sriov.yaml"))
+
+	tests := []struct {
+		name string
+		opts []rdma.Option
+		want []string
+	}{
+		{"default", nil, []string{"issm0", "ucm0"}},
+		{"none", []rdma.Option{rdma.WithIncludeDevices()}, nil},
+		{"issm", []rdma.Option{rdma.WithIncludeDevices(rdma.DeviceTypeIssm)}, []string{"issm0"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dev, err := tree.Discoverer(tc.opts...).DiscoverByPCI("0000:17:00.0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, spec := range dev.DeviceSpecs {
+				if slices.Contains(rdma.OptionalDeviceTypes, rdma.DeviceType(spec.HostPath)) {
+					got = append(got, filepath.Base(spec.HostPath))
+					if spec.Type != "c" {
+						t.Errorf("%s: expected char device numbers", spec.HostPath)
+					}
+				}
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("optional nodes = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMustBuildTree_NumaNode(t *testing.T) {
+	tree := MustBuildTree(t, filepath.Join("testdata", "cx5-sriov.yaml"))
+	d := tree.Discoverer()
+
+	tests := []struct {
+		pci  string
+		want int
+	}{
+		{"0000:17:00.0", 0},
+		{"0000:41:00.0", 1},
+		{"0000:17:00.2", -1}, // fixture leaves the VF without numa_node
+	}
+	for _, tc := range tests {
+		dev, err := d.DiscoverByPCI(tc.pci)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dev.NumaNode != tc.want {
+			t.Errorf("%s: NumaNode = %d, want %d", tc.pci, dev.NumaNode, tc.want)
+		}
+	}
+}
+
+func TestMustBuildTree_DeviceNumbers(t *testing.T) {
+	tree := MustBuildTree(t, filepath.Join("testdata", "cx5-sriov.yaml"))
+
+	dev, err := tree.Discoverer().DiscoverByPCI("0000:17:00.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, spec := range dev.DeviceSpecs {
+		if spec.Type != "c" || spec.Major == 0 {
+			t.Errorf("%s: expected char device numbers, got type=%q %d:%d",
+				spec.HostPath, spec.Type, spec.Major, spec.Minor)
+		}
+		if spec.UID == nil || spec.GID == nil {
+			t.Errorf("%s: expected node owner to be captured", spec.HostPath)
+		}
+		if filepath.Base(spec.HostPath) == "rdma_cm" && (spec.Major != 10 || spec.Minor != 58) {
+			t.Errorf("rdma_cm numbers = %d:%d, want 10:58", spec.Major, spec.Minor)
+		}
+	}
+}
+
+func TestMustBuildTree_Ports(t *testing.T) {
+	tree := MustBuildTree(t, filepath.Join("testdata", "cx3-dualport.yaml"))
+
+	dev, err := tree.Discoverer().DiscoverByPCI("0000:04:00.0")
+	if err != nil {
+		t.Fatalf("DiscoverByPCI failed: %v", err)
+	}
+	want := []types.RdmaPort{
+		{Number: 1, IfName: "ib0", RdmaDevices: []string{"/dev/infiniband/umad0"}},
+		{Number: 2, IfName: "ib1", RdmaDevices: []string{"/dev/infiniband/umad1"}},
+	}
+	if !reflect.DeepEqual(dev.Ports, want) {
+		t.Errorf("Ports = %+v, want %+v", dev.Ports, want)
+	}
+
+	// Single-port devices report no ports
+	single, err := MustBuildTree(t, filepath.Join("testdata", "cx5-sriov.yaml")).Discoverer().DiscoverByPCI("0000:41:00.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if single.Ports != nil {
+		t.Errorf("single-port device has Ports %+v", single.Ports)
+	}
+}
+
+func TestMustBuildTree_IfNames(t *testing.T) {
+	d := MustBuildTree(t, filepath.Join("testdata", "cx3-dualport.yaml")).Discoverer()
+
+	dev, err := d.DiscoverByPCI("0000:04:00.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.IfName != "ib0" || !slices.Equal(dev.IfNames, []string{"ib0", "ib1"}) {
+		t.Errorf("IfName = %q, IfNames = %v, want ib0, [ib0 ib1]", dev.IfName, dev.IfNames)
+	}
+
+	// Asking for the second interface makes it the primary one
+	dev, err = d.DiscoverByIfName("ib1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.IfName != "ib1" || !slices.Equal(dev.IfNames, []string{"ib1", "ib0"}) {
+		t.Errorf("IfName = %q, IfNames = %v, want ib1, [ib1 ib0]", dev.IfName, dev.IfNames)
+	}
+}
+
+func TestMustBuildTree_LAG(t *testing.T) {
+	tree := MustBuildTree(t, filepath.Join("testdata", "cx6dx-lag.yaml"))
+	wantBond := &types.RdmaBond{IfName: "bond0", Members: []types.BondMember{
+		{PciAddress: "0000:17:00.0", IfName: "enp23s0f0np0"},
+		{PciAddress: "0000:17:00.1", IfName: "enp23s0f1np1"},
+	}}
+
+	devices, err := tree.Discoverer(rdma.WithOffline()).DiscoverAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].PciAddress != "0000:17:00.0" {
+		t.Fatalf("expected one device for the LAG, got %+v", devices)
+	}
+	if devices[0].IfName != "bond0" || !reflect.DeepEqual(devices[0].Bond, wantBond) {
+		t.Errorf("IfName = %q, Bond = %+v, want bond0, %+v", devices[0].IfName, devices[0].Bond, wantBond)
+	}
+
+	// Any member or the bond itself resolves to the LAG's device
+	d := tree.Discoverer(rdma.WithOffline())
+	for _, lookup := range []func() (*types.RdmaDevice, error){
+		func() (*types.RdmaDevice, error) { return d.DiscoverByPCI("0000:17:00.1") },
+		func() (*types.RdmaDevice, error) { return d.DiscoverByIfName("enp23s0f1np1") },
+		func() (*types.RdmaDevice, error) { return d.DiscoverByIfName("bond0") },
+	} {
+		dev, err := lookup()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dev.PciAddress != "0000:17:00.0" || dev.IfName != "bond0" || dev.Bond == nil {
+			t.Errorf("got %s (%s, bond %+v), want the LAG device", dev.PciAddress, dev.IfName, dev.Bond)
+		}
+	}
+
+	// Opting out reports the functions as they are
+	d = tree.Discoverer(rdma.WithOffline(), rdma.WithoutLAGCollapse())
+	dev, err := d.DiscoverByPCI("0000:17:00.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.IfName != "enp23s0f0np0" || dev.Bond != nil {
+		t.Errorf("IfName = %q, Bond = %+v without LAG collapse", dev.IfName, dev.Bond)
+	}
+	if _, err := d.DiscoverByPCI("0000:17:00.1"); !errors.Is(err, rdma.ErrNoRdmaDevices) {
+		t.Errorf("second PF without LAG collapse: err = %v, want ErrNoRdmaDevices", err)
+	}
+}
@@ -0,0 +1,140 @@
+package rdma
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// DefaultPermissions is the cgroup access granted to each device node.
+const DefaultPermissions = "rw"
+
+// RDMA character device types, as returned by DeviceType.
+const (
+	DeviceTypeUverbs = "uverbs"
+	DeviceTypeUmad   = "umad"
+	DeviceTypeIssm   = "issm"
+	DeviceTypeUcm    = "ucm"
+	DeviceTypeRdmaCM = "rdma_cm"
+)
+
+// DeviceTypes lists every device type understood by WithDeviceTypes.
+var DeviceTypes = []string{DeviceTypeUverbs, DeviceTypeUmad, DeviceTypeIssm, DeviceTypeUcm, DeviceTypeRdmaCM}
+
+// OptionalDeviceTypes lists the device types that discovery reports unless
+// left out with WithIncludeDevices: issm (subnet management) and the
+// legacy ucm nodes.
+var OptionalDeviceTypes = []string{DeviceTypeIssm, DeviceTypeUcm}
+
+// SpecOption customizes how BuildDeviceSpecs turns char devices into specs.
+type SpecOption func(*specOptions)
+
+type specOptions struct {
+	permissions   string
+	containerPath func(hostPath string) string
+	deviceTypes   []string
+}
+
+// WithPermissions sets the cgroup permissions (a combination of "r", "w"
+// and "m") granted to each device node.
+func WithPermissions(perm string) SpecOption {
+	return func(o *specOptions) {
+		o.permissions = perm
+	}
+}
+
+// WithContainerPathFunc maps each host device path to the path it should
+// appear at inside the container.
+func WithContainerPathFunc(fn func(hostPath string) string) SpecOption {
+	return func(o *specOptions) {
+		o.containerPath = fn
+	}
+}
+
+// WithContainerDir places every device node directly under dir inside the
+// container, keeping its base name (e.g. /dev/infiniband/uverbs0 becomes
+// <dir>/uverbs0).
+func WithContainerDir(dir string) SpecOption {
+	return WithContainerPathFunc(func(hostPath string) string {
+		return filepath.Join(dir, filepath.Base(hostPath))
+	})
+}
+
+// WithDeviceTypes limits the specs to the given device types. Char devices
+// of any other type are dropped.
+func WithDeviceTypes(deviceTypes ...string) SpecOption {
+	return func(o *specOptions) {
+		o.deviceTypes = deviceTypes
+	}
+}
+
+// ValidatePermissions reports whether perm is a valid, non-empty cgroup
+// permission string.
+func ValidatePermissions(perm string) error {
+	if perm == "" || strings.Trim(perm, "rwm") != "" {
+		return fmt.Errorf("invalid device permissions %q: must be a combination of r, w and m", perm)
+	}
+	return nil
+}
+
+// ValidateDeviceType reports whether t is one of DeviceTypes.
+func ValidateDeviceType(t string) error {
+	if !slices.Contains(DeviceTypes, t) {
+		return fmt.Errorf("unknown device type %q (valid: %s)", t, strings.Join(DeviceTypes, ", "))
+	}
+	return nil
+}
+
+// ValidateOptionalDeviceType reports whether t is one of OptionalDeviceTypes.
+func ValidateOptionalDeviceType(t string) error {
+	if !slices.Contains(OptionalDeviceTypes, t) {
+		return fmt.Errorf("unknown optional device type %q (valid: %s)", t, strings.Join(OptionalDeviceTypes, ", "))
+	}
+	return nil
+}
+
+// DeviceType classifies an RDMA char device path by its base name, e.g.
+// "/dev/infiniband/uverbs0" is DeviceTypeUverbs. It returns "" for paths
+// that are not RDMA char devices.
+func DeviceType(devPath string) string {
+	name := filepath.Base(devPath)
+	if name == rdmaCmName {
+		return DeviceTypeRdmaCM
+	}
+	for _, t := range []string{DeviceTypeUverbs, DeviceTypeUmad, DeviceTypeIssm, DeviceTypeUcm} {
+		if strings.HasPrefix(name, t) {
+			return t
+		}
+	}
+	return ""
+}
+
+// BuildDeviceSpecs converts RDMA character device paths to DeviceSpec
+// entries. By default each device is exposed read-write at the same path
+// inside the container.
+func BuildDeviceSpecs(charDevs []string, opts ...SpecOption) []types.DeviceSpec {
+	o := specOptions{permissions: DefaultPermissions}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	specs := make([]types.DeviceSpec, 0, len(charDevs))
+	for _, dev := range charDevs {
+		if len(o.deviceTypes) > 0 && !slices.Contains(o.deviceTypes, DeviceType(dev)) {
+			continue
+		}
+		containerPath := dev
+		if o.containerPath != nil {
+			containerPath = o.containerPath(dev)
+		}
+		specs = append(specs, types.DeviceSpec{
+			HostPath:      dev,
+			ContainerPath: containerPath,
+			Permissions:   o.permissions,
+		})
+	}
+	return specs
+}
@@ -0,0 +1,109 @@
+package rdma
+
+import (
+	"testing"
+)
+
+func TestBuildDeviceSpecs(t *testing.T) {
+	charDevs := []string{"/dev/infiniband/uverbs0", "/dev/infiniband/rdma_cm"}
+	specs := BuildDeviceSpecs(charDevs)
+
+	if len(specs) != 2 {
+		t.Fatalf("expected 2 specs, got %d", len(specs))
+	}
+	for _, s := range specs {
+		if s.HostPath != s.ContainerPath {
+			t.Errorf("HostPath (%s) != ContainerPath (%s)", s.HostPath, s.ContainerPath)
+		}
+		if s.Permissions != "rw" {
+			t.Errorf("expected permissions 'rw', got %q", s.Permissions)
+		}
+	}
+}
+
+func TestBuildDeviceSpecs_Empty(t *testing.T) {
+	specs := BuildDeviceSpecs(nil)
+	if len(specs) != 0 {
+		t.Errorf("expected empty specs, got %d", len(specs))
+	}
+}
+
+func TestBuildDeviceSpecs_Options(t *testing.T) {
+	charDevs := []string{
+		"/dev/infiniband/rdma_cm",
+		"/dev/infiniband/issm0",
+		"/dev/infiniband/umad0",
+		"/dev/infiniband/uverbs0",
+	}
+	specs := BuildDeviceSpecs(charDevs,
+		WithPermissions("rwm"),
+		WithContainerDir("/dev/rdma"),
+		WithDeviceTypes(DeviceTypeUverbs, DeviceTypeRdmaCM),
+	)
+
+	want := map[string]string{
+		"/dev/infiniband/rdma_cm": "/dev/rdma/rdma_cm",
+		"/dev/infiniband/uverbs0": "/dev/rdma/uverbs0",
+	}
+	if len(specs) != len(want) {
+		t.Fatalf("expected %d specs, got %+v", len(want), specs)
+	}
+	for _, s := range specs {
+		if want[s.HostPath] != s.ContainerPath {
+			t.Errorf("%s mapped to %q, want %q", s.HostPath, s.ContainerPath, want[s.HostPath])
+		}
+		if s.Permissions != "rwm" {
+			t.Errorf("%s permissions = %q, want 'rwm'", s.HostPath, s.Permissions)
+		}
+	}
+}
+
+func TestDeviceType(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/dev/infiniband/uverbs12", DeviceTypeUverbs},
+		{"/dev/infiniband/umad0", DeviceTypeUmad},
+		{"/dev/infiniband/issm1", DeviceTypeIssm},
+		{"/dev/infiniband/ucm0", DeviceTypeUcm},
+		{"/dev/infiniband/rdma_cm", DeviceTypeRdmaCM},
+		{"/dev/null", ""},
+	}
+	for _, tc := range tests {
+		if got := DeviceType(tc.path); got != tc.want {
+			t.Errorf("DeviceType(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestValidatePermissions(t *testing.T) {
+	for _, p := range []string{"r", "rw", "rwm"} {
+		if err := ValidatePermissions(p); err != nil {
+			t.Errorf("ValidatePermissions(%q) = %v, want nil", p, err)
+		}
+	}
+	for _, p := range []string{"", "rx", "write"} {
+		if err := ValidatePermissions(p); err == nil {
+			t.Errorf("ValidatePermissions(%q) should fail", p)
+		}
+	}
+}
+
+func TestWithSpecOptions_Discoverer(t *testing.T) {
+	sysRoot, devRoot := seedRdmaSysfs(t)
+	d := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot),
+		WithSpecOptions(WithDeviceTypes(DeviceTypeUverbs)))
+
+	dev, err := d.DiscoverByPCI("0000:17:00.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dev.DeviceSpecs) != 1 || DeviceType(dev.DeviceSpecs[0].HostPath) != DeviceTypeUverbs {
+		t.Errorf("expected only the uverbs spec, got %+v", dev.DeviceSpecs)
+	}
+	// Filtering specs must not hide devices from verification
+	if len(dev.RdmaDevices) < 3 {
+		t.Errorf("RdmaDevices should stay unfiltered, got %v", dev.RdmaDevices)
+	}
+}
@@ -0,0 +1,129 @@
+package rdma
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// Kernel upper-layer protocols GetUsage detects.
+const (
+	ULPIPoIB    = "ipoib"
+	ULPSRP      = "srp"
+	ULPNVMeRDMA = "nvme-rdma"
+)
+
+// arphrdInfiniband is the type of an IPoIB netdev (ARPHRD_INFINIBAND).
+const arphrdInfiniband = "32"
+
+// WithUsage has discovery record what currently uses each device, see
+// GetUsage. It reads every process's file descriptors, so it is only
+// worth it when the answer is shown. Ignored with WithOffline.
+func WithUsage() Option {
+	return func(d *Discoverer) {
+		d.usage = true
+	}
+}
+
+// procPath joins elem onto the proc filesystem next to the sysfs root,
+// e.g. /host/proc under --host-root /host.
+func (d *Discoverer) procPath(elem ...string) string {
+	return filepath.Join(append([]string{filepath.Dir(d.sysfsRoot), "proc"}, elem...)...)
+}
+
+// GetUsage returns the kernel ULPs attached to dev and the verbs contexts
+// open on its uverbs nodes, so operators can tell whether the device is
+// in use before rebinding or removing it. Processes in other mount
+// namespaces count as long as they opened the nodes under the host path.
+// nvme-rdma is attributed by address: to the device holding a
+// controller's source address, or an address in the subnet of its target.
+func (d *Discoverer) GetUsage(dev *types.RdmaDevice) *types.RdmaUsage {
+	u := &types.RdmaUsage{}
+	if names, err := d.GetNetNames(dev.PciAddress); err == nil {
+		for _, n := range names {
+			if readSysfsAttr(d.sysPath("class/net", n, "type")) == arphrdInfiniband {
+				u.ULPs = append(u.ULPs, ULPIPoIB)
+				break
+			}
+		}
+	}
+	if dev.IbDev != "" {
+		// ib_srp registers one srp-<ibdev>-<port> host per port it serves
+		if m, _ := filepath.Glob(d.sysPath("class/infiniband_srp", "srp-"+dev.IbDev+"-*")); len(m) > 0 {
+			u.ULPs = append(u.ULPs, ULPSRP)
+		}
+	}
+	if d.nvmeRDMAOn(dev.Addresses) {
+		u.ULPs = append(u.ULPs, ULPNVMeRDMA)
+	}
+
+	var uverbs []string
+	for _, p := range dev.RdmaDevices {
+		if strings.HasPrefix(filepath.Base(p), "uverbs") {
+			uverbs = append(uverbs, p)
+		}
+	}
+	if len(uverbs) == 0 {
+		return u
+	}
+	procs, _ := os.ReadDir(d.procPath())
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			continue
+		}
+		fds, _ := os.ReadDir(d.procPath(proc.Name(), "fd"))
+		open := 0
+		for _, fd := range fds {
+			// Processes come and go while they are read
+			if target, err := os.Readlink(d.procPath(proc.Name(), "fd", fd.Name())); err == nil && slices.Contains(uverbs, target) {
+				open++
+			}
+		}
+		if open > 0 {
+			u.VerbsUsers += open
+			u.PIDs = append(u.PIDs, pid)
+		}
+	}
+	slices.Sort(u.PIDs)
+	return u
+}
+
+// nvmeRDMAOn reports whether an nvme-rdma controller is reached through
+// one of addrs (CIDR notation).
+func (d *Discoverer) nvmeRDMAOn(addrs []string) bool {
+	var prefixes []netip.Prefix
+	for _, a := range addrs {
+		if p, err := netip.ParsePrefix(a); err == nil {
+			prefixes = append(prefixes, p)
+		}
+	}
+	if len(prefixes) == 0 {
+		return false
+	}
+	ctrls, _ := filepath.Glob(d.sysPath("class/nvme", "nvme*"))
+	for _, ctrl := range ctrls {
+		if readSysfsAttr(filepath.Join(ctrl, "transport")) != "rdma" {
+			continue
+		}
+		// e.g. "traddr=192.0.2.20,trsvcid=4420,src_addr=192.0.2.10"
+		for _, kv := range strings.Split(readSysfsAttr(filepath.Join(ctrl, "address")), ",") {
+			key, value, _ := strings.Cut(kv, "=")
+			ip, err := netip.ParseAddr(value)
+			if err != nil {
+				continue
+			}
+			for _, p := range prefixes {
+				if (key == "src_addr" && p.Addr() == ip) || (key == "traddr" && p.Masked().Contains(ip)) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
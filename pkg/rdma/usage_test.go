@@ -0,0 +1,75 @@
+package rdma
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+func TestGetUsage(t *testing.T) {
+	root := t.TempDir()
+	sys := filepath.Join(root, "sys")
+	write := func(content string, elem ...string) {
+		t.Helper()
+		p := filepath.Join(append([]string{root}, elem...)...)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fd := func(target string, elem ...string) {
+		t.Helper()
+		p := filepath.Join(append([]string{root, "proc"}, elem...)...)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.Symlink(target, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	os.MkdirAll(filepath.Join(sys, "bus/pci/devices/0000:17:00.0/net/ib0"), 0755)
+	write("32\n", "sys/class/net/ib0/type")
+	os.MkdirAll(filepath.Join(sys, "class/infiniband_srp/srp-mlx5_0-1"), 0755)
+	write("rdma\n", "sys/class/nvme/nvme0/transport")
+	write("traddr=192.0.2.20,trsvcid=4420\n", "sys/class/nvme/nvme0/address")
+	write("tcp\n", "sys/class/nvme/nvme1/transport")
+	write("traddr=198.51.100.7,trsvcid=4420\n", "sys/class/nvme/nvme1/address")
+
+	fd("/dev/infiniband/uverbs0", "4242/fd/3")
+	fd("/dev/infiniband/uverbs0", "4242/fd/7")
+	fd("/dev/null", "4242/fd/0")
+	fd("/dev/infiniband/uverbs0", "17/fd/9")
+	fd("/dev/infiniband/uverbs1", "99/fd/3")
+	fd("/dev/infiniband/uverbs0", "self/fd/3")
+
+	d := NewDiscoverer(WithSysfsRoot(sys))
+	dev := &types.RdmaDevice{
+		PciAddress:  "0000:17:00.0",
+		IbDev:       "mlx5_0",
+		Addresses:   []string{"192.0.2.10/24"},
+		RdmaDevices: []string{"/dev/infiniband/uverbs0", "/dev/infiniband/rdma_cm"},
+	}
+	u := d.GetUsage(dev)
+	if want := []string{ULPIPoIB, ULPSRP, ULPNVMeRDMA}; !slices.Equal(u.ULPs, want) {
+		t.Errorf("ULPs = %v, want %v", u.ULPs, want)
+	}
+	if u.VerbsUsers != 3 || !slices.Equal(u.PIDs, []int{17, 4242}) {
+		t.Errorf("users = %d %v, want 3 contexts of pids 17 and 4242", u.VerbsUsers, u.PIDs)
+	}
+
+	// Another device: no IPoIB netdev, no SRP host, an unrelated subnet
+	other := &types.RdmaDevice{
+		PciAddress:  "0000:41:00.0",
+		IbDev:       "mlx5_1",
+		Addresses:   []string{"198.51.100.1/24"},
+		RdmaDevices: []string{"/dev/infiniband/uverbs1"},
+	}
+	u = d.GetUsage(other)
+	if len(u.ULPs) != 0 || u.VerbsUsers != 1 || !slices.Equal(u.PIDs, []int{99}) {
+		t.Errorf("GetUsage(other) = %+v", u)
+	}
+}
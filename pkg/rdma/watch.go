@@ -0,0 +1,271 @@
+package rdma
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// EventType classifies a DeviceEvent.
+type EventType string
+
+const (
+	// DeviceAdded is sent when a new RDMA device appears.
+	DeviceAdded EventType = "added"
+	// DeviceRemoved is sent when an RDMA device disappears.
+	DeviceRemoved EventType = "removed"
+	// DeviceChanged is sent when an existing device's char devices,
+	// interface, driver, or link type change.
+	DeviceChanged EventType = "changed"
+	// DeviceRenamed is sent when udev renames the interface of an existing
+	// device; Previous holds the state under the old name.
+	DeviceRenamed EventType = "renamed"
+)
+
+// DeviceEvent describes a hot-plug change of one RDMA device. For
+// DeviceRemoved, Device holds the last known state.
+type DeviceEvent struct {
+	Type     EventType
+	Device   *types.RdmaDevice
+	Previous *types.RdmaDevice
+}
+
+// watchDebounce is how long the watcher waits for a burst of kernel
+// notifications to settle before rescanning.
+var watchDebounce = 250 * time.Millisecond
+
+// newTriggerSource returns a channel that receives a value whenever the
+// kernel reports a change that may affect RDMA devices. Replaceable in tests.
+var newTriggerSource = kernelTriggers
+
+// ueventSubsystems are the uevent SUBSYSTEM values that trigger a rescan.
+var ueventSubsystems = map[string]bool{
+	"infiniband":       true,
+	"infiniband_verbs": true,
+	"infiniband_mad":   true,
+	"net":              true,
+}
+
+// WatchDevices watches the host for RDMA device hot-plug (convenience wrapper).
+func WatchDevices(ctx context.Context) (<-chan DeviceEvent, error) {
+	return NewDiscoverer().WatchDevices(ctx)
+}
+
+// WatchDevices takes an initial snapshot of RDMA devices and then emits
+// Added/Removed/Changed events as udev (kobject uevent) and netlink link
+// notifications arrive. The returned channel is closed when ctx is done.
+func (d *Discoverer) WatchDevices(ctx context.Context) (<-chan DeviceEvent, error) {
+	// Cancelled on failure, so the trigger sources do not outlive it
+	ctx, cancel := context.WithCancel(ctx)
+	triggers, err := newTriggerSource(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	snapshot, err := d.snapshot()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	events := make(chan DeviceEvent)
+	go func() {
+		defer cancel()
+		d.watchLoop(ctx, triggers, snapshot, events)
+	}()
+	return events, nil
+}
+
+// watchLoop rescans on every (debounced) trigger and emits the differences.
+func (d *Discoverer) watchLoop(ctx context.Context, triggers <-chan struct{}, snapshot map[string]*types.RdmaDevice, events chan<- DeviceEvent) {
+	defer close(events)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-triggers:
+			if !ok {
+				return
+			}
+		}
+
+		// Let a burst of notifications settle before rescanning
+		timer := time.NewTimer(watchDebounce)
+	settle:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-triggers:
+			case <-timer.C:
+				break settle
+			}
+		}
+
+		current, err := d.snapshot()
+		if err != nil {
+			log.Warnf("RDMA device rescan failed: %v", err)
+			continue
+		}
+		for _, ev := range diffDevices(snapshot, current) {
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+		snapshot = current
+	}
+}
+
+// snapshot runs DiscoverAll and indexes the result by PCI address.
+// A host without RDMA devices yields an empty snapshot, not an error.
+func (d *Discoverer) snapshot() (map[string]*types.RdmaDevice, error) {
+	devices, err := d.DiscoverAll()
+	if err != nil && !errors.Is(err, ErrNoRdmaDevices) {
+		return nil, err
+	}
+	snap := make(map[string]*types.RdmaDevice, len(devices))
+	for _, dev := range devices {
+		snap[dev.PciAddress] = dev
+	}
+	return snap, nil
+}
+
+// diffDevices compares two snapshots and returns the resulting events,
+// removals first, then additions and changes. A PCI address whose node
+// GUID changed holds another card now, and is reported as removed and
+// added rather than changed.
+func diffDevices(prev, cur map[string]*types.RdmaDevice) []DeviceEvent {
+	var events []DeviceEvent
+	for _, pciAddr := range slices.Sorted(maps.Keys(prev)) {
+		if dev, ok := cur[pciAddr]; !ok || replaced(prev[pciAddr], dev) {
+			events = append(events, DeviceEvent{Type: DeviceRemoved, Device: prev[pciAddr]})
+		}
+	}
+	for _, pciAddr := range slices.Sorted(maps.Keys(cur)) {
+		old, ok := prev[pciAddr]
+		switch {
+		case !ok || replaced(old, cur[pciAddr]):
+			events = append(events, DeviceEvent{Type: DeviceAdded, Device: cur[pciAddr]})
+		case old.IfName != "" && cur[pciAddr].IfName != "" && old.IfName != cur[pciAddr].IfName:
+			events = append(events, DeviceEvent{Type: DeviceRenamed, Device: cur[pciAddr], Previous: old})
+		case !reflect.DeepEqual(old, cur[pciAddr]):
+			events = append(events, DeviceEvent{Type: DeviceChanged, Device: cur[pciAddr]})
+		}
+	}
+	return events
+}
+
+// replaced reports whether cur is a different card than prev in the same
+// PCI slot. Devices without a node GUID are assumed to be the same.
+func replaced(prev, cur *types.RdmaDevice) bool {
+	return prev.NodeGUID != "" && cur.NodeGUID != "" && prev.NodeGUID != cur.NodeGUID
+}
+
+// ───────────────────────────────────────────
+//  kernel notification sources
+// ───────────────────────────────────────────
+
+// kernelTriggers merges kobject uevents for RDMA/net subsystems and netlink
+// link updates into a single coalescing trigger channel.
+func kernelTriggers(ctx context.Context) (<-chan struct{}, error) {
+	out := make(chan struct{}, 1)
+
+	// Cancelled on failure, so the uevent reader does not outlive it
+	ctx, cancel := context.WithCancel(ctx)
+	if err := subscribeUevents(ctx, out); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	updates := make(chan netlink.LinkUpdate)
+	if err := netlink.LinkSubscribe(updates, ctx.Done()); err != nil {
+		cancel()
+		return nil, fmt.Errorf("cannot subscribe to netlink link updates: %w", err)
+	}
+	// updates is closed once ctx is done
+	go func() {
+		defer cancel()
+		for range updates {
+			notify(out)
+		}
+	}()
+
+	return out, nil
+}
+
+// subscribeUevents opens a NETLINK_KOBJECT_UEVENT socket and signals out for
+// every uevent whose SUBSYSTEM is in ueventSubsystems.
+func subscribeUevents(ctx context.Context, out chan<- struct{}) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return fmt.Errorf("cannot open uevent socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: 1}); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("cannot bind uevent socket: %w", err)
+	}
+	// A receive timeout lets the reader notice context cancellation
+	tv := unix.Timeval{Sec: 1}
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("cannot set uevent socket timeout: %w", err)
+	}
+
+	go func() {
+		defer unix.Close(fd)
+		buf := make([]byte, 16*1024)
+		for ctx.Err() == nil {
+			n, _, err := unix.Recvfrom(fd, buf, 0)
+			switch {
+			case err == unix.EAGAIN || err == unix.EINTR:
+				continue
+			case err == unix.ENOBUFS:
+				// The socket overflowed and uevents were lost: rescan
+				notify(out)
+				continue
+			case err != nil:
+				log.Warnf("Stopped reading uevents: %v", err)
+				return
+			}
+			if ueventSubsystems[ueventSubsystem(buf[:n])] {
+				notify(out)
+			}
+		}
+	}()
+	return nil
+}
+
+// ueventSubsystem extracts the SUBSYSTEM value from a raw uevent message
+// ("action@devpath\0KEY=VALUE\0...").
+func ueventSubsystem(msg []byte) string {
+	for _, field := range bytes.Split(msg, []byte{0}) {
+		if v, ok := bytes.CutPrefix(field, []byte("SUBSYSTEM=")); ok {
+			return string(v)
+		}
+	}
+	return ""
+}
+
+// notify performs a non-blocking send; pending triggers are coalesced.
+func notify(ch chan<- struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,146 @@
+package rdma
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// fakeTriggers replaces the kernel notification source with a channel
+// controlled by the test.
+func fakeTriggers(t *testing.T) chan struct{} {
+	t.Helper()
+	origSource, origDebounce := newTriggerSource, watchDebounce
+	t.Cleanup(func() { newTriggerSource, watchDebounce = origSource, origDebounce })
+
+	ch := make(chan struct{}, 1)
+	newTriggerSource = func(context.Context) (<-chan struct{}, error) { return ch, nil }
+	watchDebounce = time.Millisecond
+	return ch
+}
+
+func nextEvent(t *testing.T, events <-chan DeviceEvent) DeviceEvent {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for device event")
+		return DeviceEvent{}
+	}
+}
+
+func TestWatchDevices_AddRemove(t *testing.T) {
+	sysRoot, devRoot := seedRdmaSysfs(t)
+	triggers := fakeTriggers(t)
+	d := NewDiscoverer(WithSysfsRoot(sysRoot), WithDevRoot(devRoot))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := d.WatchDevices(ctx)
+	if err != nil {
+		t.Fatalf("WatchDevices failed: %v", err)
+	}
+
+	// Hot-plug a second PF backed by mlx5_1
+	vf := filepath.Join(sysRoot, sysBusPci, "0000:41:00.0", "infiniband", "mlx5_1")
+	if err := os.MkdirAll(vf, 0755); err != nil {
+		t.Fatal(err)
+	}
+	triggers <- struct{}{}
+
+	ev := nextEvent(t, events)
+	if ev.Type != DeviceAdded || ev.Device.PciAddress != "0000:41:00.0" {
+		t.Errorf("expected added 0000:41:00.0, got %s %s", ev.Type, ev.Device.PciAddress)
+	}
+
+	// Unplug it again
+	if err := os.RemoveAll(filepath.Join(sysRoot, sysBusPci, "0000:41:00.0")); err != nil {
+		t.Fatal(err)
+	}
+	triggers <- struct{}{}
+
+	ev = nextEvent(t, events)
+	if ev.Type != DeviceRemoved || ev.Device.PciAddress != "0000:41:00.0" {
+		t.Errorf("expected removed 0000:41:00.0, got %s %s", ev.Type, ev.Device.PciAddress)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestDiffDevices(t *testing.T) {
+	a := &types.RdmaDevice{PciAddress: "0000:17:00.0", RdmaDevices: []string{"/dev/infiniband/uverbs0"}}
+	b := &types.RdmaDevice{PciAddress: "0000:41:00.0", RdmaDevices: []string{"/dev/infiniband/uverbs1"}}
+	a2 := &types.RdmaDevice{PciAddress: "0000:17:00.0", RdmaDevices: []string{"/dev/infiniband/uverbs2"}}
+
+	prev := map[string]*types.RdmaDevice{a.PciAddress: a, b.PciAddress: b}
+	cur := map[string]*types.RdmaDevice{a2.PciAddress: a2}
+
+	events := diffDevices(prev, cur)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d: %v", len(events), events)
+	}
+	if events[0].Type != DeviceRemoved || events[0].Device != b {
+		t.Errorf("events[0] = %s %s, want removed 0000:41:00.0", events[0].Type, events[0].Device.PciAddress)
+	}
+	if events[1].Type != DeviceChanged || events[1].Device != a2 {
+		t.Errorf("events[1] = %s %s, want changed 0000:17:00.0", events[1].Type, events[1].Device.PciAddress)
+	}
+
+	if got := diffDevices(prev, prev); len(got) != 0 {
+		t.Errorf("identical snapshots should produce no events, got %v", got)
+	}
+}
+
+func TestDiffDevices_RenameAndReplace(t *testing.T) {
+	old := &types.RdmaDevice{PciAddress: "0000:41:00.0", IfName: "enp65s0np0", NodeGUID: "0c42:a103:0065:4f3a"}
+	renamed := &types.RdmaDevice{PciAddress: "0000:41:00.0", IfName: "ens1f0np0", NodeGUID: "0c42:a103:0065:4f3a"}
+	swapped := &types.RdmaDevice{PciAddress: "0000:41:00.0", IfName: "enp65s0np0", NodeGUID: "b859:9f03:00d4:1e20"}
+	prev := map[string]*types.RdmaDevice{old.PciAddress: old}
+
+	events := diffDevices(prev, map[string]*types.RdmaDevice{renamed.PciAddress: renamed})
+	if len(events) != 1 || events[0].Type != DeviceRenamed || events[0].Device != renamed || events[0].Previous != old {
+		t.Errorf("rename: got %+v, want one renamed event", events)
+	}
+
+	events = diffDevices(prev, map[string]*types.RdmaDevice{swapped.PciAddress: swapped})
+	if len(events) != 2 || events[0].Type != DeviceRemoved || events[0].Device != old ||
+		events[1].Type != DeviceAdded || events[1].Device != swapped {
+		t.Errorf("replaced card: got %+v, want removed then added", events)
+	}
+}
+
+func TestUeventSubsystem(t *testing.T) {
+	msg := []byte("add@/devices/pci0000:00/0000:17:00.0/infiniband/mlx5_0\x00ACTION=add\x00SUBSYSTEM=infiniband\x00SEQNUM=42\x00")
+	if got := ueventSubsystem(msg); got != "infiniband" {
+		t.Errorf("ueventSubsystem = %q, want infiniband", got)
+	}
+	if got := ueventSubsystem([]byte("libudev\x00garbage")); got != "" {
+		t.Errorf("ueventSubsystem = %q, want empty", got)
+	}
+}
+
+func TestWatchDevices_SnapshotErrorStopsTriggers(t *testing.T) {
+	fakeTriggers(t)
+	var triggerCtx context.Context
+	newTriggerSource = func(ctx context.Context) (<-chan struct{}, error) {
+		triggerCtx = ctx
+		return make(chan struct{}), nil
+	}
+	d := NewDiscoverer(WithSysfsRoot(filepath.Join(t.TempDir(), "missing")))
+
+	if _, err := d.WatchDevices(context.Background()); err == nil {
+		t.Fatal("WatchDevices without a sysfs tree succeeded, want an error")
+	}
+	select {
+	case <-triggerCtx.Done():
+	default:
+		t.Error("the trigger sources outlive the failed WatchDevices")
+	}
+}
@@ -0,0 +1,395 @@
+// Package rdmacdi is the library interface of rdma-cdi. It discovers RDMA
+// devices, generates and writes their CDI specs, diagnoses them and cleans
+// specs up, for programs such as DRA drivers and node agents that embed the
+// functionality instead of running the CLI.
+//
+// The lower-level packages (rdma, cdi, doctor) remain available for finer
+// control; this package only ties them together the way the CLI does.
+package rdmacdi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
+
+	"github.com/Nativu5/rdma-cdi/pkg/cdi"
+	"github.com/Nativu5/rdma-cdi/pkg/doctor"
+	"github.com/Nativu5/rdma-cdi/pkg/rdma"
+	"github.com/Nativu5/rdma-cdi/pkg/selector"
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+	"github.com/Nativu5/rdma-cdi/pkg/utils"
+)
+
+// Selector chooses devices. PciAddresses and IfNames name devices
+// explicitly, each of which must exist; without them every device of the
+// host is a candidate. Match then narrows the candidates to those it
+// matches, e.g. selector.Parse("driver=mlx5_core,numa=1").
+type Selector struct {
+	PciAddresses []string
+	IfNames      []string
+	Match        selector.Selector
+}
+
+// IsZero reports whether the selector selects every device.
+func (s Selector) IsZero() bool {
+	return len(s.PciAddresses) == 0 && len(s.IfNames) == 0 && s.Match.IsZero()
+}
+
+// Discover returns the devices sel selects, discovered with a discoverer
+// configured by opts.
+func Discover(ctx context.Context, sel Selector, opts ...rdma.Option) ([]*types.RdmaDevice, error) {
+	return Select(ctx, rdma.NewDiscoverer(opts...), sel)
+}
+
+// Select returns the devices sel selects, discovered with d, in the order
+// they were named or, without PciAddresses and IfNames, in discovery
+// order. A device named twice is returned once.
+func Select(ctx context.Context, d types.RdmaDeviceDiscoverer, sel Selector) ([]*types.RdmaDevice, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var candidates []*types.RdmaDevice
+	if len(sel.PciAddresses) == 0 && len(sel.IfNames) == 0 {
+		devices, err := d.DiscoverAll()
+		if err != nil {
+			return nil, err
+		}
+		candidates = devices
+	} else {
+		for _, pci := range sel.PciAddresses {
+			dev, err := d.DiscoverByPCI(pci)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, dev)
+		}
+		for _, ifname := range sel.IfNames {
+			dev, err := d.DiscoverByIfName(ifname)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, dev)
+		}
+	}
+
+	var devices []*types.RdmaDevice
+	seen := make(map[string]bool, len(candidates))
+	for _, dev := range candidates {
+		if seen[dev.PciAddress] || !sel.Match.MatchesDevice(dev) {
+			continue
+		}
+		seen[dev.PciAddress] = true
+		devices = append(devices, dev)
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("%w among the selected devices", rdma.ErrNoRdmaDevices)
+	}
+	return devices, ctx.Err()
+}
+
+// GenerateOptions configures GenerateSpec.
+type GenerateOptions struct {
+	// Selector chooses the devices of the spec.
+	Selector Selector
+	// Discovery configures the discoverer, e.g. rdma.WithSysfsRoot or
+	// rdma.WithSpecOptions.
+	Discovery []rdma.Option
+	// Prefix and Name make up the spec kind, Prefix/Name. Prefix defaults
+	// to cdi.DefaultPrefix; Name is required.
+	Prefix string
+	Name   string
+	// Spec customizes the spec, e.g. cdi.WithAliases.
+	Spec []cdi.Option
+}
+
+func (o *GenerateOptions) prefix() string {
+	if o.Prefix == "" {
+		return cdi.DefaultPrefix
+	}
+	return o.Prefix
+}
+
+// GenerateSpec discovers the selected devices and returns their CDI spec,
+// without writing it.
+func GenerateSpec(ctx context.Context, opts GenerateOptions) (*cdiSpecs.Spec, error) {
+	devices, err := generateDevices(ctx, &opts)
+	if err != nil {
+		return nil, err
+	}
+	return cdi.BuildSpec(opts.prefix(), opts.Name, devices, opts.Spec...)
+}
+
+func generateDevices(ctx context.Context, opts *GenerateOptions) ([]types.RdmaDevice, error) {
+	if err := utils.ValidateCDIName(opts.Name); err != nil {
+		return nil, err
+	}
+	devices, err := Discover(ctx, opts.Selector, opts.Discovery...)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]types.RdmaDevice, 0, len(devices))
+	for _, dev := range devices {
+		out = append(out, *dev)
+	}
+	return out, nil
+}
+
+// WriteOptions configures WriteSpec.
+type WriteOptions struct {
+	GenerateOptions
+	// OutputDir defaults to cdi.DefaultOutputDir.
+	OutputDir string
+	// Format is "yaml" (the default) or "json".
+	Format string
+	// StateFile, when set, records the written spec there, as the generate
+	// command does, so Cleanup can tell whether it was modified since.
+	StateFile string
+}
+
+// WriteSpec discovers the selected devices and writes their CDI spec,
+// returning its path.
+func WriteSpec(ctx context.Context, opts WriteOptions) (string, error) {
+	devices, err := generateDevices(ctx, &opts.GenerateOptions)
+	if err != nil {
+		return "", err
+	}
+	dir, format := opts.OutputDir, opts.Format
+	if dir == "" {
+		dir = cdi.DefaultOutputDir
+	}
+	if format == "" {
+		format = "yaml"
+	}
+
+	prefix := opts.prefix()
+	if err := cdi.CreateCDISpec(prefix, opts.Name, devices, dir, format, opts.Spec...); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, cdi.SpecFileName(prefix, opts.Name, format))
+
+	if opts.StateFile != "" {
+		var pcis []string
+		var ids []cdi.DeviceIdentity
+		for i := range devices {
+			pcis = append(pcis, devices[i].PciAddress)
+			ids = append(ids, cdi.IdentityOf(&devices[i]))
+		}
+		err := cdi.UpdateState(opts.StateFile, func(state *cdi.State) error {
+			return state.Record(path, prefix+"/"+opts.Name, pcis, ids...)
+		})
+		if err != nil {
+			return path, fmt.Errorf("spec written, but not recorded in %s: %w", opts.StateFile, err)
+		}
+	}
+	return path, nil
+}
+
+// StoreSpec discovers the selected devices and puts their CDI spec into
+// store instead of a spec directory, e.g. into a kubestore.Store when
+// generating on the control plane from a captured sysfs tree.
+func StoreSpec(ctx context.Context, opts GenerateOptions, store cdi.SpecStore) (*cdiSpecs.Spec, error) {
+	spec, err := GenerateSpec(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Put(ctx, spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// DiagnoseOptions configures Diagnose.
+type DiagnoseOptions struct {
+	// Selector chooses the devices to diagnose.
+	Selector Selector
+	// Discovery configures the discoverer; the diagnostics read the same
+	// sysfs and /dev roots.
+	Discovery []rdma.Option
+	// MinKernel defaults to doctor.DefaultMinKernel.
+	MinKernel string
+	// RequiredDevices overrides the device types each device must expose
+	// (default: the vendor quirk of its driver).
+	RequiredDevices []string
+	// SpecDirs are checked for specs out of step with the devices, and
+	// for specs changed since they were written, when the selector is zero
+	// (default: cdi.StandardSpecDirs()).
+	SpecDirs []string
+	// StateFile, when set, is the state file the specs are checked against
+	// in addition to their checksum annotations.
+	StateFile string
+}
+
+// Diagnose runs the host-wide checks and those of each selected device, as
+// the doctor command does, and returns the merged report. Check failures
+// are part of the report, not an error.
+func Diagnose(ctx context.Context, opts DiagnoseOptions) (*doctor.Report, error) {
+	d := rdma.NewDiscoverer(opts.Discovery...)
+	devices, err := Select(ctx, d, opts.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	minKernel := opts.MinKernel
+	if minKernel == "" {
+		minKernel = doctor.DefaultMinKernel
+	}
+	reports := []*doctor.Report{doctor.DiagnoseHost(doctor.WithMinKernel(minKernel), doctor.WithHostSysfsRoot(d.SysfsRoot()))}
+
+	diagOpts := []doctor.Option{doctor.WithSysfsRoot(d.SysfsRoot()), doctor.WithDevRoot(d.DevRoot())}
+	if len(opts.RequiredDevices) > 0 {
+		diagOpts = append(diagOpts, doctor.WithRequiredDevices(opts.RequiredDevices...))
+	}
+	for _, dev := range devices {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		reports = append(reports, doctor.DiagnoseDevice(dev, diagOpts...))
+	}
+
+	if opts.Selector.IsZero() {
+		dirs := opts.SpecDirs
+		if len(dirs) == 0 {
+			dirs = cdi.StandardSpecDirs()
+		}
+		reports = append(reports, doctor.DiagnoseSpecs(dirs, devices))
+		var state *cdi.State
+		if opts.StateFile != "" {
+			if state, err = cdi.LoadState(opts.StateFile); err != nil {
+				return nil, err
+			}
+		}
+		reports = append(reports, doctor.DiagnoseSpecIntegrity(dirs, state))
+	}
+	return doctor.MergeReports(reports...), nil
+}
+
+// CleanupOptions configures Cleanup.
+type CleanupOptions struct {
+	// Dirs default to cdi.DefaultOutputDir.
+	Dirs []string
+	// Prefix defaults to cdi.DefaultPrefix; an empty Name selects every
+	// spec of the prefix.
+	Prefix string
+	Name   string
+	// Orphans restricts removal to specs whose devices are all gone, as
+	// discovered with a discoverer configured by Discovery.
+	Orphans   bool
+	Discovery []rdma.Option
+	// Match restricts removal to specs whose devices it all matches. A
+	// device that is gone only has its pci field.
+	Match selector.Selector
+	// StateFile, when set, keeps specs modified since they were written
+	// (unless IncludeModified) and forgets the removed ones.
+	StateFile       string
+	IncludeModified bool
+	// BackupDir, when set, receives a backup of the specs before removal.
+	BackupDir string
+	DryRun    bool
+}
+
+// Cleanup removes spec files written by this tool. The summary lists what
+// was matched, removed and kept; the error joins the removal failures.
+func Cleanup(ctx context.Context, opts CleanupOptions) (*cdi.CleanupSummary, error) {
+	dirs := opts.Dirs
+	if len(dirs) == 0 {
+		dirs = []string{cdi.DefaultOutputDir}
+	}
+	prefix := opts.Prefix
+	if prefix == "" {
+		prefix = cdi.DefaultPrefix
+	}
+
+	var devices []*types.RdmaDevice
+	var present map[string]bool
+	if opts.Orphans || !opts.Match.IsZero() {
+		var err error
+		devices, err = rdma.NewDiscoverer(opts.Discovery...).DiscoverAll()
+		if err != nil && !errors.Is(err, rdma.ErrNoRdmaDevices) {
+			return nil, err
+		}
+		present = make(map[string]bool, len(devices))
+		for _, dev := range devices {
+			present[dev.PciAddress] = true
+		}
+	}
+	var candidates []string
+	for _, dir := range dirs {
+		var files []string
+		var err error
+		if opts.Orphans {
+			files, err = cdi.FindOrphanSpecs(dir, prefix, opts.Name, func(pci string) bool { return present[pci] })
+		} else {
+			files, err = cdi.ListSpecs(dir, prefix, opts.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, files...)
+	}
+	if !opts.Match.IsZero() {
+		candidates = cdi.SelectSpecs(candidates, opts.Match.PCIMatcher(devices))
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var state *cdi.State
+	if opts.StateFile != "" {
+		var err error
+		if state, err = cdi.LoadState(opts.StateFile); err != nil {
+			return nil, err
+		}
+	}
+	summary := &cdi.CleanupSummary{DryRun: opts.DryRun}
+	for _, f := range candidates {
+		if state != nil && !opts.IncludeModified {
+			if changed, err := state.Modified(f); changed || err != nil {
+				summary.Modified = append(summary.Modified, f)
+				continue
+			}
+		}
+		summary.Matched = append(summary.Matched, f)
+	}
+	if len(summary.Matched) == 0 {
+		return summary, nil
+	}
+	if opts.DryRun {
+		summary.Skipped = summary.Matched
+		return summary, nil
+	}
+
+	if opts.BackupDir != "" {
+		id, err := cdi.BackupSpecs(opts.BackupDir, summary.Matched)
+		if err != nil {
+			return summary, fmt.Errorf("backup failed, nothing removed: %w", err)
+		}
+		summary.BackupID = id
+	}
+	var errs []error
+	for _, f := range summary.Matched {
+		if _, err := cdi.RemoveSpecs([]string{f}, false); err != nil {
+			errs = append(errs, err)
+			summary.Errors = append(summary.Errors, err.Error())
+			summary.Skipped = append(summary.Skipped, f)
+			continue
+		}
+		summary.Removed = append(summary.Removed, f)
+	}
+	if state != nil && len(summary.Removed) > 0 {
+		err := cdi.UpdateState(opts.StateFile, func(state *cdi.State) error {
+			for _, f := range summary.Removed {
+				state.Forget(f)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Warnf("cannot update state file: %v", err)
+		}
+	}
+	return summary, errors.Join(errs...)
+}
@@ -0,0 +1,189 @@
+package rdmacdi
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Nativu5/rdma-cdi/pkg/cdi"
+	"github.com/Nativu5/rdma-cdi/pkg/rdma"
+	"github.com/Nativu5/rdma-cdi/pkg/rdma/rdmatest"
+	"github.com/Nativu5/rdma-cdi/pkg/selector"
+)
+
+func mustParse(t *testing.T, exprs ...string) selector.Selector {
+	t.Helper()
+	s, err := selector.Parse(exprs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func fixture(t *testing.T) []rdma.Option {
+	t.Helper()
+	tree := rdmatest.MustBuildTree(t, filepath.Join("..", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+	return []rdma.Option{rdma.WithSysfsRoot(tree.SysfsRoot), rdma.WithDevRoot(tree.DevRoot), rdma.WithOffline()}
+}
+
+func TestDiscover(t *testing.T) {
+	opts := fixture(t)
+	tests := []struct {
+		name string
+		sel  Selector
+		want []string
+	}{
+		{"all", Selector{}, []string{"0000:17:00.0", "0000:17:00.2", "0000:41:00.0"}},
+		{"targets", Selector{PciAddresses: []string{"0000:41:00.0"}, IfNames: []string{"enp23s0f0np0", "enp65s0np0"}},
+			[]string{"0000:41:00.0", "0000:17:00.0"}},
+		{"driver", Selector{Match: mustParse(t, "driver=mlx5_core"), PciAddresses: []string{"0000:17:00.2"}}, []string{"0000:17:00.2"}},
+		{"numa", Selector{Match: mustParse(t, "numa=1")}, []string{"0000:41:00.0"}},
+		{"vf", Selector{Match: mustParse(t, "vendor=15b3,vf!=true")}, []string{"0000:17:00.0", "0000:41:00.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devices, err := Discover(context.Background(), tt.sel, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, dev := range devices {
+				got = append(got, dev.PciAddress)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Discover() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Discover() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	_, err := Discover(context.Background(), Selector{Match: mustParse(t, "driver=irdma")}, opts...)
+	if !errors.Is(err, rdma.ErrNoRdmaDevices) {
+		t.Errorf("Discover() with no match: error = %v, want ErrNoRdmaDevices", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Discover(ctx, Selector{}, opts...); !errors.Is(err, context.Canceled) {
+		t.Errorf("Discover() canceled: error = %v", err)
+	}
+}
+
+func TestGenerateSpec(t *testing.T) {
+	spec, err := GenerateSpec(context.Background(), GenerateOptions{
+		Selector:  Selector{PciAddresses: []string{"0000:17:00.0"}},
+		Discovery: fixture(t),
+		Name:      "net",
+		Spec:      []cdi.Option{cdi.WithAliases()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Kind != "rdma/net" {
+		t.Errorf("Kind = %q, want rdma/net", spec.Kind)
+	}
+	var names []string
+	for _, dev := range spec.Devices {
+		names = append(names, dev.Name)
+	}
+	if len(names) != 3 || names[0] != "0000:17:00.0" {
+		t.Errorf("devices = %v, want the PCI address and two aliases", names)
+	}
+
+	if _, err := GenerateSpec(context.Background(), GenerateOptions{Discovery: fixture(t)}); err == nil {
+		t.Error("GenerateSpec() without a name should fail")
+	}
+}
+
+func TestStoreSpec(t *testing.T) {
+	store := cdi.NewMemStore()
+	spec, err := StoreSpec(context.Background(), GenerateOptions{Discovery: fixture(t), Prefix: "example.com/rdma", Name: "all"}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Get(context.Background(), "example.com/rdma/all")
+	if err != nil || len(got.Devices) != len(spec.Devices) || len(got.Devices) != 3 {
+		t.Errorf("stored spec = %+v, %v, want the 3 devices of the fixture", got, err)
+	}
+}
+
+func TestWriteSpecAndCleanup(t *testing.T) {
+	opts := fixture(t)
+	dir := t.TempDir()
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	path, err := WriteSpec(context.Background(), WriteOptions{
+		GenerateOptions: GenerateOptions{Selector: Selector{IfNames: []string{"enp65s0np0"}}, Discovery: opts, Name: "b"},
+		OutputDir:       dir,
+		StateFile:       stateFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, "rdma-cdi_rdma_b.yaml") {
+		t.Errorf("path = %s", path)
+	}
+	if _, err := WriteSpec(context.Background(), WriteOptions{
+		GenerateOptions: GenerateOptions{Discovery: opts, Name: "all"},
+		OutputDir:       dir,
+		StateFile:       stateFile,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Edited by hand, so Cleanup leaves it alone
+	if err := os.WriteFile(path, []byte("kind: rdma/b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := Cleanup(context.Background(), CleanupOptions{Dirs: []string{dir}, StateFile: stateFile, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Matched) != 1 || len(summary.Removed) != 0 || len(summary.Modified) != 1 || summary.Modified[0] != path {
+		t.Errorf("dry run summary = %+v", summary)
+	}
+
+	summary, err = Cleanup(context.Background(), CleanupOptions{Dirs: []string{dir}, Discovery: opts,
+		Match: mustParse(t, "vendor=15b3"), StateFile: stateFile, IncludeModified: true, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The edited spec lost its devices, so nothing matches them
+	if len(summary.Matched) != 1 || summary.Matched[0] != filepath.Join(dir, "rdma-cdi_rdma_all.yaml") {
+		t.Errorf("selected dry run summary = %+v", summary)
+	}
+
+	summary, err = Cleanup(context.Background(), CleanupOptions{Dirs: []string{dir}, StateFile: stateFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Removed) != 1 || summary.Removed[0] != filepath.Join(dir, "rdma-cdi_rdma_all.yaml") {
+		t.Errorf("summary = %+v", summary)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("modified spec removed: %v", err)
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	report, err := Diagnose(context.Background(), DiagnoseOptions{
+		Selector:  Selector{PciAddresses: []string{"0000:41:00.0"}},
+		Discovery: fixture(t),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := make(map[string]bool)
+	for _, r := range report.Results {
+		devices[r.Device] = true
+	}
+	if !devices["0000:41:00.0"] || devices["0000:17:00.0"] {
+		t.Errorf("report covers %v, want only 0000:41:00.0 and host checks", devices)
+	}
+}
@@ -0,0 +1,319 @@
+// Package reconcile keeps the CDI specs of a host in step with its RDMA
+// devices: a Controller writes a spec for each device a policy selects
+// when it appears or changes, and deletes it when the device goes away.
+// Programs such as node agents embed it with their own cdi.SpecStore.
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
+
+	"github.com/Nativu5/rdma-cdi/pkg/cdi"
+	"github.com/Nativu5/rdma-cdi/pkg/rdma"
+	"github.com/Nativu5/rdma-cdi/pkg/selector"
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+	"github.com/Nativu5/rdma-cdi/pkg/utils"
+)
+
+// Source discovers the devices to reconcile and reports their hot-plug
+// changes. *rdma.Discoverer is a Source.
+type Source interface {
+	DiscoverAll() ([]*types.RdmaDevice, error)
+	WatchDevices(ctx context.Context) (<-chan rdma.DeviceEvent, error)
+}
+
+// Profile says how to generate the specs of the devices it matches.
+type Profile struct {
+	// Name identifies the profile in logs.
+	Name string
+	// Match selects the devices of the profile; the zero selector
+	// matches every device.
+	Match selector.Selector
+	// Prefix is the vendor part of the spec kinds, cdi.DefaultPrefix by
+	// default. Each device gets its own spec, prefix/pci-<address>, named
+	// as by generate --all.
+	Prefix string
+	// Spec customizes the specs, e.g. cdi.WithAliases.
+	Spec []cdi.Option
+}
+
+func (p *Profile) prefix() string {
+	if p.Prefix == "" {
+		return cdi.DefaultPrefix
+	}
+	return p.Prefix
+}
+
+// Policy decides which devices get a spec and how. Each device gets the
+// first profile it matches, and no spec if it matches none. A policy
+// without profiles gives every device a spec with the defaults.
+type Policy struct {
+	Profiles []Profile
+}
+
+// ProfileFor returns the profile of dev, or nil if dev gets no spec.
+func (p Policy) ProfileFor(dev *types.RdmaDevice) *Profile {
+	if len(p.Profiles) == 0 {
+		return &Profile{}
+	}
+	for i := range p.Profiles {
+		if p.Profiles[i].Match.MatchesDevice(dev) {
+			return &p.Profiles[i]
+		}
+	}
+	return nil
+}
+
+// prefixes returns the spec kind prefixes the policy writes.
+func (p Policy) prefixes() []string {
+	if len(p.Profiles) == 0 {
+		return []string{cdi.DefaultPrefix}
+	}
+	var out []string
+	for i := range p.Profiles {
+		if prefix := p.Profiles[i].prefix(); !slices.Contains(out, prefix) {
+			out = append(out, prefix)
+		}
+	}
+	return out
+}
+
+// Action is what the controller did to a spec.
+type Action string
+
+const (
+	// ActionPut is a spec written for a new or changed device.
+	ActionPut Action = "put"
+	// ActionDelete is a spec removed with its device.
+	ActionDelete Action = "delete"
+)
+
+// Result reports one change the controller made to the store, or failed
+// to make when Err is set.
+type Result struct {
+	Action     Action
+	Kind       string
+	PciAddress string
+	// Profile is the name of the profile of a written spec.
+	Profile string
+	Err     error
+}
+
+// Option configures a Controller.
+type Option func(*Controller)
+
+// WithResultHandler has the controller pass every Result to fn, e.g. to
+// publish events. Results are logged either way.
+func WithResultHandler(fn func(Result)) Option {
+	return func(c *Controller) {
+		c.onResult = fn
+	}
+}
+
+// Controller reconciles the specs in a cdi.SpecStore against the devices
+// of a Source. Its methods are safe for concurrent use.
+type Controller struct {
+	source   Source
+	store    cdi.SpecStore
+	policy   Policy
+	onResult func(Result)
+
+	mu sync.Mutex
+	// kinds maps the PCI address of each device with a spec to its kind
+	kinds map[string]string
+}
+
+// NewController returns a controller writing the specs policy asks for
+// the devices of source to store, by default a cdi.DirStore of
+// cdi.DefaultOutputDir.
+func NewController(source Source, store cdi.SpecStore, policy Policy, opts ...Option) *Controller {
+	if store == nil {
+		store = &cdi.DirStore{}
+	}
+	c := &Controller{
+		source: source,
+		store:  store,
+		policy: policy,
+		kinds:  make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Run syncs the store once and then applies every device event until ctx
+// is done, when it returns nil. It only fails if the devices cannot be
+// watched; failures to update the store are logged and reported as
+// Results, and retried by the next event of the device.
+func (c *Controller) Run(ctx context.Context) error {
+	// Watch first, so no change between the sync and the watch is lost
+	events, err := c.source.WatchDevices(ctx)
+	if err != nil {
+		return err
+	}
+	if err := c.Sync(ctx); err != nil {
+		log.Warnf("Initial CDI spec sync: %v", err)
+	}
+	for ev := range events {
+		c.Handle(ctx, ev)
+	}
+	return nil
+}
+
+// Sync discovers every device and brings the store in line: it puts the
+// spec of each device the policy selects, unless the stored one is
+// current, and deletes the per-device specs of the policy's prefixes that
+// belong to no device. Other specs under those prefixes, such as the
+// group and rdma_cm specs of generate, are left alone. The spec of a
+// device whose discovery failed is kept as is.
+func (c *Controller) Sync(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	devices, err := c.source.DiscoverAll()
+	if err != nil && !errors.Is(err, rdma.ErrNoRdmaDevices) {
+		return err
+	}
+
+	var errs []error
+	keep := make(map[string]bool)
+	for _, dev := range devices {
+		if dev.Error != "" {
+			for _, prefix := range c.policy.prefixes() {
+				keep[prefix+"/"+specName(dev)] = true
+			}
+			continue
+		}
+		if err := c.apply(ctx, dev); err != nil {
+			errs = append(errs, err)
+		}
+		if kind, ok := c.kinds[dev.PciAddress]; ok {
+			keep[kind] = true
+		}
+	}
+
+	kinds, err := c.store.List(ctx)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	for _, kind := range kinds {
+		i := strings.LastIndex(kind, "/")
+		if keep[kind] || i < 0 || !slices.Contains(c.policy.prefixes(), kind[:i]) || !specNameRe.MatchString(kind[i+1:]) {
+			continue
+		}
+		if err := c.delete(ctx, kind, ""); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Handle applies one device event to the store.
+func (c *Controller) Handle(ctx context.Context, ev rdma.DeviceEvent) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ev.Type == rdma.DeviceRemoved {
+		kind, ok := c.kinds[ev.Device.PciAddress]
+		if !ok {
+			return nil
+		}
+		return c.delete(ctx, kind, ev.Device.PciAddress)
+	}
+	if ev.Device.Error != "" {
+		return nil
+	}
+	return c.apply(ctx, ev.Device)
+}
+
+// apply puts the spec policy asks for dev, deleting the spec it had under
+// another kind, or deletes its spec if the policy no longer selects it.
+func (c *Controller) apply(ctx context.Context, dev *types.RdmaDevice) error {
+	old, hadSpec := c.kinds[dev.PciAddress]
+	profile := c.policy.ProfileFor(dev)
+	if profile == nil {
+		if hadSpec {
+			return c.delete(ctx, old, dev.PciAddress)
+		}
+		return nil
+	}
+
+	spec, err := cdi.BuildSpec(profile.prefix(), specName(dev), []types.RdmaDevice{*dev}, profile.Spec...)
+	if err != nil {
+		return c.report(Result{Action: ActionPut, Kind: profile.prefix() + "/" + specName(dev), PciAddress: dev.PciAddress, Profile: profile.Name, Err: err})
+	}
+	if hadSpec && old != spec.Kind {
+		if err := c.delete(ctx, old, dev.PciAddress); err != nil {
+			return err
+		}
+	}
+	c.kinds[dev.PciAddress] = spec.Kind
+	if current, err := c.store.Get(ctx, spec.Kind); err == nil && sameSpec(current, spec) {
+		return nil
+	}
+	err = c.store.Put(ctx, spec)
+	return c.report(Result{Action: ActionPut, Kind: spec.Kind, PciAddress: dev.PciAddress, Profile: profile.Name, Err: err})
+}
+
+// delete removes the spec of kind, which belonged to the device at
+// pciAddr if known.
+func (c *Controller) delete(ctx context.Context, kind, pciAddr string) error {
+	err := c.store.Delete(ctx, kind)
+	if err == nil {
+		for pci, k := range c.kinds {
+			if k == kind {
+				delete(c.kinds, pci)
+			}
+		}
+	}
+	return c.report(Result{Action: ActionDelete, Kind: kind, PciAddress: pciAddr, Err: err})
+}
+
+// report logs r, passes it to the result handler and returns its error.
+func (c *Controller) report(r Result) error {
+	l := log.WithField("spec", r.Kind)
+	if r.Profile != "" {
+		l = l.WithField("profile", r.Profile)
+	}
+	switch {
+	case r.Err != nil && r.Action == ActionPut:
+		l.Warnf("Cannot write CDI spec %s: %v", r.Kind, r.Err)
+	case r.Err != nil:
+		l.Warnf("Cannot delete CDI spec %s: %v", r.Kind, r.Err)
+	case r.Action == ActionPut:
+		l.Infof("Wrote CDI spec %s", r.Kind)
+	default:
+		l.Infof("Deleted CDI spec %s", r.Kind)
+	}
+	if c.onResult != nil {
+		c.onResult(r)
+	}
+	return r.Err
+}
+
+// specNameRe matches the names specName gives, the only specs Sync
+// deletes without a device.
+var specNameRe = regexp.MustCompile(`^pci-[0-9a-f]{4,8}-[0-9a-f]{2}-[0-9a-f]{2}-[0-7]$`)
+
+// specName returns the resource name of the spec of dev.
+func specName(dev *types.RdmaDevice) string {
+	return utils.SanitizeName("pci-" + dev.PciAddress)
+}
+
+// sameSpec reports whether a and b say the same, however they are laid out.
+func sameSpec(a, b *cdiSpecs.Spec) bool {
+	sa, err := cdi.SpecChecksum(a)
+	if err != nil {
+		return false
+	}
+	sb, err := cdi.SpecChecksum(b)
+	return err == nil && sa == sb
+}
@@ -0,0 +1,196 @@
+package reconcile
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	cdiSpecs "tags.cncf.io/container-device-interface/specs-go"
+
+	"github.com/Nativu5/rdma-cdi/pkg/cdi"
+	"github.com/Nativu5/rdma-cdi/pkg/rdma"
+	"github.com/Nativu5/rdma-cdi/pkg/rdma/rdmatest"
+	"github.com/Nativu5/rdma-cdi/pkg/selector"
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// fakeSource discovers the cx5-sriov fixture and emits the events sent
+// on its channel.
+type fakeSource struct {
+	*rdma.Discoverer
+	events chan rdma.DeviceEvent
+}
+
+func newFakeSource(t *testing.T) *fakeSource {
+	t.Helper()
+	tree := rdmatest.MustBuildTree(t, filepath.Join("..", "rdma", "rdmatest", "testdata", "cx5-sriov.yaml"))
+	return &fakeSource{
+		Discoverer: rdma.NewDiscoverer(rdma.WithSysfsRoot(tree.SysfsRoot), rdma.WithDevRoot(tree.DevRoot), rdma.WithOffline()),
+		events:     make(chan rdma.DeviceEvent),
+	}
+}
+
+func (s *fakeSource) WatchDevices(ctx context.Context) (<-chan rdma.DeviceEvent, error) {
+	out := make(chan rdma.DeviceEvent)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-s.events:
+				out <- ev
+			}
+		}
+	}()
+	return out, nil
+}
+
+func mustParse(t *testing.T, exprs ...string) selector.Selector {
+	t.Helper()
+	s, err := selector.Parse(exprs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// testPolicy gives NUMA node 1 its own prefix and the other PFs the
+// default one; the VF gets no spec.
+func testPolicy(t *testing.T) Policy {
+	return Policy{Profiles: []Profile{
+		{Name: "numa1", Match: mustParse(t, "numa=1"), Prefix: "rdma-numa1"},
+		{Name: "pf", Match: mustParse(t, "vf!=true"), Spec: []cdi.Option{cdi.WithAliases()}},
+	}}
+}
+
+func storedKinds(t *testing.T, store cdi.SpecStore) []string {
+	t.Helper()
+	kinds, err := store.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kinds
+}
+
+// putSpec stores a spec of a single device 0000:99:00.0.
+func putSpec(t *testing.T, store cdi.SpecStore, prefix, name string) *cdiSpecs.Spec {
+	t.Helper()
+	dev := types.RdmaDevice{PciAddress: "0000:99:00.0", DeviceSpecs: []types.DeviceSpec{{HostPath: "/dev/infiniband/uverbs9", ContainerPath: "/dev/infiniband/uverbs9", Permissions: "rw"}}}
+	spec, err := cdi.BuildSpec(prefix, name, []types.RdmaDevice{dev})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+func TestController_Sync(t *testing.T) {
+	ctx := context.Background()
+	store := &cdi.DirStore{Dir: t.TempDir()}
+	// A spec left behind by a removed card, one of another prefix, and
+	// group and rdma_cm specs written by generate
+	putSpec(t, store, "rdma", "pci-0000-99-00-0")
+	putSpec(t, store, "other", "x")
+	putSpec(t, store, "rdma", "infiniband")
+	putSpec(t, store, "rdma", "rdma_cm")
+
+	var results []Result
+	c := NewController(newFakeSource(t), store, testPolicy(t), WithResultHandler(func(r Result) { results = append(results, r) }))
+	if err := c.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"other/x", "rdma-numa1/pci-0000-41-00-0", "rdma/infiniband", "rdma/pci-0000-17-00-0", "rdma/rdma_cm"}
+	if got := storedKinds(t, store); !slices.Equal(got, want) {
+		t.Errorf("kinds after Sync() = %v, want %v", got, want)
+	}
+	wantResults := []Result{
+		{Action: ActionPut, Kind: "rdma/pci-0000-17-00-0", PciAddress: "0000:17:00.0", Profile: "pf"},
+		{Action: ActionPut, Kind: "rdma-numa1/pci-0000-41-00-0", PciAddress: "0000:41:00.0", Profile: "numa1"},
+		{Action: ActionDelete, Kind: "rdma/pci-0000-99-00-0"},
+	}
+	if !slices.Equal(results, wantResults) {
+		t.Errorf("results = %+v, want %+v", results, wantResults)
+	}
+
+	// Current specs are not rewritten
+	results = nil
+	if err := c.Sync(ctx); err != nil || len(results) != 0 {
+		t.Errorf("a second Sync() = %v with results %+v, want nothing to do", err, results)
+	}
+}
+
+func TestController_Handle(t *testing.T) {
+	ctx := context.Background()
+	store := &cdi.DirStore{Dir: t.TempDir()}
+	src := newFakeSource(t)
+	c := NewController(src, store, testPolicy(t))
+	if err := c.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Moving to NUMA node 1 moves the spec to the other profile's kind
+	dev, err := src.DiscoverByPCI("0000:17:00.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.NumaNode = 1
+	if err := c.Handle(ctx, rdma.DeviceEvent{Type: rdma.DeviceChanged, Device: dev}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"rdma-numa1/pci-0000-17-00-0", "rdma-numa1/pci-0000-41-00-0"}
+	if got := storedKinds(t, store); !slices.Equal(got, want) {
+		t.Errorf("kinds after a change = %v, want %v", got, want)
+	}
+
+	if err := c.Handle(ctx, rdma.DeviceEvent{Type: rdma.DeviceRemoved, Device: dev}); err != nil {
+		t.Fatal(err)
+	}
+	// The VF matches no profile, so neither adding nor removing it writes
+	vf, err := src.DiscoverByPCI("0000:17:00.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Handle(ctx, rdma.DeviceEvent{Type: rdma.DeviceAdded, Device: vf})
+	c.Handle(ctx, rdma.DeviceEvent{Type: rdma.DeviceRemoved, Device: vf})
+	if got := storedKinds(t, store); !slices.Equal(got, want[1:]) {
+		t.Errorf("kinds after the removal = %v, want %v", got, want[1:])
+	}
+}
+
+func TestController_Run(t *testing.T) {
+	store := cdi.NewMemStore()
+	src := newFakeSource(t)
+	results := make(chan Result, 8)
+	c := NewController(src, store, Policy{}, WithResultHandler(func(r Result) { results <- r }))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- c.Run(ctx) }()
+	for range 3 {
+		<-results // the initial sync writes a spec for each device
+	}
+
+	dev, err := src.DiscoverByPCI("0000:41:00.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.events <- rdma.DeviceEvent{Type: rdma.DeviceRemoved, Device: dev}
+	select {
+	case r := <-results:
+		if r.Action != ActionDelete || r.Kind != "rdma/pci-0000-41-00-0" || r.Err != nil {
+			t.Errorf("result of the removal = %+v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the removal was not applied")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run() = %v, want nil once cancelled", err)
+	}
+}
@@ -0,0 +1,195 @@
+// Package selector implements the device selector expressions the
+// subcommands share, e.g. "vendor=15b3,numa=1,linktype=ether".
+//
+// An expression is a comma-separated list of terms. A term is key=pattern
+// or key!=pattern, where pattern is a glob as in path.Match. A device
+// matches when, for each key with = terms, any of them matches, and none
+// of the != terms matches. Terms of several expressions combine the same
+// way, so repeating --selector narrows the selection like one long one.
+package selector
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// Keys a term may use.
+const (
+	KeyPCI      = "pci"
+	KeyIfName   = "ifname"
+	KeyIbDev    = "ibdev"
+	KeyVendor   = "vendor"
+	KeyDevice   = "device"
+	KeyDriver   = "driver"
+	KeyLinkType = "linktype"
+	KeyNuma     = "numa"
+	// KeyVF is "true" for SR-IOV virtual functions, "false" otherwise.
+	KeyVF = "vf"
+	// KeyPF is the PCI address of a VF's parent PF.
+	KeyPF = "pf"
+)
+
+// Keys lists the valid keys in the order help texts show them.
+var Keys = []string{KeyPCI, KeyIfName, KeyIbDev, KeyVendor, KeyDevice, KeyDriver, KeyLinkType, KeyNuma, KeyVF, KeyPF}
+
+// aliases maps alternative spellings to keys.
+var aliases = map[string]string{
+	"link-type": KeyLinkType,
+	"device-id": KeyDevice,
+	"interface": KeyIfName,
+}
+
+// Term is one key=pattern or key!=pattern condition.
+type Term struct {
+	Key     string
+	Pattern string
+	Negate  bool
+}
+
+func (t Term) String() string {
+	if t.Negate {
+		return t.Key + "!=" + t.Pattern
+	}
+	return t.Key + "=" + t.Pattern
+}
+
+// Selector is a parsed selector. The zero value matches every device.
+type Selector struct {
+	terms []Term
+}
+
+// Parse parses selector expressions.
+func Parse(exprs ...string) (Selector, error) {
+	var s Selector
+	for _, expr := range exprs {
+		for _, raw := range strings.Split(expr, ",") {
+			if strings.TrimSpace(raw) == "" {
+				continue
+			}
+			t, err := parseTerm(raw)
+			if err != nil {
+				return Selector{}, err
+			}
+			s.terms = append(s.terms, t)
+		}
+	}
+	return s, nil
+}
+
+func parseTerm(raw string) (Term, error) {
+	var t Term
+	key, pattern, ok := strings.Cut(raw, "=")
+	if !ok {
+		return t, fmt.Errorf("invalid selector term %q: want key=value or key!=value", raw)
+	}
+	if strings.HasSuffix(key, "!") {
+		key, t.Negate = strings.TrimSuffix(key, "!"), true
+	}
+	key, pattern = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(pattern)
+	if alias, ok := aliases[key]; ok {
+		key = alias
+	}
+	if !slices.Contains(Keys, key) {
+		return t, fmt.Errorf("invalid selector key %q: use %s", key, strings.Join(Keys, ", "))
+	}
+	if pattern == "" {
+		return t, fmt.Errorf("invalid selector term %q: empty value", raw)
+	}
+	if key == KeyVendor || key == KeyDevice {
+		// Accept the 0x15b3 spelling of lspci -n and friends
+		pattern = strings.TrimPrefix(strings.ToLower(pattern), "0x")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return t, fmt.Errorf("invalid selector pattern %q: %w", pattern, err)
+	}
+	t.Key, t.Pattern = key, pattern
+	return t, nil
+}
+
+// IsZero reports whether the selector has no terms.
+func (s Selector) IsZero() bool {
+	return len(s.terms) == 0
+}
+
+// Terms returns the terms of the selector.
+func (s Selector) Terms() []Term {
+	return slices.Clone(s.terms)
+}
+
+func (s Selector) String() string {
+	parts := make([]string, len(s.terms))
+	for i, t := range s.terms {
+		parts[i] = t.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// Fields are the values of a device the terms are matched against. A
+// missing key has the value "".
+type Fields map[string]string
+
+// DeviceFields returns the fields of dev.
+func DeviceFields(dev *types.RdmaDevice) Fields {
+	f := Fields{
+		KeyPCI:      dev.PciAddress,
+		KeyIfName:   dev.IfName,
+		KeyIbDev:    dev.IbDev,
+		KeyVendor:   dev.Vendor,
+		KeyDevice:   dev.DeviceID,
+		KeyDriver:   dev.Driver,
+		KeyLinkType: dev.LinkType,
+		KeyVF:       strconv.FormatBool(dev.IsVF),
+		KeyPF:       dev.ParentPFAddress,
+	}
+	if dev.NumaNode >= 0 {
+		f[KeyNuma] = strconv.Itoa(dev.NumaNode)
+	}
+	return f
+}
+
+// Matches reports whether fields satisfy the selector.
+func (s Selector) Matches(fields Fields) bool {
+	wanted := make(map[string]bool)
+	for _, t := range s.terms {
+		matched, _ := path.Match(t.Pattern, fields[t.Key])
+		switch {
+		case t.Negate && matched:
+			return false
+		case !t.Negate:
+			wanted[t.Key] = wanted[t.Key] || matched
+		}
+	}
+	for _, ok := range wanted {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchesDevice reports whether dev satisfies the selector.
+func (s Selector) MatchesDevice(dev *types.RdmaDevice) bool {
+	return s.Matches(DeviceFields(dev))
+}
+
+// PCIMatcher returns a function reporting whether the device at a PCI
+// address satisfies the selector, taking its fields from devices. An
+// address missing from devices, e.g. of a device that is gone, only has
+// its pci field.
+func (s Selector) PCIMatcher(devices []*types.RdmaDevice) func(pci string) bool {
+	byPCI := make(map[string]*types.RdmaDevice, len(devices))
+	for _, dev := range devices {
+		byPCI[dev.PciAddress] = dev
+	}
+	return func(pci string) bool {
+		if dev, ok := byPCI[pci]; ok {
+			return s.MatchesDevice(dev)
+		}
+		return s.Matches(Fields{KeyPCI: pci})
+	}
+}
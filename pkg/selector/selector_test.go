@@ -0,0 +1,91 @@
+package selector
+
+import (
+	"testing"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		exprs   []string
+		want    string
+		wantErr bool
+	}{
+		{"empty", nil, "", false},
+		{"terms", []string{"vendor=0x15B3, numa=1", "linktype!=ether"}, "vendor=15b3,numa=1,linktype!=ether", false},
+		{"aliases", []string{"link-type=infiniband,device-id=1017"}, "linktype=infiniband,device=1017", false},
+		{"glob", []string{"ifname=enp23s0f*"}, "ifname=enp23s0f*", false},
+		{"no operator", []string{"driver"}, "", true},
+		{"empty value", []string{"driver="}, "", true},
+		{"unknown key", []string{"color=blue"}, "", true},
+		{"bad pattern", []string{"pci=[0000"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.exprs...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.String() != tt.want {
+				t.Errorf("Parse() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesDevice(t *testing.T) {
+	pf := &types.RdmaDevice{PciAddress: "0000:17:00.0", IfName: "enp23s0f0np0", Vendor: "15b3", DeviceID: "1017",
+		Driver: "mlx5_core", LinkType: "ether", NumaNode: 0}
+	vf := &types.RdmaDevice{PciAddress: "0000:17:00.2", IfName: "enp23s0f0v0", Vendor: "15b3", DeviceID: "1018",
+		Driver: "mlx5_core", LinkType: "ether", NumaNode: -1, IsVF: true, ParentPFAddress: pf.PciAddress}
+
+	tests := []struct {
+		expr   string
+		pf, vf bool
+	}{
+		{"", true, true},
+		{"vendor=15b3,numa=0", true, false},
+		{"device=1017,device=1018", true, true},
+		{"device=1017,driver=irdma", false, false},
+		{"vf=false", true, false},
+		{"pf=0000:17:00.0", false, true},
+		{"ifname=enp23s0f0*,ifname!=*v*", true, false},
+		{"linktype!=infiniband", true, true},
+		{"numa!=0", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.MatchesDevice(pf); got != tt.pf {
+				t.Errorf("PF match = %v, want %v", got, tt.pf)
+			}
+			if got := s.MatchesDevice(vf); got != tt.vf {
+				t.Errorf("VF match = %v, want %v", got, tt.vf)
+			}
+		})
+	}
+}
+
+func TestPCIMatcher(t *testing.T) {
+	s, err := Parse("driver=mlx5_core")
+	if err != nil {
+		t.Fatal(err)
+	}
+	match := s.PCIMatcher([]*types.RdmaDevice{{PciAddress: "0000:17:00.0", Driver: "mlx5_core"}})
+	if !match("0000:17:00.0") || match("0000:41:00.0") {
+		t.Error("a present device should match by its driver, a missing one only by address")
+	}
+
+	s, err = Parse("pci=0000:41:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.PCIMatcher(nil)("0000:41:00.0") {
+		t.Error("a missing device should still match by address")
+	}
+}
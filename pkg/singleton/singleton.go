@@ -0,0 +1,153 @@
+// Package singleton keeps two long-running instances of the tool, such as
+// a systemd unit and a debug run, from managing the same directory: each
+// takes an exclusive flock on a lock file named after the directory. Wait
+// serializes short critical sections, like rewriting one file, the same way.
+package singleton
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultLockDir is where root instances keep their lock files.
+const DefaultLockDir = "/run/rdma-cdi"
+
+// ErrAlreadyRunning is returned by Acquire when another instance holds
+// the lock of a directory.
+var ErrAlreadyRunning = errors.New("another instance is already running")
+
+// ErrLockTimeout is returned by Wait when another process holds a lock
+// for longer than the timeout.
+var ErrLockTimeout = errors.New("timed out waiting for a lock")
+
+// pollInterval is how often Wait retries a held lock.
+const pollInterval = 50 * time.Millisecond
+
+// Lock is a set of held directory locks, released by Release or when the
+// process exits.
+type Lock struct {
+	files []*os.File
+}
+
+// LockDir returns where lock files live: DefaultLockDir for root, and
+// $XDG_RUNTIME_DIR/rdma-cdi (or the temporary directory) otherwise.
+func LockDir() string {
+	if os.Geteuid() == 0 {
+		return DefaultLockDir
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "rdma-cdi")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("rdma-cdi-%d", os.Geteuid()))
+}
+
+// Acquire locks each of dirs with a lock file under lockDir, without
+// waiting. It fails with ErrAlreadyRunning, naming the holder's PID when
+// known, if another instance holds one of the locks; locks taken so far
+// are released then.
+func Acquire(lockDir string, dirs ...string) (*Lock, error) {
+	return acquire(lockDir, time.Time{}, ErrAlreadyRunning, dirs)
+}
+
+// Wait locks each of paths, files or directories, like Acquire, but waits
+// up to timeout for locks held by another process. It fails with
+// ErrLockTimeout then; a timeout of 0 does not wait at all.
+func Wait(lockDir string, timeout time.Duration, paths ...string) (*Lock, error) {
+	return acquire(lockDir, time.Now().Add(timeout), ErrLockTimeout, paths)
+}
+
+func acquire(lockDir string, deadline time.Time, errHeld error, paths []string) (*Lock, error) {
+	if err := os.MkdirAll(lockDir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create lock directory: %w", err)
+	}
+
+	// A fixed order, so two instances never deadlock on each other's locks
+	var names []string
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		if name := lockName(abs); !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	l := &Lock{}
+	for _, name := range names {
+		f, err := lockFile(filepath.Join(lockDir, name), deadline, errHeld)
+		if err != nil {
+			l.Release()
+			return nil, err
+		}
+		l.files = append(l.files, f)
+	}
+	return l, nil
+}
+
+// Release drops the locks. The lock files stay, so a process that opened
+// one meanwhile locks the same inode.
+func (l *Lock) Release() {
+	for _, f := range l.files {
+		f.Close()
+	}
+	l.files = nil
+}
+
+// lockName maps a path to its lock file name the way systemd escapes
+// paths, e.g. "/etc/cdi" to "etc-cdi.lock".
+func lockName(dir string) string {
+	name := strings.ReplaceAll(strings.Trim(dir, "/"), "/", "-")
+	if name == "" {
+		name = "-"
+	}
+	return name + ".lock"
+}
+
+// lockFile locks path, retrying until deadline while another process
+// holds it, and fails with errHeld after that.
+func lockFile(path string, deadline time.Time, errHeld error) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open lock file: %w", err)
+	}
+	for {
+		err = unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if !errors.Is(err, unix.EWOULDBLOCK) || !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(min(pollInterval, time.Until(deadline)))
+	}
+	if err != nil {
+		defer f.Close()
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			if pid := holder(f); pid != "" {
+				return nil, fmt.Errorf("%w (PID %s holds %s)", errHeld, pid, path)
+			}
+			return nil, fmt.Errorf("%w (%s is held)", errHeld, path)
+		}
+		return nil, fmt.Errorf("cannot lock %s: %w", path, err)
+	}
+
+	// Record the holder for the error message of the next instance
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return f, nil
+}
+
+// holder returns the PID recorded in a lock file, or "".
+func holder(f *os.File) string {
+	buf := make([]byte, 32)
+	n, _ := f.ReadAt(buf, 0)
+	return strings.TrimSpace(string(buf[:n]))
+}
@@ -0,0 +1,102 @@
+package singleton
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcquire_RefusesSecondInstance(t *testing.T) {
+	lockDir := t.TempDir()
+
+	first, err := Acquire(lockDir, "/etc/cdi", "/var/run/cdi")
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	// flock locks belong to the open file, so a second open in the same
+	// process conflicts just as another process would
+	_, err = Acquire(lockDir, "/var/run/cdi")
+	if !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatalf("expected ErrAlreadyRunning, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "PID "+strconv.Itoa(os.Getpid())) {
+		t.Errorf("expected the holder's PID in %q", err)
+	}
+
+	// Other directories are not affected
+	other, err := Acquire(lockDir, "/tmp/cdi")
+	if err != nil {
+		t.Fatalf("Acquire of another directory: %v", err)
+	}
+	other.Release()
+
+	first.Release()
+	second, err := Acquire(lockDir, "/var/run/cdi/")
+	if err != nil {
+		t.Fatalf("Acquire after Release: %v", err)
+	}
+	second.Release()
+}
+
+func TestAcquire_ReleasesOnFailure(t *testing.T) {
+	lockDir := t.TempDir()
+	held, err := Acquire(lockDir, "/var/run/cdi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Release()
+
+	// /etc/cdi sorts first and is locked before /var/run/cdi fails
+	if _, err := Acquire(lockDir, "/var/run/cdi", "/etc/cdi"); !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatalf("expected ErrAlreadyRunning, got %v", err)
+	}
+	l, err := Acquire(lockDir, "/etc/cdi")
+	if err != nil {
+		t.Fatalf("/etc/cdi should have been released: %v", err)
+	}
+	l.Release()
+}
+
+func TestWait(t *testing.T) {
+	lockDir := t.TempDir()
+	const spec = "/etc/cdi/rdma-cdi_rdma_pci-0000-17-00-0.yaml"
+	held, err := Wait(lockDir, 0, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := Wait(lockDir, 120*time.Millisecond, spec); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected ErrLockTimeout, got %v", err)
+	}
+	if waited := time.Since(start); waited < 120*time.Millisecond {
+		t.Errorf("Wait gave up after %v, before its timeout", waited)
+	}
+
+	// The lock is taken as soon as the holder lets go
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		held.Release()
+	}()
+	l, err := Wait(lockDir, 5*time.Second, spec)
+	if err != nil {
+		t.Fatalf("Wait should get the released lock: %v", err)
+	}
+	l.Release()
+}
+
+func TestLockName(t *testing.T) {
+	for dir, want := range map[string]string{
+		"/etc/cdi":     "etc-cdi.lock",
+		"/var/run/cdi": "var-run-cdi.lock",
+		"/":            "-.lock",
+	} {
+		if got := lockName(filepath.Clean(dir)); got != want {
+			t.Errorf("lockName(%q) = %q, want %q", dir, got, want)
+		}
+	}
+}
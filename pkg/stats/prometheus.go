@@ -0,0 +1,104 @@
+package stats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// TextfileName is the file PrintPrometheus output is written to in a
+// node_exporter textfile collector directory.
+const TextfileName = "rdma-cdi.prom"
+
+// GuardTextfileName is the file PrintGuardPrometheus output is written to,
+// next to TextfileName.
+const GuardTextfileName = "rdma-cdi-guard.prom"
+
+// PrintPrometheus renders the counters of ports and inventory gauges of
+// devices in the Prometheus text exposition format, as read by the
+// node_exporter textfile collector. now is exported as the time of the
+// run, so stale files can be alerted on.
+func PrintPrometheus(w io.Writer, devices []*types.RdmaDevice, ports []PortStats, now time.Time) error {
+	bw := bufio.NewWriter(w)
+
+	header(bw, "rdma_cdi_device_info", "gauge", "RDMA device inventory, always 1.")
+	for _, dev := range devices {
+		sample(bw, "rdma_cdi_device_info", "1",
+			"pci_address", dev.PciAddress, "ibdev", dev.IbDev, "interface", dev.IfName,
+			"driver", dev.Driver, "link_type", dev.LinkType, "vendor", dev.Vendor,
+			"device_id", dev.DeviceID, "model", dev.ModelName, "is_vf", strconv.FormatBool(dev.IsVF))
+	}
+	header(bw, "rdma_cdi_device_char_devices", "gauge", "Number of RDMA character devices of the device.")
+	for _, dev := range devices {
+		sample(bw, "rdma_cdi_device_char_devices", strconv.Itoa(len(dev.RdmaDevices)), "pci_address", dev.PciAddress)
+	}
+
+	// Port counters only increase; some hw_counters are settings (e.g.
+	// lifespan), so those are untyped
+	header(bw, "rdma_cdi_port_counter_total", "counter", "RDMA port counter from <ibdev>/ports/<port>/counters.")
+	for _, p := range ports {
+		counters(bw, "rdma_cdi_port_counter_total", p, p.Counters)
+	}
+	header(bw, "rdma_cdi_port_hw_counter", "untyped", "Driver-specific RDMA port counter from <ibdev>/ports/<port>/hw_counters.")
+	for _, p := range ports {
+		counters(bw, "rdma_cdi_port_hw_counter", p, p.HwCounters)
+	}
+
+	header(bw, "rdma_cdi_stats_timestamp_seconds", "gauge", "Unix time the counters were read.")
+	sample(bw, "rdma_cdi_stats_timestamp_seconds", strconv.FormatInt(now.Unix(), 10))
+	return bw.Flush()
+}
+
+// PrintGuardPrometheus renders the counts of spec files guard restored
+// and failed to restore since it started, as exported at now.
+func PrintGuardPrometheus(w io.Writer, restored, failed uint64, now time.Time) error {
+	bw := bufio.NewWriter(w)
+	header(bw, "rdma_cdi_guard_restores_total", "counter", "Spec files modified or removed outside rdma-cdi, by whether guard restored them.")
+	sample(bw, "rdma_cdi_guard_restores_total", strconv.FormatUint(restored, 10), "result", "ok")
+	sample(bw, "rdma_cdi_guard_restores_total", strconv.FormatUint(failed, 10), "result", "failed")
+	header(bw, "rdma_cdi_guard_timestamp_seconds", "gauge", "Unix time guard last wrote its metrics.")
+	sample(bw, "rdma_cdi_guard_timestamp_seconds", strconv.FormatInt(now.Unix(), 10))
+	return bw.Flush()
+}
+
+func header(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func counters(w io.Writer, name string, p PortStats, values map[string]uint64) {
+	names := make([]string, 0, len(values))
+	for counter := range values {
+		names = append(names, counter)
+	}
+	slices.Sort(names)
+	for _, counter := range names {
+		sample(w, name, strconv.FormatUint(values[counter], 10),
+			"pci_address", p.PciAddress, "ibdev", p.IbDev, "port", strconv.Itoa(p.Port), "counter", counter)
+	}
+}
+
+// sample writes one sample; labels are name/value pairs.
+func sample(w io.Writer, name, value string, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(w, "%s %s\n", b.String(), value)
+}
+
+// labelEscaper escapes label values as the exposition format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
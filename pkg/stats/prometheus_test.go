@@ -0,0 +1,63 @@
+package stats
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+func TestPrintPrometheus(t *testing.T) {
+	devices := []*types.RdmaDevice{{
+		PciAddress:  "0000:17:00.0",
+		IbDev:       "mlx5_0",
+		Driver:      "mlx5_core",
+		ModelName:   `Odd "Model"`,
+		RdmaDevices: []string{"/dev/infiniband/uverbs0", "/dev/infiniband/rdma_cm"},
+	}}
+	ports := []PortStats{{
+		PciAddress: "0000:17:00.0",
+		IbDev:      "mlx5_0",
+		Port:       1,
+		Counters:   map[string]uint64{"port_xmit_data": 123456789012345},
+		HwCounters: map[string]uint64{"np_cnp_sent": 7},
+	}}
+
+	var buf bytes.Buffer
+	if err := PrintPrometheus(&buf, devices, ports, time.Unix(1700000000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE rdma_cdi_port_counter_total counter\n",
+		`rdma_cdi_port_counter_total{pci_address="0000:17:00.0",ibdev="mlx5_0",port="1",counter="port_xmit_data"} 123456789012345` + "\n",
+		`rdma_cdi_port_hw_counter{pci_address="0000:17:00.0",ibdev="mlx5_0",port="1",counter="np_cnp_sent"} 7` + "\n",
+		`model="Odd \"Model\""`,
+		`rdma_cdi_device_char_devices{pci_address="0000:17:00.0"} 2` + "\n",
+		"rdma_cdi_stats_timestamp_seconds 1700000000\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output should contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestPrintGuardPrometheus(t *testing.T) {
+	var buf bytes.Buffer
+	if err := PrintGuardPrometheus(&buf, 3, 1, time.Unix(1700000000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE rdma_cdi_guard_restores_total counter\n",
+		`rdma_cdi_guard_restores_total{result="ok"} 3` + "\n",
+		`rdma_cdi_guard_restores_total{result="failed"} 1` + "\n",
+		"rdma_cdi_guard_timestamp_seconds 1700000000\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output should contain %q, got:\n%s", want, out)
+		}
+	}
+}
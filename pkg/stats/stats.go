@@ -0,0 +1,217 @@
+// Package stats reads and formats the per-port RDMA counters for the stats
+// subcommand.
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// sysInfiniband is the RDMA device class directory relative to the sysfs root.
+const sysInfiniband = "class/infiniband"
+
+// KeyCounters are the counters the table shows by default, in order, when
+// the device reports them: traffic, errors, retransmissions and congestion
+// notification. The port_*_data counters count 4-octet words.
+var KeyCounters = []string{
+	"port_xmit_data",
+	"port_rcv_data",
+	"port_xmit_packets",
+	"port_rcv_packets",
+	"port_xmit_discards",
+	"port_rcv_errors",
+	"link_downed",
+	"out_of_sequence",
+	"packet_seq_err",
+	"local_ack_timeout_err",
+	"roce_adp_retrans",
+	"rnr_nak_retry_err",
+	"np_cnp_sent",
+	"rp_cnp_handled",
+	"np_ecn_marked_roce_packets",
+}
+
+// PortStats holds the counters of one port of an RDMA device.
+type PortStats struct {
+	PciAddress string `json:"pci_address"`
+	IbDev      string `json:"ibdev"`
+	Port       int    `json:"port"`
+	// Counters are the standard InfiniBand port counters
+	// (<ibdev>/ports/<port>/counters).
+	Counters map[string]uint64 `json:"counters"`
+	// HwCounters are the driver-specific counters
+	// (<ibdev>/ports/<port>/hw_counters), e.g. mlx5 retransmission and
+	// CNP counters.
+	HwCounters map[string]uint64 `json:"hw_counters,omitempty"`
+	// Rates are the per-second changes of the counters since the previous
+	// sample, keyed like Counters and HwCounters. Only set by SetRates.
+	Rates map[string]float64 `json:"rates,omitempty"`
+}
+
+// Read returns the counters of every port of the RDMA devices of devices,
+// reading <sysfsRoot>/class/infiniband. Devices without an RDMA device name
+// are skipped; counter files that cannot be read or parsed are left out.
+func Read(sysfsRoot string, devices []*types.RdmaDevice) ([]PortStats, error) {
+	var out []PortStats
+	for _, dev := range devices {
+		if dev.IbDev == "" {
+			continue
+		}
+		portsDir := filepath.Join(sysfsRoot, sysInfiniband, dev.IbDev, "ports")
+		entries, err := os.ReadDir(portsDir)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read counters of %s: %w", dev.IbDev, err)
+		}
+		var ports []int
+		for _, e := range entries {
+			if port, err := strconv.Atoi(e.Name()); err == nil {
+				ports = append(ports, port)
+			}
+		}
+		slices.Sort(ports)
+		for _, port := range ports {
+			dir := filepath.Join(portsDir, strconv.Itoa(port))
+			out = append(out, PortStats{
+				PciAddress: dev.PciAddress,
+				IbDev:      dev.IbDev,
+				Port:       port,
+				Counters:   readCounters(filepath.Join(dir, "counters")),
+				HwCounters: readCounters(filepath.Join(dir, "hw_counters")),
+			})
+		}
+	}
+	return out, nil
+}
+
+// readCounters reads every numeric file in dir.
+func readCounters(dir string) map[string]uint64 {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	counters := make(map[string]uint64, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			continue
+		}
+		counters[e.Name()] = v
+	}
+	return counters
+}
+
+// SetRates sets the Rates of each port in cur from its counters in prev,
+// sampled elapsed earlier. Counters that went backwards, e.g. after a
+// driver reload, get no rate.
+func SetRates(prev, cur []PortStats, elapsed time.Duration) {
+	if elapsed <= 0 {
+		return
+	}
+	type key struct {
+		ibdev string
+		port  int
+	}
+	before := make(map[key]*PortStats, len(prev))
+	for i := range prev {
+		before[key{prev[i].IbDev, prev[i].Port}] = &prev[i]
+	}
+	for i := range cur {
+		p, ok := before[key{cur[i].IbDev, cur[i].Port}]
+		if !ok {
+			continue
+		}
+		rates := make(map[string]float64)
+		for _, pair := range [][2]map[string]uint64{{p.Counters, cur[i].Counters}, {p.HwCounters, cur[i].HwCounters}} {
+			for name, v := range pair[1] {
+				if old, ok := pair[0][name]; ok && v >= old {
+					rates[name] = float64(v-old) / elapsed.Seconds()
+				}
+			}
+		}
+		cur[i].Rates = rates
+	}
+}
+
+// PrintTable renders the counters one per row: the KeyCounters each port
+// reports, or all of them when all is set, naming devices as f does. A PER
+// SECOND column is added when the ports carry rates.
+func PrintTable(w io.Writer, ports []PortStats, all bool, f types.DeviceIDFormat) {
+	withRates := slices.ContainsFunc(ports, func(p PortStats) bool { return p.Rates != nil })
+	table := tablewriter.NewTable(w)
+	if withRates {
+		table.Header("DEVICE", "PORT", "COUNTER", "VALUE", "PER SECOND")
+	} else {
+		table.Header("DEVICE", "PORT", "COUNTER", "VALUE")
+	}
+	for _, p := range ports {
+		for _, name := range counterNames(p, all) {
+			v, ok := p.Counters[name]
+			if !ok {
+				v = p.HwCounters[name]
+			}
+			row := []any{f.Label(p.PciAddress, p.IbDev, ""), p.Port, name, v}
+			if withRates {
+				rate := "-"
+				if r, ok := p.Rates[name]; ok {
+					rate = strconv.FormatFloat(r, 'f', 1, 64)
+				}
+				row = append(row, rate)
+			}
+			table.Append(row...)
+		}
+	}
+	table.Render()
+}
+
+// counterNames returns the counters of p to show, KeyCounters first.
+func counterNames(p PortStats, all bool) []string {
+	var names []string
+	for _, name := range KeyCounters {
+		if _, ok := p.Counters[name]; ok {
+			names = append(names, name)
+		} else if _, ok := p.HwCounters[name]; ok {
+			names = append(names, name)
+		}
+	}
+	if !all {
+		return names
+	}
+	var rest []string
+	for _, m := range []map[string]uint64{p.Counters, p.HwCounters} {
+		for name := range m {
+			if !slices.Contains(names, name) && !slices.Contains(rest, name) {
+				rest = append(rest, name)
+			}
+		}
+	}
+	slices.Sort(rest)
+	return append(names, rest...)
+}
+
+// PrintJSON renders the counters of every port.
+func PrintJSON(w io.Writer, ports []PortStats) error {
+	if ports == nil {
+		ports = []PortStats{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ports)
+}
@@ -0,0 +1,80 @@
+package stats
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nativu5/rdma-cdi/pkg/types"
+)
+
+// writeCounters writes counter files of mlx5_0 port 1 under a fake sysfs.
+func writeCounters(t *testing.T, root, group string, counters map[string]string) {
+	t.Helper()
+	dir := filepath.Join(root, sysInfiniband, "mlx5_0", "ports", "1", group)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range counters {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadAndSetRates(t *testing.T) {
+	root := t.TempDir()
+	writeCounters(t, root, "counters", map[string]string{"port_xmit_data": "1000\n", "port_rcv_data": "50\n", "bogus": "n/a\n"})
+	writeCounters(t, root, "hw_counters", map[string]string{"out_of_sequence": "3\n", "np_cnp_sent": "7\n"})
+	devices := []*types.RdmaDevice{
+		{PciAddress: "0000:17:00.0", IbDev: "mlx5_0"},
+		{PciAddress: "0000:17:00.2"}, // no RDMA device name: skipped
+	}
+
+	prev, err := Read(root, devices)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(prev) != 1 || prev[0].Port != 1 || prev[0].Counters["port_xmit_data"] != 1000 ||
+		prev[0].HwCounters["out_of_sequence"] != 3 {
+		t.Fatalf("Read() = %+v", prev)
+	}
+	if _, ok := prev[0].Counters["bogus"]; ok {
+		t.Error("non-numeric counter should be skipped")
+	}
+
+	writeCounters(t, root, "counters", map[string]string{"port_xmit_data": "3000\n", "port_rcv_data": "10\n"})
+	writeCounters(t, root, "hw_counters", map[string]string{"out_of_sequence": "5\n"})
+	cur, err := Read(root, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetRates(prev, cur, 2*time.Second)
+	if got := cur[0].Rates["port_xmit_data"]; got != 1000 {
+		t.Errorf("port_xmit_data rate = %v, want 1000", got)
+	}
+	if got := cur[0].Rates["out_of_sequence"]; got != 1 {
+		t.Errorf("out_of_sequence rate = %v, want 1", got)
+	}
+	if _, ok := cur[0].Rates["port_rcv_data"]; ok {
+		t.Error("a counter that went backwards should have no rate")
+	}
+
+	var buf bytes.Buffer
+	PrintTable(&buf, cur, false, types.DeviceIDIbdev)
+	out := buf.String()
+	for _, want := range []string{"PER SECOND", "port_xmit_data", "out_of_sequence", "1000.0"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("table should contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestRead_MissingDevice(t *testing.T) {
+	if _, err := Read(t.TempDir(), []*types.RdmaDevice{{IbDev: "mlx5_9"}}); err == nil {
+		t.Error("Read() should fail for a device without ports")
+	}
+}
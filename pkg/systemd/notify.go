@@ -0,0 +1,50 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Service states sent with Notify, as sd_notify(3) names them.
+const (
+	Ready    = "READY=1"
+	Watchdog = "WATCHDOG=1"
+	Stopping = "STOPPING=1"
+)
+
+// Notify sends state, e.g. Ready, to the service manager over
+// $NOTIFY_SOCKET, as sd_notify does. It does nothing outside a
+// Type=notify service, where the variable is unset.
+func Notify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+	// A leading '@' names a socket in the abstract namespace
+	if name[0] == '@' {
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// WatchdogInterval returns how often a service should send Watchdog: half
+// of the timeout in $WATCHDOG_USEC, as sd_watchdog_enabled(3) advises, or
+// 0 if the watchdog is not enabled for this process.
+func WatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,141 @@
+// Package systemd renders and installs the systemd units that generate CDI
+// specs at boot, before container runtimes start, and guard them
+// afterwards, and lets such long-running services notify systemd.
+package systemd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Nativu5/rdma-cdi/pkg/utils"
+)
+
+// DefaultUnitFile is where Install writes the unit by default.
+const DefaultUnitFile = "/etc/systemd/system/rdma-cdi.service"
+
+// DefaultGuardUnitFile is where the guard unit is installed by default.
+const DefaultGuardUnitFile = "/etc/systemd/system/rdma-cdi-guard.service"
+
+// header marks unit files written by this package.
+const header = "# Generated by rdma-cdi systemd install.\n"
+
+// ErrInvalidUnit is returned when a unit cannot be rendered.
+var ErrInvalidUnit = errors.New("invalid systemd unit")
+
+// Unit renders a oneshot service that runs binary, the absolute path of
+// rdma-cdi, with args (e.g. global flags followed by "generate --all").
+// It is ordered after module loading and before the common container
+// runtimes, so their first CDI refresh already sees the specs; ordering
+// against runtimes that are not installed is a no-op.
+func Unit(binary string, args []string) (string, error) {
+	cmdline, err := execStart(binary, args)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString(header)
+	b.WriteString(`[Unit]
+Description=Generate CDI specs for RDMA devices
+Documentation=https://github.com/Nativu5/rdma-cdi
+After=systemd-modules-load.service systemd-udev-trigger.service
+Before=containerd.service crio.service docker.service podman.service kubelet.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+`)
+	fmt.Fprintf(&b, "ExecStart=%s\n", cmdline)
+	b.WriteString(`
+[Install]
+WantedBy=multi-user.target
+`)
+	return b.String(), nil
+}
+
+// GuardUnit renders a Type=notify service that runs binary with args
+// (e.g. global flags followed by "guard") after the unit of Unit. The
+// guard reports itself ready and pets the watchdog, and systemctl reload
+// sends it SIGHUP to re-read the state file.
+func GuardUnit(binary string, args []string) (string, error) {
+	cmdline, err := execStart(binary, args)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString(header)
+	fmt.Fprintf(&b, `[Unit]
+Description=Restore CDI specs for RDMA devices modified outside rdma-cdi
+Documentation=https://github.com/Nativu5/rdma-cdi
+Wants=%[1]s
+After=%[1]s
+
+[Service]
+Type=notify
+ExecStart=%[2]s
+ExecReload=/bin/kill -HUP $MAINPID
+WatchdogSec=30s
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`, filepath.Base(DefaultUnitFile), cmdline)
+	return b.String(), nil
+}
+
+// execStart renders the ExecStart= command line running binary with args.
+func execStart(binary string, args []string) (string, error) {
+	if !filepath.IsAbs(binary) {
+		return "", fmt.Errorf("%w: binary path %q is not absolute", ErrInvalidUnit, binary)
+	}
+	words := make([]string, 0, len(args)+1)
+	for _, s := range append([]string{binary}, args...) {
+		if strings.ContainsAny(s, "\n\r") {
+			return "", fmt.Errorf("%w: %q contains a newline", ErrInvalidUnit, s)
+		}
+		words = append(words, quote(s))
+	}
+	return strings.Join(words, " "), nil
+}
+
+// quote escapes s for an ExecStart= command line: systemd expands '%'
+// specifiers and '$' variables, and splits unquoted words on whitespace.
+func quote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	s = strings.ReplaceAll(s, "$", "$$")
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;") {
+		return s
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// Install writes unit to path, creating its directory if needed. An
+// existing file is only replaced if this package wrote it, unless force
+// is set.
+func Install(path, unit string, force bool) error {
+	existing, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if !force && !bytes.HasPrefix(existing, []byte(header)) {
+			return fmt.Errorf("%s exists and was not written by rdma-cdi (use --force to replace it)", path)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	if err := utils.WriteFileAtomic(path, []byte(unit), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
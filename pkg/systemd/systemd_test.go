@@ -0,0 +1,108 @@
+package systemd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnit(t *testing.T) {
+	unit, err := Unit("/usr/bin/rdma-cdi", []string{"--host-root", "/host", "generate", "--all"})
+	if err != nil {
+		t.Fatalf("Unit() error = %v", err)
+	}
+	for _, want := range []string{
+		"ExecStart=/usr/bin/rdma-cdi --host-root /host generate --all\n",
+		"Type=oneshot\n",
+		"WantedBy=multi-user.target\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("unit missing %q:\n%s", want, unit)
+		}
+	}
+	if !strings.HasPrefix(unit, header) {
+		t.Errorf("unit does not start with the header:\n%s", unit)
+	}
+}
+
+func TestGuardUnit(t *testing.T) {
+	unit, err := GuardUnit("/usr/bin/rdma-cdi", []string{"--host-root", "/host", "guard", "--all-dirs"})
+	if err != nil {
+		t.Fatalf("GuardUnit() error = %v", err)
+	}
+	for _, want := range []string{
+		"ExecStart=/usr/bin/rdma-cdi --host-root /host guard --all-dirs\n",
+		"Type=notify\n",
+		"ExecReload=/bin/kill -HUP $MAINPID\n",
+		"WatchdogSec=",
+		"After=rdma-cdi.service\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("unit missing %q:\n%s", want, unit)
+		}
+	}
+	if !strings.HasPrefix(unit, header) {
+		t.Errorf("unit does not start with the header:\n%s", unit)
+	}
+	if _, err := GuardUnit("rdma-cdi", nil); !errors.Is(err, ErrInvalidUnit) {
+		t.Errorf("relative binary: error = %v, want ErrInvalidUnit", err)
+	}
+}
+
+func TestUnit_Invalid(t *testing.T) {
+	if _, err := Unit("rdma-cdi", nil); !errors.Is(err, ErrInvalidUnit) {
+		t.Errorf("relative binary: error = %v, want ErrInvalidUnit", err)
+	}
+	if _, err := Unit("/usr/bin/rdma-cdi", []string{"a\nb"}); !errors.Is(err, ErrInvalidUnit) {
+		t.Errorf("newline: error = %v, want ErrInvalidUnit", err)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"--all", "--all"},
+		{"", `""`},
+		{"/opt/rdma cdi", `"/opt/rdma cdi"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{"50%", "50%%"},
+		{"$HOME", "$$HOME"},
+	}
+	for _, tt := range tests {
+		if got := quote(tt.in); got != tt.want {
+			t.Errorf("quote(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestInstall(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "system", "rdma-cdi.service")
+	unit, err := Unit("/usr/bin/rdma-cdi", []string{"generate", "--all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Install(path, unit, false); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != unit {
+		t.Errorf("installed unit = %q, want %q", got, unit)
+	}
+	if err := Install(path, unit, false); err != nil {
+		t.Errorf("reinstall error = %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("[Unit]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Install(path, unit, false); err == nil {
+		t.Error("Install() over a foreign unit succeeded, want error")
+	}
+	if err := Install(path, unit, true); err != nil {
+		t.Errorf("forced Install() error = %v", err)
+	}
+}
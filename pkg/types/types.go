@@ -1,41 +1,353 @@
 // Package types defines shared data types for the rdma-cdi tool.
 // These types replace upstream K8s pluginapi.DeviceSpec with plain Go structs,
 // eliminating all Kubernetes dependencies.
+//
+// RdmaDevice and DeviceSpec carry the canonical JSON/YAML form of the device
+// inventory, as printed by discover --output json and described by
+// InventorySchema.
 package types
 
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Nativu5/rdma-cdi/pkg/utils"
+)
+
+// ErrInvalidDevice is returned by the Validate methods.
+var ErrInvalidDevice = errors.New("invalid device")
+
+// InventorySchema is the JSON Schema (draft 2020-12) of a device inventory:
+// a JSON array of RdmaDevice.
+//
+//go:embed inventory.schema.json
+var InventorySchema []byte
+
 // DeviceSpec describes a host device to expose inside a container.
 // It mirrors the fields of k8s.io/kubelet pluginapi.DeviceSpec but
 // carries no Kubernetes dependency.
 type DeviceSpec struct {
 	// HostPath is the path of the device on the host (e.g. /dev/infiniband/uverbs0).
-	HostPath string
+	HostPath string `json:"host_path" yaml:"host_path"`
 	// ContainerPath is the path of the device inside the container.
-	ContainerPath string
+	ContainerPath string `json:"container_path" yaml:"container_path"`
 	// Permissions is the cgroup permissions for the device (e.g. "rw", "rwm").
-	Permissions string
+	Permissions string `json:"permissions" yaml:"permissions"`
+	// Type is the device node type ("c" for character devices).
+	// Empty when the device numbers could not be determined.
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Major and Minor are the device numbers of the host device node, or
+	// those the kernel reports in sysfs if it cannot be inspected.
+	// Only meaningful when Type is set.
+	Major int64 `json:"major,omitempty" yaml:"major,omitempty"`
+	Minor int64 `json:"minor,omitempty" yaml:"minor,omitempty"`
+	// UID and GID are the owner of the host device node, or nil if the
+	// node could not be inspected.
+	UID *uint32 `json:"uid,omitempty" yaml:"uid,omitempty"`
+	GID *uint32 `json:"gid,omitempty" yaml:"gid,omitempty"`
+}
+
+// Validate checks that both paths are absolute, that Permissions is a
+// non-empty combination of r, w and m, and that Type, if set, is a known
+// device node type.
+func (s *DeviceSpec) Validate() error {
+	for _, p := range []struct{ name, path string }{{"host path", s.HostPath}, {"container path", s.ContainerPath}} {
+		if !filepath.IsAbs(p.path) {
+			return fmt.Errorf("%w: %s %q is not absolute", ErrInvalidDevice, p.name, p.path)
+		}
+	}
+	if s.Permissions == "" || strings.Trim(s.Permissions, "rwm") != "" {
+		return fmt.Errorf("%w: permissions %q of %s: must be a combination of r, w and m", ErrInvalidDevice, s.Permissions, s.HostPath)
+	}
+	switch s.Type {
+	case "", "c", "b", "u", "p":
+	default:
+		return fmt.Errorf("%w: type %q of %s: must be c, b, u or p", ErrInvalidDevice, s.Type, s.HostPath)
+	}
+	return nil
 }
 
 // RdmaDevice represents a single RDMA-capable network device with its
 // associated PCI address and discovered character devices.
 type RdmaDevice struct {
 	// PciAddress is the PCI Bus-Device-Function address (e.g. "0000:17:00.0").
-	PciAddress string
+	PciAddress string `json:"pci_address" yaml:"pci_address"`
 	// IfName is the network interface name (e.g. "enp23s0f0np0", "enp65s0np0").
 	// May be empty if the device has no net interface.
-	IfName string
+	IfName string `json:"interface,omitempty" yaml:"interface,omitempty"`
+	// IfNames lists every network interface of the function, IfName
+	// first, for functions with more than one (e.g. one per port). Empty
+	// when the function has at most one.
+	IfNames []string `json:"interfaces,omitempty" yaml:"interfaces,omitempty"`
+	// IbDev is the RDMA device name registered on this function
+	// (e.g. "mlx5_0"). May be empty if none was reported.
+	IbDev string `json:"ibdev,omitempty" yaml:"ibdev,omitempty"`
+	// NodeGUID is the node GUID of IbDev (e.g. "0c42:a103:0065:4f3a").
+	// Together with PciAddress it identifies the hardware across
+	// interface renames and card swaps. Empty when unset or unknown.
+	NodeGUID string `json:"node_guid,omitempty" yaml:"node_guid,omitempty"`
 	// Vendor is the PCI vendor ID (e.g. "15b3" for Mellanox).
-	Vendor string
+	Vendor string `json:"vendor,omitempty" yaml:"vendor,omitempty"`
 	// DeviceID is the PCI device/product ID.
-	DeviceID string
+	DeviceID string `json:"device_id,omitempty" yaml:"device_id,omitempty"`
+	// ModelName is the human-readable model from the PCI IDs database
+	// (e.g. "Mellanox ConnectX-6 Dx"). Empty when the IDs are unknown.
+	ModelName string `json:"model_name,omitempty" yaml:"model_name,omitempty"`
+	// BoardID is the OEM board identifier of IbDev (e.g. "MT_0000000010"),
+	// which names the card's part number. Empty when the driver does not
+	// report one.
+	BoardID string `json:"board_id,omitempty" yaml:"board_id,omitempty"`
+	// Slot is the label of the physical PCIe slot holding the card (e.g.
+	// "3"), as the platform firmware names it. Empty when the platform
+	// does not describe its slots.
+	Slot string `json:"slot,omitempty" yaml:"slot,omitempty"`
 	// Driver is the kernel driver bound to this device (e.g. "mlx5_core").
-	Driver string
+	Driver string `json:"driver,omitempty" yaml:"driver,omitempty"`
 	// LinkType is the link encapsulation type (e.g. "infiniband", "ether").
-	LinkType string
+	LinkType string `json:"link_type,omitempty" yaml:"link_type,omitempty"`
+	// Rate is the active rate of the first port of IbDev as the kernel
+	// reports it (e.g. "100 Gb/sec (4X EDR)"). Empty when unknown.
+	Rate string `json:"rate,omitempty" yaml:"rate,omitempty"`
+	// NetnsMode is the host's RDMA subsystem netns mode, "shared" or
+	// "exclusive". In exclusive mode a container only sees the device once
+	// it is moved into the container's network namespace. Empty when it
+	// could not be queried.
+	NetnsMode string `json:"netns_mode,omitempty" yaml:"netns_mode,omitempty"`
+	// Addresses are the IPv4 and IPv6 addresses configured on the
+	// device's network interfaces, in CIDR notation (e.g.
+	// "192.0.2.10/24"). GID selection and rdma_cm depend on them.
+	Addresses []string `json:"addresses,omitempty" yaml:"addresses,omitempty"`
+	// NumaNode is the NUMA node the device is attached to, or -1 when the
+	// platform does not report one.
+	NumaNode int `json:"numa_node" yaml:"numa_node"`
+	// IsVF is true when the device is an SR-IOV virtual function.
+	IsVF bool `json:"is_vf,omitempty" yaml:"is_vf,omitempty"`
+	// VFIndex is the VF number N (the parent's virtfnN link).
+	// Only meaningful when IsVF is true.
+	VFIndex int `json:"vf_index" yaml:"vf_index"`
+	// ParentPFAddress is the PCI address of the physical function that owns
+	// this VF. Empty for physical functions.
+	ParentPFAddress string `json:"parent_pf,omitempty" yaml:"parent_pf,omitempty"`
 	// RdmaDevices is the list of RDMA character device paths
 	// (e.g. ["/dev/infiniband/uverbs0", "/dev/infiniband/rdma_cm"]).
-	RdmaDevices []string
+	RdmaDevices []string `json:"rdma_devices" yaml:"rdma_devices"`
 	// DeviceSpecs is the list of DeviceSpec entries derived from RdmaDevices.
-	DeviceSpecs []DeviceSpec
+	DeviceSpecs []DeviceSpec `json:"device_specs,omitempty" yaml:"device_specs,omitempty"`
+	// Ports lists the ports of an RDMA device with more than one (e.g. a
+	// dual-port ConnectX-3 function). Empty for single-port devices.
+	Ports []RdmaPort `json:"ports,omitempty" yaml:"ports,omitempty"`
+	// Bond describes the RoCE LAG (link aggregation) the device serves
+	// when its physical functions are bonded in hardware, e.g. mlx5 LAG,
+	// which registers one RDMA device for all of them. IfName is then the
+	// bond's interface. Nil for devices that are not bonded.
+	Bond *RdmaBond `json:"bond,omitempty" yaml:"bond,omitempty"`
+	// Usage is what currently uses the device, recorded only when asked
+	// for (discover --output wide). Nil otherwise.
+	Usage *RdmaUsage `json:"usage,omitempty" yaml:"usage,omitempty"`
+	// Attributes are vendor-specific facts recorded by discovery plugins,
+	// keyed under the vendor's prefix (e.g. "nvidia.com/gpus").
+	Attributes map[string]string `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+	// Warnings lists what discovery could not determine about the device
+	// (e.g. an unreadable driver symlink or a failed netlink query), so
+	// consumers know the fields they name are incomplete.
+	Warnings []DiscoveryWarning `json:"warnings,omitempty" yaml:"warnings,omitempty"`
+	// Error is why discovery of the device failed altogether (e.g. it
+	// timed out or panicked). Only PciAddress is meaningful then.
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// DiscoveryWarning is a piece of information discovery failed to gather
+// about a device.
+type DiscoveryWarning struct {
+	// Field is the JSON name of the RdmaDevice field left incomplete
+	// (e.g. "driver", "addresses").
+	Field string `json:"field" yaml:"field"`
+	// Message describes the failure.
+	Message string `json:"message" yaml:"message"`
+}
+
+// RdmaBond is a RoCE LAG: a bonding interface over the netdevs of
+// several physical functions that share one RDMA device.
+type RdmaBond struct {
+	// IfName is the bonding interface (e.g. "bond0").
+	IfName string `json:"interface" yaml:"interface"`
+	// Members are the bonded physical functions, by PCI address.
+	Members []BondMember `json:"members" yaml:"members"`
+}
+
+// RdmaUsage is what uses an RDMA device at the time of discovery.
+type RdmaUsage struct {
+	// ULPs are the kernel upper-layer protocols attached to the device:
+	// "ipoib", "srp" or "nvme-rdma".
+	ULPs []string `json:"ulps,omitempty" yaml:"ulps,omitempty"`
+	// VerbsUsers is the number of verbs contexts open on the device, i.e.
+	// of file descriptors open on its uverbs nodes.
+	VerbsUsers int `json:"verbs_users" yaml:"verbs_users"`
+	// PIDs are the processes holding those contexts.
+	PIDs []int `json:"pids,omitempty" yaml:"pids,omitempty"`
+}
+
+// BondMember is one physical function of an RdmaBond.
+type BondMember struct {
+	// PciAddress is the PCI address of the function (e.g. "0000:17:00.1").
+	PciAddress string `json:"pci_address" yaml:"pci_address"`
+	// IfName is the function's netdev enslaved to the bond.
+	IfName string `json:"interface" yaml:"interface"`
+}
+
+// RdmaPort is one port of a multi-port RDMA device.
+type RdmaPort struct {
+	// Number is the port number, starting at 1.
+	Number int `json:"port" yaml:"port"`
+	// IfName is the network interface of the port. May be empty.
+	IfName string `json:"interface,omitempty" yaml:"interface,omitempty"`
+	// RdmaDevices are the character device paths that belong to this port
+	// alone (umad, issm); the others are shared by all ports.
+	RdmaDevices []string `json:"rdma_devices,omitempty" yaml:"rdma_devices,omitempty"`
+}
+
+// Validate checks that PciAddress is a PCI address in canonical form
+// (e.g. "0000:17:00.0"), that discovery of the device did not fail, that
+// the character device paths are absolute, and
+// that every DeviceSpec is valid. Devices built by hand or read from a file
+// should be validated before specs are generated from them.
+func (d *RdmaDevice) Validate() error {
+	if d.PciAddress == "" {
+		return fmt.Errorf("%w: empty PCI address", ErrInvalidDevice)
+	}
+	if d.Error != "" {
+		return fmt.Errorf("%w: %s: discovery failed: %s", ErrInvalidDevice, d.PciAddress, d.Error)
+	}
+	canonical, err := utils.NormalizePCIAddress(d.PciAddress)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidDevice, err)
+	}
+	if canonical != d.PciAddress {
+		return fmt.Errorf("%w: PCI address %q is not in canonical form, use %q", ErrInvalidDevice, d.PciAddress, canonical)
+	}
+	for _, p := range d.RdmaDevices {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("%w: %s: RDMA device path %q is not absolute", ErrInvalidDevice, d.PciAddress, p)
+		}
+	}
+	for i := range d.DeviceSpecs {
+		if err := d.DeviceSpecs[i].Validate(); err != nil {
+			return fmt.Errorf("%s: %w", d.PciAddress, err)
+		}
+	}
+	return nil
+}
+
+// Clone returns a deep copy of d, sharing no slices, maps or pointers with
+// it, so discoverers can hand out devices that callers may modify freely.
+func (d *RdmaDevice) Clone() *RdmaDevice {
+	cp := *d
+	cp.IfNames = slices.Clone(d.IfNames)
+	cp.Addresses = slices.Clone(d.Addresses)
+	cp.RdmaDevices = slices.Clone(d.RdmaDevices)
+	cp.DeviceSpecs = slices.Clone(d.DeviceSpecs)
+	for i := range cp.DeviceSpecs {
+		spec := &cp.DeviceSpecs[i]
+		if spec.UID != nil {
+			uid := *spec.UID
+			spec.UID = &uid
+		}
+		if spec.GID != nil {
+			gid := *spec.GID
+			spec.GID = &gid
+		}
+	}
+	cp.Ports = slices.Clone(d.Ports)
+	for i := range cp.Ports {
+		cp.Ports[i].RdmaDevices = slices.Clone(cp.Ports[i].RdmaDevices)
+	}
+	if d.Bond != nil {
+		bond := *d.Bond
+		bond.Members = slices.Clone(d.Bond.Members)
+		cp.Bond = &bond
+	}
+	if d.Usage != nil {
+		usage := *d.Usage
+		usage.ULPs = slices.Clone(d.Usage.ULPs)
+		usage.PIDs = slices.Clone(d.Usage.PIDs)
+		cp.Usage = &usage
+	}
+	cp.Attributes = maps.Clone(d.Attributes)
+	cp.Warnings = slices.Clone(d.Warnings)
+	return &cp
+}
+
+// MarshalJSON omits vf_index for physical functions, where it has no
+// meaning, while keeping it for VF 0.
+func (d RdmaDevice) MarshalJSON() ([]byte, error) {
+	type plain RdmaDevice
+	out := struct {
+		plain
+		VFIndex *int `json:"vf_index,omitempty"`
+	}{plain: plain(d)}
+	if d.IsVF {
+		out.VFIndex = &d.VFIndex
+	}
+	return json.Marshal(out)
+}
+
+// DeviceIDFormat selects how human-readable outputs refer to a device.
+type DeviceIDFormat string
+
+const (
+	// DeviceIDAuto names a device by its RDMA device and PCI address, e.g.
+	// "mlx5_0 (0000:17:00.0)", or by the PCI address alone when it has no
+	// RDMA device.
+	DeviceIDAuto DeviceIDFormat = "auto"
+	// DeviceIDPCI names a device by its PCI address.
+	DeviceIDPCI DeviceIDFormat = "pci"
+	// DeviceIDIbdev names a device by its RDMA device, as rdma and
+	// ibv_devinfo do.
+	DeviceIDIbdev DeviceIDFormat = "ibdev"
+	// DeviceIDIfName names a device by its network interface.
+	DeviceIDIfName DeviceIDFormat = "ifname"
+)
+
+// ParseDeviceIDFormat parses a --device-id-format value.
+func ParseDeviceIDFormat(s string) (DeviceIDFormat, error) {
+	switch f := DeviceIDFormat(s); f {
+	case DeviceIDAuto, DeviceIDPCI, DeviceIDIbdev, DeviceIDIfName:
+		return f, nil
+	}
+	return "", fmt.Errorf("unsupported device ID format %q: use %s, %s, %s or %s", s, DeviceIDAuto, DeviceIDPCI, DeviceIDIbdev, DeviceIDIfName)
+}
+
+// Label returns how f refers to the device with the given identifiers.
+// The PCI address stands in for a missing RDMA device or interface name;
+// the empty format is DeviceIDAuto.
+func (f DeviceIDFormat) Label(pciAddr, ibdev, ifName string) string {
+	switch f {
+	case DeviceIDPCI:
+	case DeviceIDIbdev:
+		if ibdev != "" {
+			return ibdev
+		}
+	case DeviceIDIfName:
+		if ifName != "" {
+			return ifName
+		}
+	default:
+		if ibdev != "" && pciAddr != "" {
+			return ibdev + " (" + pciAddr + ")"
+		}
+	}
+	return pciAddr
+}
+
+// Label returns how f refers to d.
+func (d *RdmaDevice) Label(f DeviceIDFormat) string {
+	return f.Label(d.PciAddress, d.IbDev, d.IfName)
 }
 
 // RequiredRdmaDevices lists the RDMA character device types that must be
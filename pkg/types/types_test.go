@@ -0,0 +1,222 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+// jsonNames returns the JSON field names of struct type t.
+func jsonNames(t reflect.Type) []string {
+	var names []string
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// schemaNames returns the property names of a definition in InventorySchema.
+func schemaNames(t *testing.T, def string) []string {
+	t.Helper()
+	var schema struct {
+		Defs map[string]struct {
+			Properties map[string]any `json:"properties"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(InventorySchema, &schema); err != nil {
+		t.Fatalf("InventorySchema is not valid JSON: %v", err)
+	}
+	var names []string
+	for name := range schema.Defs[def].Properties {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestInventorySchema_MatchesTags(t *testing.T) {
+	tests := []struct {
+		def string
+		typ reflect.Type
+	}{
+		{"rdmaDevice", reflect.TypeOf(RdmaDevice{})},
+		{"deviceSpec", reflect.TypeOf(DeviceSpec{})},
+		{"rdmaPort", reflect.TypeOf(RdmaPort{})},
+		{"discoveryWarning", reflect.TypeOf(DiscoveryWarning{})},
+		{"rdmaBond", reflect.TypeOf(RdmaBond{})},
+		{"bondMember", reflect.TypeOf(BondMember{})},
+		{"rdmaUsage", reflect.TypeOf(RdmaUsage{})},
+	}
+	for _, tc := range tests {
+		t.Run(tc.def, func(t *testing.T) {
+			if got, want := schemaNames(t, tc.def), jsonNames(tc.typ); !slices.Equal(got, want) {
+				t.Errorf("schema properties = %v, struct tags = %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRdmaDevice_JSONRoundTrip(t *testing.T) {
+	uid := uint32(0)
+	devices := []RdmaDevice{
+		{PciAddress: "0000:17:00.0", IfName: "ib0", NumaNode: -1, RdmaDevices: []string{"/dev/infiniband/uverbs0"},
+			DeviceSpecs: []DeviceSpec{{HostPath: "/dev/infiniband/uverbs0", ContainerPath: "/dev/infiniband/uverbs0", Permissions: "rw", UID: &uid}}},
+		{PciAddress: "0000:17:00.2", IsVF: true, VFIndex: 0, ParentPFAddress: "0000:17:00.0", RdmaDevices: []string{}},
+	}
+
+	data, err := json.Marshal(devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var generic []map[string]any
+	if err := json.Unmarshal(data, &generic); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := generic[0]["vf_index"]; ok {
+		t.Errorf("PF should omit vf_index: %v", generic[0])
+	}
+	if generic[1]["vf_index"] != float64(0) {
+		t.Errorf("VF 0 should keep vf_index: %v", generic[1])
+	}
+
+	// YAML goes through the same tags
+	yamlData, err := yaml.Marshal(devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{data, yamlData} {
+		var got []RdmaDevice
+		if err := yaml.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, devices) {
+			t.Errorf("round trip = %+v, want %+v", got, devices)
+		}
+	}
+}
+
+func TestRdmaDevice_Validate(t *testing.T) {
+	valid := func() RdmaDevice {
+		return RdmaDevice{
+			PciAddress:  "0000:17:00.0",
+			RdmaDevices: []string{"/dev/infiniband/uverbs0"},
+			DeviceSpecs: []DeviceSpec{{HostPath: "/dev/infiniband/uverbs0", ContainerPath: "/dev/infiniband/uverbs0", Permissions: "rw", Type: "c"}},
+		}
+	}
+	tests := []struct {
+		name   string
+		modify func(*RdmaDevice)
+		want   string
+	}{
+		{"valid", func(*RdmaDevice) {}, ""},
+		{"empty_pci", func(d *RdmaDevice) { d.PciAddress = "" }, "empty PCI address"},
+		{"bad_pci", func(d *RdmaDevice) { d.PciAddress = "17-00-0" }, "invalid PCI address"},
+		{"short_pci", func(d *RdmaDevice) { d.PciAddress = "17:00.0" }, `use "0000:17:00.0"`},
+		{"relative_rdma_device", func(d *RdmaDevice) { d.RdmaDevices[0] = "uverbs0" }, "is not absolute"},
+		{"relative_host_path", func(d *RdmaDevice) { d.DeviceSpecs[0].HostPath = "dev/uverbs0" }, "host path"},
+		{"relative_container_path", func(d *RdmaDevice) { d.DeviceSpecs[0].ContainerPath = "" }, "container path"},
+		{"bad_permissions", func(d *RdmaDevice) { d.DeviceSpecs[0].Permissions = "rx" }, "permissions"},
+		{"empty_permissions", func(d *RdmaDevice) { d.DeviceSpecs[0].Permissions = "" }, "permissions"},
+		{"bad_type", func(d *RdmaDevice) { d.DeviceSpecs[0].Type = "x" }, "type"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := valid()
+			tc.modify(&d)
+			err := d.Validate()
+			if tc.want == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidDevice) || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("err = %v, want ErrInvalidDevice mentioning %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestDeviceIDFormat_Label(t *testing.T) {
+	dev := &RdmaDevice{PciAddress: "0000:17:00.0", IbDev: "mlx5_0", IfName: "ens1f0"}
+	bare := &RdmaDevice{PciAddress: "0000:18:00.0"}
+	tests := []struct {
+		format DeviceIDFormat
+		dev    *RdmaDevice
+		want   string
+	}{
+		{DeviceIDAuto, dev, "mlx5_0 (0000:17:00.0)"},
+		{"", dev, "mlx5_0 (0000:17:00.0)"},
+		{DeviceIDPCI, dev, "0000:17:00.0"},
+		{DeviceIDIbdev, dev, "mlx5_0"},
+		{DeviceIDIfName, dev, "ens1f0"},
+		{DeviceIDAuto, bare, "0000:18:00.0"},
+		{DeviceIDIbdev, bare, "0000:18:00.0"},
+		{DeviceIDIfName, bare, "0000:18:00.0"},
+	}
+	for _, tt := range tests {
+		if got := tt.dev.Label(tt.format); got != tt.want {
+			t.Errorf("Label(%q) of %s = %q, want %q", tt.format, tt.dev.PciAddress, got, tt.want)
+		}
+	}
+	if _, err := ParseDeviceIDFormat("guid"); err == nil {
+		t.Error("ParseDeviceIDFormat(guid) succeeded")
+	}
+}
+
+func TestRdmaDeviceClone(t *testing.T) {
+	id := uint32(0)
+	full := func() *RdmaDevice {
+		return &RdmaDevice{
+			PciAddress:  "0000:17:00.0",
+			IfNames:     []string{"bond0"},
+			Addresses:   []string{"192.0.2.1/24"},
+			RdmaDevices: []string{"/dev/infiniband/uverbs0"},
+			DeviceSpecs: []DeviceSpec{{HostPath: "/dev/infiniband/uverbs0", UID: &id, GID: &id}},
+			Ports:       []RdmaPort{{Number: 1, RdmaDevices: []string{"/dev/infiniband/umad0"}}},
+			Bond:        &RdmaBond{Members: []BondMember{{PciAddress: "0000:17:00.1"}}},
+			Usage:       &RdmaUsage{ULPs: []string{"ib_ipoib"}, PIDs: []int{1}},
+			Attributes:  map[string]string{"k": "v"},
+			Warnings:    []DiscoveryWarning{{Field: "driver"}},
+		}
+	}
+	orig := full()
+	// A field the fixture leaves empty is a field Clone may have missed
+	v := reflect.ValueOf(orig).Elem()
+	for i := range v.NumField() {
+		switch f := v.Field(i); f.Kind() {
+		case reflect.Slice, reflect.Map, reflect.Pointer:
+			if f.IsNil() {
+				t.Errorf("set %s in this test and deep-copy it in Clone", v.Type().Field(i).Name)
+			}
+		}
+	}
+
+	cp := orig.Clone()
+	if !reflect.DeepEqual(cp, orig) {
+		t.Fatalf("Clone() = %+v, want %+v", cp, orig)
+	}
+	cp.IfNames[0] = "x"
+	cp.Addresses[0] = "x"
+	cp.RdmaDevices[0] = "x"
+	cp.DeviceSpecs[0].HostPath = "x"
+	*cp.DeviceSpecs[0].UID = 1
+	*cp.DeviceSpecs[0].GID = 1
+	cp.Ports[0].RdmaDevices[0] = "x"
+	cp.Bond.Members[0].PciAddress = "x"
+	cp.Usage.ULPs[0] = "x"
+	cp.Usage.PIDs[0] = 2
+	cp.Attributes["k"] = "x"
+	cp.Warnings[0].Field = "x"
+	if !reflect.DeepEqual(orig, full()) {
+		t.Errorf("modifying the clone changed the original: %+v", orig)
+	}
+}
@@ -0,0 +1,108 @@
+// Package udev renders and installs the udev rule that keeps CDI specs in
+// step with RDMA hot-plug, e.g. SR-IOV VFs created after boot.
+package udev
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Nativu5/rdma-cdi/pkg/utils"
+)
+
+// DefaultRulesFile is where Install writes the rule by default. The 90-
+// prefix orders it after the distribution rules that name interfaces and
+// load the RDMA modules.
+const DefaultRulesFile = "/etc/udev/rules.d/90-rdma-cdi.rules"
+
+// header marks rule files written by this package.
+const header = "# Generated by rdma-cdi udev install; regenerates CDI specs on RDMA hot-plug.\n"
+
+// ErrInvalidRule is returned when a rule cannot be rendered safely.
+var ErrInvalidRule = errors.New("invalid udev rule")
+
+// Rules renders the rule file for binary, the absolute path of rdma-cdi.
+// generateArgs are passed to "generate --all", so that specs written on
+// hot-plug match the ones an operator generates by hand (e.g. --aliases).
+//
+// Specs are regenerated when a uverbs device appears, which is the last
+// node ib_uverbs creates for a new HCA, and when an RDMA-capable netdev is
+// added or renamed, which changes the interface a spec records. Plain
+// netdevs such as container veths are excluded by requiring a PCI parent
+// with an infiniband directory. Specs of removed devices are cleaned up
+// where generate writes them, as the --output-dir and --prefix among
+// generateArgs say.
+func Rules(binary string, generateArgs []string) (string, error) {
+	if !filepath.IsAbs(binary) {
+		return "", fmt.Errorf("%w: binary path %q is not absolute", ErrInvalidRule, binary)
+	}
+	for _, s := range append([]string{binary}, generateArgs...) {
+		// udev splits RUN on whitespace, ends the value at a double quote
+		// and substitutes %k, $kernel and the like before running it
+		if s == "" || strings.ContainsAny(s, " \t\n\"'%$") {
+			return "", fmt.Errorf("%w: %q cannot appear in a RUN key", ErrInvalidRule, s)
+		}
+	}
+
+	generate := strings.Join(append([]string{binary, "--quiet", "generate", "--all"}, generateArgs...), " ")
+	cleanup := strings.Join(append([]string{binary, "--quiet", "cleanup", "--orphans", "--force"}, cleanupArgs(generateArgs)...), " ")
+
+	var b strings.Builder
+	b.WriteString(header)
+	b.WriteString("# Installed rules are not reloaded automatically: run 'udevadm control --reload'.\n\n")
+	fmt.Fprintf(&b, "ACTION==\"add\", SUBSYSTEM==\"infiniband_verbs\", RUN+=\"%s\"\n", generate)
+	fmt.Fprintf(&b, "ACTION==\"add|move\", SUBSYSTEM==\"net\", SUBSYSTEMS==\"pci\", TEST==\"device/infiniband\", RUN+=\"%s\"\n", generate)
+	fmt.Fprintf(&b, "ACTION==\"remove\", SUBSYSTEM==\"infiniband_verbs\", RUN+=\"%s\"\n", cleanup)
+	return b.String(), nil
+}
+
+// cleanupFlags are the generate flags that cleanup shares, locating the
+// specs generate writes.
+var cleanupFlags = []string{"--output-dir", "--prefix"}
+
+// cleanupArgs returns the cleanupFlags among generateArgs, given either as
+// "--flag=value" or as "--flag" followed by its value.
+func cleanupArgs(generateArgs []string) []string {
+	var out []string
+	for i := 0; i < len(generateArgs); i++ {
+		arg := generateArgs[i]
+		for _, flag := range cleanupFlags {
+			switch {
+			case strings.HasPrefix(arg, flag+"="):
+				out = append(out, arg)
+			case arg == flag && i+1 < len(generateArgs):
+				out = append(out, arg, generateArgs[i+1])
+				i++
+			}
+		}
+	}
+	return out
+}
+
+// Install writes rules to path, creating its directory if needed. An
+// existing file is only replaced if this package wrote it, unless force
+// is set. The file is replaced atomically, so udev never reads a partial
+// rule.
+func Install(path, rules string, force bool) error {
+	existing, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if !force && !bytes.HasPrefix(existing, []byte(header)) {
+			return fmt.Errorf("%s exists and was not written by rdma-cdi (use --force to replace it)", path)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	if err := utils.WriteFileAtomic(path, []byte(rules), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
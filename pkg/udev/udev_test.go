@@ -0,0 +1,107 @@
+package udev
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	rules, err := Rules("/usr/local/bin/rdma-cdi", []string{"--aliases", "--format=json"})
+	if err != nil {
+		t.Fatalf("Rules() error = %v", err)
+	}
+
+	for _, want := range []string{
+		`ACTION=="add", SUBSYSTEM=="infiniband_verbs", RUN+="/usr/local/bin/rdma-cdi --quiet generate --all --aliases --format=json"`,
+		`SUBSYSTEM=="net", SUBSYSTEMS=="pci", TEST=="device/infiniband"`,
+		`ACTION=="remove", SUBSYSTEM=="infiniband_verbs", RUN+="/usr/local/bin/rdma-cdi --quiet cleanup --orphans --force"`,
+	} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("rules missing %q:\n%s", want, rules)
+		}
+	}
+	if !strings.HasPrefix(rules, header) {
+		t.Errorf("rules do not start with the header:\n%s", rules)
+	}
+}
+
+func TestRules_CleanupFollowsGenerate(t *testing.T) {
+	rules, err := Rules("/usr/bin/rdma-cdi", []string{"--aliases", "--output-dir=/var/run/cdi", "--prefix", "foo", "--format=json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `ACTION=="remove", SUBSYSTEM=="infiniband_verbs", RUN+="/usr/bin/rdma-cdi --quiet cleanup --orphans --force --output-dir=/var/run/cdi --prefix foo"`
+	if !strings.Contains(rules, want) {
+		t.Errorf("rules missing %q:\n%s", want, rules)
+	}
+}
+
+func TestRules_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		binary string
+		args   []string
+	}{
+		{"relative binary", "rdma-cdi", nil},
+		{"space in binary", "/opt/rdma cdi/rdma-cdi", nil},
+		{"quote in arg", "/usr/bin/rdma-cdi", []string{`--prefix="x"`}},
+		{"space in arg", "/usr/bin/rdma-cdi", []string{"--prefix x"}},
+		{"empty arg", "/usr/bin/rdma-cdi", []string{""}},
+		{"percent in output dir", "/usr/bin/rdma-cdi", []string{"--output-dir=/var/run/cdi/%k"}},
+		{"dollar in prefix", "/usr/bin/rdma-cdi", []string{"--prefix", "$kernel"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Rules(tt.binary, tt.args); !errors.Is(err, ErrInvalidRule) {
+				t.Errorf("Rules() error = %v, want ErrInvalidRule", err)
+			}
+		})
+	}
+}
+
+func TestInstall(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.d", "90-rdma-cdi.rules")
+	rules, err := Rules("/usr/bin/rdma-cdi", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Install(path, rules, false); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != rules {
+		t.Errorf("installed rules = %q, want %q", got, rules)
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("mode = %v, want 0644", info.Mode().Perm())
+	}
+
+	// Our own file is replaced without --force
+	if err := Install(path, rules, false); err != nil {
+		t.Errorf("reinstall error = %v", err)
+	}
+
+	// A foreign file is kept unless forced
+	if err := os.WriteFile(path, []byte("# hand-written\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Install(path, rules, false); err == nil {
+		t.Error("Install() over a foreign file succeeded, want error")
+	}
+	if err := Install(path, rules, true); err != nil {
+		t.Errorf("forced Install() error = %v", err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("rules dir has %d entries, want 1 (temp file left behind?)", len(entries))
+	}
+}
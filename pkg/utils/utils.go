@@ -1,15 +1,209 @@
 // Package utils provides shared utility functions for rdma-cdi.
 package utils
 
-import "strings"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 
-// SanitizeName replaces characters that are unsafe for CDI names and file names
-// (colons, slashes, dots) with hyphens.
+	"golang.org/x/sys/unix"
+	cdiparser "tags.cncf.io/container-device-interface/pkg/parser"
+)
+
+// ErrInvalidPCIAddress is returned (wrapped) when a PCI address cannot be parsed.
+var ErrInvalidPCIAddress = errors.New("invalid PCI address")
+
+// pciAddressRe matches [domain:]bus:device.function with hex fields.
+var pciAddressRe = regexp.MustCompile(`^(?:([0-9a-f]{1,8}):)?([0-9a-f]{2}):([0-9a-f]{2})\.([0-7])$`)
+
+// MaxCDINameLength caps resource names so that spec file names and the
+// qualified names runtimes display stay manageable.
+const MaxCDINameLength = 63
+
+// sanitizedNamePrefix is prepended when a name would not start with a letter.
+const sanitizedNamePrefix = "dev-"
+
+// SanitizeName turns s into a valid CDI class (resource) name: lowercase,
+// characters other than letters, digits, '_' and '-' replaced by '-',
+// repeated separators collapsed, trimmed to start with a letter and end
+// with a letter or digit, and at most MaxCDINameLength long. Names that
+// must be shortened get a hash suffix so distinct inputs stay distinct.
+// An input without any letter or digit yields "".
 func SanitizeName(s string) string {
-	r := strings.NewReplacer(
-		":", "-",
-		"/", "-",
-		".", "-",
-	)
-	return r.Replace(s)
+	var b strings.Builder
+	for _, c := range strings.ToLower(s) {
+		switch {
+		case ('a' <= c && c <= 'z') || ('0' <= c && c <= '9'):
+			b.WriteRune(c)
+		case c == '_' || c == '-':
+			if last := lastByte(b.String()); last != '_' && last != '-' {
+				b.WriteRune(c)
+			}
+		default:
+			if last := lastByte(b.String()); last != '_' && last != '-' {
+				b.WriteByte('-')
+			}
+		}
+	}
+	name := strings.Trim(b.String(), "_-")
+	if name == "" {
+		return ""
+	}
+	if name[0] < 'a' || name[0] > 'z' {
+		name = sanitizedNamePrefix + name
+	}
+	if len(name) > MaxCDINameLength {
+		sum := sha256.Sum256([]byte(s))
+		suffix := hex.EncodeToString(sum[:4])
+		name = strings.TrimRight(name[:MaxCDINameLength-len(suffix)-1], "_-") + "-" + suffix
+	}
+	return name
+}
+
+func lastByte(s string) byte {
+	if s == "" {
+		return 0
+	}
+	return s[len(s)-1]
+}
+
+// ValidateCDIName reports whether name is usable as a CDI resource name:
+// accepted by the CDI parser as a class name and at most MaxCDINameLength
+// long.
+func ValidateCDIName(name string) error {
+	// The parser slices name[1:len-1] and panics on one-character names
+	if len(name) == 1 && !cdiparser.IsLetter(rune(name[0])) {
+		return fmt.Errorf("invalid CDI resource name %q: should start with a letter", name)
+	}
+	if len(name) == 1 {
+		return nil
+	}
+	if err := cdiparser.ValidateClassName(name); err != nil {
+		return fmt.Errorf("invalid CDI resource name: %w", err)
+	}
+	if len(name) > MaxCDINameLength {
+		return fmt.Errorf("invalid CDI resource name %q: longer than %d characters", name, MaxCDINameLength)
+	}
+	return nil
+}
+
+// NormalizePCIAddress converts a PCI BDF address to the canonical sysfs form
+// "dddd:bb:dd.f". It trims whitespace, lowercases hex digits, and fills in the
+// default domain 0000 for short forms such as "86:00.0".
+func NormalizePCIAddress(s string) (string, error) {
+	addr := strings.ToLower(strings.TrimSpace(s))
+	m := pciAddressRe.FindStringSubmatch(addr)
+	if m == nil {
+		return "", fmt.Errorf("%w %q: expected [domain:]bus:device.function (e.g. 0000:86:00.0)", ErrInvalidPCIAddress, s)
+	}
+
+	domain := m[1]
+	if domain == "" {
+		domain = "0000"
+	} else if len(domain) < 4 {
+		domain = strings.Repeat("0", 4-len(domain)) + domain
+	}
+
+	// The device number is 5 bits wide
+	if m[3] > "1f" {
+		return "", fmt.Errorf("%w %q: device number %s out of range (00-1f)", ErrInvalidPCIAddress, s, m[3])
+	}
+
+	return fmt.Sprintf("%s:%s:%s.%s", domain, m[2], m[3], m[4]), nil
+}
+
+// ParseFileMode parses an octal permission string such as "0644" or "600".
+// Only permission bits are accepted.
+func ParseFileMode(s string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("invalid file mode %q: expected octal permission bits (e.g. 0644)", s)
+	}
+	return os.FileMode(m), nil
+}
+
+// ParseOwner parses "user[:group]" or ":group", where each part is a name
+// or a numeric ID, as accepted by chown(1). A part that is omitted is
+// returned as -1, which os.Chown leaves unchanged.
+func ParseOwner(s string) (uid, gid int, err error) {
+	userPart, groupPart, _ := strings.Cut(s, ":")
+	if userPart == "" && groupPart == "" {
+		return 0, 0, fmt.Errorf("invalid owner %q: expected user[:group]", s)
+	}
+
+	uid, gid = -1, -1
+	if userPart != "" {
+		if uid, err = strconv.Atoi(userPart); err != nil {
+			u, lerr := user.Lookup(userPart)
+			if lerr != nil {
+				return 0, 0, fmt.Errorf("invalid owner %q: %w", s, lerr)
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+	if groupPart != "" {
+		if gid, err = strconv.Atoi(groupPart); err != nil {
+			g, lerr := user.LookupGroup(groupPart)
+			if lerr != nil {
+				return 0, 0, fmt.Errorf("invalid owner %q: %w", s, lerr)
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	return uid, gid, nil
+}
+
+// RebasePath maps p from under the directory from to the same location
+// under to, e.g. "/dev/infiniband/uverbs0" from "/dev" to "/host/dev".
+// Paths outside from, or an empty to, are returned unchanged.
+func RebasePath(p, from, to string) string {
+	if to == "" || filepath.Clean(to) == filepath.Clean(from) {
+		return p
+	}
+	rel, err := filepath.Rel(from, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return p
+	}
+	return filepath.Join(to, rel)
+}
+
+// WriteFileAtomic writes data to path with mode perm under a temporary
+// name in the same directory and renames it into place, so readers never
+// see a partial file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// IsTerminal reports whether w is an *os.File attached to a terminal.
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
 }
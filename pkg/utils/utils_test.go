@@ -1,6 +1,12 @@
 package utils
 
-import "testing"
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
 
 func TestSanitizeName(t *testing.T) {
 	tests := []struct {
@@ -8,18 +14,23 @@ func TestSanitizeName(t *testing.T) {
 		in   string
 		want string
 	}{
-		{"pci_pf", "0000:17:00.0", "0000-17-00-0"},
-		{"pci_vf", "0000:17:00.2", "0000-17-00-2"},
-		{"pci_second_pf", "0000:41:00.0", "0000-41-00-0"},
+		{"pci_pf", "0000:17:00.0", "dev-0000-17-00-0"},
+		{"pci_vf", "0000:17:00.2", "dev-0000-17-00-2"},
+		{"pci_second_pf", "0000:41:00.0", "dev-0000-41-00-0"},
 		{"slash", "a/b/c", "a-b-c"},
-		{"dot", "1.2.3", "1-2-3"},
+		{"dot", "1.2.3", "dev-1-2-3"},
 		{"mixed", "pci-0000:17:00.0", "pci-0000-17-00-0"},
 		{"ifname_pf", "enp23s0f0np0", "enp23s0f0np0"},
 		{"ifname_vf", "enp23s0f0v0", "enp23s0f0v0"},
 		{"empty", "", ""},
-		{"all_special", ":/.", "---"},
+		{"all_special", ":/.", ""},
 		{"hyphen_passthrough", "already-safe", "already-safe"},
 		{"underscore_passthrough", "a_b", "a_b"},
+		{"uppercase", "InfiniBand", "infiniband"},
+		{"collapse_repeats", "a::b--c__d", "a-b-c_d"},
+		{"trim_separators", "-ib0_", "ib0"},
+		{"other_chars", "eth 0@vlan+1", "eth-0-vlan-1"},
+		{"long", strings.Repeat("a", 70), strings.Repeat("a", 54) + "-6bd5e503"},
 	}
 
 	for _, tc := range tests {
@@ -31,3 +42,238 @@ func TestSanitizeName(t *testing.T) {
 		})
 	}
 }
+
+func TestSanitizeName_ValidAndBounded(t *testing.T) {
+	for _, in := range []string{"0000:17:00.0", "eth 0@vlan+1", "_x_", strings.Repeat("ab:", 40), "mlx5_core"} {
+		got := SanitizeName(in)
+		if err := ValidateCDIName(got); err != nil {
+			t.Errorf("SanitizeName(%q) = %q is not a valid CDI name: %v", in, got, err)
+		}
+	}
+	long1, long2 := SanitizeName(strings.Repeat("a", 70)+"1"), SanitizeName(strings.Repeat("a", 70)+"2")
+	if long1 == long2 {
+		t.Errorf("truncated names should stay distinct, both %q", long1)
+	}
+}
+
+func TestValidateCDIName(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantErr bool
+	}{
+		{"simple", "pci-0000-17-00-0", false},
+		{"dots_and_underscores", "mlx5_core.v2", false},
+		{"empty", "", true},
+		{"single_letter", "x", false},
+		{"single_digit", "0", true},
+		{"leading_digit", "0000-17-00-0", true},
+		{"trailing_dash", "ib0-", true},
+		{"colon", "a:b", true},
+		{"slash", "a/b", true},
+		{"too_long", strings.Repeat("a", MaxCDINameLength+1), true},
+		{"max_length", strings.Repeat("a", MaxCDINameLength), false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateCDIName(tc.in)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("ValidateCDIName(%q) error = %v, wantErr %v", tc.in, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestNormalizePCIAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"canonical", "0000:86:00.0", "0000:86:00.0"},
+		{"short_form", "86:00.0", "0000:86:00.0"},
+		{"uppercase", "0000:AF:00.1", "0000:af:00.1"},
+		{"whitespace", "  0000:17:00.2\n", "0000:17:00.2"},
+		{"short_domain", "1:17:00.0", "0001:17:00.0"},
+		{"wide_domain", "10000:01:00.0", "10000:01:00.0"},
+		{"max_device", "00:1f.7", "0000:00:1f.7"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := NormalizePCIAddress(tc.in)
+			if err != nil {
+				t.Fatalf("NormalizePCIAddress(%q) returned error: %v", tc.in, err)
+			}
+			if got != tc.want {
+				t.Errorf("NormalizePCIAddress(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNormalizePCIAddress_Invalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"enp23s0f0np0",
+		"0000:86:00",
+		"0000:86:00.8",
+		"0000:86:20.0",
+		"0000:8g:00.0",
+		"0000:86-00.0",
+		"123456789:86:00.0",
+	} {
+		t.Run(in, func(t *testing.T) {
+			_, err := NormalizePCIAddress(in)
+			if !errors.Is(err, ErrInvalidPCIAddress) {
+				t.Errorf("NormalizePCIAddress(%q) error = %v, want ErrInvalidPCIAddress", in, err)
+			}
+		})
+	}
+}
+
+func TestIsTerminal_NonFile(t *testing.T) {
+	if IsTerminal(&strings.Builder{}) {
+		t.Error("a strings.Builder is not a terminal")
+	}
+	f, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if IsTerminal(f) {
+		t.Error("a regular file is not a terminal")
+	}
+}
+
+func TestParseFileMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{"0644", 0644, false},
+		{"600", 0600, false},
+		{"0640", 0640, false},
+		{"0999", 0, true},
+		{"1777", 0, true},
+		{"rw", 0, true},
+	}
+	for _, tc := range tests {
+		got, err := ParseFileMode(tc.in)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("ParseFileMode(%q) = %o, %v; want %o, err=%v", tc.in, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestParseOwner(t *testing.T) {
+	tests := []struct {
+		in       string
+		uid, gid int
+		wantErr  bool
+	}{
+		{"0:0", 0, 0, false},
+		{"root", 0, -1, false},
+		{"root:0", 0, 0, false},
+		{":27", -1, 27, false},
+		{"1000:", 1000, -1, false},
+		{":", 0, 0, true},
+		{"no-such-user-rdma-cdi", 0, 0, true},
+	}
+	for _, tc := range tests {
+		uid, gid, err := ParseOwner(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseOwner(%q) error = %v, wantErr %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && (uid != tc.uid || gid != tc.gid) {
+			t.Errorf("ParseOwner(%q) = %d:%d, want %d:%d", tc.in, uid, gid, tc.uid, tc.gid)
+		}
+	}
+}
+
+func TestRebasePath(t *testing.T) {
+	tests := []struct {
+		name string
+		p    string
+		from string
+		to   string
+		want string
+	}{
+		{"dev_node", "/dev/infiniband/uverbs0", "/dev", "/host/dev", "/host/dev/infiniband/uverbs0"},
+		{"same_root", "/dev/infiniband/uverbs0", "/dev", "/dev", "/dev/infiniband/uverbs0"},
+		{"empty_target", "/dev/infiniband/uverbs0", "/dev", "", "/dev/infiniband/uverbs0"},
+		{"outside", "/run/foo", "/dev", "/host/dev", "/run/foo"},
+		{"prefix_only", "/devices/x", "/dev", "/host/dev", "/devices/x"},
+		{"root_itself", "/dev", "/dev", "/host/dev", "/host/dev"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := RebasePath(tc.p, tc.from, tc.to); got != tc.want {
+				t.Errorf("RebasePath(%q, %q, %q) = %q, want %q", tc.p, tc.from, tc.to, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.conf")
+	if err := os.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFileAtomic(path, []byte("new"), 0644); err != nil {
+		t.Fatalf("WriteFileAtomic() error = %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "new" {
+		t.Errorf("content = %q, want %q", got, "new")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0644 {
+		t.Errorf("mode = %v, want 0644", info.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("dir has %d entries, want 1 (temp file left behind?)", len(entries))
+	}
+
+	if err := WriteFileAtomic(filepath.Join(dir, "missing", "x"), nil, 0644); err == nil {
+		t.Error("WriteFileAtomic() into a missing directory succeeded, want error")
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{
+			"change",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			"1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			"--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{"from empty", "", "x\n", "--- old\n+++ new\n@@ -0,0 +1 @@\n+x\n"},
+		{
+			"two hunks",
+			"a\n1\n2\n3\n4\n5\n6\n7\n8\nb\n",
+			"A\n1\n2\n3\n4\n5\n6\n7\n8\nB\n",
+			"--- old\n+++ new\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n@@ -7,4 +7,4 @@\n 6\n 7\n 8\n-b\n+B\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := UnifiedDiff("old", "new", tc.a, tc.b); got != tc.want {
+				t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}